@@ -0,0 +1,39 @@
+#!/bin/bash
+
+# Benchmark comparison script for optimistic-lock project
+#
+# Usage: ./bench-compare.sh [base-ref] [bench-regexp] [count]
+#
+# Runs the benchmarks in ./test on base-ref (default main) and on the working
+# tree, against the same database, and compares them with benchstat.
+
+set -euo pipefail
+
+BASE=${1:-main}
+BENCH=${2:-.}
+COUNT=${3:-6}
+OUT=bench
+ROOT=$(pwd)
+
+mkdir -p "$OUT"
+WORKTREE=$(mktemp -d)
+trap 'git worktree remove --force "$WORKTREE"' EXIT
+
+echo "Checking out $BASE..."
+git worktree add --detach "$WORKTREE" "$BASE" >/dev/null
+
+run() {
+  (cd "$1" && go test -run '^$' -bench "$BENCH" -benchmem -count "$COUNT" ./test) | tee "$2"
+}
+
+echo "Benchmarking $BASE..."
+run "$WORKTREE" "$ROOT/$OUT/base.txt"
+echo "Benchmarking working tree..."
+run "$ROOT" "$ROOT/$OUT/head.txt"
+
+if command -v benchstat >/dev/null; then
+  benchstat "$OUT/base.txt" "$OUT/head.txt"
+else
+  echo "Results are in $OUT/base.txt and $OUT/head.txt."
+  echo "Install benchstat to compare them: go install golang.org/x/perf/cmd/benchstat@latest"
+fi
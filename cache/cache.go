@@ -0,0 +1,117 @@
+// Package cache keeps recently read balances in memory, keyed by ID, so
+// read-heavy workloads do not query the database for balances that rarely
+// change. Entries carry the version they were read at: an older version
+// never replaces a newer one, so a slow read cannot undo a write.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Entry is a cached balance
+type Entry struct {
+	ID      uint
+	Amount  int64
+	Version int
+	Stored  time.Time // when the amount was read or written
+}
+
+// Age returns how long ago the entry was stored
+func (e Entry) Age() time.Duration {
+	return time.Since(e.Stored)
+}
+
+// Cache is a fixed-size LRU of balances, safe for concurrent use
+type Cache struct {
+	capacity int
+
+	mu      sync.Mutex
+	order   *list.List // most recently used first
+	entries map[uint]*list.Element
+	hits    uint64
+	misses  uint64
+}
+
+// Stats counts cache lookups
+type Stats struct {
+	Size   int
+	Hits   uint64
+	Misses uint64
+}
+
+// New returns a cache holding at most capacity balances (at least one)
+func New(capacity int) *Cache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Cache{capacity: capacity, order: list.New(), entries: make(map[uint]*list.Element)}
+}
+
+// Get returns the entry for id if it is cached and at most maxAge old
+func (c *Cache) Get(id uint, maxAge time.Duration) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[id]
+	if !ok || el.Value.(Entry).Age() > maxAge {
+		c.misses++
+		return Entry{}, false
+	}
+	c.hits++
+	c.order.MoveToFront(el)
+	return el.Value.(Entry), true
+}
+
+// Peek returns the entry for id whatever its age, without counting a lookup
+// or refreshing its place in the LRU, e.g. to guess the version a write
+// should expect
+func (c *Cache) Peek(id uint) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[id]; ok {
+		return el.Value.(Entry), true
+	}
+	return Entry{}, false
+}
+
+// Set stores the amount of balance id at version, unless a later version is
+// already cached. The least recently used balance is evicted when full.
+func (c *Cache) Set(id uint, amount int64, version int) {
+	entry := Entry{ID: id, Amount: amount, Version: version, Stored: time.Now()}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[id]; ok {
+		if el.Value.(Entry).Version > version {
+			return
+		}
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[id] = c.order.PushFront(entry)
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(Entry).ID)
+	}
+}
+
+// Invalidate drops balance id, e.g. after a write lost a version conflict
+// and the cached version is known to be stale
+func (c *Cache) Invalidate(id uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[id]; ok {
+		c.order.Remove(el)
+		delete(c.entries, id)
+	}
+}
+
+// Stats returns the number of cached balances and the lookups so far
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{Size: c.order.Len(), Hits: c.hits, Misses: c.misses}
+}
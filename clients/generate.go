@@ -0,0 +1,5 @@
+// Package clients holds the API clients generated from api/openapi.yaml.
+// Regenerate them with `go generate ./clients` after changing the spec.
+package clients
+
+//go:generate go run ../cmd/clientgen -spec ../api/openapi.yaml -go-out go/client.go -go-package client -ts-out typescript/client.ts
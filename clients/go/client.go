@@ -0,0 +1,444 @@
+// Code generated by clientgen from openapi.yaml. DO NOT EDIT.
+
+// Package client is a client for the Balance API 1.0.0.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type Balance struct {
+	Amount  int64 `json:"amount"`
+	ID      int64 `json:"id"`
+	Version int64 `json:"version"`
+}
+
+type BalanceList struct {
+	Balances   []ListedBalance `json:"balances"`
+	NextCursor string          `json:"next_cursor,omitempty"` // Cursor of the next page; absent on the last page
+}
+
+type BalanceMetadata struct {
+	ID       int64          `json:"id"`
+	Metadata map[string]any `json:"metadata"` // Free-form JSON object
+	Version  int64          `json:"version"`
+}
+
+type History struct {
+	Entries    []HistoryEntry `json:"entries"`
+	NextCursor string         `json:"next_cursor,omitempty"` // Cursor of the next page; absent on the last page
+}
+
+type HistoryEntry struct {
+	Amount    int64     `json:"amount"` // Running balance after the change
+	CreatedAt time.Time `json:"created_at"`
+	Delta     int64     `json:"delta"`
+	Kind      string    `json:"kind"` // change, or repair for a reset by the rebuild tool
+	Version   int64     `json:"version"`
+}
+
+type ListedBalance struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency,omitempty"`
+	ID       int64  `json:"id"`
+	OwnerID  string `json:"owner_id,omitempty"`
+	Version  int64  `json:"version"`
+}
+
+type Operation struct {
+	BalanceID   int64      `json:"balance_id"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Delta       int64      `json:"delta"`
+	Error       string     `json:"error,omitempty"`
+	ID          string     `json:"id"`
+	Status      string     `json:"status"` // pending, succeeded or failed
+}
+
+type PatchBalanceRequest struct {
+	Delta int64 `json:"delta"`
+}
+
+type PrepareRequest struct {
+	Delta     int64  `json:"delta"`
+	ExpiresIn string `json:"expires_in,omitempty"` // Time to commit or abort in, e.g. "2m"; 5m by default
+}
+
+type PreparedUpdate struct {
+	BalanceID       int64      `json:"balance_id"`
+	Delta           int64      `json:"delta"`
+	ExpectedVersion int64      `json:"expected_version"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	ResolvedAt      *time.Time `json:"resolved_at,omitempty"`
+	Status          string     `json:"status"` // prepared, committed, aborted or expired
+	Token           string     `json:"token"`
+}
+
+type PutBalanceRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+type Validation struct {
+	Amount     int64    `json:"amount"`
+	ID         int64    `json:"id"`
+	NewAmount  int64    `json:"new_amount"`
+	NewVersion int64    `json:"new_version"`
+	Valid      bool     `json:"valid"`
+	Version    int64    `json:"version"`
+	Violations []string `json:"violations,omitempty"` // Rules the update would break, e.g. "insufficient funds"
+}
+
+type VelocityAccount struct {
+	BalanceID   int64    `json:"balance_id"`
+	CreditTotal int64    `json:"credit_total"`
+	Credits     int64    `json:"credits"`
+	DebitTotal  int64    `json:"debit_total"`
+	Debits      int64    `json:"debits"`
+	Flags       []string `json:"flags,omitempty"`
+}
+
+type VelocityReport struct {
+	Accounts []VelocityAccount `json:"accounts"`
+	Rules    VelocityRules     `json:"rules"`
+	Since    time.Time         `json:"since"`
+	Until    time.Time         `json:"until"`
+}
+
+type VelocityRules struct {
+	MaxCreditTotal int64 `json:"max_credit_total,omitempty"`
+	MaxCredits     int64 `json:"max_credits,omitempty"`
+	MaxDebitTotal  int64 `json:"max_debit_total,omitempty"`
+	MaxDebits      int64 `json:"max_debits,omitempty"`
+}
+
+// Client calls the API at BaseURL
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// New returns a Client for baseURL using http.DefaultClient
+func New(baseURL string) *Client {
+	return &Client{BaseURL: baseURL, HTTPClient: http.DefaultClient}
+}
+
+// Error is returned for responses outside the 2xx range
+type Error struct {
+	StatusCode int
+	Message    string
+	Code       string // reason, e.g. ABORTED or FAILED_PRECONDITION
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("api error %d: %s", e.StatusCode, e.Message)
+}
+
+// Retryable reports whether the same request may be sent again as is: the
+// server rejected it without applying it and the cause is transient. A
+// FAILED_PRECONDITION is not retryable; read the balance again for a fresh
+// ETag first.
+func (e *Error) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusConflict, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body, out any) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return &Error{StatusCode: resp.StatusCode, Message: apiErr.Error, Code: apiErr.Code}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// AbortPrepared discards a prepared update
+func (c *Client) AbortPrepared(ctx context.Context, token string) (*PreparedUpdate, error) {
+	path := "/prepared/" + url.PathEscape(fmt.Sprint(token)) + "/abort"
+	query := url.Values{}
+	header := http.Header{}
+	var out PreparedUpdate
+	if err := c.do(ctx, "POST", path, query, header, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CommitPrepared applies a prepared update
+func (c *Client) CommitPrepared(ctx context.Context, token string) (*Balance, error) {
+	path := "/prepared/" + url.PathEscape(fmt.Sprint(token)) + "/commit"
+	query := url.Values{}
+	header := http.Header{}
+	var out Balance
+	if err := c.do(ctx, "POST", path, query, header, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetBalance returns the balance and its version
+func (c *Client) GetBalance(ctx context.Context, id int64, consistency string, minVersion int64, maxStale string) (*Balance, error) {
+	path := "/balances/" + url.PathEscape(fmt.Sprint(id))
+	query := url.Values{}
+	header := http.Header{}
+	if consistency != "" {
+		query.Set("consistency", fmt.Sprint(consistency))
+	}
+	if minVersion != 0 {
+		query.Set("min_version", fmt.Sprint(minVersion))
+	}
+	if maxStale != "" {
+		query.Set("max_stale", fmt.Sprint(maxStale))
+	}
+	var out Balance
+	if err := c.do(ctx, "GET", path, query, header, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetHistory returns the changes of the balance, oldest first, with running balances
+func (c *Client) GetHistory(ctx context.Context, id int64, since string, until string, cursor string, limit int64, consistency string) (*History, error) {
+	path := "/balances/" + url.PathEscape(fmt.Sprint(id)) + "/history"
+	query := url.Values{}
+	header := http.Header{}
+	if since != "" {
+		query.Set("since", fmt.Sprint(since))
+	}
+	if until != "" {
+		query.Set("until", fmt.Sprint(until))
+	}
+	if cursor != "" {
+		query.Set("cursor", fmt.Sprint(cursor))
+	}
+	if limit != 0 {
+		query.Set("limit", fmt.Sprint(limit))
+	}
+	if consistency != "" {
+		query.Set("consistency", fmt.Sprint(consistency))
+	}
+	var out History
+	if err := c.do(ctx, "GET", path, query, header, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetMetadata returns the metadata of the balance with its version as the ETag
+func (c *Client) GetMetadata(ctx context.Context, id int64) (*BalanceMetadata, error) {
+	path := "/balances/" + url.PathEscape(fmt.Sprint(id)) + "/metadata"
+	query := url.Values{}
+	header := http.Header{}
+	var out BalanceMetadata
+	if err := c.do(ctx, "GET", path, query, header, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetOperation returns the status of an asynchronous update
+func (c *Client) GetOperation(ctx context.Context, id string) (*Operation, error) {
+	path := "/operations/" + url.PathEscape(fmt.Sprint(id))
+	query := url.Values{}
+	header := http.Header{}
+	var out Operation
+	if err := c.do(ctx, "GET", path, query, header, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetPrepared returns a prepared update
+func (c *Client) GetPrepared(ctx context.Context, token string) (*PreparedUpdate, error) {
+	path := "/prepared/" + url.PathEscape(fmt.Sprint(token))
+	query := url.Values{}
+	header := http.Header{}
+	var out PreparedUpdate
+	if err := c.do(ctx, "GET", path, query, header, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetVelocityReport returns per-balance debit/credit velocity
+func (c *Client) GetVelocityReport(ctx context.Context, window string, flagged bool, consistency string) (*VelocityReport, error) {
+	path := "/reports/velocity"
+	query := url.Values{}
+	header := http.Header{}
+	if window != "" {
+		query.Set("window", fmt.Sprint(window))
+	}
+	if flagged {
+		query.Set("flagged", fmt.Sprint(flagged))
+	}
+	if consistency != "" {
+		query.Set("consistency", fmt.Sprint(consistency))
+	}
+	var out VelocityReport
+	if err := c.do(ctx, "GET", path, query, header, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListBalances returns a page of balances with their versions
+func (c *Client) ListBalances(ctx context.Context, ownerID string, currency string, minAmount int64, maxAmount int64, order string, cursor string, limit int64, consistency string) (*BalanceList, error) {
+	path := "/balances"
+	query := url.Values{}
+	header := http.Header{}
+	if ownerID != "" {
+		query.Set("owner_id", fmt.Sprint(ownerID))
+	}
+	if currency != "" {
+		query.Set("currency", fmt.Sprint(currency))
+	}
+	if minAmount != 0 {
+		query.Set("min_amount", fmt.Sprint(minAmount))
+	}
+	if maxAmount != 0 {
+		query.Set("max_amount", fmt.Sprint(maxAmount))
+	}
+	if order != "" {
+		query.Set("order", fmt.Sprint(order))
+	}
+	if cursor != "" {
+		query.Set("cursor", fmt.Sprint(cursor))
+	}
+	if limit != 0 {
+		query.Set("limit", fmt.Sprint(limit))
+	}
+	if consistency != "" {
+		query.Set("consistency", fmt.Sprint(consistency))
+	}
+	var out BalanceList
+	if err := c.do(ctx, "GET", path, query, header, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PatchBalance adds a delta to the balance if it is still at the If-Match version
+func (c *Client) PatchBalance(ctx context.Context, id int64, ifMatch string, body PatchBalanceRequest) (*Balance, error) {
+	path := "/balances/" + url.PathEscape(fmt.Sprint(id))
+	query := url.Values{}
+	header := http.Header{}
+	header.Set("If-Match", ifMatch)
+	var out Balance
+	if err := c.do(ctx, "PATCH", path, query, header, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PatchMetadata applies a JSON merge patch to the metadata if the balance is still at the If-Match version
+func (c *Client) PatchMetadata(ctx context.Context, id int64, ifMatch string, body map[string]any) (*BalanceMetadata, error) {
+	path := "/balances/" + url.PathEscape(fmt.Sprint(id)) + "/metadata"
+	query := url.Values{}
+	header := http.Header{}
+	header.Set("If-Match", ifMatch)
+	var out BalanceMetadata
+	if err := c.do(ctx, "PATCH", path, query, header, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PrepareBalance pins a delta to the balance version until it is committed or aborted
+func (c *Client) PrepareBalance(ctx context.Context, id int64, ifMatch string, body PrepareRequest) (*PreparedUpdate, error) {
+	path := "/balances/" + url.PathEscape(fmt.Sprint(id)) + "/prepare"
+	query := url.Values{}
+	header := http.Header{}
+	header.Set("If-Match", ifMatch)
+	var out PreparedUpdate
+	if err := c.do(ctx, "POST", path, query, header, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PutBalance sets the amount if the balance is still at the If-Match version
+func (c *Client) PutBalance(ctx context.Context, id int64, ifMatch string, body PutBalanceRequest) (*Balance, error) {
+	path := "/balances/" + url.PathEscape(fmt.Sprint(id))
+	query := url.Values{}
+	header := http.Header{}
+	header.Set("If-Match", ifMatch)
+	var out Balance
+	if err := c.do(ctx, "PUT", path, query, header, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SubmitBalanceUpdate queues a delta and returns the operation to poll
+func (c *Client) SubmitBalanceUpdate(ctx context.Context, id int64, body PatchBalanceRequest) (*Operation, error) {
+	path := "/balances/" + url.PathEscape(fmt.Sprint(id)) + "/updates"
+	query := url.Values{}
+	header := http.Header{}
+	var out Operation
+	if err := c.do(ctx, "POST", path, query, header, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ValidateBalanceUpdate checks what adding a delta would do, without writing
+func (c *Client) ValidateBalanceUpdate(ctx context.Context, id int64, body PatchBalanceRequest) (*Validation, error) {
+	path := "/balances/" + url.PathEscape(fmt.Sprint(id)) + "/validate"
+	query := url.Values{}
+	header := http.Header{}
+	var out Validation
+	if err := c.do(ctx, "POST", path, query, header, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
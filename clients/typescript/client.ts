@@ -0,0 +1,242 @@
+// Code generated by clientgen from openapi.yaml. DO NOT EDIT.
+// Client for the Balance API 1.0.0.
+
+export interface Balance {
+  amount: number;
+  id: number;
+  version: number;
+}
+
+export interface BalanceList {
+  balances: ListedBalance[];
+  /** Cursor of the next page; absent on the last page */
+  next_cursor?: string;
+}
+
+export interface BalanceMetadata {
+  id: number;
+  /** Free-form JSON object */
+  metadata: Record<string, unknown>;
+  version: number;
+}
+
+export interface History {
+  entries: HistoryEntry[];
+  /** Cursor of the next page; absent on the last page */
+  next_cursor?: string;
+}
+
+export interface HistoryEntry {
+  /** Running balance after the change */
+  amount: number;
+  created_at: string;
+  delta: number;
+  /** change, or repair for a reset by the rebuild tool */
+  kind: string;
+  version: number;
+}
+
+export interface ListedBalance {
+  amount: number;
+  currency?: string;
+  id: number;
+  owner_id?: string;
+  version: number;
+}
+
+export interface Operation {
+  balance_id: number;
+  completed_at?: string;
+  created_at: string;
+  delta: number;
+  error?: string;
+  id: string;
+  /** pending, succeeded or failed */
+  status: string;
+}
+
+export interface PatchBalanceRequest {
+  delta: number;
+}
+
+export interface PrepareRequest {
+  delta: number;
+  /** Time to commit or abort in, e.g. "2m"; 5m by default */
+  expires_in?: string;
+}
+
+export interface PreparedUpdate {
+  balance_id: number;
+  delta: number;
+  expected_version: number;
+  expires_at: string;
+  resolved_at?: string;
+  /** prepared, committed, aborted or expired */
+  status: string;
+  token: string;
+}
+
+export interface PutBalanceRequest {
+  amount: number;
+}
+
+export interface Validation {
+  amount: number;
+  id: number;
+  new_amount: number;
+  new_version: number;
+  valid: boolean;
+  version: number;
+  /** Rules the update would break, e.g. "insufficient funds" */
+  violations?: string[];
+}
+
+export interface VelocityAccount {
+  balance_id: number;
+  credit_total: number;
+  credits: number;
+  debit_total: number;
+  debits: number;
+  flags?: string[];
+}
+
+export interface VelocityReport {
+  accounts: VelocityAccount[];
+  rules: VelocityRules;
+  since: string;
+  until: string;
+}
+
+export interface VelocityRules {
+  max_credit_total?: number;
+  max_credits?: number;
+  max_debit_total?: number;
+  max_debits?: number;
+}
+
+/** Thrown for responses outside the 2xx range */
+export class ApiError extends Error {
+  constructor(
+    public readonly status: number,
+    message: string,
+    /** Reason, e.g. ABORTED or FAILED_PRECONDITION */
+    public readonly code?: string,
+  ) {
+    super(message);
+  }
+
+  /**
+   * Whether the same request may be sent again as is: the server rejected it
+   * without applying it and the cause is transient. FAILED_PRECONDITION is not
+   * retryable; read the balance again for a fresh ETag first.
+   */
+  get retryable(): boolean {
+    return this.status === 409 || this.status === 429 || this.status === 503;
+  }
+}
+
+type Query = Record<string, string | number | boolean | undefined>;
+
+export class Client {
+  constructor(
+    private readonly baseUrl: string,
+    private readonly fetchImpl: typeof fetch = fetch,
+  ) {}
+
+  private async request<T>(method: string, path: string, query: Query, headers: Record<string, string>, body: unknown): Promise<T> {
+    const params = new URLSearchParams();
+    for (const [key, value] of Object.entries(query)) {
+      if (value !== undefined) {
+        params.set(key, String(value));
+      }
+    }
+    const search = params.toString();
+    const init: RequestInit = { method, headers: { ...headers } };
+    if (body !== undefined) {
+      init.body = JSON.stringify(body);
+      (init.headers as Record<string, string>)["Content-Type"] = "application/json";
+    }
+
+    const resp = await this.fetchImpl(this.baseUrl + path + (search ? "?" + search : ""), init);
+    if (!resp.ok) {
+      const data = await resp.json().catch(() => ({}));
+      throw new ApiError(resp.status, data.error ?? resp.statusText, data.code);
+    }
+    return (resp.status === 204 ? undefined : await resp.json()) as T;
+  }
+
+  /** Discards a prepared update */
+  async abortPrepared(token: string): Promise<PreparedUpdate> {
+    return this.request<PreparedUpdate>("POST", `/prepared/${encodeURIComponent(String(token))}/abort`, {}, {}, undefined);
+  }
+
+  /** Applies a prepared update */
+  async commitPrepared(token: string): Promise<Balance> {
+    return this.request<Balance>("POST", `/prepared/${encodeURIComponent(String(token))}/commit`, {}, {}, undefined);
+  }
+
+  /** Returns the balance and its version */
+  async getBalance(id: number, query: { consistency?: string; min_version?: number; max_stale?: string } = {}): Promise<Balance> {
+    return this.request<Balance>("GET", `/balances/${encodeURIComponent(String(id))}`, query, {}, undefined);
+  }
+
+  /** Returns the changes of the balance, oldest first, with running balances */
+  async getHistory(id: number, query: { since?: string; until?: string; cursor?: string; limit?: number; consistency?: string } = {}): Promise<History> {
+    return this.request<History>("GET", `/balances/${encodeURIComponent(String(id))}/history`, query, {}, undefined);
+  }
+
+  /** Returns the metadata of the balance with its version as the ETag */
+  async getMetadata(id: number): Promise<BalanceMetadata> {
+    return this.request<BalanceMetadata>("GET", `/balances/${encodeURIComponent(String(id))}/metadata`, {}, {}, undefined);
+  }
+
+  /** Returns the status of an asynchronous update */
+  async getOperation(id: string): Promise<Operation> {
+    return this.request<Operation>("GET", `/operations/${encodeURIComponent(String(id))}`, {}, {}, undefined);
+  }
+
+  /** Returns a prepared update */
+  async getPrepared(token: string): Promise<PreparedUpdate> {
+    return this.request<PreparedUpdate>("GET", `/prepared/${encodeURIComponent(String(token))}`, {}, {}, undefined);
+  }
+
+  /** Returns per-balance debit/credit velocity */
+  async getVelocityReport(query: { window?: string; flagged?: boolean; consistency?: string } = {}): Promise<VelocityReport> {
+    return this.request<VelocityReport>("GET", `/reports/velocity`, query, {}, undefined);
+  }
+
+  /** Returns a page of balances with their versions */
+  async listBalances(query: { owner_id?: string; currency?: string; min_amount?: number; max_amount?: number; order?: string; cursor?: string; limit?: number; consistency?: string } = {}): Promise<BalanceList> {
+    return this.request<BalanceList>("GET", `/balances`, query, {}, undefined);
+  }
+
+  /** Adds a delta to the balance if it is still at the If-Match version */
+  async patchBalance(id: number, ifMatch: string, body: PatchBalanceRequest): Promise<Balance> {
+    return this.request<Balance>("PATCH", `/balances/${encodeURIComponent(String(id))}`, {}, { "If-Match": ifMatch }, body);
+  }
+
+  /** Applies a JSON merge patch to the metadata if the balance is still at the If-Match version */
+  async patchMetadata(id: number, ifMatch: string, body: Record<string, unknown>): Promise<BalanceMetadata> {
+    return this.request<BalanceMetadata>("PATCH", `/balances/${encodeURIComponent(String(id))}/metadata`, {}, { "If-Match": ifMatch }, body);
+  }
+
+  /** Pins a delta to the balance version until it is committed or aborted */
+  async prepareBalance(id: number, ifMatch: string, body: PrepareRequest): Promise<PreparedUpdate> {
+    return this.request<PreparedUpdate>("POST", `/balances/${encodeURIComponent(String(id))}/prepare`, {}, { "If-Match": ifMatch }, body);
+  }
+
+  /** Sets the amount if the balance is still at the If-Match version */
+  async putBalance(id: number, ifMatch: string, body: PutBalanceRequest): Promise<Balance> {
+    return this.request<Balance>("PUT", `/balances/${encodeURIComponent(String(id))}`, {}, { "If-Match": ifMatch }, body);
+  }
+
+  /** Queues a delta and returns the operation to poll */
+  async submitBalanceUpdate(id: number, body: PatchBalanceRequest): Promise<Operation> {
+    return this.request<Operation>("POST", `/balances/${encodeURIComponent(String(id))}/updates`, {}, {}, body);
+  }
+
+  /** Checks what adding a delta would do, without writing */
+  async validateBalanceUpdate(id: number, body: PatchBalanceRequest): Promise<Validation> {
+    return this.request<Validation>("POST", `/balances/${encodeURIComponent(String(id))}/validate`, {}, {}, body);
+  }
+}
@@ -0,0 +1,50 @@
+// Command clientgen generates the Go and TypeScript API clients from the
+// OpenAPI definition. It is run through go generate in the clients directory.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/ghozilaaa/optimistic-lock/internal/clientgen"
+)
+
+func main() {
+	specPath := flag.String("spec", "api/openapi.yaml", "OpenAPI definition")
+	goOut := flag.String("go-out", "", "Go client output file (skipped if empty)")
+	goPkg := flag.String("go-package", "client", "Go client package name")
+	tsOut := flag.String("ts-out", "", "TypeScript client output file (skipped if empty)")
+	flag.Parse()
+
+	spec, err := clientgen.Load(*specPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	source := filepath.ToSlash(filepath.Base(*specPath))
+
+	if *goOut != "" {
+		src, err := clientgen.GenerateGo(spec, *goPkg, source)
+		if err != nil {
+			log.Fatal("generate Go client: ", err)
+		}
+		write(*goOut, src)
+	}
+	if *tsOut != "" {
+		src, err := clientgen.GenerateTypeScript(spec, source)
+		if err != nil {
+			log.Fatal("generate TypeScript client: ", err)
+		}
+		write(*tsOut, src)
+	}
+}
+
+func write(path string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ghozilaaa/optimistic-lock/csvproc"
+	"github.com/ghozilaaa/optimistic-lock/migrations"
+)
+
+// newApplyCSVCmd applies a CSV command file of manual balance adjustments
+func newApplyCSVCmd() *cobra.Command {
+	var file, failures, progress string
+	var workers int
+	cmd := &cobra.Command{
+		Use:   "apply-csv",
+		Short: "Apply a CSV of balance_id,delta,reference rows",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if progress == "" {
+				progress = file + ".progress"
+			}
+
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+			if _, err := migrations.Up(db, 0); err != nil {
+				return fmt.Errorf("failed to migrate database: %w", err)
+			}
+
+			summary, err := csvproc.ProcessFile(db, file, csvproc.Config{
+				Workers:      workers,
+				FailureFile:  failures,
+				ProgressFile: progress,
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Total: %d, applied: %d, skipped: %d, failed: %d\n",
+				summary.Total, summary.Applied, summary.Skipped, summary.Failed)
+			if summary.Failed > 0 {
+				return fmt.Errorf("%d rows failed, see %s", summary.Failed, failures)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "CSV file with balance_id,delta,reference rows")
+	cmd.Flags().IntVar(&workers, "workers", 4, "number of concurrent workers")
+	cmd.Flags().StringVar(&failures, "failures", "failures.csv", "report file for failed rows")
+	cmd.Flags().StringVar(&progress, "progress", "", "progress journal used to resume (default <file>.progress)")
+	cmd.MarkFlagRequired("file")
+	return cmd
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ghozilaaa/optimistic-lock/recon"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// newAuditVersionsCmd checks balance versions against their history
+func newAuditVersionsCmd() *cobra.Command {
+	var report, plan string
+	var opts recon.VersionAuditOptions
+	var apply bool
+	cmd := &cobra.Command{
+		Use:   "audit-versions",
+		Short: "Find balances whose version disagrees with their history and plan repairs",
+		Long: "audit-versions compares every balance's version with its history since its last force set:\n" +
+			"its adjustments, or with --events its balance events. With --open-at-zero it compares the\n" +
+			"amount with their sum too. It writes the findings and a repair plan; --apply force sets each\n" +
+			"planned balance to its amount, recording an override, and raises versions that fell behind.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+
+			audit, err := recon.AuditVersions(db, opts)
+			if err != nil {
+				return err
+			}
+			if err := writeFile(report, audit.WriteReport); err != nil {
+				return err
+			}
+			if err := writeFile(plan, audit.WritePlan); err != nil {
+				return err
+			}
+			fmt.Printf("Balances: %d, findings: %d, fixes planned: %d\n", audit.Scanned, len(audit.Findings), len(audit.Fixes))
+			if !apply {
+				if len(audit.Fixes) > 0 {
+					fmt.Printf("Review %s, then apply it with: optlockctl audit-versions --apply\n", plan)
+				}
+				return nil
+			}
+
+			var applied, stale int
+			for _, fix := range audit.Fixes {
+				err := recon.ApplyVersionFix(db, fix)
+				switch {
+				case errors.Is(err, service.ErrConflict):
+					stale++
+				case err != nil:
+					return fmt.Errorf("balance %d: %w", fix.BalanceID, err)
+				default:
+					applied++
+				}
+			}
+			fmt.Printf("Applied: %d, skipped because the balance changed: %d\n", applied, stale)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&opts.OpenAtZero, "open-at-zero", false, "balances open at zero, so amounts must equal the adjustment sum")
+	cmd.Flags().BoolVar(&opts.Events, "events", false, "count balance events as the history, where every write was event-sourced")
+	cmd.Flags().IntVar(&opts.BatchSize, "batch", 1000, "balances read per query")
+	cmd.Flags().StringVar(&report, "report", "version-report.csv", "report file listing every finding")
+	cmd.Flags().StringVar(&plan, "plan", "version-plan.csv", "repair plan file")
+	cmd.Flags().BoolVar(&apply, "apply", false, "apply the planned fixes")
+	return cmd
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// newCreateCmd provisions the balance of an owner
+func newCreateCmd() *cobra.Command {
+	var owner string
+	var amount int64
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create the balance of an owner",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+			balance, err := service.CreateBalance(db, owner, amount)
+			if errors.Is(err, service.ErrBalanceExists) {
+				return fmt.Errorf("owner %s already has balance %d", owner, balance.ID)
+			}
+			if err != nil {
+				return err
+			}
+			printBalance(balance)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&owner, "owner", "", "owner of the balance")
+	cmd.Flags().Int64Var(&amount, "amount", 0, "initial amount")
+	cmd.MarkFlagRequired("owner")
+	return cmd
+}
+
+// newGetCmd prints a balance with its version
+func newGetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "get ID",
+		Short: "Show a balance and its version",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+			balance, err := service.GetBalance(db, id)
+			if err != nil {
+				return err
+			}
+			printBalance(balance)
+			return nil
+		},
+	}
+}
+
+// newCreditCmd adds an amount to a balance
+func newCreditCmd() *cobra.Command {
+	return newAdjustCmd("credit", "Add an amount to a balance", 1)
+}
+
+// newDebitCmd subtracts an amount from a balance
+func newDebitCmd() *cobra.Command {
+	return newAdjustCmd("debit", "Subtract an amount from a balance", -1)
+}
+
+// newAdjustCmd builds credit and debit, which differ only in the sign of the delta
+func newAdjustCmd(name, short string, sign int64) *cobra.Command {
+	var reference string
+	cmd := &cobra.Command{
+		Use:   name + " ID AMOUNT",
+		Short: short,
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			amount, err := parseAmount(args[1])
+			if err != nil {
+				return err
+			}
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+
+			if reference != "" {
+				applied, err := newUpdater(db).ApplyAdjustment(reference, id, sign*amount)
+				if err != nil {
+					return err
+				}
+				if !applied {
+					fmt.Printf("Reference %s was already applied\n", reference)
+				}
+			} else if _, err := newUpdater(db).UpdateBalance(id, sign*amount); err != nil {
+				return err
+			}
+
+			balance, err := service.GetBalance(db, id)
+			if err != nil {
+				return err
+			}
+			printBalance(balance)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&reference, "reference", "", "idempotency reference; a reference is applied at most once")
+	return cmd
+}
+
+// newTransferCmd moves an amount between two balances in one transaction
+func newTransferCmd() *cobra.Command {
+	var allowNegative bool
+	cmd := &cobra.Command{
+		Use:   "transfer FROM TO AMOUNT",
+		Short: "Move an amount between two balances atomically",
+		Args:  cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			from, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			to, err := parseID(args[1])
+			if err != nil {
+				return err
+			}
+			amount, err := parseAmount(args[2])
+			if err != nil {
+				return err
+			}
+			if from == to {
+				return errors.New("cannot transfer to the same balance")
+			}
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+
+			ops := []service.Op{{Kind: service.OpDebit, BalanceID: from, Amount: amount}}
+			if !allowNegative {
+				ops = append(ops, service.Op{Kind: service.OpAssert, BalanceID: from, Check: service.AtLeast(0)})
+			}
+			ops = append(ops, service.Op{Kind: service.OpCredit, BalanceID: to, Amount: amount})
+
+			result, err := newUpdater(db).RunScript(cmd.Context(), ops)
+			if err != nil {
+				return err
+			}
+			printBalance(result.Balances[from], result.Balances[to])
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&allowNegative, "allow-negative", false, "allow the source balance to go below zero")
+	return cmd
+}
+
+// newHistoryCmd lists the adjustments recorded for a balance
+func newHistoryCmd() *cobra.Command {
+	var limit int
+	cmd := &cobra.Command{
+		Use:   "history ID",
+		Short: "List the referenced adjustments of a balance, newest first",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+
+			var adjustments []models.Adjustment
+			if err := db.Where("balance_id = ?", id).Order("created_at DESC, id DESC").Limit(limit).
+				Find(&adjustments).Error; err != nil {
+				return err
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "CREATED\tREFERENCE\tDELTA")
+			for _, a := range adjustments {
+				fmt.Fprintf(tw, "%s\t%s\t%d\n", a.CreatedAt.Format(time.RFC3339), a.Reference, a.Delta)
+			}
+			return tw.Flush()
+		},
+	}
+	cmd.Flags().IntVar(&limit, "limit", 50, "maximum number of adjustments to list")
+	return cmd
+}
+
+// printBalance prints balances as a table
+func printBalance(balances ...models.Balance) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUID\tOWNER\tAMOUNT\tVERSION\tSTATUS")
+	for _, b := range balances {
+		uid, owner := "-", "-"
+		if b.UID != nil {
+			uid = *b.UID
+		}
+		if b.OwnerID != nil {
+			owner = *b.OwnerID
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\t%s\n", b.ID, uid, owner, b.Amount, b.Version, b.Status)
+	}
+	tw.Flush()
+}
+
+func parseID(s string) (uint, error) {
+	id, err := strconv.ParseUint(s, 10, 0)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("invalid balance ID %q", s)
+	}
+	return uint(id), nil
+}
+
+func parseAmount(s string) (int64, error) {
+	amount, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || amount <= 0 {
+		return 0, fmt.Errorf("invalid amount %q: must be a positive integer", s)
+	}
+	return amount, nil
+}
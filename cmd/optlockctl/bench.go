@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/loadgen"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// newBenchCmd groups the load-test subcommands
+func newBenchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Run load tests and compare result files",
+	}
+	cmd.AddCommand(newBenchRunCmd(), newBenchCompareCmd())
+	return cmd
+}
+
+// newBenchRunCmd drives updates against one hot balance in a loadgen.Shape,
+// like the TPS tests, and writes a loadgen.Report
+func newBenchRunCmd() *cobra.Command {
+	var shape shapeFlags
+	var name, out, latencyOut, strategyName string
+	var runs int
+	var amount int64
+	var reportEvery time.Duration
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run a load test against the configured database",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if runs <= 0 {
+				return errors.New("--runs must be positive")
+			}
+			shape, err := shape.shape()
+			if err != nil {
+				return err
+			}
+			strategy, err := service.ParseStrategy(strategyName)
+			if err != nil {
+				return err
+			}
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+
+			var opts []loadgen.Option
+			if reportEvery > 0 {
+				sqlDB, err := db.DB()
+				if err != nil {
+					return err
+				}
+				opts = append(opts, loadgen.WithInterim(reportEvery, printInterim), loadgen.WithPoolStats(sqlDB.Stats))
+			}
+
+			report := loadgen.Report{Name: name}
+			var latency loadgen.Histogram
+			for i := 1; i <= runs; i++ {
+				res, err := runLoad(cmd.Context(), db, shape, strategy, amount, opts...)
+				if err != nil {
+					return err
+				}
+				run := res.Run()
+				fmt.Printf("Run %d: %.1f TPS, p50 %.1fms, p90 %.1fms, p99 %.1fms, p999 %.1fms, max %.1fms, conflict rate %.2f%%\n",
+					i, run.TPS, run.P50Ms, run.P90Ms, run.P99Ms, run.P999Ms, run.MaxMs, run.ConflictRate*100)
+				report.Runs = append(report.Runs, run)
+				latency.Merge(&res.Latency)
+			}
+
+			if latencyOut != "" {
+				if err := loadgen.WritePercentilesFile(latencyOut, &latency); err != nil {
+					return err
+				}
+				fmt.Printf("Wrote the latency distribution of %d transactions to %s\n", latency.Count(), latencyOut)
+			}
+			if out == "" {
+				return nil
+			}
+			if err := writeBenchReport(out, report); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote %s; compare JSON reports with: optlockctl bench compare old.json new.json\n", out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "bench", "scenario name stored in the report")
+	shape.register(cmd)
+	cmd.Flags().StringVar(&strategyName, "strategy", "optimistic", "update strategy: "+strings.Join(service.StrategyNames(), ", "))
+	cmd.Flags().Int64Var(&amount, "amount", 10, "amount added by each transaction")
+	cmd.Flags().IntVar(&runs, "runs", 1, "number of runs; use at least 2 for bench compare")
+	cmd.Flags().StringVar(&out, "out", "", "write the results to this report file, as CSV if it ends in .csv and JSON otherwise")
+	cmd.Flags().DurationVar(&reportEvery, "report-every", 0, "print the rate, latency, memory and pool connections every interval while a run runs, e.g. 1m for a soak (0 disables)")
+	cmd.Flags().StringVar(&latencyOut, "latency-out", "", "write the latency percentiles of all runs to this file, in the HdrHistogram .hgrm format")
+	return cmd
+}
+
+// shapeFlags are the traffic shape flags of bench run and bench compare
+type shapeFlags struct {
+	name, bursts          string
+	tps, from, to, jitter float64
+	duration              time.Duration
+}
+
+func (f *shapeFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.name, "shape", "constant", "traffic shape: constant, variable, ramp, soak or burst")
+	cmd.Flags().Float64Var(&f.tps, "tps", 100, "target transactions per second (constant, variable, soak)")
+	cmd.Flags().DurationVar(&f.duration, "duration", 10*time.Second, "length of each run (constant, variable, ramp, soak)")
+	cmd.Flags().Float64Var(&f.jitter, "jitter", 0.5, "random variation of each interval, 0.5 = ±50% (variable)")
+	cmd.Flags().Float64Var(&f.from, "from", 10, "starting transactions per second (ramp)")
+	cmd.Flags().Float64Var(&f.to, "to", 200, "final transactions per second (ramp)")
+	cmd.Flags().StringVar(&f.bursts, "bursts", "50x10ms+2s,30x100ms+1s,20x200ms+500ms,40x20ms", "phases as COUNTxINTERVAL[+PAUSE] (burst)")
+}
+
+// shape builds the traffic shape the flags select
+func (f *shapeFlags) shape() (loadgen.Shape, error) {
+	return benchShape(f.name, f.tps, f.duration, f.jitter, f.from, f.to, f.bursts)
+}
+
+// benchShape builds the traffic shape selected by the bench run flags
+func benchShape(name string, tps float64, duration time.Duration, jitter, from, to float64, burst string) (loadgen.Shape, error) {
+	switch name {
+	case "constant", "variable", "soak":
+		if tps <= 0 || duration <= 0 {
+			return nil, errors.New("--tps and --duration must be positive")
+		}
+		switch name {
+		case "constant":
+			return loadgen.Constant{TPS: tps, Duration: duration}, nil
+		case "soak":
+			return loadgen.Soak{TPS: tps, Duration: duration}, nil
+		}
+		if jitter < 0 || jitter > 1 {
+			return nil, errors.New("--jitter must be between 0 and 1")
+		}
+		return loadgen.Variable{TPS: tps, Duration: duration, Jitter: jitter}, nil
+	case "ramp":
+		if from < 0 || to < 0 || from+to == 0 || duration <= 0 {
+			return nil, errors.New("--from and --to must not be negative, one of them positive, and --duration positive")
+		}
+		return loadgen.Ramp{From: from, To: to, Duration: duration}, nil
+	case "burst":
+		return loadgen.ParseBurst(burst)
+	default:
+		return nil, fmt.Errorf("unknown --shape %q, want constant, variable, ramp, soak or burst", name)
+	}
+}
+
+// writeBenchReport writes report to path as CSV or JSON, by extension
+func writeBenchReport(path string, report loadgen.Report) error {
+	if filepath.Ext(path) != ".csv" {
+		return loadgen.WriteReport(path, report)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := loadgen.WriteCSV(f, report); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runLoad sends updates with strategy to a new balance in shape, waits for
+// them to finish and checks the final amount
+func runLoad(ctx context.Context, db *gorm.DB, shape loadgen.Shape, strategy service.Strategy, amount int64, opts ...loadgen.Option) (loadgen.Result, error) {
+	balance := models.Balance{Amount: 0}
+	if err := db.Create(&balance).Error; err != nil {
+		return loadgen.Result{}, err
+	}
+
+	updater := newUpdater(db).With(service.WithStrategy(strategy))
+	res := loadgen.Generate(ctx, shape, func() (service.UpdateOutcome, error) {
+		return updater.UpdateBalance(balance.ID, amount)
+	}, opts...)
+
+	var updated models.Balance
+	if err := db.First(&updated, balance.ID).Error; err != nil {
+		return loadgen.Result{}, err
+	}
+	if res.Succeeded == 0 && len(res.Errors) > 0 && errors.Is(res.Errors[0], service.ErrStrategyUnsupported) {
+		return loadgen.Result{}, res.Errors[0]
+	}
+	if want := int64(res.Succeeded) * amount; updated.Amount != want {
+		return loadgen.Result{}, fmt.Errorf("balance integrity failed: expected %d, got %d", want, updated.Amount)
+	}
+	if res.Failures > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d transactions failed with errors other than conflicts, first: %v\n", res.Failures, res.Errors[0])
+	}
+	return res, nil
+}
+
+// printInterim prints a sample of a run in progress
+func printInterim(in loadgen.Interim) {
+	elapsed := time.Duration(in.ElapsedMs * float64(time.Millisecond)).Round(10 * time.Millisecond)
+	fmt.Printf("  %v: %.1f TPS, p50 %.1fms, p99 %.1fms, %d conflicts, %d failures, %d in flight; heap %.1fMB, %d goroutines, %d GCs; pool %d open, %d in use, %d waits (%.0fms)\n",
+		elapsed, in.TPS, in.P50Ms, in.P99Ms, in.Conflicts, in.Failures, in.InFlight,
+		in.HeapMB, in.Goroutines, in.GCs, in.OpenConns, in.InUseConns, in.Waits, in.WaitMs)
+}
+
+// newBenchCompareCmd runs the same workload with every update strategy and
+// prints them side by side, or prints metric deltas with confidence intervals
+// between two result files
+func newBenchCompareCmd() *cobra.Command {
+	var shape shapeFlags
+	var strategies []string
+	var runs int
+	var amount int64
+	cmd := &cobra.Command{
+		Use:   "compare [old.json new.json]",
+		Short: "Compare update strategies on the same workload, or two load-test result files",
+		Long: `Without arguments, compare runs the workload of the shape flags against a fresh
+balance with each of --strategies in turn and prints their TPS, p50 and p99
+latency and conflict rate. Strategies the database cannot run are skipped.
+With two report files written by bench run, it compares them instead.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 && len(args) != 2 {
+				return errors.New("want no arguments, or two report files")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 2 {
+				return compareReports(args[0], args[1])
+			}
+			if runs <= 0 {
+				return errors.New("--runs must be positive")
+			}
+			shape, err := shape.shape()
+			if err != nil {
+				return err
+			}
+			parsed := make([]service.Strategy, len(strategies))
+			for i, name := range strategies {
+				if parsed[i], err = service.ParseStrategy(name); err != nil {
+					return err
+				}
+			}
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+			return compareStrategies(cmd.Context(), db, shape, parsed, runs, amount)
+		},
+	}
+	shape.register(cmd)
+	cmd.Flags().StringSliceVar(&strategies, "strategies", []string{"optimistic", "for_update", "advisory", "atomic"},
+		"comma-separated strategies to compare, the baseline first: "+strings.Join(service.StrategyNames(), ", "))
+	cmd.Flags().Int64Var(&amount, "amount", 10, "amount added by each transaction")
+	cmd.Flags().IntVar(&runs, "runs", 1, "runs per strategy, averaged")
+	return cmd
+}
+
+// compareStrategies runs shape with each strategy and prints the summaries
+func compareStrategies(ctx context.Context, db *gorm.DB, shape loadgen.Shape, strategies []service.Strategy, runs int, amount int64) error {
+	fmt.Printf("Workload: %s, %d run(s) per strategy\n", shape, runs)
+	var reports []loadgen.Report
+	for _, strategy := range strategies {
+		report := loadgen.Report{Name: string(strategy)}
+		for i := 1; i <= runs; i++ {
+			res, err := runLoad(ctx, db, shape, strategy, amount)
+			if errors.Is(err, service.ErrStrategyUnsupported) {
+				fmt.Printf("Skipping %s: %v\n", strategy, err)
+				report.Runs = nil
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", strategy, err)
+			}
+			run := res.Run()
+			fmt.Printf("%s run %d: %.1f TPS, p50 %.1fms, p99 %.1fms, conflict rate %.2f%%\n",
+				strategy, i, run.TPS, run.P50Ms, run.P99Ms, run.ConflictRate*100)
+			report.Runs = append(report.Runs, run)
+		}
+		if len(report.Runs) > 0 {
+			reports = append(reports, report)
+		}
+	}
+	if len(reports) == 0 {
+		return errors.New("no strategy could run")
+	}
+	fmt.Println()
+	loadgen.PrintSummaries(os.Stdout, reports)
+	return nil
+}
+
+// compareReports prints metric deltas with confidence intervals between two result files
+func compareReports(oldPath, newPath string) error {
+	old, err := loadgen.LoadReport(oldPath)
+	if err != nil {
+		return err
+	}
+	new, err := loadgen.LoadReport(newPath)
+	if err != nil {
+		return err
+	}
+
+	loadgen.PrintComparison(os.Stdout, old, new, loadgen.Compare(old, new))
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// newForceSetCmd sets the amount of a corrupted balance, bypassing the version check
+func newForceSetCmd() *cobra.Command {
+	var reason, actor string
+	cmd := &cobra.Command{
+		Use:   "force-set ID AMOUNT",
+		Short: "Set the amount of a balance whatever its version, recording why",
+		Long: "force-set overwrites the amount of a balance without an expected version, for fixing a corrupted\n" +
+			"balance instead of raw SQL. It bumps the version, so writers holding the old one conflict, and\n" +
+			"records the previous and new amount with --reason and --actor in balance_overrides.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			amount, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid amount %q: must be an integer", args[1])
+			}
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+			balance, err := newUpdater(db).As(actor).ForceSet(id, amount, reason)
+			if err != nil {
+				return err
+			}
+			printBalance(balance)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&reason, "reason", "", "why the amount is overwritten, e.g. a ticket")
+	cmd.Flags().StringVar(&actor, "actor", os.Getenv("USER"), "who overwrites it")
+	cmd.MarkFlagRequired("reason")
+	return cmd
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ghozilaaa/optimistic-lock/migrations"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// newImportCmd seeds balances from a CSV export of another system
+func newImportCmd() *cobra.Command {
+	var file string
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import balances from a CSV of owner_id,currency,external_ref,amount,version rows",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			seeds, err := readSeeds(f)
+			if err != nil {
+				return err
+			}
+
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+			if _, err := migrations.Up(db, 0); err != nil {
+				return fmt.Errorf("failed to migrate database: %w", err)
+			}
+
+			result, err := service.ImportBalances(db, seeds)
+			fmt.Printf("Total: %d, inserted: %d, updated: %d, skipped: %d\n",
+				len(seeds), result.Inserted, result.Updated, result.Skipped)
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "CSV file with a header row and owner_id,currency,external_ref,amount,version rows")
+	cmd.MarkFlagRequired("file")
+	return cmd
+}
+
+// readSeeds parses the import CSV, skipping its header row
+func readSeeds(r io.Reader) ([]service.BalanceSeed, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 5
+	if _, err := reader.Read(); err != nil {
+		return nil, fmt.Errorf("reading the header: %w", err)
+	}
+
+	var seeds []service.BalanceSeed
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return seeds, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		amount, err := strconv.ParseInt(record[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid amount %q", line, record[3])
+		}
+		version, err := strconv.Atoi(record[4])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid version %q", line, record[4])
+		}
+		seeds = append(seeds, service.BalanceSeed{
+			OwnerID:     record[0],
+			Currency:    record[1],
+			ExternalRef: record[2],
+			Amount:      amount,
+			Version:     version,
+		})
+	}
+}
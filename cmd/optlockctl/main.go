@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/database"
+	"github.com/ghozilaaa/optimistic-lock/ids"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func main() {
+	if err := newRootCmd().Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// newRootCmd assembles the optlockctl command tree
+func newRootCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:           "optlockctl",
+		Short:         "Operate balances and run maintenance tasks",
+		Long:          "optlockctl operates balances and runs maintenance tasks. It reads the same DB_* and STORAGE_MODE environment variables as the application.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	root.AddCommand(
+		newMigrateCmd(),
+		newCreateCmd(),
+		newGetCmd(),
+		newCreditCmd(),
+		newDebitCmd(),
+		newTransferCmd(),
+		newFreezeCmd(),
+		newUnfreezeCmd(),
+		newCloseCmd(),
+		newForceSetCmd(),
+		newHistoryCmd(),
+		newApplyCSVCmd(),
+		newImportCmd(),
+		newReconcileCmd(),
+		newSendPaymentCmd(),
+		newAuditVersionsCmd(),
+		newRebuildCmd(),
+		newBenchCmd(),
+		newVelocityCmd(),
+		newPromoteCmd(),
+		newScheduleCmd(),
+	)
+	return root
+}
+
+// openDB connects to the database configured by the DB_* environment
+// variables. Like the application, it gives new balances a UID when
+// STORAGE_BALANCE_UIDS names a generator.
+func openDB() (*gorm.DB, error) {
+	db, err := database.Open(database.ConfigFromEnv(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if generate, ok := ids.ByName(os.Getenv("STORAGE_BALANCE_UIDS")); ok {
+		if err := db.Use(ids.Plugin{Generate: generate}); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// newUpdater returns the updater for balance writes. Like the application, it
+// appends balance events when STORAGE_MODE is events.
+func newUpdater(db *gorm.DB) *service.Updater {
+	if os.Getenv("STORAGE_MODE") == "events" {
+		return service.NewUpdater(db, service.WithEventSourcing())
+	}
+	return service.NewUpdater(db)
+}
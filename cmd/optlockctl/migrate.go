@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/migrations"
+)
+
+// newMigrateCmd applies, reverts and inspects the versioned schema migrations.
+// Without a subcommand it applies every pending migration.
+func newMigrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply the pending schema migrations, or manage them with a subcommand",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return migrateUp(0)
+		},
+	}
+	cmd.AddCommand(newMigrateUpCmd(), newMigrateDownCmd(), newMigrateStatusCmd(), newMigrateDriftCmd())
+	return cmd
+}
+
+func newMigrateUpCmd() *cobra.Command {
+	var to int
+	cmd := &cobra.Command{
+		Use:   "up",
+		Short: "Apply the pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return migrateUp(to)
+		},
+	}
+	cmd.Flags().IntVar(&to, "to", 0, "stop after this version (0 = latest)")
+	return cmd
+}
+
+// migrateUp applies the migrations up to version to
+func migrateUp(to int) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	applied, err := migrations.Up(db, to)
+	for _, m := range applied {
+		fmt.Printf("Applied %d %s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+	fmt.Println("Database migration completed successfully")
+	return nil
+}
+
+func newMigrateDownCmd() *cobra.Command {
+	var to, steps int
+	cmd := &cobra.Command{
+		Use:   "down",
+		Short: "Revert applied migrations, dropping their tables and data",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cmd.Flags().Changed("to") && cmd.Flags().Changed("steps") {
+				return errors.New("give --to or --steps, not both")
+			}
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+			if !cmd.Flags().Changed("to") {
+				if to, err = stepsBack(db, steps); err != nil {
+					return err
+				}
+			}
+
+			reverted, err := migrations.Down(db, to)
+			for _, m := range reverted {
+				fmt.Printf("Reverted %d %s\n", m.Version, m.Name)
+			}
+			return err
+		},
+	}
+	cmd.Flags().IntVar(&to, "to", 0, "revert the migrations above this version (0 reverts all)")
+	cmd.Flags().IntVar(&steps, "steps", 1, "revert this many of the applied migrations, when --to is not given")
+	return cmd
+}
+
+// stepsBack returns the version to revert to so that the last steps applied
+// migrations are reverted
+func stepsBack(db *gorm.DB, steps int) (int, error) {
+	if steps <= 0 {
+		return 0, errors.New("--steps must be positive")
+	}
+	statuses, err := migrations.Status(db)
+	if err != nil {
+		return 0, err
+	}
+	var applied []int
+	for _, s := range statuses {
+		if s.Applied && !s.Unknown {
+			applied = append(applied, s.Version)
+		}
+	}
+	if steps >= len(applied) {
+		return 0, nil
+	}
+	return applied[len(applied)-steps-1], nil
+}
+
+func newMigrateStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "List the migrations and whether they are applied",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+			statuses, err := migrations.Status(db)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+			for _, s := range statuses {
+				applied := "pending"
+				switch {
+				case s.Unknown:
+					applied = s.AppliedAt.Format("2006-01-02 15:04:05") + " (unknown to this release)"
+				case s.Applied:
+					applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func newMigrateDriftCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "drift",
+		Short: "Compare the database schema with the migrations and models; exits non-zero on drift",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+			drift, err := migrations.DetectDrift(db)
+			if err != nil {
+				return err
+			}
+			for _, d := range drift {
+				fmt.Println(d)
+			}
+			if len(drift) > 0 {
+				return fmt.Errorf("%d schema differences", len(drift))
+			}
+			fmt.Println("No drift")
+			return nil
+		},
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ghozilaaa/optimistic-lock/region"
+)
+
+// newPromoteCmd promotes the standby database of a passive region to primary
+func newPromoteCmd() *cobra.Command {
+	var name string
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "promote",
+		Short: "Promote a passive region's standby database and bump the failover epoch",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// DB_* must point at the region's standby
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+
+			controller := region.NewController(db, name, false)
+			if err := controller.Promote(cmd.Context(), region.PromoteOptions{CatchUpTimeout: timeout}); err != nil {
+				return err
+			}
+			fmt.Printf("Region %s promoted, failover epoch %d\n", name, controller.Epoch())
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "region", "", "name of the region being promoted")
+	cmd.Flags().DurationVar(&timeout, "catch-up-timeout", time.Minute, "how long to wait for the replica to replay received WAL")
+	cmd.MarkFlagRequired("region")
+	return cmd
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ghozilaaa/optimistic-lock/recon"
+)
+
+// newReconcileCmd matches an external statement against recorded adjustments
+func newReconcileCmd() *cobra.Command {
+	var statement, format, report, adjustments string
+	var balanceID uint
+	cmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Reconcile a bank statement (CSV or MT940) against adjustments",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(statement)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			var parse func(io.Reader) ([]recon.Entry, error)
+			switch format {
+			case "csv":
+				parse = recon.ParseCSV
+			case "mt940":
+				parse = recon.ParseMT940
+			default:
+				return fmt.Errorf("unknown statement format %q", format)
+			}
+			entries, err := parse(f)
+			if err != nil {
+				return err
+			}
+
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+
+			result, err := recon.Reconcile(db, balanceID, entries, recon.Options{})
+			if err != nil {
+				return err
+			}
+
+			if err := writeFile(report, func(w io.Writer) error { return result.WriteReport(w) }); err != nil {
+				return err
+			}
+			var proposed int
+			if err := writeFile(adjustments, func(w io.Writer) error {
+				proposed, err = result.WriteAdjustments(w)
+				return err
+			}); err != nil {
+				return err
+			}
+
+			fmt.Printf("Entries: %d, mismatches: %d, proposed adjustments: %d\n",
+				len(entries), len(result.Mismatches()), proposed)
+			fmt.Printf("Review %s, then apply it with: optlockctl apply-csv --file %s\n", adjustments, adjustments)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&statement, "statement", "", "statement file to reconcile")
+	cmd.Flags().StringVar(&format, "format", "csv", "statement format: csv or mt940")
+	cmd.Flags().UintVar(&balanceID, "balance", 0, "balance ID the statement belongs to")
+	cmd.Flags().StringVar(&report, "report", "recon-report.csv", "report file listing every finding")
+	cmd.Flags().StringVar(&adjustments, "adjustments", "recon-adjustments.csv", "proposed adjusting entries for apply-csv")
+	cmd.MarkFlagRequired("statement")
+	cmd.MarkFlagRequired("balance")
+	return cmd
+}
+
+// writeFile creates path and passes it to write
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
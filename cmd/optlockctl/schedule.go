@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/schedule"
+)
+
+// newScheduleCmd manages the recurring adjustments of the schedules table
+func newScheduleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "Manage recurring adjustments such as interest and fees",
+	}
+	cmd.AddCommand(newScheduleCreateCmd(), newScheduleListCmd(),
+		newSchedulePauseCmd("pause", true), newSchedulePauseCmd("resume", false), newScheduleRunCmd())
+	return cmd
+}
+
+func newScheduleCreateCmd() *cobra.Command {
+	var s models.Schedule
+	var first string
+	cmd := &cobra.Command{
+		Use:   "create <name> <balance-id> <spec>",
+		Short: `Create a schedule; spec is a cron expression in UTC, e.g. "0 0 1 * *", or "@every 1h"`,
+		Args:  cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseUint(args[1], 10, 0)
+			if err != nil {
+				return fmt.Errorf("invalid balance ID %q", args[1])
+			}
+			s.Name, s.BalanceID, s.Spec = args[0], uint(id), args[2]
+			if first != "" {
+				if s.NextRunAt, err = time.Parse(time.RFC3339, first); err != nil {
+					return fmt.Errorf("invalid --first-run: %w", err)
+				}
+			}
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+			created, err := schedule.Create(db, s)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Schedule %d %s created, first run at %s\n", created.ID, created.Name, created.NextRunAt.UTC().Format(time.RFC3339))
+			return nil
+		},
+	}
+	cmd.Flags().Int64Var(&s.Delta, "delta", 0, "amount added every run; negative for fees")
+	cmd.Flags().Int64Var(&s.RateBps, "rate-bps", 0, "basis points of the amount added every run, e.g. for interest")
+	cmd.Flags().StringVar(&first, "first-run", "", "first run time (RFC 3339); defaults to the next time the spec matches")
+	return cmd
+}
+
+func newScheduleListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the schedules",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+			var schedules []models.Schedule
+			if err := db.Order("id").Find(&schedules).Error; err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tNAME\tBALANCE\tSPEC\tDELTA\tRATE BPS\tNEXT RUN\tLAST RUN\tPAUSED")
+			for _, s := range schedules {
+				last := "-"
+				if s.LastRunAt != nil {
+					last = s.LastRunAt.UTC().Format(time.RFC3339)
+				}
+				fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%d\t%d\t%s\t%s\t%t\n", s.ID, s.Name, s.BalanceID, s.Spec,
+					s.Delta, s.RateBps, s.NextRunAt.UTC().Format(time.RFC3339), last, s.Paused)
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+func newSchedulePauseCmd(use string, paused bool) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " <schedule-id>",
+		Short: fmt.Sprintf("%s a schedule", map[bool]string{true: "Pause", false: "Resume"}[paused]),
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseUint(args[0], 10, 0)
+			if err != nil {
+				return fmt.Errorf("invalid schedule ID %q", args[0])
+			}
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+			if err := schedule.SetPaused(db, uint(id), paused); err != nil {
+				return err
+			}
+			fmt.Printf("Schedule %d %sd\n", id, use)
+			return nil
+		},
+	}
+}
+
+func newScheduleRunCmd() *cobra.Command {
+	var maxCatchUp int
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Apply the runs that are due once, e.g. from cron instead of the service",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+			applied, err := schedule.NewScheduler(db, newUpdater(db), maxCatchUp).RunOnce(context.Background())
+			fmt.Printf("Applied %d runs\n", applied)
+			return err
+		},
+	}
+	cmd.Flags().IntVar(&maxCatchUp, "max-catch-up", 100, "missed runs applied per schedule")
+	return cmd
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// newFreezeCmd stops the updates of a balance
+func newFreezeCmd() *cobra.Command {
+	return newStatusCmd("freeze", "Stop all updates of a balance until it is unfrozen", service.Freeze)
+}
+
+// newUnfreezeCmd lets a frozen balance take updates again
+func newUnfreezeCmd() *cobra.Command {
+	return newStatusCmd("unfreeze", "Let a frozen balance take updates again", service.Unfreeze)
+}
+
+// newCloseCmd stops the updates of a balance for good
+func newCloseCmd() *cobra.Command {
+	return newStatusCmd("close", "Stop all updates of a balance for good", service.Close)
+}
+
+// newStatusCmd builds freeze, unfreeze and close, which differ only in the transition
+func newStatusCmd(name, short string, transition func(*gorm.DB, uint) (models.Balance, error)) *cobra.Command {
+	return &cobra.Command{
+		Use:   name + " ID",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+			balance, err := transition(db, id)
+			if err != nil {
+				return err
+			}
+			printBalance(balance)
+			return nil
+		},
+	}
+}
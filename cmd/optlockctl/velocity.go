@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ghozilaaa/optimistic-lock/velocity"
+)
+
+// newVelocityCmd prints the balances whose debit/credit velocity breaks a rule
+func newVelocityCmd() *cobra.Command {
+	var window time.Duration
+	var all bool
+	var rules velocity.Rules
+	cmd := &cobra.Command{
+		Use:   "velocity",
+		Short: "Report balances whose debit/credit velocity breaks rule thresholds",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := openDB()
+			if err != nil {
+				return err
+			}
+
+			report, err := velocity.Build(db, window, rules)
+			if err != nil {
+				return err
+			}
+			accounts := report.Flagged()
+			if all {
+				accounts = report.Accounts
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "BALANCE\tDEBITS\tDEBIT TOTAL\tCREDITS\tCREDIT TOTAL\tFLAGS")
+			for _, a := range accounts {
+				fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%s\n",
+					a.BalanceID, a.Debits, a.DebitTotal, a.Credits, a.CreditTotal, strings.Join(a.Flags, "; "))
+			}
+			tw.Flush()
+
+			fmt.Printf("%d of %d active balances flagged since %s\n",
+				len(report.Flagged()), len(report.Accounts), report.Since.Format(time.RFC3339))
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&window, "window", 24*time.Hour, "aggregation window ending now")
+	cmd.Flags().BoolVar(&all, "all", false, "list every active balance, not only flagged ones")
+	cmd.Flags().IntVar(&rules.MaxDebits, "max-debits", 0, "flag balances with more debits in the window (0 disables)")
+	cmd.Flags().IntVar(&rules.MaxCredits, "max-credits", 0, "flag balances with more credits in the window (0 disables)")
+	cmd.Flags().Int64Var(&rules.MaxDebitTotal, "max-debit-total", 0, "flag balances debited more in total (0 disables)")
+	cmd.Flags().Int64Var(&rules.MaxCreditTotal, "max-credit-total", 0, "flag balances credited more in total (0 disables)")
+	return cmd
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ghozilaaa/optimistic-lock/webhook"
+)
+
+// newSendPaymentCmd delivers a signed payment notification to the service,
+// e.g. to replay one the provider failed to deliver
+func newSendPaymentCmd() *cobra.Command {
+	var url, reference, tenantHeader, tenantID string
+	var balanceID uint
+	var delta int64
+	cmd := &cobra.Command{
+		Use:   "send-payment",
+		Short: "Deliver a signed payment webhook, signed with SERVER_WEBHOOK_SECRET",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			secret := os.Getenv("SERVER_WEBHOOK_SECRET")
+			if secret == "" {
+				return errors.New("SERVER_WEBHOOK_SECRET is not set")
+			}
+			body, err := json.Marshal(map[string]any{"reference": reference, "balance_id": balanceID, "delta": delta})
+			if err != nil {
+				return err
+			}
+			req, err := webhook.Signer{Secret: []byte(secret)}.NewRequest(url, body)
+			if err != nil {
+				return err
+			}
+			if tenantHeader != "" {
+				req.Header.Set(tenantHeader, tenantID)
+			}
+			resp, err := http.DefaultClient.Do(req.WithContext(cmd.Context()))
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			reply, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("delivery refused: %s: %s", resp.Status, reply)
+			}
+			fmt.Printf("%s\n", reply)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&url, "url", "http://localhost:8080/webhooks/payments", "payment webhook endpoint")
+	cmd.Flags().StringVar(&reference, "reference", "", "the provider's reference of the payment")
+	cmd.Flags().UintVar(&balanceID, "balance", 0, "balance ID to credit or debit")
+	cmd.Flags().Int64Var(&delta, "delta", 0, "amount to add; negative to debit")
+	cmd.Flags().StringVar(&tenantHeader, "tenant-header", os.Getenv("SERVER_TENANT_HEADER"), "request header naming the tenant")
+	cmd.Flags().StringVar(&tenantID, "tenant", "", "tenant of the balance")
+	cmd.MarkFlagRequired("reference")
+	cmd.MarkFlagRequired("balance")
+	return cmd
+}
@@ -0,0 +1,792 @@
+// Package config loads the application settings from defaults, an optional
+// YAML or TOML file, environment variables and command-line flags, each
+// source overriding the previous one.
+package config
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/cache"
+	"github.com/ghozilaaa/optimistic-lock/cpuquota"
+	"github.com/ghozilaaa/optimistic-lock/database"
+	"github.com/ghozilaaa/optimistic-lock/ids"
+	"github.com/ghozilaaa/optimistic-lock/logging"
+	"github.com/ghozilaaa/optimistic-lock/metrics"
+	"github.com/ghozilaaa/optimistic-lock/secrets"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// Config holds every setting of the service
+type Config struct {
+	// Profile is production, staging or test; only the test profile serves
+	// the testing endpoints, and only staging and test add debug headers
+	Profile   string    `yaml:"profile" toml:"profile"`
+	Database  Database  `yaml:"database" toml:"database"`
+	Storage   Storage   `yaml:"storage" toml:"storage"`
+	Cache     Cache     `yaml:"cache" toml:"cache"`
+	Retry     Retry     `yaml:"retry" toml:"retry"`
+	Server    Server    `yaml:"server" toml:"server"`
+	Metrics   Metrics   `yaml:"metrics" toml:"metrics"`
+	Audit     Audit     `yaml:"audit" toml:"audit"`
+	Log       Log       `yaml:"log" toml:"log"`
+	Runtime   Runtime   `yaml:"runtime" toml:"runtime"`
+	Scheduler Scheduler `yaml:"scheduler" toml:"scheduler"`
+	Testing   Testing   `yaml:"testing" toml:"testing"`
+}
+
+// Database holds the connection and pool settings
+type Database struct {
+	Driver   string   `yaml:"driver" toml:"driver"`
+	Host     string   `yaml:"host" toml:"host"`
+	Port     string   `yaml:"port" toml:"port"`
+	User     string   `yaml:"user" toml:"user"`
+	Password string   `yaml:"password" toml:"password"`
+	Name     string   `yaml:"name" toml:"name"`
+	SSLMode  string   `yaml:"sslmode" toml:"sslmode"`
+	Hosts    []string `yaml:"hosts" toml:"hosts"`
+
+	FailoverResolveInterval time.Duration `yaml:"failover_resolve_interval" toml:"failover_resolve_interval"`
+
+	// Replicas lists read replica endpoints ("host" or "host:port"); reads
+	// go to them by their consistency level
+	Replicas      []string      `yaml:"replicas" toml:"replicas"`
+	ReplicaMaxLag time.Duration `yaml:"replica_max_lag" toml:"replica_max_lag"` // lag bounded reads accept
+
+	// Migrate is what the service does with pending schema migrations at
+	// start: apply them, check that there are none (and no drift), or off
+	Migrate string `yaml:"migrate" toml:"migrate"`
+
+	Secrets Secrets `yaml:"secrets" toml:"secrets"`
+	Pool    Pool    `yaml:"pool" toml:"pool"`
+}
+
+// Secrets selects where the database user and password come from. With no
+// provider User and Password are used as they are.
+type Secrets struct {
+	Provider        string        `yaml:"provider" toml:"provider"` // "", env, file, vault or aws
+	UserFile        string        `yaml:"user_file" toml:"user_file"`
+	PasswordFile    string        `yaml:"password_file" toml:"password_file"`
+	VaultAddr       string        `yaml:"vault_addr" toml:"vault_addr"` // defaults to VAULT_ADDR
+	VaultPath       string        `yaml:"vault_path" toml:"vault_path"` // e.g. secret/data/optimistic-lock or database/static-creds/optimistic-lock
+	VaultTokenFile  string        `yaml:"vault_token_file" toml:"vault_token_file"`
+	AWSRegion       string        `yaml:"aws_region" toml:"aws_region"` // defaults to AWS_REGION
+	AWSSecretID     string        `yaml:"aws_secret_id" toml:"aws_secret_id"`
+	RefreshInterval time.Duration `yaml:"refresh_interval" toml:"refresh_interval"` // how often to look for rotated credentials
+}
+
+// Pool holds the database/sql connection pool limits
+type Pool struct {
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time"`
+
+	Tune PoolTune `yaml:"tune" toml:"tune"`
+}
+
+// PoolTune bounds the dbpool auto-tuner, which moves MaxOpenConns between
+// MinOpenConns and MaxOpenConns
+type PoolTune struct {
+	Enabled         bool          `yaml:"enabled" toml:"enabled"`
+	MinOpenConns    int           `yaml:"min_open_conns" toml:"min_open_conns"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns"`
+	WaitThreshold   time.Duration `yaml:"wait_threshold" toml:"wait_threshold"`     // grow when the average wait for a connection exceeds this
+	ConflictPercent int           `yaml:"conflict_percent" toml:"conflict_percent"` // shrink when more attempts than this conflict
+}
+
+// Storage selects how balance changes are stored
+type Storage struct {
+	Mode           string        `yaml:"mode" toml:"mode"`                       // row, or events to also append every change to balance_events
+	SpendingLimits bool          `yaml:"spending_limits" toml:"spending_limits"` // enforce the daily and monthly debit limits of balance_limits
+	Policies       []string      `yaml:"policies" toml:"policies"`               // built-in policies checked on every update, e.g. overdraft
+	BalanceUIDs    string        `yaml:"balance_uids" toml:"balance_uids"`       // uuid, uuidv7 or ulid to give new balances a UID; empty for none
+	MetadataMerge  bool          `yaml:"metadata_merge" toml:"metadata_merge"`   // apply a stale metadata patch when the keys it patches did not change
+	DedupTTL       time.Duration `yaml:"dedup_ttl" toml:"dedup_ttl"`             // window of the webhook dedup keys; 0 disables it
+}
+
+// Cache sizes the in-memory balance cache behind GET /balances/{id}?max_stale=
+type Cache struct {
+	Size int `yaml:"size" toml:"size"` // balances kept; 0 disables the cache
+}
+
+// Retry holds the optimistic retry policy
+type Retry struct {
+	MaxAttempts      int           `yaml:"max_attempts" toml:"max_attempts"`
+	Strategy         string        `yaml:"strategy" toml:"strategy"` // optimistic, serializable, advisory (Postgres), for_update or atomic
+	BaseBackoff      time.Duration `yaml:"base_backoff" toml:"base_backoff"`
+	Timeout          time.Duration `yaml:"timeout" toml:"timeout"`                     // retry budget per update; 0 disables it
+	StatementTimeout time.Duration `yaml:"statement_timeout" toml:"statement_timeout"` // limit of each attempt, retried past it; 0 disables it
+	Adaptive         bool          `yaml:"adaptive" toml:"adaptive"`                   // stretch the backoff of balances with a high conflict rate
+	PessimisticAfter int           `yaml:"pessimistic_after" toml:"pessimistic_after"` // 0 disables the fallback
+	DeadLetter       bool          `yaml:"dead_letter" toml:"dead_letter"`             // queue exhausted updates in failed_updates for replay
+}
+
+// Server holds the HTTP server settings
+type Server struct {
+	Addr            string        `yaml:"addr" toml:"addr"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	Admin           bool          `yaml:"admin" toml:"admin"`                 // serve the /admin endpoints
+	TenantHeader    string        `yaml:"tenant_header" toml:"tenant_header"` // request header naming the tenant; empty for single-tenant
+	AsyncWorkers    int           `yaml:"async_workers" toml:"async_workers"` // workers of POST /balances/{id}/updates; 0 disables it
+	AsyncQueue      int           `yaml:"async_queue" toml:"async_queue"`     // updates queued before it answers 503
+
+	// WebhookSecret verifies deliveries to POST /webhooks/payments, which is
+	// only served with a secret; WebhookTolerance is the clock difference
+	// accepted on their timestamps
+	WebhookSecret    string        `yaml:"webhook_secret" toml:"webhook_secret"`
+	WebhookTolerance time.Duration `yaml:"webhook_tolerance" toml:"webhook_tolerance"`
+}
+
+// Metrics selects the metrics backend
+type Metrics struct {
+	Backend    string `yaml:"backend" toml:"backend"` // none, prometheus or statsd
+	StatsdAddr string `yaml:"statsd_addr" toml:"statsd_addr"`
+	Prefix     string `yaml:"prefix" toml:"prefix"` // statsd metric name prefix
+
+	// HotKeyWindow is the window conflicts per balance are counted over to
+	// find hot keys; 0 disables the count
+	HotKeyWindow time.Duration `yaml:"hot_key_window" toml:"hot_key_window"`
+}
+
+// Audit toggles the audit trails
+type Audit struct {
+	Conflicts bool `yaml:"conflicts" toml:"conflicts"` // record rejected writes in update_conflicts
+}
+
+// Log configures the structured logger
+type Log struct {
+	Level  string `yaml:"level" toml:"level"`   // debug, info, warn or error
+	Format string `yaml:"format" toml:"format"` // text or json
+}
+
+// Runtime sizes the Go scheduler and the in-process worker pools
+type Runtime struct {
+	MaxProcs          int  `yaml:"max_procs" toml:"max_procs"`                   // GOMAXPROCS; 0 follows the container CPU quota
+	Serialize         bool `yaml:"serialize" toml:"serialize"`                   // queue updates to the same balance behind each other in this process
+	SerializerStripes int  `yaml:"serializer_stripes" toml:"serializer_stripes"` // 0 is 64 per GOMAXPROCS
+}
+
+// Scheduler runs the recurring adjustments of the schedules table
+type Scheduler struct {
+	Enabled    bool          `yaml:"enabled" toml:"enabled"`
+	Interval   time.Duration `yaml:"interval" toml:"interval"`         // how often to look for due schedules
+	MaxCatchUp int           `yaml:"max_catch_up" toml:"max_catch_up"` // missed runs applied per schedule and pass
+}
+
+// Testing configures the endpoints served with the test profile
+type Testing struct {
+	ConflictBalanceIDs []uint `yaml:"conflict_balance_ids" toml:"conflict_balance_ids"` // balances whose writes always get a simulated conflict
+	ConflictEveryNth   int    `yaml:"conflict_every_nth" toml:"conflict_every_nth"`     // simulate a conflict on every Nth balance write; 0 disables
+}
+
+// Default returns the settings used when no source overrides them
+func Default() Config {
+	return Config{
+		Profile: "production",
+		Database: Database{
+			Driver:                  "postgres",
+			Host:                    "localhost",
+			Port:                    "5432",
+			User:                    "postgres",
+			Password:                "postgres",
+			Name:                    "optimistic_lock",
+			SSLMode:                 "disable",
+			FailoverResolveInterval: 30 * time.Second,
+			ReplicaMaxLag:           time.Second,
+			Migrate:                 "apply",
+			Secrets: Secrets{
+				RefreshInterval: time.Minute,
+			},
+			Pool: Pool{
+				MaxOpenConns:    50,
+				MaxIdleConns:    25,
+				ConnMaxLifetime: 5 * time.Minute,
+				ConnMaxIdleTime: 30 * time.Second,
+				Tune: PoolTune{
+					MinOpenConns:    5,
+					MaxOpenConns:    200,
+					WaitThreshold:   5 * time.Millisecond,
+					ConflictPercent: 20,
+				},
+			},
+		},
+		Storage: Storage{
+			Mode: "row",
+		},
+		Retry: Retry{
+			MaxAttempts: 5,
+			Strategy:    string(service.StrategyOptimistic),
+			BaseBackoff: 10 * time.Millisecond,
+		},
+		Server: Server{
+			Addr:            ":8080",
+			ShutdownTimeout: 30 * time.Second,
+			AsyncQueue:      1000,
+
+			WebhookTolerance: 5 * time.Minute,
+		},
+		Metrics: Metrics{
+			Backend:      "none",
+			Prefix:       "optlock",
+			HotKeyWindow: time.Minute,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "text",
+		},
+		Scheduler: Scheduler{
+			Interval:   time.Minute,
+			MaxCatchUp: 100,
+		},
+	}
+}
+
+// setting is one value that can be set from the environment and a flag
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	field func(*Config) any // pointer to the field
+}
+
+var settings = []setting{
+	{"profile", "PROFILE", "production, staging or test; test serves the testing endpoints", func(c *Config) any { return &c.Profile }},
+	{"db-driver", "DB_DRIVER", "database driver: postgres, mysql or sqlite", func(c *Config) any { return &c.Database.Driver }},
+	{"db-host", "DB_HOST", "database host", func(c *Config) any { return &c.Database.Host }},
+	{"db-port", "DB_PORT", "database port", func(c *Config) any { return &c.Database.Port }},
+	{"db-user", "DB_USER", "database user", func(c *Config) any { return &c.Database.User }},
+	{"db-password", "DB_PASSWORD", "database password", func(c *Config) any { return &c.Database.Password }},
+	{"db-name", "DB_NAME", "database name, or the file for sqlite", func(c *Config) any { return &c.Database.Name }},
+	{"db-sslmode", "DB_SSLMODE", "Postgres sslmode", func(c *Config) any { return &c.Database.SSLMode }},
+	{"db-hosts", "DB_HOSTS", "comma-separated failover endpoints", func(c *Config) any { return &c.Database.Hosts }},
+	{"db-failover-resolve-interval", "DB_FAILOVER_RESOLVE_INTERVAL", "how often to retry the first failover endpoint", func(c *Config) any { return &c.Database.FailoverResolveInterval }},
+	{"db-replicas", "DB_REPLICAS", "comma-separated read replica endpoints", func(c *Config) any { return &c.Database.Replicas }},
+	{"db-replica-max-lag", "DB_REPLICA_MAX_LAG", "replica lag bounded reads accept", func(c *Config) any { return &c.Database.ReplicaMaxLag }},
+	{"db-migrate", "DB_MIGRATE", "schema migrations at start: apply, check or off", func(c *Config) any { return &c.Database.Migrate }},
+	{"db-secrets-provider", "DB_SECRETS_PROVIDER", "where the database credentials come from: env, file, vault or aws", func(c *Config) any { return &c.Database.Secrets.Provider }},
+	{"db-secrets-user-file", "DB_SECRETS_USER_FILE", "file holding the database user (file provider)", func(c *Config) any { return &c.Database.Secrets.UserFile }},
+	{"db-secrets-password-file", "DB_SECRETS_PASSWORD_FILE", "file holding the database password (file provider)", func(c *Config) any { return &c.Database.Secrets.PasswordFile }},
+	{"db-secrets-vault-addr", "DB_SECRETS_VAULT_ADDR", "Vault address (defaults to VAULT_ADDR)", func(c *Config) any { return &c.Database.Secrets.VaultAddr }},
+	{"db-secrets-vault-path", "DB_SECRETS_VAULT_PATH", "Vault path of the credentials", func(c *Config) any { return &c.Database.Secrets.VaultPath }},
+	{"db-secrets-vault-token-file", "DB_SECRETS_VAULT_TOKEN_FILE", "file holding the Vault token (defaults to VAULT_TOKEN)", func(c *Config) any { return &c.Database.Secrets.VaultTokenFile }},
+	{"db-secrets-aws-region", "DB_SECRETS_AWS_REGION", "AWS Secrets Manager region (defaults to AWS_REGION)", func(c *Config) any { return &c.Database.Secrets.AWSRegion }},
+	{"db-secrets-aws-secret-id", "DB_SECRETS_AWS_SECRET_ID", "AWS Secrets Manager secret name or ARN", func(c *Config) any { return &c.Database.Secrets.AWSSecretID }},
+	{"db-secrets-refresh-interval", "DB_SECRETS_REFRESH_INTERVAL", "how often to look for rotated database credentials", func(c *Config) any { return &c.Database.Secrets.RefreshInterval }},
+	{"db-max-open-conns", "DB_MAX_OPEN_CONNS", "maximum open connections (0 is unlimited)", func(c *Config) any { return &c.Database.Pool.MaxOpenConns }},
+	{"db-max-idle-conns", "DB_MAX_IDLE_CONNS", "maximum idle connections", func(c *Config) any { return &c.Database.Pool.MaxIdleConns }},
+	{"db-conn-max-lifetime", "DB_CONN_MAX_LIFETIME", "maximum connection lifetime (0 is unlimited)", func(c *Config) any { return &c.Database.Pool.ConnMaxLifetime }},
+	{"db-conn-max-idle-time", "DB_CONN_MAX_IDLE_TIME", "maximum connection idle time (0 is unlimited)", func(c *Config) any { return &c.Database.Pool.ConnMaxIdleTime }},
+	{"db-pool-tune", "DB_POOL_TUNE", "adjust max open connections to wait times and conflicts", func(c *Config) any { return &c.Database.Pool.Tune.Enabled }},
+	{"db-pool-tune-min-open-conns", "DB_POOL_TUNE_MIN_OPEN_CONNS", "lower bound for tuned max open connections", func(c *Config) any { return &c.Database.Pool.Tune.MinOpenConns }},
+	{"db-pool-tune-max-open-conns", "DB_POOL_TUNE_MAX_OPEN_CONNS", "upper bound for tuned max open connections", func(c *Config) any { return &c.Database.Pool.Tune.MaxOpenConns }},
+	{"db-pool-tune-wait-threshold", "DB_POOL_TUNE_WAIT_THRESHOLD", "average connection wait that grows the pool", func(c *Config) any { return &c.Database.Pool.Tune.WaitThreshold }},
+	{"db-pool-tune-conflict-percent", "DB_POOL_TUNE_CONFLICT_PERCENT", "percentage of conflicting attempts that shrinks the pool", func(c *Config) any { return &c.Database.Pool.Tune.ConflictPercent }},
+	{"storage-mode", "STORAGE_MODE", "storage mode: row, or events to keep an event log per balance", func(c *Config) any { return &c.Storage.Mode }},
+	{"storage-spending-limits", "STORAGE_SPENDING_LIMITS", "enforce the periodic debit limits of balances", func(c *Config) any { return &c.Storage.SpendingLimits }},
+	{"storage-balance-uids", "STORAGE_BALANCE_UIDS", "UID generator of new balances: uuid, uuidv7 or ulid (empty for none)", func(c *Config) any { return &c.Storage.BalanceUIDs }},
+	{"storage-metadata-merge", "STORAGE_METADATA_MERGE", "merge metadata patches that touch keys other writers left alone", func(c *Config) any { return &c.Storage.MetadataMerge }},
+	{"storage-dedup-ttl", "STORAGE_DEDUP_TTL", "how long webhook references are kept in dedup_keys (0 disables the window)", func(c *Config) any { return &c.Storage.DedupTTL }},
+	{"storage-policies", "STORAGE_POLICIES", "comma-separated balance policies: minimum_balance, overdraft, require_reservation", func(c *Config) any { return &c.Storage.Policies }},
+	{"cache-size", "CACHE_SIZE", "balances kept in the in-memory read cache (0 disables it)", func(c *Config) any { return &c.Cache.Size }},
+	{"retry-max-attempts", "RETRY_MAX_ATTEMPTS", "optimistic attempts per update", func(c *Config) any { return &c.Retry.MaxAttempts }},
+	{"retry-base-backoff", "RETRY_BASE_BACKOFF", "backoff after the first conflict, doubled on each retry", func(c *Config) any { return &c.Retry.BaseBackoff }},
+	{"retry-strategy", "RETRY_STRATEGY", "update strategy: optimistic, serializable, advisory, for_update or atomic", func(c *Config) any { return &c.Retry.Strategy }},
+	{"retry-timeout", "RETRY_TIMEOUT", "time budget for retrying an update (0 disables)", func(c *Config) any { return &c.Retry.Timeout }},
+	{"retry-statement-timeout", "RETRY_STATEMENT_TIMEOUT", "time limit of each attempt of an update, retried past it (0 disables)", func(c *Config) any { return &c.Retry.StatementTimeout }},
+	{"retry-adaptive", "RETRY_ADAPTIVE", "scale the backoff by each balance's recent conflict rate", func(c *Config) any { return &c.Retry.Adaptive }},
+	{"retry-pessimistic-after", "RETRY_PESSIMISTIC_AFTER", "conflicts before falling back to a row lock (0 disables)", func(c *Config) any { return &c.Retry.PessimisticAfter }},
+	{"retry-dead-letter", "RETRY_DEAD_LETTER", "queue updates that exhaust their retries for replay", func(c *Config) any { return &c.Retry.DeadLetter }},
+	{"server-addr", "SERVER_ADDR", "HTTP listen address", func(c *Config) any { return &c.Server.Addr }},
+	{"server-shutdown-timeout", "SERVER_SHUTDOWN_TIMEOUT", "how long shutdown waits for in-flight work", func(c *Config) any { return &c.Server.ShutdownTimeout }},
+	{"server-admin", "SERVER_ADMIN", "serve the /admin endpoints", func(c *Config) any { return &c.Server.Admin }},
+	{"server-tenant-header", "SERVER_TENANT_HEADER", "request header naming the tenant of balance requests", func(c *Config) any { return &c.Server.TenantHeader }},
+	{"server-async-workers", "SERVER_ASYNC_WORKERS", "workers applying updates queued by POST /balances/{id}/updates (0 disables it)", func(c *Config) any { return &c.Server.AsyncWorkers }},
+	{"server-async-queue", "SERVER_ASYNC_QUEUE", "queued async updates before new ones are refused", func(c *Config) any { return &c.Server.AsyncQueue }},
+	{"server-webhook-secret", "SERVER_WEBHOOK_SECRET", "secret of the payment webhooks; serves POST /webhooks/payments when set", func(c *Config) any { return &c.Server.WebhookSecret }},
+	{"server-webhook-tolerance", "SERVER_WEBHOOK_TOLERANCE", "clock difference accepted on payment webhook timestamps", func(c *Config) any { return &c.Server.WebhookTolerance }},
+	{"metrics-backend", "METRICS_BACKEND", "metrics backend: none, prometheus or statsd", func(c *Config) any { return &c.Metrics.Backend }},
+	{"metrics-statsd-addr", "METRICS_STATSD_ADDR", "statsd address (host:port)", func(c *Config) any { return &c.Metrics.StatsdAddr }},
+	{"metrics-prefix", "METRICS_PREFIX", "statsd metric name prefix", func(c *Config) any { return &c.Metrics.Prefix }},
+	{"metrics-hot-key-window", "METRICS_HOT_KEY_WINDOW", "window to count conflicts per balance over for hot keys; 0 disables", func(c *Config) any { return &c.Metrics.HotKeyWindow }},
+	{"audit-conflicts", "AUDIT_CONFLICTS", "record rejected writes in update_conflicts", func(c *Config) any { return &c.Audit.Conflicts }},
+	{"log-level", "LOG_LEVEL", "log level: debug, info, warn or error", func(c *Config) any { return &c.Log.Level }},
+	{"log-format", "LOG_FORMAT", "log format: text or json", func(c *Config) any { return &c.Log.Format }},
+	{"runtime-max-procs", "RUNTIME_MAX_PROCS", "GOMAXPROCS (0 follows the container CPU quota)", func(c *Config) any { return &c.Runtime.MaxProcs }},
+	{"runtime-serialize", "RUNTIME_SERIALIZE", "queue updates to the same balance behind each other in this process", func(c *Config) any { return &c.Runtime.Serialize }},
+	{"runtime-serializer-stripes", "RUNTIME_SERIALIZER_STRIPES", "serializer stripes (0 is 64 per GOMAXPROCS)", func(c *Config) any { return &c.Runtime.SerializerStripes }},
+	{"scheduler-enabled", "SCHEDULER_ENABLED", "apply the recurring adjustments of the schedules table", func(c *Config) any { return &c.Scheduler.Enabled }},
+	{"scheduler-interval", "SCHEDULER_INTERVAL", "how often to look for due schedules", func(c *Config) any { return &c.Scheduler.Interval }},
+	{"scheduler-max-catch-up", "SCHEDULER_MAX_CATCH_UP", "missed runs applied per schedule and pass", func(c *Config) any { return &c.Scheduler.MaxCatchUp }},
+	{"testing-conflict-balance-ids", "TESTING_CONFLICT_BALANCE_IDS", "comma-separated balances whose writes get a simulated conflict (test profile)", func(c *Config) any { return &c.Testing.ConflictBalanceIDs }},
+	{"testing-conflict-every-nth", "TESTING_CONFLICT_EVERY_NTH", "simulate a conflict on every Nth balance write (test profile)", func(c *Config) any { return &c.Testing.ConflictEveryNth }},
+}
+
+// Load builds the configuration for a program called name from its
+// arguments. The file named by -config (or CONFIG_FILE) is read first, then
+// the environment variables and finally the flags given in args override it;
+// -h lists the flags. The result is validated.
+func Load(name string, args []string) (Config, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML (.yaml, .yml) or TOML (.toml) config file")
+	var flagged []func(*Config) error
+	for _, s := range settings {
+		fs.Var(flagValue{s, &flagged}, s.flag, s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg := Default()
+	if *file != "" {
+		if err := loadFile(*file, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok && value != "" {
+			if err := set(s.field(&cfg), value); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	for _, apply := range flagged {
+		apply(&cfg)
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// flagValue records a flag so it can be applied after the file and environment
+type flagValue struct {
+	setting setting
+	flagged *[]func(*Config) error
+}
+
+func (v flagValue) String() string { return "" }
+
+func (v flagValue) Set(value string) error {
+	// Parse once up front so a bad value is reported as a flag error
+	if err := set(v.setting.field(&Config{}), value); err != nil {
+		return err
+	}
+	*v.flagged = append(*v.flagged, func(c *Config) error { return set(v.setting.field(c), value) })
+	return nil
+}
+
+func (v flagValue) IsBoolFlag() bool {
+	_, ok := v.setting.field(&Config{}).(*bool)
+	return ok
+}
+
+// loadFile decodes path into cfg based on its extension
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("%s: unsupported config format %q", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// set parses value into the field ptr points at
+func set(ptr any, value string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = value
+	case *[]string:
+		*p = nil
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				*p = append(*p, part)
+			}
+		}
+	case *[]uint:
+		*p = nil
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			n, err := strconv.ParseUint(part, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid ID %q", part)
+			}
+			*p = append(*p, uint(n))
+		}
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*p = b
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*p = d
+	default:
+		return fmt.Errorf("unsupported setting type %T", ptr)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Profile == "production" || c.Profile == "staging" || c.Profile == "test", "profile: unknown profile %q", c.Profile)
+	check(c.Profile == "test" || (len(c.Testing.ConflictBalanceIDs) == 0 && c.Testing.ConflictEveryNth == 0),
+		"testing settings require the test profile")
+	check(c.Testing.ConflictEveryNth >= 0, "testing.conflict_every_nth must not be negative")
+
+	db := c.Database
+	check(db.Driver == "postgres" || db.Driver == "mysql" || db.Driver == "sqlite",
+		"database.driver: unknown driver %q", db.Driver)
+	check(db.Name != "", "database.name is required")
+	check(db.Pool.MaxOpenConns >= 0, "database.pool.max_open_conns must not be negative")
+	check(db.Pool.MaxIdleConns >= 0, "database.pool.max_idle_conns must not be negative")
+	check(db.Pool.MaxOpenConns == 0 || db.Pool.MaxIdleConns <= db.Pool.MaxOpenConns,
+		"database.pool.max_idle_conns (%d) exceeds max_open_conns (%d)", db.Pool.MaxIdleConns, db.Pool.MaxOpenConns)
+	check(db.Pool.ConnMaxLifetime >= 0 && db.Pool.ConnMaxIdleTime >= 0, "database.pool durations must not be negative")
+	if tune := db.Pool.Tune; tune.Enabled {
+		check(tune.MinOpenConns >= 1 && tune.MaxOpenConns >= tune.MinOpenConns,
+			"database.pool.tune needs 1 <= min_open_conns (%d) <= max_open_conns (%d)", tune.MinOpenConns, tune.MaxOpenConns)
+		check(tune.WaitThreshold > 0, "database.pool.tune.wait_threshold must be positive")
+		check(tune.ConflictPercent >= 1 && tune.ConflictPercent <= 100, "database.pool.tune.conflict_percent must be between 1 and 100")
+	}
+	check(len(db.Hosts) == 0 || db.FailoverResolveInterval > 0,
+		"database.failover_resolve_interval must be positive when hosts are set")
+
+	check(len(db.Replicas) == 0 || db.ReplicaMaxLag > 0,
+		"database.replica_max_lag must be positive when replicas are set")
+	check(db.Migrate == "apply" || db.Migrate == "check" || db.Migrate == "off",
+		"database.migrate: want apply, check or off, got %q", db.Migrate)
+	switch sec := db.Secrets; sec.Provider {
+	case "", "env":
+	case "file":
+		check(sec.UserFile != "" && sec.PasswordFile != "", "database.secrets: the file provider needs user_file and password_file")
+	case "vault":
+		check(sec.VaultPath != "", "database.secrets.vault_path is required for the vault provider")
+	case "aws":
+		check(sec.AWSSecretID != "", "database.secrets.aws_secret_id is required for the aws provider")
+	default:
+		check(false, "database.secrets.provider: unknown provider %q", sec.Provider)
+	}
+	check(db.Secrets.Provider == "" || db.Secrets.RefreshInterval > 0,
+		"database.secrets.refresh_interval must be positive when a provider is set")
+
+	check(c.Storage.Mode == "row" || c.Storage.Mode == "events", "storage.mode: unknown mode %q", c.Storage.Mode)
+	check(c.Storage.DedupTTL >= 0, "storage.dedup_ttl must not be negative")
+	if c.Storage.BalanceUIDs != "" {
+		_, ok := ids.ByName(c.Storage.BalanceUIDs)
+		check(ok, "storage.balance_uids: unknown generator %q, want one of %s", c.Storage.BalanceUIDs, strings.Join(ids.Names(), ", "))
+	}
+	for _, name := range c.Storage.Policies {
+		_, ok := service.PolicyByName(name)
+		check(ok, "storage.policies: unknown policy %q, want one of %s", name, strings.Join(service.PolicyNames(), ", "))
+	}
+
+	check(c.Cache.Size >= 0, "cache.size must not be negative")
+
+	check(c.Retry.MaxAttempts >= 1, "retry.max_attempts must be at least 1")
+	check(c.Retry.BaseBackoff > 0, "retry.base_backoff must be positive")
+	_, err := service.ParseStrategy(c.Retry.Strategy)
+	check(err == nil, "retry.strategy: %v", err)
+	check(c.Retry.Timeout >= 0, "retry.timeout must not be negative")
+	check(c.Retry.StatementTimeout >= 0, "retry.statement_timeout must not be negative")
+	check(c.Retry.PessimisticAfter >= 0, "retry.pessimistic_after must not be negative")
+
+	check(c.Server.Addr != "", "server.addr is required")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Server.AsyncWorkers >= 0, "server.async_workers must not be negative")
+	check(c.Server.AsyncQueue >= 0, "server.async_queue must not be negative")
+	check(c.Server.WebhookTolerance > 0, "server.webhook_tolerance must be positive")
+
+	check(c.Runtime.MaxProcs >= 0, "runtime.max_procs must not be negative")
+	check(c.Runtime.SerializerStripes >= 0, "runtime.serializer_stripes must not be negative")
+
+	check(!c.Scheduler.Enabled || c.Scheduler.Interval > 0, "scheduler.interval must be positive when the scheduler is enabled")
+	check(c.Scheduler.MaxCatchUp >= 1, "scheduler.max_catch_up must be at least 1")
+
+	switch c.Metrics.Backend {
+	case "none", "prometheus":
+	case "statsd":
+		check(c.Metrics.StatsdAddr != "", "metrics.statsd_addr is required for the statsd backend")
+	default:
+		check(false, "metrics.backend: unknown backend %q", c.Metrics.Backend)
+	}
+	check(c.Metrics.HotKeyWindow >= 0, "metrics.hot_key_window must not be negative")
+	if _, err := c.NewLogger(io.Discard); err != nil {
+		check(false, "log: %v", err)
+	}
+	return errors.Join(errs...)
+}
+
+// DebugHeaders reports whether responses carry debug headers such as
+// X-OptLock-Attempts: in the staging and test profiles, or at the debug log
+// level
+func (c Config) DebugHeaders() bool {
+	return c.Profile != "production" || strings.EqualFold(c.Log.Level, "debug")
+}
+
+// DatabaseConfig returns the connection settings for database.Open
+func (c Config) DatabaseConfig() database.Config {
+	db := c.Database
+	return database.Config{
+		Driver:                  db.Driver,
+		Host:                    db.Host,
+		Port:                    db.Port,
+		User:                    db.User,
+		Password:                db.Password,
+		Name:                    db.Name,
+		SSLMode:                 db.SSLMode,
+		Hosts:                   db.Hosts,
+		FailoverResolveInterval: db.FailoverResolveInterval,
+	}
+}
+
+// SecretProvider returns the configured source of database credentials, or
+// nil when the static user and password are used
+func (c Config) SecretProvider() secrets.Provider {
+	sec := c.Database.Secrets
+	switch sec.Provider {
+	case "env":
+		return secrets.Env{}
+	case "file":
+		return secrets.File{UserPath: sec.UserFile, PasswordPath: sec.PasswordFile}
+	case "vault":
+		return secrets.Vault{Addr: sec.VaultAddr, Path: sec.VaultPath, TokenFile: sec.VaultTokenFile}
+	case "aws":
+		return secrets.AWSSecretsManager{Region: sec.AWSRegion, SecretID: sec.AWSSecretID}
+	}
+	return nil
+}
+
+// NewCredentials fetches the database credentials from the configured
+// provider; it returns nil when there is none
+func (c Config) NewCredentials(ctx context.Context) (*database.Credentials, error) {
+	provider := c.SecretProvider()
+	if provider == nil {
+		return nil, nil
+	}
+	return database.NewCredentials(ctx, provider)
+}
+
+// OpenDB connects to the database and applies the pool limits. With creds,
+// which may be nil, connections authenticate with the current credentials
+// and a rotation closes the idle connections, so the pool is rebuilt with
+// the new ones while connections in use finish their work.
+func (c Config) OpenDB(gormCfg *gorm.Config, creds *database.Credentials) (*gorm.DB, error) {
+	dbCfg := c.DatabaseConfig()
+	dbCfg.Credentials = creds
+	db, err := database.Open(dbCfg, gormCfg)
+	if err != nil {
+		return nil, err
+	}
+	if generate, ok := ids.ByName(c.Storage.BalanceUIDs); ok {
+		if err := db.Use(ids.Plugin{Generate: generate}); err != nil {
+			return nil, err
+		}
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	pool := c.PoolLimits()
+	pool.Apply(sqlDB)
+	if creds != nil {
+		creds.OnRotate(func() {
+			sqlDB.SetMaxIdleConns(0)
+			sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+		})
+	}
+	return db, nil
+}
+
+// OpenReplicas connects to every configured read replica with the primary's
+// settings, credentials and pool limits
+func (c Config) OpenReplicas(gormCfg *gorm.Config, creds *database.Credentials) ([]*gorm.DB, error) {
+	var replicas []*gorm.DB
+	for _, endpoint := range c.Database.Replicas {
+		rc := c
+		rc.Database.Hosts = nil
+		rc.Database.Host = endpoint
+		if host, port, err := net.SplitHostPort(endpoint); err == nil {
+			rc.Database.Host, rc.Database.Port = host, port
+		}
+		db, err := rc.OpenDB(gormCfg, creds)
+		if err != nil {
+			for _, opened := range replicas {
+				if sqlDB, err := opened.DB(); err == nil {
+					sqlDB.Close()
+				}
+			}
+			return nil, fmt.Errorf("replica %s: %w", endpoint, err)
+		}
+		replicas = append(replicas, db)
+	}
+	return replicas, nil
+}
+
+// PoolLimits returns the pool settings OpenDB applies
+func (c Config) PoolLimits() Pool {
+	pool := c.Database.Pool
+	// Failover connections must still be recycled every resolve interval
+	if len(c.Database.Hosts) > 0 && (pool.ConnMaxLifetime == 0 || c.Database.FailoverResolveInterval < pool.ConnMaxLifetime) {
+		pool.ConnMaxLifetime = c.Database.FailoverResolveInterval
+	}
+	return pool
+}
+
+// Apply sets the pool limits on db
+func (p Pool) Apply(db *sql.DB) {
+	db.SetMaxOpenConns(p.MaxOpenConns)
+	db.SetMaxIdleConns(p.MaxIdleConns)
+	db.SetConnMaxLifetime(p.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(p.ConnMaxIdleTime)
+}
+
+// NewMetrics returns the configured metrics backend. Prometheus collectors
+// are registered on reg.
+func (c Config) NewMetrics(reg prometheus.Registerer) (metrics.Metrics, error) {
+	switch c.Metrics.Backend {
+	case "prometheus":
+		return metrics.NewPrometheus(reg, nil), nil
+	case "statsd":
+		return metrics.NewStatsd(c.Metrics.StatsdAddr, c.Metrics.Prefix)
+	}
+	return metrics.Nop{}, nil
+}
+
+// NewLogger returns the configured logger writing to w
+func (c Config) NewLogger(w io.Writer) (*slog.Logger, error) {
+	return logging.New(w, c.Log.Format, c.Log.Level)
+}
+
+// ApplyRuntime sets GOMAXPROCS to runtime.max_procs or, when that is 0, to
+// the container CPU quota unless the GOMAXPROCS environment variable is set.
+// It returns the GOMAXPROCS in effect and the quota in CPUs, 0 when there is
+// none.
+func (c Config) ApplyRuntime() (procs int, quota float64) {
+	quota, limited := cpuquota.Detect()
+	switch {
+	case c.Runtime.MaxProcs > 0:
+		runtime.GOMAXPROCS(c.Runtime.MaxProcs)
+	case limited && os.Getenv("GOMAXPROCS") == "":
+		runtime.GOMAXPROCS(cpuquota.Procs(quota))
+	}
+	return runtime.GOMAXPROCS(0), quota
+}
+
+// NewSerializer returns the hot-row serializer, or nil unless
+// runtime.serialize is set. Call it after ApplyRuntime, since the default
+// stripe count follows GOMAXPROCS.
+func (c Config) NewSerializer() *service.KeyedSerializer {
+	if !c.Runtime.Serialize {
+		return nil
+	}
+	stripes := c.Runtime.SerializerStripes
+	if stripes == 0 {
+		stripes = 64 * runtime.GOMAXPROCS(0)
+	}
+	return service.NewKeyedSerializer(stripes)
+}
+
+// NewContentionMonitor returns the hot-key monitor reporting to m, or nil
+// when metrics.hot_key_window is 0
+func (c Config) NewContentionMonitor(m metrics.Metrics) *service.ContentionMonitor {
+	if c.Metrics.HotKeyWindow == 0 {
+		return nil
+	}
+	return service.NewContentionMonitor(c.Metrics.HotKeyWindow, m)
+}
+
+// UpdaterOptions returns the service options for the retry policy, audit,
+// storage and cache settings; add metrics and other options to them as
+// needed. Each call creates a new cache and adaptive backoff.
+func (c Config) UpdaterOptions(db *gorm.DB) []service.Option {
+	opts := []service.Option{
+		service.WithMaxAttempts(c.Retry.MaxAttempts),
+		service.WithBaseBackoff(c.Retry.BaseBackoff),
+	}
+	if c.Retry.Timeout > 0 {
+		opts = append(opts, service.WithTimeout(c.Retry.Timeout))
+	}
+	if strategy, _ := service.ParseStrategy(c.Retry.Strategy); strategy != service.StrategyOptimistic {
+		opts = append(opts, service.WithStrategy(strategy))
+	}
+	if c.Retry.StatementTimeout > 0 {
+		opts = append(opts, service.WithStatementTimeout(c.Retry.StatementTimeout))
+	}
+	if c.Retry.Adaptive {
+		opts = append(opts, service.WithAdaptiveBackoff(service.NewAdaptiveBackoff(service.AdaptiveConfig{})))
+	}
+	if c.Retry.PessimisticAfter > 0 {
+		opts = append(opts, service.WithPessimisticFallback(c.Retry.PessimisticAfter))
+	}
+	if c.Retry.DeadLetter {
+		opts = append(opts, service.WithDeadLetter(service.TableDeadLetterSink{DB: db}, nil))
+	}
+	if c.Audit.Conflicts {
+		opts = append(opts, service.WithConflictAudit(service.TableConflictSink{DB: db}, nil))
+	}
+	if c.Storage.Mode == "events" {
+		opts = append(opts, service.WithEventSourcing())
+	}
+	if c.Storage.SpendingLimits {
+		opts = append(opts, service.WithSpendingLimits())
+	}
+	if len(c.Storage.Policies) > 0 {
+		policies := make([]service.Policy, len(c.Storage.Policies))
+		for i, name := range c.Storage.Policies {
+			policies[i], _ = service.PolicyByName(name)
+		}
+		opts = append(opts, service.WithPolicies(policies...))
+	}
+	if c.Storage.MetadataMerge {
+		opts = append(opts, service.WithMetadataMerge())
+	}
+	if c.Cache.Size > 0 {
+		opts = append(opts, service.WithCache(cache.New(c.Cache.Size)))
+	}
+	return opts
+}
@@ -0,0 +1,111 @@
+// Package cpuquota detects the CPU quota a container grants the process, so
+// GOMAXPROCS and the worker pools can be sized to it instead of to the CPUs
+// of the host.
+package cpuquota
+
+import (
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Detect returns the CPU quota of this process's cgroup in CPUs, e.g. 1.5.
+// ok is false when there is no quota or it cannot be read.
+func Detect() (cpus float64, ok bool) {
+	self, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return 0, false
+	}
+	return Read(os.DirFS("/sys/fs/cgroup"), string(self))
+}
+
+// Read returns the CPU quota from the cgroup filesystem fsys (mounted at
+// /sys/fs/cgroup) for the cgroups listed in selfCgroup, the contents of
+// /proc/self/cgroup. Both cgroup v2 (cpu.max) and v1 (cpu.cfs_quota_us)
+// are understood.
+func Read(fsys fs.FS, selfCgroup string) (cpus float64, ok bool) {
+	for _, line := range strings.Split(selfCgroup, "\n") {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			if cpus, ok := readV2(fsys, parts[2]); ok {
+				return cpus, true
+			}
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			if controller == "cpu" {
+				if cpus, ok := readV1(fsys, parts[1], parts[2]); ok {
+					return cpus, true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+// readV2 parses "<quota> <period>" from cpu.max; the quota is "max" when unlimited
+func readV2(fsys fs.FS, cgroup string) (float64, bool) {
+	data, ok := readNearest(fsys, ".", cgroup, "cpu.max")
+	if !ok {
+		return 0, false
+	}
+	fields := strings.Fields(data)
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0, false
+	}
+	return ratio(fields[0], fields[1])
+}
+
+// readV1 divides cpu.cfs_quota_us by cpu.cfs_period_us; the quota is -1 when unlimited
+func readV1(fsys fs.FS, controllers, cgroup string) (float64, bool) {
+	quota, ok := readNearest(fsys, controllers, cgroup, "cpu.cfs_quota_us")
+	if !ok {
+		quota, ok = readNearest(fsys, "cpu", cgroup, "cpu.cfs_quota_us")
+		controllers = "cpu"
+	}
+	if !ok {
+		return 0, false
+	}
+	period, ok := readNearest(fsys, controllers, cgroup, "cpu.cfs_period_us")
+	if !ok {
+		return 0, false
+	}
+	return ratio(strings.TrimSpace(quota), strings.TrimSpace(period))
+}
+
+// readNearest reads file in the cgroup's directory under mount, or at the
+// mount root when the cgroup path is not visible, as inside a cgroup namespace
+func readNearest(fsys fs.FS, mount, cgroup, file string) (string, bool) {
+	for _, dir := range []string{path.Join(mount, strings.TrimPrefix(cgroup, "/")), mount} {
+		if data, err := fs.ReadFile(fsys, path.Join(dir, file)); err == nil {
+			return string(data), true
+		}
+	}
+	return "", false
+}
+
+func ratio(quota, period string) (float64, bool) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return q / p, true
+}
+
+// Procs returns the GOMAXPROCS for a quota of cpus: rounded down so the
+// process is not throttled, but at least 1 and at most the CPUs of the host
+func Procs(cpus float64) int {
+	return max(1, min(int(math.Floor(cpus)), runtime.NumCPU()))
+}
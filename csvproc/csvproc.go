@@ -0,0 +1,218 @@
+package csvproc
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// Row is a single (balance_id, delta, reference) line of a command file
+type Row struct {
+	Line      int // 1-based line number in the source file
+	BalanceID uint
+	Delta     int64
+	Reference string
+}
+
+// Config controls how a command file is processed
+type Config struct {
+	Workers      int    // Number of concurrent workers (default 4)
+	FailureFile  string // CSV report of rows that failed, empty to skip
+	ProgressFile string // Journal of completed references used to resume, empty to disable
+}
+
+// Summary reports the outcome of a run
+type Summary struct {
+	Total   int // Rows read from the file
+	Applied int // Rows applied to a balance
+	Skipped int // Rows already applied (progress journal or idempotency reference)
+	Failed  int // Rows that returned an error
+}
+
+// ReadRows parses a command file. A header row starting with "balance_id" is skipped.
+func ReadRows(r io.Reader) ([]Row, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	var rows []Row
+	line := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line++
+
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "balance_id") {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSpace(record[0]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid balance_id %q", line, record[0])
+		}
+		delta, err := strconv.ParseInt(strings.TrimSpace(record[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid delta %q", line, record[1])
+		}
+		reference := strings.TrimSpace(record[2])
+		if reference == "" {
+			return nil, fmt.Errorf("line %d: reference is required", line)
+		}
+
+		rows = append(rows, Row{Line: line, BalanceID: uint(id), Delta: delta, Reference: reference})
+	}
+
+	return rows, nil
+}
+
+// Process applies every row through service.ApplyAdjustment. Each reference is
+// applied at most once, completed references are appended to the progress
+// journal so an interrupted run can be resumed, and failed rows are written to
+// the failure report.
+func Process(db *gorm.DB, rows []Row, cfg Config) (Summary, error) {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+
+	done, err := loadProgress(cfg.ProgressFile)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	var progress *os.File
+	if cfg.ProgressFile != "" {
+		progress, err = os.OpenFile(cfg.ProgressFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return Summary{}, err
+		}
+		defer progress.Close()
+	}
+
+	var failures *csv.Writer
+	if cfg.FailureFile != "" {
+		f, err := os.Create(cfg.FailureFile)
+		if err != nil {
+			return Summary{}, err
+		}
+		defer f.Close()
+		failures = csv.NewWriter(f)
+		failures.Write([]string{"line", "balance_id", "delta", "reference", "error"})
+		defer failures.Flush()
+	}
+
+	summary := Summary{Total: len(rows)}
+	var mu sync.Mutex
+
+	// Duplicate references inside one file are only applied once
+	seen := make(map[string]bool, len(rows))
+	jobs := make(chan Row)
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for row := range jobs {
+				applied, err := service.ApplyAdjustment(db, row.Reference, row.BalanceID, row.Delta)
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					summary.Failed++
+					if failures != nil {
+						failures.Write([]string{
+							strconv.Itoa(row.Line),
+							strconv.FormatUint(uint64(row.BalanceID), 10),
+							strconv.FormatInt(row.Delta, 10),
+							row.Reference,
+							err.Error(),
+						})
+					}
+				case applied:
+					summary.Applied++
+				default:
+					summary.Skipped++
+				}
+				if err == nil && progress != nil {
+					fmt.Fprintln(progress, row.Reference)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, row := range rows {
+		if done[row.Reference] || seen[row.Reference] {
+			summary.Skipped++
+			continue
+		}
+		seen[row.Reference] = true
+		jobs <- row
+	}
+	close(jobs)
+	wg.Wait()
+
+	if failures != nil {
+		failures.Flush()
+		if err := failures.Error(); err != nil {
+			return summary, err
+		}
+	}
+
+	return summary, nil
+}
+
+// ProcessFile reads the command file at path and processes it
+func ProcessFile(db *gorm.DB, path string, cfg Config) (Summary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Summary{}, err
+	}
+	defer f.Close()
+
+	rows, err := ReadRows(f)
+	if err != nil {
+		return Summary{}, err
+	}
+	return Process(db, rows, cfg)
+}
+
+// loadProgress reads the references already completed by a previous run
+func loadProgress(path string) (map[string]bool, error) {
+	done := make(map[string]bool)
+	if path == "" {
+		return done, nil
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if ref := strings.TrimSpace(scanner.Text()); ref != "" {
+			done[ref] = true
+		}
+	}
+	return done, scanner.Err()
+}
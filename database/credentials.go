@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/secrets"
+)
+
+// Credentials holds the user and password new connections authenticate with,
+// fetched from a secrets.Provider. Set Config.Credentials to use it: a
+// rotated secret then reaches new connections without reopening the
+// database, and existing connections keep their session.
+type Credentials struct {
+	provider secrets.Provider
+
+	mu            sync.RWMutex
+	current       secrets.Credentials
+	onRotate      []func()
+	failedRefresh time.Time // when a refused connection last asked the provider
+}
+
+// failureRefreshInterval is the least time between two refreshes caused by
+// refused connections, so a database that is down does not turn every
+// connection attempt into a call to the provider
+const failureRefreshInterval = 10 * time.Second
+
+// NewCredentials fetches the initial credentials from p
+func NewCredentials(ctx context.Context, p secrets.Provider) (*Credentials, error) {
+	c := &Credentials{provider: p}
+	if _, err := c.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Current returns the credentials new connections use
+func (c *Credentials) Current() secrets.Credentials {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.current
+}
+
+// OnRotate registers fn to run after the credentials change, e.g. to close
+// the idle connections so the pool is rebuilt with the new credentials
+func (c *Credentials) OnRotate(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onRotate = append(c.onRotate, fn)
+}
+
+// Refresh asks the provider again and reports whether the credentials changed
+func (c *Credentials) Refresh(ctx context.Context) (rotated bool, err error) {
+	creds, err := c.provider.Credentials(ctx)
+	if err != nil {
+		return false, fmt.Errorf("fetch database credentials: %w", err)
+	}
+
+	c.mu.Lock()
+	rotated = c.current != (secrets.Credentials{}) && c.current != creds
+	c.current = creds
+	callbacks := c.onRotate
+	c.mu.Unlock()
+
+	if rotated {
+		for _, fn := range callbacks {
+			fn()
+		}
+	}
+	return rotated, nil
+}
+
+// refreshAfterFailure is Refresh for a refused connection. It does nothing if
+// another refused connection refreshed within failureRefreshInterval.
+func (c *Credentials) refreshAfterFailure(ctx context.Context) (rotated bool, err error) {
+	c.mu.Lock()
+	if time.Since(c.failedRefresh) < failureRefreshInterval {
+		c.mu.Unlock()
+		return false, nil
+	}
+	c.failedRefresh = time.Now()
+	c.mu.Unlock()
+	return c.Refresh(ctx)
+}
+
+// Run refreshes the credentials every interval until ctx is cancelled.
+// Errors are passed to onError, which may be nil; the last credentials stay
+// in use meanwhile.
+func (c *Credentials) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if _, err := c.Refresh(ctx); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// credentialConnector opens connections with the current credentials. When a
+// connection is refused it refreshes them once and retries if they rotated,
+// so a rotation is picked up before the next scheduled refresh. Such
+// refreshes are at most one per failureRefreshInterval.
+type credentialConnector struct {
+	creds *Credentials
+	build func(secrets.Credentials) (driver.Connector, error)
+
+	mu        sync.Mutex
+	built     secrets.Credentials
+	connector driver.Connector
+}
+
+// Connect implements driver.Connector
+func (c *credentialConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connect(ctx)
+	if err == nil {
+		return conn, nil
+	}
+	if rotated, refreshErr := c.creds.refreshAfterFailure(ctx); refreshErr != nil || !rotated {
+		return nil, err
+	}
+	return c.connect(ctx)
+}
+
+func (c *credentialConnector) connect(ctx context.Context) (driver.Conn, error) {
+	connector, err := c.current()
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+// current returns a connector for the current credentials, building a new one after a rotation
+func (c *credentialConnector) current() (driver.Connector, error) {
+	creds := c.creds.Current()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.connector == nil || creds != c.built {
+		connector, err := c.build(creds)
+		if err != nil {
+			return nil, err
+		}
+		c.connector, c.built = connector, creds
+	}
+	return c.connector, nil
+}
+
+// Driver implements driver.Connector
+func (c *credentialConnector) Driver() driver.Driver {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connector.Driver() // built by endpointConnector
+}
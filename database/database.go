@@ -0,0 +1,212 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/secrets"
+	"github.com/ghozilaaa/optimistic-lock/tenant"
+)
+
+// Config holds the database connection settings
+type Config struct {
+	Driver   string // postgres (default), mysql or sqlite; non-Postgres drivers need a build tag
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Name     string // database name, or the file path for sqlite
+	SSLMode  string
+
+	// Hosts lists failover endpoints ("host" or "host:port") in priority order,
+	// e.g. a primary followed by standbys or several PgBouncers. When set it
+	// takes precedence over Host/Port.
+	Hosts []string
+	// FailoverResolveInterval is how often new connections retry the first
+	// endpoint after failing over, and the max lifetime of pooled connections.
+	FailoverResolveInterval time.Duration
+	// Credentials, when set, replaces User and Password with the ones from a
+	// secrets provider, following rotations
+	Credentials *Credentials
+}
+
+// dialect describes how to connect with one SQL dialect
+type dialect struct {
+	dsn       func(Config) string
+	dialector func(dsn string) gorm.Dialector
+	configure func(*sql.DB) // optional pool tuning applied after connecting
+
+	// connector and wrap enable multi-endpoint failover; nil if unsupported
+	connector func(dsn string) (driver.Connector, error)
+	wrap      func(*sql.DB) gorm.Dialector
+}
+
+var dialects = map[string]dialect{
+	"postgres": {
+		dsn: func(c Config) string {
+			return fmt.Sprintf("host=%s user=%s dbname=%s password=%s port=%s sslmode=%s",
+				c.Host, c.User, c.Name, c.Password, c.Port, c.SSLMode)
+		},
+		dialector: postgres.Open,
+		connector: func(dsn string) (driver.Connector, error) {
+			connConfig, err := pgx.ParseConfig(dsn)
+			if err != nil {
+				return nil, err
+			}
+			return stdlib.GetConnector(*connConfig), nil
+		},
+		wrap: func(db *sql.DB) gorm.Dialector {
+			return postgres.New(postgres.Config{Conn: db})
+		},
+	},
+}
+
+// registerDialect makes a dialect available to Open; called from build-tagged files
+func registerDialect(name string, d dialect) {
+	dialects[name] = d
+}
+
+// ConfigFromEnv builds a Config from DB_* environment variables
+func ConfigFromEnv() Config {
+	return Config{
+		Driver:   getEnv("DB_DRIVER", "postgres"),
+		Host:     getEnv("DB_HOST", "localhost"),
+		Port:     getEnv("DB_PORT", "5432"),
+		User:     getEnv("DB_USER", "postgres"),
+		Password: getEnv("DB_PASSWORD", "postgres"),
+		Name:     getEnv("DB_NAME", "optimistic_lock"),
+		SSLMode:  getEnv("DB_SSLMODE", "disable"),
+		Hosts:    splitList(os.Getenv("DB_HOSTS")),
+
+		FailoverResolveInterval: getDuration("DB_FAILOVER_RESOLVE_INTERVAL", 30*time.Second),
+	}
+}
+
+// DSN returns the connection string for the configured driver
+func (c Config) DSN() string {
+	d, ok := dialects[c.driverName()]
+	if !ok {
+		return ""
+	}
+	return d.dsn(c)
+}
+
+// Open connects to the database described by cfg
+func Open(cfg Config, gormCfg *gorm.Config) (*gorm.DB, error) {
+	d, ok := dialects[cfg.driverName()]
+	if !ok {
+		return nil, fmt.Errorf("database driver %q is not compiled in (build with -tags %s)", cfg.Driver, cfg.Driver)
+	}
+	if gormCfg == nil {
+		gormCfg = &gorm.Config{}
+	}
+
+	dialector := d.dialector(d.dsn(cfg))
+	if len(cfg.Hosts) > 0 {
+		if d.connector == nil {
+			return nil, fmt.Errorf("database driver %q does not support multiple hosts", cfg.driverName())
+		}
+
+		connectors := make([]driver.Connector, 0, len(cfg.Hosts))
+		for _, endpoint := range endpointConfigs(cfg) {
+			c, err := d.endpointConnector(endpoint, cfg.Credentials)
+			if err != nil {
+				return nil, fmt.Errorf("endpoint %s: %w", endpoint.Host, err)
+			}
+			connectors = append(connectors, c)
+		}
+
+		sqlDB := sql.OpenDB(newFailoverConnector(connectors, cfg.FailoverResolveInterval))
+		// Recycle connections so ones opened against a standby move back to the primary
+		sqlDB.SetConnMaxLifetime(cfg.FailoverResolveInterval)
+		dialector = d.wrap(sqlDB)
+	} else if cfg.Credentials != nil {
+		if d.connector == nil {
+			return nil, fmt.Errorf("database driver %q does not support credential providers", cfg.driverName())
+		}
+		c, err := d.endpointConnector(cfg, cfg.Credentials)
+		if err != nil {
+			return nil, err
+		}
+		dialector = d.wrap(sql.OpenDB(c))
+	}
+
+	db, err := gorm.Open(dialector, gormCfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Use(tenant.Plugin{}); err != nil {
+		return nil, err
+	}
+
+	if d.configure != nil {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		d.configure(sqlDB)
+	}
+
+	return db, nil
+}
+
+// endpointConnector returns a connector for endpoint, authenticating with
+// creds instead of the configured user and password when creds is set
+func (d dialect) endpointConnector(endpoint Config, creds *Credentials) (driver.Connector, error) {
+	if creds == nil {
+		return d.connector(d.dsn(endpoint))
+	}
+	c := &credentialConnector{creds: creds, build: func(current secrets.Credentials) (driver.Connector, error) {
+		endpoint.User, endpoint.Password = current.User, current.Password
+		return d.connector(d.dsn(endpoint))
+	}}
+	// Build once up front so a bad DSN fails Open
+	if _, err := c.current(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// driverName returns the configured driver, defaulting to postgres
+func (c Config) driverName() string {
+	if c.Driver == "" {
+		return "postgres"
+	}
+	return c.Driver
+}
+
+// splitList splits a comma-separated value, dropping empty entries
+func splitList(value string) []string {
+	var out []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// getDuration parses a duration environment variable or returns default value
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return d
+	}
+	return defaultValue
+}
+
+// getEnv gets environment variable or returns default value
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,35 @@
+//go:build mysql
+
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func init() {
+	registerDialect("mysql", dialect{
+		dsn: func(c Config) string {
+			// clientFoundRows makes RowsAffected count matched rows like Postgres does,
+			// instead of only rows whose values actually changed.
+			return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true&clientFoundRows=true",
+				c.User, c.Password, c.Host, c.Port, c.Name)
+		},
+		dialector: mysql.Open,
+		connector: func(dsn string) (driver.Connector, error) {
+			cfg, err := mysqldriver.ParseDSN(dsn)
+			if err != nil {
+				return nil, err
+			}
+			return mysqldriver.NewConnector(cfg)
+		},
+		wrap: func(db *sql.DB) gorm.Dialector {
+			return mysql.New(mysql.Config{Conn: db})
+		},
+	})
+}
@@ -0,0 +1,25 @@
+//go:build sqlite
+
+package database
+
+import (
+	"database/sql"
+
+	"gorm.io/driver/sqlite"
+)
+
+func init() {
+	registerDialect("sqlite", dialect{
+		dsn: func(c Config) string {
+			// SQLite locks the whole database for writes; wait for the lock instead of
+			// failing immediately with SQLITE_BUSY.
+			return "file:" + c.Name + "?_busy_timeout=5000&_journal_mode=WAL"
+		},
+		dialector: sqlite.Open,
+		configure: func(db *sql.DB) {
+			// Only one writer can hold the database lock at a time, so a single
+			// connection turns lock contention into queueing inside the pool.
+			db.SetMaxOpenConns(1)
+		},
+	})
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// failoverConnector opens connections against an ordered list of endpoints.
+// New connections go to the endpoint that last worked and fall through the
+// list on dial errors; every resolveEvery the preference is reset to the first
+// endpoint so traffic returns to the primary once it recovers.
+type failoverConnector struct {
+	connectors   []driver.Connector
+	resolveEvery time.Duration
+
+	mu        sync.Mutex
+	preferred int
+	resetAt   time.Time
+}
+
+func newFailoverConnector(connectors []driver.Connector, resolveEvery time.Duration) *failoverConnector {
+	return &failoverConnector{
+		connectors:   connectors,
+		resolveEvery: resolveEvery,
+		resetAt:      time.Now().Add(resolveEvery),
+	}
+}
+
+// Connect implements driver.Connector
+func (c *failoverConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	start := c.start()
+
+	var errs []error
+	for i := range c.connectors {
+		idx := (start + i) % len(c.connectors)
+		conn, err := c.connectors[idx].Connect(ctx)
+		if err == nil {
+			c.setPreferred(idx)
+			return conn, nil
+		}
+		errs = append(errs, fmt.Errorf("endpoint %d: %w", idx, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Driver implements driver.Connector
+func (c *failoverConnector) Driver() driver.Driver {
+	return c.connectors[0].Driver()
+}
+
+// start returns the endpoint to try first, re-resolving back to the primary periodically
+func (c *failoverConnector) start() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resolveEvery > 0 && time.Now().After(c.resetAt) {
+		c.preferred = 0
+		c.resetAt = time.Now().Add(c.resolveEvery)
+	}
+	return c.preferred
+}
+
+func (c *failoverConnector) setPreferred(idx int) {
+	c.mu.Lock()
+	c.preferred = idx
+	c.mu.Unlock()
+}
+
+// endpointConfigs returns one Config per entry in cfg.Hosts. Entries are either
+// "host" (using cfg.Port) or "host:port".
+func endpointConfigs(cfg Config) []Config {
+	configs := make([]Config, 0, len(cfg.Hosts))
+	for _, h := range cfg.Hosts {
+		c := cfg
+		c.Hosts = nil
+		c.Host = h
+		if host, port, err := net.SplitHostPort(h); err == nil {
+			c.Host, c.Port = host, port
+		}
+		configs = append(configs, c)
+	}
+	return configs
+}
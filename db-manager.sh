@@ -33,7 +33,7 @@ case "$1" in
   test)
     echo "Testing database connection..."
     export $(cat .env | xargs)
-    go run main.go
+    go run ./cmd/optlockctl migrate
     ;;
   psql)
     echo "Connecting to database with psql..."
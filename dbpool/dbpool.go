@@ -0,0 +1,164 @@
+// Package dbpool sizes a database/sql connection pool from the configuration,
+// samples its statistics into metrics and, with tuning enabled, moves the
+// open connection limit on connection wait times and version conflicts.
+package dbpool
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/config"
+	"github.com/ghozilaaa/optimistic-lock/metrics"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// Metric names recorded by Sample
+const (
+	OpenConns    = "optlock_db_open_connections"     // gauge
+	InUseConns   = "optlock_db_in_use_connections"   // gauge
+	IdleConns    = "optlock_db_idle_connections"     // gauge
+	MaxOpenConns = "optlock_db_max_open_connections" // gauge; the current limit
+	WaitsTotal   = "optlock_db_waits_total"          // connections waited for
+	WaitSeconds  = "optlock_db_wait_seconds"         // average wait per waited connection in a sample
+	ResizesTotal = "optlock_db_resizes_total"        // label direction: grow, shrink
+)
+
+// Sample is one reading of the pool
+type Sample struct {
+	Stats sql.DBStats
+
+	// Since the previous sample
+	Waits     int64
+	AvgWait   time.Duration
+	Attempts  int64
+	Conflicts int64
+
+	MaxOpen int // limit after the sample, which tuning may have changed
+}
+
+// Pool watches a connection pool
+type Pool struct {
+	db      *sql.DB
+	cfg     config.Pool
+	metrics metrics.Metrics
+
+	attempts  atomic.Int64
+	conflicts atomic.Int64
+
+	mu      sync.Mutex
+	maxOpen int
+	last    sql.DBStats
+}
+
+// New applies cfg to db and returns a pool reporting to m (nil for none).
+// With tuning enabled the open limit starts at cfg.MaxOpenConns clamped to
+// the tuning bounds.
+func New(db *sql.DB, cfg config.Pool, m metrics.Metrics) *Pool {
+	if m == nil {
+		m = metrics.Nop{}
+	}
+	if tune := cfg.Tune; tune.Enabled {
+		if cfg.MaxOpenConns == 0 || cfg.MaxOpenConns > tune.MaxOpenConns {
+			cfg.MaxOpenConns = tune.MaxOpenConns
+		}
+		cfg.MaxOpenConns = max(cfg.MaxOpenConns, tune.MinOpenConns)
+	}
+	cfg.Apply(db)
+	return &Pool{db: db, cfg: cfg, metrics: m, maxOpen: cfg.MaxOpenConns, last: db.Stats()}
+}
+
+// Hooks count optimistic attempts and conflicts for tuning; pass them to
+// service.WithHooks on the updaters sharing the pool
+func (p *Pool) Hooks() service.Hooks {
+	return service.Hooks{
+		AfterAttempt: func(_ service.Attempt, err error) {
+			p.attempts.Add(1)
+			if errors.Is(err, service.ErrConflict) {
+				p.conflicts.Add(1)
+			}
+		},
+	}
+}
+
+// MaxOpen returns the current open connection limit (0 is unlimited)
+func (p *Pool) MaxOpen() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.maxOpen
+}
+
+// Sample reads the pool statistics, tunes the limit if enabled and records
+// the metrics
+func (p *Pool) Sample() Sample {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := p.db.Stats()
+	s := Sample{
+		Stats:     stats,
+		Waits:     stats.WaitCount - p.last.WaitCount,
+		Attempts:  p.attempts.Swap(0),
+		Conflicts: p.conflicts.Swap(0),
+	}
+	if s.Waits > 0 {
+		s.AvgWait = (stats.WaitDuration - p.last.WaitDuration) / time.Duration(s.Waits)
+	}
+	p.last = stats
+
+	if p.cfg.Tune.Enabled {
+		p.tune(s)
+	}
+	s.MaxOpen = p.maxOpen
+
+	p.metrics.Gauge(OpenConns, float64(stats.OpenConnections), nil)
+	p.metrics.Gauge(InUseConns, float64(stats.InUse), nil)
+	p.metrics.Gauge(IdleConns, float64(stats.Idle), nil)
+	p.metrics.Gauge(MaxOpenConns, float64(s.MaxOpen), nil)
+	if s.Waits > 0 {
+		p.metrics.Count(WaitsTotal, s.Waits, nil)
+		p.metrics.Observe(WaitSeconds, s.AvgWait.Seconds(), nil)
+	}
+	return s
+}
+
+// tune moves the limit by a quarter (at least one connection). A high
+// conflict rate shrinks the pool even when callers are waiting: more
+// connections would only let more writers race for the same rows.
+func (p *Pool) tune(s Sample) {
+	tune := p.cfg.Tune
+	step := max(1, p.maxOpen/4)
+
+	next, direction := p.maxOpen, ""
+	switch {
+	case s.Attempts > 0 && s.Conflicts*100 > s.Attempts*int64(tune.ConflictPercent):
+		next, direction = max(tune.MinOpenConns, p.maxOpen-step), "shrink"
+	case s.AvgWait > tune.WaitThreshold:
+		next, direction = min(tune.MaxOpenConns, p.maxOpen+step), "grow"
+	}
+	if next == p.maxOpen {
+		return
+	}
+
+	p.maxOpen = next
+	p.db.SetMaxOpenConns(next)
+	p.db.SetMaxIdleConns(min(p.cfg.MaxIdleConns, next))
+	p.metrics.Count(ResizesTotal, 1, metrics.Labels{"direction": direction})
+}
+
+// Run samples the pool every interval until ctx is done
+func (p *Pool) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Sample()
+		}
+	}
+}
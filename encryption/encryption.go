@@ -0,0 +1,193 @@
+// Package encryption seals balance amounts at rest with AES-256-GCM, for
+// deployments that must not store them in the clear. Every sealed amount is
+// bound to the table, column and row it is stored in, so one copied to
+// another row does not open. Sealing is deterministic: an amount seals to the
+// same text in the same row under the same key, so a retried write stores
+// exactly what the first one did. The version column stays in the clear, so
+// version-checked updates work unchanged.
+//
+// models.Balance cannot be sealed: the atomic and RETURNING strategies of
+// the service add to the amount in SQL (amount = amount + ?), which needs
+// the integer in the clear.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+// SerializerName is the GORM serializer Register installs, for a field tagged
+// `gorm:"serializer:encrypted_amount"`
+const SerializerName = "encrypted_amount"
+
+// ErrTampered is returned for a sealed amount that was not sealed by the key
+// of the codec, or was changed since
+var ErrTampered = errors.New("sealed amount does not authenticate")
+
+// ErrUnboundRow is returned by the Serializer for a row without its primary
+// key, which the sealed amount is bound to
+var ErrUnboundRow = errors.New("sealed amount needs the primary key of its row; set it before creating the row")
+
+// Binding is where a sealed amount is stored. It is authenticated with the
+// amount, which then only opens with the same binding.
+type Binding struct {
+	Table  string
+	Column string
+	RowID  uint64
+}
+
+// additionalData encodes b unambiguously, as the additional data of a seal
+func (b Binding) additionalData() []byte {
+	var aad []byte
+	for _, s := range []string{b.Table, b.Column} {
+		aad = binary.BigEndian.AppendUint32(aad, uint32(len(s)))
+		aad = append(aad, s...)
+	}
+	return binary.BigEndian.AppendUint64(aad, b.RowID)
+}
+
+// Codec seals and opens amounts with one key
+type Codec struct {
+	aead     cipher.AEAD
+	nonceKey []byte
+}
+
+// NewCodec returns a codec for a 32-byte key. The encryption key and the key
+// deriving nonces are both derived from it.
+func NewCodec(key []byte) (*Codec, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(derive(key, "amount encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Codec{aead: aead, nonceKey: derive(key, "amount nonce")}, nil
+}
+
+// derive returns a subkey of key for purpose
+func derive(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Seal returns amount encrypted for b and base64-encoded. The nonce is a MAC
+// of the binding and the amount, which makes sealing deterministic without
+// ever reusing a nonce for two different amounts or places.
+func (c *Codec) Seal(amount int64, b Binding) string {
+	plain := binary.BigEndian.AppendUint64(nil, uint64(amount))
+	aad := b.additionalData()
+	mac := hmac.New(sha256.New, c.nonceKey)
+	mac.Write(aad)
+	mac.Write(plain)
+	nonce := mac.Sum(nil)[:c.aead.NonceSize()]
+	return base64.StdEncoding.EncodeToString(c.aead.Seal(nonce, nonce, plain, aad))
+}
+
+// Open returns the amount sealed in s for b
+func (c *Codec) Open(s string, b Binding) (int64, error) {
+	sealed, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return 0, ErrTampered
+	}
+	n := c.aead.NonceSize()
+	plain, err := c.aead.Open(nil, sealed[:n], sealed[n:], b.additionalData())
+	if err != nil || len(plain) != 8 {
+		return 0, ErrTampered
+	}
+	return int64(binary.BigEndian.Uint64(plain)), nil
+}
+
+// Serializer is the GORM serializer of an integer field sealed by Codec,
+// bound to the table and column of the field and the primary key of the
+// row. It is applied when GORM writes a struct; updates with a map bypass
+// serializers, so pass Codec.Seal of the amount there. The primary key must
+// be set before the row is created, as the database cannot assign it first,
+// and selected ahead of the amount when the row is read.
+type Serializer struct {
+	Codec *Codec
+}
+
+var _ schema.SerializerInterface = Serializer{}
+
+// Register installs a Serializer for c as SerializerName. GORM serializers
+// are global, so one key serves the whole process.
+func Register(c *Codec) {
+	schema.RegisterSerializer(SerializerName, Serializer{Codec: c})
+}
+
+// Scan opens the sealed amount of a column into the field
+func (s Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue any) error {
+	var sealed string
+	switch v := dbValue.(type) {
+	case nil:
+		return nil
+	case []byte:
+		sealed = string(v)
+	case string:
+		sealed = v
+	default:
+		return fmt.Errorf("%s: cannot open a sealed amount from %T", field.Name, dbValue)
+	}
+	b, err := binding(ctx, field, dst)
+	if err != nil {
+		return err
+	}
+	amount, err := s.Codec.Open(sealed, b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", field.Name, err)
+	}
+	field.ReflectValueOf(ctx, dst).SetInt(amount)
+	return nil
+}
+
+// Value seals the amount of the field
+func (s Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue any) (any, error) {
+	v := reflect.ValueOf(fieldValue)
+	if !v.CanInt() {
+		return nil, fmt.Errorf("%s: cannot seal a %T", field.Name, fieldValue)
+	}
+	b, err := binding(ctx, field, dst)
+	if err != nil {
+		return nil, err
+	}
+	return s.Codec.Seal(v.Int(), b), nil
+}
+
+// binding returns where field of the row dst is stored
+func binding(ctx context.Context, field *schema.Field, dst reflect.Value) (Binding, error) {
+	pk := field.Schema.PrioritizedPrimaryField
+	if pk == nil {
+		return Binding{}, fmt.Errorf("%s: %s has no single primary key", field.Name, field.Schema.Name)
+	}
+	value, zero := pk.ValueOf(ctx, dst)
+	if zero {
+		return Binding{}, fmt.Errorf("%s: %w", field.Name, ErrUnboundRow)
+	}
+	id := reflect.ValueOf(value)
+	b := Binding{Table: field.Schema.Table, Column: field.DBName}
+	switch {
+	case id.CanUint():
+		b.RowID = id.Uint()
+	case id.CanInt() && id.Int() > 0:
+		b.RowID = uint64(id.Int())
+	default:
+		return Binding{}, fmt.Errorf("%s: cannot bind to a %T primary key", field.Name, value)
+	}
+	return b, nil
+}
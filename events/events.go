@@ -0,0 +1,30 @@
+// Package events describes balance changes for downstream consumers and the
+// publishers that deliver them.
+package events
+
+import (
+	"context"
+	"time"
+)
+
+// BalanceChanged is emitted for every committed balance update
+type BalanceChanged struct {
+	BalanceID uint      `json:"balance_id"`
+	OldAmount int64     `json:"old_amount"`
+	NewAmount int64     `json:"new_amount"`
+	Delta     int64     `json:"delta"`
+	Version   int       `json:"version"` // Version after the update; orders events of one balance
+	At        time.Time `json:"at"`
+}
+
+// Publisher delivers balance events. Implementations must be safe for concurrent use.
+type Publisher interface {
+	Publish(ctx context.Context, event BalanceChanged) error
+}
+
+// PublisherFunc adapts a function to Publisher
+type PublisherFunc func(ctx context.Context, event BalanceChanged) error
+
+func (f PublisherFunc) Publish(ctx context.Context, event BalanceChanged) error {
+	return f(ctx, event)
+}
@@ -0,0 +1,80 @@
+package events
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaMessage is one record for a Kafka topic
+type KafkaMessage struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+}
+
+// KafkaWriter sends records synchronously. KafkaProducer implements it on
+// segmentio/kafka-go; it is a few lines to implement on another client, such
+// as sarama's SyncProducer.SendMessages.
+type KafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...KafkaMessage) error
+}
+
+// KafkaPublisher publishes events as JSON to Topic, keyed by balance ID so
+// every change of a balance lands on the same partition in version order.
+type KafkaPublisher struct {
+	Writer KafkaWriter
+	Topic  string
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, event BalanceChanged) error {
+	return p.Writer.WriteMessages(ctx, KafkaMessage{
+		Topic:   p.Topic,
+		Key:     []byte(strconv.FormatUint(uint64(event.BalanceID), 10)),
+		Value:   event.AppendJSON(nil),
+		Headers: map[string]string{"type": "balance.changed"},
+	})
+}
+
+// KafkaProducer is a KafkaWriter on segmentio/kafka-go. It waits for every
+// in-sync replica to acknowledge a record, and picks its partition by a hash
+// of its key, so the records of a balance keep their order.
+type KafkaProducer struct {
+	w *kafka.Writer
+}
+
+// NewKafkaProducer returns a producer for the cluster of brokers. Configure
+// TLS, SASL or batching on Writer() before the first write.
+func NewKafkaProducer(brokers ...string) *KafkaProducer {
+	return &KafkaProducer{w: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		// Writes run in the update transaction or the outbox relay, which
+		// wait for them; do not hold a batch open for more records
+		BatchTimeout: 10 * time.Millisecond,
+	}}
+}
+
+// Writer returns the kafka-go writer of p
+func (p *KafkaProducer) Writer() *kafka.Writer { return p.w }
+
+// WriteMessages implements KafkaWriter
+func (p *KafkaProducer) WriteMessages(ctx context.Context, msgs ...KafkaMessage) error {
+	out := make([]kafka.Message, len(msgs))
+	for i, m := range msgs {
+		out[i] = kafka.Message{Topic: m.Topic, Key: m.Key, Value: m.Value}
+		for k, v := range m.Headers {
+			out[i].Headers = append(out[i].Headers, kafka.Header{Key: k, Value: []byte(v)})
+		}
+	}
+	return p.w.WriteMessages(ctx, out...)
+}
+
+// Close flushes pending records and closes the connections to the brokers
+func (p *KafkaProducer) Close() error {
+	return p.w.Close()
+}
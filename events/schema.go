@@ -0,0 +1,91 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// SchemaVersion is the BalanceChanged schema this build writes. Payloads
+// written before events carried a version are version 1.
+//
+// To change the schema, bump SchemaVersion, update BalanceChanged and its
+// encoder, and register an upcaster from the previous version in
+// BalanceChangedSchema so consumers keep reading events already stored in
+// outboxes and topics.
+const SchemaVersion = 2
+
+// ErrUnknownSchemaVersion is returned for payloads newer than this build
+// understands or with no upcaster path to it
+var ErrUnknownSchemaVersion = errors.New("unknown event schema version")
+
+// Upcaster rewrites the fields of a payload at one schema version into the
+// next version
+type Upcaster func(fields map[string]json.RawMessage) error
+
+// Schema upcasts payloads from any older version to Current
+type Schema struct {
+	Current   int
+	Upcasters map[int]Upcaster // Upcasters[v] turns version v into v+1
+}
+
+// BalanceChangedSchema is the upcaster chain of BalanceChanged
+var BalanceChangedSchema = Schema{
+	Current: SchemaVersion,
+	Upcasters: map[int]Upcaster{
+		// Version 2 only added schema_version itself
+		1: func(map[string]json.RawMessage) error { return nil },
+	},
+}
+
+// Upcast returns data rewritten to the current version, with schema_version
+// set. A payload without schema_version is version 1.
+func (s Schema) Upcast(data []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	version := 1
+	if raw, ok := fields["schema_version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, fmt.Errorf("invalid schema_version: %w", err)
+		}
+	}
+	if version == s.Current {
+		return data, nil
+	}
+	if version < 1 || version > s.Current {
+		return nil, fmt.Errorf("%w: %d (current is %d)", ErrUnknownSchemaVersion, version, s.Current)
+	}
+
+	for ; version < s.Current; version++ {
+		upcast, ok := s.Upcasters[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: no upcaster from %d", ErrUnknownSchemaVersion, version)
+		}
+		if err := upcast(fields); err != nil {
+			return nil, fmt.Errorf("upcasting from schema version %d: %w", version, err)
+		}
+	}
+	fields["schema_version"] = json.RawMessage(strconv.Itoa(s.Current))
+	return json.Marshal(fields)
+}
+
+// currentPrefix starts every payload AppendJSON writes, so those skip upcasting
+var currentPrefix = []byte(`{"schema_version":` + strconv.Itoa(SchemaVersion) + `,`)
+
+// UnmarshalJSON decodes a payload of any known schema version, upcasting
+// older ones
+func (e *BalanceChanged) UnmarshalJSON(data []byte) error {
+	type plain BalanceChanged
+	if !bytes.HasPrefix(data, currentPrefix) {
+		var err error
+		if data, err = BalanceChangedSchema.Upcast(data); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, (*plain)(e))
+}
@@ -0,0 +1,281 @@
+// Package hotpath absorbs writes to hot balances in a shared store such as
+// Redis and flushes their sum to the database in the background, for
+// balances that take more writes than a single-row compare-and-swap allows.
+//
+// A claimed balance is updated in the store only. A Coordinator flushes the
+// deltas of each hot balance periodically as one idempotent adjustment under
+// the optimistic lock, so the database lags the store by at most a flush
+// interval. Writes to a hot balance should go through a Coordinator: the
+// database still adds up if they do not, but the amount the store reports
+// is only corrected at the next flush. The same goes for freezing and
+// closing a hot balance: the Coordinator records the status in the store,
+// so writes are refused there instead of failing at the next flush.
+package hotpath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// State is a hot balance as the store holds it
+type State struct {
+	Amount   int64  // database amount plus the deltas not flushed yet
+	Version  int64  // bumped by every write; starts at the database version when claimed
+	Pending  int64  // sum of the deltas not flushed yet
+	Flushing int64  // sum of the deltas a flush is writing
+	Status   string // status of the balance; writes are refused unless models.BalanceActive
+}
+
+// Batch is the sum of deltas one flush writes. Seq numbers the batches of a
+// balance, so a batch retried after a failed flush is applied once.
+type Batch struct {
+	Seq   int64
+	Delta int64
+}
+
+// Store holds hot balances. Every method must be atomic per balance across
+// all processes sharing the store; RedisStore does it with Lua scripts.
+type Store interface {
+	// Claim makes balance id hot with the given amount, version and status,
+	// unless it already is, and returns its state
+	Claim(ctx context.Context, id uint, amount int64, version int64, status string) (State, error)
+	// Apply adds delta to a hot, active balance if expectedVersion is
+	// negative or matches its version. It reports hot=false without changing
+	// anything if the balance is not hot, and applied=false if it is not
+	// active or on a version mismatch.
+	Apply(ctx context.Context, id uint, delta, expectedVersion int64) (state State, hot, applied bool, err error)
+	// Get returns the state of a hot balance
+	Get(ctx context.Context, id uint) (state State, hot bool, err error)
+	// SetStatus records the status of a hot balance and returns its state
+	SetStatus(ctx context.Context, id uint, status string) (state State, hot bool, err error)
+	// Take moves the pending deltas into a new batch, or returns the batch
+	// still being flushed. A zero Delta means there is nothing to flush.
+	Take(ctx context.Context, id uint) (Batch, error)
+	// Ack ends batch seq after it was written, resetting the amount to
+	// base, the database amount, plus the deltas pending since
+	Ack(ctx context.Context, id uint, seq, base int64) error
+	// Release drops a hot balance if nothing is pending or being flushed
+	Release(ctx context.Context, id uint) (released bool, err error)
+	// Hot lists the hot balances
+	Hot(ctx context.Context) ([]uint, error)
+}
+
+// Coordinator sends writes to hot balances to a Store and flushes them to
+// the database; writes to other balances go to the Updater directly
+type Coordinator struct {
+	db      *gorm.DB
+	store   Store
+	updater *service.Updater
+}
+
+// NewCoordinator returns a Coordinator writing through updater, which
+// defaults to service.NewUpdater(db)
+func NewCoordinator(db *gorm.DB, store Store, updater *service.Updater) *Coordinator {
+	if updater == nil {
+		updater = service.NewUpdater(db)
+	}
+	return &Coordinator{db: db, store: store, updater: updater}
+}
+
+// Claim makes balance id hot, starting from its database amount, version
+// and status. A frozen or closed balance can be claimed, but refuses writes
+// until it is unfrozen through the Coordinator.
+func (c *Coordinator) Claim(ctx context.Context, id uint) (State, error) {
+	balance, err := service.GetBalance(c.db.WithContext(ctx), id)
+	if err != nil {
+		return State{}, err
+	}
+	status := balance.Status
+	if status == "" {
+		status = models.BalanceActive
+	}
+	return c.store.Claim(ctx, id, balance.Amount, int64(balance.Version), status)
+}
+
+// Add adds delta to balance id: in the store if it is hot, otherwise in the
+// database with the updater's retry policy
+func (c *Coordinator) Add(ctx context.Context, id uint, delta int64) (State, error) {
+	return c.add(ctx, id, delta, -1)
+}
+
+// AddIfVersion adds delta only if balance id is at version, returning
+// service.ErrConflict otherwise. For a hot balance the version is the one
+// State reports.
+func (c *Coordinator) AddIfVersion(ctx context.Context, id uint, version, delta int64) (State, error) {
+	if version < 0 {
+		return State{}, errors.New("version must not be negative")
+	}
+	return c.add(ctx, id, delta, version)
+}
+
+func (c *Coordinator) add(ctx context.Context, id uint, delta, expectedVersion int64) (State, error) {
+	state, hot, applied, err := c.store.Apply(ctx, id, delta, expectedVersion)
+	switch {
+	case err != nil:
+		return State{}, fmt.Errorf("hot balance %d: %w", id, err)
+	case hot && state.Status != models.BalanceActive:
+		return state, &service.StatusError{BalanceID: id, Status: state.Status}
+	case hot && !applied:
+		return state, service.ErrConflict
+	case hot:
+		return state, nil
+	}
+
+	var outcome service.UpdateOutcome
+	if expectedVersion < 0 {
+		outcome, err = c.updater.UpdateBalance(id, delta)
+	} else {
+		outcome, err = c.updater.UpdateIfVersion(id, int(expectedVersion), delta)
+	}
+	return State{Amount: outcome.NewAmount, Version: int64(outcome.Version)}, err
+}
+
+// Balance returns the amount and version of balance id, from the store if
+// it is hot
+func (c *Coordinator) Balance(ctx context.Context, id uint) (State, error) {
+	state, hot, err := c.store.Get(ctx, id)
+	if err != nil || hot {
+		return state, err
+	}
+	balance, err := service.GetBalance(c.db.WithContext(ctx), id)
+	return State{Amount: balance.Amount, Version: int64(balance.Version)}, err
+}
+
+// Flush writes the pending deltas of every hot balance to the database
+func (c *Coordinator) Flush(ctx context.Context) error {
+	ids, err := c.store.Hot(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, id := range ids {
+		if err := c.flush(ctx, id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// flush writes one batch of balance id as an adjustment keyed by its
+// sequence number, so a batch whose acknowledgement was lost is not applied
+// twice when it is taken again
+func (c *Coordinator) flush(ctx context.Context, id uint) error {
+	batch, err := c.store.Take(ctx, id)
+	if err != nil || batch.Delta == 0 {
+		return err
+	}
+	reference := fmt.Sprintf("hotpath:%d:%d", id, batch.Seq)
+	if _, err := c.updater.ApplyAdjustment(reference, id, batch.Delta); err != nil {
+		// The balance was frozen or closed around the Coordinator: refuse
+		// further writes in the store. The batch stays until it is unfrozen.
+		var statusErr *service.StatusError
+		if errors.As(err, &statusErr) {
+			if _, _, serr := c.store.SetStatus(ctx, id, statusErr.Status); serr != nil {
+				err = errors.Join(err, serr)
+			}
+		}
+		return fmt.Errorf("flush hot balance %d: %w", id, err)
+	}
+	balance, err := service.GetBalance(c.db.WithContext(ctx), id)
+	if err != nil {
+		return err
+	}
+	return c.store.Ack(ctx, id, batch.Seq, balance.Amount)
+}
+
+// Release flushes balance id and makes it cold again. It returns false if
+// writes arrived during the flush; try again later.
+func (c *Coordinator) Release(ctx context.Context, id uint) (bool, error) {
+	if err := c.flush(ctx, id); err != nil {
+		return false, err
+	}
+	return c.store.Release(ctx, id)
+}
+
+// Freeze stops all writes to balance id. If it is hot, writes are refused in
+// the store first and the deltas accepted before are flushed, so none of
+// them is stranded behind the frozen status.
+func (c *Coordinator) Freeze(ctx context.Context, id uint) (models.Balance, error) {
+	return c.deactivate(ctx, id, models.BalanceFrozen, c.updater.Freeze)
+}
+
+// Close closes balance id for good like Freeze, and releases it if it is hot
+func (c *Coordinator) Close(ctx context.Context, id uint) (models.Balance, error) {
+	balance, err := c.deactivate(ctx, id, models.BalanceClosed, c.updater.Close)
+	if err != nil {
+		return balance, err
+	}
+	_, err = c.store.Release(ctx, id)
+	return balance, err
+}
+
+// Unfreeze lets balance id take writes again, in the store too if it is hot
+func (c *Coordinator) Unfreeze(ctx context.Context, id uint) (models.Balance, error) {
+	balance, err := c.updater.Unfreeze(id)
+	if err != nil {
+		return balance, err
+	}
+	_, _, err = c.store.SetStatus(ctx, id, models.BalanceActive)
+	return balance, err
+}
+
+// deactivate records status for a hot balance, flushes what it accepted
+// while active, then applies the change through change. The store goes back
+// to its previous status if either step fails.
+func (c *Coordinator) deactivate(ctx context.Context, id uint, status string, change func(uint) (models.Balance, error)) (models.Balance, error) {
+	prev, hot, err := c.store.SetStatus(ctx, id, status)
+	if err != nil {
+		return models.Balance{}, err
+	}
+	restore := func(err error) (models.Balance, error) {
+		if hot {
+			if _, _, serr := c.store.SetStatus(ctx, id, prev.Status); serr != nil {
+				err = errors.Join(err, serr)
+			}
+		}
+		return models.Balance{}, err
+	}
+	for hot {
+		state, stillHot, err := c.store.Get(ctx, id)
+		if err != nil {
+			return restore(err)
+		}
+		if !stillHot || state.Pending == 0 && state.Flushing == 0 {
+			break
+		}
+		if err := c.flush(ctx, id); err != nil {
+			return restore(err)
+		}
+	}
+	balance, err := change(id)
+	if err != nil {
+		return restore(err)
+	}
+	return balance, nil
+}
+
+// Run flushes every interval until ctx is cancelled, and once more before
+// returning. Errors are passed to onError, which may be nil.
+func (c *Coordinator) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	for {
+		select {
+		case <-ctx.Done():
+			// Flush what was accepted since the last tick with a fresh context
+			if err := c.Flush(context.WithoutCancel(ctx)); err != nil && onError != nil {
+				onError(err)
+			}
+			return
+		case <-time.After(interval):
+		}
+		if err := c.Flush(ctx); err != nil && ctx.Err() == nil && onError != nil {
+			onError(err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package hotpath
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// MemoryStore is a Store in this process, for a single instance or tests
+type MemoryStore struct {
+	mu       sync.Mutex
+	balances map[uint]*memoryBalance
+}
+
+type memoryBalance struct {
+	state State
+	seq   int64
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{balances: make(map[uint]*memoryBalance)}
+}
+
+// Claim implements Store
+func (s *MemoryStore) Claim(_ context.Context, id uint, amount, version int64, status string) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.balances[id]
+	if !ok {
+		b = &memoryBalance{state: State{Amount: amount, Version: version, Status: status}}
+		s.balances[id] = b
+	}
+	return b.state, nil
+}
+
+// Apply implements Store
+func (s *MemoryStore) Apply(_ context.Context, id uint, delta, expectedVersion int64) (State, bool, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.balances[id]
+	if !ok {
+		return State{}, false, false, nil
+	}
+	if b.state.Status != models.BalanceActive {
+		return b.state, true, false, nil
+	}
+	if expectedVersion >= 0 && b.state.Version != expectedVersion {
+		return b.state, true, false, nil
+	}
+	b.state.Amount += delta
+	b.state.Pending += delta
+	b.state.Version++
+	return b.state, true, true, nil
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(_ context.Context, id uint) (State, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.balances[id]; ok {
+		return b.state, true, nil
+	}
+	return State{}, false, nil
+}
+
+// SetStatus implements Store
+func (s *MemoryStore) SetStatus(_ context.Context, id uint, status string) (State, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.balances[id]
+	if !ok {
+		return State{}, false, nil
+	}
+	b.state.Status = status
+	return b.state, true, nil
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(_ context.Context, id uint) (Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.balances[id]
+	if !ok {
+		return Batch{}, nil
+	}
+	if b.state.Flushing == 0 && b.state.Pending != 0 {
+		b.state.Flushing, b.state.Pending = b.state.Pending, 0
+		b.seq++
+	}
+	return Batch{Seq: b.seq, Delta: b.state.Flushing}, nil
+}
+
+// Ack implements Store
+func (s *MemoryStore) Ack(_ context.Context, id uint, seq, base int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.balances[id]; ok && b.seq == seq {
+		b.state.Flushing = 0
+		b.state.Amount = base + b.state.Pending
+	}
+	return nil
+}
+
+// Release implements Store
+func (s *MemoryStore) Release(_ context.Context, id uint) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.balances[id]; ok {
+		if b.state.Pending != 0 || b.state.Flushing != 0 {
+			return false, nil
+		}
+		delete(s.balances, id)
+	}
+	return true, nil
+}
+
+// Hot implements Store
+func (s *MemoryStore) Hot(context.Context) ([]uint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]uint, 0, len(s.balances))
+	for id := range s.balances {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
@@ -0,0 +1,263 @@
+package hotpath
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// RedisScripter runs a Lua script. It is one line on top of any client, e.g.
+// go-redis's client.Eval(ctx, script, keys, args...).Result(), which keeps
+// the client library and its configuration the application's choice.
+type RedisScripter interface {
+	Eval(ctx context.Context, script string, keys []string, args ...any) (any, error)
+}
+
+// RedisStore keeps hot balances in Redis hashes, one per balance, and their
+// IDs in a set. Each operation is a Lua script, so it is atomic across every
+// process using the same Redis. The keys of a script must map to one slot
+// on Redis Cluster, so put a hash tag in Prefix there, e.g. "{optlock}:".
+type RedisStore struct {
+	Client RedisScripter
+	Prefix string // defaults to "optlock:hot:"
+}
+
+// The hash fields are amount, version, pending, flushing, status and seq,
+// the sequence number of the last batch taken. Scripts reply with the state
+// of a balance as the first five of these; a hash claimed without a status
+// counts as active. Replies avoid nil, which some clients report as an error.
+const (
+	redisState = `
+local function state(key)
+  local s = redis.call('HMGET', key, 'amount', 'version', 'pending', 'flushing', 'status')
+  return {s[1], s[2], s[3], s[4], s[5] or 'active'}
+end
+`
+
+	redisClaim = redisState + `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  redis.call('HSET', KEYS[1], 'amount', ARGV[1], 'version', ARGV[2], 'pending', 0, 'flushing', 0, 'status', ARGV[4], 'seq', 0)
+  redis.call('SADD', KEYS[2], ARGV[3])
+end
+return state(KEYS[1])`
+
+	redisApply = redisState + `
+if redis.call('EXISTS', KEYS[1]) == 0 then return {-1} end
+local s = state(KEYS[1])
+local expected = tonumber(ARGV[2])
+if s[5] ~= 'active' or (expected >= 0 and tonumber(s[2]) ~= expected) then
+  return {0, s[1], s[2], s[3], s[4], s[5]}
+end
+redis.call('HINCRBY', KEYS[1], 'amount', ARGV[1])
+redis.call('HINCRBY', KEYS[1], 'pending', ARGV[1])
+redis.call('HINCRBY', KEYS[1], 'version', 1)
+s = state(KEYS[1])
+return {1, s[1], s[2], s[3], s[4], s[5]}`
+
+	redisGet = redisState + `
+if redis.call('EXISTS', KEYS[1]) == 0 then return {} end
+return state(KEYS[1])`
+
+	redisSetStatus = redisState + `
+if redis.call('EXISTS', KEYS[1]) == 0 then return {} end
+redis.call('HSET', KEYS[1], 'status', ARGV[1])
+return state(KEYS[1])`
+
+	redisTake = `
+if redis.call('EXISTS', KEYS[1]) == 0 then return {0, 0} end
+if tonumber(redis.call('HGET', KEYS[1], 'flushing')) == 0 then
+  local pending = tonumber(redis.call('HGET', KEYS[1], 'pending'))
+  if pending ~= 0 then
+    redis.call('HSET', KEYS[1], 'flushing', pending, 'pending', 0)
+    redis.call('HINCRBY', KEYS[1], 'seq', 1)
+  end
+end
+return redis.call('HMGET', KEYS[1], 'seq', 'flushing')`
+
+	redisAck = `
+if redis.call('EXISTS', KEYS[1]) == 0 or redis.call('HGET', KEYS[1], 'seq') ~= ARGV[1] then return 0 end
+local pending = tonumber(redis.call('HGET', KEYS[1], 'pending'))
+redis.call('HSET', KEYS[1], 'flushing', 0, 'amount', tonumber(ARGV[2]) + pending)
+return 1`
+
+	redisRelease = `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  local s = redis.call('HMGET', KEYS[1], 'pending', 'flushing')
+  if tonumber(s[1]) ~= 0 or tonumber(s[2]) ~= 0 then return 0 end
+  redis.call('DEL', KEYS[1])
+end
+redis.call('SREM', KEYS[2], ARGV[1])
+return 1`
+
+	redisHot = `return redis.call('SMEMBERS', KEYS[1])`
+)
+
+func (s RedisStore) key(id uint) string {
+	return s.prefix() + strconv.FormatUint(uint64(id), 10)
+}
+
+func (s RedisStore) setKey() string {
+	return s.prefix() + "ids"
+}
+
+func (s RedisStore) prefix() string {
+	if s.Prefix == "" {
+		return "optlock:hot:"
+	}
+	return s.Prefix
+}
+
+// Claim implements Store
+func (s RedisStore) Claim(ctx context.Context, id uint, amount, version int64, status string) (State, error) {
+	reply, err := s.Client.Eval(ctx, redisClaim, []string{s.key(id), s.setKey()}, amount, version, uint64(id), status)
+	if err != nil {
+		return State{}, err
+	}
+	return stateOf(reply, 0)
+}
+
+// Apply implements Store
+func (s RedisStore) Apply(ctx context.Context, id uint, delta, expectedVersion int64) (State, bool, bool, error) {
+	reply, err := s.Client.Eval(ctx, redisApply, []string{s.key(id)}, delta, expectedVersion)
+	if err != nil {
+		return State{}, false, false, err
+	}
+	values, ok := reply.([]any)
+	if !ok || len(values) == 0 {
+		return State{}, false, false, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	applied, err := redisInt(values[0])
+	if err != nil || applied == -1 {
+		return State{}, false, false, err
+	}
+	state, err := stateOf(reply, 1)
+	if err != nil {
+		return State{}, false, false, err
+	}
+	return state, true, applied == 1, nil
+}
+
+// Get implements Store
+func (s RedisStore) Get(ctx context.Context, id uint) (State, bool, error) {
+	return s.hotState(s.Client.Eval(ctx, redisGet, []string{s.key(id)}))
+}
+
+// SetStatus implements Store
+func (s RedisStore) SetStatus(ctx context.Context, id uint, status string) (State, bool, error) {
+	return s.hotState(s.Client.Eval(ctx, redisSetStatus, []string{s.key(id)}, status))
+}
+
+// hotState decodes the reply of a script returning the state of a balance,
+// or an empty array if it is not hot
+func (s RedisStore) hotState(reply any, err error) (State, bool, error) {
+	if err != nil {
+		return State{}, false, err
+	}
+	if values, ok := reply.([]any); ok && len(values) == 0 {
+		return State{}, false, nil
+	}
+	state, err := stateOf(reply, 0)
+	return state, err == nil, err
+}
+
+// Take implements Store
+func (s RedisStore) Take(ctx context.Context, id uint) (Batch, error) {
+	reply, err := s.Client.Eval(ctx, redisTake, []string{s.key(id)})
+	if err != nil {
+		return Batch{}, err
+	}
+	values, err := redisInts(reply, 2)
+	if err != nil {
+		return Batch{}, err
+	}
+	return Batch{Seq: values[0], Delta: values[1]}, nil
+}
+
+// Ack implements Store
+func (s RedisStore) Ack(ctx context.Context, id uint, seq, base int64) error {
+	_, err := s.Client.Eval(ctx, redisAck, []string{s.key(id)}, seq, base)
+	return err
+}
+
+// Release implements Store
+func (s RedisStore) Release(ctx context.Context, id uint) (bool, error) {
+	reply, err := s.Client.Eval(ctx, redisRelease, []string{s.key(id), s.setKey()}, uint64(id))
+	if err != nil {
+		return false, err
+	}
+	released, err := redisInt(reply)
+	return released == 1, err
+}
+
+// Hot implements Store
+func (s RedisStore) Hot(ctx context.Context) ([]uint, error) {
+	reply, err := s.Client.Eval(ctx, redisHot, []string{s.setKey()})
+	if err != nil {
+		return nil, err
+	}
+	members, ok := reply.([]any)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected reply %T", reply)
+	}
+	ids := make([]uint, 0, len(members))
+	for _, m := range members {
+		id, err := redisInt(m)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
+
+// stateOf decodes the state a script returned from index i of its reply:
+// four integers and the status
+func stateOf(reply any, i int) (State, error) {
+	values, ok := reply.([]any)
+	if !ok || len(values) < i+5 {
+		return State{}, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	ints, err := redisInts(values[i:i+4], 4)
+	if err != nil {
+		return State{}, err
+	}
+	state := State{Amount: ints[0], Version: ints[1], Pending: ints[2], Flushing: ints[3]}
+	switch status := values[i+4].(type) {
+	case string:
+		state.Status = status
+	case []byte:
+		state.Status = string(status)
+	default:
+		return State{}, fmt.Errorf("redis: unexpected status %v (%T)", status, status)
+	}
+	return state, nil
+}
+
+// redisInts decodes an array reply of at least n integers, which Redis
+// returns as integers or, from hash fields, as strings
+func redisInts(reply any, n int) ([]int64, error) {
+	values, ok := reply.([]any)
+	if !ok || len(values) < n {
+		return nil, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	out := make([]int64, len(values))
+	for i, v := range values {
+		var err error
+		if out[i], err = redisInt(v); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func redisInt(v any) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	}
+	return 0, fmt.Errorf("redis: unexpected value %v (%T)", v, v)
+}
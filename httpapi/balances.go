@@ -0,0 +1,297 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+	"github.com/ghozilaaa/optimistic-lock/tenant"
+)
+
+// balanceResponse is the JSON body of the balance endpoints
+type balanceResponse struct {
+	ID      uint  `json:"id"`
+	Amount  int64 `json:"amount"`
+	Version int   `json:"version"`
+}
+
+// appendJSON appends the body as json.Encoder would write it, without
+// reflection: GET /balances/{id} is the hottest read endpoint
+func (b balanceResponse) appendJSON(buf []byte) []byte {
+	buf = append(buf, `{"id":`...)
+	buf = strconv.AppendUint(buf, uint64(b.ID), 10)
+	buf = append(buf, `,"amount":`...)
+	buf = strconv.AppendInt(buf, b.Amount, 10)
+	buf = append(buf, `,"version":`...)
+	buf = strconv.AppendInt(buf, int64(b.Version), 10)
+	return append(buf, "}\n"...)
+}
+
+// bufPool holds the buffers writeBalance encodes into
+var bufPool = sync.Pool{New: func() any { buf := make([]byte, 0, 64); return &buf }}
+
+// writeBalance writes b with the given status code
+func writeBalance(w http.ResponseWriter, status int, b balanceResponse) {
+	buf := bufPool.Get().(*[]byte)
+	*buf = b.appendJSON((*buf)[:0])
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(*buf)))
+	w.WriteHeader(status)
+	w.Write(*buf)
+	bufPool.Put(buf)
+}
+
+// patchBalanceRequest is the body of PATCH /balances/{id}
+type patchBalanceRequest struct {
+	Delta int64 `json:"delta"`
+}
+
+// putBalanceRequest is the body of PUT /balances/{id}
+type putBalanceRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+// getBalance returns the balance with its version as the ETag. A replica read
+// may return an older version, which a conditional write then rejects with
+// 412, unless ?min_version= asks for at least the version of an earlier
+// write; without ?consistency= such reads are eventual. ?max_stale= accepts
+// a balance from the Updater's cache up to that old.
+func (s *Server) getBalance(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.balanceID(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	minVersion := 0
+	if v := query.Get("min_version"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid min_version")
+			return
+		}
+		minVersion = n
+	}
+	level := query.Get("consistency")
+	if level == "" && minVersion > 0 {
+		level = string(service.Eventual)
+	}
+	c, err := service.ParseConsistency(level)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if v := query.Get("max_stale"); v != "" {
+		maxStale, err := time.ParseDuration(v)
+		if err != nil || maxStale < 0 {
+			writeError(w, http.StatusBadRequest, "invalid max_stale")
+			return
+		}
+		// A miss reads the primary, which always satisfies min_version
+		entry, hit, err := s.updater(r).GetCached(id, maxStale)
+		if err != nil {
+			setReadSource(w, false)
+			writeBalanceError(w, err)
+			return
+		}
+		if entry.Version >= minVersion {
+			source := "primary"
+			if hit {
+				source = "cache"
+			}
+			w.Header().Set("X-Read-Source", source)
+			w.Header().Set("ETag", etag(entry.Version))
+			writeBalance(w, http.StatusOK, balanceResponse{ID: id, Amount: entry.Amount, Version: entry.Version})
+			return
+		}
+	}
+
+	var balance models.Balance
+	fromReplica := false
+	if s.Reads != nil {
+		balance, fromReplica, err = s.Reads.Balance(r.Context(), id, c, minVersion)
+	} else {
+		balance, err = service.GetBalance(s.DB.WithContext(r.Context()), id)
+	}
+	setReadSource(w, fromReplica)
+	if err != nil {
+		writeBalanceError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(balance.Version))
+	writeBalance(w, http.StatusOK, balanceResponse{ID: balance.ID, Amount: balance.Amount, Version: balance.Version})
+}
+
+// patchBalance adds the delta from the body if If-Match names the current version
+func (s *Server) patchBalance(w http.ResponseWriter, r *http.Request) {
+	var req patchBalanceRequest
+	s.conditionalUpdate(w, r, &req, func(models.Balance) int64 { return req.Delta })
+}
+
+// putBalance sets the amount from the body if If-Match names the current version
+func (s *Server) putBalance(w http.ResponseWriter, r *http.Request) {
+	var req putBalanceRequest
+	s.conditionalUpdate(w, r, &req, func(current models.Balance) int64 { return req.Amount - current.Amount })
+}
+
+// conditionalUpdate decodes the body into req and applies the delta computed
+// from the balance read at the If-Match version
+func (s *Server) conditionalUpdate(w http.ResponseWriter, r *http.Request, req any, delta func(models.Balance) int64) {
+	id, ok := s.balanceID(w, r)
+	if !ok {
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		writeError(w, http.StatusPreconditionRequired, "If-Match header with the balance ETag is required")
+		return
+	}
+	version, ok := parseETag(ifMatch)
+	if !ok {
+		writeError(w, http.StatusPreconditionFailed, "If-Match does not name a balance version")
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if s.simulateConflict(w, id) {
+		return
+	}
+
+	current, err := service.GetBalance(s.DB.WithContext(r.Context()), id)
+	if err != nil {
+		writeBalanceError(w, err)
+		return
+	}
+	if current.Version != version {
+		w.Header().Set("ETag", etag(current.Version))
+		writeError(w, http.StatusPreconditionFailed, "balance has been modified")
+		return
+	}
+
+	start := time.Now()
+	outcome, err := s.updater(r).UpdateIfVersion(id, version, delta(current))
+	s.writeAttempts(w, outcome, time.Since(start))
+	if err != nil {
+		writeBalanceError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(outcome.Version))
+	writeBalance(w, http.StatusOK, balanceResponse{ID: id, Amount: outcome.NewAmount, Version: outcome.Version})
+}
+
+// writeAttempts reports how the update went in X-OptLock-Attempts, e.g.
+// "attempts=2; conflicts=1; elapsed=3.2ms", when AttemptHeader is set
+func (s *Server) writeAttempts(w http.ResponseWriter, outcome service.UpdateOutcome, elapsed time.Duration) {
+	if !s.AttemptHeader {
+		return
+	}
+	w.Header().Set("X-OptLock-Attempts", fmt.Sprintf("attempts=%d; conflicts=%d; elapsed=%s",
+		outcome.Attempts, outcome.Conflicts, elapsed.Round(time.Microsecond)))
+}
+
+// balanceID parses the {id} path value, which is either the integer ID or
+// the UID of the balance. A UID is resolved within the tenant of the request,
+// writing a 404 if no balance has it.
+func (s *Server) balanceID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	if s.DB == nil {
+		writeError(w, http.StatusNotFound, "balances are not enabled")
+		return 0, false
+	}
+	value := r.PathValue("id")
+	if id, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return uint(id), true
+	}
+	if value == "" || len(value) > maxUIDLength {
+		writeError(w, http.StatusBadRequest, "invalid balance id")
+		return 0, false
+	}
+	balance, err := service.GetBalanceByUID(s.DB.WithContext(r.Context()), value)
+	if err != nil {
+		writeBalanceError(w, err)
+		return 0, false
+	}
+	return balance.ID, true
+}
+
+// maxUIDLength is the size of the uid column
+const maxUIDLength = 36
+
+// updater returns the configured Updater or a default one on DB, with the
+// context of the request for its Authorizer and scoped to the tenant of the
+// request if it has one
+func (s *Server) updater(r *http.Request) *service.Updater {
+	u := s.Updater
+	if u == nil {
+		u = service.NewUpdater(s.DB)
+	}
+	return u.With(requestOptions(r.Context())...)
+}
+
+// requestOptions passes ctx to the Authorizer and scopes to its tenant, if any
+func requestOptions(ctx context.Context) []service.Option {
+	opts := []service.Option{service.WithContext(ctx)}
+	if id, ok := tenant.FromContext(ctx); ok {
+		opts = append(opts, service.WithTenant(id))
+	}
+	return opts
+}
+
+// writeBalanceError maps service errors to status codes
+func writeBalanceError(w http.ResponseWriter, err error) {
+	if delay, ok := service.RetryAfter(err); ok {
+		// Whole seconds, rounded up so the client never comes back early
+		w.Header().Set("Retry-After", strconv.Itoa(int(max(1, (delay+time.Second-1)/time.Second))))
+		writeError(w, http.StatusConflict, "balance is contended; retry later")
+		return
+	}
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		writeError(w, http.StatusNotFound, "balance not found")
+	case errors.Is(err, service.ErrForbidden):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, service.ErrConflict):
+		writeError(w, http.StatusPreconditionFailed, "balance has been modified")
+	case errors.Is(err, service.ErrLimitExceeded), errors.Is(err, service.ErrNotActive), errors.Is(err, service.ErrPolicyDenied):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, service.ErrRateLimited):
+		writeError(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, service.ErrCircuitOpen):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// etag formats a balance version as a strong ETag
+func etag(version int) string {
+	return fmt.Sprintf(`"v=%d"`, version)
+}
+
+// parseETag extracts the version from an If-Match value produced by etag
+func parseETag(value string) (int, bool) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, `"v=`) || !strings.HasSuffix(value, `"`) {
+		return 0, false
+	}
+	version, err := strconv.Atoi(value[3 : len(value)-1])
+	if err != nil {
+		return 0, false
+	}
+	return version, true
+}
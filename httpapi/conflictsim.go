@@ -0,0 +1,81 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// ConflictRules select the balance writes a ConflictSimulator rejects
+type ConflictRules struct {
+	BalanceIDs []uint `json:"balance_ids"` // every write to these balances
+	EveryNth   int    `json:"every_nth"`   // every Nth balance write, counted across balances; 0 disables
+}
+
+// ConflictSimulator rejects chosen balance writes with 409 and code ABORTED
+// before they reach the database, so client teams can exercise their retry
+// and idempotency handling without real contention. Serve it only in test
+// deployments.
+type ConflictSimulator struct {
+	mu     sync.Mutex
+	rules  ConflictRules
+	writes int
+}
+
+// NewConflictSimulator returns a simulator applying rules
+func NewConflictSimulator(rules ConflictRules) *ConflictSimulator {
+	return &ConflictSimulator{rules: rules}
+}
+
+// SetRules replaces the rules and restarts the every-Nth count
+func (c *ConflictSimulator) SetRules(rules ConflictRules) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules, c.writes = rules, 0
+}
+
+// Rules returns the current rules
+func (c *ConflictSimulator) Rules() ConflictRules {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rules
+}
+
+// conflict counts a write to balance id and reports whether to reject it
+func (c *ConflictSimulator) conflict(id uint) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes++
+	if slices.Contains(c.rules.BalanceIDs, id) {
+		return true
+	}
+	return c.rules.EveryNth > 0 && c.writes%c.rules.EveryNth == 0
+}
+
+// simulateConflict writes the simulated rejection and returns true if the
+// write to id should fail
+func (s *Server) simulateConflict(w http.ResponseWriter, id uint) bool {
+	if s.Conflicts == nil || !s.Conflicts.conflict(id) {
+		return false
+	}
+	w.Header().Set("X-Simulated-Conflict", "true")
+	writeError(w, http.StatusConflict, "simulated version conflict")
+	return true
+}
+
+// getConflictRules returns the simulator's rules
+func (s *Server) getConflictRules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Conflicts.Rules())
+}
+
+// putConflictRules replaces the simulator's rules from the body
+func (s *Server) putConflictRules(w http.ResponseWriter, r *http.Request) {
+	var rules ConflictRules
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil || rules.EveryNth < 0 {
+		writeError(w, http.StatusBadRequest, "invalid conflict rules")
+		return
+	}
+	s.Conflicts.SetRules(rules)
+	writeJSON(w, http.StatusOK, rules)
+}
@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// dedupResponse is the body of GET and PUT /admin/dedup
+type dedupResponse struct {
+	Applied       int64      `json:"applied"`
+	Duplicates    int64      `json:"duplicates"`
+	DuplicateRate float64    `json:"duplicate_rate"`
+	Purged        int64      `json:"purged"`
+	Stored        int64      `json:"stored"`
+	Oldest        *time.Time `json:"oldest,omitempty"`
+	TTL           string     `json:"ttl"`
+}
+
+// putDedupRequest is the body of PUT /admin/dedup
+type putDedupRequest struct {
+	TTL string `json:"ttl"` // a Go duration, e.g. 72h
+}
+
+func newDedupResponse(stats service.DedupStats) dedupResponse {
+	resp := dedupResponse{
+		Applied:       stats.Applied,
+		Duplicates:    stats.Duplicates,
+		DuplicateRate: stats.DuplicateRate(),
+		Purged:        stats.Purged,
+		Stored:        stats.Stored,
+		TTL:           stats.TTL.String(),
+	}
+	if !stats.Oldest.IsZero() {
+		resp.Oldest = &stats.Oldest
+	}
+	return resp
+}
+
+// getDedup returns the counters and size of the idempotency window
+func (s *Server) getDedup(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.Dedup.Stats()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, newDedupResponse(stats))
+}
+
+// putDedup changes the TTL of the window, applied by the next cleanup
+func (s *Server) putDedup(w http.ResponseWriter, r *http.Request) {
+	var req putDedupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil || ttl <= 0 {
+		writeError(w, http.StatusBadRequest, "ttl must be a positive duration")
+		return
+	}
+	s.Dedup.SetTTL(ttl)
+	s.getDedup(w, r)
+}
+
+// cleanupDedup deletes the keys past the TTL now, without waiting for the
+// periodic cleanup
+func (s *Server) cleanupDedup(w http.ResponseWriter, r *http.Request) {
+	purged, err := s.Dedup.Cleanup()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"purged": purged})
+}
@@ -0,0 +1,44 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// readyTimeout bounds the database ping behind GET /readyz
+const readyTimeout = 2 * time.Second
+
+// Drain makes GET /readyz fail so load balancers stop routing new requests
+// here while in-flight ones finish. It cannot be undone.
+func (s *Server) Drain() {
+	s.draining.Store(true)
+}
+
+// healthz reports that the process is serving. It does not check the
+// database, so an outage does not get healthy instances restarted.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz reports whether the server should receive traffic: it is not
+// draining and the database answers a ping
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+		return
+	}
+	if s.DB != nil {
+		sqlDB, err := s.DB.DB()
+		if err == nil {
+			ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+			defer cancel()
+			err = sqlDB.PingContext(ctx)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "database unavailable", "error": err.Error()})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// historyEntry is one change in the body of GET /balances/{id}/history
+type historyEntry struct {
+	Version   int       `json:"version"`
+	Kind      string    `json:"kind"`
+	Delta     int64     `json:"delta"`
+	Amount    int64     `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// historyResponse is the body of GET /balances/{id}/history
+type historyResponse struct {
+	Entries    []historyEntry `json:"entries"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// getHistory returns the changes of a balance made in [?since=, ?until=)
+// (RFC 3339), oldest first with the running balance after each. Pass
+// next_cursor back as ?cursor= for the next page; ?limit= sets the page size.
+func (s *Server) getHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.balanceID(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	var timeRange service.TimeRange
+	for name, dst := range map[string]*time.Time{"since": &timeRange.Since, "until": &timeRange.Until} {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid "+name+"; use RFC 3339")
+				return
+			}
+			*dst = t
+		}
+	}
+	page := service.Pagination{Cursor: query.Get("cursor")}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		page.Limit = n
+	}
+
+	db, ok := s.readDB(w, r)
+	if !ok {
+		return
+	}
+	history, err := service.GetHistory(db, id, timeRange, page)
+	if errors.Is(err, service.ErrInvalidCursor) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeBalanceError(w, err)
+		return
+	}
+
+	resp := historyResponse{Entries: make([]historyEntry, len(history.Entries)), NextCursor: history.Next}
+	for i, e := range history.Entries {
+		resp.Entries[i] = historyEntry(e)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,25 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// defaultHotKeys is the number of balances GET /admin/hot-keys returns
+// without ?limit=
+const defaultHotKeys = 10
+
+// getHotKeys returns the balances with the most conflicts over the window of
+// the contention monitor, most first
+func (s *Server) getHotKeys(w http.ResponseWriter, r *http.Request) {
+	limit := defaultHotKeys
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, http.StatusOK, s.HotKeys.HotKeys(limit))
+}
@@ -0,0 +1,85 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// listedBalance is one balance in the body of GET /balances
+type listedBalance struct {
+	ID       uint   `json:"id"`
+	OwnerID  string `json:"owner_id,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	Amount   int64  `json:"amount"`
+	Version  int    `json:"version"`
+}
+
+// listBalancesResponse is the body of GET /balances
+type listBalancesResponse struct {
+	Balances   []listedBalance `json:"balances"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// listBalances returns a page of balances filtered by ?owner_id=, ?currency=,
+// ?min_amount= and ?max_amount=, sorted by ?order= (id or amount). Pass
+// next_cursor back as ?cursor= for the next page; ?limit= sets the page size.
+// The versions can be sent as If-Match to PATCH and PUT /balances/{id}.
+func (s *Server) listBalances(w http.ResponseWriter, r *http.Request) {
+	if s.DB == nil {
+		writeError(w, http.StatusNotFound, "balances are not enabled")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := service.BalanceFilter{OwnerID: query.Get("owner_id"), Currency: query.Get("currency")}
+	page := service.Pagination{Order: service.ListOrder(query.Get("order")), Cursor: query.Get("cursor")}
+	for name, dst := range map[string]**int64{"min_amount": &filter.MinAmount, "max_amount": &filter.MaxAmount} {
+		if v := query.Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid "+name)
+				return
+			}
+			*dst = &n
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		page.Limit = n
+	}
+	if page.Order != "" && page.Order != service.ByID && page.Order != service.ByAmount {
+		writeError(w, http.StatusBadRequest, "order must be id or amount")
+		return
+	}
+
+	db, ok := s.readDB(w, r)
+	if !ok {
+		return
+	}
+	result, err := service.ListBalances(db, filter, page)
+	if errors.Is(err, service.ErrInvalidCursor) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := listBalancesResponse{Balances: make([]listedBalance, 0, len(result.Balances)), NextCursor: result.Next}
+	for _, b := range result.Balances {
+		item := listedBalance{ID: b.ID, Currency: b.Currency, Amount: b.Amount, Version: b.Version}
+		if b.OwnerID != nil {
+			item.OwnerID = *b.OwnerID
+		}
+		resp.Balances = append(resp.Balances, item)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,90 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// metadataResponse is the JSON body of the metadata endpoints
+type metadataResponse struct {
+	ID       uint            `json:"id"`
+	Version  int             `json:"version"`
+	Metadata models.Metadata `json:"metadata"`
+}
+
+// getMetadata returns the metadata of the balance with its version as the ETag
+func (s *Server) getMetadata(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.balanceID(w, r)
+	if !ok {
+		return
+	}
+	balance, err := service.GetBalance(s.DB.WithContext(r.Context()), id)
+	if err != nil {
+		writeBalanceError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(balance.Version))
+	writeJSON(w, http.StatusOK, metadataResponse{ID: id, Version: balance.Version, Metadata: orEmpty(balance.Metadata)})
+}
+
+// patchMetadata applies the JSON merge patch in the body to the metadata if
+// If-Match names the current version
+func (s *Server) patchMetadata(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.balanceID(w, r)
+	if !ok {
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		writeError(w, http.StatusPreconditionRequired, "If-Match header with the balance ETag is required")
+		return
+	}
+	version, ok := parseETag(ifMatch)
+	if !ok {
+		writeError(w, http.StatusPreconditionFailed, "If-Match does not name a balance version")
+		return
+	}
+
+	var patch map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		writeError(w, http.StatusBadRequest, "body must be a JSON object")
+		return
+	}
+
+	current, err := service.GetBalance(s.DB.WithContext(r.Context()), id)
+	if err != nil {
+		writeBalanceError(w, err)
+		return
+	}
+	if current.Version != version {
+		w.Header().Set("ETag", etag(current.Version))
+		writeError(w, http.StatusPreconditionFailed, "balance has been modified")
+		return
+	}
+
+	balance, err := s.updater(r).PatchMetadata(current, patch)
+	var conflict *service.MetadataConflictError
+	if errors.As(err, &conflict) {
+		writeError(w, http.StatusPreconditionFailed, conflict.Error())
+		return
+	}
+	if err != nil {
+		writeBalanceError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(balance.Version))
+	writeJSON(w, http.StatusOK, metadataResponse{ID: id, Version: balance.Version, Metadata: orEmpty(balance.Metadata)})
+}
+
+// orEmpty returns m, or an empty object for a balance without metadata
+func orEmpty(m models.Metadata) models.Metadata {
+	if m == nil {
+		return models.Metadata{}
+	}
+	return m
+}
@@ -0,0 +1,93 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/service"
+	"github.com/ghozilaaa/optimistic-lock/tenant"
+)
+
+// operationResponse is the JSON body of GET /operations/{id}
+type operationResponse struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	BalanceID   uint       `json:"balance_id"`
+	Delta       int64      `json:"delta"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// submitUpdateRequest is the body of POST /balances/{id}/updates
+type submitUpdateRequest struct {
+	Delta int64 `json:"delta"`
+}
+
+// submitUpdate queues a delta for the async workers and answers 202 with the
+// operation to poll in Location
+func (s *Server) submitUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.balanceID(w, r)
+	if !ok {
+		return
+	}
+	var req submitUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	// The update outlives the request, but keeps its values for the Authorizer
+	opID, err := s.Async.SubmitTracked(s.Operations, id, req.Delta, requestOptions(context.WithoutCancel(r.Context()))...)
+	if errors.Is(err, service.ErrQueueFull) || errors.Is(err, service.ErrAsyncUpdaterClosed) {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	op, _ := s.Operations.Get(opID)
+	w.Header().Set("Location", "/operations/"+opID)
+	writeJSON(w, http.StatusAccepted, newOperationResponse(op))
+}
+
+// getOperation reports whether an asynchronous operation is pending,
+// succeeded or failed. Another tenant's operation is not found.
+func (s *Server) getOperation(w http.ResponseWriter, r *http.Request) {
+	if s.Operations == nil {
+		writeError(w, http.StatusNotFound, "operation tracking is not enabled")
+		return
+	}
+
+	op, ok := s.Operations.Get(r.PathValue("id"))
+	if id, scoped := tenant.FromContext(r.Context()); ok && scoped && op.TenantID != id {
+		ok = false
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "operation not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newOperationResponse(op))
+}
+
+func newOperationResponse(op service.Operation) operationResponse {
+	resp := operationResponse{
+		ID:        op.ID,
+		Status:    string(op.Status),
+		BalanceID: op.BalanceID,
+		Delta:     op.Delta,
+		Error:     op.Error,
+		CreatedAt: op.CreatedAt,
+	}
+	if !op.CompletedAt.IsZero() {
+		resp.CompletedAt = &op.CompletedAt
+	}
+	return resp
+}
@@ -0,0 +1,152 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// prepareRequest is the body of POST /balances/{id}/prepare
+type prepareRequest struct {
+	Delta     int64  `json:"delta"`
+	ExpiresIn string `json:"expires_in"` // TTL as a Go duration; service.DefaultPrepareTTL if empty
+}
+
+// preparedResponse is the JSON body of the prepared update endpoints
+type preparedResponse struct {
+	Token           string     `json:"token"`
+	BalanceID       uint       `json:"balance_id"`
+	Delta           int64      `json:"delta"`
+	ExpectedVersion int        `json:"expected_version"`
+	Status          string     `json:"status"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	ResolvedAt      *time.Time `json:"resolved_at,omitempty"`
+}
+
+func newPreparedResponse(p models.PreparedUpdate) preparedResponse {
+	return preparedResponse{
+		Token:           p.ID,
+		BalanceID:       p.BalanceID,
+		Delta:           p.Delta,
+		ExpectedVersion: p.ExpectedVersion,
+		Status:          p.Status,
+		ExpiresAt:       p.ExpiresAt,
+		ResolvedAt:      p.ResolvedAt,
+	}
+}
+
+// prepareBalance pins the delta from the body to the balance version named
+// by If-Match, or to the current version without it, and returns the token
+// to commit or abort it with
+func (s *Server) prepareBalance(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.balanceID(w, r)
+	if !ok {
+		return
+	}
+	var req prepareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	var ttl time.Duration
+	if req.ExpiresIn != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.ExpiresIn); err != nil || ttl <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid expires_in")
+			return
+		}
+	}
+
+	var prepared models.PreparedUpdate
+	var err error
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		version, ok := parseETag(ifMatch)
+		if !ok {
+			writeError(w, http.StatusPreconditionFailed, "If-Match does not name a balance version")
+			return
+		}
+		prepared, err = s.updater(r).PrepareIfVersion(id, version, req.Delta, ttl)
+	} else {
+		prepared, err = s.updater(r).Prepare(id, req.Delta, ttl)
+	}
+	if err != nil {
+		writeBalanceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newPreparedResponse(prepared))
+}
+
+// getPrepared returns a prepared update and whether it is still pending
+func (s *Server) getPrepared(w http.ResponseWriter, r *http.Request) {
+	if s.DB == nil {
+		writeError(w, http.StatusNotFound, "balances are not enabled")
+		return
+	}
+	prepared, err := service.GetPrepared(s.DB.WithContext(r.Context()), r.PathValue("token"))
+	if err != nil {
+		writePreparedError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPreparedResponse(prepared))
+}
+
+// commitPrepared applies a prepared update and returns the balance. A
+// balance written since the prepare, an expired prepared update and a
+// repeated commit get 412.
+func (s *Server) commitPrepared(w http.ResponseWriter, r *http.Request) {
+	if s.DB == nil {
+		writeError(w, http.StatusNotFound, "balances are not enabled")
+		return
+	}
+	token := r.PathValue("token")
+	start := time.Now()
+	outcome, err := s.updater(r).CommitPrepared(token)
+	s.writeAttempts(w, outcome, time.Since(start))
+	if err != nil {
+		writePreparedError(w, err)
+		return
+	}
+	prepared, err := service.GetPrepared(s.DB.WithContext(r.Context()), token)
+	if err != nil {
+		writePreparedError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(outcome.Version))
+	writeBalance(w, http.StatusOK, balanceResponse{ID: prepared.BalanceID, Amount: outcome.NewAmount, Version: outcome.Version})
+}
+
+// abortPrepared discards a prepared update; aborting one that was already
+// aborted or expired succeeds, aborting a committed one gets 412
+func (s *Server) abortPrepared(w http.ResponseWriter, r *http.Request) {
+	if s.DB == nil {
+		writeError(w, http.StatusNotFound, "balances are not enabled")
+		return
+	}
+	token := r.PathValue("token")
+	if err := s.updater(r).AbortPrepared(token); err != nil {
+		writePreparedError(w, err)
+		return
+	}
+	prepared, err := service.GetPrepared(s.DB.WithContext(r.Context()), token)
+	if err != nil {
+		writePreparedError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPreparedResponse(prepared))
+}
+
+// writePreparedError maps the errors of the prepared update endpoints to a status
+func writePreparedError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrPreparedNotFound):
+		writeError(w, http.StatusNotFound, "prepared update not found")
+	case errors.Is(err, service.ErrPreparedExpired), errors.Is(err, service.ErrPreparedResolved):
+		writeError(w, http.StatusPreconditionFailed, err.Error())
+	default:
+		writeBalanceError(w, err)
+	}
+}
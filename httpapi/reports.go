@@ -0,0 +1,45 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/velocity"
+)
+
+// getVelocityReport returns per-balance debit/credit velocity over ?window=
+// (default 24h). With ?flagged=true only balances breaking a rule are listed.
+func (s *Server) getVelocityReport(w http.ResponseWriter, r *http.Request) {
+	if s.DB == nil {
+		writeError(w, http.StatusNotFound, "reports are not enabled")
+		return
+	}
+
+	window := 24 * time.Hour
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid window")
+			return
+		}
+		window = d
+	}
+
+	db, ok := s.readDB(w, r)
+	if !ok {
+		return
+	}
+	report, err := velocity.Build(db, window, s.VelocityRules)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if r.URL.Query().Get("flagged") == "true" {
+		report.Accounts = report.Flagged()
+	}
+	if report.Accounts == nil {
+		report.Accounts = []velocity.Account{}
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,195 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/service"
+	"github.com/ghozilaaa/optimistic-lock/tenant"
+	"github.com/ghozilaaa/optimistic-lock/velocity"
+	"github.com/ghozilaaa/optimistic-lock/webhook"
+)
+
+// Server exposes the balance service over HTTP
+type Server struct {
+	// Operations records the updates queued on Async for GET /operations/{id}
+	Operations *service.OperationRegistry
+
+	// Async, with Operations and DB, serves POST /balances/{id}/updates,
+	// which queues the update and answers 202 with the operation to poll
+	Async *service.AsyncUpdater
+
+	// DB enables the /balances endpoints and, with VelocityRules, GET /reports/velocity
+	DB            *gorm.DB
+	VelocityRules velocity.Rules
+
+	// Updater applies balance writes; defaults to service.NewUpdater(DB).
+	// Its Authorizer gets the context of the request, so a middleware can
+	// put the authenticated caller there.
+	Updater *service.Updater
+
+	// Reads routes read endpoints to replicas by their ?consistency=
+	// parameter; without it every read goes to DB
+	Reads *service.ReadRouter
+
+	// PaymentWebhooks verifies deliveries to POST /webhooks/payments, which
+	// is only served when it and DB are set
+	PaymentWebhooks *webhook.Verifier
+
+	// Dedup, when set, applies the payment webhooks through it, so duplicate
+	// deliveries are counted and its keys bound the idempotency window
+	Dedup *service.Deduplicator
+
+	// DedupAdmin, with Dedup, serves GET and PUT /admin/dedup to inspect the
+	// window and change its TTL, and POST /admin/dedup/cleanup to purge it
+	// now. Keep it off public listeners.
+	DedupAdmin bool
+
+	// Conflicts, when set, rejects chosen balance writes with a simulated
+	// conflict and serves GET and PUT /testing/conflicts to change the rules.
+	// Test deployments only.
+	Conflicts *ConflictSimulator
+
+	// Workers, when set, serves GET and PUT /admin/workers to inspect and
+	// resize GOMAXPROCS and the worker pools. Keep it off public listeners.
+	Workers *Workers
+
+	// HotKeys, when set, serves GET /admin/hot-keys with the balances that
+	// conflicted most recently. Keep it off public listeners.
+	HotKeys *service.ContentionMonitor
+
+	// AttemptHeader adds X-OptLock-Attempts to balance writes with the
+	// attempts, conflicts and elapsed time of the update, so clients can
+	// line their latency up with the server's retries. Debug and staging
+	// deployments only.
+	AttemptHeader bool
+
+	// TenantHeader names the request header carrying the tenant, e.g.
+	// X-Tenant-ID. When set, the balance, prepared update, report and
+	// webhook endpoints reject requests without it and only see the balances
+	// of that tenant.
+	TenantHeader string
+
+	draining atomic.Bool
+}
+
+// Handler returns the HTTP routes
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("GET /readyz", s.readyz)
+	mux.HandleFunc("GET /operations/{id}", s.scoped(s.getOperation))
+	mux.HandleFunc("GET /balances", s.scoped(s.listBalances))
+	mux.HandleFunc("GET /balances/{id}", s.scoped(s.getBalance))
+	mux.HandleFunc("PATCH /balances/{id}", s.scoped(s.patchBalance))
+	mux.HandleFunc("PUT /balances/{id}", s.scoped(s.putBalance))
+	mux.HandleFunc("GET /balances/{id}/history", s.scoped(s.getHistory))
+	mux.HandleFunc("GET /balances/{id}/metadata", s.scoped(s.getMetadata))
+	mux.HandleFunc("PATCH /balances/{id}/metadata", s.scoped(s.patchMetadata))
+	mux.HandleFunc("POST /balances/{id}/validate", s.scoped(s.validateBalanceUpdate))
+	mux.HandleFunc("POST /balances/{id}/prepare", s.scoped(s.prepareBalance))
+	mux.HandleFunc("GET /prepared/{token}", s.scoped(s.getPrepared))
+	mux.HandleFunc("POST /prepared/{token}/commit", s.scoped(s.commitPrepared))
+	mux.HandleFunc("POST /prepared/{token}/abort", s.scoped(s.abortPrepared))
+	mux.HandleFunc("GET /reports/velocity", s.scoped(s.getVelocityReport))
+	if s.Async != nil && s.Operations != nil {
+		mux.HandleFunc("POST /balances/{id}/updates", s.scoped(s.submitUpdate))
+	}
+	if s.PaymentWebhooks != nil && s.DB != nil {
+		mux.Handle("POST /webhooks/payments", s.PaymentWebhooks.Middleware(s.scoped(s.paymentWebhook)))
+	}
+	if s.Conflicts != nil {
+		mux.HandleFunc("GET /testing/conflicts", s.getConflictRules)
+		mux.HandleFunc("PUT /testing/conflicts", s.putConflictRules)
+	}
+	if s.Workers != nil {
+		mux.HandleFunc("GET /admin/workers", s.getWorkers)
+		mux.HandleFunc("PUT /admin/workers", s.putWorkers)
+	}
+	if s.HotKeys != nil {
+		mux.HandleFunc("GET /admin/hot-keys", s.getHotKeys)
+	}
+	if s.Dedup != nil && s.DedupAdmin {
+		mux.HandleFunc("GET /admin/dedup", s.getDedup)
+		mux.HandleFunc("PUT /admin/dedup", s.putDedup)
+		mux.HandleFunc("POST /admin/dedup/cleanup", s.cleanupDedup)
+	}
+	return mux
+}
+
+// scoped puts the tenant named by TenantHeader into the request context,
+// writing 400 if the header is missing. Without TenantHeader it returns h.
+func (s *Server) scoped(h http.HandlerFunc) http.HandlerFunc {
+	if s.TenantHeader == "" {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(s.TenantHeader)
+		if id == "" {
+			writeError(w, http.StatusBadRequest, s.TenantHeader+" header is required")
+			return
+		}
+		h(w, r.WithContext(tenant.With(r.Context(), id)))
+	}
+}
+
+// readDB returns the connection for a read at the request's ?consistency=
+// level (default strong) and reports it in X-Read-Source. It writes 400 and
+// returns false for an unknown level.
+func (s *Server) readDB(w http.ResponseWriter, r *http.Request) (*gorm.DB, bool) {
+	c, err := service.ParseConsistency(r.URL.Query().Get("consistency"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	db, fromReplica := s.DB, false
+	if s.Reads != nil {
+		db, fromReplica = s.Reads.DB(r.Context(), c)
+	}
+	setReadSource(w, fromReplica)
+	return db.WithContext(r.Context()), true
+}
+
+// setReadSource reports in X-Read-Source where a read was served from
+func setReadSource(w http.ResponseWriter, fromReplica bool) {
+	source := "primary"
+	if fromReplica {
+		source = "replica"
+	}
+	w.Header().Set("X-Read-Source", source)
+}
+
+// writeJSON writes v with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// errorCodes name the reason for an error status in the body, so clients can
+// branch on it without parsing messages
+var errorCodes = map[int]string{
+	http.StatusBadRequest:           "INVALID_ARGUMENT",
+	http.StatusForbidden:            "PERMISSION_DENIED",
+	http.StatusNotFound:             "NOT_FOUND",
+	http.StatusConflict:             "ABORTED", // rejected before anything was written; resend as is
+	http.StatusPreconditionFailed:   "FAILED_PRECONDITION",
+	http.StatusPreconditionRequired: "FAILED_PRECONDITION",
+	http.StatusUnprocessableEntity:  "FAILED_PRECONDITION", // balance limit or status refuses it; do not resend as is
+	http.StatusTooManyRequests:      "RESOURCE_EXHAUSTED",
+	http.StatusInternalServerError:  "INTERNAL",
+	http.StatusServiceUnavailable:   "UNAVAILABLE",
+}
+
+// writeError writes a JSON error body with the message and the code for status
+func writeError(w http.ResponseWriter, status int, msg string) {
+	body := map[string]string{"error": msg}
+	if code, ok := errorCodes[status]; ok {
+		body["code"] = code
+	}
+	writeJSON(w, status, body)
+}
@@ -0,0 +1,54 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// validationResponse is the JSON body of POST /balances/{id}/validate
+type validationResponse struct {
+	ID         uint     `json:"id"`
+	Amount     int64    `json:"amount"`
+	Version    int      `json:"version"`
+	NewAmount  int64    `json:"new_amount"`
+	NewVersion int      `json:"new_version"`
+	Valid      bool     `json:"valid"`
+	Violations []string `json:"violations,omitempty"`
+}
+
+// validateBalanceUpdate reports what adding the delta from the body would do,
+// without writing. Broken rules are part of the 200 response, not errors.
+func (s *Server) validateBalanceUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.balanceID(w, r)
+	if !ok {
+		return
+	}
+	var req patchBalanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	v, err := service.ValidateUpdate(s.DB.WithContext(r.Context()), id, req.Delta)
+	var violations []string
+	if errors.Is(err, service.ErrInsufficientFunds) || errors.Is(err, service.ErrLimitExceeded) {
+		for _, violation := range err.(interface{ Unwrap() []error }).Unwrap() {
+			violations = append(violations, violation.Error())
+		}
+	} else if err != nil {
+		writeBalanceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, validationResponse{
+		ID:         v.BalanceID,
+		Amount:     v.Amount,
+		Version:    v.Version,
+		NewAmount:  v.NewAmount,
+		NewVersion: v.NewVersion,
+		Valid:      len(violations) == 0,
+		Violations: violations,
+	})
+}
@@ -0,0 +1,39 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// paymentWebhookRequest is the body of POST /webhooks/payments
+type paymentWebhookRequest struct {
+	Reference string `json:"reference"`
+	BalanceID uint   `json:"balance_id"`
+	Delta     int64  `json:"delta"`
+}
+
+// paymentWebhook applies a payment provider notification as an idempotent
+// adjustment keyed by the provider's reference. The signature and replay
+// checks run in the webhook middleware before this handler. With Dedup, the
+// duplicates are counted there.
+func (s *Server) paymentWebhook(w http.ResponseWriter, r *http.Request) {
+	var req paymentWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Reference == "" || req.BalanceID == 0 {
+		writeError(w, http.StatusBadRequest, "reference, balance_id and delta are required")
+		return
+	}
+
+	updater := s.updater(r)
+	var applied bool
+	var err error
+	if s.Dedup != nil {
+		applied, err = s.Dedup.ApplyWith(updater, req.Reference, req.BalanceID, req.Delta)
+	} else {
+		applied, err = updater.ApplyAdjustment(req.Reference, req.BalanceID, req.Delta)
+	}
+	if err != nil {
+		writeBalanceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"applied": applied})
+}
@@ -0,0 +1,67 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// Workers exposes the scheduler and the worker pools to GET and PUT
+// /admin/workers, so a deployment can be resized without a restart. Pools
+// left nil are not reported and cannot be resized.
+type Workers struct {
+	Serializer *service.KeyedSerializer
+	Async      *service.AsyncUpdater
+	CPUQuota   float64 // detected container quota in CPUs, 0 when unlimited
+}
+
+// workerSizes is the body of /admin/workers. In a PUT, zero leaves a size unchanged.
+type workerSizes struct {
+	MaxProcs          int     `json:"gomaxprocs"`
+	CPUQuota          float64 `json:"cpu_quota,omitempty"`
+	SerializerStripes int     `json:"serializer_stripes,omitempty"`
+	AsyncWorkers      int     `json:"async_workers,omitempty"`
+}
+
+func (w *Workers) sizes() workerSizes {
+	sizes := workerSizes{MaxProcs: runtime.GOMAXPROCS(0), CPUQuota: w.CPUQuota}
+	if w.Serializer != nil {
+		sizes.SerializerStripes = w.Serializer.Stripes()
+	}
+	if w.Async != nil {
+		sizes.AsyncWorkers = w.Async.Workers()
+	}
+	return sizes
+}
+
+// getWorkers returns GOMAXPROCS, the CPU quota and the pool sizes
+func (s *Server) getWorkers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Workers.sizes())
+}
+
+// putWorkers resizes GOMAXPROCS and the pools given in the body
+func (s *Server) putWorkers(w http.ResponseWriter, r *http.Request) {
+	var req workerSizes
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil ||
+		req.MaxProcs < 0 || req.SerializerStripes < 0 || req.AsyncWorkers < 0 {
+		writeError(w, http.StatusBadRequest, "invalid worker sizes")
+		return
+	}
+	if (req.SerializerStripes > 0 && s.Workers.Serializer == nil) || (req.AsyncWorkers > 0 && s.Workers.Async == nil) {
+		writeError(w, http.StatusBadRequest, "pool is not enabled")
+		return
+	}
+
+	if req.MaxProcs > 0 {
+		runtime.GOMAXPROCS(req.MaxProcs)
+	}
+	if req.SerializerStripes > 0 {
+		s.Workers.Serializer.Resize(req.SerializerStripes)
+	}
+	if req.AsyncWorkers > 0 {
+		s.Workers.Async.Resize(req.AsyncWorkers)
+	}
+	writeJSON(w, http.StatusOK, s.Workers.sizes())
+}
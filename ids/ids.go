@@ -0,0 +1,115 @@
+// Package ids generates the UIDs of balances: globally unique identifiers,
+// such as UUIDs or ULIDs, that services sharding their data can use instead
+// of the sequential integer ID. The integer stays the primary key the
+// version-checked writes address rows by; the UID is an alternate key.
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Generator returns a new UID
+type Generator func() string
+
+// UUID returns a random (version 4) UUID
+func UUID() string { return uuid.NewString() }
+
+// UUIDv7 returns a time-ordered (version 7) UUID, which keeps index inserts
+// local like a sequence does
+func UUIDv7() string { return uuid.Must(uuid.NewV7()).String() }
+
+// crockford is the alphabet of ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID returns a ULID: 26 characters encoding a millisecond timestamp and 80
+// random bits, sorting by creation time
+func ULID() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16)
+	rand.Read(b[6:])
+
+	// 128 bits as 26 groups of 5, the first group holding the top 3 bits
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	var s [26]byte
+	for i := 25; i >= 0; i-- {
+		s[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
+
+var generators = map[string]Generator{"uuid": UUID, "uuidv7": UUIDv7, "ulid": ULID}
+
+// ByName returns the generator called name: uuid, uuidv7 or ulid
+func ByName(name string) (Generator, bool) {
+	g, ok := generators[name]
+	return g, ok
+}
+
+// Names returns the names ByName takes, sorted
+func Names() []string {
+	names := make([]string, 0, len(generators))
+	for name := range generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// field is the field Plugin fills in
+const field = "UID"
+
+// Plugin sets the UID field of created rows that have none, on every model
+// with one, e.g. models.Balance. A model can also set its own in BeforeCreate.
+type Plugin struct {
+	Generate Generator
+}
+
+// Name implements gorm.Plugin
+func (Plugin) Name() string { return "ids" }
+
+// Initialize implements gorm.Plugin
+func (p Plugin) Initialize(db *gorm.DB) error {
+	if p.Generate == nil {
+		return errors.New("ids: plugin without a generator")
+	}
+	return db.Callback().Create().Before("gorm:create").Register("ids:assign", p.assign)
+}
+
+// assign sets the UIDs of the created rows
+func (p Plugin) assign(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+	f := db.Statement.Schema.LookUpField(field)
+	if f == nil {
+		return
+	}
+	ctx := db.Statement.Context
+	set := func(rv reflect.Value) {
+		if _, zero := f.ValueOf(ctx, rv); !zero {
+			return
+		}
+		if err := f.Set(ctx, rv, p.Generate()); err != nil {
+			db.AddError(fmt.Errorf("ids: %w", err))
+		}
+	}
+	switch rv := db.Statement.ReflectValue; rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			set(reflect.Indirect(rv.Index(i)))
+		}
+	case reflect.Struct:
+		set(rv)
+	}
+}
@@ -0,0 +1,23 @@
+package backoff
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Exponential returns the sleep before retrying after the given attempt:
+// base * 2^(attempt-1) with up to +-50% jitter.
+func Exponential(rnd *rand.Rand, base time.Duration, attempt int) time.Duration {
+	// backoff = baseBackoff * 2^(attempt-1)
+	backoff := base * (1 << (attempt - 1))
+	if backoff <= 0 {
+		return 0
+	}
+	// add jitter up to +-50%
+	jitter := time.Duration(rnd.Int63n(int64(backoff))) - backoff/2
+	sleep := backoff + jitter
+	if sleep < 0 {
+		sleep = 0
+	}
+	return sleep
+}
@@ -0,0 +1,307 @@
+package clientgen
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+// goInitialisms are kept upper case in Go identifiers
+var goInitialisms = map[string]string{"id": "ID", "url": "URL", "http": "HTTP", "api": "API"}
+
+// GenerateGo returns a gofmt'ed Go client for spec in package pkg
+func GenerateGo(spec *Spec, pkg, source string) ([]byte, error) {
+	var b strings.Builder
+	for _, name := range spec.SchemaNames() {
+		writeGoStruct(&b, name, spec.Components.Schemas[name])
+	}
+	b.WriteString(goRuntime)
+	for _, op := range spec.Operations() {
+		if err := writeGoMethod(&b, op); err != nil {
+			return nil, err
+		}
+	}
+
+	imports := []string{"bytes", "context", "encoding/json", "fmt", "io", "net/http", "net/url"}
+	if strings.Contains(b.String(), "time.Time") {
+		imports = append(imports, "time")
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "// Code generated by clientgen from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&out, "// Package %s is a client for the %s %s.\n", pkg, spec.Info.Title, spec.Info.Version)
+	fmt.Fprintf(&out, "package %s\n\nimport (\n", pkg)
+	for _, imp := range imports {
+		fmt.Fprintf(&out, "\t%q\n", imp)
+	}
+	out.WriteString(")\n\n")
+	out.WriteString(b.String())
+
+	return format.Source([]byte(out.String()))
+}
+
+func writeGoStruct(b *strings.Builder, name string, s *Schema) {
+	if s.Description != "" {
+		fmt.Fprintf(b, "// %s %s\n", name, s.Description)
+	}
+	fmt.Fprintf(b, "type %s struct {\n", name)
+	for _, prop := range sortedKeys(s.Properties) {
+		p := s.Properties[prop]
+		typ := goType(p)
+		tag := prop
+		if !s.IsRequired(prop) {
+			tag += ",omitempty"
+			if p.Format == "date-time" || p.Ref != "" {
+				typ = "*" + typ
+			}
+		}
+		comment := ""
+		if p.Description != "" {
+			comment = " // " + p.Description
+		}
+		fmt.Fprintf(b, "\t%s %s `json:\"%s\"`%s\n", goName(prop), typ, tag, comment)
+	}
+	b.WriteString("}\n\n")
+}
+
+func writeGoMethod(b *strings.Builder, op *Operation) error {
+	name := goName(op.OperationID)
+	result := op.ResultSchema()
+
+	params := []string{"ctx context.Context"}
+	var setup []string
+	for _, p := range op.Parameters {
+		arg := goArg(p.Name)
+		params = append(params, arg+" "+goType(p.Schema))
+		switch p.In {
+		case "path":
+		case "query":
+			cond := arg + " != " + goZero(p.Schema)
+			if p.Schema.Type == "boolean" {
+				cond = arg
+			}
+			setup = append(setup, fmt.Sprintf("if %s {\n\tquery.Set(%q, fmt.Sprint(%s))\n}", cond, p.Name, arg))
+		case "header":
+			setup = append(setup, fmt.Sprintf("header.Set(%q, %s)", p.Name, arg))
+		default:
+			return fmt.Errorf("%s: unsupported parameter location %q", op.OperationID, p.In)
+		}
+	}
+	body := "nil"
+	if s := op.BodySchema(); s != nil {
+		params = append(params, "body "+goType(s))
+		body = "body"
+	}
+
+	returns := "error"
+	if result != nil {
+		returns = "(*" + goType(result) + ", error)"
+	}
+
+	if op.Summary != "" {
+		fmt.Fprintf(b, "// %s %s\n", name, lowerFirst(op.Summary))
+	}
+	fmt.Fprintf(b, "func (c *Client) %s(%s) %s {\n", name, strings.Join(params, ", "), returns)
+	fmt.Fprintf(b, "\tpath := %s\n", goPath(op))
+	b.WriteString("\tquery := url.Values{}\n\theader := http.Header{}\n")
+	for _, s := range setup {
+		b.WriteString(s + "\n")
+	}
+	if result == nil {
+		fmt.Fprintf(b, "\treturn c.do(ctx, %q, path, query, header, %s, nil)\n}\n\n", op.Method, body)
+		return nil
+	}
+	fmt.Fprintf(b, "\tvar out %s\n", goType(result))
+	fmt.Fprintf(b, "\tif err := c.do(ctx, %q, path, query, header, %s, &out); err != nil {\n\t\treturn nil, err\n\t}\n", op.Method, body)
+	b.WriteString("\treturn &out, nil\n}\n\n")
+	return nil
+}
+
+// goPath returns a Go expression building the request path
+func goPath(op *Operation) string {
+	expr := fmt.Sprintf("%q", op.Path)
+	for _, p := range op.Parameters {
+		if p.In == "path" {
+			placeholder := "{" + p.Name + "}"
+			expr = strings.Replace(expr, placeholder, `" + url.PathEscape(fmt.Sprint(`+goArg(p.Name)+`)) + "`, 1)
+		}
+	}
+	return strings.TrimSuffix(expr, ` + ""`)
+}
+
+func goType(s *Schema) string {
+	if s.Ref != "" {
+		return refName(s.Ref)
+	}
+	switch s.Type {
+	case "integer":
+		if s.Format == "int32" {
+			return "int32"
+		}
+		return "int64"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "[]" + goType(s.Items)
+	case "object":
+		return "map[string]any"
+	case "string":
+		if s.Format == "date-time" {
+			return "time.Time"
+		}
+	}
+	return "string"
+}
+
+func goZero(s *Schema) string {
+	switch goType(s) {
+	case "string":
+		return `""`
+	case "int32", "int64", "float64":
+		return "0"
+	}
+	return "nil"
+}
+
+// goName converts snake_case, kebab-case or camelCase to an exported Go name
+func goName(s string) string {
+	var out strings.Builder
+	for _, word := range splitWords(s) {
+		if up, ok := goInitialisms[strings.ToLower(word)]; ok {
+			out.WriteString(up)
+			continue
+		}
+		out.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return out.String()
+}
+
+// goArg converts a parameter name to an unexported Go identifier
+func goArg(s string) string {
+	words := splitWords(s)
+	name := strings.ToLower(words[0])
+	for _, w := range words[1:] {
+		name += goName(w)
+	}
+	return name
+}
+
+// splitWords splits on _, - and lower-to-upper case changes
+func splitWords(s string) []string {
+	var words []string
+	start := 0
+	for i := 1; i <= len(s); i++ {
+		switch {
+		case i == len(s):
+			words = append(words, s[start:i])
+		case s[i] == '_' || s[i] == '-':
+			words = append(words, s[start:i])
+			start = i + 1
+		case s[i] >= 'A' && s[i] <= 'Z' && s[i-1] >= 'a' && s[i-1] <= 'z':
+			words = append(words, s[start:i])
+			start = i
+		}
+	}
+	var out []string
+	for _, w := range words {
+		if w != "" {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+const goRuntime = `// Client calls the API at BaseURL
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// New returns a Client for baseURL using http.DefaultClient
+func New(baseURL string) *Client {
+	return &Client{BaseURL: baseURL, HTTPClient: http.DefaultClient}
+}
+
+// Error is returned for responses outside the 2xx range
+type Error struct {
+	StatusCode int
+	Message    string
+	Code       string // reason, e.g. ABORTED or FAILED_PRECONDITION
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("api error %d: %s", e.StatusCode, e.Message)
+}
+
+// Retryable reports whether the same request may be sent again as is: the
+// server rejected it without applying it and the cause is transient. A
+// FAILED_PRECONDITION is not retryable; read the balance again for a fresh
+// ETag first.
+func (e *Error) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusConflict, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body, out any) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error string ` + "`json:\"error\"`" + `
+			Code  string ` + "`json:\"code\"`" + `
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return &Error{StatusCode: resp.StatusCode, Message: apiErr.Error, Code: apiErr.Code}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+`
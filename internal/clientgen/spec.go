@@ -0,0 +1,162 @@
+// Package clientgen generates Go and TypeScript clients from the subset of
+// OpenAPI 3 used by api/openapi.yaml: JSON bodies, path, query and header
+// parameters, and object schemas referenced from components.
+package clientgen
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is the parsed API definition
+type Spec struct {
+	Info struct {
+		Title   string `yaml:"title"`
+		Version string `yaml:"version"`
+	} `yaml:"info"`
+	Paths      map[string]map[string]*Operation `yaml:"paths"`
+	Components struct {
+		Parameters map[string]Parameter `yaml:"parameters"`
+		Schemas    map[string]*Schema   `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+// Operation is one method on a path
+type Operation struct {
+	OperationID string      `yaml:"operationId"`
+	Summary     string      `yaml:"summary"`
+	Parameters  []Parameter `yaml:"parameters"`
+	RequestBody *struct {
+		Required bool                 `yaml:"required"`
+		Content  map[string]mediaType `yaml:"content"`
+	} `yaml:"requestBody"`
+	Responses map[string]struct {
+		Description string               `yaml:"description"`
+		Content     map[string]mediaType `yaml:"content"`
+	} `yaml:"responses"`
+
+	// Filled in by Load
+	Method string `yaml:"-"`
+	Path   string `yaml:"-"`
+}
+
+// Parameter is a path, query or header parameter
+type Parameter struct {
+	Ref         string  `yaml:"$ref"`
+	Name        string  `yaml:"name"`
+	In          string  `yaml:"in"`
+	Required    bool    `yaml:"required"`
+	Description string  `yaml:"description"`
+	Schema      *Schema `yaml:"schema"`
+}
+
+// Schema is a type: a primitive, an array or an object
+type Schema struct {
+	Ref         string             `yaml:"$ref"`
+	Type        string             `yaml:"type"`
+	Format      string             `yaml:"format"`
+	Description string             `yaml:"description"`
+	Required    []string           `yaml:"required"`
+	Properties  map[string]*Schema `yaml:"properties"`
+	Items       *Schema            `yaml:"items"`
+}
+
+type mediaType struct {
+	Schema *Schema `yaml:"schema"`
+}
+
+// Load parses the spec at path and resolves parameter references
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec Spec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for p, methods := range spec.Paths {
+		for method, op := range methods {
+			op.Method = strings.ToUpper(method)
+			op.Path = p
+			if op.OperationID == "" {
+				return nil, fmt.Errorf("%s %s: operationId is required", op.Method, p)
+			}
+			for i, param := range op.Parameters {
+				if param.Ref == "" {
+					continue
+				}
+				resolved, ok := spec.Components.Parameters[refName(param.Ref)]
+				if !ok {
+					return nil, fmt.Errorf("%s %s: unknown parameter %s", op.Method, p, param.Ref)
+				}
+				op.Parameters[i] = resolved
+			}
+		}
+	}
+	return &spec, nil
+}
+
+// Operations returns every operation sorted by operationId
+func (s *Spec) Operations() []*Operation {
+	var ops []*Operation
+	for _, methods := range s.Paths {
+		for _, op := range methods {
+			ops = append(ops, op)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].OperationID < ops[j].OperationID })
+	return ops
+}
+
+// SchemaNames returns the component schema names in sorted order
+func (s *Spec) SchemaNames() []string {
+	return sortedKeys(s.Components.Schemas)
+}
+
+// BodySchema returns the JSON request body schema, or nil
+func (op *Operation) BodySchema() *Schema {
+	if op.RequestBody == nil {
+		return nil
+	}
+	return op.RequestBody.Content["application/json"].Schema
+}
+
+// ResultSchema returns the JSON schema of the first 2xx response, or nil
+func (op *Operation) ResultSchema() *Schema {
+	for _, code := range sortedKeys(op.Responses) {
+		if strings.HasPrefix(code, "2") {
+			return op.Responses[code].Content["application/json"].Schema
+		}
+	}
+	return nil
+}
+
+// IsRequired reports whether the object schema requires property name
+func (s *Schema) IsRequired(name string) bool {
+	for _, r := range s.Required {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,170 @@
+package clientgen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GenerateTypeScript returns a dependency-free TypeScript client for spec
+// that uses the Fetch API
+func GenerateTypeScript(spec *Spec, source string) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated by clientgen from %s. DO NOT EDIT.\n", source)
+	fmt.Fprintf(&b, "// Client for the %s %s.\n\n", spec.Info.Title, spec.Info.Version)
+
+	for _, name := range spec.SchemaNames() {
+		s := spec.Components.Schemas[name]
+		if s.Description != "" {
+			fmt.Fprintf(&b, "/** %s */\n", s.Description)
+		}
+		fmt.Fprintf(&b, "export interface %s {\n", name)
+		for _, prop := range sortedKeys(s.Properties) {
+			p := s.Properties[prop]
+			if p.Description != "" {
+				fmt.Fprintf(&b, "  /** %s */\n", p.Description)
+			}
+			optional := ""
+			if !s.IsRequired(prop) {
+				optional = "?"
+			}
+			fmt.Fprintf(&b, "  %s%s: %s;\n", prop, optional, tsType(p))
+		}
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString(tsRuntime)
+
+	for _, op := range spec.Operations() {
+		b.WriteString("\n")
+		if err := writeTSMethod(&b, op); err != nil {
+			return nil, err
+		}
+	}
+	b.WriteString("}\n")
+	return []byte(b.String()), nil
+}
+
+func writeTSMethod(b *strings.Builder, op *Operation) error {
+	var params, query, headers []string
+	for _, p := range op.Parameters {
+		arg := goArg(p.Name)
+		switch p.In {
+		case "path":
+			params = append(params, arg+": "+tsType(p.Schema))
+		case "header":
+			params = append(params, arg+": "+tsType(p.Schema))
+			headers = append(headers, fmt.Sprintf("%q: %s", p.Name, arg))
+		case "query":
+			optional := "?"
+			if p.Required {
+				optional = ""
+			}
+			query = append(query, fmt.Sprintf("%s%s: %s", p.Name, optional, tsType(p.Schema)))
+		default:
+			return fmt.Errorf("%s: unsupported parameter location %q", op.OperationID, p.In)
+		}
+	}
+	body := "undefined"
+	if s := op.BodySchema(); s != nil {
+		params = append(params, "body: "+tsType(s))
+		body = "body"
+	}
+	queryArg := "{}"
+	if len(query) > 0 {
+		params = append(params, "query: { "+strings.Join(query, "; ")+" } = {}")
+		queryArg = "query"
+	}
+
+	result := "void"
+	if s := op.ResultSchema(); s != nil {
+		result = tsType(s)
+	}
+
+	path := "`" + op.Path + "`"
+	for _, p := range op.Parameters {
+		if p.In == "path" {
+			path = strings.Replace(path, "{"+p.Name+"}", "${encodeURIComponent(String("+goArg(p.Name)+"))}", 1)
+		}
+	}
+
+	if op.Summary != "" {
+		fmt.Fprintf(b, "  /** %s */\n", op.Summary)
+	}
+	fmt.Fprintf(b, "  async %s(%s): Promise<%s> {\n", op.OperationID, strings.Join(params, ", "), result)
+	headerArg := "{}"
+	if len(headers) > 0 {
+		headerArg = "{ " + strings.Join(headers, ", ") + " }"
+	}
+	fmt.Fprintf(b, "    return this.request<%s>(%q, %s, %s, %s, %s);\n  }\n",
+		result, op.Method, path, queryArg, headerArg, body)
+	return nil
+}
+
+func tsType(s *Schema) string {
+	if s.Ref != "" {
+		return refName(s.Ref)
+	}
+	switch s.Type {
+	case "integer", "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		return tsType(s.Items) + "[]"
+	case "object":
+		return "Record<string, unknown>"
+	}
+	return "string"
+}
+
+const tsRuntime = `/** Thrown for responses outside the 2xx range */
+export class ApiError extends Error {
+  constructor(
+    public readonly status: number,
+    message: string,
+    /** Reason, e.g. ABORTED or FAILED_PRECONDITION */
+    public readonly code?: string,
+  ) {
+    super(message);
+  }
+
+  /**
+   * Whether the same request may be sent again as is: the server rejected it
+   * without applying it and the cause is transient. FAILED_PRECONDITION is not
+   * retryable; read the balance again for a fresh ETag first.
+   */
+  get retryable(): boolean {
+    return this.status === 409 || this.status === 429 || this.status === 503;
+  }
+}
+
+type Query = Record<string, string | number | boolean | undefined>;
+
+export class Client {
+  constructor(
+    private readonly baseUrl: string,
+    private readonly fetchImpl: typeof fetch = fetch,
+  ) {}
+
+  private async request<T>(method: string, path: string, query: Query, headers: Record<string, string>, body: unknown): Promise<T> {
+    const params = new URLSearchParams();
+    for (const [key, value] of Object.entries(query)) {
+      if (value !== undefined) {
+        params.set(key, String(value));
+      }
+    }
+    const search = params.toString();
+    const init: RequestInit = { method, headers: { ...headers } };
+    if (body !== undefined) {
+      init.body = JSON.stringify(body);
+      (init.headers as Record<string, string>)["Content-Type"] = "application/json";
+    }
+
+    const resp = await this.fetchImpl(this.baseUrl + path + (search ? "?" + search : ""), init);
+    if (!resp.ok) {
+      const data = await resp.json().catch(() => ({}));
+      throw new ApiError(resp.status, data.error ?? resp.statusText, data.code);
+    }
+    return (resp.status === 204 ? undefined : await resp.json()) as T;
+  }
+`
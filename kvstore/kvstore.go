@@ -0,0 +1,179 @@
+// Package kvstore is an experimental balance store on an embedded bbolt
+// database. It implements the same compare-and-swap update and retry loop as
+// the SQL path so the retry engine and bench tooling can be exercised without
+// any SQL database.
+package kvstore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/ghozilaaa/optimistic-lock/internal/backoff"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// ErrNotFound is returned when a balance does not exist. It is
+// service.ErrNotFound, so the store can back a service.Updater.
+var ErrNotFound = service.ErrNotFound
+
+var _ service.BalanceStore = (*Store)(nil)
+
+var bucketName = []byte("balances")
+
+// record is the stored value of a balance
+type record struct {
+	Amount  int64 `json:"amount"`
+	Version int   `json:"version"`
+}
+
+// Store keeps balances in a bbolt file
+type Store struct {
+	db          *bolt.DB
+	writers     *service.KeyedSerializer
+	MaxAttempts int           // Attempts before returning service.ErrRetryExhausted (default 5)
+	BaseBackoff time.Duration // Backoff after the first conflict (default 10ms)
+}
+
+// Open opens or creates the store at path
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{
+		db:          db,
+		writers:     service.NewKeyedSerializer(0),
+		MaxAttempts: 5,
+		BaseBackoff: 10 * time.Millisecond,
+	}, nil
+}
+
+// Close closes the underlying file
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Create inserts a new balance and returns it with its assigned ID
+func (s *Store) Create(amount int64) (models.Balance, error) {
+	var balance models.Balance
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		balance = models.Balance{ID: uint(seq), Amount: amount}
+		return put(b, balance.ID, record{Amount: amount})
+	})
+	return balance, err
+}
+
+// Get returns the current balance
+func (s *Store) Get(id uint) (models.Balance, error) {
+	var balance models.Balance
+	err := s.db.View(func(tx *bolt.Tx) error {
+		rec, err := get(tx.Bucket(bucketName), id)
+		if err != nil {
+			return err
+		}
+		balance = models.Balance{ID: id, Amount: rec.Amount, Version: rec.Version}
+		return nil
+	})
+	return balance, err
+}
+
+// CompareAndSwap stores amount and bumps the version if the balance is still
+// at expectedVersion. Writers for the same ID are serialized so the check and
+// write are atomic per key.
+func (s *Store) CompareAndSwap(id uint, expectedVersion int, amount int64) (bool, error) {
+	swapped := false
+	err := s.writers.Do(id, func() error {
+		return s.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(bucketName)
+			rec, err := get(b, id)
+			if err != nil {
+				return err
+			}
+			if rec.Version != expectedVersion {
+				return nil
+			}
+			swapped = true
+			return put(b, id, record{Amount: amount, Version: rec.Version + 1})
+		})
+	})
+	return swapped, err
+}
+
+// UpdateBalance adds delta with the same read/CAS/backoff loop and errors as
+// service.UpdateBalance, so it can be used wherever a service.UpdateFunc is.
+func (s *Store) UpdateBalance(id uint, delta int64) (service.UpdateOutcome, error) {
+	// Use a local random source for jitter to avoid global Seed usage
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	var outcome service.UpdateOutcome
+	for attempt := 1; attempt <= s.MaxAttempts; attempt++ {
+		outcome.Attempts = attempt
+
+		balance, err := s.Get(id)
+		if err != nil {
+			return outcome, err
+		}
+		outcome.PreviousAmount = balance.Amount
+
+		swapped, err := s.CompareAndSwap(id, balance.Version, balance.Amount+delta)
+		if err != nil {
+			return outcome, err
+		}
+		if swapped {
+			outcome.NewAmount = balance.Amount + delta
+			outcome.Version = balance.Version + 1
+			return outcome, nil
+		}
+		outcome.Conflicts++
+
+		if attempt < s.MaxAttempts {
+			sleep := backoff.Exponential(rnd, s.BaseBackoff, attempt)
+			outcome.Backoff += sleep
+			time.Sleep(sleep)
+		}
+	}
+	return outcome, service.ErrRetryExhausted
+}
+
+func get(b *bolt.Bucket, id uint) (record, error) {
+	data := b.Get(key(id))
+	if data == nil {
+		return record{}, ErrNotFound
+	}
+	var rec record
+	err := json.Unmarshal(data, &rec)
+	return rec, err
+}
+
+func put(b *bolt.Bucket, id uint, rec record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return b.Put(key(id), data)
+}
+
+func key(id uint) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(id))
+	return k
+}
@@ -0,0 +1,145 @@
+// Package latency injects artificial delays into GORM operations so retry
+// policies can be evaluated against a slow or degraded database without
+// external tooling such as a network proxy.
+package latency
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Op is the kind of database operation a delay applies to
+type Op string
+
+const (
+	Query  Op = "query"  // First, Find, Take, ...
+	Create Op = "create" // Create, Save of new rows
+	Update Op = "update" // Update, Updates, Save of existing rows
+	Delete Op = "delete"
+	Row    Op = "row" // Row, Rows, Scan
+	Raw    Op = "raw" // Exec
+)
+
+// Distribution draws delays
+type Distribution interface {
+	Sample(r *rand.Rand) time.Duration
+}
+
+// Fixed always delays by the same duration
+type Fixed time.Duration
+
+func (f Fixed) Sample(*rand.Rand) time.Duration {
+	return time.Duration(f)
+}
+
+// Normal draws from a normal distribution; negative samples become zero
+type Normal struct {
+	Mean   time.Duration
+	StdDev time.Duration
+}
+
+func (n Normal) Sample(r *rand.Rand) time.Duration {
+	d := float64(n.Mean) + r.NormFloat64()*float64(n.StdDev)
+	return time.Duration(math.Max(d, 0))
+}
+
+// Pareto draws from a Pareto distribution with minimum Scale and tail index
+// Shape (lower is heavier), modelling rare but very slow operations. Max caps
+// the samples; zero means no cap.
+type Pareto struct {
+	Scale time.Duration
+	Shape float64
+	Max   time.Duration
+}
+
+func (p Pareto) Sample(r *rand.Rand) time.Duration {
+	d := float64(p.Scale) / math.Pow(1-r.Float64(), 1/p.Shape)
+	if p.Max > 0 && d > float64(p.Max) {
+		return p.Max
+	}
+	return time.Duration(d)
+}
+
+// Injector is a gorm.Plugin delaying each operation by a sample from its
+// distribution. Operations without a distribution are not delayed. Register
+// it with db.Use, which affects every session sharing db's configuration.
+type Injector struct {
+	mu     sync.Mutex
+	rng    *rand.Rand
+	delays map[Op]Distribution
+}
+
+// New returns an Injector with the given delays
+func New(delays map[Op]Distribution) *Injector {
+	i := &Injector{rng: rand.New(rand.NewSource(time.Now().UnixNano())), delays: make(map[Op]Distribution)}
+	for op, d := range delays {
+		i.delays[op] = d
+	}
+	return i
+}
+
+// Set changes the distribution for op; nil removes the delay
+func (i *Injector) Set(op Op, d Distribution) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if d == nil {
+		delete(i.delays, op)
+		return
+	}
+	i.delays[op] = d
+}
+
+func (i *Injector) Name() string {
+	return "latency"
+}
+
+// Initialize registers a callback in front of each operation
+func (i *Injector) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Query().Before("gorm:query").Register("latency:query", i.callback(Query)),
+		cb.Create().Before("gorm:create").Register("latency:create", i.callback(Create)),
+		cb.Update().Before("gorm:update").Register("latency:update", i.callback(Update)),
+		cb.Delete().Before("gorm:delete").Register("latency:delete", i.callback(Delete)),
+		cb.Row().Before("gorm:row").Register("latency:row", i.callback(Row)),
+		cb.Raw().Before("gorm:raw").Register("latency:raw", i.callback(Raw)),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delay samples the delay for op, or returns 0 if op is not delayed
+func (i *Injector) delay(op Op) time.Duration {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	d, ok := i.delays[op]
+	if !ok {
+		return 0
+	}
+	return d.Sample(i.rng)
+}
+
+// callback sleeps for op's delay, returning early with the context's error
+// if the statement's context is cancelled first
+func (i *Injector) callback(op Op) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		d := i.delay(op)
+		if d <= 0 {
+			return
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-db.Statement.Context.Done():
+			db.AddError(db.Statement.Context.Err())
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// Delta compares one metric between two reports
+type Delta struct {
+	Metric  string
+	OldMean float64
+	OldSD   float64
+	NewMean float64
+	NewSD   float64
+	Diff    float64 // NewMean - OldMean
+	CILow   float64 // 95% confidence interval of Diff
+	CIHigh  float64
+	HasCI   bool // false when either side has fewer than two runs
+}
+
+// Significant reports whether the 95% confidence interval excludes zero
+func (d Delta) Significant() bool {
+	return d.HasCI && (d.CILow > 0 || d.CIHigh < 0)
+}
+
+// Percent returns Diff relative to the old mean
+func (d Delta) Percent() float64 {
+	if d.OldMean == 0 {
+		return 0
+	}
+	return d.Diff / d.OldMean * 100
+}
+
+// Compare computes deltas for TPS, p99 latency and conflict rate using Welch's
+// t-interval, which does not assume both reports have equal variance.
+func Compare(old, new Report) []Delta {
+	metrics := []struct {
+		name string
+		get  func(Run) float64
+	}{
+		{"tps", func(r Run) float64 { return r.TPS }},
+		{"p99_ms", func(r Run) float64 { return r.P99Ms }},
+		{"conflict_rate", func(r Run) float64 { return r.ConflictRate }},
+	}
+
+	deltas := make([]Delta, 0, len(metrics))
+	for _, m := range metrics {
+		a := values(old.Runs, m.get)
+		b := values(new.Runs, m.get)
+
+		d := Delta{Metric: m.name}
+		d.OldMean, d.OldSD = meanSD(a)
+		d.NewMean, d.NewSD = meanSD(b)
+		d.Diff = d.NewMean - d.OldMean
+
+		if len(a) >= 2 && len(b) >= 2 {
+			va := d.OldSD * d.OldSD / float64(len(a))
+			vb := d.NewSD * d.NewSD / float64(len(b))
+			se := math.Sqrt(va + vb)
+
+			// Welch-Satterthwaite degrees of freedom
+			df := float64(len(a) + len(b) - 2)
+			if va+vb > 0 {
+				df = (va + vb) * (va + vb) /
+					(va*va/float64(len(a)-1) + vb*vb/float64(len(b)-1))
+			}
+
+			margin := tCritical95(df) * se
+			d.CILow, d.CIHigh, d.HasCI = d.Diff-margin, d.Diff+margin, true
+		}
+
+		deltas = append(deltas, d)
+	}
+	return deltas
+}
+
+// PrintComparison writes the deltas as an aligned table
+func PrintComparison(w io.Writer, old, new Report, deltas []Delta) {
+	fmt.Fprintf(w, "old: %s (%d runs)\nnew: %s (%d runs)\n\n", old.Name, len(old.Runs), new.Name, len(new.Runs))
+	fmt.Fprintf(w, "%-14s %20s %20s %10s %26s\n", "metric", "old", "new", "delta", "95% CI")
+	for _, d := range deltas {
+		ci := "n/a (need >= 2 runs)"
+		if d.HasCI {
+			ci = fmt.Sprintf("[%+.4g, %+.4g]", d.CILow, d.CIHigh)
+			if d.Significant() {
+				ci += " *"
+			}
+		}
+		fmt.Fprintf(w, "%-14s %20s %20s %+9.2f%% %26s\n", d.Metric,
+			fmt.Sprintf("%.4g ± %.2g", d.OldMean, d.OldSD),
+			fmt.Sprintf("%.4g ± %.2g", d.NewMean, d.NewSD),
+			d.Percent(), ci)
+	}
+	fmt.Fprintln(w, "\n* confidence interval excludes zero")
+}
+
+// Summary is the mean of each metric over the runs of a report
+type Summary struct {
+	Name         string
+	Runs         int
+	TPS          float64
+	P50Ms        float64
+	P99Ms        float64
+	ConflictRate float64
+}
+
+// Summarize averages the runs of r
+func Summarize(r Report) Summary {
+	s := Summary{Name: r.Name, Runs: len(r.Runs)}
+	s.TPS, _ = meanSD(values(r.Runs, func(r Run) float64 { return r.TPS }))
+	s.P50Ms, _ = meanSD(values(r.Runs, func(r Run) float64 { return r.P50Ms }))
+	s.P99Ms, _ = meanSD(values(r.Runs, func(r Run) float64 { return r.P99Ms }))
+	s.ConflictRate, _ = meanSD(values(r.Runs, func(r Run) float64 { return r.ConflictRate }))
+	return s
+}
+
+// PrintSummaries writes one row per report, such as one per strategy on the
+// same workload, with its TPS relative to the first
+func PrintSummaries(w io.Writer, reports []Report) {
+	fmt.Fprintf(w, "%-14s %5s %10s %10s %10s %10s %10s\n", "name", "runs", "tps", "vs first", "p50_ms", "p99_ms", "conflicts")
+	var base float64
+	for i, r := range reports {
+		s := Summarize(r)
+		if i == 0 {
+			base = s.TPS
+		}
+		relative := "n/a"
+		if base > 0 {
+			relative = fmt.Sprintf("%+.1f%%", (s.TPS/base-1)*100)
+		}
+		fmt.Fprintf(w, "%-14s %5d %10.1f %10s %10.2f %10.2f %9.2f%%\n", s.Name, s.Runs, s.TPS, relative, s.P50Ms, s.P99Ms, s.ConflictRate*100)
+	}
+}
+
+func values(runs []Run, get func(Run) float64) []float64 {
+	out := make([]float64, len(runs))
+	for i, r := range runs {
+		out[i] = get(r)
+	}
+	return out
+}
+
+// meanSD returns the mean and sample standard deviation
+func meanSD(xs []float64) (float64, float64) {
+	if len(xs) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	mean := sum / float64(len(xs))
+	if len(xs) < 2 {
+		return mean, 0
+	}
+
+	var ss float64
+	for _, x := range xs {
+		ss += (x - mean) * (x - mean)
+	}
+	return mean, math.Sqrt(ss / float64(len(xs)-1))
+}
+
+// tTable holds two-sided 95% Student's t critical values for 1..30 degrees of freedom
+var tTable = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// tCritical95 returns the two-sided 95% t critical value, rounding df down
+func tCritical95(df float64) float64 {
+	i := int(math.Floor(df))
+	if i < 1 {
+		i = 1
+	}
+	if i <= len(tTable) {
+		return tTable[i-1]
+	}
+	// Beyond 30 degrees of freedom the normal approximation is close enough
+	return 1.96
+}
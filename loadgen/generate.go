@@ -0,0 +1,170 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// maxSampledErrors is how many failures a Result keeps for logging
+const maxSampledErrors = 10
+
+// Target is one transaction of a load test, e.g. a closure calling
+// Updater.UpdateBalance on the balance under test
+type Target func() (service.UpdateOutcome, error)
+
+// Result holds the counts and latencies of one load-test run
+type Result struct {
+	Shape     string
+	Sent      int // transactions started; fewer than scheduled if the run was cancelled
+	Succeeded int
+	Retried   int     // succeeded after a conflict
+	Conflicts int     // failed with service.ErrConflict, i.e. retries exhausted
+	Failures  int     // failed with any other error
+	Errors    []error // the first failures, for logging
+	Elapsed   time.Duration
+	Latency   Histogram
+	Interims  []Interim // with WithInterim
+}
+
+// Generate starts target at the offsets of shape, each in its own goroutine,
+// and waits for all of them to return. Cancelling ctx stops sending; the
+// transactions already sent still complete.
+func Generate(ctx context.Context, shape Shape, target Target, opts ...Option) Result {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	res := Result{Shape: shape.String()}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	start := time.Now()
+	var interim *sampler
+	if o.every > 0 {
+		interim = newSampler(o, start)
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			ticker := time.NewTicker(o.every)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case now := <-ticker.C:
+					mu.Lock()
+					in := interim.sample(&res, now)
+					res.Interims = append(res.Interims, in)
+					mu.Unlock()
+					if o.report != nil {
+						o.report(in)
+					}
+				}
+			}
+		}()
+		defer func() {
+			close(stop)
+			<-done
+		}()
+	}
+
+send:
+	for offset := range offsets(shape, rand.New(rand.NewSource(time.Now().UnixNano()))) {
+		if wait := time.Until(start.Add(offset)); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				break send
+			}
+		} else if ctx.Err() != nil {
+			break
+		}
+
+		mu.Lock()
+		res.Sent++
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			txStart := time.Now()
+			outcome, err := target()
+			elapsed := time.Since(txStart)
+
+			mu.Lock()
+			defer mu.Unlock()
+			res.Latency.Record(elapsed)
+			if interim != nil {
+				interim.latency.Record(elapsed)
+			}
+			switch {
+			case err == nil:
+				res.Succeeded++
+				if outcome.Retried() {
+					res.Retried++
+				}
+			case errors.Is(err, service.ErrConflict):
+				res.Conflicts++
+			default:
+				res.Failures++
+				if len(res.Errors) < maxSampledErrors {
+					res.Errors = append(res.Errors, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+	return res
+}
+
+// TPS returns the successful transactions per second
+func (r *Result) TPS() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Succeeded) / r.Elapsed.Seconds()
+}
+
+// ConflictRate returns the share of sent transactions that failed with a conflict
+func (r *Result) ConflictRate() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.Conflicts) / float64(r.Sent)
+}
+
+// Run summarizes the result as an entry of a Report
+func (r *Result) Run() Run {
+	return Run{
+		TPS:          r.TPS(),
+		P99Ms:        ms(r.Latency.Quantile(0.99)),
+		ConflictRate: r.ConflictRate(),
+		Shape:        r.Shape,
+		Sent:         r.Sent,
+		Succeeded:    r.Succeeded,
+		Retried:      r.Retried,
+		Conflicts:    r.Conflicts,
+		Failures:     r.Failures,
+		ElapsedMs:    ms(r.Elapsed),
+		MeanMs:       ms(r.Latency.Mean()),
+		P50Ms:        ms(r.Latency.Quantile(0.50)),
+		P90Ms:        ms(r.Latency.Quantile(0.90)),
+		P95Ms:        ms(r.Latency.Quantile(0.95)),
+		P999Ms:       ms(r.Latency.Quantile(0.999)),
+		MaxMs:        ms(r.Latency.Max()),
+		Latency:      r.Latency.Buckets(),
+		Interims:     r.Interims,
+	}
+}
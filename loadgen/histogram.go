@@ -0,0 +1,211 @@
+package loadgen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"time"
+)
+
+// HistogramDigits is the precision of a Histogram in significant decimal
+// digits: quantiles are within 0.1% of the recorded latency
+const HistogramDigits = 3
+
+const (
+	histogramUnit = time.Microsecond // the lowest latency told apart
+
+	// Each power of two of latencies is split into subBuckets linear steps,
+	// the smallest power of two that keeps HistogramDigits digits
+	subBucketBits = 11 // ceil(log2(2 * 10^HistogramDigits))
+	subBuckets    = 1 << subBucketBits
+	halfBits      = subBucketBits - 1
+	halfBuckets   = 1 << halfBits
+)
+
+// Histogram counts latencies in the layout of an HDR histogram: one bucket per
+// power of two of microseconds, each split linearly in 2048, so quantiles keep
+// HistogramDigits significant digits in memory that grows only with the log of
+// the highest latency. It is not safe for concurrent use.
+type Histogram struct {
+	counts   []int
+	count    int
+	sum      time.Duration
+	min, max time.Duration
+}
+
+// Bucket is one non-empty bucket of a Histogram, for reports
+type Bucket struct {
+	UpperMs float64 `json:"le_ms"` // latencies up to this bound
+	Count   int     `json:"count"`
+}
+
+// Record adds one latency
+func (h *Histogram) Record(d time.Duration) {
+	h.add(index(d), 1)
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+}
+
+// Merge adds the latencies recorded by other, e.g. to report several runs as one
+func (h *Histogram) Merge(other *Histogram) {
+	if other.count == 0 {
+		return
+	}
+	for i, c := range other.counts {
+		if c > 0 {
+			h.add(i, c)
+		}
+	}
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	h.max = max(h.max, other.max)
+	h.count += other.count
+	h.sum += other.sum
+}
+
+func (h *Histogram) add(i, n int) {
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]int, i+1-len(h.counts))...)
+	}
+	h.counts[i] += n
+}
+
+// Count returns the number of latencies recorded
+func (h *Histogram) Count() int {
+	return h.count
+}
+
+// Mean returns the average latency
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Max returns the highest latency recorded
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Quantile returns the latency below which a share q (0..1) of the recorded
+// latencies fall, e.g. 0.99 for p99 or 0.999 for p999
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			return h.clamp(upper(i))
+		}
+	}
+	return h.max
+}
+
+// Buckets returns the non-empty buckets in ascending order
+func (h *Histogram) Buckets() []Bucket {
+	var out []Bucket
+	for i, c := range h.counts {
+		if c > 0 {
+			out = append(out, Bucket{UpperMs: ms(h.clamp(upper(i))), Count: c})
+		}
+	}
+	return out
+}
+
+// clamp keeps a bucket bound within the latencies recorded
+func (h *Histogram) clamp(d time.Duration) time.Duration {
+	return min(max(d, h.min), h.max)
+}
+
+// WritePercentiles writes the percentile distribution of h in milliseconds,
+// in the .hgrm text format of HdrHistogram, which its plotters read. The
+// percentiles step closer together towards the tail, five per halving of the
+// remaining share.
+func (h *Histogram) WritePercentiles(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+
+	var seen int
+	var level float64 // the next percentile to write, 0..100
+	var sumSq float64
+	mean := float64(h.Mean())
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		seen += c
+		value := h.clamp(upper(i))
+		sumSq += float64(c) * math.Pow(float64(value)-mean, 2)
+		for float64(seen)*100 >= level*float64(h.count) {
+			if seen == h.count {
+				fmt.Fprintf(bw, "%12.3f %1.12f %10d\n", ms(value), 1.0, seen)
+				break
+			}
+			fmt.Fprintf(bw, "%12.3f %1.12f %10d %14.2f\n", ms(value), level/100, seen, 1/(1-level/100))
+			ticks := 5 * math.Pow(2, math.Floor(math.Log2(100/(100-level)))+1)
+			level += 100 / ticks
+		}
+	}
+
+	var sd float64
+	if h.count > 0 {
+		sd = math.Sqrt(sumSq / float64(h.count))
+	}
+	fmt.Fprintf(bw, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n", ms(h.Mean()), sd/float64(time.Millisecond))
+	fmt.Fprintf(bw, "#[Max     = %12.3f, Total count    = %12d]\n", ms(h.max), h.count)
+	fmt.Fprintf(bw, "#[Buckets = %12d, SubBuckets     = %12d]\n", max(len(h.counts)>>halfBits-1, 1), subBuckets)
+	return bw.Flush()
+}
+
+// WritePercentilesFile writes the percentile distribution of h to path
+func WritePercentilesFile(path string, h *Histogram) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := h.WritePercentiles(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// index returns the index of the count holding d. The first halfBuckets
+// counts step by one unit; after them, bucket b steps by 2^b units.
+func index(d time.Duration) int {
+	v := uint64(max(d/histogramUnit, 0))
+	b := 64 - bits.LeadingZeros64(v|(subBuckets-1)) - subBucketBits
+	sub := int(v >> b)
+	return (b+1)<<halfBits + sub - halfBuckets
+}
+
+// upper returns the highest latency counted at index i, in whole units as
+// HdrHistogram reports it
+func upper(i int) time.Duration {
+	b, sub := i>>halfBits-1, i&(halfBuckets-1)+halfBuckets
+	if b < 0 {
+		b, sub = 0, sub-halfBuckets
+	}
+	return time.Duration((uint64(sub)+1)<<b-1) * histogramUnit
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
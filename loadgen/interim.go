@@ -0,0 +1,118 @@
+package loadgen
+
+import (
+	"database/sql"
+	"runtime"
+	"time"
+)
+
+// Interim reports a run in progress over the interval since the previous
+// one, for capacity planning on long ramps and soaks: the rate and latency
+// reached, and whether memory, goroutines or pool connections keep growing.
+type Interim struct {
+	ElapsedMs float64 `json:"elapsed_ms"` // since the start of the run
+	Sent      int     `json:"sent"`
+	Succeeded int     `json:"succeeded"`
+	Conflicts int     `json:"conflicts"`
+	Failures  int     `json:"failures"`
+	TPS       float64 `json:"tps"`    // successes per second
+	P50Ms     float64 `json:"p50_ms"` // of the transactions that returned
+	P99Ms     float64 `json:"p99_ms"`
+	InFlight  int     `json:"in_flight"` // sent and not returned, at the end
+
+	HeapMB     float64 `json:"heap_mb"` // live heap at the end
+	Goroutines int     `json:"goroutines"`
+	GCs        uint32  `json:"gcs"`
+
+	// Connection pool of the database under test, with WithPoolStats
+	OpenConns  int     `json:"open_conns,omitempty"`
+	InUseConns int     `json:"in_use_conns,omitempty"`
+	Waits      int64   `json:"waits,omitempty"`
+	WaitMs     float64 `json:"wait_ms,omitempty"` // total time waited for a connection
+}
+
+// Option configures Generate
+type Option func(*options)
+
+type options struct {
+	every     time.Duration
+	report    func(Interim)
+	poolStats func() sql.DBStats
+}
+
+// WithInterim samples the run every d while it runs into Result.Interims, and
+// passes each sample to report, if not nil
+func WithInterim(d time.Duration, report func(Interim)) Option {
+	return func(o *options) {
+		o.every, o.report = d, report
+	}
+}
+
+// WithPoolStats adds the connection pool statistics of stats, such as the
+// Stats method of the *sql.DB under test, to each Interim
+func WithPoolStats(stats func() sql.DBStats) Option {
+	return func(o *options) {
+		o.poolStats = stats
+	}
+}
+
+// sampler keeps the counts at the previous Interim to report the difference
+type sampler struct {
+	opts      options
+	start     time.Time
+	last      time.Time
+	prev      Result
+	latency   Histogram // of the transactions returned since the previous Interim
+	gcs       uint32
+	poolWaits int64
+	poolWait  time.Duration
+}
+
+func newSampler(opts options, start time.Time) *sampler {
+	s := &sampler{opts: opts, start: start, last: start}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	s.gcs = mem.NumGC
+	if opts.poolStats != nil {
+		stats := opts.poolStats()
+		s.poolWaits, s.poolWait = stats.WaitCount, stats.WaitDuration
+	}
+	return s
+}
+
+// sample returns the Interim since the previous one, given the counts of the
+// run so far, and resets the interval latencies. The caller holds the lock
+// of the run.
+func (s *sampler) sample(res *Result, now time.Time) Interim {
+	done := res.Succeeded + res.Conflicts + res.Failures
+	in := Interim{
+		ElapsedMs:  ms(now.Sub(s.start)),
+		Sent:       res.Sent - s.prev.Sent,
+		Succeeded:  res.Succeeded - s.prev.Succeeded,
+		Conflicts:  res.Conflicts - s.prev.Conflicts,
+		Failures:   res.Failures - s.prev.Failures,
+		P50Ms:      ms(s.latency.Quantile(0.50)),
+		P99Ms:      ms(s.latency.Quantile(0.99)),
+		InFlight:   res.Sent - done,
+		Goroutines: runtime.NumGoroutine(),
+	}
+	if secs := now.Sub(s.last).Seconds(); secs > 0 {
+		in.TPS = float64(in.Succeeded) / secs
+	}
+	s.prev = Result{Sent: res.Sent, Succeeded: res.Succeeded, Conflicts: res.Conflicts, Failures: res.Failures}
+	s.latency = Histogram{}
+	s.last = now
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	in.HeapMB = float64(mem.HeapAlloc) / (1 << 20)
+	in.GCs, s.gcs = mem.NumGC-s.gcs, mem.NumGC
+
+	if s.opts.poolStats != nil {
+		stats := s.opts.poolStats()
+		in.OpenConns, in.InUseConns = stats.OpenConnections, stats.InUse
+		in.Waits, in.WaitMs = stats.WaitCount-s.poolWaits, ms(stats.WaitDuration-s.poolWait)
+		s.poolWaits, s.poolWait = stats.WaitCount, stats.WaitDuration
+	}
+	return in
+}
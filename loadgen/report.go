@@ -0,0 +1,97 @@
+package loadgen
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Run holds the results of one load-test run. Compare uses the first three
+// fields; the rest are filled in by Result.Run for reading the report.
+type Run struct {
+	TPS          float64 `json:"tps"`
+	P99Ms        float64 `json:"p99_ms"`
+	ConflictRate float64 `json:"conflict_rate"` // conflicts / transactions, 0..1
+
+	Shape     string    `json:"shape,omitempty"`
+	Sent      int       `json:"sent,omitempty"`
+	Succeeded int       `json:"succeeded,omitempty"`
+	Retried   int       `json:"retried,omitempty"`
+	Conflicts int       `json:"conflicts,omitempty"`
+	Failures  int       `json:"failures,omitempty"`
+	ElapsedMs float64   `json:"elapsed_ms,omitempty"`
+	MeanMs    float64   `json:"mean_ms,omitempty"`
+	P50Ms     float64   `json:"p50_ms,omitempty"`
+	P90Ms     float64   `json:"p90_ms,omitempty"`
+	P95Ms     float64   `json:"p95_ms,omitempty"`
+	P999Ms    float64   `json:"p999_ms,omitempty"`
+	MaxMs     float64   `json:"max_ms,omitempty"`
+	Latency   []Bucket  `json:"latency,omitempty"`  // latency histogram
+	Interims  []Interim `json:"interims,omitempty"` // samples taken while the run ran
+}
+
+// Report is the JSON result file written by a load-test session. Repeated runs
+// of the same scenario are what make comparisons between reports meaningful.
+type Report struct {
+	Name string `json:"name"`
+	Runs []Run  `json:"runs"`
+}
+
+// LoadReport reads a report from a JSON file
+func LoadReport(path string) (Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Report{}, err
+	}
+
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Report{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(r.Runs) == 0 {
+		return Report{}, fmt.Errorf("%s: report has no runs", path)
+	}
+	return r, nil
+}
+
+// WriteReport writes a report as indented JSON
+func WriteReport(path string, r Report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// csvHeader names the columns written by WriteCSV
+var csvHeader = []string{
+	"name", "run", "shape", "sent", "succeeded", "retried", "conflicts", "failures",
+	"elapsed_ms", "tps", "conflict_rate", "mean_ms", "p50_ms", "p90_ms", "p95_ms", "p99_ms", "p999_ms", "max_ms",
+}
+
+// WriteCSV writes one row per run of r, for spreadsheets. The latency
+// histograms are only in the JSON report.
+func WriteCSV(w io.Writer, r Report) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for i, run := range r.Runs {
+		err := cw.Write([]string{
+			r.Name, strconv.Itoa(i + 1), run.Shape,
+			strconv.Itoa(run.Sent), strconv.Itoa(run.Succeeded), strconv.Itoa(run.Retried),
+			strconv.Itoa(run.Conflicts), strconv.Itoa(run.Failures),
+			f(run.ElapsedMs), f(run.TPS), f(run.ConflictRate),
+			f(run.MeanMs), f(run.P50Ms), f(run.P90Ms), f(run.P95Ms), f(run.P99Ms), f(run.P999Ms), f(run.MaxMs),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
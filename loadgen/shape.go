@@ -0,0 +1,218 @@
+package loadgen
+
+import (
+	"fmt"
+	"iter"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shape is a traffic pattern: when each transaction of a run starts
+type Shape interface {
+	// Offsets returns the start of every transaction relative to the start
+	// of the run, in ascending order. r drives any randomness.
+	Offsets(r *rand.Rand) []time.Duration
+	String() string
+}
+
+// Stream is a Shape that also yields its offsets one at a time, so a run of
+// hours need not hold them all in memory. Generate prefers it.
+type Stream interface {
+	Shape
+	Stream(r *rand.Rand) iter.Seq[time.Duration]
+}
+
+// offsets yields the offsets of shape, streamed if it can
+func offsets(shape Shape, r *rand.Rand) iter.Seq[time.Duration] {
+	if s, ok := shape.(Stream); ok {
+		return s.Stream(r)
+	}
+	return slices.Values(shape.Offsets(r))
+}
+
+// Constant sends TPS transactions per second for Duration at a fixed interval
+type Constant struct {
+	TPS      float64
+	Duration time.Duration
+}
+
+func (c Constant) Offsets(*rand.Rand) []time.Duration {
+	n := count(c.TPS, c.Duration)
+	interval := c.Duration / time.Duration(n)
+	offsets := make([]time.Duration, n)
+	for i := range offsets {
+		offsets[i] = time.Duration(i) * interval
+	}
+	return offsets
+}
+
+func (c Constant) String() string {
+	return fmt.Sprintf("constant %g tps for %v", c.TPS, c.Duration)
+}
+
+// Variable sends TPS transactions per second on average for Duration, with
+// each interval varied at random by up to Jitter of the base interval (0.5
+// means ±50%), like bursty client traffic
+type Variable struct {
+	TPS      float64
+	Duration time.Duration
+	Jitter   float64
+}
+
+func (v Variable) Offsets(r *rand.Rand) []time.Duration {
+	n := count(v.TPS, v.Duration)
+	base := float64(v.Duration) / float64(n)
+	offsets := make([]time.Duration, n)
+	var at float64
+	for i := range offsets {
+		offsets[i] = time.Duration(at)
+		at += math.Max(base*(1+v.Jitter*(2*r.Float64()-1)), 0)
+	}
+	return offsets
+}
+
+func (v Variable) String() string {
+	return fmt.Sprintf("variable %g tps ±%g%% for %v", v.TPS, v.Jitter*100, v.Duration)
+}
+
+// Ramp raises (or lowers) the rate linearly from From to To transactions per
+// second over Duration, to find the rate at which conflicts take over
+type Ramp struct {
+	From, To float64
+	Duration time.Duration
+}
+
+func (r Ramp) Offsets(rng *rand.Rand) []time.Duration {
+	return slices.Collect(r.Stream(rng))
+}
+
+func (r Ramp) Stream(*rand.Rand) iter.Seq[time.Duration] {
+	// The k-th transaction starts when the integral of the rate,
+	// From*t + (To-From)*t²/(2*Duration), reaches k
+	secs := r.Duration.Seconds()
+	a := (r.To - r.From) / (2 * secs)
+	b := r.From
+	n := int(b*secs + a*secs*secs)
+	return func(yield func(time.Duration) bool) {
+		for k := 0; k < n; k++ {
+			t := float64(k) / b
+			if a != 0 {
+				t = (-b + math.Sqrt(b*b+4*a*float64(k))) / (2 * a)
+			}
+			if !yield(time.Duration(t * float64(time.Second))) {
+				return
+			}
+		}
+	}
+}
+
+func (r Ramp) String() string {
+	return fmt.Sprintf("ramp %g to %g tps over %v", r.From, r.To, r.Duration)
+}
+
+// Soak sends TPS transactions per second at a fixed interval for Duration,
+// typically hours, to show what builds up under sustained load: memory,
+// connections, latency drift. Report it WithInterim while it runs.
+type Soak struct {
+	TPS      float64
+	Duration time.Duration
+}
+
+func (s Soak) Offsets(r *rand.Rand) []time.Duration {
+	return slices.Collect(s.Stream(r))
+}
+
+func (s Soak) Stream(*rand.Rand) iter.Seq[time.Duration] {
+	n := count(s.TPS, s.Duration)
+	interval := float64(s.Duration) / float64(n)
+	return func(yield func(time.Duration) bool) {
+		for i := 0; i < n; i++ {
+			if !yield(time.Duration(float64(i) * interval)) {
+				return
+			}
+		}
+	}
+}
+
+func (s Soak) String() string {
+	return fmt.Sprintf("soak %g tps for %v", s.TPS, s.Duration)
+}
+
+// Phase is one part of a Burst: Count transactions Interval apart, then a
+// quiet Pause before the next phase
+type Phase struct {
+	Count    int
+	Interval time.Duration
+	Pause    time.Duration
+}
+
+// Burst alternates phases of high and low activity
+type Burst []Phase
+
+func (b Burst) Offsets(*rand.Rand) []time.Duration {
+	var offsets []time.Duration
+	var at time.Duration
+	for _, p := range b {
+		for i := 0; i < p.Count; i++ {
+			if i > 0 {
+				at += p.Interval
+			}
+			offsets = append(offsets, at)
+		}
+		at += p.Pause
+	}
+	return offsets
+}
+
+func (b Burst) String() string {
+	phases := make([]string, len(b))
+	for i, p := range b {
+		phases[i] = fmt.Sprintf("%dx%v", p.Count, p.Interval)
+		if p.Pause > 0 {
+			phases[i] += "+" + p.Pause.String()
+		}
+	}
+	return "burst " + strings.Join(phases, ",")
+}
+
+// ParseBurst parses phases written as COUNTxINTERVAL[+PAUSE], separated by
+// commas, e.g. "50x10ms+2s,30x100ms+1s,40x20ms"
+func ParseBurst(spec string) (Burst, error) {
+	var b Burst
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		countStr, rest, ok := strings.Cut(part, "x")
+		if !ok {
+			return nil, fmt.Errorf("burst phase %q: want COUNTxINTERVAL[+PAUSE]", part)
+		}
+		var p Phase
+		var err error
+		if p.Count, err = strconv.Atoi(countStr); err != nil || p.Count <= 0 {
+			return nil, fmt.Errorf("burst phase %q: count must be a positive integer", part)
+		}
+		intervalStr, pauseStr, hasPause := strings.Cut(rest, "+")
+		if p.Interval, err = time.ParseDuration(intervalStr); err != nil || p.Interval < 0 {
+			return nil, fmt.Errorf("burst phase %q: bad interval %q", part, intervalStr)
+		}
+		if hasPause {
+			if p.Pause, err = time.ParseDuration(pauseStr); err != nil || p.Pause < 0 {
+				return nil, fmt.Errorf("burst phase %q: bad pause %q", part, pauseStr)
+			}
+		}
+		b = append(b, p)
+	}
+	return b, nil
+}
+
+// count returns the number of transactions of a run at tps for d, at least one
+func count(tps float64, d time.Duration) int {
+	n := int(tps * d.Seconds())
+	if n < 1 {
+		return 1
+	}
+	return n
+}
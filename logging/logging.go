@@ -0,0 +1,59 @@
+// Package logging is the structured logging interface used across the
+// service. *slog.Logger implements it, so the adapter is slog itself: pass
+// slog.Default(), a logger from New or one wrapping any slog.Handler.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Logger writes leveled messages with alternating key-value fields, as
+// slog does. Implementations must be safe for concurrent use.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+var _ Logger = (*slog.Logger)(nil)
+
+// Nop discards every message
+type Nop struct{}
+
+func (Nop) Debug(string, ...any) {}
+func (Nop) Info(string, ...any)  {}
+func (Nop) Warn(string, ...any)  {}
+func (Nop) Error(string, ...any) {}
+
+// Field keys used by the service
+const (
+	BalanceID = "balance_id"
+	Attempt   = "attempt"  // 1-based attempt number
+	Attempts  = "attempts" // attempts made by a finished update
+	Conflicts = "conflicts"
+	Delta     = "delta"
+	Backoff   = "backoff"
+	Err       = "error"
+)
+
+// New returns a slog logger writing to w in format (text or json) at level
+// (debug, info, warn or error)
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q: want text or json", format)
+}
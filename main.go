@@ -1,49 +1,225 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"gorm.io/driver/postgres"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gorm.io/gorm"
 
-	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/config"
+	"github.com/ghozilaaa/optimistic-lock/dbpool"
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/logging"
+	"github.com/ghozilaaa/optimistic-lock/migrations"
+	"github.com/ghozilaaa/optimistic-lock/schedule"
+	"github.com/ghozilaaa/optimistic-lock/service"
+	"github.com/ghozilaaa/optimistic-lock/webhook"
 )
 
+// poolSampleInterval is how often connection pool statistics are recorded
+const poolSampleInterval = 10 * time.Second
+
+// deadLetterInterval is how often failed updates are checked for replay
+const deadLetterInterval = 5 * time.Second
+
+// dedupCleanupInterval is how often expired dedup keys are deleted and the
+// size of the window is recorded
+const dedupCleanupInterval = 5 * time.Minute
+
 func main() {
-	// Get database configuration from environment variables
-	dbHost := getEnv("DB_HOST", "localhost")
-	dbPort := getEnv("DB_PORT", "5432")
-	dbUser := getEnv("DB_USER", "postgres")
-	dbPassword := getEnv("DB_PASSWORD", "postgres")
-	dbName := getEnv("DB_NAME", "optimistic_lock")
-	dbSSLMode := getEnv("DB_SSLMODE", "disable")
-
-	// Build connection string
-	dsn := fmt.Sprintf("host=%s user=%s dbname=%s password=%s port=%s sslmode=%s",
-		dbHost, dbUser, dbName, dbPassword, dbPort, dbSSLMode)
-
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// Settings come from defaults, -config file, environment variables and flags
+	cfg, err := config.Load("optimistic-lock", os.Args[1:])
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		os.Exit(2)
+	}
+	logger, _ := cfg.NewLogger(os.Stderr) // Load validated the log settings
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, cfg, logger); err != nil {
+		logger.Error("Service stopped", logging.Err, err)
+		os.Exit(1)
 	}
+}
 
-	log.Println("Successfully connected to database")
+// run serves the balance API until ctx is cancelled, then drains in-flight
+// requests within cfg.Server.ShutdownTimeout
+func run(ctx context.Context, cfg config.Config, logger logging.Logger) error {
+	procs, quota := cfg.ApplyRuntime()
+	logger.Info("Runtime sized", "gomaxprocs", procs, "cpu_quota", quota)
 
-	// Auto-migrate for demo purposes
-	err = db.AutoMigrate(&models.Balance{})
+	creds, err := cfg.NewCredentials(ctx)
+	if err != nil {
+		return err
+	}
+	db, err := cfg.OpenDB(nil, creds)
+	if err != nil {
+		return errors.Join(errors.New("failed to connect to database"), err)
+	}
+	sqlDB, err := db.DB()
 	if err != nil {
-		log.Fatal("Failed to migrate database:", err)
+		return err
 	}
+	defer sqlDB.Close()
 
-	log.Println("Database migration completed successfully")
+	logger.Info("Connected to database", "driver", cfg.Database.Driver)
+
+	if err := migrate(db, cfg.Database.Migrate, logger); err != nil {
+		return err
+	}
+
+	registry := prometheus.NewRegistry()
+	m, err := cfg.NewMetrics(registry)
+	if err != nil {
+		return err
+	}
+	pool := dbpool.New(sqlDB, cfg.PoolLimits(), m)
+	background, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pool.Run(background, poolSampleInterval)
+	if creds != nil {
+		go creds.Run(background, cfg.Database.Secrets.RefreshInterval, func(err error) {
+			logger.Error("Refreshing database credentials", logging.Err, err)
+		})
+		creds.OnRotate(func() { logger.Info("Database credentials rotated") })
+	}
+
+	opts := append(cfg.UpdaterOptions(db), service.WithMetrics(m), service.WithHooks(pool.Hooks()), service.WithLogger(logger))
+	serializer := cfg.NewSerializer()
+	if serializer != nil {
+		opts = append(opts, service.WithSerializer(serializer))
+	}
+	contention := cfg.NewContentionMonitor(m)
+	if contention != nil {
+		opts = append(opts, service.WithContentionMonitor(contention))
+		go contention.Run(background)
+	}
+	api := &httpapi.Server{
+		DB:         db,
+		Updater:    service.NewUpdater(db, opts...),
+		Operations: service.NewOperationRegistry(time.Hour),
+
+		TenantHeader: cfg.Server.TenantHeader,
+	}
+	if len(cfg.Database.Replicas) > 0 {
+		replicas, err := cfg.OpenReplicas(nil, creds)
+		if err != nil {
+			return errors.Join(errors.New("failed to connect to read replicas"), err)
+		}
+		for _, replica := range replicas {
+			if replicaDB, err := replica.DB(); err == nil {
+				defer replicaDB.Close()
+			}
+		}
+		api.Reads = service.NewReadRouter(db, replicas, cfg.Database.ReplicaMaxLag, nil)
+		logger.Info("Routing reads to replicas", "replicas", len(replicas))
+	}
+	if cfg.DebugHeaders() {
+		api.AttemptHeader = true
+		logger.Info("Debug headers enabled", "profile", cfg.Profile)
+	}
+	if cfg.Server.WebhookSecret != "" {
+		api.PaymentWebhooks = webhook.NewVerifier([]byte(cfg.Server.WebhookSecret), cfg.Server.WebhookTolerance)
+		logger.Info("Serving payment webhooks")
+	}
+	if cfg.Storage.DedupTTL > 0 {
+		api.Dedup = service.NewDeduplicator(db, cfg.Storage.DedupTTL, m)
+		api.DedupAdmin = cfg.Server.Admin
+		go api.Dedup.RunCleanup(background, dedupCleanupInterval, func(err error) {
+			logger.Error("Cleaning up dedup keys", logging.Err, err)
+		})
+	}
+	if cfg.Server.AsyncWorkers > 0 {
+		api.Async = service.NewAsyncUpdater(api.Updater, cfg.Server.AsyncWorkers, cfg.Server.AsyncQueue)
+	}
+	if cfg.Server.Admin {
+		api.Workers = &httpapi.Workers{Serializer: serializer, Async: api.Async, CPUQuota: quota}
+		api.HotKeys = contention
+	}
+	if cfg.Retry.DeadLetter {
+		reprocessor := service.NewReprocessor(db, api.Updater, 100)
+		go reprocessor.Run(background, deadLetterInterval, func(err error) {
+			logger.Error("Replaying failed updates", logging.Err, err)
+		})
+	}
+	if cfg.Scheduler.Enabled {
+		scheduler := schedule.NewScheduler(db, api.Updater, cfg.Scheduler.MaxCatchUp)
+		go scheduler.Run(background, cfg.Scheduler.Interval, func(err error) {
+			logger.Error("Running schedules", logging.Err, err)
+		})
+	}
+	if cfg.Profile == "test" {
+		logger.Warn("Test profile: serving /testing endpoints and simulated conflicts")
+		api.Conflicts = httpapi.NewConflictSimulator(httpapi.ConflictRules{
+			BalanceIDs: cfg.Testing.ConflictBalanceIDs,
+			EveryNth:   cfg.Testing.ConflictEveryNth,
+		})
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", api.Handler())
+	if cfg.Metrics.Backend == "prometheus" {
+		mux.Handle("GET /metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	}
+	server := &http.Server{Addr: cfg.Server.Addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	served := make(chan error, 1)
+	go func() { served <- server.ListenAndServe() }()
+	logger.Info("Serving", "addr", cfg.Server.Addr)
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Fail readiness first so no new traffic arrives, then wait for the
+	// updates already running to commit or roll back
+	logger.Info("Shutting down, draining in-flight requests", "timeout", cfg.Server.ShutdownTimeout)
+	api.Drain()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return errors.Join(errors.New("shutdown did not finish"), err)
+	}
+	if api.Async != nil {
+		if err := api.Async.Shutdown(shutdownCtx); err != nil {
+			return errors.Join(errors.New("async updates did not finish"), err)
+		}
+	}
+	logger.Info("Shutdown complete")
+	return nil
 }
 
-// getEnv gets environment variable or returns default value
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// migrate applies the pending schema migrations, or with mode "check" refuses
+// to start while any are pending or the schema drifted
+func migrate(db *gorm.DB, mode string, logger logging.Logger) error {
+	switch mode {
+	case "apply":
+		applied, err := migrations.Up(db, 0)
+		if err != nil {
+			return errors.Join(errors.New("failed to migrate database"), err)
+		}
+		logger.Info("Database migrated", "applied", len(applied), "version", migrations.Latest())
+	case "check":
+		drift, err := migrations.DetectDrift(db)
+		if err != nil {
+			return errors.Join(errors.New("failed to check the database schema"), err)
+		}
+		if len(drift) > 0 {
+			for _, d := range drift {
+				logger.Error("Schema drift", "drift", d.String())
+			}
+			return fmt.Errorf("database schema does not match this release (%d differences), run optlockctl migrate", len(drift))
+		}
 	}
-	return defaultValue
+	return nil
 }
@@ -0,0 +1,49 @@
+// Package metrics is the small instrumentation interface used across the
+// service, with adapters for Prometheus, OpenTelemetry and statsd/DogStatsD.
+package metrics
+
+// Labels are dimensions attached to a measurement. Keep their values low
+// cardinality: balance IDs do not belong here.
+type Labels map[string]string
+
+// Metrics receives measurements. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// Count adds delta to a monotonically increasing counter
+	Count(name string, delta int64, labels Labels)
+	// Observe records a sample, such as a latency in seconds, in a distribution
+	Observe(name string, value float64, labels Labels)
+	// Gauge sets a value that can go up and down
+	Gauge(name string, value float64, labels Labels)
+}
+
+// Nop discards every measurement
+type Nop struct{}
+
+func (Nop) Count(string, int64, Labels)     {}
+func (Nop) Observe(string, float64, Labels) {}
+func (Nop) Gauge(string, float64, Labels)   {}
+
+// Metric names recorded by the service package
+const (
+	UpdatesTotal     = "optlock_updates_total"           // label result: ok, conflict, error
+	AttemptsTotal    = "optlock_attempts_total"          // optimistic attempts made
+	ConflictsTotal   = "optlock_conflicts_total"         // attempts rejected by the version check
+	PessimisticTotal = "optlock_pessimistic_total"       // updates finished under a row lock
+	UpdateSeconds    = "optlock_update_duration_seconds" // end-to-end update latency
+	BackoffSeconds   = "optlock_backoff_seconds"         // time slept between attempts per update
+)
+
+// Metric names recorded by service.ContentionMonitor
+const (
+	ContendedBalances = "optlock_contended_balances" // gauge; balances with conflicts in the window
+	BalanceConflicts  = "optlock_balance_conflicts"  // conflicts of each contended balance over the window
+)
+
+// Metric names recorded by service.Deduplicator
+const (
+	DedupAppliedTotal    = "optlock_dedup_applied_total"    // references applied for the first time
+	DedupDuplicatesTotal = "optlock_dedup_duplicates_total" // calls skipped as duplicates
+	DedupPurgedTotal     = "optlock_dedup_purged_total"     // keys deleted past the window
+	DedupStoredKeys      = "optlock_dedup_stored_keys"      // gauge; keys in the window
+	DedupOldestSeconds   = "optlock_dedup_oldest_seconds"   // gauge; age of the oldest key
+)
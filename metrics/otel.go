@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// OTel records measurements with an OpenTelemetry meter. Instruments are
+// created on first use of each name; gauges are reported by an observable
+// gauge holding the last value set for each label set.
+type OTel struct {
+	meter metric.Meter
+
+	mu         sync.Mutex
+	counters   map[string]metric.Int64Counter
+	histograms map[string]metric.Float64Histogram
+	gauges     map[string]*otelGauge
+}
+
+type otelGauge struct {
+	mu     sync.Mutex
+	values map[attribute.Distinct]otelGaugeValue
+}
+
+type otelGaugeValue struct {
+	set   attribute.Set
+	value float64
+}
+
+// NewOTel returns an adapter creating instruments on meter
+func NewOTel(meter metric.Meter) *OTel {
+	return &OTel{
+		meter:      meter,
+		counters:   make(map[string]metric.Int64Counter),
+		histograms: make(map[string]metric.Float64Histogram),
+		gauges:     make(map[string]*otelGauge),
+	}
+}
+
+func (o *OTel) Count(name string, delta int64, labels Labels) {
+	o.mu.Lock()
+	c, ok := o.counters[name]
+	if !ok {
+		// Instrument errors come with a usable no-op instrument
+		c, _ = o.meter.Int64Counter(name)
+		o.counters[name] = c
+	}
+	o.mu.Unlock()
+	c.Add(context.Background(), delta, metric.WithAttributeSet(attributes(labels)))
+}
+
+func (o *OTel) Observe(name string, value float64, labels Labels) {
+	o.mu.Lock()
+	h, ok := o.histograms[name]
+	if !ok {
+		h, _ = o.meter.Float64Histogram(name)
+		o.histograms[name] = h
+	}
+	o.mu.Unlock()
+	h.Record(context.Background(), value, metric.WithAttributeSet(attributes(labels)))
+}
+
+func (o *OTel) Gauge(name string, value float64, labels Labels) {
+	o.mu.Lock()
+	g, ok := o.gauges[name]
+	if !ok {
+		g = &otelGauge{values: make(map[attribute.Distinct]otelGaugeValue)}
+		o.meter.Float64ObservableGauge(name, metric.WithFloat64Callback(g.observe))
+		o.gauges[name] = g
+	}
+	o.mu.Unlock()
+
+	set := attributes(labels)
+	g.mu.Lock()
+	g.values[set.Equivalent()] = otelGaugeValue{set: set, value: value}
+	g.mu.Unlock()
+}
+
+func (g *otelGauge) observe(_ context.Context, obs metric.Float64Observer) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, v := range g.values {
+		obs.Observe(v.value, metric.WithAttributeSet(v.set))
+	}
+	return nil
+}
+
+func attributes(labels Labels) attribute.Set {
+	kvs := make([]attribute.KeyValue, 0, len(labels))
+	for k, v := range labels {
+		kvs = append(kvs, attribute.String(k, v))
+	}
+	return attribute.NewSet(kvs...)
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus registers collectors on first use of each metric name. The label
+// names of a metric are fixed by its first measurement, as Prometheus requires.
+type Prometheus struct {
+	registerer prometheus.Registerer
+	buckets    []float64
+
+	mu         sync.Mutex
+	counters   map[string]*prometheus.CounterVec
+	histograms map[string]*prometheus.HistogramVec
+	gauges     map[string]*prometheus.GaugeVec
+}
+
+// NewPrometheus returns an adapter registering on reg (prometheus.DefaultRegisterer
+// if nil). Histograms use buckets, or prometheus.DefBuckets if nil.
+func NewPrometheus(reg prometheus.Registerer, buckets []float64) *Prometheus {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+	return &Prometheus{
+		registerer: reg,
+		buckets:    buckets,
+		counters:   make(map[string]*prometheus.CounterVec),
+		histograms: make(map[string]*prometheus.HistogramVec),
+		gauges:     make(map[string]*prometheus.GaugeVec),
+	}
+}
+
+func (p *Prometheus) Count(name string, delta int64, labels Labels) {
+	p.mu.Lock()
+	c, ok := p.counters[name]
+	if !ok {
+		c = register(p.registerer, prometheus.NewCounterVec(prometheus.CounterOpts{Name: name}, labelNames(labels)))
+		p.counters[name] = c
+	}
+	p.mu.Unlock()
+	c.With(prometheus.Labels(labels)).Add(float64(delta))
+}
+
+func (p *Prometheus) Observe(name string, value float64, labels Labels) {
+	p.mu.Lock()
+	h, ok := p.histograms[name]
+	if !ok {
+		h = register(p.registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Buckets: p.buckets}, labelNames(labels)))
+		p.histograms[name] = h
+	}
+	p.mu.Unlock()
+	h.With(prometheus.Labels(labels)).Observe(value)
+}
+
+func (p *Prometheus) Gauge(name string, value float64, labels Labels) {
+	p.mu.Lock()
+	g, ok := p.gauges[name]
+	if !ok {
+		g = register(p.registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name}, labelNames(labels)))
+		p.gauges[name] = g
+	}
+	p.mu.Unlock()
+	g.With(prometheus.Labels(labels)).Set(value)
+}
+
+// register adds c to reg. If an identical collector is already registered,
+// e.g. by another adapter on the same registry, that one is used instead.
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) C {
+	if err := reg.Register(c); err != nil {
+		var already prometheus.AlreadyRegisteredError
+		if errors.As(err, &already) {
+			if existing, ok := already.ExistingCollector.(C); ok {
+				return existing
+			}
+		}
+	}
+	return c
+}
+
+func labelNames(labels Labels) []string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Statsd sends measurements over UDP in the statsd line protocol, with labels
+// as DogStatsD tags (name:value|type|#key:value). Datadog agents and
+// statsd_exporter accept the tags; plain statsd servers ignore them.
+// Write errors are dropped, as is usual for statsd.
+type Statsd struct {
+	conn   net.Conn
+	prefix string
+}
+
+// NewStatsd dials addr ("host:port") over UDP. Prefix, if set, is prepended
+// to every metric name followed by a dot.
+func NewStatsd(addr, prefix string) (*Statsd, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" {
+		prefix += "."
+	}
+	return &Statsd{conn: conn, prefix: prefix}, nil
+}
+
+// Close closes the UDP socket
+func (s *Statsd) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Statsd) Count(name string, delta int64, labels Labels) {
+	s.send(name, strconv.FormatInt(delta, 10), "c", labels)
+}
+
+func (s *Statsd) Observe(name string, value float64, labels Labels) {
+	// Distributions are reported as milliseconds timings when given seconds
+	if strings.HasSuffix(name, "_seconds") {
+		s.send(name, strconv.FormatFloat(value*1000, 'f', -1, 64), "ms", labels)
+		return
+	}
+	s.send(name, strconv.FormatFloat(value, 'f', -1, 64), "h", labels)
+}
+
+func (s *Statsd) Gauge(name string, value float64, labels Labels) {
+	s.send(name, strconv.FormatFloat(value, 'f', -1, 64), "g", labels)
+}
+
+func (s *Statsd) send(name, value, kind string, labels Labels) {
+	line := fmt.Sprintf("%s%s:%s|%s", s.prefix, name, value, kind)
+	if len(labels) > 0 {
+		tags := make([]string, 0, len(labels))
+		for k, v := range labels {
+			tags = append(tags, k+":"+v)
+		}
+		sort.Strings(tags)
+		line += "|#" + strings.Join(tags, ",")
+	}
+	s.conn.Write([]byte(line))
+}
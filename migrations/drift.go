@@ -0,0 +1,82 @@
+package migrations
+
+import (
+	"fmt"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+// Drift is one difference between the database and what the migrations and
+// models expect
+type Drift struct {
+	Table   string
+	Object  string // column or index; empty for the table itself
+	Problem string
+}
+
+func (d Drift) String() string {
+	if d.Object == "" {
+		return fmt.Sprintf("%s: %s", d.Table, d.Problem)
+	}
+	return fmt.Sprintf("%s.%s: %s", d.Table, d.Object, d.Problem)
+}
+
+// DetectDrift reports pending and unknown migrations, and tables, columns
+// and indexes of Models that are missing from the database or columns the
+// database has that the models do not. Column types are not compared, as
+// they are spelled differently by every driver.
+func DetectDrift(db *gorm.DB) ([]Drift, error) {
+	statuses, err := Status(db)
+	if err != nil {
+		return nil, err
+	}
+	var drift []Drift
+	for _, s := range statuses {
+		switch {
+		case s.Unknown:
+			drift = append(drift, Drift{Table: "schema_migrations", Object: fmt.Sprint(s.Version), Problem: "applied migration unknown to this release: " + s.Name})
+		case !s.Applied:
+			drift = append(drift, Drift{Table: "schema_migrations", Object: fmt.Sprint(s.Version), Problem: "pending migration: " + s.Name})
+		}
+	}
+
+	migrator := db.Migrator()
+	for _, model := range Models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		table := stmt.Schema.Table
+		if !migrator.HasTable(model) {
+			drift = append(drift, Drift{Table: table, Problem: "missing table"})
+			continue
+		}
+
+		columns, err := migrator.ColumnTypes(model)
+		if err != nil {
+			return nil, fmt.Errorf("reading the columns of %s: %w", table, err)
+		}
+		var existing []string
+		for _, c := range columns {
+			existing = append(existing, c.Name())
+		}
+		for _, name := range stmt.Schema.DBNames {
+			if !slices.Contains(existing, name) {
+				drift = append(drift, Drift{Table: table, Object: name, Problem: "missing column"})
+			}
+		}
+		for _, name := range existing {
+			if !slices.Contains(stmt.Schema.DBNames, name) {
+				drift = append(drift, Drift{Table: table, Object: name, Problem: "column not in the model"})
+			}
+		}
+
+		for _, idx := range stmt.Schema.ParseIndexes() {
+			if !migrator.HasIndex(model, idx.Name) {
+				drift = append(drift, Drift{Table: table, Object: idx.Name, Problem: "missing index"})
+			}
+		}
+	}
+	return drift, nil
+}
@@ -0,0 +1,194 @@
+// Package migrations versions the database schema. Each Migration has an Up
+// and a Down step; the versions applied to a database are recorded in the
+// schema_migrations table. Migrations create tables from frozen snapshots of
+// the models rather than the models themselves, so changing a model later
+// takes a new migration instead of silently changing an old one. DetectDrift
+// compares the live schema with the current models.
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// record is a row of schema_migrations
+type record struct {
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:128"`
+	AppliedAt time.Time
+}
+
+func (record) TableName() string { return "schema_migrations" }
+
+// MigrationStatus reports whether a migration was applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Unknown   bool // applied to the database but not in All, e.g. by a newer release
+}
+
+// Latest returns the version of the last migration
+func Latest() int {
+	return All[len(All)-1].Version
+}
+
+// Up applies the pending migrations up to and including version to, in
+// order, or all of them if to is 0. Each migration runs in a transaction with
+// the insert of its schema_migrations row; on MySQL, where DDL commits
+// implicitly, a failed migration may leave part of its changes behind. On
+// Postgres and MySQL a lock keeps two processes from migrating at once. Up
+// returns the migrations it applied.
+func Up(db *gorm.DB, to int) ([]Migration, error) {
+	if to == 0 {
+		to = Latest()
+	}
+	var applied []Migration
+	err := locked(db, func(conn *gorm.DB) error {
+		done, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range All {
+			if _, ok := done[m.Version]; ok || m.Version > to {
+				continue
+			}
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := m.Up(tx); err != nil {
+					return err
+				}
+				return tx.Create(&record{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+			}
+			applied = append(applied, m)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the applied migrations above version to, newest first, and
+// returns them. Down(db, 0) reverts everything, dropping every table.
+func Down(db *gorm.DB, to int) ([]Migration, error) {
+	var reverted []Migration
+	err := locked(db, func(conn *gorm.DB) error {
+		done, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(All) - 1; i >= 0; i-- {
+			m := All[i]
+			if _, ok := done[m.Version]; !ok || m.Version <= to {
+				continue
+			}
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := m.Down(tx); err != nil {
+					return err
+				}
+				return tx.Delete(&record{Version: m.Version}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("reverting migration %d %s: %w", m.Version, m.Name, err)
+			}
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status lists every migration with whether it was applied, followed by the
+// versions applied to the database that this release does not know
+func Status(db *gorm.DB) ([]MigrationStatus, error) {
+	done, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(All))
+	for _, m := range All {
+		r, ok := done[m.Version]
+		statuses = append(statuses, MigrationStatus{Version: m.Version, Name: m.Name, Applied: ok, AppliedAt: r.AppliedAt})
+		delete(done, m.Version)
+	}
+
+	unknown := make([]MigrationStatus, 0, len(done))
+	for _, r := range done {
+		unknown = append(unknown, MigrationStatus{Version: r.Version, Name: r.Name, Applied: true, AppliedAt: r.AppliedAt, Unknown: true})
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+	return append(statuses, unknown...), nil
+}
+
+// Pending returns the migrations not yet applied
+func Pending(db *gorm.DB) ([]Migration, error) {
+	done, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, m := range All {
+		if _, ok := done[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// appliedVersions reads schema_migrations, creating it on first use
+func appliedVersions(db *gorm.DB) (map[int]record, error) {
+	if err := db.AutoMigrate(&record{}); err != nil {
+		return nil, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+	var records []record
+	if err := db.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	done := make(map[int]record, len(records))
+	for _, r := range records {
+		done[r.Version] = r
+	}
+	return done, nil
+}
+
+// lockKey identifies the migration lock on Postgres and MySQL
+var lockKey = func() int64 {
+	h := fnv.New64a()
+	h.Write([]byte("optimistic-lock/migrations"))
+	return int64(h.Sum64() >> 1)
+}()
+
+// locked runs fn on one connection holding the migration lock of the driver
+func locked(db *gorm.DB, fn func(conn *gorm.DB) error) error {
+	var lock, unlock string
+	switch db.Dialector.Name() {
+	case "postgres":
+		lock, unlock = "SELECT pg_advisory_lock(?)", "SELECT pg_advisory_unlock(?)"
+	case "mysql":
+		lock, unlock = "SELECT GET_LOCK(CONCAT('optlock-migrations-', ?), -1)", "SELECT RELEASE_LOCK(CONCAT('optlock-migrations-', ?))"
+	default:
+		return fn(db)
+	}
+	return db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec(lock, lockKey).Error; err != nil {
+			return fmt.Errorf("taking the migration lock: %w", err)
+		}
+		err := fn(conn)
+		return errors.Join(err, conn.Exec(unlock, lockKey).Error)
+	})
+}
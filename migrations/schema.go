@@ -0,0 +1,791 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/ids"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/outbox"
+)
+
+// All lists the migrations in version order. Append new ones; never edit or
+// renumber a migration that has been released.
+var All = []Migration{
+	{1, "create balances", createTables(&balanceV1{}), dropTables(&balanceV1{})},
+	{2, "create adjustments", createTables(&adjustmentV1{}), dropTables(&adjustmentV1{})},
+	{3, "create decimal balances", createTables(&decimalBalanceV1{}), dropTables(&decimalBalanceV1{})},
+	{4, "create failover epochs", createTables(&failoverEpochV1{}), dropTables(&failoverEpochV1{})},
+	{5, "create update conflicts", createTables(&updateConflictV1{}), dropTables(&updateConflictV1{})},
+	{6, "create failed updates", createTables(&failedUpdateV1{}), dropTables(&failedUpdateV1{})},
+	{7, "create balance events", createTables(&balanceEventV1{}), dropTables(&balanceEventV1{})},
+	{8, "create balance shards", createTables(&balanceShardV1{}), dropTables(&balanceShardV1{})},
+	{9, "create outbox", createTables(&outboxMessageV1{}, &outboxOffsetV1{}), dropTables(&outboxMessageV1{}, &outboxOffsetV1{})},
+	{10, "add balance tenants", addBalanceTenants, dropBalanceTenants},
+	{11, "add balance business keys", addBalanceKeys, dropBalanceKeys},
+	{12, "create schedules", createTables(&scheduleV1{}), dropTables(&scheduleV1{})},
+	{13, "create prepared updates", createTables(&preparedUpdateV1{}), dropTables(&preparedUpdateV1{})},
+	{14, "create balance limits", createTables(&balanceLimitV1{}), dropTables(&balanceLimitV1{})},
+	{15, "add balance limit usage", addLimitUsage, dropLimitUsage},
+	{16, "add balance status", addBalanceStatus, dropBalanceStatus},
+	{17, "create balance overrides", createTables(&balanceOverrideV1{}), dropTables(&balanceOverrideV1{})},
+	{18, "add balance uids", addBalanceUIDs, dropBalanceUIDs},
+	{19, "add balance metadata", addBalanceMetadata, dropBalanceMetadata},
+	{20, "add shard and decimal balance tenants", addShardTenants, dropShardTenants},
+	{21, "create dedup keys", createTables(&dedupKeyV1{}), dropTables(&dedupKeyV1{})},
+	{22, "backfill balance uids", backfillBalanceUIDs, keepBalanceUIDs},
+	{23, "scope idempotency keys to tenants", addKeyTenants, dropKeyTenants},
+	{24, "add override adjustment ids", addOverrideAdjustments, dropOverrideAdjustments},
+}
+
+// Models are the current models whose tables the migrations maintain.
+// DetectDrift compares the database with them.
+var Models = []any{
+	&models.Balance{}, &models.Adjustment{}, &models.DecimalBalance{}, &models.FailoverEpoch{},
+	&models.UpdateConflict{}, &models.FailedUpdate{}, &models.BalanceEvent{}, &models.BalanceShard{},
+	&outbox.Message{}, &outbox.Offset{}, &models.Schedule{}, &models.PreparedUpdate{},
+	&models.BalanceLimit{}, &models.BalanceOverride{}, &models.DedupKey{},
+}
+
+// createTables creates the tables of snapshots. A table that already exists
+// is left alone, so a database created by AutoMigrate before versioned
+// migrations is adopted as it is; DetectDrift reports where it differs.
+func createTables(snapshots ...any) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		for _, s := range snapshots {
+			if tx.Migrator().HasTable(s) {
+				continue
+			}
+			if err := tx.Migrator().CreateTable(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// dropTables drops the tables of snapshots in reverse order
+func dropTables(snapshots ...any) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			if err := tx.Migrator().DropTable(snapshots[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addBalanceTenants adds balances.tenant_id and makes owners unique per
+// tenant instead of globally. Existing balances belong to the empty tenant.
+func addBalanceTenants(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if !m.HasColumn(&balanceV2{}, "TenantID") {
+		if err := m.AddColumn(&balanceV2{}, "TenantID"); err != nil {
+			return err
+		}
+	}
+	if m.HasIndex(&balanceV1{}, "idx_balances_owner_id") {
+		if err := m.DropIndex(&balanceV1{}, "idx_balances_owner_id"); err != nil {
+			return err
+		}
+	}
+	if !m.HasIndex(&balanceV2{}, "idx_balances_tenant_owner") {
+		return m.CreateIndex(&balanceV2{}, "idx_balances_tenant_owner")
+	}
+	return nil
+}
+
+// dropBalanceTenants reverts addBalanceTenants. It fails if two tenants have
+// a balance for the same owner.
+func dropBalanceTenants(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if m.HasIndex(&balanceV2{}, "idx_balances_tenant_owner") {
+		if err := m.DropIndex(&balanceV2{}, "idx_balances_tenant_owner"); err != nil {
+			return err
+		}
+	}
+	if err := m.DropColumn(&balanceV2{}, "TenantID"); err != nil {
+		return err
+	}
+	return recreateIndexes(tx, &balanceV1{})
+}
+
+// addBalanceKeys adds balances.currency and balances.external_ref, making
+// owners unique per tenant and currency and references unique per tenant.
+// Existing balances get the empty currency and no reference.
+func addBalanceKeys(tx *gorm.DB) error {
+	m := tx.Migrator()
+	for _, column := range []string{"Currency", "ExternalRef"} {
+		if !m.HasColumn(&balanceV3{}, column) {
+			if err := m.AddColumn(&balanceV3{}, column); err != nil {
+				return err
+			}
+		}
+	}
+	if m.HasIndex(&balanceV2{}, "idx_balances_tenant_owner") {
+		if err := m.DropIndex(&balanceV2{}, "idx_balances_tenant_owner"); err != nil {
+			return err
+		}
+	}
+	for _, idx := range []string{"idx_balances_owner_key", "idx_balances_external_ref"} {
+		if !m.HasIndex(&balanceV3{}, idx) {
+			if err := m.CreateIndex(&balanceV3{}, idx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dropBalanceKeys reverts addBalanceKeys. It fails if an owner has balances
+// in several currencies.
+func dropBalanceKeys(tx *gorm.DB) error {
+	m := tx.Migrator()
+	for _, idx := range []string{"idx_balances_owner_key", "idx_balances_external_ref"} {
+		if m.HasIndex(&balanceV3{}, idx) {
+			if err := m.DropIndex(&balanceV3{}, idx); err != nil {
+				return err
+			}
+		}
+	}
+	for _, column := range []string{"ExternalRef", "Currency"} {
+		if err := m.DropColumn(&balanceV3{}, column); err != nil {
+			return err
+		}
+	}
+	return recreateIndexes(tx, &balanceV2{})
+}
+
+// addLimitUsage adds balance_limits.used and period_start, which periodic
+// limits count debits in
+func addLimitUsage(tx *gorm.DB) error {
+	m := tx.Migrator()
+	for _, column := range []string{"Used", "PeriodStart"} {
+		if !m.HasColumn(&balanceLimitV2{}, column) {
+			if err := m.AddColumn(&balanceLimitV2{}, column); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dropLimitUsage reverts addLimitUsage
+func dropLimitUsage(tx *gorm.DB) error {
+	m := tx.Migrator()
+	for _, column := range []string{"PeriodStart", "Used"} {
+		if err := m.DropColumn(&balanceLimitV2{}, column); err != nil {
+			return err
+		}
+	}
+	return recreateIndexes(tx, &balanceLimitV1{})
+}
+
+// addBalanceStatus adds balances.status. Existing balances are active.
+func addBalanceStatus(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if m.HasColumn(&balanceV4{}, "Status") {
+		return nil
+	}
+	return m.AddColumn(&balanceV4{}, "Status")
+}
+
+// dropBalanceStatus reverts addBalanceStatus; frozen and closed balances
+// become active again
+func dropBalanceStatus(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if err := m.DropColumn(&balanceV4{}, "Status"); err != nil {
+		return err
+	}
+	return recreateIndexes(tx, &balanceV3{})
+}
+
+// addBalanceUIDs adds balances.uid with its unique index. Existing balances
+// have none.
+func addBalanceUIDs(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if !m.HasColumn(&balanceV5{}, "UID") {
+		if err := m.AddColumn(&balanceV5{}, "UID"); err != nil {
+			return err
+		}
+	}
+	if !m.HasIndex(&balanceV5{}, "idx_balances_uid") {
+		return m.CreateIndex(&balanceV5{}, "idx_balances_uid")
+	}
+	return nil
+}
+
+// dropBalanceUIDs reverts addBalanceUIDs
+func dropBalanceUIDs(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if m.HasIndex(&balanceV5{}, "idx_balances_uid") {
+		if err := m.DropIndex(&balanceV5{}, "idx_balances_uid"); err != nil {
+			return err
+		}
+	}
+	if err := m.DropColumn(&balanceV5{}, "UID"); err != nil {
+		return err
+	}
+	return recreateIndexes(tx, &balanceV4{})
+}
+
+// addBalanceMetadata adds balances.metadata. Existing balances have none.
+func addBalanceMetadata(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if m.HasColumn(&balanceV6{}, "Metadata") {
+		return nil
+	}
+	return m.AddColumn(&balanceV6{}, "Metadata")
+}
+
+// dropBalanceMetadata reverts addBalanceMetadata
+func dropBalanceMetadata(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if err := m.DropColumn(&balanceV6{}, "Metadata"); err != nil {
+		return err
+	}
+	return recreateIndexes(tx, &balanceV5{})
+}
+
+// addShardTenants adds tenant_id to balance_shards and decimal_balances, so
+// the tenant plugin scopes them too. Shards take the tenant of the balance
+// with their BalanceID; other rows belong to the empty tenant.
+func addShardTenants(tx *gorm.DB) error {
+	m := tx.Migrator()
+	for _, model := range []any{&balanceShardV2{}, &decimalBalanceV2{}} {
+		if !m.HasColumn(model, "TenantID") {
+			if err := m.AddColumn(model, "TenantID"); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Exec(`UPDATE balance_shards SET tenant_id = (SELECT b.tenant_id FROM balances b WHERE b.id = balance_shards.balance_id)
+		WHERE EXISTS (SELECT 1 FROM balances b WHERE b.id = balance_shards.balance_id)`).Error
+}
+
+// dropShardTenants reverts addShardTenants
+func dropShardTenants(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if err := m.DropColumn(&decimalBalanceV2{}, "TenantID"); err != nil {
+		return err
+	}
+	if err := m.DropColumn(&balanceShardV2{}, "TenantID"); err != nil {
+		return err
+	}
+	return recreateIndexes(tx, &balanceShardV1{})
+}
+
+// recreateIndexes creates the indexes model declares that its table lacks.
+// SQLite drops a column by rebuilding the table, losing its indexes, so a
+// migration dropping a column calls it with the model the table reverts to.
+func recreateIndexes(tx *gorm.DB, model any) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	m := tx.Migrator()
+	for _, idx := range stmt.Schema.ParseIndexes() {
+		if !m.HasIndex(model, idx.Name) {
+			if err := m.CreateIndex(model, idx.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// backfillBatch is how many balances backfillBalanceUIDs fills per query
+const backfillBatch = 500
+
+// backfillBalanceUIDs gives every balance without a UID one, soft-deleted
+// balances included, so every balance can be addressed by its UID. It uses
+// the generator of the ids.Plugin of the database, or UUIDv7 without one.
+func backfillBalanceUIDs(tx *gorm.DB) error {
+	generate := ids.UUIDv7
+	if p, ok := tx.Config.Plugins[ids.Plugin{}.Name()].(ids.Plugin); ok && p.Generate != nil {
+		generate = p.Generate
+	}
+	for {
+		var missing []uint
+		if err := tx.Table("balances").Where("uid IS NULL").Limit(backfillBatch).Pluck("id", &missing).Error; err != nil {
+			return err
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		for _, id := range missing {
+			if err := tx.Table("balances").Where("id = ? AND uid IS NULL", id).Update("uid", generate()).Error; err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// keepBalanceUIDs reverts backfillBalanceUIDs by keeping the UIDs: they
+// cannot be told apart from the ones given at creation, and reverting
+// migration 18 drops them all
+func keepBalanceUIDs(*gorm.DB) error { return nil }
+
+// keyTables are the tables addKeyTenants scopes in place: their snapshot
+// before and after, and their unique index on the reference before and after
+var keyTables = []struct {
+	before, after       any
+	table               string
+	beforeIdx, afterIdx string
+}{
+	{&adjustmentV1{}, &adjustmentV2{}, "adjustments", "idx_adjustments_reference", "idx_adjustments_tenant_reference"},
+	{&failedUpdateV1{}, &failedUpdateV2{}, "failed_updates", "idx_failed_updates_idempotency_key", "idx_failed_updates_tenant_key"},
+}
+
+// addKeyTenants adds tenant_id to adjustments, failed_updates and dedup_keys
+// and makes their references unique per tenant instead of globally, so one
+// tenant's reference does not swallow another's payment. Rows take the tenant
+// of the balance with their BalanceID. dedup_keys is rebuilt, as the tenant
+// becomes part of its primary key.
+func addKeyTenants(tx *gorm.DB) error {
+	m := tx.Migrator()
+	for _, k := range keyTables {
+		if !m.HasColumn(k.after, "TenantID") {
+			if err := m.AddColumn(k.after, "TenantID"); err != nil {
+				return err
+			}
+		}
+		err := tx.Exec(`UPDATE ` + k.table + ` SET tenant_id = (SELECT b.tenant_id FROM balances b WHERE b.id = ` + k.table + `.balance_id)
+			WHERE EXISTS (SELECT 1 FROM balances b WHERE b.id = ` + k.table + `.balance_id)`).Error
+		if err != nil {
+			return err
+		}
+		if m.HasIndex(k.before, k.beforeIdx) {
+			if err := m.DropIndex(k.before, k.beforeIdx); err != nil {
+				return err
+			}
+		}
+		if !m.HasIndex(k.after, k.afterIdx) {
+			if err := m.CreateIndex(k.after, k.afterIdx); err != nil {
+				return err
+			}
+		}
+	}
+	if m.HasColumn(&dedupKeyV2{}, "TenantID") {
+		return nil
+	}
+	return rebuildTable(tx, &dedupKeyV1{}, &dedupKeyV2{}, `INSERT INTO dedup_keys (tenant_id, reference, balance_id, created_at)
+		SELECT COALESCE((SELECT b.tenant_id FROM balances b WHERE b.id = k.balance_id), ''), k.reference, k.balance_id, k.created_at
+		FROM dedup_keys_rebuild k`)
+}
+
+// dropKeyTenants reverts addKeyTenants. It fails if two tenants used the same
+// reference.
+func dropKeyTenants(tx *gorm.DB) error {
+	m := tx.Migrator()
+	err := rebuildTable(tx, &dedupKeyV2{}, &dedupKeyV1{}, `INSERT INTO dedup_keys (reference, balance_id, created_at)
+		SELECT reference, balance_id, created_at FROM dedup_keys_rebuild`)
+	if err != nil {
+		return err
+	}
+	for _, k := range keyTables {
+		if m.HasIndex(k.after, k.afterIdx) {
+			if err := m.DropIndex(k.after, k.afterIdx); err != nil {
+				return err
+			}
+		}
+		if err := m.DropColumn(k.after, "TenantID"); err != nil {
+			return err
+		}
+		if err := recreateIndexes(tx, k.before); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addOverrideAdjustments adds balance_overrides.adjustment_id, which orders
+// an override among the adjustments of its balance by ID rather than by
+// clock. Existing overrides take the last adjustment created before them,
+// the best their timestamps can tell.
+func addOverrideAdjustments(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if m.HasColumn(&balanceOverrideV2{}, "AdjustmentID") {
+		return nil
+	}
+	if err := m.AddColumn(&balanceOverrideV2{}, "AdjustmentID"); err != nil {
+		return err
+	}
+	return tx.Exec(`UPDATE balance_overrides SET adjustment_id = COALESCE((SELECT MAX(a.id) FROM adjustments a
+		WHERE a.balance_id = balance_overrides.balance_id AND a.created_at <= balance_overrides.created_at), 0)`).Error
+}
+
+// dropOverrideAdjustments reverts addOverrideAdjustments
+func dropOverrideAdjustments(tx *gorm.DB) error {
+	if err := tx.Migrator().DropColumn(&balanceOverrideV2{}, "AdjustmentID"); err != nil {
+		return err
+	}
+	return recreateIndexes(tx, &balanceOverrideV1{})
+}
+
+// rebuildTable replaces the table of from with a new one shaped like to, for
+// changes such as a new primary key that not every database makes in place.
+// insert copies the rows, reading them from the old table, which is renamed
+// to <table>_rebuild until it is dropped at the end.
+func rebuildTable(tx *gorm.DB, from, to any, insert string) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(from); err != nil {
+		return err
+	}
+	table := stmt.Schema.Table
+	old := table + "_rebuild"
+	m := tx.Migrator()
+	// Index names are unique per schema rather than per table, so the new
+	// table can only create its indexes once the old ones are gone
+	for _, idx := range stmt.Schema.ParseIndexes() {
+		if m.HasIndex(from, idx.Name) {
+			if err := m.DropIndex(from, idx.Name); err != nil {
+				return err
+			}
+		}
+	}
+	if err := m.RenameTable(table, old); err != nil {
+		return err
+	}
+	if tx.Dialector.Name() == "postgres" {
+		// The primary key constraint keeps its name too
+		if err := tx.Exec(`ALTER TABLE ` + old + ` RENAME CONSTRAINT ` + table + `_pkey TO ` + old + `_pkey`).Error; err != nil {
+			return err
+		}
+	}
+	if err := m.CreateTable(to); err != nil {
+		return err
+	}
+	if err := tx.Exec(insert).Error; err != nil {
+		return err
+	}
+	return m.DropTable(old)
+}
+
+// The snapshots below are the tables as each migration created them. Keep
+// them unchanged when a model changes; add a migration that alters the table.
+
+type balanceV1 struct {
+	ID        uint    `gorm:"primaryKey"`
+	OwnerID   *string `gorm:"size:128;uniqueIndex"`
+	Amount    int64
+	Version   int
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+func (balanceV1) TableName() string { return "balances" }
+
+type balanceV2 struct {
+	ID        uint    `gorm:"primaryKey"`
+	TenantID  string  `gorm:"size:64;not null;default:'';uniqueIndex:idx_balances_tenant_owner,priority:1"`
+	OwnerID   *string `gorm:"size:128;uniqueIndex:idx_balances_tenant_owner,priority:2"`
+	Amount    int64
+	Version   int
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+func (balanceV2) TableName() string { return "balances" }
+
+type balanceV3 struct {
+	ID          uint    `gorm:"primaryKey"`
+	TenantID    string  `gorm:"size:64;not null;default:'';uniqueIndex:idx_balances_owner_key,priority:1;uniqueIndex:idx_balances_external_ref,priority:1"`
+	OwnerID     *string `gorm:"size:128;uniqueIndex:idx_balances_owner_key,priority:2"`
+	Currency    string  `gorm:"size:3;not null;default:'';uniqueIndex:idx_balances_owner_key,priority:3"`
+	ExternalRef *string `gorm:"size:128;uniqueIndex:idx_balances_external_ref,priority:2"`
+	Amount      int64
+	Version     int
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+}
+
+func (balanceV3) TableName() string { return "balances" }
+
+type balanceV4 struct {
+	ID          uint    `gorm:"primaryKey"`
+	TenantID    string  `gorm:"size:64;not null;default:'';uniqueIndex:idx_balances_owner_key,priority:1;uniqueIndex:idx_balances_external_ref,priority:1"`
+	OwnerID     *string `gorm:"size:128;uniqueIndex:idx_balances_owner_key,priority:2"`
+	Currency    string  `gorm:"size:3;not null;default:'';uniqueIndex:idx_balances_owner_key,priority:3"`
+	ExternalRef *string `gorm:"size:128;uniqueIndex:idx_balances_external_ref,priority:2"`
+	Amount      int64
+	Version     int
+	Status      string         `gorm:"size:16;not null;default:'active'"`
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+}
+
+func (balanceV4) TableName() string { return "balances" }
+
+type balanceV5 struct {
+	ID          uint    `gorm:"primaryKey"`
+	TenantID    string  `gorm:"size:64;not null;default:'';uniqueIndex:idx_balances_owner_key,priority:1;uniqueIndex:idx_balances_external_ref,priority:1"`
+	UID         *string `gorm:"size:36;uniqueIndex:idx_balances_uid"`
+	OwnerID     *string `gorm:"size:128;uniqueIndex:idx_balances_owner_key,priority:2"`
+	Currency    string  `gorm:"size:3;not null;default:'';uniqueIndex:idx_balances_owner_key,priority:3"`
+	ExternalRef *string `gorm:"size:128;uniqueIndex:idx_balances_external_ref,priority:2"`
+	Amount      int64
+	Version     int
+	Status      string         `gorm:"size:16;not null;default:'active'"`
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+}
+
+func (balanceV5) TableName() string { return "balances" }
+
+type balanceV6 struct {
+	ID          uint    `gorm:"primaryKey"`
+	TenantID    string  `gorm:"size:64;not null;default:'';uniqueIndex:idx_balances_owner_key,priority:1;uniqueIndex:idx_balances_external_ref,priority:1"`
+	UID         *string `gorm:"size:36;uniqueIndex:idx_balances_uid"`
+	OwnerID     *string `gorm:"size:128;uniqueIndex:idx_balances_owner_key,priority:2"`
+	Currency    string  `gorm:"size:3;not null;default:'';uniqueIndex:idx_balances_owner_key,priority:3"`
+	ExternalRef *string `gorm:"size:128;uniqueIndex:idx_balances_external_ref,priority:2"`
+	Amount      int64
+	Version     int
+	Status      string `gorm:"size:16;not null;default:'active'"`
+	Metadata    models.Metadata
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+}
+
+func (balanceV6) TableName() string { return "balances" }
+
+type adjustmentV1 struct {
+	ID        uint   `gorm:"primaryKey"`
+	Reference string `gorm:"size:128;uniqueIndex"`
+	BalanceID uint   `gorm:"index"`
+	Delta     int64
+	CreatedAt time.Time
+}
+
+func (adjustmentV1) TableName() string { return "adjustments" }
+
+type decimalBalanceV1 struct {
+	ID      uint            `gorm:"primaryKey"`
+	Amount  decimal.Decimal `gorm:"type:numeric(38,18)"`
+	Version int
+}
+
+func (decimalBalanceV1) TableName() string { return "decimal_balances" }
+
+type decimalBalanceV2 struct {
+	ID       uint            `gorm:"primaryKey"`
+	TenantID string          `gorm:"size:64;not null;default:''"`
+	Amount   decimal.Decimal `gorm:"type:numeric(38,18)"`
+	Version  int
+}
+
+func (decimalBalanceV2) TableName() string { return "decimal_balances" }
+
+type failoverEpochV1 struct {
+	ID         uint `gorm:"primaryKey"`
+	Epoch      int64
+	Region     string `gorm:"size:64"`
+	PromotedAt time.Time
+}
+
+func (failoverEpochV1) TableName() string { return "failover_epoches" }
+
+type updateConflictV1 struct {
+	ID              uint   `gorm:"primaryKey"`
+	BalanceID       uint   `gorm:"index"`
+	Actor           string `gorm:"size:128"`
+	ExpectedVersion int
+	ActualVersion   int
+	Delta           int64
+	Attempt         int
+	CreatedAt       time.Time `gorm:"index"`
+}
+
+func (updateConflictV1) TableName() string { return "update_conflicts" }
+
+type failedUpdateV1 struct {
+	ID             uint   `gorm:"primaryKey"`
+	IdempotencyKey string `gorm:"size:128;uniqueIndex"`
+	BalanceID      uint   `gorm:"index"`
+	Delta          int64
+	Attempts       int
+	Replays        int
+	LastError      string     `gorm:"size:512"`
+	NextReplayAt   time.Time  `gorm:"index"`
+	ReplayedAt     *time.Time `gorm:"index"`
+	CreatedAt      time.Time
+}
+
+func (failedUpdateV1) TableName() string { return "failed_updates" }
+
+type balanceEventV1 struct {
+	ID        uint   `gorm:"primaryKey"`
+	BalanceID uint   `gorm:"uniqueIndex:idx_balance_event_version"`
+	Version   int    `gorm:"uniqueIndex:idx_balance_event_version"`
+	Kind      string `gorm:"size:16"`
+	Delta     int64
+	Amount    int64
+	CreatedAt time.Time
+}
+
+func (balanceEventV1) TableName() string { return "balance_events" }
+
+type balanceShardV1 struct {
+	ID        uint `gorm:"primaryKey"`
+	BalanceID uint `gorm:"uniqueIndex:idx_balance_shard"`
+	Shard     int  `gorm:"uniqueIndex:idx_balance_shard"`
+	Amount    int64
+	Version   int
+}
+
+func (balanceShardV1) TableName() string { return "balance_shards" }
+
+type balanceShardV2 struct {
+	ID        uint   `gorm:"primaryKey"`
+	TenantID  string `gorm:"size:64;not null;default:''"`
+	BalanceID uint   `gorm:"uniqueIndex:idx_balance_shard"`
+	Shard     int    `gorm:"uniqueIndex:idx_balance_shard"`
+	Amount    int64
+	Version   int
+}
+
+func (balanceShardV2) TableName() string { return "balance_shards" }
+
+type outboxMessageV1 struct {
+	ID        uint64 `gorm:"primaryKey"`
+	BalanceID uint
+	Payload   []byte
+	CreatedAt time.Time
+}
+
+func (outboxMessageV1) TableName() string { return "outbox_messages" }
+
+type outboxOffsetV1 struct {
+	Relay     string `gorm:"primaryKey;size:64"`
+	MessageID uint64
+	UpdatedAt time.Time
+}
+
+func (outboxOffsetV1) TableName() string { return "outbox_offsets" }
+
+type scheduleV1 struct {
+	ID        uint   `gorm:"primaryKey"`
+	Name      string `gorm:"size:128;uniqueIndex"`
+	BalanceID uint   `gorm:"index"`
+	Spec      string `gorm:"size:128"`
+	Delta     int64
+	RateBps   int64
+	NextRunAt time.Time `gorm:"index"`
+	LastRunAt *time.Time
+	Paused    bool
+	Version   int
+	CreatedAt time.Time
+}
+
+func (scheduleV1) TableName() string { return "schedules" }
+
+type preparedUpdateV1 struct {
+	ID              string `gorm:"primaryKey;size:32"`
+	TenantID        string `gorm:"size:64;not null;default:''"`
+	BalanceID       uint   `gorm:"index"`
+	Delta           int64
+	ExpectedVersion int
+	Status          string    `gorm:"size:16"`
+	ExpiresAt       time.Time `gorm:"index"`
+	CreatedAt       time.Time
+	ResolvedAt      *time.Time
+}
+
+func (preparedUpdateV1) TableName() string { return "prepared_updates" }
+
+type balanceLimitV1 struct {
+	ID        uint   `gorm:"primaryKey"`
+	TenantID  string `gorm:"size:64;not null;default:''"`
+	BalanceID uint   `gorm:"uniqueIndex:idx_balance_limit"`
+	Kind      string `gorm:"size:64;uniqueIndex:idx_balance_limit"`
+	Amount    int64
+	Version   int
+}
+
+func (balanceLimitV1) TableName() string { return "balance_limits" }
+
+type balanceLimitV2 struct {
+	ID          uint   `gorm:"primaryKey"`
+	TenantID    string `gorm:"size:64;not null;default:''"`
+	BalanceID   uint   `gorm:"uniqueIndex:idx_balance_limit"`
+	Kind        string `gorm:"size:64;uniqueIndex:idx_balance_limit"`
+	Amount      int64
+	Version     int
+	Used        int64
+	PeriodStart *time.Time
+}
+
+func (balanceLimitV2) TableName() string { return "balance_limits" }
+
+type balanceOverrideV1 struct {
+	ID              uint   `gorm:"primaryKey"`
+	TenantID        string `gorm:"size:64;not null;default:''"`
+	BalanceID       uint   `gorm:"index"`
+	Actor           string `gorm:"size:128"`
+	Reason          string `gorm:"size:512"`
+	PreviousAmount  int64
+	Amount          int64
+	PreviousVersion int
+	Version         int
+	CreatedAt       time.Time `gorm:"index"`
+}
+
+func (balanceOverrideV1) TableName() string { return "balance_overrides" }
+
+type balanceOverrideV2 struct {
+	ID              uint   `gorm:"primaryKey"`
+	TenantID        string `gorm:"size:64;not null;default:''"`
+	BalanceID       uint   `gorm:"index"`
+	Actor           string `gorm:"size:128"`
+	Reason          string `gorm:"size:512"`
+	PreviousAmount  int64
+	Amount          int64
+	PreviousVersion int
+	Version         int
+	AdjustmentID    uint
+	CreatedAt       time.Time `gorm:"index"`
+}
+
+func (balanceOverrideV2) TableName() string { return "balance_overrides" }
+
+type dedupKeyV1 struct {
+	Reference string    `gorm:"primaryKey;size:128"`
+	BalanceID uint      `gorm:"index"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (dedupKeyV1) TableName() string { return "dedup_keys" }
+
+type dedupKeyV2 struct {
+	TenantID  string    `gorm:"primaryKey;size:64;default:''"`
+	Reference string    `gorm:"primaryKey;size:128"`
+	BalanceID uint      `gorm:"index"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (dedupKeyV2) TableName() string { return "dedup_keys" }
+
+type adjustmentV2 struct {
+	ID        uint   `gorm:"primaryKey"`
+	TenantID  string `gorm:"size:64;not null;default:'';uniqueIndex:idx_adjustments_tenant_reference,priority:1"`
+	Reference string `gorm:"size:128;uniqueIndex:idx_adjustments_tenant_reference,priority:2"`
+	BalanceID uint   `gorm:"index"`
+	Delta     int64
+	CreatedAt time.Time
+}
+
+func (adjustmentV2) TableName() string { return "adjustments" }
+
+type failedUpdateV2 struct {
+	ID             uint   `gorm:"primaryKey"`
+	TenantID       string `gorm:"size:64;not null;default:'';uniqueIndex:idx_failed_updates_tenant_key,priority:1"`
+	IdempotencyKey string `gorm:"size:128;uniqueIndex:idx_failed_updates_tenant_key,priority:2"`
+	BalanceID      uint   `gorm:"index"`
+	Delta          int64
+	Attempts       int
+	Replays        int
+	LastError      string     `gorm:"size:512"`
+	NextReplayAt   time.Time  `gorm:"index"`
+	ReplayedAt     *time.Time `gorm:"index"`
+	CreatedAt      time.Time
+}
+
+func (failedUpdateV2) TableName() string { return "failed_updates" }
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Adjustment records a balance change applied under an idempotency reference
+type Adjustment struct {
+	ID        uint   `gorm:"primaryKey"`
+	TenantID  string `gorm:"size:64;not null;default:'';uniqueIndex:idx_adjustments_tenant_reference,priority:1"` // see package tenant
+	Reference string `gorm:"size:128;uniqueIndex:idx_adjustments_tenant_reference,priority:2"`                    // idempotency key, one application per reference and tenant
+	BalanceID uint   `gorm:"index"`
+	Delta     int64
+	CreatedAt time.Time
+}
@@ -1,7 +1,26 @@
 package models
 
+import "gorm.io/gorm"
+
 type Balance struct {
-	ID      uint  `gorm:"primaryKey"`
-	Amount  int64 // your balance field
-	Version int   `gorm:"version"` // enables optimistic locking
+	ID          uint           `gorm:"primaryKey"`
+	TenantID    string         `gorm:"size:64;not null;default:'';uniqueIndex:idx_balances_owner_key,priority:1;uniqueIndex:idx_balances_external_ref,priority:1"` // see package tenant; empty in single-tenant deployments
+	UID         *string        `gorm:"size:36;uniqueIndex:idx_balances_uid"`                                                                                       // globally unique UUID or ULID set by ids.Plugin; nil without it
+	OwnerID     *string        `gorm:"size:128;uniqueIndex:idx_balances_owner_key,priority:2"`                                                                     // at most one balance per owner, currency and tenant; nil for unowned balances
+	Currency    string         `gorm:"size:3;not null;default:'';uniqueIndex:idx_balances_owner_key,priority:3"`                                                   // ISO 4217 code; empty in single-currency deployments
+	ExternalRef *string        `gorm:"size:128;uniqueIndex:idx_balances_external_ref,priority:2"`                                                                  // the caller's own identifier, unique per tenant; nil if unused
+	Amount      int64          // your balance field; kept in the clear, as the atomic and RETURNING strategies add to it in SQL
+	Version     int            `gorm:"version"`                           // enables optimistic locking
+	Status      string         `gorm:"size:16;not null;default:'active'"` // active, frozen or closed; see service.Freeze
+	Metadata    Metadata       // free-form JSON object; see service.PatchMetadata
+	DeletedAt   gorm.DeletedAt `gorm:"index"` // soft delete; deleted rows are skipped by reads and updates
+
+	Limits []BalanceLimit `gorm:"foreignKey:BalanceID"` // loaded by service.GetBalanceAggregate only
 }
+
+// Statuses of a Balance. Only active balances take updates.
+const (
+	BalanceActive = "active"
+	BalanceFrozen = "frozen"
+	BalanceClosed = "closed"
+)
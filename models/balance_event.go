@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Kinds of BalanceEvent
+const (
+	BalanceEventChange   = "change"   // an update applied Delta
+	BalanceEventRepair   = "repair"   // Rebuild reset the snapshot to the amount the events add up to
+	BalanceEventStatus   = "status"   // a status change bumped the version; Delta is zero
+	BalanceEventMetadata = "metadata" // a metadata patch bumped the version; Delta is zero
+)
+
+// BalanceEvent is one change of a balance in the event-sourced storage mode,
+// appended in the same transaction as the write to the balance row. Version
+// is the balance version the change produced and is unique per balance, so
+// the events of a balance form a gapless chain.
+type BalanceEvent struct {
+	ID        uint   `gorm:"primaryKey"`
+	BalanceID uint   `gorm:"uniqueIndex:idx_balance_event_version"`
+	Version   int    `gorm:"uniqueIndex:idx_balance_event_version"`
+	Kind      string `gorm:"size:16"`
+	Delta     int64
+	Amount    int64 // amount after the change
+	CreatedAt time.Time
+}
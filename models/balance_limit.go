@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// BalanceLimit is a limit of a balance, such as a daily debit cap, saved
+// with it as one aggregate by service.SaveBalanceAggregate. Each limit has
+// its own version, so a writer that changed it outside the aggregate is
+// detected too.
+type BalanceLimit struct {
+	ID        uint   `gorm:"primaryKey"`
+	TenantID  string `gorm:"size:64;not null;default:''"`
+	BalanceID uint   `gorm:"uniqueIndex:idx_balance_limit"`
+	Kind      string `gorm:"size:64;uniqueIndex:idx_balance_limit"` // e.g. daily_debit or max_amount
+	Amount    int64
+	Version   int `gorm:"version"`
+
+	// Used is what periodic limits have counted in the period starting at
+	// PeriodStart; written with the balance by service.WithSpendingLimits
+	// and left alone by SaveBalanceAggregate
+	Used        int64
+	PeriodStart *time.Time
+}
+
+// Kinds of BalanceLimit checked by service.ValidateUpdate
+const (
+	LimitMinAmount = "min_amount" // lowest amount a debit may leave; 0 without it. Also the service.MinimumBalance policy.
+	LimitMaxAmount = "max_amount" // highest amount a credit may reach
+)
+
+// Kinds of BalanceLimit configuring the policies of service.WithPolicies
+const (
+	LimitOverdraft       = "overdraft"        // how far below zero a debit may take the balance
+	LimitReservationOver = "reservation_over" // debits larger than this must commit a prepared update
+)
+
+// Periodic kinds of BalanceLimit, capping the debits of a UTC calendar
+// period. Enforced by service.WithSpendingLimits.
+const (
+	LimitDailyDebit   = "daily_debit"
+	LimitMonthlyDebit = "monthly_debit"
+)
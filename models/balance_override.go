@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// BalanceOverride records an amount set by service.ForceSet outside the
+// version check, with who set it and why, so support fixes stay auditable
+type BalanceOverride struct {
+	ID              uint   `gorm:"primaryKey"`
+	TenantID        string `gorm:"size:64;not null;default:''"`
+	BalanceID       uint   `gorm:"index"`
+	Actor           string `gorm:"size:128"`
+	Reason          string `gorm:"size:512"`
+	PreviousAmount  int64
+	Amount          int64
+	PreviousVersion int
+	Version         int
+	AdjustmentID    uint      // the last adjustment of the balance when it was set; later ones build on this override
+	CreatedAt       time.Time `gorm:"index"`
+}
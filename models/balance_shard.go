@@ -0,0 +1,14 @@
+package models
+
+// BalanceShard is one sub-row of a sharded balance. The amount of the
+// logical balance BalanceID is the sum of its shards, which are written
+// independently under their own versions so concurrent writers spread over
+// several rows instead of conflicting on one.
+type BalanceShard struct {
+	ID        uint   `gorm:"primaryKey"`
+	TenantID  string `gorm:"size:64;not null;default:''"` // see package tenant
+	BalanceID uint   `gorm:"uniqueIndex:idx_balance_shard"`
+	Shard     int    `gorm:"uniqueIndex:idx_balance_shard"`
+	Amount    int64
+	Version   int `gorm:"version"`
+}
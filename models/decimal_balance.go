@@ -0,0 +1,12 @@
+package models
+
+import "github.com/shopspring/decimal"
+
+// DecimalBalance is a Balance with a fractional amount stored as NUMERIC, for
+// domains where int64 minor units are not acceptable
+type DecimalBalance struct {
+	ID       uint            `gorm:"primaryKey"`
+	TenantID string          `gorm:"size:64;not null;default:''"` // see package tenant
+	Amount   decimal.Decimal `gorm:"type:numeric(38,18)"`
+	Version  int             `gorm:"version"` // enables optimistic locking
+}
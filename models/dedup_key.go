@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// DedupKey holds a reference within the idempotency window of
+// service.Deduplicator. Unlike the adjustments, which are the ledger, keys
+// are deleted once they are older than the window.
+type DedupKey struct {
+	TenantID  string    `gorm:"primaryKey;size:64;default:''"` // see package tenant
+	Reference string    `gorm:"primaryKey;size:128"`
+	BalanceID uint      `gorm:"index"`
+	CreatedAt time.Time `gorm:"index"`
+}
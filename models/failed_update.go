@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// FailedUpdate is a balance update that exhausted its retries, kept so it can
+// be replayed instead of lost
+type FailedUpdate struct {
+	ID             uint   `gorm:"primaryKey"`
+	TenantID       string `gorm:"size:64;not null;default:'';uniqueIndex:idx_failed_updates_tenant_key,priority:1"` // tenant the replay is scoped to
+	IdempotencyKey string `gorm:"size:128;uniqueIndex:idx_failed_updates_tenant_key,priority:2"`                    // adjustment reference the replay is applied under
+	BalanceID      uint   `gorm:"index"`
+	Delta          int64
+	Attempts       int        // attempts made by the original update
+	Replays        int        // failed replays so far
+	LastError      string     `gorm:"size:512"`
+	NextReplayAt   time.Time  `gorm:"index"`
+	ReplayedAt     *time.Time `gorm:"index"` // nil until replayed
+	CreatedAt      time.Time
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// FailoverEpoch is the single row recording which region last became active.
+// Every promotion increments Epoch, fencing out the previously active region.
+type FailoverEpoch struct {
+	ID         uint `gorm:"primaryKey"`
+	Epoch      int64
+	Region     string `gorm:"size:64"`
+	PromotedAt time.Time
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Metadata is a JSON object stored with a balance, in a jsonb column on
+// Postgres, json on MySQL and text on SQLite. Change it with
+// service.PatchMetadata, under the version of the balance.
+type Metadata map[string]any
+
+// Value implements driver.Valuer
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(map[string]any(m))
+	return string(b), err
+}
+
+// Scan implements sql.Scanner
+func (m *Metadata) Scan(value any) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Metadata", value)
+	}
+	return json.Unmarshal(b, (*map[string]any)(m))
+}
+
+// GormDataType implements schema.GormDataTypeInterface
+func (Metadata) GormDataType() string { return "json" }
+
+// GormDBDataType implements migrator.GormDataTypeInterface
+func (Metadata) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return "JSONB"
+	case "mysql":
+		return "JSON"
+	}
+	return "TEXT"
+}
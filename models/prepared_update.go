@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Statuses of a PreparedUpdate
+const (
+	PreparedPending   = "prepared"
+	PreparedCommitted = "committed"
+	PreparedAborted   = "aborted"
+	PreparedExpired   = "expired"
+)
+
+// PreparedUpdate is the first phase of a two-phase update: a delta pinned to
+// the balance version it was prepared at, waiting to be committed or aborted
+// before ExpiresAt
+type PreparedUpdate struct {
+	ID              string `gorm:"primaryKey;size:32"` // opaque token handed to the caller
+	TenantID        string `gorm:"size:64;not null;default:''"`
+	BalanceID       uint   `gorm:"index"`
+	Delta           int64
+	ExpectedVersion int
+	Status          string    `gorm:"size:16"`
+	ExpiresAt       time.Time `gorm:"index"`
+	CreatedAt       time.Time
+	ResolvedAt      *time.Time // nil while prepared
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Schedule is a recurring adjustment of a balance, such as a monthly fee or
+// daily interest, applied by the schedule package. Each run is applied as
+// an adjustment referenced by the schedule and the run time, so it lands
+// once however often it is attempted.
+type Schedule struct {
+	ID        uint       `gorm:"primaryKey"`
+	Name      string     `gorm:"size:128;uniqueIndex"`
+	BalanceID uint       `gorm:"index"`
+	Spec      string     `gorm:"size:128"` // cron expression in UTC, or @every <duration>
+	Delta     int64      // added every run
+	RateBps   int64      // plus this many basis points of the amount at run time, e.g. interest; may be negative
+	NextRunAt time.Time  `gorm:"index"`
+	LastRunAt *time.Time // nil until the first run
+	Paused    bool
+	Version   int // guards NextRunAt between scheduler instances
+	CreatedAt time.Time
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// UpdateConflict records a write rejected because the balance version had
+// moved, kept for investigating disputed operations
+type UpdateConflict struct {
+	ID              uint   `gorm:"primaryKey"`
+	BalanceID       uint   `gorm:"index"`
+	Actor           string `gorm:"size:128"`
+	ExpectedVersion int
+	ActualVersion   int
+	Delta           int64
+	Attempt         int
+	CreatedAt       time.Time `gorm:"index"`
+}
@@ -0,0 +1,155 @@
+// Package outbox implements the transactional outbox: balance events are
+// inserted into the outbox_messages table in the same transaction as the
+// balance update, and a Relay publishes them afterwards. An event is therefore
+// stored if and only if its update commits, and a crash between commit and
+// publish only delays delivery instead of losing the event.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ghozilaaa/optimistic-lock/events"
+)
+
+// Message is an event waiting in the outbox
+type Message struct {
+	ID        uint64 `gorm:"primaryKey"`
+	BalanceID uint
+	Payload   []byte
+	CreatedAt time.Time
+}
+
+func (Message) TableName() string { return "outbox_messages" }
+
+// Offset is the ID of the last message a relay has published
+type Offset struct {
+	Relay     string `gorm:"primaryKey;size:64"`
+	MessageID uint64
+	UpdatedAt time.Time
+}
+
+func (Offset) TableName() string { return "outbox_offsets" }
+
+// Migrate creates the outbox tables
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Message{}, &Offset{})
+}
+
+// Enqueue stores event in the outbox. Pass the transaction that performs the
+// balance update so both commit or roll back together.
+func Enqueue(tx *gorm.DB, event events.BalanceChanged) error {
+	return tx.Create(&Message{BalanceID: event.BalanceID, Payload: event.AppendJSON(nil)}).Error
+}
+
+// Relay publishes outbox messages in ID order and records its progress in
+// outbox_offsets under its name, so a restarted relay resumes where it
+// stopped. Delivery is at least once: a message published just before a crash
+// and not yet recorded is published again, so consumers should de-duplicate
+// by balance ID and version. Run one relay per name at a time.
+type Relay struct {
+	db        *gorm.DB
+	name      string
+	publisher events.Publisher
+	batchSize int
+}
+
+// NewRelay returns a Relay that publishes to p, reading batchSize messages at a time (default 100)
+func NewRelay(db *gorm.DB, name string, p events.Publisher, batchSize int) *Relay {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	return &Relay{db: db, name: name, publisher: p, batchSize: batchSize}
+}
+
+// Offset returns the ID of the last published message, or 0 if none
+func (r *Relay) Offset() (uint64, error) {
+	var offset Offset
+	err := r.db.Where("relay = ?", r.name).First(&offset).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return offset.MessageID, err
+}
+
+// RelayOnce publishes the next batch of messages and returns how many were
+// published. It stops at the first failed publish; the messages before it stay
+// published and the failed one is retried on the next call.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	offset, err := r.Offset()
+	if err != nil {
+		return 0, err
+	}
+
+	var batch []Message
+	if err := r.db.Where("id > ?", offset).Order("id").Limit(r.batchSize).Find(&batch).Error; err != nil {
+		return 0, err
+	}
+
+	published := 0
+	var publishErr error
+	for _, msg := range batch {
+		var event events.BalanceChanged
+		if err := json.Unmarshal(msg.Payload, &event); err != nil {
+			publishErr = fmt.Errorf("decode outbox message %d: %w", msg.ID, err)
+			break
+		}
+		if err := r.publisher.Publish(ctx, event); err != nil {
+			publishErr = fmt.Errorf("publish outbox message %d: %w", msg.ID, err)
+			break
+		}
+		offset = msg.ID
+		published++
+	}
+
+	if published > 0 {
+		if err := r.saveOffset(offset); err != nil {
+			return published, err
+		}
+	}
+	return published, publishErr
+}
+
+// Run relays messages until ctx is cancelled. Full batches are followed
+// immediately by the next one; otherwise the relay waits interval. Errors are
+// passed to onError, which may be nil.
+func (r *Relay) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil && onError != nil {
+			onError(err)
+		}
+		if err == nil && n == r.batchSize && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Prune deletes messages this relay has already published and returns how
+// many were removed. Do not call it while other relays still read the table.
+func (r *Relay) Prune() (int64, error) {
+	offset, err := r.Offset()
+	if err != nil {
+		return 0, err
+	}
+	result := r.db.Where("id <= ?", offset).Delete(&Message{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *Relay) saveOffset(id uint64) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "relay"}},
+		DoUpdates: clause.AssignmentColumns([]string{"message_id", "updated_at"}),
+	}).Create(&Offset{Relay: r.name, MessageID: id}).Error
+}
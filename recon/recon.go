@@ -0,0 +1,197 @@
+package recon
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// Kind classifies a reconciliation finding
+type Kind string
+
+const (
+	Matched         Kind = "matched"
+	AmountMismatch  Kind = "amount_mismatch"  // same reference, different amount
+	MissingInternal Kind = "missing_internal" // on the statement, not in the ledger
+	MissingExternal Kind = "missing_external" // in the ledger, not on the statement
+)
+
+// Finding is the reconciliation result for one statement entry or adjustment
+type Finding struct {
+	Kind       Kind
+	Entry      *Entry             // nil for MissingExternal
+	Adjustment *models.Adjustment // nil for MissingInternal
+}
+
+// Options controls matching
+type Options struct {
+	// DateTolerance is how far apart statement and ledger dates may be when
+	// matching by amount and date after reference matching (default 1 day)
+	DateTolerance time.Duration
+}
+
+// Result holds every finding for a statement
+type Result struct {
+	BalanceID uint
+	Findings  []Finding
+}
+
+// Reconcile matches statement entries for one balance against its recorded
+// adjustments. Entries are matched by reference first, then remaining ones by
+// equal amount within the date tolerance. Only adjustments inside the
+// statement's date range are considered.
+func Reconcile(db *gorm.DB, balanceID uint, entries []Entry, opts Options) (Result, error) {
+	if opts.DateTolerance <= 0 {
+		opts.DateTolerance = 24 * time.Hour
+	}
+	result := Result{BalanceID: balanceID}
+	if len(entries) == 0 {
+		return result, nil
+	}
+
+	from, to := entries[0].Date, entries[0].Date
+	for _, e := range entries {
+		if e.Date.Before(from) {
+			from = e.Date
+		}
+		if e.Date.After(to) {
+			to = e.Date
+		}
+	}
+
+	var adjustments []models.Adjustment
+	err := db.Where("balance_id = ? AND created_at >= ? AND created_at < ?",
+		balanceID, from.Add(-opts.DateTolerance), to.Add(24*time.Hour+opts.DateTolerance)).
+		Order("created_at").Find(&adjustments).Error
+	if err != nil {
+		return result, err
+	}
+
+	byRef := make(map[string]int, len(adjustments))
+	for i, a := range adjustments {
+		byRef[a.Reference] = i
+	}
+	used := make([]bool, len(adjustments))
+
+	var unmatched []int
+	for i := range entries {
+		e := &entries[i]
+		if j, ok := byRef[e.Reference]; ok && e.Reference != "" && !used[j] {
+			used[j] = true
+			kind := Matched
+			if adjustments[j].Delta != e.Amount {
+				kind = AmountMismatch
+			}
+			result.Findings = append(result.Findings, Finding{Kind: kind, Entry: e, Adjustment: &adjustments[j]})
+			continue
+		}
+		unmatched = append(unmatched, i)
+	}
+
+	// Fall back to amount + date for entries whose reference was rewritten by the bank
+	for _, i := range unmatched {
+		e := &entries[i]
+		found := -1
+		for j, a := range adjustments {
+			if used[j] || a.Delta != e.Amount {
+				continue
+			}
+			if diff := a.CreatedAt.Sub(e.Date); diff > -opts.DateTolerance && diff < 24*time.Hour+opts.DateTolerance {
+				found = j
+				break
+			}
+		}
+		if found < 0 {
+			result.Findings = append(result.Findings, Finding{Kind: MissingInternal, Entry: e})
+			continue
+		}
+		used[found] = true
+		result.Findings = append(result.Findings, Finding{Kind: Matched, Entry: e, Adjustment: &adjustments[found]})
+	}
+
+	for j := range adjustments {
+		if !used[j] {
+			result.Findings = append(result.Findings, Finding{Kind: MissingExternal, Adjustment: &adjustments[j]})
+		}
+	}
+
+	sort.SliceStable(result.Findings, func(a, b int) bool {
+		return result.Findings[a].Kind < result.Findings[b].Kind
+	})
+	return result, nil
+}
+
+// Mismatches returns the findings that need attention
+func (r Result) Mismatches() []Finding {
+	var out []Finding
+	for _, f := range r.Findings {
+		if f.Kind != Matched {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// WriteReport writes every finding as CSV
+func (r Result) WriteReport(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"kind", "balance_id", "statement_date", "statement_reference", "statement_amount",
+		"ledger_reference", "ledger_amount", "ledger_created_at"})
+	for _, f := range r.Findings {
+		row := []string{string(f.Kind), strconv.FormatUint(uint64(r.BalanceID), 10), "", "", "", "", "", ""}
+		if f.Entry != nil {
+			row[2] = f.Entry.Date.Format("2006-01-02")
+			row[3] = f.Entry.Reference
+			row[4] = strconv.FormatInt(f.Entry.Amount, 10)
+		}
+		if f.Adjustment != nil {
+			row[5] = f.Adjustment.Reference
+			row[6] = strconv.FormatInt(f.Adjustment.Delta, 10)
+			row[7] = f.Adjustment.CreatedAt.Format(time.RFC3339)
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteAdjustments writes proposed adjusting entries in the apply-csv format
+// (balance_id,delta,reference). Statement entries missing from the ledger are
+// booked in full and amount mismatches for the difference. Ledger entries
+// missing from the statement are left for manual review. The file is meant to
+// be reviewed and then applied with "optlockctl apply-csv"; references are
+// derived from the statement so applying it twice is harmless.
+func (r Result) WriteAdjustments(w io.Writer) (int, error) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"balance_id", "delta", "reference"})
+	id := strconv.FormatUint(uint64(r.BalanceID), 10)
+
+	count := 0
+	for _, f := range r.Findings {
+		switch f.Kind {
+		case MissingInternal:
+			cw.Write([]string{id, strconv.FormatInt(f.Entry.Amount, 10), reconReference(f.Entry)})
+			count++
+		case AmountMismatch:
+			diff := f.Entry.Amount - f.Adjustment.Delta
+			cw.Write([]string{id, strconv.FormatInt(diff, 10), reconReference(f.Entry) + ":diff"})
+			count++
+		}
+	}
+	cw.Flush()
+	return count, cw.Error()
+}
+
+// reconReference derives a stable idempotency reference from a statement entry
+func reconReference(e *Entry) string {
+	if e.Reference != "" {
+		return "recon:" + e.Reference
+	}
+	return "recon:" + e.Date.Format("2006-01-02") + ":" + strconv.FormatInt(e.Amount, 10)
+}
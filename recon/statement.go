@@ -0,0 +1,172 @@
+package recon
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is one line of an external bank statement. Amounts are in minor units
+// (cents), positive for credits and negative for debits.
+type Entry struct {
+	Date      time.Time
+	Reference string
+	Amount    int64
+}
+
+// ParseCSV reads a "date,reference,amount" statement with ISO dates and
+// decimal amounts ("12.50", "-3.00"). A header row is skipped.
+func ParseCSV(r io.Reader) ([]Entry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	var entries []Entry
+	line := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line++
+
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "date") {
+			continue
+		}
+
+		date, err := time.Parse("2006-01-02", strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", line, record[0])
+		}
+		amount, err := parseAmount(strings.TrimSpace(record[2]), '.')
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, Entry{Date: date, Reference: strings.TrimSpace(record[1]), Amount: amount})
+	}
+	return entries, nil
+}
+
+// ParseMT940 reads the :61: statement lines of an MT940 file, e.g.
+//
+//	:61:2310161016C123,45NTRFINV-1001//BANKREF
+//
+// value date (YYMMDD), optional entry date (MMDD), debit/credit mark, amount
+// with a decimal comma, a four character transaction type and the customer
+// reference up to "//". Other tags are ignored.
+func ParseMT940(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(text, ":61:") {
+			continue
+		}
+
+		entry, err := parse61(strings.TrimPrefix(text, ":61:"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func parse61(s string) (Entry, error) {
+	if len(s) < 6 {
+		return Entry{}, fmt.Errorf("statement line too short: %q", s)
+	}
+	date, err := time.Parse("060102", s[:6])
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid value date %q", s[:6])
+	}
+	s = s[6:]
+
+	// Optional entry date
+	if len(s) >= 4 && isDigits(s[:4]) {
+		s = s[4:]
+	}
+
+	sign := int64(1)
+	switch {
+	case strings.HasPrefix(s, "RC"), strings.HasPrefix(s, "RD"):
+		// Reversals flip the sign of the original mark
+		if s[1] == 'C' {
+			sign = -1
+		}
+		s = s[2:]
+	case strings.HasPrefix(s, "C"):
+		s = s[1:]
+	case strings.HasPrefix(s, "D"):
+		sign = -1
+		s = s[1:]
+	default:
+		return Entry{}, fmt.Errorf("missing debit/credit mark in %q", s)
+	}
+
+	end := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != ',' })
+	if end <= 0 {
+		return Entry{}, fmt.Errorf("missing amount in %q", s)
+	}
+	amount, err := parseAmount(s[:end], ',')
+	if err != nil {
+		return Entry{}, err
+	}
+	s = s[end:]
+
+	// Transaction type, e.g. NTRF
+	if len(s) < 4 {
+		return Entry{}, fmt.Errorf("missing transaction type in %q", s)
+	}
+	reference := s[4:]
+	if i := strings.Index(reference, "//"); i >= 0 {
+		reference = reference[:i]
+	}
+
+	return Entry{Date: date, Reference: strings.TrimSpace(reference), Amount: sign * amount}, nil
+}
+
+// parseAmount converts a decimal string to minor units (two decimal places)
+func parseAmount(s string, decimalSep byte) (int64, error) {
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, decimalSep); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("invalid amount %q: more than two decimals", s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+
+	value, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if negative {
+		value = -value
+	}
+	return value, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,209 @@
+package recon
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// VersionKind classifies a version audit finding
+type VersionKind string
+
+const (
+	// VersionBehind: the balance has more history than version bumps, e.g.
+	// after its version was reset
+	VersionBehind VersionKind = "version_behind"
+	// Unledgered: the balance was written more often than its history
+	// records, i.e. outside the adjustment flow
+	Unledgered VersionKind = "unledgered"
+	// AmountDrift: with VersionAuditOptions.OpenAtZero, the amount differs from
+	// the sum of the adjustments
+	AmountDrift VersionKind = "amount_drift"
+)
+
+// VersionAuditOptions controls AuditVersions
+type VersionAuditOptions struct {
+	// OpenAtZero declares that balances open at zero and every change,
+	// including the opening amount, is an adjustment, so amounts are checked
+	// against the adjustment sum too
+	OpenAtZero bool
+	// Events counts the balance_events of the event-sourced storage mode as
+	// the history instead of the adjustments, which also covers plain
+	// updates and the status and metadata changes of an event-sourced
+	// Updater. Only use it where every write was recorded from the start.
+	Events bool
+	// BatchSize is how many balances are read per query (default 1000)
+	BatchSize int
+}
+
+// VersionFinding is one inconsistency between a balance and its history. The
+// history starts at the last ForceSet of the balance, whose override records
+// the amount and version it was set to.
+type VersionFinding struct {
+	Kind      VersionKind
+	BalanceID uint
+	Version   int
+	History   int64 // adjustments, or events, since the last override
+	Amount    int64
+	LedgerSum int64 // amount of the last override plus the adjustment deltas since
+}
+
+// VersionFix repairs one balance by force setting it to the amount it has,
+// which records an override the next audit starts from. The version moves to
+// ToVersion, past every version the balance had. Fixes are guarded by the
+// version the audit saw.
+type VersionFix struct {
+	BalanceID uint
+	Version   int
+	Amount    int64
+	ToVersion int
+	Reason    string
+}
+
+// VersionAudit is the result of AuditVersions
+type VersionAudit struct {
+	Scanned  int
+	Findings []VersionFinding
+	Fixes    []VersionFix
+}
+
+// versionRow is a balance with its last override and history since
+type versionRow struct {
+	ID          uint
+	Version     int
+	Amount      int64
+	BaseVersion int
+	BaseAmount  int64
+	Entries     int64
+	Total       int64
+}
+
+// AuditVersions compares the version of every balance with its history:
+// since its last override, a balance written only through the adjustment
+// flow has exactly one version bump per adjustment. It proposes a fix where
+// one is safe: versions are only raised, since lowering one could let a
+// stale write through, and drift is kept as the amount of an override rather
+// than reversed. Unledgered writes that remain are reported for review.
+func AuditVersions(db *gorm.DB, opts VersionAuditOptions) (VersionAudit, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+
+	// Databases from before ForceSet have no overrides to start from
+	baseVersion, baseAmount, since := "0", "0", ""
+	query := db.Table("balances b")
+	if db.Migrator().HasTable(&models.BalanceOverride{}) {
+		baseVersion, baseAmount = "COALESCE(o.version, 0)", "COALESCE(o.amount, 0)"
+		since = " AND (o.id IS NULL OR a.id > o.adjustment_id)"
+		query = query.Joins("LEFT JOIN balance_overrides o ON o.id = (SELECT MAX(id) FROM balance_overrides WHERE balance_id = b.id)")
+	}
+	entries := "(SELECT COUNT(*) FROM adjustments a WHERE a.balance_id = b.id" + since + ")"
+	if opts.Events {
+		entries = "(SELECT COUNT(*) FROM balance_events e WHERE e.balance_id = b.id AND e.version > " + baseVersion + ")"
+	}
+	total := "(SELECT COALESCE(SUM(a.delta), 0) FROM adjustments a WHERE a.balance_id = b.id" + since + ")"
+	columns := fmt.Sprintf("b.id, b.version, b.amount, %s AS base_version, %s AS base_amount, %s AS entries, %s AS total",
+		baseVersion, baseAmount, entries, total)
+
+	var audit VersionAudit
+	var after uint
+	for {
+		var rows []versionRow
+		err := query.Session(&gorm.Session{}).
+			Select(columns).
+			Where("b.deleted_at IS NULL AND b.id > ?", after).
+			Order("b.id").
+			Limit(opts.BatchSize).
+			Scan(&rows).Error
+		if err != nil {
+			return audit, err
+		}
+
+		for _, r := range rows {
+			audit.check(r, opts)
+		}
+		audit.Scanned += len(rows)
+		if len(rows) < opts.BatchSize {
+			return audit, nil
+		}
+		after = rows[len(rows)-1].ID
+	}
+}
+
+// check records the findings and fix for one balance
+func (a *VersionAudit) check(r versionRow, opts VersionAuditOptions) {
+	var kinds []string
+	finding := func(kind VersionKind) {
+		a.Findings = append(a.Findings, VersionFinding{Kind: kind, BalanceID: r.ID, Version: r.Version,
+			History: r.Entries, Amount: r.Amount, LedgerSum: r.BaseAmount + r.Total})
+		kinds = append(kinds, string(kind))
+	}
+
+	drift := r.Amount - r.BaseAmount - r.Total
+	if opts.OpenAtZero && drift != 0 {
+		finding(AmountDrift)
+	}
+	expected := int64(r.BaseVersion) + r.Entries
+	switch {
+	case int64(r.Version) < expected:
+		finding(VersionBehind)
+	case int64(r.Version) > expected:
+		finding(Unledgered)
+	}
+
+	// Unledgered writes alone are left for review
+	if len(kinds) == 0 || (len(kinds) == 1 && kinds[0] == string(Unledgered)) {
+		return
+	}
+	reason := "version audit: " + strings.Join(kinds, ", ")
+	if opts.OpenAtZero && drift != 0 {
+		reason += fmt.Sprintf("; amount %d is %+d from the ledger", r.Amount, drift)
+	}
+	a.Fixes = append(a.Fixes, VersionFix{
+		BalanceID: r.ID,
+		Version:   r.Version,
+		Amount:    r.Amount,
+		ToVersion: max(r.Version+1, int(expected)+1),
+		Reason:    reason,
+	})
+}
+
+// WriteReport writes every finding as CSV
+func (a VersionAudit) WriteReport(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"kind", "balance_id", "version", "history", "amount", "ledger_sum"})
+	for _, f := range a.Findings {
+		cw.Write([]string{string(f.Kind), strconv.FormatUint(uint64(f.BalanceID), 10), strconv.Itoa(f.Version),
+			strconv.FormatInt(f.History, 10), strconv.FormatInt(f.Amount, 10), strconv.FormatInt(f.LedgerSum, 10)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WritePlan writes the proposed fixes as CSV
+func (a VersionAudit) WritePlan(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"balance_id", "version", "amount", "to_version", "reason"})
+	for _, f := range a.Fixes {
+		cw.Write([]string{strconv.FormatUint(uint64(f.BalanceID), 10), strconv.Itoa(f.Version),
+			strconv.FormatInt(f.Amount, 10), strconv.Itoa(f.ToVersion), f.Reason})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ApplyVersionFix applies fix through service.ForceSetIfVersion as actor
+// "version-audit", with opts for the Updater. It returns service.ErrConflict
+// if the balance changed since the audit; audit again before retrying.
+func ApplyVersionFix(db *gorm.DB, fix VersionFix, opts ...service.Option) error {
+	_, err := service.NewUpdater(db, opts...).As("version-audit").
+		ForceSetIfVersion(fix.BalanceID, fix.Version, fix.ToVersion, fix.Amount, fix.Reason)
+	return err
+}
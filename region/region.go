@@ -0,0 +1,200 @@
+// Package region runs the service active-passive across regions. The passive
+// region serves reads from a streaming replica and rejects writes; Promote
+// turns its replica into the primary once it has replayed everything it
+// received and bumps the failover epoch, which demotes the old region when it
+// next refreshes.
+package region
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+var (
+	// ErrReadOnly is returned for writes while the region is passive
+	ErrReadOnly = errors.New("region is passive: writes are disabled")
+
+	// ErrNotCaughtUp is returned when the replica did not replay all received WAL in time
+	ErrNotCaughtUp = errors.New("replica has not caught up with received WAL")
+)
+
+// epochRowID is the primary key of the single failover_epochs row
+const epochRowID = 1
+
+// Controller tracks whether this region may write
+type Controller struct {
+	db     *gorm.DB
+	region string
+
+	mu     sync.RWMutex
+	active bool
+	epoch  int64
+}
+
+// NewController returns a controller for region, starting active or passive.
+// Pass CheckWrite to service.WithWriteCheck so updates honour the role.
+func NewController(db *gorm.DB, region string, active bool) *Controller {
+	return &Controller{db: db, region: region, active: active}
+}
+
+// Active reports whether the region currently accepts writes
+func (c *Controller) Active() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.active
+}
+
+// Epoch returns the failover epoch the region last observed
+func (c *Controller) Epoch() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.epoch
+}
+
+// CheckWrite returns ErrReadOnly unless the region is active
+func (c *Controller) CheckWrite() error {
+	if !c.Active() {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// Refresh reads the failover epoch. If a promotion happened after the epoch
+// this region last saw, the region becomes active if it was the one promoted
+// and passive otherwise.
+func (c *Controller) Refresh() error {
+	var row models.FailoverEpoch
+	err := c.db.Where("id = ?", epochRowID).Limit(1).Find(&row).Error
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if row.Epoch > c.epoch {
+		// Promoted elsewhere, or this region was promoted by another process (optlockctl promote)
+		c.active = row.Region == c.region
+		c.epoch = row.Epoch
+	}
+	return nil
+}
+
+// Run calls Refresh every interval until ctx is cancelled. Errors are passed
+// to onError, which may be nil; the role is left unchanged when a refresh fails.
+func (c *Controller) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Refresh(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// PromoteOptions control Promote
+type PromoteOptions struct {
+	CatchUpTimeout time.Duration // How long to wait for replay to catch up (default 1m)
+	PollInterval   time.Duration // How often to check replay progress (default 500ms)
+}
+
+// Promote makes this region active. The database behind the controller must
+// be the region's Postgres standby: Promote waits until it has replayed all
+// WAL it received, promotes it with pg_promote and increments the failover
+// epoch. Stop writes in the old region (or make sure it is down) first; WAL
+// the standby never received is lost.
+func (c *Controller) Promote(ctx context.Context, opts PromoteOptions) error {
+	if opts.CatchUpTimeout <= 0 {
+		opts.CatchUpTimeout = time.Minute
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 500 * time.Millisecond
+	}
+
+	var inRecovery bool
+	if err := c.db.WithContext(ctx).Raw("SELECT pg_is_in_recovery()").Scan(&inRecovery).Error; err != nil {
+		return err
+	}
+
+	// An already promoted database only needs the epoch bump
+	if inRecovery {
+		if err := c.waitForReplay(ctx, opts); err != nil {
+			return err
+		}
+		var promoted bool
+		if err := c.db.WithContext(ctx).Raw("SELECT pg_promote(true, 60)").Scan(&promoted).Error; err != nil {
+			return err
+		}
+		if !promoted {
+			return errors.New("pg_promote did not complete within 60s")
+		}
+	}
+
+	return c.bumpEpoch(ctx)
+}
+
+// waitForReplay polls until the standby has replayed every WAL record it received
+func (c *Controller) waitForReplay(ctx context.Context, opts PromoteOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.CatchUpTimeout)
+	defer cancel()
+
+	for {
+		var caughtUp bool
+		err := c.db.WithContext(ctx).
+			Raw("SELECT pg_last_wal_receive_lsn() IS NULL OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn()").
+			Scan(&caughtUp).Error
+		if err != nil {
+			return err
+		}
+		if caughtUp {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w after %s", ErrNotCaughtUp, opts.CatchUpTimeout)
+		case <-time.After(opts.PollInterval):
+		}
+	}
+}
+
+// bumpEpoch records this region as active under the next epoch
+func (c *Controller) bumpEpoch(ctx context.Context) error {
+	// The table normally replicates from the old primary, but may predate it
+	if err := c.db.WithContext(ctx).AutoMigrate(&models.FailoverEpoch{}); err != nil {
+		return err
+	}
+
+	row := models.FailoverEpoch{ID: epochRowID}
+	err := c.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", epochRowID).Limit(1).Find(&row).Error; err != nil {
+			return err
+		}
+
+		row.Epoch++
+		row.Region = c.region
+		row.PromotedAt = time.Now()
+		return tx.Save(&row).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active = true
+	c.epoch = row.Epoch
+	return nil
+}
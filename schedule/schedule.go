@@ -0,0 +1,172 @@
+// Package schedule applies recurring balance adjustments, such as interest
+// accrual or monthly fees, from the schedules table. Every run is applied
+// through service.Updater.ApplyAdjustment under a reference made of the
+// schedule and the run time, so a run lands exactly once however many
+// scheduler instances attempt it, and a scheduler that was down catches up
+// on the runs it missed, in order.
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// Create validates the spec of s and stores it. The first run is the first
+// time the spec matches after now unless s.NextRunAt is set.
+func Create(db *gorm.DB, s models.Schedule) (models.Schedule, error) {
+	if s.Name == "" || s.BalanceID == 0 {
+		return models.Schedule{}, errors.New("schedule name and balance are required")
+	}
+	spec, err := ParseSpec(s.Spec)
+	if err != nil {
+		return models.Schedule{}, err
+	}
+	if s.NextRunAt.IsZero() {
+		s.NextRunAt = spec.Next(time.Now())
+	}
+	if err := db.Create(&s).Error; err != nil {
+		return models.Schedule{}, err
+	}
+	return s, nil
+}
+
+// SetPaused pauses or resumes the schedule. A resumed schedule catches up
+// on the runs it missed while paused; move NextRunAt forward to skip them.
+func SetPaused(db *gorm.DB, id uint, paused bool) error {
+	result := db.Model(&models.Schedule{}).Where("id = ?", id).
+		Updates(map[string]any{"paused": paused, "version": gorm.Expr("version + 1")})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Reference returns the adjustment reference of the run of schedule id at at
+func Reference(id uint, at time.Time) string {
+	return fmt.Sprintf("schedule:%d:%d", id, at.Unix())
+}
+
+// Scheduler applies the runs of the schedules that are due
+type Scheduler struct {
+	db         *gorm.DB
+	updater    *service.Updater
+	maxCatchUp int
+	now        func() time.Time
+}
+
+// NewScheduler returns a scheduler applying runs with updater. A schedule
+// that missed more than maxCatchUp runs, e.g. after a long outage, catches
+// up over several passes instead of holding up the others; zero means 100.
+func NewScheduler(db *gorm.DB, updater *service.Updater, maxCatchUp int) *Scheduler {
+	if maxCatchUp <= 0 {
+		maxCatchUp = 100
+	}
+	return &Scheduler{db: db, updater: updater, maxCatchUp: maxCatchUp, now: time.Now}
+}
+
+// RunOnce applies the runs due by now and returns how many it applied. A
+// schedule whose balance is gone is paused. Errors of one schedule do not
+// stop the others; they are joined into the returned error.
+func (s *Scheduler) RunOnce(ctx context.Context) (int, error) {
+	now := s.now()
+	var due []models.Schedule
+	err := s.db.WithContext(ctx).Where("paused = ? AND next_run_at <= ?", false, now).
+		Order("next_run_at").Find(&due).Error
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	var errs []error
+	for _, sched := range due {
+		if err := ctx.Err(); err != nil {
+			return applied, err
+		}
+		n, err := s.catchUp(sched, now)
+		applied += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("schedule %s: %w", sched.Name, err))
+		}
+	}
+	return applied, errors.Join(errs...)
+}
+
+// catchUp applies the runs of sched due by now, oldest first. Each run is
+// applied before NextRunAt moves past it, so a crash in between repeats the
+// run, which its reference then turns into a no-op.
+func (s *Scheduler) catchUp(sched models.Schedule, now time.Time) (int, error) {
+	spec, err := ParseSpec(sched.Spec)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for n := 0; n < s.maxCatchUp && !sched.NextRunAt.After(now); n++ {
+		at := sched.NextRunAt
+		ok, err := s.apply(sched, at)
+		if errors.Is(err, service.ErrNotFound) {
+			return applied, errors.Join(err, SetPaused(s.db, sched.ID, true))
+		}
+		if err != nil {
+			return applied, err
+		}
+		if ok {
+			applied++
+		}
+
+		// Another instance may have moved the schedule on; it owns the rest
+		next := spec.Next(at)
+		result := s.db.Model(&models.Schedule{}).Where("id = ? AND version = ?", sched.ID, sched.Version).
+			Updates(map[string]any{"next_run_at": next, "last_run_at": at, "version": sched.Version + 1})
+		if result.Error != nil {
+			return applied, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return applied, nil
+		}
+		sched.NextRunAt, sched.Version = next, sched.Version+1
+	}
+	return applied, nil
+}
+
+// apply applies the run of sched at at and reports whether it was new
+func (s *Scheduler) apply(sched models.Schedule, at time.Time) (bool, error) {
+	delta := sched.Delta
+	if sched.RateBps != 0 {
+		// The basis is the amount when the run is applied, not at its scheduled time
+		balance, err := service.GetBalance(s.db, sched.BalanceID)
+		if err != nil {
+			return false, err
+		}
+		delta += balance.Amount * sched.RateBps / 10000
+	}
+	if delta == 0 {
+		return false, nil
+	}
+	return s.updater.ApplyAdjustment(Reference(sched.ID, at), sched.BalanceID, delta)
+}
+
+// Run calls RunOnce every interval until ctx is done. Errors go to onError,
+// which may be nil.
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	for {
+		if _, err := s.RunOnce(ctx); err != nil && ctx.Err() == nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
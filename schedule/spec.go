@@ -0,0 +1,152 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spec computes the run times of a schedule
+type Spec interface {
+	// Next returns the first run time after t
+	Next(t time.Time) time.Time
+}
+
+// every runs at a fixed interval from the previous run
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time { return t.Add(time.Duration(e)) }
+
+// descriptors are the cron shorthands ParseSpec accepts
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseSpec parses a five-field cron expression (minute, hour, day of month,
+// month, day of week) evaluated in UTC, a shorthand such as @daily or
+// @monthly, or "@every <duration>" for a fixed interval. Fields take *,
+// numbers, ranges (1-5), lists (1,15) and steps (*/15, 0-30/10). As in cron,
+// a day matches if either the day of month or the day of week does when
+// both are restricted.
+func ParseSpec(spec string) (Spec, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := strings.CutPrefix(spec, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || interval < time.Minute {
+			return nil, fmt.Errorf("schedule %q: @every needs a duration of at least 1m", spec)
+		}
+		return every(interval), nil
+	}
+	if expanded, ok := descriptors[spec]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: expected 5 fields, got %d", spec, len(fields))
+	}
+	var c cron
+	bounds := []struct {
+		dst      *uint64
+		min, max int
+	}{{&c.minute, 0, 59}, {&c.hour, 0, 23}, {&c.dom, 1, 31}, {&c.month, 1, 12}, {&c.dow, 0, 7}}
+	for i, f := range fields {
+		set, err := parseField(f, bounds[i].min, bounds[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: field %d: %w", spec, i+1, err)
+		}
+		*bounds[i].dst = set
+	}
+	// Sunday is 0 or 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny, c.dowAny = fields[2] == "*", fields[4] == "*"
+	if c.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, fmt.Errorf("schedule %q never runs", spec)
+	}
+	return c, nil
+}
+
+// parseField returns the values a cron field matches as a bit set
+func parseField(field string, lo, hi int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+			step = n
+		}
+
+		start, end := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if start, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", a)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", b)
+				}
+			} else if hasStep {
+				end = hi
+			}
+		}
+		if start < lo || end > hi || start > end {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, lo, hi)
+		}
+		for v := start; v <= end; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// cron is a parsed five-field expression; each field is a bit set of values
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+func (c cron) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	// An expression that never matches, such as 0 0 30 2 *, gives up after five years
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches applies the cron rule for day of month and day of week
+func (c cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
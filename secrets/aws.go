@@ -0,0 +1,129 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWSSecretsManager reads the credentials from an AWS Secrets Manager secret
+// holding "username" and "password", the layout RDS rotation uses. Requests
+// are signed with the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and optional
+// AWS_SESSION_TOKEN environment variables.
+type AWSSecretsManager struct {
+	Region     string // defaults to AWS_REGION
+	SecretID   string // name or ARN
+	Endpoint   string // defaults to https://secretsmanager.<region>.amazonaws.com
+	HTTPClient *http.Client
+}
+
+// Credentials implements Provider
+func (a AWSSecretsManager) Credentials(ctx context.Context) (Credentials, error) {
+	region := a.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	key := awsKey{
+		id:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:  os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if region == "" || key.id == "" || key.secret == "" {
+		return Credentials{}, errors.New("aws: AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+	}
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+
+	body, _ := json.Marshal(map[string]string{"SecretId": a.SecretID})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signV4(req, body, key, region, "secretsmanager", time.Now())
+
+	data, err := send(a.HTTPClient, req, "aws")
+	if err != nil {
+		return Credentials{}, err
+	}
+	var resp struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return Credentials{}, fmt.Errorf("aws: decode response: %w", err)
+	}
+	return parseSecret([]byte(resp.SecretString))
+}
+
+// awsKey is an AWS access key
+type awsKey struct {
+	id, secret, token string
+}
+
+// signV4 adds the Signature Version 4 headers to req, whose body is body
+func signV4(req *http.Request, body []byte, key awsKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if key.token != "" {
+		req.Header.Set("X-Amz-Security-Token", key.token)
+	}
+
+	// Sign the host and every header set so far
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, sha256Hex(body),
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	signingKey := hmacSHA256([]byte("AWS4"+key.secret), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		key.id, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
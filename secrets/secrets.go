@@ -0,0 +1,91 @@
+// Package secrets supplies database credentials from an environment,
+// mounted files, HashiCorp Vault or AWS Secrets Manager. Providers are asked
+// again whenever credentials are needed, so a rotated secret is picked up
+// without restarting; see database.Credentials.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Credentials are a database user and password
+type Credentials struct {
+	User     string
+	Password string
+}
+
+// Provider returns the current database credentials
+type Provider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// ProviderFunc adapts a function to a Provider
+type ProviderFunc func(ctx context.Context) (Credentials, error)
+
+// Credentials implements Provider
+func (f ProviderFunc) Credentials(ctx context.Context) (Credentials, error) { return f(ctx) }
+
+// Env reads the credentials from environment variables (default DB_USER and
+// DB_PASSWORD)
+type Env struct {
+	UserVar     string
+	PasswordVar string
+}
+
+// Credentials implements Provider
+func (e Env) Credentials(context.Context) (Credentials, error) {
+	userVar, passwordVar := e.UserVar, e.PasswordVar
+	if userVar == "" {
+		userVar = "DB_USER"
+	}
+	if passwordVar == "" {
+		passwordVar = "DB_PASSWORD"
+	}
+	creds := Credentials{User: os.Getenv(userVar), Password: os.Getenv(passwordVar)}
+	if creds.User == "" {
+		return creds, fmt.Errorf("%s is not set", userVar)
+	}
+	return creds, nil
+}
+
+// File reads the user and password from files, such as a Kubernetes secret
+// volume whose files are replaced on rotation. Surrounding whitespace is
+// trimmed.
+type File struct {
+	UserPath     string
+	PasswordPath string
+}
+
+// Credentials implements Provider
+func (f File) Credentials(context.Context) (Credentials, error) {
+	user, err := os.ReadFile(f.UserPath)
+	if err != nil {
+		return Credentials{}, err
+	}
+	password, err := os.ReadFile(f.PasswordPath)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{User: strings.TrimSpace(string(user)), Password: strings.TrimSpace(string(password))}, nil
+}
+
+// parseSecret decodes a JSON secret with "username" and "password" keys, the
+// layout of RDS-managed secrets and Vault database credentials
+func parseSecret(data []byte) (Credentials, error) {
+	var secret struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.Unmarshal(data, &secret); err != nil {
+		return Credentials{}, fmt.Errorf("decode secret: %w", err)
+	}
+	if secret.Username == "" {
+		return Credentials{}, errors.New("secret has no username")
+	}
+	return Credentials{User: secret.Username, Password: secret.Password}, nil
+}
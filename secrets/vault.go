@@ -0,0 +1,106 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ErrLeasedSecret is returned for a Vault secret issued under a lease, such as
+// the credentials of a dynamic database role
+var ErrLeasedSecret = errors.New("vault: leased secrets are not supported; use a static role such as database/static-creds/<role>")
+
+// Vault reads the credentials from HashiCorp Vault. Path is either a KV v2
+// secret ("secret/data/optimistic-lock") or a database secrets engine static
+// role ("database/static-creds/optimistic-lock"), whose password Vault
+// rotates in place; both hold "username" and "password". Dynamic roles
+// ("database/creds/<role>") are refused with ErrLeasedSecret: every read
+// creates another database user under a lease of its own, which this
+// provider neither renews nor revokes. The token is read from TokenFile on
+// every call, or from VAULT_TOKEN, so an agent can renew it.
+type Vault struct {
+	Addr       string // e.g. https://vault.internal:8200; defaults to VAULT_ADDR
+	Path       string
+	TokenFile  string
+	HTTPClient *http.Client
+}
+
+// Credentials implements Provider
+func (v Vault) Credentials(ctx context.Context) (Credentials, error) {
+	token, err := v.token()
+	if err != nil {
+		return Credentials{}, err
+	}
+	addr := v.Addr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(v.Path, "/"), nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	body, err := send(v.HTTPClient, req, "vault")
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	// KV v2 nests the secret in data.data, the database engine returns it in data
+	var resp struct {
+		LeaseID string          `json:"lease_id"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return Credentials{}, fmt.Errorf("vault: decode response: %w", err)
+	}
+	if resp.LeaseID != "" {
+		return Credentials{}, fmt.Errorf("%w: %s", ErrLeasedSecret, v.Path)
+	}
+	var kv struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(resp.Data, &kv) == nil && len(kv.Data) > 0 && kv.Data[0] == '{' {
+		return parseSecret(kv.Data)
+	}
+	return parseSecret(resp.Data)
+}
+
+func (v Vault) token() (string, error) {
+	if v.TokenFile != "" {
+		data, err := os.ReadFile(v.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("vault token: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	return "", fmt.Errorf("vault token: set a token file or VAULT_TOKEN")
+}
+
+// send runs req and returns the body of a 2xx response
+func send(client *http.Client, req *http.Request, service string) ([]byte, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", service, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", service, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s: status %d: %s", service, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
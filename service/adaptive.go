@@ -0,0 +1,159 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// AdaptiveConfig configures an AdaptiveBackoff
+type AdaptiveConfig struct {
+	Window      time.Duration // Period the conflict rate is measured over (default 10s)
+	MinAttempts int           // Attempts in a window before a balance counts as hot (default 10)
+	MaxScale    float64       // Backoff multiplier for a balance whose every attempt conflicts (default 8)
+}
+
+// AdaptiveBackoff tracks the recent conflict rate of each balance ID and
+// stretches the retry backoff of hot balances by up to MaxScale, so writers
+// of a contended row spread out while cold rows keep retrying quickly. Share
+// one between the updaters writing the same balances.
+type AdaptiveBackoff struct {
+	cfg AdaptiveConfig
+
+	mu      sync.Mutex
+	windows conflictWindows
+}
+
+// conflictWindows holds the conflictWindow of each balance ID with recent
+// attempts
+type conflictWindows struct {
+	window    time.Duration
+	keys      map[uint]*conflictWindow
+	lastPrune time.Time
+}
+
+// conflictWindow counts attempts in the current and the previous window. The
+// rate over the last Window weighs the previous counts by the part of it
+// still covered, which slides the window without keeping every attempt.
+type conflictWindow struct {
+	start                       time.Time
+	attempts, conflicts         int
+	prevAttempts, prevConflicts int
+}
+
+// NewAdaptiveBackoff returns an AdaptiveBackoff with no history
+func NewAdaptiveBackoff(cfg AdaptiveConfig) *AdaptiveBackoff {
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.MinAttempts <= 0 {
+		cfg.MinAttempts = 10
+	}
+	if cfg.MaxScale < 1 {
+		cfg.MaxScale = 8
+	}
+	return &AdaptiveBackoff{cfg: cfg, windows: newConflictWindows(cfg.Window)}
+}
+
+func newConflictWindows(window time.Duration) conflictWindows {
+	return conflictWindows{window: window, keys: make(map[uint]*conflictWindow), lastPrune: time.Now()}
+}
+
+// WithAdaptiveBackoff scales the backoff of every retry by the conflict rate
+// of the balance, as measured by a
+func WithAdaptiveBackoff(a *AdaptiveBackoff) Option {
+	return func(u *Updater) {
+		u.adaptive = a
+	}
+}
+
+// Record counts one attempt on balance id and whether it conflicted
+func (a *AdaptiveBackoff) Record(id uint, conflict bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.windows.record(id, conflict, time.Now())
+}
+
+// ConflictRate returns the share of attempts on balance id that conflicted
+// over the last window, and the number of attempts it is based on
+func (a *AdaptiveBackoff) ConflictRate(id uint) (rate float64, attempts int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.windows.rate(id, time.Now())
+}
+
+// Scale returns the backoff multiplier for balance id: 1 for a cold balance,
+// rising with the conflict rate to MaxScale
+func (a *AdaptiveBackoff) Scale(id uint) float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rate, attempts := a.windows.rate(id, time.Now())
+	if attempts < a.cfg.MinAttempts {
+		return 1
+	}
+	return 1 + (a.cfg.MaxScale-1)*rate
+}
+
+// record counts one attempt on balance id
+func (c *conflictWindows) record(id uint, conflict bool, now time.Time) {
+	w := c.keys[id]
+	if w == nil {
+		w = &conflictWindow{start: now}
+		c.keys[id] = w
+	}
+	w.advance(now, c.window)
+	w.attempts++
+	if conflict {
+		w.conflicts++
+	}
+	c.prune(now)
+}
+
+// rate returns the conflict rate of balance id over the last window and the
+// number of attempts it is based on
+func (c *conflictWindows) rate(id uint, now time.Time) (float64, int) {
+	w := c.keys[id]
+	if w == nil {
+		return 0, 0
+	}
+	attempts, conflicts := w.counts(now, c.window)
+	if attempts < 1 {
+		return 0, 0
+	}
+	return conflicts / attempts, int(attempts)
+}
+
+// counts returns the attempts and conflicts over the last window
+func (w *conflictWindow) counts(now time.Time, window time.Duration) (attempts, conflicts float64) {
+	w.advance(now, window)
+	covered := 1 - float64(now.Sub(w.start))/float64(window)
+	return float64(w.attempts) + covered*float64(w.prevAttempts), float64(w.conflicts) + covered*float64(w.prevConflicts)
+}
+
+// advance starts a new window once the current one is over
+func (w *conflictWindow) advance(now time.Time, window time.Duration) {
+	elapsed := now.Sub(w.start)
+	if elapsed < window {
+		return
+	}
+	if elapsed < 2*window {
+		w.prevAttempts, w.prevConflicts = w.attempts, w.conflicts
+		w.start = w.start.Add(window)
+	} else {
+		w.prevAttempts, w.prevConflicts = 0, 0
+		w.start = now
+	}
+	w.attempts, w.conflicts = 0, 0
+}
+
+// prune drops balances without attempts in the last two windows
+func (c *conflictWindows) prune(now time.Time) {
+	if now.Sub(c.lastPrune) < c.window {
+		return
+	}
+	for id, w := range c.keys {
+		if now.Sub(w.start) >= 2*c.window {
+			delete(c.keys, id)
+		}
+	}
+	c.lastPrune = now
+}
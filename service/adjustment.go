@@ -0,0 +1,72 @@
+package service
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// ApplyAdjustment applies delta to the balance at most once per reference,
+// using the default Updater overridden by opts
+func ApplyAdjustment(db *gorm.DB, reference string, id uint, delta int64, opts ...Option) (applied bool, err error) {
+	return NewUpdater(db, opts...).ApplyAdjustment(reference, id, delta)
+}
+
+// ApplyAdjustment applies delta to the balance at most once per reference.
+// The reference is recorded in the same transaction as the balance update, so
+// a repeated call with the same reference is a no-op and returns applied=false.
+// With WithDeadLetter, an adjustment that exhausts its retries is queued for
+// replay under its reference.
+func (u *Updater) ApplyAdjustment(reference string, id uint, delta int64) (applied bool, err error) {
+	return u.applyAdjustment(reference, id, delta, nil)
+}
+
+// applyAdjustment is ApplyAdjustment calling claim, if not nil, first in the
+// transaction; a claim returning false skips the adjustment as a duplicate
+func (u *Updater) applyAdjustment(reference string, id uint, delta int64, claim func(tx *gorm.DB) (bool, error)) (applied bool, err error) {
+	if reference == "" {
+		return false, errors.New("adjustment reference is required")
+	}
+
+	// The update runs on the transaction; a dead letter is only written once
+	// it has rolled back
+	inTx := *u
+	inTx.deadLetter = nil
+	inTx.cache = nil
+
+	var outcome UpdateOutcome
+	err = u.db.Transaction(func(tx *gorm.DB) error {
+		if claim != nil {
+			if claimed, err := claim(tx); err != nil || !claimed {
+				return err
+			}
+		}
+		adj := models.Adjustment{Reference: reference, BalanceID: id, Delta: delta}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&adj)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// Reference already applied
+			return nil
+		}
+
+		inTx.db = tx
+		var err error
+		if outcome, err = inTx.UpdateBalance(id, delta); err != nil {
+			return err
+		}
+		applied = true
+		return nil
+	})
+	if applied || err != nil {
+		u.cacheWrite(id, outcome, err)
+	}
+	if err != nil {
+		return false, u.deadLettered(reference, id, delta, outcome, err)
+	}
+	return applied, nil
+}
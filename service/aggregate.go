@@ -0,0 +1,113 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// ConflictError is the conflict of an aggregate write, naming the rows that
+// changed since the aggregate was read. It wraps ErrConflict.
+type ConflictError struct {
+	BalanceID uint
+	Root      bool     // the balance itself was written
+	Limits    []string // kinds of the limits written, deleted or created by someone else
+}
+
+func (e *ConflictError) Error() string {
+	var parts []string
+	if e.Root {
+		parts = append(parts, "balance")
+	}
+	if len(e.Limits) > 0 {
+		parts = append(parts, "limits "+strings.Join(e.Limits, ", "))
+	}
+	return fmt.Sprintf("conflict: aggregate of balance %d changed since it was read (%s)", e.BalanceID, strings.Join(parts, "; "))
+}
+
+func (e *ConflictError) Unwrap() error { return ErrConflict }
+
+// GetBalanceAggregate returns the balance with its limits, ordered by kind,
+// for SaveBalanceAggregate
+func GetBalanceAggregate(db *gorm.DB, id uint) (models.Balance, error) {
+	var balance models.Balance
+	err := db.Preload("Limits", func(db *gorm.DB) *gorm.DB { return db.Order("kind") }).First(&balance, id).Error
+	if err != nil {
+		return models.Balance{}, notFound(err)
+	}
+	return balance, nil
+}
+
+// SaveBalanceAggregate writes a balance read by GetBalanceAggregate and
+// changed since, with its limits, in one transaction. The balance is the
+// root of the aggregate: its version moves on every save, so two saves of
+// the same aggregate conflict even if they changed different limits. Each
+// limit is also written only at the version it was read at, and a new limit
+// (zero ID) only if no limit of its kind exists, which catches writers that
+// change limits on their own. When anything changed, nothing is written and
+// a *ConflictError lists every row that did; re-read the aggregate and try
+// again. Limits missing from balance.Limits are left as they are.
+//
+// The amount is written as it is, bypassing the Updater: no hooks, events or
+// audit records are produced, so change amounts with UpdateBalance where
+// those matter. The balance is returned with its new versions.
+func SaveBalanceAggregate(db *gorm.DB, balance models.Balance) (models.Balance, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		conflict := &ConflictError{BalanceID: balance.ID}
+		result := tx.Model(&models.Balance{}).Where("id = ? AND version = ?", balance.ID, balance.Version).
+			Updates(map[string]any{"amount": balance.Amount, "version": balance.Version + 1})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			if err := conflictOrMissing(tx, &models.Balance{}, balance.ID); err != ErrConflict {
+				return err
+			}
+			conflict.Root = true
+		}
+
+		// Check every limit, so the error names all the rows that changed
+		limits := make([]models.BalanceLimit, len(balance.Limits))
+		for i, limit := range balance.Limits {
+			if limit.BalanceID != 0 && limit.BalanceID != balance.ID {
+				return fmt.Errorf("limit %s belongs to balance %d, not %d", limit.Kind, limit.BalanceID, balance.ID)
+			}
+			limit.BalanceID = balance.ID
+			if limit.ID == 0 {
+				created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&limit)
+				if created.Error != nil {
+					return created.Error
+				}
+				if created.RowsAffected == 0 {
+					conflict.Limits = append(conflict.Limits, limit.Kind)
+				}
+			} else {
+				updated := tx.Model(&models.BalanceLimit{}).
+					Where("id = ? AND balance_id = ? AND version = ?", limit.ID, balance.ID, limit.Version).
+					Updates(map[string]any{"amount": limit.Amount, "version": limit.Version + 1})
+				if updated.Error != nil {
+					return updated.Error
+				}
+				if updated.RowsAffected == 0 {
+					conflict.Limits = append(conflict.Limits, limit.Kind)
+				}
+				limit.Version++
+			}
+			limits[i] = limit
+		}
+		if conflict.Root || len(conflict.Limits) > 0 {
+			return conflict
+		}
+		balance.Version++
+		balance.Limits = limits
+		return nil
+	})
+	if err != nil {
+		return models.Balance{}, err
+	}
+	return balance, nil
+}
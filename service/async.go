@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrQueueFull is returned when the async queue has no free slot
+	ErrQueueFull = errors.New("async update queue is full")
+
+	// ErrAsyncUpdaterClosed is returned for submissions after Shutdown
+	ErrAsyncUpdaterClosed = errors.New("async updater is shut down")
+)
+
+// asyncJob is one queued update
+type asyncJob struct {
+	updater *Updater // nil for that of the AsyncUpdater
+	id      uint
+	delta   int64
+	done    func(UpdateOutcome, error)
+}
+
+// AsyncUpdater applies updates on a fixed pool of workers fed by a bounded
+// queue, so callers can fire-and-track updates without a goroutine per request.
+type AsyncUpdater struct {
+	updater *Updater
+	queue   chan asyncJob
+	wg      sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+	stops  []chan struct{} // one per running worker; closing it retires the worker
+}
+
+// NewAsyncUpdater starts workers goroutines draining a queue of queueSize updates
+func NewAsyncUpdater(updater *Updater, workers, queueSize int) *AsyncUpdater {
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	a := &AsyncUpdater{
+		updater: updater,
+		queue:   make(chan asyncJob, queueSize),
+	}
+	a.Resize(workers)
+	return a
+}
+
+// Resize starts or retires workers until workers (at least 1) are running.
+// A retired worker finishes the update it is applying first. It does nothing
+// after Shutdown.
+func (a *AsyncUpdater) Resize(workers int) {
+	if workers <= 0 {
+		workers = 1
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	for len(a.stops) < workers {
+		stop := make(chan struct{})
+		a.stops = append(a.stops, stop)
+		a.wg.Add(1)
+		go a.work(stop)
+	}
+	for len(a.stops) > workers {
+		last := len(a.stops) - 1
+		close(a.stops[last])
+		a.stops = a.stops[:last]
+	}
+}
+
+// Workers returns the number of running workers
+func (a *AsyncUpdater) Workers() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.stops)
+}
+
+// Submit queues an update without blocking. done, if not nil, is called from a
+// worker with the result of UpdateBalance. Returns ErrQueueFull when the queue
+// is at capacity so callers can shed load instead of piling up.
+func (a *AsyncUpdater) Submit(id uint, delta int64, done func(UpdateOutcome, error)) error {
+	return a.submit(asyncJob{id: id, delta: delta, done: done})
+}
+
+// SubmitTracked queues an update like Submit and records it in ops, returning
+// an operation ID the caller can poll for the result. opts apply to this
+// update only, e.g. WithTenant for the tenant of the request. An update that
+// could not be queued is not recorded.
+func (a *AsyncUpdater) SubmitTracked(ops *OperationRegistry, id uint, delta int64, opts ...Option) (string, error) {
+	job := asyncJob{id: id, delta: delta}
+	updater := a.updater
+	if len(opts) > 0 {
+		job.updater = a.updater.With(opts...)
+		updater = job.updater
+	}
+	opID := ops.start(tenantOf(updater.db), id, delta)
+	job.done = func(_ UpdateOutcome, err error) { ops.Complete(opID, err) }
+	if err := a.submit(job); err != nil {
+		ops.forget(opID)
+		return "", err
+	}
+	return opID, nil
+}
+
+func (a *AsyncUpdater) submit(job asyncJob) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return ErrAsyncUpdaterClosed
+	}
+
+	select {
+	case a.queue <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Shutdown stops accepting updates and waits for queued and in-flight ones to
+// finish, or for ctx to be done.
+func (a *AsyncUpdater) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pending returns the number of queued updates not yet picked up by a worker
+func (a *AsyncUpdater) Pending() int {
+	return len(a.queue)
+}
+
+func (a *AsyncUpdater) work(stop <-chan struct{}) {
+	defer a.wg.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		case job, ok := <-a.queue:
+			if !ok {
+				return
+			}
+			u := job.updater
+			if u == nil {
+				u = a.updater
+			}
+			outcome, err := u.UpdateBalance(job.id, job.delta)
+			if job.done != nil {
+				job.done(outcome, err)
+			}
+		}
+	}
+}
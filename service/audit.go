@@ -0,0 +1,106 @@
+package service
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// ConflictRecord describes one write rejected by the version check
+type ConflictRecord struct {
+	BalanceID       uint
+	Actor           string // Set with Updater.As; empty if unknown
+	ExpectedVersion int    // Version the write was based on
+	ActualVersion   int    // Version found after the rejection; 0 if the balance is gone
+	Delta           int64
+	Attempt         int
+	At              time.Time
+}
+
+// ConflictSink stores conflict records
+type ConflictSink interface {
+	RecordConflict(ConflictRecord) error
+}
+
+// ConflictSinkFunc adapts a function to ConflictSink
+type ConflictSinkFunc func(ConflictRecord) error
+
+func (f ConflictSinkFunc) RecordConflict(r ConflictRecord) error {
+	return f(r)
+}
+
+// TableConflictSink writes conflict records to the update_conflicts table
+type TableConflictSink struct {
+	DB *gorm.DB
+}
+
+func (s TableConflictSink) RecordConflict(r ConflictRecord) error {
+	return s.DB.Create(&models.UpdateConflict{
+		BalanceID:       r.BalanceID,
+		Actor:           r.Actor,
+		ExpectedVersion: r.ExpectedVersion,
+		ActualVersion:   r.ActualVersion,
+		Delta:           r.Delta,
+		Attempt:         r.Attempt,
+		CreatedAt:       r.At,
+	}).Error
+}
+
+// WithConflictAudit sends a ConflictRecord to sink for every attempt of
+// UpdateBalance or UpdateIfVersion rejected by the version check. Auditing
+// costs one extra read per conflict. A failing sink does not fail the update;
+// its errors are passed to onError, which may be nil.
+func WithConflictAudit(sink ConflictSink, onError func(error)) Option {
+	return func(u *Updater) {
+		u.conflictSink = sink
+		u.onAuditError = onError
+	}
+}
+
+// As returns a copy of the updater that records actor as the author of its
+// conflicting writes, e.g. the authenticated user of a request. The copy
+// shares the breaker, limiter, serializer and other options with u.
+func (u *Updater) As(actor string) *Updater {
+	c := *u
+	c.actor = actor
+	return &c
+}
+
+// auditConflict records a rejected write. actual is the version the write
+// saw, or -1 to read it from the database.
+func (u *Updater) auditConflict(id uint, expected, actual int, delta int64, attempt int) {
+	if u.conflictSink == nil {
+		return
+	}
+	if actual < 0 {
+		var versions []int
+		if err := u.db.Unscoped().Model(&models.Balance{}).Where("id = ?", id).Pluck("version", &versions).Error; err != nil {
+			u.auditError(err)
+		}
+		actual = 0
+		if len(versions) > 0 {
+			actual = versions[0]
+		}
+	}
+
+	err := u.conflictSink.RecordConflict(ConflictRecord{
+		BalanceID:       id,
+		Actor:           u.actor,
+		ExpectedVersion: expected,
+		ActualVersion:   actual,
+		Delta:           delta,
+		Attempt:         attempt,
+		At:              time.Now(),
+	})
+	if err != nil {
+		u.auditError(err)
+	}
+}
+
+func (u *Updater) auditError(err error) {
+	if u.onAuditError != nil {
+		u.onAuditError(err)
+	}
+}
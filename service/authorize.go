@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// ErrForbidden is wrapped by the error of a write its Authorizer refused
+var ErrForbidden = errors.New("write not authorized")
+
+// Kinds of WriteOp
+const (
+	WriteUpdate   = "update"    // UpdateBalance and UpdateIfVersion, and what runs on them
+	WritePrepare  = "prepare"   // Prepare and PrepareIfVersion
+	WriteScript   = "script"    // a balance written by RunScript
+	WriteForceSet = "force_set" // ForceSet
+	WriteMetadata = "metadata"  // PatchMetadata
+	WriteShard    = "shard"     // UpdateShardedBalance
+	WriteFreeze   = "freeze"    // Updater.Freeze
+	WriteUnfreeze = "unfreeze"  // Updater.Unfreeze
+	WriteClose    = "close"     // Updater.Close
+	WriteDelete   = "delete"    // Updater.DeleteBalance
+)
+
+// WriteOp is the write an Authorizer is asked about
+type WriteOp struct {
+	Kind   string
+	Delta  int64 // the change of the amount; 0 for metadata, status changes and deletes
+	Amount int64 // for WriteForceSet the amount set; 0 otherwise
+}
+
+// Authorizer allows a write of balance by returning nil. Its error is
+// returned wrapped in ErrForbidden, and nothing is written.
+type Authorizer func(ctx context.Context, balance models.Balance, op WriteOp) error
+
+// WithAuthorizer asks authorize before every write of an integer balance, so
+// the HTTP layer can check that the caller owns it. The balance is read for
+// it first, one more read per update; a script passes the balances it read.
+// ApplyAdjustment, CommitPrepared, sagas and the key lookups are authorized
+// as the updates they run. The context is the one of WithContext, or the
+// one passed to RunScript. Decimal balances have no Balance to ask about, so
+// UpdateDecimalBalance refuses to run with an Authorizer.
+func WithAuthorizer(authorize Authorizer) Option {
+	return func(u *Updater) {
+		u.authorizer = authorize
+	}
+}
+
+// WithContext sets the context an Authorizer gets, e.g. that of the request,
+// carrying the authenticated caller. Derive a per-request copy with
+// u.With(WithContext(ctx)).
+func WithContext(ctx context.Context) Option {
+	return func(u *Updater) {
+		u.ctx = ctx
+	}
+}
+
+// authorize reads balance id and asks the Authorizer about op, if there is one
+func (u *Updater) authorize(id uint, op WriteOp) error {
+	if u.authorizer == nil {
+		return nil
+	}
+	var balance models.Balance
+	var err error
+	if u.store != nil {
+		balance, err = u.store.Get(id)
+	} else {
+		err = notFound(u.db.First(&balance, id).Error)
+	}
+	if err != nil {
+		return err
+	}
+	return u.authorizeBalance(u.context(), balance, op)
+}
+
+// context returns the context of WithContext, or the background context
+func (u *Updater) context() context.Context {
+	if u.ctx == nil {
+		return context.Background()
+	}
+	return u.ctx
+}
+
+// authorizeBalance asks the Authorizer about op on a balance already read
+func (u *Updater) authorizeBalance(ctx context.Context, balance models.Balance, op WriteOp) error {
+	if u.authorizer == nil {
+		return nil
+	}
+	if err := u.authorizer(ctx, balance, op); err != nil {
+		if errors.Is(err, ErrForbidden) {
+			return err
+		}
+		return fmt.Errorf("%w: balance %d: %w", ErrForbidden, balance.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBatcherClosed is returned for submissions after Close
+var ErrBatcherClosed = errors.New("batcher is closed")
+
+// Batcher coalesces deltas for the same balance ID over a short window and
+// applies them as a single versioned update. On a hot row this turns N
+// conflicting CAS attempts into one, at the cost of up to one window of latency.
+type Batcher struct {
+	updater *Updater
+	window  time.Duration
+	maxOps  int
+	flushes *KeyedSerializer // one in-flight flush per balance ID
+
+	mu      sync.Mutex
+	pending map[uint]*batch
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// BatchResult is delivered to every caller whose delta was part of a flush.
+// Outcome describes the combined update, not the caller's share of it.
+type BatchResult struct {
+	Outcome UpdateOutcome
+	Err     error
+}
+
+// batch accumulates deltas for one balance ID until it is flushed
+type batch struct {
+	delta   int64
+	waiters []chan BatchResult
+	timer   *time.Timer
+}
+
+// NewBatcher returns a Batcher that flushes a balance's pending deltas after
+// window has passed since the first one, or as soon as maxOps have queued.
+func NewBatcher(updater *Updater, window time.Duration, maxOps int) *Batcher {
+	if maxOps <= 0 {
+		maxOps = 100
+	}
+	return &Batcher{
+		updater: updater,
+		window:  window,
+		maxOps:  maxOps,
+		flushes: NewKeyedSerializer(0),
+		pending: make(map[uint]*batch),
+	}
+}
+
+// Submit queues delta for the balance and returns a channel that receives the
+// result of the combined update the delta was flushed with.
+func (b *Batcher) Submit(id uint, delta int64) <-chan BatchResult {
+	result := make(chan BatchResult, 1)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		result <- BatchResult{Err: ErrBatcherClosed}
+		return result
+	}
+
+	p, ok := b.pending[id]
+	if !ok {
+		p = &batch{}
+		b.pending[id] = p
+		p.timer = time.AfterFunc(b.window, func() { b.flush(id, p) })
+	}
+	p.delta += delta
+	p.waiters = append(p.waiters, result)
+
+	if len(p.waiters) >= b.maxOps {
+		p.timer.Stop()
+		b.detach(id, p)
+	}
+	return result
+}
+
+// Add submits delta and waits for the combined update to finish
+func (b *Batcher) Add(id uint, delta int64) (UpdateOutcome, error) {
+	r := <-b.Submit(id, delta)
+	return r.Outcome, r.Err
+}
+
+// Close flushes every pending batch and waits for in-flight updates to finish.
+// Later submissions fail with ErrBatcherClosed.
+func (b *Batcher) Close() {
+	b.mu.Lock()
+	b.closed = true
+	for id, p := range b.pending {
+		p.timer.Stop()
+		b.detach(id, p)
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+}
+
+// flush is called by the window timer
+func (b *Batcher) flush(id uint, p *batch) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// The batch may already have been flushed because it filled up
+	if b.pending[id] == p {
+		b.detach(id, p)
+	}
+}
+
+// detach removes the batch from pending and applies it in the background.
+// Callers must hold b.mu.
+func (b *Batcher) detach(id uint, p *batch) {
+	delete(b.pending, id)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		var r BatchResult
+		b.flushes.Do(id, func() error {
+			r.Outcome, r.Err = b.updater.UpdateBalance(id, p.delta)
+			return r.Err
+		})
+		for _, w := range p.waiters {
+			w <- r
+		}
+	}()
+}
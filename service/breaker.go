@@ -0,0 +1,185 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrCircuitOpen is returned without touching the database while the breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of a CircuitBreaker
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // requests flow normally
+	CircuitOpen                         // requests fail fast with ErrCircuitOpen
+	CircuitHalfOpen                     // a single probe request is let through
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig configures a CircuitBreaker
+type BreakerConfig struct {
+	Window        time.Duration // Period failures are counted over (default 10s)
+	MinRequests   int           // Requests needed in a window before it can trip (default 20)
+	FailureRatio  float64       // Failed/total ratio that opens the breaker (default 0.5)
+	OpenTimeout   time.Duration // Time spent open before a probe is allowed (default 5s)
+	OnStateChange func(from, to CircuitState)
+}
+
+// CircuitBreaker opens when retry exhaustion or database errors exceed the
+// configured ratio, so a struggling database is not hammered with retries.
+type CircuitBreaker struct {
+	cfg BreakerConfig
+
+	mu          sync.Mutex
+	state       CircuitState
+	windowStart time.Time
+	total       int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+}
+
+// NewCircuitBreaker returns a closed breaker
+func NewCircuitBreaker(cfg BreakerConfig) *CircuitBreaker {
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 20
+	}
+	if cfg.FailureRatio <= 0 {
+		cfg.FailureRatio = 0.5
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = 5 * time.Second
+	}
+	return &CircuitBreaker{cfg: cfg, windowStart: time.Now()}
+}
+
+// State returns the current breaker state
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// Allow reports whether a request may proceed. Every allowed request must be
+// followed by Record with its result.
+func (cb *CircuitBreaker) Allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		if time.Since(cb.openedAt) < cb.cfg.OpenTimeout {
+			return ErrCircuitOpen
+		}
+		cb.setState(CircuitHalfOpen)
+		cb.probing = true
+		return nil
+	case CircuitHalfOpen:
+		if cb.probing {
+			return ErrCircuitOpen
+		}
+		cb.probing = true
+	}
+	return nil
+}
+
+// Record reports the result of an allowed request
+func (cb *CircuitBreaker) Record(err error) {
+	failed := isBreakerFailure(err)
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitHalfOpen {
+		cb.probing = false
+		if failed {
+			cb.open()
+		} else {
+			cb.setState(CircuitClosed)
+			cb.resetWindow()
+		}
+		return
+	}
+
+	if time.Since(cb.windowStart) > cb.cfg.Window {
+		cb.resetWindow()
+	}
+	cb.total++
+	if failed {
+		cb.failures++
+	}
+
+	if cb.state == CircuitClosed && cb.total >= cb.cfg.MinRequests &&
+		float64(cb.failures)/float64(cb.total) >= cb.cfg.FailureRatio {
+		cb.open()
+	}
+}
+
+func (cb *CircuitBreaker) open() {
+	cb.openedAt = time.Now()
+	cb.setState(CircuitOpen)
+}
+
+func (cb *CircuitBreaker) resetWindow() {
+	cb.windowStart = time.Now()
+	cb.total = 0
+	cb.failures = 0
+}
+
+func (cb *CircuitBreaker) setState(to CircuitState) {
+	from := cb.state
+	if from == to {
+		return
+	}
+	cb.state = to
+	if cb.cfg.OnStateChange != nil {
+		cb.cfg.OnStateChange(from, to)
+	}
+}
+
+// rejections are errors of updates the database served but the service or
+// the caller refused. They say nothing about database health.
+var rejections = []error{
+	gorm.ErrRecordNotFound, ErrConflict, ErrNotActive, ErrInvalidTransition,
+	ErrInsufficientFunds, ErrLimitExceeded, ErrPolicyDenied, ErrForbidden,
+	ErrAssertionFailed, ErrNotSharded, ErrStaleFence, ErrPreparedExpired,
+	ErrPreparedResolved, ErrBalanceExists, ErrStrategyUnsupported, ErrRateLimited,
+}
+
+// isBreakerFailure counts retry exhaustion, running out of the retry budget
+// and database errors. Rejections, such as a missing row, a frozen balance,
+// a policy or a single version conflict the caller asked to see, count as
+// successes.
+func isBreakerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrRetryExhausted) || errors.Is(err, ErrDeadlineExceeded) {
+		return true
+	}
+	for _, rejection := range rejections {
+		if errors.Is(err, rejection) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/cache"
+)
+
+// WithCache keeps c up to date with the writes of this updater: a successful
+// update stores the new amount and version, and a conflict drops the
+// balance, whose cached version is then known to be stale. Writes made
+// elsewhere are only seen once an entry is older than the staleness bound a
+// reader passes to GetCached.
+func WithCache(c *cache.Cache) Option {
+	return func(u *Updater) {
+		u.cache = c
+	}
+}
+
+// GetCached returns balance id from the cache if it was stored at most
+// maxStale ago, and otherwise reads it from the database and caches it. It
+// reports whether the cache served the read. Without WithCache every call
+// reads the database, as does every call on an Updater scoped by WithTenant,
+// since cache entries do not record the tenant.
+func (u *Updater) GetCached(id uint, maxStale time.Duration) (cache.Entry, bool, error) {
+	if u.cache != nil && !tenantScoped(u.db) {
+		if entry, ok := u.cache.Get(id, maxStale); ok {
+			return entry, true, nil
+		}
+	}
+	balance, err := GetBalance(u.db, id)
+	if err != nil {
+		return cache.Entry{}, false, err
+	}
+	if u.cache != nil {
+		u.cache.Set(id, balance.Amount, balance.Version)
+	}
+	return cache.Entry{ID: id, Amount: balance.Amount, Version: balance.Version, Stored: time.Now()}, false, nil
+}
+
+// cacheWrite updates the cache with the result of a write to balance id
+func (u *Updater) cacheWrite(id uint, outcome UpdateOutcome, err error) {
+	switch {
+	case u.cache == nil:
+	case err == nil:
+		u.cache.Set(id, outcome.NewAmount, outcome.Version)
+	case errors.Is(err, ErrConflict), errors.Is(err, ErrRetryExhausted), errors.Is(err, gorm.ErrRecordNotFound):
+		u.cache.Invalidate(id)
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// GetBalance returns the balance with its current version. Hand the version
+// back to UpdateIfVersion to write based on what was read.
+func GetBalance(db *gorm.DB, id uint) (models.Balance, error) {
+	var balance models.Balance
+	if err := db.First(&balance, id).Error; err != nil {
+		return models.Balance{}, err
+	}
+	return balance, nil
+}
+
+// UpdateIfVersion adds delta only if the balance is still at expectedVersion,
+// using the default Updater
+func UpdateIfVersion(db *gorm.DB, id uint, expectedVersion int, delta int64) (UpdateOutcome, error) {
+	return NewUpdater(db).UpdateIfVersion(id, expectedVersion, delta)
+}
+
+// UpdateIfVersion adds delta only if the balance is still at expectedVersion,
+// for clients that read and write in separate requests. It makes a single
+// attempt and returns ErrConflict on a version mismatch: retrying is up to the
+// client, which should re-read the balance first. The write check, rate
+// limit, breaker, serializer, metrics, event publisher and conflict audit
+// options apply; retries, the pessimistic fallback and hooks do not.
+func (u *Updater) UpdateIfVersion(id uint, expectedVersion int, delta int64) (UpdateOutcome, error) {
+	if err := u.authorize(id, WriteOp{Kind: WriteUpdate, Delta: delta}); err != nil {
+		return UpdateOutcome{}, err
+	}
+	start := time.Now()
+	outcome := UpdateOutcome{Attempts: 1}
+	err := u.guard(id, func() error {
+		// The expected version is known, so drivers with RETURNING skip the read
+		current := -1
+		var err error
+		if supportsReturning(u.db) {
+			err = u.commit(func(tx *gorm.DB) error {
+				return writeReturning(tx, id, expectedVersion, delta, &current, &outcome)
+			}, id, delta, &outcome)
+		} else {
+			var balance models.Balance
+			if err := u.db.First(&balance, id).Error; err != nil {
+				return notFound(err)
+			}
+			if err := checkActive(balance); err != nil {
+				return err
+			}
+			if balance.Version != expectedVersion {
+				outcome.Conflicts = 1
+				u.auditConflict(id, expectedVersion, balance.Version, delta, 1)
+				return ErrConflict
+			}
+			err = u.commit(func(tx *gorm.DB) error {
+				return writeVersioned(tx, balance, delta, &outcome)
+			}, id, delta, &outcome)
+		}
+
+		var retryable retryableError
+		if errors.As(err, &retryable) {
+			return retryable.err
+		}
+		if err == ErrConflict {
+			outcome.Conflicts = 1
+			u.auditConflict(id, expectedVersion, current, delta, 1)
+		}
+		return err
+	})
+	u.record(outcome, err, start)
+	u.cacheWrite(id, outcome, err)
+	return outcome, err
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// Consistency is how fresh a read must be
+type Consistency string
+
+const (
+	// Strong reads from the primary
+	Strong Consistency = "strong"
+	// BoundedStaleness reads from a replica lagging less than the router's
+	// bound, or from the primary if none does
+	BoundedStaleness Consistency = "bounded"
+	// Eventual reads from any replica, or from the primary if there are none
+	Eventual Consistency = "eventual"
+)
+
+// ParseConsistency parses a consistency level; the empty string means Strong
+func ParseConsistency(s string) (Consistency, error) {
+	switch c := Consistency(s); c {
+	case "":
+		return Strong, nil
+	case Strong, BoundedStaleness, Eventual:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown consistency %q: want strong, bounded or eventual", s)
+}
+
+// LagFunc measures how far a replica is behind the primary
+type LagFunc func(ctx context.Context, replica *gorm.DB) (time.Duration, error)
+
+// PostgresLag measures replica lag as the age of the last replayed
+// transaction. An idle primary makes the lag look larger than it is, so
+// bounded reads fall back to the primary until the next write replays.
+func PostgresLag(ctx context.Context, replica *gorm.DB) (time.Duration, error) {
+	var seconds float64
+	err := replica.WithContext(ctx).
+		Raw("SELECT COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)").
+		Scan(&seconds).Error
+	return time.Duration(seconds * float64(time.Second)), err
+}
+
+// ReadRouter picks the connection a read goes to by its consistency level
+type ReadRouter struct {
+	primary  *gorm.DB
+	replicas []*replica
+	maxLag   time.Duration
+	lag      LagFunc
+	next     atomic.Uint64
+}
+
+type replica struct {
+	db *gorm.DB
+
+	mu       sync.Mutex
+	lag      time.Duration
+	healthy  bool
+	measured time.Time
+}
+
+// lagRefresh is how long a lag measurement is reused
+const lagRefresh = time.Second
+
+// NewReadRouter returns a router over primary and replicas. Bounded reads
+// accept replicas lagging at most maxLag, measured with lag (default
+// PostgresLag) at most once a second per replica.
+func NewReadRouter(primary *gorm.DB, replicas []*gorm.DB, maxLag time.Duration, lag LagFunc) *ReadRouter {
+	if lag == nil {
+		lag = PostgresLag
+	}
+	r := &ReadRouter{primary: primary, maxLag: maxLag, lag: lag}
+	for _, db := range replicas {
+		r.replicas = append(r.replicas, &replica{db: db})
+	}
+	return r
+}
+
+// DB returns the connection for a read at consistency c and whether it is a replica
+func (r *ReadRouter) DB(ctx context.Context, c Consistency) (*gorm.DB, bool) {
+	if c == Strong || len(r.replicas) == 0 {
+		return r.primary, false
+	}
+
+	start := int(r.next.Add(1))
+	for i := range r.replicas {
+		rep := r.replicas[(start+i)%len(r.replicas)]
+		if c == Eventual {
+			return rep.db, true
+		}
+		if lag, ok := r.replicaLag(ctx, rep); ok && lag <= r.maxLag {
+			return rep.db, true
+		}
+	}
+	return r.primary, false
+}
+
+// Balance reads balance id at consistency c and reports whether a replica
+// served it. With minVersion above zero the read must see at least that
+// version, e.g. the one a write by the same client returned: a replica that
+// has not replayed it yet, or does not have the balance, is passed over for
+// the primary. This gives read-your-writes while other reads stay on the
+// replicas.
+func (r *ReadRouter) Balance(ctx context.Context, id uint, c Consistency, minVersion int) (models.Balance, bool, error) {
+	db, fromReplica := r.DB(ctx, c)
+	balance, err := GetBalance(db.WithContext(ctx), id)
+	if !fromReplica || minVersion <= 0 {
+		return balance, fromReplica, err
+	}
+	if (err == nil && balance.Version < minVersion) || errors.Is(err, gorm.ErrRecordNotFound) {
+		balance, err = GetBalance(r.primary.WithContext(ctx), id)
+		return balance, false, err
+	}
+	return balance, true, err
+}
+
+// replicaLag returns the cached lag of rep, measuring it again when stale.
+// A replica whose lag cannot be measured is not used for bounded reads.
+func (r *ReadRouter) replicaLag(ctx context.Context, rep *replica) (time.Duration, bool) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	if time.Since(rep.measured) >= lagRefresh {
+		lag, err := r.lag(ctx, rep.db)
+		rep.lag, rep.healthy, rep.measured = lag, err == nil, time.Now()
+	}
+	return rep.lag, rep.healthy
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/metrics"
+)
+
+// DefaultContentionWindow is the window of a ContentionMonitor given none
+const DefaultContentionWindow = time.Minute
+
+// HotKey is the recent contention on one balance
+type HotKey struct {
+	BalanceID    uint    `json:"balance_id"`
+	Attempts     int     `json:"attempts"`
+	Conflicts    int     `json:"conflicts"`
+	ConflictRate float64 `json:"conflict_rate"`
+}
+
+// ContentionMonitor counts the attempts and conflicts of every balance over a
+// sliding window, to find the hot keys: the balances that conflict most and
+// may need sharding or batching. Share one between the updaters of a process.
+type ContentionMonitor struct {
+	metrics metrics.Metrics
+
+	mu      sync.Mutex
+	windows conflictWindows
+}
+
+// NewContentionMonitor returns a monitor over window (DefaultContentionWindow
+// if zero) that reports to m, which may be nil
+func NewContentionMonitor(window time.Duration, m metrics.Metrics) *ContentionMonitor {
+	if window <= 0 {
+		window = DefaultContentionWindow
+	}
+	if m == nil {
+		m = metrics.Nop{}
+	}
+	return &ContentionMonitor{metrics: m, windows: newConflictWindows(window)}
+}
+
+// WithContentionMonitor counts every optimistic attempt on m
+func WithContentionMonitor(m *ContentionMonitor) Option {
+	return func(u *Updater) {
+		u.contention = m
+	}
+}
+
+// Record counts one attempt on balance id and whether it conflicted
+func (m *ContentionMonitor) Record(id uint, conflict bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.windows.record(id, conflict, time.Now())
+}
+
+// HotKeys returns up to n balances with conflicts over the last window, most
+// conflicts first; n <= 0 returns them all
+func (m *ContentionMonitor) HotKeys(n int) []HotKey {
+	m.mu.Lock()
+	keys := m.hotKeys(time.Now())
+	m.mu.Unlock()
+
+	slices.SortFunc(keys, func(a, b HotKey) int {
+		if c := cmp.Compare(b.Conflicts, a.Conflicts); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.BalanceID, b.BalanceID)
+	})
+	if n > 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// hotKeys returns the balances with conflicts, unordered
+func (m *ContentionMonitor) hotKeys(now time.Time) []HotKey {
+	var keys []HotKey
+	for id, w := range m.windows.keys {
+		attempts, conflicts := w.counts(now, m.windows.window)
+		if int(conflicts) == 0 {
+			continue
+		}
+		keys = append(keys, HotKey{
+			BalanceID:    id,
+			Attempts:     int(attempts),
+			Conflicts:    int(conflicts),
+			ConflictRate: conflicts / attempts,
+		})
+	}
+	return keys
+}
+
+// Report records the number of balances with conflicts and, per balance, its
+// conflicts over the window in a distribution. Balance IDs are not labels;
+// use HotKeys to name them.
+func (m *ContentionMonitor) Report() {
+	m.mu.Lock()
+	keys := m.hotKeys(time.Now())
+	m.mu.Unlock()
+
+	m.metrics.Gauge(metrics.ContendedBalances, float64(len(keys)), nil)
+	for _, k := range keys {
+		m.metrics.Observe(metrics.BalanceConflicts, float64(k.Conflicts), nil)
+	}
+}
+
+// Run reports once per window until ctx is done, so each balance is counted
+// about once in the distribution
+func (m *ContentionMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.windows.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Report()
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// ErrBalanceExists is returned by CreateBalance when the owner already has a balance
+var ErrBalanceExists = errors.New("balance already exists for owner")
+
+// CreateBalance provisions the balance for ownerID. If the owner already has
+// one it is returned together with ErrBalanceExists, so a retried request can
+// tell "created" from "already there" without a second lookup.
+func CreateBalance(db *gorm.DB, ownerID string, initialAmount int64) (models.Balance, error) {
+	balance, created, err := GetOrCreateBalance(db, ownerID, initialAmount)
+	if err != nil {
+		return models.Balance{}, err
+	}
+	if !created {
+		return balance, ErrBalanceExists
+	}
+	return balance, nil
+}
+
+// GetOrCreateBalance returns the owner's balance, creating it with
+// initialAmount if it does not exist. Concurrent calls for the same owner
+// create exactly one row; initialAmount is ignored for an existing balance.
+// An owner whose balance was deleted gets gorm.ErrRecordNotFound rather than a
+// new balance, since the deleted row still holds the owner ID.
+func GetOrCreateBalance(db *gorm.DB, ownerID string, initialAmount int64) (balance models.Balance, created bool, err error) {
+	return GetOrCreateBalanceByKey(db, ownerID, "", initialAmount)
+}
+
+// GetOrCreateBalanceByKey is GetOrCreateBalance for the owner's balance in
+// currency. An owner has at most one balance per currency.
+func GetOrCreateBalanceByKey(db *gorm.DB, ownerID, currency string, initialAmount int64) (balance models.Balance, created bool, err error) {
+	if ownerID == "" {
+		return models.Balance{}, false, errors.New("balance owner is required")
+	}
+
+	balance = models.Balance{OwnerID: &ownerID, Currency: currency, Amount: initialAmount}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&balance)
+	if result.Error != nil {
+		return models.Balance{}, false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return balance, true, nil
+	}
+
+	// Lost the race or created earlier: load the existing row
+	balance, err = GetBalanceByKey(db, ownerID, currency)
+	if err != nil {
+		return models.Balance{}, false, err
+	}
+	return balance, false, nil
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrStatementTimeout is the error of an attempt that ran past the
+// WithStatementTimeout limit. It is retried.
+var ErrStatementTimeout = errors.New("statement timeout")
+
+// ErrorClassifier reports whether a database error is transient, so the
+// attempt that failed with it is worth retrying
+type ErrorClassifier func(err error) bool
+
+// WithErrorClassifier decides with c which database errors are retried
+// instead of Retryable, e.g. to retry the errors of another driver
+func WithErrorClassifier(c ErrorClassifier) Option {
+	return func(u *Updater) {
+		u.classifier = c
+	}
+}
+
+// WithStatementTimeout bounds every attempt of UpdateBalance and Modify,
+// including its statements and its transaction, to d. An attempt past it is
+// cancelled and retried with ErrStatementTimeout, so one slow statement, e.g.
+// waiting on a lock, does not use up the retry budget of WithTimeout. Zero,
+// the default, leaves attempts unbounded.
+func WithStatementTimeout(d time.Duration) Option {
+	return func(u *Updater) {
+		u.statementTimeout = d
+	}
+}
+
+// SQLSTATE codes of transient Postgres errors, besides class 08, connection
+// exceptions
+var retryableStates = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"55P03": true, // lock_not_available
+	"57014": true, // query_canceled, e.g. by statement_timeout
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+	"53300": true, // too_many_connections
+}
+
+// Error numbers of transient MySQL errors
+var retryableMySQL = map[string]bool{
+	"1040": true, // too many connections
+	"1205": true, // lock wait timeout exceeded
+	"1213": true, // deadlock found
+	"3024": true, // max_execution_time exceeded
+}
+
+// mysqlError matches the message of a MySQL server error,
+// "Error 1213 (40001): Deadlock found ..."
+var mysqlError = regexp.MustCompile(`^Error (\d+)(?: \(([0-9A-Z]{5})\))?:`)
+
+// Retryable is the default ErrorClassifier. It reports as transient
+// serialization failures, deadlocks, lock and statement timeouts and broken
+// connections of Postgres, MySQL and SQLite, and ErrStatementTimeout.
+// Anything else, such as a constraint violation or a syntax error, fails the
+// same way on every attempt and is returned at once.
+func Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrStatementTimeout) || errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return retryableStates[pgErr.Code] || strings.HasPrefix(pgErr.Code, "08")
+	}
+	if pgconn.SafeToRetry(err) {
+		return true
+	}
+
+	msg := err.Error()
+	if m := mysqlError.FindStringSubmatch(msg); m != nil {
+		return retryableMySQL[m[1]] || m[2] == "40001"
+	}
+	// SQLITE_BUSY and SQLITE_LOCKED, and the broken connection of the MySQL driver
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked") ||
+		strings.Contains(msg, "invalid connection")
+}
+
+// retryable reports whether the retry loop retries err
+func (u *Updater) retryable(err error) bool {
+	if errors.Is(err, ErrInjectedFault) {
+		return true
+	}
+	if u.classifier != nil {
+		return u.classifier(err)
+	}
+	return Retryable(err)
+}
+
+// timed runs attempt on a copy of the Updater whose database is bounded by the
+// statement timeout, and turns running past it into ErrStatementTimeout
+func (u *Updater) timed(attempt func(u *Updater, o *UpdateOutcome) error) func(*UpdateOutcome) error {
+	if u.statementTimeout <= 0 {
+		return func(o *UpdateOutcome) error { return attempt(u, o) }
+	}
+	return func(o *UpdateOutcome) error {
+		// The statement context of the handle carries its tenant and any
+		// deadline of the caller; the context of WithContext cancels it too
+		parent := u.db.Statement.Context
+		if parent == nil {
+			parent = context.Background()
+		}
+		ctx, cancel := context.WithTimeout(parent, u.statementTimeout)
+		defer cancel()
+		if u.ctx != nil {
+			defer context.AfterFunc(u.ctx, cancel)()
+		}
+		c := *u
+		c.db = u.db.WithContext(ctx)
+		err := attempt(&c, o)
+		if err != nil && ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+			return retryableError{fmt.Errorf("%w after %s", ErrStatementTimeout, u.statementTimeout)}
+		}
+		return err
+	}
+}
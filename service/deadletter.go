@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// ErrDeadLettered is returned instead of ErrRetryExhausted when the update was
+// queued for replay by WithDeadLetter. The update will still be applied, so
+// the caller must not retry it itself.
+var ErrDeadLettered = fmt.Errorf("%w, queued for replay", ErrRetryExhausted)
+
+// DeadLetter is an update that exhausted its retries
+type DeadLetter struct {
+	BalanceID uint
+	Delta     int64
+	// IdempotencyKey is the adjustment reference for ApplyAdjustment, or a
+	// generated "dead-letter:" key for UpdateBalance. The replay is applied
+	// under it, so it happens at most once.
+	IdempotencyKey string
+	TenantID       string // tenant the update was scoped to, if any; the key is unique per tenant
+	Attempts       int
+	At             time.Time
+}
+
+// DeadLetterSink stores dead letters for replay
+type DeadLetterSink interface {
+	AddDeadLetter(DeadLetter) error
+}
+
+// DeadLetterSinkFunc adapts a function to DeadLetterSink, e.g. to publish
+// dead letters on a message queue
+type DeadLetterSinkFunc func(DeadLetter) error
+
+func (f DeadLetterSinkFunc) AddDeadLetter(d DeadLetter) error {
+	return f(d)
+}
+
+// TableDeadLetterSink writes dead letters to the failed_updates table, where
+// a Reprocessor replays them
+type TableDeadLetterSink struct {
+	DB *gorm.DB
+}
+
+func (s TableDeadLetterSink) AddDeadLetter(d DeadLetter) error {
+	return s.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.FailedUpdate{
+		TenantID:       d.TenantID,
+		IdempotencyKey: d.IdempotencyKey,
+		BalanceID:      d.BalanceID,
+		Delta:          d.Delta,
+		Attempts:       d.Attempts,
+		NextReplayAt:   d.At,
+		CreatedAt:      d.At,
+	}).Error
+}
+
+// WithDeadLetter sends updates of UpdateBalance and ApplyAdjustment that
+// exhaust their retries to sink and returns ErrDeadLettered for them. If the
+// sink fails, the update returns ErrRetryExhausted as before and the sink
+// error goes to onError, which may be nil.
+func WithDeadLetter(sink DeadLetterSink, onError func(error)) Option {
+	return func(u *Updater) {
+		u.deadLetter = sink
+		u.onDeadLetterError = onError
+	}
+}
+
+// deadLettered queues an exhausted update and returns the error for the
+// caller: ErrDeadLettered once queued, err otherwise. key may be empty to
+// generate one.
+func (u *Updater) deadLettered(key string, id uint, delta int64, outcome UpdateOutcome, err error) error {
+	if u.deadLetter == nil || !errors.Is(err, ErrRetryExhausted) {
+		return err
+	}
+	if key == "" {
+		key = "dead-letter:" + newOperationID()
+	}
+	sinkErr := u.deadLetter.AddDeadLetter(DeadLetter{
+		BalanceID:      id,
+		Delta:          delta,
+		IdempotencyKey: key,
+		TenantID:       tenantOf(u.db),
+		Attempts:       outcome.Attempts,
+		At:             time.Now(),
+	})
+	if sinkErr != nil {
+		if u.onDeadLetterError != nil {
+			u.onDeadLetterError(sinkErr)
+		}
+		return err
+	}
+	return ErrDeadLettered
+}
+
+// Reprocessor replays the updates in the failed_updates table through
+// ApplyAdjustment under their idempotency keys and tenants, so a replay is
+// applied once even if it is retried or marking it done fails. Failed replays back off
+// exponentially; they are never dropped.
+type Reprocessor struct {
+	db         *gorm.DB
+	updater    *Updater
+	batchSize  int
+	maxBackoff time.Duration
+}
+
+// NewReprocessor returns a reprocessor replaying up to batchSize updates per
+// pass with updater, whose dead letter option is ignored
+func NewReprocessor(db *gorm.DB, updater *Updater, batchSize int) *Reprocessor {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	replay := *updater
+	replay.deadLetter = nil
+	return &Reprocessor{db: db, updater: &replay, batchSize: batchSize, maxBackoff: time.Hour}
+}
+
+// replayBackoff is the wait after the first failed replay, doubled after each
+const replayBackoff = time.Second
+
+// ReprocessOnce replays the updates that are due and returns how many were
+// applied (or found already applied)
+func (r *Reprocessor) ReprocessOnce(ctx context.Context) (int, error) {
+	var due []models.FailedUpdate
+	err := r.db.WithContext(ctx).Where("replayed_at IS NULL AND next_replay_at <= ?", time.Now()).
+		Order("id").Limit(r.batchSize).Find(&due).Error
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, f := range due {
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+
+		updater := r.updater
+		if f.TenantID != "" {
+			updater = updater.With(WithTenant(f.TenantID))
+		}
+		_, err := updater.ApplyAdjustment(f.IdempotencyKey, f.BalanceID, f.Delta)
+		now := time.Now()
+		if err == nil {
+			if err := r.db.Model(&f).Update("replayed_at", now).Error; err != nil {
+				return replayed, err
+			}
+			replayed++
+			continue
+		}
+
+		wait := replayBackoff << min(f.Replays, 20)
+		if wait > r.maxBackoff {
+			wait = r.maxBackoff
+		}
+		msg := err.Error()
+		if len(msg) > 512 {
+			msg = msg[:512]
+		}
+		if err := r.db.Model(&f).Updates(map[string]any{
+			"replays":        f.Replays + 1,
+			"last_error":     msg,
+			"next_replay_at": now.Add(wait),
+		}).Error; err != nil {
+			return replayed, err
+		}
+	}
+	return replayed, nil
+}
+
+// Run calls ReprocessOnce every interval until ctx is done. Errors go to
+// onError, which may be nil.
+func (r *Reprocessor) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	for {
+		if _, err := r.ReprocessOnce(ctx); err != nil && ctx.Err() == nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// DecimalOutcome is the UpdateOutcome of a decimal update. Its amounts shadow
+// the int64 amounts of the embedded outcome, which are left zero.
+type DecimalOutcome struct {
+	UpdateOutcome
+	PreviousAmount decimal.Decimal
+	NewAmount      decimal.Decimal
+}
+
+// UpdateDecimalBalance adds delta to a DecimalBalance using the default retry
+// policy, overridden by opts for this call only
+func UpdateDecimalBalance(db *gorm.DB, id uint, delta decimal.Decimal, opts ...Option) (DecimalOutcome, error) {
+	return NewUpdater(db, opts...).UpdateDecimalBalance(id, delta)
+}
+
+// UpdateDecimalBalance adds delta to the DecimalBalance with the same retry,
+// fallback and guard options as UpdateBalance. Hooks see a zero Attempt.Delta.
+// It returns ErrForbidden with an Authorizer, which only judges integer
+// balances, and ErrChecksUnsupported with policies or spending limits.
+func (u *Updater) UpdateDecimalBalance(id uint, delta decimal.Decimal) (DecimalOutcome, error) {
+	if u.authorizer != nil {
+		return DecimalOutcome{}, fmt.Errorf("%w: decimal balance %d: an Authorizer cannot check decimal balances", ErrForbidden, id)
+	}
+	if err := u.refuseUnchecked(id, "decimal update"); err != nil {
+		return DecimalOutcome{}, err
+	}
+	start := time.Now()
+	var outcome DecimalOutcome
+	err := u.guard(id, func() error {
+		var err error
+		outcome.UpdateOutcome, err = u.retry(Attempt{BalanceID: id},
+			func(o *UpdateOutcome) error { return u.updateDecimalOnce(id, delta, &outcome, o) },
+			func(o *UpdateOutcome) error { return u.updateDecimalLocked(id, delta, &outcome, o) })
+		return err
+	})
+	u.record(outcome.UpdateOutcome, err, start)
+	return outcome, err
+}
+
+// updateDecimalOnce reads the balance and writes it back guarded by its version
+func (u *Updater) updateDecimalOnce(id uint, delta decimal.Decimal, d *DecimalOutcome, o *UpdateOutcome) error {
+	var balance models.DecimalBalance
+	if err := u.db.First(&balance, id).Error; err != nil {
+		return notFound(err)
+	}
+
+	// Updates writes the new values back into balance, so keep the originals
+	previous, version := balance.Amount, balance.Version
+	amount := previous.Add(delta)
+	result := u.db.Model(&balance).Where("id = ? AND version = ?", balance.ID, version).Updates(map[string]any{
+		"amount":  amount,
+		"version": version + 1,
+	})
+	if result.Error != nil {
+		return retryableError{result.Error}
+	}
+	if result.RowsAffected == 0 {
+		return conflictOrMissing(u.db, &models.DecimalBalance{}, balance.ID)
+	}
+
+	d.PreviousAmount = previous
+	d.NewAmount = amount
+	o.Version = version + 1
+	return nil
+}
+
+// updateDecimalLocked is updateLocked for DecimalBalance
+func (u *Updater) updateDecimalLocked(id uint, delta decimal.Decimal, d *DecimalOutcome, o *UpdateOutcome) error {
+	return u.db.Transaction(func(tx *gorm.DB) error {
+		var balance models.DecimalBalance
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&balance, id).Error; err != nil {
+			return notFound(err)
+		}
+
+		previous, version := balance.Amount, balance.Version
+		amount := previous.Add(delta)
+		result := tx.Model(&balance).Where("id = ? AND version = ?", balance.ID, version).Updates(map[string]any{
+			"amount":  amount,
+			"version": version + 1,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// Only possible when the driver ignores row locks (e.g. SQLite)
+			return ErrConflict
+		}
+
+		d.PreviousAmount = previous
+		d.NewAmount = amount
+		o.Version = version + 1
+		return nil
+	})
+}
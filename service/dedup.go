@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ghozilaaa/optimistic-lock/metrics"
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// DedupStats describes how the idempotency window is being used
+type DedupStats struct {
+	Applied    int64     // References applied for the first time
+	Duplicates int64     // Calls skipped because their reference was already applied
+	Purged     int64     // Keys deleted by Cleanup
+	Stored     int64     // Keys currently in the window
+	Oldest     time.Time // Creation time of the oldest stored key; zero if none
+	TTL        time.Duration
+}
+
+// DuplicateRate is the share of calls that hit an already applied reference
+func (s DedupStats) DuplicateRate() float64 {
+	total := s.Applied + s.Duplicates
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Duplicates) / float64(total)
+}
+
+// Deduplicator applies adjustments through ApplyAdjustment and keeps their
+// references in dedup_keys for the TTL, so duplicates within the window are
+// counted and skipped without touching the ledger. Cleanup only deletes keys:
+// the adjustments stay, and their unique reference still refuses a replay
+// after its key expired.
+type Deduplicator struct {
+	db      *gorm.DB
+	metrics metrics.Metrics
+
+	mu         sync.Mutex
+	ttl        time.Duration
+	applied    int64
+	duplicates int64
+	purged     int64
+}
+
+// NewDeduplicator returns a Deduplicator keeping references for ttl (default
+// 7 days) and reporting to m (nil for none)
+func NewDeduplicator(db *gorm.DB, ttl time.Duration, m metrics.Metrics) *Deduplicator {
+	if m == nil {
+		m = metrics.Nop{}
+	}
+	d := &Deduplicator{db: db, metrics: m}
+	d.SetTTL(ttl)
+	return d
+}
+
+// Apply applies delta at most once per reference and counts the result
+func (d *Deduplicator) Apply(reference string, id uint, delta int64) (bool, error) {
+	return d.ApplyWith(NewUpdater(d.db), reference, id, delta)
+}
+
+// ApplyWith is Apply through u, e.g. an Updater scoped to the tenant and
+// caller of a request. The key is written in the transaction of the
+// adjustment, on the database of u.
+func (d *Deduplicator) ApplyWith(u *Updater, reference string, id uint, delta int64) (bool, error) {
+	applied, err := u.applyAdjustment(reference, id, delta, func(tx *gorm.DB) (bool, error) {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.DedupKey{Reference: reference, BalanceID: id})
+		return result.RowsAffected > 0, result.Error
+	})
+	if err != nil {
+		return false, err
+	}
+
+	d.mu.Lock()
+	if applied {
+		d.applied++
+	} else {
+		d.duplicates++
+	}
+	d.mu.Unlock()
+	if applied {
+		d.metrics.Count(metrics.DedupAppliedTotal, 1, nil)
+	} else {
+		d.metrics.Count(metrics.DedupDuplicatesTotal, 1, nil)
+	}
+	return applied, nil
+}
+
+// SetTTL changes how long references are kept; it takes effect on the next cleanup
+func (d *Deduplicator) SetTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = 7 * 24 * time.Hour
+	}
+	d.mu.Lock()
+	d.ttl = ttl
+	d.mu.Unlock()
+}
+
+// TTL returns how long references are kept
+func (d *Deduplicator) TTL() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ttl
+}
+
+// Cleanup deletes the keys older than the TTL and returns how many were removed
+func (d *Deduplicator) Cleanup() (int64, error) {
+	cutoff := time.Now().Add(-d.TTL())
+	result := d.db.Where("created_at < ?", cutoff).Delete(&models.DedupKey{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	d.mu.Lock()
+	d.purged += result.RowsAffected
+	d.mu.Unlock()
+	d.metrics.Count(metrics.DedupPurgedTotal, result.RowsAffected, nil)
+	return result.RowsAffected, nil
+}
+
+// RunCleanup calls Cleanup and Report every interval until ctx is cancelled.
+// Errors are passed to onError, which may be nil.
+func (d *Deduplicator) RunCleanup(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := d.Cleanup()
+			if err == nil {
+				_, err = d.Report()
+			}
+			if err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// Report records the size of the window and the age of its oldest key, and
+// returns the Stats they came from
+func (d *Deduplicator) Report() (DedupStats, error) {
+	stats, err := d.Stats()
+	if err != nil {
+		return DedupStats{}, err
+	}
+	d.metrics.Gauge(metrics.DedupStoredKeys, float64(stats.Stored), nil)
+	var age time.Duration
+	if !stats.Oldest.IsZero() {
+		age = time.Since(stats.Oldest)
+	}
+	d.metrics.Gauge(metrics.DedupOldestSeconds, age.Seconds(), nil)
+	return stats, nil
+}
+
+// Stats returns the counters together with the current size of the window
+func (d *Deduplicator) Stats() (DedupStats, error) {
+	var stored int64
+	if err := d.db.Model(&models.DedupKey{}).Count(&stored).Error; err != nil {
+		return DedupStats{}, err
+	}
+	var oldest []models.DedupKey
+	if err := d.db.Select("created_at").Order("created_at").Limit(1).Find(&oldest).Error; err != nil {
+		return DedupStats{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats := DedupStats{
+		Applied:    d.applied,
+		Duplicates: d.duplicates,
+		Purged:     d.purged,
+		Stored:     stored,
+		TTL:        d.ttl,
+	}
+	if len(oldest) > 0 {
+		stats.Oldest = oldest[0].CreatedAt
+	}
+	return stats, nil
+}
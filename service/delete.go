@@ -0,0 +1,38 @@
+package service
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// DeleteBalance soft-deletes the balance if it is still at version, the
+// version the caller read before deciding to delete. It returns ErrConflict
+// when the balance was updated since, and ErrNotFound when it does not exist
+// or was already deleted. Deletes are not retried: a conflict means the
+// decision to delete was based on stale data.
+func DeleteBalance(db *gorm.DB, id uint, version int) error {
+	result := db.Where("version = ?", version).Delete(&models.Balance{ID: id})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	// Nothing matched: tell a concurrent update apart from a missing row
+	var balance models.Balance
+	if err := db.Select("id").First(&balance, id).Error; err != nil {
+		return notFound(err)
+	}
+	return ErrConflict
+}
+
+// DeleteBalance is DeleteBalance on the database of the Updater, asking its
+// Authorizer first
+func (u *Updater) DeleteBalance(id uint, version int) error {
+	if err := u.authorize(id, WriteOp{Kind: WriteDelete}); err != nil {
+		return err
+	}
+	return DeleteBalance(u.db, id, version)
+}
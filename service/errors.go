@@ -0,0 +1,47 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrConflict is returned when the balance version changed between read and write
+	ErrConflict = errors.New("conflict: balance updated by another transaction")
+
+	// ErrRetryExhausted is returned when every attempt ended in a version conflict
+	ErrRetryExhausted = fmt.Errorf("%w, retry exhausted", ErrConflict)
+
+	// ErrNotFound is returned when the balance does not exist or was deleted,
+	// including between the read and the versioned write of an attempt. It
+	// wraps gorm.ErrRecordNotFound.
+	ErrNotFound = fmt.Errorf("balance not found: %w", gorm.ErrRecordNotFound)
+
+	// ErrDeadlineExceeded is returned when the retry loop stopped early because
+	// the next backoff would overrun the WithTimeout budget. The error also
+	// wraps the reason for the last retry, usually ErrRetryExhausted.
+	ErrDeadlineExceeded = errors.New("retry budget exceeded")
+)
+
+// notFound turns gorm.ErrRecordNotFound from a read into ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// conflictOrMissing tells why a versioned write matched no row: it returns
+// ErrNotFound if row id of model is gone and ErrConflict if its version moved
+func conflictOrMissing(tx *gorm.DB, model any, id uint) error {
+	var count int64
+	if err := tx.Model(model).Where("id = ?", id).Count(&count).Error; err != nil {
+		return retryableError{err}
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrConflict
+}
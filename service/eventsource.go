@@ -0,0 +1,159 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+var (
+	// ErrEventChainBroken is returned when the events of a balance skip a
+	// version or do not add up, so no snapshot can be rebuilt from them
+	ErrEventChainBroken = errors.New("balance event chain is broken")
+
+	// ErrUnrecordedWrites is returned when the balance row is at a later
+	// version than its last event, i.e. it was written without the
+	// event-sourced mode
+	ErrUnrecordedWrites = errors.New("balance has writes without events")
+)
+
+// WithEventSourcing appends a models.BalanceEvent for every integer balance
+// update in the same transaction as the write, which makes the balance row a
+// snapshot of its events. Status changes through the Updater and metadata
+// patches append an event without a delta, so the chain has no gaps. Rebuild checks and repairs the snapshot. Writes
+// made without this option leave no event and are reported by Rebuild.
+func WithEventSourcing() Option {
+	return func(u *Updater) {
+		u.eventSourced = true
+	}
+}
+
+// appendEvent records the change an update made
+func appendEvent(tx *gorm.DB, id uint, delta int64, outcome UpdateOutcome) error {
+	err := tx.Create(&models.BalanceEvent{
+		BalanceID: id,
+		Version:   outcome.Version,
+		Kind:      models.BalanceEventChange,
+		Delta:     delta,
+		Amount:    outcome.NewAmount,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("append balance event: %w", err)
+	}
+	return nil
+}
+
+// appendMarker records a write of balance that bumped its version without
+// changing the amount
+func appendMarker(tx *gorm.DB, balance models.Balance, kind string) error {
+	err := tx.Create(&models.BalanceEvent{
+		BalanceID: balance.ID,
+		Version:   balance.Version,
+		Kind:      kind,
+		Amount:    balance.Amount,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("append balance event: %w", err)
+	}
+	return nil
+}
+
+// RebuildResult compares a balance row with the state its events add up to
+type RebuildResult struct {
+	BalanceID uint
+	Events    int
+	Amount    int64 // amount after the last event
+	Version   int   // version of the last event
+	Snapshot  models.Balance
+	Repaired  bool // Rebuild reset the snapshot
+}
+
+// Consistent reports whether the snapshot matches the events
+func (r RebuildResult) Consistent() bool {
+	return r.Snapshot.Amount == r.Amount && r.Snapshot.Version == r.Version
+}
+
+// VerifySnapshot replays the events of balance id and compares the result
+// with the balance row without changing anything. The amount before the
+// first event is taken from that event, so the mode can be turned on for
+// existing balances.
+func VerifySnapshot(db *gorm.DB, id uint) (RebuildResult, error) {
+	var balance models.Balance
+	if err := db.First(&balance, id).Error; err != nil {
+		return RebuildResult{BalanceID: id}, err
+	}
+	result, err := replayEvents(db, balance)
+	if err == nil && result.Events > 0 && balance.Version > result.Version {
+		err = ErrUnrecordedWrites
+	}
+	return result, err
+}
+
+// Rebuild replays the events of balance id and, if the balance row no longer
+// matches them, resets it to the amount they add up to. The reset bumps the
+// version, so writers holding the old snapshot conflict, and is recorded as
+// a repair event. A row written without events is reported with
+// ErrUnrecordedWrites and left as it is, since replaying would lose those
+// writes.
+func Rebuild(db *gorm.DB, id uint) (RebuildResult, error) {
+	result := RebuildResult{BalanceID: id}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var balance models.Balance
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&balance, id).Error; err != nil {
+			return err
+		}
+		var err error
+		if result, err = replayEvents(tx, balance); err != nil {
+			return err
+		}
+		switch {
+		case result.Events == 0 || result.Consistent():
+			return nil
+		case balance.Version > result.Version:
+			return ErrUnrecordedWrites
+		}
+
+		version := result.Version + 1
+		update := tx.Model(&models.Balance{}).Where("id = ? AND version = ?", id, balance.Version).
+			Updates(map[string]any{"amount": result.Amount, "version": version})
+		if update.Error != nil {
+			return update.Error
+		}
+		if update.RowsAffected == 0 {
+			return ErrConflict
+		}
+		repair := models.BalanceEvent{BalanceID: id, Version: version, Kind: models.BalanceEventRepair, Amount: result.Amount}
+		if err := tx.Create(&repair).Error; err != nil {
+			return fmt.Errorf("append repair event: %w", err)
+		}
+		result.Version, result.Events, result.Repaired = version, result.Events+1, true
+		return nil
+	})
+	return result, err
+}
+
+// replayEvents folds the events of balance into a RebuildResult, checking
+// that every event follows the one before it
+func replayEvents(db *gorm.DB, balance models.Balance) (RebuildResult, error) {
+	result := RebuildResult{BalanceID: balance.ID, Snapshot: balance}
+	var events []models.BalanceEvent
+	if err := db.Where("balance_id = ?", balance.ID).Order("version").Find(&events).Error; err != nil {
+		return result, err
+	}
+	for i, e := range events {
+		if i == 0 {
+			result.Amount, result.Version = e.Amount-e.Delta, e.Version-1
+		}
+		if e.Version != result.Version+1 || e.Amount != result.Amount+e.Delta {
+			return result, fmt.Errorf("%w: balance %d event at version %d does not follow version %d (amount %d)",
+				ErrEventChainBroken, balance.ID, e.Version, result.Version, result.Amount)
+		}
+		result.Amount, result.Version = e.Amount, e.Version
+		result.Events++
+	}
+	return result, nil
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/latency"
+)
+
+// ErrInjectedFault is the transient database error a FaultInjector simulates
+var ErrInjectedFault = errors.New("injected fault: transient database error")
+
+// FaultConfig sets the probabilities (0..1) of the faults a FaultInjector
+// injects into each optimistic attempt
+type FaultConfig struct {
+	ErrorRate    float64              // Attempts failing with ErrInjectedFault, retried like a failed write
+	ConflictRate float64              // Attempts failing with a version conflict without writing
+	LatencyRate  float64              // Attempts delayed by a sample from Latency
+	Latency      latency.Distribution // Delay for LatencyRate of the attempts
+	Seed         int64                // Seed of the random source; zero seeds from the clock
+}
+
+// FaultStats counts the faults injected so far
+type FaultStats struct {
+	Attempts  int64 // Attempts that went through the injector
+	Errors    int64
+	Conflicts int64
+	Delays    int64
+}
+
+// FaultInjector makes optimistic attempts fail or slow down at random, to
+// test how callers cope with a flaky database without needing one. A delay
+// is applied before the attempt; an injected error or conflict replaces the
+// attempt, so nothing is written. The pessimistic fallback and the writes
+// outside the retry loop are not affected.
+type FaultInjector struct {
+	mu  sync.Mutex
+	cfg FaultConfig
+	rng *rand.Rand
+
+	attempts, errors, conflicts, delays atomic.Int64
+}
+
+// NewFaultInjector returns a FaultInjector injecting the faults of cfg
+func NewFaultInjector(cfg FaultConfig) *FaultInjector {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &FaultInjector{cfg: cfg, rng: rand.New(rand.NewSource(seed))}
+}
+
+// WithFaultInjector runs every optimistic attempt through f. Share one
+// injector between updaters to change their faults together with Set.
+func WithFaultInjector(f *FaultInjector) Option {
+	return func(u *Updater) {
+		u.faults = f
+	}
+}
+
+// Set replaces the fault probabilities, e.g. to end an outage mid-test. The
+// random source is kept.
+func (f *FaultInjector) Set(cfg FaultConfig) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cfg = cfg
+}
+
+// Stats returns the number of faults injected so far
+func (f *FaultInjector) Stats() FaultStats {
+	return FaultStats{
+		Attempts:  f.attempts.Load(),
+		Errors:    f.errors.Load(),
+		Conflicts: f.conflicts.Load(),
+		Delays:    f.delays.Load(),
+	}
+}
+
+// wrap returns attempt with the faults injected in front of it
+func (f *FaultInjector) wrap(attempt func(*UpdateOutcome) error) func(*UpdateOutcome) error {
+	return func(o *UpdateOutcome) error {
+		delay, err := f.draw()
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		if err != nil {
+			return err
+		}
+		return attempt(o)
+	}
+}
+
+// draw decides the faults of one attempt: a delay, and an error to return
+// instead of running it
+func (f *FaultInjector) draw() (time.Duration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts.Add(1)
+
+	var delay time.Duration
+	if f.cfg.Latency != nil && f.rng.Float64() < f.cfg.LatencyRate {
+		delay = f.cfg.Latency.Sample(f.rng)
+		f.delays.Add(1)
+	}
+
+	// One draw for both, so the rates add up rather than overlap
+	switch p := f.rng.Float64(); {
+	case p < f.cfg.ErrorRate:
+		f.errors.Add(1)
+		return delay, retryableError{ErrInjectedFault}
+	case p < f.cfg.ErrorRate+f.cfg.ConflictRate:
+		f.conflicts.Add(1)
+		return delay, ErrConflict
+	}
+	return delay, nil
+}
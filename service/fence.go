@@ -0,0 +1,40 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// ErrStaleFence is returned when a fencing token no longer matches the balance version
+var ErrStaleFence = errors.New("stale fencing token: balance changed since it was observed")
+
+// FenceToken is the balance version observed by a reader. The version only
+// ever increases, so an external system that performs side effects (e.g.
+// dispensing goods) can hand the token back and reject the action if the
+// balance has moved on since the observation it was based on.
+type FenceToken int
+
+// ReadFence returns the current fencing token for a balance
+func ReadFence(db *gorm.DB, id uint) (FenceToken, error) {
+	var balance models.Balance
+	if err := db.Select("version").First(&balance, id).Error; err != nil {
+		return 0, err
+	}
+	return FenceToken(balance.Version), nil
+}
+
+// VerifyFence returns ErrStaleFence unless token is the current version of the balance
+func VerifyFence(db *gorm.DB, id uint, token FenceToken) error {
+	current, err := ReadFence(db, id)
+	if err != nil {
+		return err
+	}
+	if current != token {
+		return fmt.Errorf("%w (token %d, current %d)", ErrStaleFence, token, current)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// ForceSet sets the amount of a balance whatever its version, using the
+// default Updater overridden by opts
+func ForceSet(db *gorm.DB, id uint, amount int64, reason string, opts ...Option) (models.Balance, error) {
+	return NewUpdater(db, opts...).ForceSet(id, amount, reason)
+}
+
+// ForceSet is the escape hatch for support teams fixing a corrupted balance,
+// instead of raw SQL: it sets the amount without an expected version, under a
+// row lock, and bumps the version so every writer holding the old one
+// conflicts. Status, hooks, policies and limits are skipped, so it works on
+// frozen balances too. A models.BalanceOverride records the previous and new
+// amount and version, the reason, which is required, and the actor set with
+// As. The change is published like an update, as a delta from the previous
+// amount. It returns the balance as written. On drivers that ignore row
+// locks, such as SQLite, a write in between fails it with ErrConflict; try
+// again.
+func (u *Updater) ForceSet(id uint, amount int64, reason string) (models.Balance, error) {
+	return u.forceSet(id, amount, reason, -1, 0)
+}
+
+// ForceSetIfVersion is ForceSet for repairs computed from a read of the
+// balance, such as those of the version audit: it returns ErrConflict unless
+// the balance is still at version. The version moves to toVersion when that
+// is past version+1, so writers holding a version the balance had before a
+// reset conflict too.
+func (u *Updater) ForceSetIfVersion(id uint, version, toVersion int, amount int64, reason string) (models.Balance, error) {
+	return u.forceSet(id, amount, reason, version, toVersion)
+}
+
+// forceSet is ForceSet, requiring the balance to be at expect unless it is
+// negative and raising the version to toVersion if that is later
+func (u *Updater) forceSet(id uint, amount int64, reason string, expect, toVersion int) (models.Balance, error) {
+	if strings.TrimSpace(reason) == "" {
+		return models.Balance{}, errors.New("force set reason is required")
+	}
+
+	var outcome UpdateOutcome
+	var written models.Balance
+	err := u.db.Transaction(func(tx *gorm.DB) error {
+		var balance models.Balance
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&balance, id).Error; err != nil {
+			return notFound(err)
+		}
+		if expect >= 0 && balance.Version != expect {
+			return ErrConflict
+		}
+		if err := u.authorizeBalance(u.context(), balance, WriteOp{Kind: WriteForceSet, Delta: amount - balance.Amount, Amount: amount}); err != nil {
+			return err
+		}
+		version := max(balance.Version+1, toVersion)
+		result := tx.Model(&models.Balance{}).Where("id = ? AND version = ?", id, balance.Version).
+			Updates(map[string]any{"amount": amount, "version": version})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrConflict
+		}
+
+		// The balance is locked, so every adjustment already applied to it
+		// is committed and every later one gets a higher ID
+		var last uint
+		if err := tx.Model(&models.Adjustment{}).Where("balance_id = ?", id).Select("COALESCE(MAX(id), 0)").Scan(&last).Error; err != nil {
+			return err
+		}
+		override := models.BalanceOverride{
+			BalanceID:       id,
+			Actor:           u.actor,
+			Reason:          reason,
+			PreviousAmount:  balance.Amount,
+			Amount:          amount,
+			PreviousVersion: balance.Version,
+			Version:         version,
+			AdjustmentID:    last,
+		}
+		if err := tx.Create(&override).Error; err != nil {
+			return fmt.Errorf("record override: %w", err)
+		}
+		outcome = UpdateOutcome{Attempts: 1, PreviousAmount: balance.Amount, NewAmount: amount, Version: version}
+		written = balance
+		written.Amount, written.Version = amount, version
+		return u.publish(tx, id, amount-balance.Amount, outcome)
+	})
+	u.cacheWrite(id, outcome, err)
+	if err != nil {
+		return models.Balance{}, err
+	}
+	return written, nil
+}
+
+// ListOverrides returns the overrides of balance id, oldest first
+func ListOverrides(db *gorm.DB, id uint) ([]models.BalanceOverride, error) {
+	var overrides []models.BalanceOverride
+	err := db.Where("balance_id = ?", id).Order("id").Find(&overrides).Error
+	return overrides, err
+}
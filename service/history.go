@@ -0,0 +1,103 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// TimeRange selects changes made in [Since, Until). A zero bound is open.
+type TimeRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+// HistoryEntry is one change of a balance
+type HistoryEntry struct {
+	Version   int
+	Kind      string // models.BalanceEventChange or models.BalanceEventRepair
+	Delta     int64
+	Amount    int64 // running balance after the change
+	CreatedAt time.Time
+}
+
+// HistoryPage is one page of the history of a balance
+type HistoryPage struct {
+	Entries []HistoryEntry
+	Next    string // cursor of the next page; empty on the last page
+}
+
+// GetHistory returns the changes of balance id within r, oldest first, with
+// the running balance after each, e.g. for statements. The history is read
+// from the balance events, so it covers the writes made WithEventSourcing
+// only. Pages are keyset-paginated by version like ListBalances; the Order
+// of page must be empty. A missing balance, or one of another tenant,
+// returns ErrNotFound.
+func GetHistory(db *gorm.DB, id uint, r TimeRange, page Pagination) (HistoryPage, error) {
+	if page.Order != "" {
+		return HistoryPage{}, fmt.Errorf("history is ordered by version; order %q is not supported", page.Order)
+	}
+	limit := page.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	limit = min(limit, MaxPageLimit)
+
+	// Events have no tenant of their own: check the balance is visible first
+	var balance models.Balance
+	if err := db.Select("id").First(&balance, id).Error; err != nil {
+		return HistoryPage{}, notFound(err)
+	}
+
+	query := db.Model(&models.BalanceEvent{}).Where("balance_id = ?", id)
+	if !r.Since.IsZero() {
+		query = query.Where("created_at >= ?", r.Since)
+	}
+	if !r.Until.IsZero() {
+		query = query.Where("created_at < ?", r.Until)
+	}
+	if page.Cursor != "" {
+		after, err := decodeHistoryCursor(page.Cursor)
+		if err != nil {
+			return HistoryPage{}, err
+		}
+		query = query.Where("version > ?", after)
+	}
+
+	var events []models.BalanceEvent
+	if err := query.Order("version").Limit(limit + 1).Find(&events).Error; err != nil {
+		return HistoryPage{}, err
+	}
+	var result HistoryPage
+	if len(events) > limit {
+		events = events[:limit]
+		result.Next = encodeHistoryCursor(events[limit-1].Version)
+	}
+	result.Entries = make([]HistoryEntry, len(events))
+	for i, e := range events {
+		result.Entries[i] = HistoryEntry{Version: e.Version, Kind: e.Kind, Delta: e.Delta, Amount: e.Amount, CreatedAt: e.CreatedAt}
+	}
+	return result, nil
+}
+
+// encodeHistoryCursor makes an opaque cursor after version
+func encodeHistoryCursor(version int) string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "version:%d", version))
+}
+
+// decodeHistoryCursor parses a cursor of encodeHistoryCursor
+func decodeHistoryCursor(s string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	var version int
+	if _, err := fmt.Sscanf(string(raw), "version:%d", &version); err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return version, nil
+}
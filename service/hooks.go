@@ -0,0 +1,71 @@
+package service
+
+// Attempt identifies one optimistic attempt for the hooks
+type Attempt struct {
+	BalanceID uint
+	Delta     int64
+	Number    int // 1-based attempt number
+}
+
+// Hooks are callbacks around the optimistic retry loop, for logging, metrics
+// or chaos injection. Every field is optional. Hooks run on the updating
+// goroutine, so they should not block for long.
+type Hooks struct {
+	// BeforeAttempt runs before the row is read. A non-nil error aborts the
+	// update with that error.
+	BeforeAttempt func(a Attempt) error
+	// AfterAttempt runs after the write with its result: nil on success,
+	// ErrConflict on a version conflict, or the database error.
+	AfterAttempt func(a Attempt, err error)
+	// OnConflict runs after a version conflict, before backing off. A non-nil
+	// error stops retrying and is returned to the caller.
+	OnConflict func(a Attempt) error
+	// OnExhausted runs when the update gives up with ErrRetryExhausted
+	OnExhausted func(id uint, outcome UpdateOutcome)
+}
+
+// WithHooks adds hooks to the updater. It may be given several times; hooks
+// run in the order they were added and the first error aborts the update.
+func WithHooks(h Hooks) Option {
+	return func(u *Updater) {
+		u.hooks = append(u.hooks, h)
+	}
+}
+
+func (u *Updater) beforeAttempt(a Attempt) error {
+	for _, h := range u.hooks {
+		if h.BeforeAttempt != nil {
+			if err := h.BeforeAttempt(a); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (u *Updater) afterAttempt(a Attempt, err error) {
+	for _, h := range u.hooks {
+		if h.AfterAttempt != nil {
+			h.AfterAttempt(a, err)
+		}
+	}
+}
+
+func (u *Updater) onConflict(a Attempt) error {
+	for _, h := range u.hooks {
+		if h.OnConflict != nil {
+			if err := h.OnConflict(a); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (u *Updater) onExhausted(id uint, outcome UpdateOutcome) {
+	for _, h := range u.hooks {
+		if h.OnExhausted != nil {
+			h.OnExhausted(id, outcome)
+		}
+	}
+}
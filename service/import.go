@@ -0,0 +1,183 @@
+package service
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// importBatchSize is how many seeds ImportBalances writes per transaction
+const importBatchSize = 1000
+
+// BalanceSeed is one balance to import. A seed is matched with an existing
+// balance by ExternalRef if set, else by OwnerID and Currency; a seed with
+// neither always creates a balance.
+type BalanceSeed struct {
+	OwnerID     string
+	Currency    string
+	ExternalRef string
+	Amount      int64
+	Version     int // version the balance has after the import, e.g. the one of the legacy system
+}
+
+// ImportResult counts what ImportBalances did with the seeds
+type ImportResult struct {
+	Inserted int
+	Updated  int
+	Skipped  int // the balance already was at the seed's version or newer
+}
+
+// ImportBalances loads seeds for initial data loads and migrations from
+// legacy systems. New balances are created with batched inserts at the seed
+// version. An existing balance is overwritten only if its version is below
+// the seed's, with the same compare-and-swap as an update, so running an
+// import again is harmless and never undoes writes made after it. Each batch
+// of seeds is written in one transaction; on an error the result counts the
+// batches written before it. A deleted balance is not revived. Imports
+// bypass the Updater: no hooks, events or audit records are produced.
+func ImportBalances(db *gorm.DB, seeds []BalanceSeed) (ImportResult, error) {
+	var result ImportResult
+	for start := 0; start < len(seeds); start += importBatchSize {
+		batch := seeds[start:min(start+importBatchSize, len(seeds))]
+		var r ImportResult
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			r, err = importBatch(tx, batch)
+			return err
+		})
+		if err != nil {
+			return result, fmt.Errorf("importing seeds %d to %d: %w", start, start+len(batch)-1, err)
+		}
+		result.Inserted += r.Inserted
+		result.Updated += r.Updated
+		result.Skipped += r.Skipped
+	}
+	return result, nil
+}
+
+// importBatch writes one batch of seeds in transaction tx
+func importBatch(tx *gorm.DB, seeds []BalanceSeed) (ImportResult, error) {
+	existing, err := existingBalances(tx, seeds)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	var result ImportResult
+	var inserts []models.Balance
+	for i, seed := range seeds {
+		if seed.Version < 0 {
+			return ImportResult{}, fmt.Errorf("seed %d: negative version %d", i, seed.Version)
+		}
+		current, ok := existing.find(seed)
+		if !ok {
+			inserts = append(inserts, seed.balance())
+			continue
+		}
+		if current.Version >= seed.Version {
+			result.Skipped++
+			continue
+		}
+		updated := tx.Model(&models.Balance{}).
+			Where("id = ? AND version = ?", current.ID, current.Version).
+			Updates(map[string]any{"amount": seed.Amount, "version": seed.Version})
+		if updated.Error != nil {
+			return ImportResult{}, updated.Error
+		}
+		if updated.RowsAffected == 0 {
+			// Written since it was read: the live balance wins
+			result.Skipped++
+			continue
+		}
+		result.Updated++
+	}
+
+	if len(inserts) > 0 {
+		// A balance created concurrently for the same key is left as it is
+		created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&inserts)
+		if created.Error != nil {
+			return ImportResult{}, created.Error
+		}
+		result.Inserted += int(created.RowsAffected)
+		result.Skipped += len(inserts) - int(created.RowsAffected)
+	}
+	return result, nil
+}
+
+// balance returns the row a seed creates
+func (s BalanceSeed) balance() models.Balance {
+	b := models.Balance{Currency: s.Currency, Amount: s.Amount, Version: s.Version}
+	if s.OwnerID != "" {
+		b.OwnerID = &s.OwnerID
+	}
+	if s.ExternalRef != "" {
+		b.ExternalRef = &s.ExternalRef
+	}
+	return b
+}
+
+// seedMatches indexes the existing balances of a batch by their keys
+type seedMatches struct {
+	byRef   map[string]models.Balance
+	byOwner map[[2]string]models.Balance
+}
+
+// find returns the existing balance seed would overwrite
+func (m seedMatches) find(seed BalanceSeed) (models.Balance, bool) {
+	if seed.ExternalRef != "" {
+		b, ok := m.byRef[seed.ExternalRef]
+		return b, ok
+	}
+	if seed.OwnerID != "" {
+		b, ok := m.byOwner[[2]string{seed.OwnerID, seed.Currency}]
+		return b, ok
+	}
+	return models.Balance{}, false
+}
+
+// existingBalances reads the balances the seeds may match in two queries
+func existingBalances(tx *gorm.DB, seeds []BalanceSeed) (seedMatches, error) {
+	m := seedMatches{byRef: map[string]models.Balance{}, byOwner: map[[2]string]models.Balance{}}
+	var refs, owners []string
+	for _, s := range seeds {
+		switch {
+		case s.ExternalRef != "":
+			if _, dup := m.byRef[s.ExternalRef]; dup {
+				return m, fmt.Errorf("external reference %q appears twice in one batch", s.ExternalRef)
+			}
+			m.byRef[s.ExternalRef] = models.Balance{}
+			refs = append(refs, s.ExternalRef)
+		case s.OwnerID != "":
+			key := [2]string{s.OwnerID, s.Currency}
+			if _, dup := m.byOwner[key]; dup {
+				return m, fmt.Errorf("owner %q appears twice in one batch for currency %q", s.OwnerID, s.Currency)
+			}
+			m.byOwner[key] = models.Balance{}
+			owners = append(owners, s.OwnerID)
+		}
+	}
+	clear(m.byRef)
+	clear(m.byOwner)
+
+	var balances []models.Balance
+	if len(refs) > 0 {
+		if err := tx.Where("external_ref IN ?", refs).Find(&balances).Error; err != nil {
+			return m, err
+		}
+		for _, b := range balances {
+			m.byRef[*b.ExternalRef] = b
+		}
+	}
+	if len(owners) > 0 {
+		balances = nil
+		if err := tx.Where("owner_id IN ?", owners).Find(&balances).Error; err != nil {
+			return m, err
+		}
+		for _, b := range balances {
+			m.byOwner[[2]string{*b.OwnerID, b.Currency}] = b
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// GetBalanceByKey returns the balance of ownerID in currency with its
+// current version, like GetBalance
+func GetBalanceByKey(db *gorm.DB, ownerID, currency string) (models.Balance, error) {
+	var balance models.Balance
+	if err := db.Where("owner_id = ? AND currency = ?", ownerID, currency).First(&balance).Error; err != nil {
+		return models.Balance{}, err
+	}
+	return balance, nil
+}
+
+// GetBalanceByRef returns the balance with external reference ref, like GetBalance
+func GetBalanceByRef(db *gorm.DB, ref string) (models.Balance, error) {
+	var balance models.Balance
+	if err := db.Where("external_ref = ?", ref).First(&balance).Error; err != nil {
+		return models.Balance{}, err
+	}
+	return balance, nil
+}
+
+// GetBalanceByUID returns the balance with UID uid, like GetBalance
+func GetBalanceByUID(db *gorm.DB, uid string) (models.Balance, error) {
+	var balance models.Balance
+	if err := db.Where("uid = ?", uid).First(&balance).Error; err != nil {
+		return models.Balance{}, err
+	}
+	return balance, nil
+}
+
+// SetExternalRef sets the external reference of balance id, by which
+// UpdateBalanceByRef finds it. References are unique per tenant, so the
+// unique index rejects one already in use. The version is not bumped:
+// the reference is not part of the balance's state.
+func SetExternalRef(db *gorm.DB, id uint, ref string) error {
+	result := db.Model(&models.Balance{ID: id}).UpdateColumn("external_ref", ref)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UpdateBalanceByKey adds delta to the balance of ownerID in currency, like
+// UpdateBalance
+func UpdateBalanceByKey(db *gorm.DB, ownerID, currency string, delta int64, opts ...Option) (UpdateOutcome, error) {
+	return NewUpdater(db, opts...).UpdateBalanceByKey(ownerID, currency, delta)
+}
+
+// UpdateBalanceByRef adds delta to the balance with external reference ref,
+// like UpdateBalance
+func UpdateBalanceByRef(db *gorm.DB, ref string, delta int64, opts ...Option) (UpdateOutcome, error) {
+	return NewUpdater(db, opts...).UpdateBalanceByRef(ref, delta)
+}
+
+// UpdateBalanceByUID adds delta to the balance with UID uid, like UpdateBalance
+func UpdateBalanceByUID(db *gorm.DB, uid string, delta int64, opts ...Option) (UpdateOutcome, error) {
+	return NewUpdater(db, opts...).UpdateBalanceByUID(uid, delta)
+}
+
+// UpdateBalanceByKey resolves the balance of ownerID in currency to its ID
+// once and updates it with UpdateBalance, so the version check, retries and
+// options apply unchanged. The owner and currency of a balance never change,
+// so the ID stays right across retries. An unknown key returns ErrNotFound.
+func (u *Updater) UpdateBalanceByKey(ownerID, currency string, delta int64) (UpdateOutcome, error) {
+	id, err := u.resolve("owner_id = ? AND currency = ?", ownerID, currency)
+	if err != nil {
+		return UpdateOutcome{}, err
+	}
+	return u.UpdateBalance(id, delta)
+}
+
+// UpdateBalanceByRef is UpdateBalanceByKey for an external reference. A
+// reference moved to another balance while the update runs may still update
+// the balance it named when resolved.
+func (u *Updater) UpdateBalanceByRef(ref string, delta int64) (UpdateOutcome, error) {
+	id, err := u.resolve("external_ref = ?", ref)
+	if err != nil {
+		return UpdateOutcome{}, err
+	}
+	return u.UpdateBalance(id, delta)
+}
+
+// UpdateBalanceByUID is UpdateBalanceByKey for the UID of the balance, which
+// never changes
+func (u *Updater) UpdateBalanceByUID(uid string, delta int64) (UpdateOutcome, error) {
+	id, err := u.resolve("uid = ?", uid)
+	if err != nil {
+		return UpdateOutcome{}, err
+	}
+	return u.UpdateBalance(id, delta)
+}
+
+// resolve returns the ID of the balance matching a unique key
+func (u *Updater) resolve(query string, args ...any) (uint, error) {
+	if u.db == nil {
+		return 0, errors.New("balance keys need a database; BalanceStore only addresses balances by ID")
+	}
+	var balance models.Balance
+	if err := u.db.Select("id").Where(query, args...).First(&balance).Error; err != nil {
+		return 0, notFound(err)
+	}
+	return balance.ID, nil
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// ErrInvalidCursor is returned by ListBalances for a cursor it did not issue
+// for the same order
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// Page limits of ListBalances
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+// ListOrder is the order ListBalances returns balances in
+type ListOrder string
+
+const (
+	ByID     ListOrder = "id"     // ascending ID, the default
+	ByAmount ListOrder = "amount" // ascending amount, ties by ID
+)
+
+// BalanceFilter selects the balances ListBalances returns. Zero fields match
+// every balance.
+type BalanceFilter struct {
+	OwnerID   string
+	Currency  string
+	MinAmount *int64 // inclusive
+	MaxAmount *int64 // inclusive
+}
+
+// Pagination asks ListBalances for one page
+type Pagination struct {
+	Order  ListOrder
+	Cursor string // BalancePage.Next of the previous page; empty for the first
+	Limit  int    // DefaultPageLimit if zero, at most MaxPageLimit
+}
+
+// BalancePage is one page of balances with their versions, which can be
+// handed to UpdateIfVersion for a conditional write
+type BalancePage struct {
+	Balances []models.Balance
+	Next     string // cursor of the next page; empty on the last page
+}
+
+// ListBalances returns a page of the balances matching filter. Pages are
+// keyset-paginated: the cursor holds the sort key of the last balance
+// returned instead of an offset, so balances created or deleted while
+// paging neither shift nor repeat the rows of later pages, and a ByID page
+// is a primary key range scan however deep it is. Amount is deliberately not
+// indexed, as every update would have to maintain the index, so a ByAmount
+// page sorts the matching balances; narrow it with a filter on large
+// tables. A balance whose amount changes between pages of a ByAmount
+// listing may be seen twice or not at all.
+func ListBalances(db *gorm.DB, filter BalanceFilter, page Pagination) (BalancePage, error) {
+	if page.Order == "" {
+		page.Order = ByID
+	}
+	if page.Order != ByID && page.Order != ByAmount {
+		return BalancePage{}, fmt.Errorf("unknown order %q", page.Order)
+	}
+	limit := page.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	limit = min(limit, MaxPageLimit)
+
+	query := db.Model(&models.Balance{})
+	if filter.OwnerID != "" {
+		query = query.Where("owner_id = ?", filter.OwnerID)
+	}
+	if filter.Currency != "" {
+		query = query.Where("currency = ?", filter.Currency)
+	}
+	if filter.MinAmount != nil {
+		query = query.Where("amount >= ?", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		query = query.Where("amount <= ?", *filter.MaxAmount)
+	}
+
+	if page.Cursor != "" {
+		after, err := decodeCursor(page.Cursor, page.Order)
+		if err != nil {
+			return BalancePage{}, err
+		}
+		if page.Order == ByAmount {
+			query = query.Where("amount > ? OR (amount = ? AND id > ?)", after.amount, after.amount, after.id)
+		} else {
+			query = query.Where("id > ?", after.id)
+		}
+	}
+	if page.Order == ByAmount {
+		query = query.Order("amount").Order("id")
+	} else {
+		query = query.Order("id")
+	}
+
+	var balances []models.Balance
+	if err := query.Limit(limit + 1).Find(&balances).Error; err != nil {
+		return BalancePage{}, err
+	}
+	result := BalancePage{Balances: balances}
+	if len(balances) > limit {
+		result.Balances = balances[:limit]
+		last := balances[limit-1]
+		result.Next = encodeCursor(page.Order, cursor{id: last.ID, amount: last.Amount})
+	}
+	return result, nil
+}
+
+// cursor is the sort key of the last balance of a page
+type cursor struct {
+	id     uint
+	amount int64
+}
+
+// encodeCursor makes an opaque cursor naming the order it belongs to
+func encodeCursor(order ListOrder, c cursor) string {
+	var raw string
+	if order == ByAmount {
+		raw = fmt.Sprintf("amount:%d:%d", c.amount, c.id)
+	} else {
+		raw = fmt.Sprintf("id:%d", c.id)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor parses a cursor of encodeCursor for order
+func decodeCursor(s string, order ListOrder) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	var c cursor
+	if order == ByAmount {
+		_, err = fmt.Sscanf(string(raw), "amount:%d:%d", &c.amount, &c.id)
+	} else {
+		_, err = fmt.Sscanf(string(raw), "id:%d", &c.id)
+	}
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
@@ -0,0 +1,14 @@
+package service
+
+import "github.com/ghozilaaa/optimistic-lock/logging"
+
+// WithLogger logs the retry loop on l: conflicts at debug, failed writes at
+// warn, pessimistic fallbacks at info and exhausted retries at warn, each with
+// the balance ID and attempt fields. Without it nothing is logged.
+func WithLogger(l logging.Logger) Option {
+	return func(u *Updater) {
+		if l != nil {
+			u.logger = l
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// MemoryStore is a BalanceStore in a map, for unit-testing code that updates
+// balances without a database. It can simulate a concurrent writer: a
+// simulated conflict bumps the version of the balance just before the
+// compare-and-swap, so the swap fails the way it would against a contended
+// row. It is safe for concurrent use.
+type MemoryStore struct {
+	mu           sync.Mutex
+	balances     map[uint]models.Balance
+	nextID       uint
+	rng          *rand.Rand
+	conflictRate float64
+	forced       map[uint]int
+	simulated    int
+}
+
+// NewMemoryStore returns an empty store without simulated conflicts
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		balances: make(map[uint]models.Balance),
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		forced:   make(map[uint]int),
+	}
+}
+
+// Create inserts a new balance and returns it with its assigned ID
+func (m *MemoryStore) Create(amount int64) (models.Balance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	balance := models.Balance{ID: m.nextID, Amount: amount}
+	m.balances[balance.ID] = balance
+	return balance, nil
+}
+
+// Get returns the balance, or ErrNotFound
+func (m *MemoryStore) Get(id uint) (models.Balance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	balance, ok := m.balances[id]
+	if !ok {
+		return models.Balance{}, ErrNotFound
+	}
+	return balance, nil
+}
+
+// CompareAndSwap stores amount and bumps the version if the balance is
+// still at expectedVersion, unless a conflict is simulated
+func (m *MemoryStore) CompareAndSwap(id uint, expectedVersion int, amount int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	balance, ok := m.balances[id]
+	if !ok {
+		return false, ErrNotFound
+	}
+
+	if m.simulateConflict(id) {
+		m.simulated++
+		balance.Version++
+		m.balances[id] = balance
+	}
+	if balance.Version != expectedVersion {
+		return false, nil
+	}
+	balance.Amount = amount
+	balance.Version++
+	m.balances[id] = balance
+	return true, nil
+}
+
+// Delete removes the balance, like a concurrent delete between read and write
+func (m *MemoryStore) Delete(id uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.balances, id)
+}
+
+// SetConflictRate makes a share rate (0..1) of the swaps conflict at random
+func (m *MemoryStore) SetConflictRate(rate float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conflictRate = rate
+}
+
+// ConflictNext makes the next n swaps of balance id conflict, for tests that
+// need an exact number of retries
+func (m *MemoryStore) ConflictNext(id uint, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.forced[id] += n
+}
+
+// SimulatedConflicts returns the number of conflicts simulated so far
+func (m *MemoryStore) SimulatedConflicts() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.simulated
+}
+
+func (m *MemoryStore) simulateConflict(id uint) bool {
+	if m.forced[id] > 0 {
+		m.forced[id]--
+		return true
+	}
+	return m.conflictRate > 0 && m.rng.Float64() < m.conflictRate
+}
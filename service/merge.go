@@ -0,0 +1,151 @@
+package service
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// ModifyFunc computes the new amount of a balance from the balance as read.
+// Its error is returned as is and ends the update.
+type ModifyFunc func(balance models.Balance) (int64, error)
+
+// Merge resolves a conflicting write of Modify without reading the balance
+// again or calling its ModifyFunc: amount was computed from read, but the row
+// is now fresh. It returns the amount to write over fresh instead, or an error
+// to give up; ErrConflict falls back to reading again.
+type Merge func(read, fresh models.Balance, amount int64) (int64, error)
+
+// ReapplyDelta is the Merge of commutative updates, such as credits and
+// debits: it applies the change from read to amount on top of fresh
+func ReapplyDelta(read, fresh models.Balance, amount int64) (int64, error) {
+	return fresh.Amount + amount - read.Amount, nil
+}
+
+// LastWriterWins is the Merge that writes amount whatever the other writers
+// did, for updates that set the balance rather than change it
+func LastWriterWins(read, fresh models.Balance, amount int64) (int64, error) {
+	return amount, nil
+}
+
+// WithMerge resolves the conflicts of Modify with m, so a retry writes over
+// the row the conflicting write found instead of reading the balance again and
+// calling the ModifyFunc once more. The merged write is still guarded by the
+// version, backs off and counts as an attempt; UpdateOutcome.Merged counts
+// them. UpdateBalance needs none: a delta is reapplied to the fresh amount.
+func WithMerge(m Merge) Option {
+	return func(u *Updater) {
+		u.merge = m
+	}
+}
+
+// Modify sets the amount of balance id to what fn computes from it, using the
+// default Updater overridden by opts
+func Modify(db *gorm.DB, id uint, fn ModifyFunc, opts ...Option) (UpdateOutcome, error) {
+	return NewUpdater(db, opts...).Modify(id, fn)
+}
+
+// Modify is a read-modify-write: it reads balance id, sets its amount to what
+// fn computes from it and bumps the version, guarded by the version read. On a
+// conflict it reads the balance again and calls fn again, unless WithMerge
+// resolves it. The write is published, limited and checked by policies like an
+// update by the difference; Modify always writes through the database of the
+// Updater, not WithStore.
+func (u *Updater) Modify(id uint, fn ModifyFunc) (UpdateOutcome, error) {
+	start := time.Now()
+	var outcome UpdateOutcome
+	err := u.guard(id, func() error {
+		var (
+			read   models.Balance
+			fresh  *models.Balance // the row a conflicting write found
+			amount int64
+		)
+		attempt := u.timed(func(u *Updater, o *UpdateOutcome) error {
+			merged := false
+			if fresh != nil && u.merge != nil {
+				next, err := u.merge(read, *fresh, amount)
+				switch {
+				case err == nil:
+					read, amount, merged = *fresh, next, true
+					o.Merged++
+				case err != ErrConflict:
+					return err
+				}
+			}
+			fresh = nil
+			if !merged {
+				read = models.Balance{}
+				if err := u.db.First(&read, id).Error; err != nil {
+					return notFound(err)
+				}
+				if err := checkActive(read); err != nil {
+					return err
+				}
+				var err error
+				if amount, err = fn(read); err != nil {
+					return err
+				}
+			}
+
+			delta := amount - read.Amount
+			if err := u.authorizeBalance(u.context(), read, WriteOp{Kind: WriteUpdate, Delta: delta}); err != nil {
+				return err
+			}
+			var latest models.Balance
+			err := u.commit(func(tx *gorm.DB) error {
+				return writeModified(tx, read, delta, &latest, o)
+			}, id, delta, o)
+			if err == ErrConflict {
+				fresh = &latest
+				u.auditConflict(id, read.Version, latest.Version, delta, o.Attempts)
+			}
+			return err
+		})
+		locked := u.timed(func(u *Updater, o *UpdateOutcome) error {
+			return u.modifyLocked(id, func(balance models.Balance) (int64, error) {
+				amount, err := fn(balance)
+				if err != nil {
+					return 0, err
+				}
+				delta := amount - balance.Amount
+				return delta, u.authorizeBalance(u.context(), balance, WriteOp{Kind: WriteUpdate, Delta: delta})
+			}, o)
+		})
+
+		var err error
+		outcome, err = u.retry(Attempt{BalanceID: id}, attempt, locked)
+		return err
+	})
+	u.record(outcome, err, start)
+	u.cacheWrite(id, outcome, err)
+	return outcome, err
+}
+
+// writeModified is writeVersioned that, when the version moved, reads the row
+// the write found into latest for a Merge
+func writeModified(tx *gorm.DB, balance models.Balance, delta int64, latest *models.Balance, outcome *UpdateOutcome) error {
+	result := tx.Model(&models.Balance{}).Where("id = ? AND version = ? AND status = ?", balance.ID, balance.Version, models.BalanceActive).
+		Updates(map[string]any{"amount": balance.Amount + delta, "version": balance.Version + 1})
+	if result.Error != nil {
+		return retryableError{result.Error}
+	}
+	if result.RowsAffected == 0 {
+		if err := tx.First(latest, balance.ID).Error; err != nil {
+			if err = notFound(err); err == ErrNotFound {
+				return err
+			}
+			return retryableError{err}
+		}
+		if err := checkActive(*latest); err != nil {
+			return err
+		}
+		return ErrConflict
+	}
+
+	outcome.PreviousAmount = balance.Amount
+	outcome.NewAmount = balance.Amount + delta
+	outcome.Version = balance.Version + 1
+	return nil
+}
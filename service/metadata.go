@@ -0,0 +1,189 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// MetadataConflictError is returned when a metadata patch was written against
+// a version that is no longer current. Keys are the paths, dot-separated, that
+// both the patch and the writes since that version changed; without
+// WithMetadataMerge it may be empty. It wraps ErrConflict.
+type MetadataConflictError struct {
+	BalanceID uint
+	Keys      []string
+}
+
+func (e *MetadataConflictError) Error() string {
+	if len(e.Keys) == 0 {
+		return fmt.Sprintf("balance %d: metadata changed since it was read", e.BalanceID)
+	}
+	return fmt.Sprintf("balance %d: metadata keys %s changed since they were read", e.BalanceID, strings.Join(e.Keys, ", "))
+}
+
+func (e *MetadataConflictError) Unwrap() error { return ErrConflict }
+
+// WithMetadataMerge lets PatchMetadata apply a patch whose version is stale
+// when the writes since then changed none of the keys it patches: the patch is
+// applied again to the current metadata, under the current version
+func WithMetadataMerge() Option {
+	return func(u *Updater) {
+		u.metadataMerge = true
+	}
+}
+
+// PatchMetadata applies a merge patch to the metadata of base, using the
+// default Updater overridden by opts
+func PatchMetadata(db *gorm.DB, base models.Balance, patch map[string]any, opts ...Option) (models.Balance, error) {
+	return NewUpdater(db, opts...).PatchMetadata(base, patch)
+}
+
+// PatchMetadata applies patch to the metadata of base as a JSON merge patch
+// (RFC 7386): null removes a key and objects merge key by key. The write is
+// guarded by the version of base and bumps it, like an update of the amount,
+// which it leaves alone. If the balance moved on since base was read, the patch
+// fails with a *MetadataConflictError, unless WithMetadataMerge is set and the
+// other writes touched none of the patched keys. Merged retries back off and
+// count towards the attempts of the Updater. Frozen and closed balances can be
+// patched.
+func (u *Updater) PatchMetadata(base models.Balance, patch map[string]any) (models.Balance, error) {
+	id := base.ID
+	if err := u.authorize(id, WriteOp{Kind: WriteMetadata}); err != nil {
+		return models.Balance{}, err
+	}
+
+	current := base
+	attempt := func(outcome *UpdateOutcome) error {
+		if outcome.Attempts > 1 {
+			var err error
+			if current, err = GetBalance(u.db, id); err != nil {
+				return notFound(err)
+			}
+			if current.Version != base.Version {
+				keys := conflictingKeys(base.Metadata, current.Metadata, patch)
+				if !u.metadataMerge || len(keys) > 0 {
+					return &MetadataConflictError{BalanceID: id, Keys: keys}
+				}
+			}
+		}
+
+		metadata := models.Metadata(MergePatch(current.Metadata, patch))
+		var result *gorm.DB
+		write := func(tx *gorm.DB) error {
+			result = tx.Model(&models.Balance{}).Where("id = ? AND version = ?", id, current.Version).
+				Updates(map[string]any{"metadata": metadata, "version": current.Version + 1})
+			if result.Error != nil || result.RowsAffected == 0 || !u.eventSourced {
+				return result.Error
+			}
+			var written models.Balance
+			if err := tx.Select("id", "amount", "version").First(&written, id).Error; err != nil {
+				return err
+			}
+			return appendMarker(tx, written, models.BalanceEventMetadata)
+		}
+		var err error
+		if u.eventSourced {
+			err = u.db.Transaction(write)
+		} else {
+			err = write(u.db)
+		}
+		if err != nil {
+			return retryableError{err}
+		}
+		if result.RowsAffected == 0 {
+			if err := u.db.Select("id").First(&models.Balance{}, id).Error; err != nil {
+				return notFound(err)
+			}
+			return ErrConflict
+		}
+		current.Metadata = metadata
+		current.Version++
+		outcome.Version = current.Version
+		return nil
+	}
+	_, err := u.retry(Attempt{BalanceID: id}, attempt, attempt)
+	if err != nil {
+		return models.Balance{}, err
+	}
+	return current, nil
+}
+
+// MergePatch returns doc with the JSON merge patch applied (RFC 7386). doc is
+// not modified; the result shares the values patch leaves alone.
+func MergePatch(doc, patch map[string]any) map[string]any {
+	merged := make(map[string]any, len(doc)+len(patch))
+	for k, v := range doc {
+		merged[k] = v
+	}
+	for k, v := range patch {
+		switch v := v.(type) {
+		case nil:
+			delete(merged, k)
+		case map[string]any:
+			target, _ := merged[k].(map[string]any)
+			merged[k] = MergePatch(target, v)
+		default:
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// conflictingKeys returns the paths of patch that overlap a path changed from
+// base to current, sorted
+func conflictingKeys(base, current, patch map[string]any) []string {
+	changed := changedPaths("", base, current, nil)
+	var keys []string
+	for _, p := range patchPaths("", patch, nil) {
+		for _, c := range changed {
+			if p == c || strings.HasPrefix(p, c+".") || strings.HasPrefix(c, p+".") {
+				keys = append(keys, p)
+				break
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// changedPaths appends the paths whose value differs between a and b, down to
+// the deepest object both have
+func changedPaths(prefix string, a, b map[string]any, paths []string) []string {
+	seen := make(map[string]bool, len(a))
+	for k, av := range a {
+		seen[k] = true
+		bv, ok := b[k]
+		am, aObj := av.(map[string]any)
+		bm, bObj := bv.(map[string]any)
+		switch {
+		case ok && aObj && bObj:
+			paths = changedPaths(prefix+k+".", am, bm, paths)
+		case !ok || !reflect.DeepEqual(av, bv):
+			paths = append(paths, prefix+k)
+		}
+	}
+	for k := range b {
+		if !seen[k] {
+			paths = append(paths, prefix+k)
+		}
+	}
+	return paths
+}
+
+// patchPaths appends the paths patch sets or removes
+func patchPaths(prefix string, patch map[string]any, paths []string) []string {
+	for k, v := range patch {
+		if m, ok := v.(map[string]any); ok && len(m) > 0 {
+			paths = patchPaths(prefix+k+".", m, paths)
+			continue
+		}
+		paths = append(paths, prefix+k)
+	}
+	return paths
+}
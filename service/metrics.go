@@ -0,0 +1,45 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/metrics"
+)
+
+// WithMetrics records every update on m: counts of updates by result,
+// attempts, conflicts and pessimistic fallbacks, plus latency and backoff
+// distributions. See the metrics package for the names.
+func WithMetrics(m metrics.Metrics) Option {
+	return func(u *Updater) {
+		u.recorder = m
+	}
+}
+
+// record reports one finished update to the configured metrics backend
+func (u *Updater) record(outcome UpdateOutcome, err error, start time.Time) {
+	if u.recorder == nil {
+		return
+	}
+
+	result := "ok"
+	switch {
+	case errors.Is(err, ErrConflict):
+		result = "conflict"
+	case err != nil:
+		result = "error"
+	}
+
+	u.recorder.Count(metrics.UpdatesTotal, 1, metrics.Labels{"result": result})
+	if outcome.Attempts > 0 {
+		u.recorder.Count(metrics.AttemptsTotal, int64(outcome.Attempts), nil)
+	}
+	if outcome.Conflicts > 0 {
+		u.recorder.Count(metrics.ConflictsTotal, int64(outcome.Conflicts), nil)
+	}
+	if outcome.Pessimistic {
+		u.recorder.Count(metrics.PessimisticTotal, 1, nil)
+	}
+	u.recorder.Observe(metrics.UpdateSeconds, time.Since(start).Seconds(), metrics.Labels{"result": result})
+	u.recorder.Observe(metrics.BackoffSeconds, outcome.Backoff.Seconds(), nil)
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// OperationStatus is the lifecycle state of an asynchronous operation
+type OperationStatus string
+
+const (
+	OperationPending   OperationStatus = "pending"
+	OperationSucceeded OperationStatus = "succeeded"
+	OperationFailed    OperationStatus = "failed"
+)
+
+// Operation describes an update accepted for asynchronous processing
+type Operation struct {
+	ID          string
+	Status      OperationStatus
+	TenantID    string // tenant the update was scoped to, if any
+	BalanceID   uint
+	Delta       int64
+	Error       string
+	CreatedAt   time.Time
+	CompletedAt time.Time
+}
+
+// OperationRegistry tracks asynchronous operations in memory so callers can
+// poll for their outcome. Completed operations are kept for the retention
+// period and then pruned.
+type OperationRegistry struct {
+	retention time.Duration
+
+	mu  sync.Mutex
+	ops map[string]*Operation
+}
+
+// NewOperationRegistry returns a registry keeping completed operations for retention (default 1h)
+func NewOperationRegistry(retention time.Duration) *OperationRegistry {
+	if retention <= 0 {
+		retention = time.Hour
+	}
+	return &OperationRegistry{retention: retention, ops: make(map[string]*Operation)}
+}
+
+// Start records a pending operation and returns its ID
+func (r *OperationRegistry) Start(balanceID uint, delta int64) string {
+	return r.start("", balanceID, delta)
+}
+
+// start is Start for an update scoped to tenantID
+func (r *OperationRegistry) start(tenantID string, balanceID uint, delta int64) string {
+	op := &Operation{
+		ID:        newOperationID(),
+		Status:    OperationPending,
+		TenantID:  tenantID,
+		BalanceID: balanceID,
+		Delta:     delta,
+		CreatedAt: time.Now(),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune()
+	r.ops[op.ID] = op
+	return op.ID
+}
+
+// Complete records the result of an operation
+func (r *OperationRegistry) Complete(id string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	op, ok := r.ops[id]
+	if !ok {
+		return
+	}
+	op.CompletedAt = time.Now()
+	if err != nil {
+		op.Status = OperationFailed
+		op.Error = err.Error()
+	} else {
+		op.Status = OperationSucceeded
+	}
+}
+
+// Len returns the number of operations held, pending or retained
+func (r *OperationRegistry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.ops)
+}
+
+// forget drops an operation that was never queued
+func (r *OperationRegistry) forget(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.ops, id)
+}
+
+// Get returns a copy of the operation
+func (r *OperationRegistry) Get(id string) (Operation, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	op, ok := r.ops[id]
+	if !ok {
+		return Operation{}, false
+	}
+	return *op, true
+}
+
+// prune drops completed operations past retention; callers must hold r.mu
+func (r *OperationRegistry) prune() {
+	cutoff := time.Now().Add(-r.retention)
+	for id, op := range r.ops {
+		if op.Status != OperationPending && op.CompletedAt.Before(cutoff) {
+			delete(r.ops, id)
+		}
+	}
+}
+
+func newOperationID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
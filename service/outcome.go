@@ -0,0 +1,22 @@
+package service
+
+import "time"
+
+// UpdateOutcome describes how an update went, so callers can log and alert on
+// contention without instrumenting the library.
+type UpdateOutcome struct {
+	Attempts       int              // Optimistic attempts made, 1 when the first write won
+	Conflicts      int              // Attempts rejected because the version had changed
+	Backoff        time.Duration    // Total time slept between attempts
+	Pessimistic    bool             // The update was finished under SELECT ... FOR UPDATE
+	Merged         int              // Conflicts resolved by the Merge of WithMerge rather than by reading again
+	PreviousAmount int64            // Amount read by the winning (or last) attempt
+	NewAmount      int64            // Amount written; zero if the update failed
+	Version        int              // Version after the write; zero if the update failed
+	Policies       []PolicyDecision // Decisions of the policies that apply to the balance, see WithPolicies
+}
+
+// Retried reports whether the update needed more than one attempt
+func (o UpdateOutcome) Retried() bool {
+	return o.Attempts > 1 || o.Pessimistic
+}
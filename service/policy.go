@@ -0,0 +1,209 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// ErrPolicyDenied is wrapped by the *PolicyError an update refused by a
+// policy returns
+var ErrPolicyDenied = errors.New("update denied by policy")
+
+// ErrChecksUnsupported is returned by the updates that cannot run the
+// policies of WithPolicies or the limits of WithSpendingLimits, rather than
+// skipping them: updates through WithStore, and of decimal and sharded
+// balances
+var ErrChecksUnsupported = errors.New("policies and spending limits are not supported by this update")
+
+// PolicyRequest is an update as a Policy sees it
+type PolicyRequest struct {
+	Balance  models.Balance // as the update read it, with its limits
+	Delta    int64
+	Reserved bool // the update commits a prepared update
+}
+
+// NewAmount returns the amount the update leaves
+func (r PolicyRequest) NewAmount() int64 { return r.Balance.Amount + r.Delta }
+
+// limit returns the limit of kind of the balance, if it has one
+func (r PolicyRequest) limit(kind string) (models.BalanceLimit, bool) {
+	for _, limit := range r.Balance.Limits {
+		if limit.Kind == kind {
+			return limit, true
+		}
+	}
+	return models.BalanceLimit{}, false
+}
+
+// PolicyDecision is what a policy decided about an update. Err is the error
+// a refusal wraps besides ErrPolicyDenied, such as ErrInsufficientFunds.
+type PolicyDecision struct {
+	Policy  string
+	Allowed bool
+	Reason  string
+	Err     error
+}
+
+// Policy decides whether an update may apply. It is configured per balance by
+// a limit kind and does not apply, returning false, to balances without one.
+type Policy interface {
+	Decide(r PolicyRequest) (PolicyDecision, bool)
+}
+
+// PolicyFunc is a Policy as a function
+type PolicyFunc func(r PolicyRequest) (PolicyDecision, bool)
+
+// Decide calls f
+func (f PolicyFunc) Decide(r PolicyRequest) (PolicyDecision, bool) { return f(r) }
+
+// PolicyError is returned when a policy refused an update. It wraps
+// ErrPolicyDenied and the Err of the decision, and is never retried.
+type PolicyError struct {
+	BalanceID uint
+	Decision  PolicyDecision
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("balance %d: %s: %s", e.BalanceID, e.Decision.Policy, e.Decision.Reason)
+}
+
+func (e *PolicyError) Unwrap() []error {
+	if e.Decision.Err == nil {
+		return []error{ErrPolicyDenied}
+	}
+	return []error{ErrPolicyDenied, e.Decision.Err}
+}
+
+// WithPolicies evaluates policies on every integer update, in the transaction
+// of its versioned write and after it, so they see the amount the write
+// replaced and the limits as of that version: a concurrent update either
+// conflicts first or commits before the read. Every policy that applies to
+// the balance is recorded in UpdateOutcome.Policies; the first refusal rolls
+// the write back with a *PolicyError.
+func WithPolicies(policies ...Policy) Option {
+	return func(u *Updater) {
+		u.policies = append(u.policies[:len(u.policies):len(u.policies)], policies...)
+	}
+}
+
+// refuseUnchecked returns ErrChecksUnsupported for an update of balance id
+// that cannot apply the policies and spending limits of u, if it has any
+func (u *Updater) refuseUnchecked(id uint, what string) error {
+	if len(u.policies) == 0 && !u.spendingLimits {
+		return nil
+	}
+	return fmt.Errorf("%w: %s of balance %d", ErrChecksUnsupported, what, id)
+}
+
+// Names of the built-in policies, as PolicyByName takes them
+const (
+	PolicyMinimumBalance     = "minimum_balance"
+	PolicyOverdraft          = "overdraft"
+	PolicyRequireReservation = "require_reservation"
+)
+
+var builtinPolicies = map[string]func() Policy{
+	PolicyMinimumBalance:     MinimumBalance,
+	PolicyOverdraft:          Overdraft,
+	PolicyRequireReservation: RequireReservation,
+}
+
+// PolicyByName returns the built-in policy called name
+func PolicyByName(name string) (Policy, bool) {
+	policy, ok := builtinPolicies[name]
+	if !ok {
+		return nil, false
+	}
+	return policy(), true
+}
+
+// PolicyNames returns the names of the built-in policies, sorted
+func PolicyNames() []string {
+	names := make([]string, 0, len(builtinPolicies))
+	for name := range builtinPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MinimumBalance refuses a debit that leaves less than the min_amount limit
+// of the balance with ErrInsufficientFunds. Credits are always allowed.
+func MinimumBalance() Policy {
+	return PolicyFunc(func(r PolicyRequest) (PolicyDecision, bool) {
+		limit, ok := r.limit(models.LimitMinAmount)
+		if !ok {
+			return PolicyDecision{}, false
+		}
+		return floorDecision(PolicyMinimumBalance, r, limit.Amount), true
+	})
+}
+
+// Overdraft lets a debit take the balance below zero by up to its overdraft
+// limit, and refuses one that goes further with ErrInsufficientFunds
+func Overdraft() Policy {
+	return PolicyFunc(func(r PolicyRequest) (PolicyDecision, bool) {
+		limit, ok := r.limit(models.LimitOverdraft)
+		if !ok {
+			return PolicyDecision{}, false
+		}
+		return floorDecision(PolicyOverdraft, r, -limit.Amount), true
+	})
+}
+
+// floorDecision allows r if it is a credit or leaves at least floor
+func floorDecision(policy string, r PolicyRequest, floor int64) PolicyDecision {
+	if r.Delta < 0 && r.NewAmount() < floor {
+		return PolicyDecision{Policy: policy, Reason: fmt.Sprintf("would leave %d, below %d", r.NewAmount(), floor), Err: ErrInsufficientFunds}
+	}
+	return PolicyDecision{Policy: policy, Allowed: true, Reason: fmt.Sprintf("leaves %d, floor %d", r.NewAmount(), floor)}
+}
+
+// RequireReservation refuses a debit larger than the reservation_over limit
+// of the balance unless it commits a prepared update, so large debits are
+// confirmed in two phases
+func RequireReservation() Policy {
+	return PolicyFunc(func(r PolicyRequest) (PolicyDecision, bool) {
+		limit, ok := r.limit(models.LimitReservationOver)
+		if !ok {
+			return PolicyDecision{}, false
+		}
+		switch {
+		case r.Delta >= -limit.Amount:
+			return PolicyDecision{Policy: PolicyRequireReservation, Allowed: true, Reason: fmt.Sprintf("not a debit over %d", limit.Amount)}, true
+		case r.Reserved:
+			return PolicyDecision{Policy: PolicyRequireReservation, Allowed: true, Reason: "commits a prepared update"}, true
+		}
+		return PolicyDecision{Policy: PolicyRequireReservation, Reason: fmt.Sprintf("debit of %d over %d needs a prepared update", -r.Delta, limit.Amount)}, true
+	})
+}
+
+// checkPolicies evaluates the policies on an update of balance id that has
+// just been written in tx, recording their decisions in the outcome
+func (u *Updater) checkPolicies(tx *gorm.DB, id uint, delta int64, outcome *UpdateOutcome) error {
+	r := PolicyRequest{
+		Balance:  models.Balance{ID: id, Amount: outcome.PreviousAmount, Version: outcome.Version - 1},
+		Delta:    delta,
+		Reserved: u.reserved,
+	}
+	outcome.Policies = nil
+	if err := tx.Where("balance_id = ?", id).Order("kind").Find(&r.Balance.Limits).Error; err != nil {
+		return err
+	}
+	for _, policy := range u.policies {
+		decision, ok := policy.Decide(r)
+		if !ok {
+			continue
+		}
+		outcome.Policies = append(outcome.Policies, decision)
+		if !decision.Allowed {
+			return &PolicyError{BalanceID: id, Decision: decision}
+		}
+	}
+	return nil
+}
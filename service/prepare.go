@@ -0,0 +1,197 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+var (
+	// ErrPreparedNotFound is returned for a token Prepare did not issue, or
+	// issued for another tenant
+	ErrPreparedNotFound = fmt.Errorf("prepared update not found: %w", gorm.ErrRecordNotFound)
+
+	// ErrPreparedExpired is returned when committing a prepared update after
+	// its TTL; it is marked expired and never applied
+	ErrPreparedExpired = errors.New("prepared update expired")
+
+	// ErrPreparedResolved is returned when committing a prepared update that
+	// was already committed or aborted, or aborting a committed one
+	ErrPreparedResolved = errors.New("prepared update already resolved")
+)
+
+// DefaultPrepareTTL is the TTL of a prepared update when Prepare gets none
+const DefaultPrepareTTL = 5 * time.Minute
+
+// Prepare pins delta to the current version of the balance, using the
+// default Updater overridden by opts
+func Prepare(db *gorm.DB, id uint, delta int64, ttl time.Duration, opts ...Option) (models.PreparedUpdate, error) {
+	return NewUpdater(db, opts...).Prepare(id, delta, ttl)
+}
+
+// CommitPrepared applies a prepared update, using the default Updater
+// overridden by opts
+func CommitPrepared(db *gorm.DB, token string, opts ...Option) (UpdateOutcome, error) {
+	return NewUpdater(db, opts...).CommitPrepared(token)
+}
+
+// AbortPrepared discards a prepared update
+func AbortPrepared(db *gorm.DB, token string) error {
+	return NewUpdater(db).AbortPrepared(token)
+}
+
+// Prepare is the first phase of a two-phase update, for flows that confirm
+// asynchronously such as a payment gateway: it records delta against the
+// current version of the balance and returns the prepared update, whose ID
+// is the token to commit or abort it with before ttl elapses
+// (DefaultPrepareTTL if zero). Nothing is reserved: a write to the balance
+// in between makes the commit fail with ErrConflict.
+func (u *Updater) Prepare(id uint, delta int64, ttl time.Duration) (models.PreparedUpdate, error) {
+	if err := u.authorize(id, WriteOp{Kind: WritePrepare, Delta: delta}); err != nil {
+		return models.PreparedUpdate{}, err
+	}
+	var balance models.Balance
+	if err := u.db.Select("id", "version").First(&balance, id).Error; err != nil {
+		return models.PreparedUpdate{}, notFound(err)
+	}
+	return u.prepare(id, balance.Version, delta, ttl)
+}
+
+// PrepareIfVersion is Prepare pinned to expectedVersion, e.g. the version of
+// a balance the client showed its user. It returns ErrConflict right away if
+// the balance already moved past it.
+func (u *Updater) PrepareIfVersion(id uint, expectedVersion int, delta int64, ttl time.Duration) (models.PreparedUpdate, error) {
+	if err := u.authorize(id, WriteOp{Kind: WritePrepare, Delta: delta}); err != nil {
+		return models.PreparedUpdate{}, err
+	}
+	var balance models.Balance
+	if err := u.db.Select("id", "version").First(&balance, id).Error; err != nil {
+		return models.PreparedUpdate{}, notFound(err)
+	}
+	if balance.Version != expectedVersion {
+		return models.PreparedUpdate{}, ErrConflict
+	}
+	return u.prepare(id, expectedVersion, delta, ttl)
+}
+
+// prepare records a prepared update of a balance known to be at expectedVersion
+func (u *Updater) prepare(id uint, expectedVersion int, delta int64, ttl time.Duration) (models.PreparedUpdate, error) {
+	if ttl < 0 {
+		return models.PreparedUpdate{}, fmt.Errorf("negative prepare TTL %v", ttl)
+	}
+	if ttl == 0 {
+		ttl = DefaultPrepareTTL
+	}
+
+	now := time.Now()
+	prepared := models.PreparedUpdate{
+		ID:              newOperationID(),
+		BalanceID:       id,
+		Delta:           delta,
+		ExpectedVersion: expectedVersion,
+		Status:          models.PreparedPending,
+		ExpiresAt:       now.Add(ttl),
+		CreatedAt:       now,
+	}
+	if err := u.db.Create(&prepared).Error; err != nil {
+		return models.PreparedUpdate{}, err
+	}
+	return prepared, nil
+}
+
+// GetPrepared returns the prepared update of token. One past its TTL is
+// reported as expired even before a commit attempt marks it so.
+func GetPrepared(db *gorm.DB, token string) (models.PreparedUpdate, error) {
+	var prepared models.PreparedUpdate
+	if err := db.Where("id = ?", token).First(&prepared).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.PreparedUpdate{}, ErrPreparedNotFound
+		}
+		return models.PreparedUpdate{}, err
+	}
+	if prepared.Status == models.PreparedPending && !time.Now().Before(prepared.ExpiresAt) {
+		prepared.Status = models.PreparedExpired
+	}
+	return prepared, nil
+}
+
+// CommitPrepared is the second phase of a two-phase update: it applies the
+// prepared delta with UpdateIfVersion at the pinned version and marks the
+// prepared update committed, in one transaction. If the balance was written
+// since Prepare it returns ErrConflict and aborts the prepared update, as it
+// can never apply; after the TTL it returns ErrPreparedExpired. Committing
+// twice returns ErrPreparedResolved, so a caller retrying a commit whose
+// response it lost can tell it landed by checking GetPrepared.
+func (u *Updater) CommitPrepared(token string) (UpdateOutcome, error) {
+	// The update runs on the transaction; the cache is updated once it committed
+	inTx := *u
+	inTx.deadLetter = nil
+	inTx.cache = nil
+	inTx.reserved = true
+
+	var prepared models.PreparedUpdate
+	var outcome UpdateOutcome
+	err := u.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		claimed := tx.Model(&models.PreparedUpdate{}).
+			Where("id = ? AND status = ? AND expires_at > ?", token, models.PreparedPending, now).
+			Updates(map[string]any{"status": models.PreparedCommitted, "resolved_at": now})
+		if claimed.Error != nil {
+			return claimed.Error
+		}
+		var err error
+		if prepared, err = GetPrepared(tx, token); err != nil {
+			return err
+		}
+		if claimed.RowsAffected == 0 {
+			if prepared.Status == models.PreparedExpired {
+				return ErrPreparedExpired
+			}
+			return ErrPreparedResolved
+		}
+
+		inTx.db = tx
+		outcome, err = inTx.UpdateIfVersion(prepared.BalanceID, prepared.ExpectedVersion, prepared.Delta)
+		return err
+	})
+
+	switch {
+	case errors.Is(err, ErrConflict), errors.Is(err, ErrNotFound):
+		err = errors.Join(err, u.resolvePrepared(token, models.PreparedAborted))
+	case errors.Is(err, ErrPreparedExpired):
+		err = errors.Join(err, u.resolvePrepared(token, models.PreparedExpired))
+	}
+	if prepared.BalanceID != 0 {
+		u.cacheWrite(prepared.BalanceID, outcome, err)
+	}
+	return outcome, err
+}
+
+// AbortPrepared discards the prepared update of token. Aborting an aborted
+// or expired prepared update does nothing; aborting a committed one returns
+// ErrPreparedResolved.
+func (u *Updater) AbortPrepared(token string) error {
+	if err := u.resolvePrepared(token, models.PreparedAborted); err != nil {
+		return err
+	}
+	prepared, err := GetPrepared(u.db, token)
+	if err != nil {
+		return err
+	}
+	if prepared.Status == models.PreparedCommitted {
+		return ErrPreparedResolved
+	}
+	return nil
+}
+
+// resolvePrepared moves the prepared update of token to status unless it
+// was already resolved
+func (u *Updater) resolvePrepared(token, status string) error {
+	return u.db.Model(&models.PreparedUpdate{}).
+		Where("id = ? AND status = ?", token, models.PreparedPending).
+		Updates(map[string]any{"status": status, "resolved_at": time.Now()}).Error
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/events"
+	"github.com/ghozilaaa/optimistic-lock/outbox"
+)
+
+// WithEventPublisher publishes an events.BalanceChanged for every integer
+// balance update. The write and the publish share a transaction: if Publish
+// fails the update is rolled back and the error returned without retrying.
+// An event can still be published for a write whose commit then fails, so
+// consumers should de-duplicate by balance ID and version.
+func WithEventPublisher(p events.Publisher) Option {
+	return func(u *Updater) {
+		u.publisher = p
+	}
+}
+
+// WithOutbox stores an events.BalanceChanged in the outbox table in the same
+// transaction as every integer balance update. Unlike WithEventPublisher, an
+// event is recorded exactly when its update commits; an outbox.Relay delivers
+// it afterwards. The outbox tables must exist, see outbox.Migrate.
+func WithOutbox() Option {
+	return func(u *Updater) {
+		u.outbox = true
+	}
+}
+
+// publishes reports whether updates emit events
+func (u *Updater) publishes() bool {
+	return u.publisher != nil || u.outbox || u.notify || u.eventSourced
+}
+
+// commit runs write directly, or inside a transaction that also checks the
+// policies, charges the spending limits and publishes the change when
+// updates do any of those. If they refuse the write, the outcome is left
+// without its new amount and version.
+func (u *Updater) commit(write func(tx *gorm.DB) error, id uint, delta int64, outcome *UpdateOutcome) error {
+	if !u.publishes() && !u.spendingLimits && len(u.policies) == 0 {
+		return write(u.db)
+	}
+	err := u.db.Transaction(func(tx *gorm.DB) error {
+		if err := write(tx); err != nil {
+			return err
+		}
+		return u.afterWrite(tx, id, delta, outcome)
+	})
+	if err != nil {
+		outcome.NewAmount, outcome.Version = 0, 0
+	}
+	return err
+}
+
+// afterWrite runs in the transaction of a successful versioned write: it
+// checks the policies, charges the spending limits, then publishes the change
+func (u *Updater) afterWrite(tx *gorm.DB, id uint, delta int64, outcome *UpdateOutcome) error {
+	if len(u.policies) > 0 {
+		if err := u.checkPolicies(tx, id, delta, outcome); err != nil {
+			return err
+		}
+	}
+	if u.spendingLimits {
+		if err := chargeLimits(tx, id, delta); err != nil {
+			return err
+		}
+	}
+	return u.publish(tx, id, delta, *outcome)
+}
+
+// publish appends the change to the balance events, records the event for a
+// successful write in the outbox, queues its notification and sends it to
+// the publisher, whichever are configured
+func (u *Updater) publish(tx *gorm.DB, id uint, delta int64, outcome UpdateOutcome) error {
+	if !u.publishes() {
+		return nil
+	}
+	if u.eventSourced {
+		if err := appendEvent(tx, id, delta, outcome); err != nil {
+			return err
+		}
+	}
+	event := events.BalanceChanged{
+		BalanceID: id,
+		OldAmount: outcome.PreviousAmount,
+		NewAmount: outcome.NewAmount,
+		Delta:     delta,
+		Version:   outcome.Version,
+		At:        time.Now(),
+	}
+
+	if u.outbox {
+		if err := outbox.Enqueue(tx, event); err != nil {
+			return fmt.Errorf("enqueue balance event: %w", err)
+		}
+	}
+	if u.notify {
+		if err := notify(tx, event); err != nil {
+			return fmt.Errorf("notify balance event: %w", err)
+		}
+	}
+	if u.publisher != nil {
+		if err := u.publisher.Publish(context.Background(), event); err != nil {
+			return fmt.Errorf("publish balance event: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when an update exceeds the configured write rate
+var ErrRateLimited = errors.New("rate limited: too many updates")
+
+// RateLimitOptions caps write pressure. A zero rate disables that limit.
+type RateLimitOptions struct {
+	PerKeyRate  float64 // Updates per second allowed for each balance ID
+	PerKeyBurst int     // Bucket size per balance ID (default: one second of PerKeyRate)
+	GlobalRate  float64 // Updates per second allowed across all balances
+	GlobalBurst int     // Global bucket size (default: one second of GlobalRate)
+}
+
+// tokenBucket refills at rate tokens per second up to burst
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) bool {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimiter holds a global bucket and one bucket per balance ID
+type rateLimiter struct {
+	opts RateLimitOptions
+
+	mu        sync.Mutex
+	global    tokenBucket
+	keys      map[uint]*tokenBucket
+	lastPrune time.Time
+}
+
+func newRateLimiter(opts RateLimitOptions) *rateLimiter {
+	if opts.PerKeyBurst <= 0 {
+		opts.PerKeyBurst = max(1, int(opts.PerKeyRate))
+	}
+	if opts.GlobalBurst <= 0 {
+		opts.GlobalBurst = max(1, int(opts.GlobalRate))
+	}
+	now := time.Now()
+	return &rateLimiter{
+		opts:      opts,
+		global:    tokenBucket{tokens: float64(opts.GlobalBurst), last: now},
+		keys:      make(map[uint]*tokenBucket),
+		lastPrune: now,
+	}
+}
+
+// allow takes a token from the key bucket and the global bucket. A rejected
+// request consumes nothing.
+func (l *rateLimiter) allow(id uint) bool {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var key *tokenBucket
+	if l.opts.PerKeyRate > 0 {
+		key = l.keys[id]
+		if key == nil {
+			key = &tokenBucket{tokens: float64(l.opts.PerKeyBurst), last: now}
+			l.keys[id] = key
+		}
+		if !key.take(now, l.opts.PerKeyRate, l.opts.PerKeyBurst) {
+			return false
+		}
+	}
+
+	if l.opts.GlobalRate > 0 && !l.global.take(now, l.opts.GlobalRate, l.opts.GlobalBurst) {
+		if key != nil {
+			key.tokens++ // refund
+		}
+		return false
+	}
+
+	l.prune(now)
+	return true
+}
+
+// prune drops buckets that have been idle long enough to be full again
+func (l *rateLimiter) prune(now time.Time) {
+	if l.opts.PerKeyRate <= 0 || now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	refill := time.Duration(float64(l.opts.PerKeyBurst) / l.opts.PerKeyRate * float64(time.Second))
+	for id, b := range l.keys {
+		if now.Sub(b.last) > refill {
+			delete(l.keys, id)
+		}
+	}
+	l.lastPrune = now
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/sqladapter"
+)
+
+// WithRawSQL runs the optimistic attempts of UpdateBalance as two hand-written
+// statements on the connection pool of the GORM handle instead of through
+// GORM's model reflection, which shows in profiles at several hundred updates
+// per second. Statements are prepared and cached when the handle was opened
+// with gorm.Config{PrepareStmt: true}. GORM callbacks do not see the
+// attempts; everything else, including the pessimistic fallback, is unchanged.
+// The attempts of an Updater scoped to a tenant go through GORM regardless,
+// so the tenant plugin sees them.
+func WithRawSQL() Option {
+	return func(u *Updater) {
+		u.rawSQL = true
+	}
+}
+
+// rawQueries returns the sqladapter statements in the placeholder style of
+// the driver of db, with the update limited to active balances
+func rawQueries(db *gorm.DB) sqladapter.Queries {
+	q := sqladapter.MySQLQueries
+	if db.Dialector.Name() == "postgres" {
+		q = sqladapter.PostgresQueries
+	}
+	q.Update += " AND status = '" + models.BalanceActive + "'"
+	return q
+}
+
+// updateRaw is updateOnce without GORM: a select and a version-checked update
+func (u *Updater) updateRaw(id uint, delta int64, outcome *UpdateOutcome) error {
+	q := rawQueries(u.db)
+	ctx := u.db.Statement.Context
+
+	var amount int64
+	var version int
+	err := u.db.Statement.ConnPool.QueryRowContext(ctx, q.Select, id).Scan(&amount, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return retryableError{err}
+	}
+
+	err = u.commit(func(tx *gorm.DB) error {
+		result, err := tx.Statement.ConnPool.ExecContext(ctx, q.Update, amount+delta, version+1, id, version)
+		if err != nil {
+			return retryableError{err}
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return retryableError{err}
+		} else if n == 0 {
+			return missedWrite(tx, id)
+		}
+		outcome.PreviousAmount = amount
+		outcome.NewAmount = amount + delta
+		outcome.Version = version + 1
+		return nil
+	}, id, delta, outcome)
+	if err == ErrConflict {
+		u.auditConflict(id, version, -1, delta, outcome.Attempts)
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"errors"
+	"time"
+)
+
+// maxRetryAfter caps the delay RetryAfterError suggests
+const maxRetryAfter = 30 * time.Second
+
+// RetryAfterError is returned when an update gave up on a contended balance,
+// with ErrRetryExhausted or ErrDeadlineExceeded. RetryAfter is how long the
+// caller should wait before sending the update again, so it backs off instead
+// of retrying straight into the same hot row.
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string { return e.Err.Error() }
+
+func (e *RetryAfterError) Unwrap() error { return e.Err }
+
+// RetryAfter returns the delay an update error suggests waiting before the
+// next try, if it carries one
+func RetryAfter(err error) (time.Duration, bool) {
+	var r *RetryAfterError
+	if errors.As(err, &r) {
+		return r.RetryAfter, true
+	}
+	return 0, false
+}
+
+// retryAfter wraps err, the reason update of balance id gave up, with the
+// delay to suggest to the caller. The delay carries on the backoff schedule
+// where the attempts stopped. With WithAdaptiveBackoff it is stretched by the
+// recent conflict rate of the balance, which counts the other writers too.
+func (u *Updater) retryAfter(id uint, outcome UpdateOutcome, err error) error {
+	if !errors.Is(err, ErrRetryExhausted) {
+		return err
+	}
+	d := u.baseBackoff
+	for i := 0; i < outcome.Attempts && d < maxRetryAfter; i++ {
+		d *= 2
+	}
+	if u.adaptive != nil {
+		d = time.Duration(float64(d) * u.adaptive.Scale(id))
+	}
+	return &RetryAfterError{Err: err, RetryAfter: min(d, maxRetryAfter)}
+}
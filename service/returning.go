@@ -0,0 +1,85 @@
+package service
+
+import (
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// supportsReturning reports whether the driver of db accepts UPDATE ...
+// RETURNING, as Postgres and SQLite 3.35+ do, by asking GORM which clauses
+// the dialect registered for updates
+func supportsReturning(db *gorm.DB) bool {
+	return slices.Contains(db.Callback().Update().Clauses, "RETURNING")
+}
+
+// knownVersion returns the version a write to balance id can expect without
+// reading it first: the cached version, if any, or -1
+func (u *Updater) knownVersion(id uint) int {
+	if u.cache != nil {
+		if entry, ok := u.cache.Peek(id); ok {
+			return entry.Version
+		}
+	}
+	return -1
+}
+
+// updateReturning is updateOnce in a single statement when the version to
+// expect is known: the write adds delta in SQL and returns the new amount and
+// version. When it is not, the balance is read first. After a conflict
+// *version holds the version the write lost to, so the next attempt skips
+// the read.
+func (u *Updater) updateReturning(id uint, delta int64, version *int, outcome *UpdateOutcome) error {
+	if *version < 0 {
+		var balance models.Balance
+		if err := u.db.Select("version").First(&balance, id).Error; err != nil {
+			return notFound(err)
+		}
+		*version = balance.Version
+	}
+
+	expected := *version
+	err := u.commit(func(tx *gorm.DB) error {
+		return writeReturning(tx, id, expected, delta, version, outcome)
+	}, id, delta, outcome)
+	if err == ErrConflict {
+		u.auditConflict(id, expected, *version, delta, outcome.Attempts)
+	}
+	return err
+}
+
+// writeReturning adds delta to balance id if it is still at expected and
+// active. On a conflict it stores the current version in *current; a deleted
+// balance returns ErrNotFound and a frozen or closed one a *StatusError.
+func writeReturning(tx *gorm.DB, id uint, expected int, delta int64, current *int, outcome *UpdateOutcome) error {
+	var balance models.Balance
+	result := tx.Model(&balance).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "amount"}, {Name: "version"}}}).
+		Where("id = ? AND version = ? AND status = ?", id, expected, models.BalanceActive).
+		Updates(map[string]any{"amount": gorm.Expr("amount + ?", delta), "version": gorm.Expr("version + 1")})
+	if result.Error != nil {
+		return retryableError{result.Error}
+	}
+	if result.RowsAffected == 0 {
+		var latest models.Balance
+		if err := tx.Select("id", "version", "status").First(&latest, id).Error; err != nil {
+			if err = notFound(err); err == ErrNotFound {
+				return err
+			}
+			return retryableError{err}
+		}
+		if err := checkActive(latest); err != nil {
+			return err
+		}
+		*current = latest.Version
+		return ErrConflict
+	}
+
+	outcome.PreviousAmount = balance.Amount - delta
+	outcome.NewAmount = balance.Amount
+	outcome.Version = balance.Version
+	return nil
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// UpdateFunc is a balance update strategy, e.g. Updater.UpdateBalance or Batcher.Add
+type UpdateFunc func(id uint, delta int64) (UpdateOutcome, error)
+
+// ArmStats aggregates results for one side of a rollout
+type ArmStats struct {
+	Requests     int
+	Errors       int
+	TotalLatency time.Duration
+}
+
+// ErrorRate returns Errors/Requests
+func (a ArmStats) ErrorRate() float64 {
+	if a.Requests == 0 {
+		return 0
+	}
+	return float64(a.Errors) / float64(a.Requests)
+}
+
+// MeanLatency returns the average request latency
+func (a ArmStats) MeanLatency() time.Duration {
+	if a.Requests == 0 {
+		return 0
+	}
+	return a.TotalLatency / time.Duration(a.Requests)
+}
+
+// RolloutStats compares the control and candidate strategies
+type RolloutStats struct {
+	Percent    float64
+	RolledBack bool
+	Control    ArmStats
+	Candidate  ArmStats
+}
+
+// RolloutConfig configures a Rollout
+type RolloutConfig struct {
+	Percent              float64 // Share of balance IDs (0-100) routed to the candidate
+	MinSamples           int     // Requests needed on each arm before comparing (default 100)
+	MaxErrorRateIncrease float64 // Allowed candidate error rate above control (default 0.05)
+	MaxLatencyRatio      float64 // Allowed candidate/control mean latency (default 1.5)
+	OnRollback           func(RolloutStats)
+}
+
+// Rollout routes a percentage of traffic through a candidate strategy while
+// comparing it to the control path, and routes everything back to control if
+// the candidate's error rate or latency regresses. Routing hashes the balance
+// ID, so a given balance always uses the same strategy and the two strategies
+// never race on the same row.
+type Rollout struct {
+	control   UpdateFunc
+	candidate UpdateFunc
+	cfg       RolloutConfig
+
+	mu    sync.Mutex
+	stats RolloutStats
+}
+
+// NewRollout returns a rollout starting at cfg.Percent
+func NewRollout(control, candidate UpdateFunc, cfg RolloutConfig) *Rollout {
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = 100
+	}
+	if cfg.MaxErrorRateIncrease <= 0 {
+		cfg.MaxErrorRateIncrease = 0.05
+	}
+	if cfg.MaxLatencyRatio <= 0 {
+		cfg.MaxLatencyRatio = 1.5
+	}
+	return &Rollout{
+		control:   control,
+		candidate: candidate,
+		cfg:       cfg,
+		stats:     RolloutStats{Percent: cfg.Percent},
+	}
+}
+
+// UpdateBalance applies the update through the strategy selected for id
+func (r *Rollout) UpdateBalance(id uint, delta int64) (UpdateOutcome, error) {
+	useCandidate := r.routesToCandidate(id)
+
+	fn := r.control
+	if useCandidate {
+		fn = r.candidate
+	}
+
+	start := time.Now()
+	outcome, err := fn(id, delta)
+	r.record(useCandidate, time.Since(start), err)
+	return outcome, err
+}
+
+// SetPercent changes the candidate share and clears a previous rollback
+func (r *Rollout) SetPercent(percent float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.Percent = percent
+	r.stats.RolledBack = false
+}
+
+// Stats returns a snapshot of the comparison
+func (r *Rollout) Stats() RolloutStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+func (r *Rollout) routesToCandidate(id uint) bool {
+	r.mu.Lock()
+	percent := r.stats.Percent
+	r.mu.Unlock()
+	if percent <= 0 {
+		return false
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(strconv.FormatUint(uint64(id), 10)))
+	return float64(h.Sum32()%10000) < percent*100
+}
+
+func (r *Rollout) record(candidate bool, latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	arm := &r.stats.Control
+	if candidate {
+		arm = &r.stats.Candidate
+	}
+	arm.Requests++
+	arm.TotalLatency += latency
+	if isBreakerFailure(err) {
+		arm.Errors++
+	}
+
+	if r.stats.RolledBack || r.stats.Control.Requests < r.cfg.MinSamples ||
+		r.stats.Candidate.Requests < r.cfg.MinSamples {
+		return
+	}
+
+	control, cand := r.stats.Control, r.stats.Candidate
+	regressed := cand.ErrorRate() > control.ErrorRate()+r.cfg.MaxErrorRateIncrease ||
+		(control.MeanLatency() > 0 &&
+			float64(cand.MeanLatency()) > float64(control.MeanLatency())*r.cfg.MaxLatencyRatio)
+	if !regressed {
+		return
+	}
+
+	r.stats.Percent = 0
+	r.stats.RolledBack = true
+	if r.cfg.OnRollback != nil {
+		r.cfg.OnRollback(r.stats)
+	}
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+var (
+	// ErrSagaCompensated is returned when a saga that was already compensated is run again
+	ErrSagaCompensated = errors.New("saga was compensated")
+
+	// ErrCompensationFailed is wrapped by a SagaError whose compensation did
+	// not finish; call CompensateSaga again to complete it
+	ErrCompensationFailed = errors.New("saga compensation failed")
+)
+
+// maxSagaIDLength keeps the step references within the adjustments reference column
+const maxSagaIDLength = 100
+
+// SagaStep is one balance change of a saga
+type SagaStep struct {
+	Name      string // used in errors, e.g. "fee"
+	BalanceID uint
+	Delta     int64
+}
+
+// SagaError reports the step that failed and whether the steps before it
+// were compensated
+type SagaError struct {
+	Saga            string
+	Step            int // index of the failed step
+	Name            string
+	Err             error // why the step failed
+	CompensationErr error // nil when every applied step was compensated
+}
+
+func (e *SagaError) Error() string {
+	msg := fmt.Sprintf("saga %s: step %d (%s) failed: %v", e.Saga, e.Step, e.Name, e.Err)
+	if e.CompensationErr != nil {
+		return msg + "; " + e.CompensationErr.Error()
+	}
+	return msg + "; earlier steps were compensated"
+}
+
+func (e *SagaError) Unwrap() []error {
+	if e.CompensationErr != nil {
+		return []error{e.Err, e.CompensationErr}
+	}
+	return []error{e.Err}
+}
+
+// RunSaga runs steps against db with the default retry policy
+func RunSaga(db *gorm.DB, id string, steps []SagaStep) error {
+	return NewUpdater(db).RunSaga(id, steps)
+}
+
+// RunSaga applies steps in order for an operation that spans several
+// balances, such as a debit, a fee and a credit. Each step is committed on
+// its own as an adjustment with the reference saga:<id>:<n>, so the
+// adjustments record how far the saga got. If a step fails, for example by
+// exhausting its retries, the applied steps are compensated and a *SagaError
+// is returned; the error wraps the step's error.
+//
+// Every write is idempotent by reference: running a saga again under the same
+// ID after a crash skips the steps already applied and continues. A saga that
+// was compensated returns ErrSagaCompensated instead of being applied again.
+// Steps are never dead-lettered, since a replay after the compensation would
+// apply them after all.
+func (u *Updater) RunSaga(id string, steps []SagaStep) error {
+	if err := validateSagaID(id); err != nil {
+		return err
+	}
+	saga := *u
+	saga.deadLetter = nil
+
+	recorded, err := saga.sagaAdjustments(id)
+	if err != nil {
+		return err
+	}
+	for _, adj := range recorded {
+		if strings.HasSuffix(adj.Reference, ":undo") {
+			return ErrSagaCompensated
+		}
+	}
+
+	for n, step := range steps {
+		if _, err := saga.ApplyAdjustment(sagaReference(id, n), step.BalanceID, step.Delta); err != nil {
+			return &SagaError{Saga: id, Step: n, Name: step.Name, Err: err, CompensationErr: saga.CompensateSaga(id)}
+		}
+	}
+	return nil
+}
+
+// CompensateSaga undoes every applied step of the saga that is not undone
+// yet, latest first, by applying the opposite delta under the reference
+// saga:<id>:<n>:undo. It is safe to call again after a failure or on a saga
+// that completed, e.g. to cancel the operation later.
+func (u *Updater) CompensateSaga(id string) error {
+	if err := validateSagaID(id); err != nil {
+		return err
+	}
+	saga := *u
+	saga.deadLetter = nil
+
+	recorded, err := saga.sagaAdjustments(id)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCompensationFailed, err)
+	}
+
+	undone := make(map[string]bool)
+	var applied []models.Adjustment
+	for _, adj := range recorded {
+		if forward, ok := strings.CutSuffix(adj.Reference, ":undo"); ok {
+			undone[forward] = true
+		} else {
+			applied = append(applied, adj)
+		}
+	}
+	sort.Slice(applied, func(i, j int) bool { return sagaStep(applied[i].Reference) > sagaStep(applied[j].Reference) })
+
+	var errs []error
+	for _, adj := range applied {
+		if undone[adj.Reference] {
+			continue
+		}
+		if _, err := saga.ApplyAdjustment(adj.Reference+":undo", adj.BalanceID, -adj.Delta); err != nil {
+			errs = append(errs, fmt.Errorf("undo %s: %w", adj.Reference, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrCompensationFailed, errors.Join(errs...))
+	}
+	return nil
+}
+
+// sagaAdjustments returns the adjustments recorded for the saga, found by
+// a range scan on the reference index
+func (u *Updater) sagaAdjustments(id string) ([]models.Adjustment, error) {
+	prefix := "saga:" + id + ":"
+	var adjs []models.Adjustment
+	// ';' sorts right after ':', so the range covers exactly the prefix
+	err := u.db.Where("reference >= ? AND reference < ?", prefix, "saga:"+id+";").Find(&adjs).Error
+	return adjs, err
+}
+
+// validateSagaID keeps the references of one saga from matching another's
+func validateSagaID(id string) error {
+	if id == "" || len(id) > maxSagaIDLength || strings.ContainsAny(id, ":;") {
+		return fmt.Errorf("saga ID must be 1 to %d characters without ':' or ';'", maxSagaIDLength)
+	}
+	return nil
+}
+
+func sagaReference(id string, step int) string {
+	return "saga:" + id + ":" + strconv.Itoa(step)
+}
+
+// sagaStep returns the step number at the end of a forward step reference
+func sagaStep(reference string) int {
+	n, _ := strconv.Atoi(reference[strings.LastIndexByte(reference, ':')+1:])
+	return n
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// ErrAssertionFailed is returned when an OpAssert check rejects a balance
+var ErrAssertionFailed = errors.New("script assertion failed")
+
+// OpKind is the kind of a script operation
+type OpKind int
+
+const (
+	OpRead   OpKind = iota // Load the balance into the result
+	OpAssert               // Run Check against the balance as modified so far
+	OpCredit               // Add Amount
+	OpDebit                // Subtract Amount
+)
+
+// Op is one step of a script run by RunScript
+type Op struct {
+	Kind      OpKind
+	BalanceID uint
+	Amount    int64                      // For OpCredit and OpDebit
+	Check     func(models.Balance) error // For OpAssert
+}
+
+// AtLeast returns an OpAssert check requiring the amount to be at least min
+func AtLeast(min int64) func(models.Balance) error {
+	return func(b models.Balance) error {
+		if b.Amount < min {
+			return fmt.Errorf("balance %d has %d, want at least %d", b.ID, b.Amount, min)
+		}
+		return nil
+	}
+}
+
+// ScriptResult is the outcome of RunScript
+type ScriptResult struct {
+	Balances map[uint]models.Balance // Final state of every balance the script touched
+	Outcome  UpdateOutcome           // Attempts, conflicts and backoff of the script as a whole
+}
+
+// RunScript runs ops against db with the default retry policy
+func RunScript(ctx context.Context, db *gorm.DB, ops []Op) (ScriptResult, error) {
+	return NewUpdater(db).RunScript(ctx, ops)
+}
+
+// RunScript runs ops in order inside one transaction. Every balance is read
+// on first use; at the end each changed balance is written guarded by the
+// version that was read and every balance that was only read is checked to
+// still be at that version. Any mismatch rolls the transaction back and the
+// whole script is retried like a single update, including the pessimistic
+// fallback, which locks all the script's balances in ID order. A failed
+// assertion aborts with ErrAssertionFailed without retrying.
+//
+// The write check, breaker, metrics, event publisher and outbox options apply;
+// hooks see the script as balance 0. The rate limit, serializer and conflict
+// audit are per balance and do not apply.
+func (u *Updater) RunScript(ctx context.Context, ops []Op) (ScriptResult, error) {
+	var result ScriptResult
+	if u.writeCheck != nil {
+		if err := u.writeCheck(); err != nil {
+			return result, err
+		}
+	}
+	if u.breaker != nil {
+		if err := u.breaker.Allow(); err != nil {
+			return result, err
+		}
+	}
+
+	start := time.Now()
+	run := func(lock bool) func(*UpdateOutcome) error {
+		return func(*UpdateOutcome) error {
+			balances, err := u.runScriptOnce(ctx, ops, lock)
+			var retryable retryableError
+			if lock && errors.As(err, &retryable) {
+				// The locked attempt is final, so report the underlying error
+				err = retryable.err
+			}
+			if err == nil {
+				result.Balances = balances
+			}
+			return err
+		}
+	}
+	outcome, err := u.retry(Attempt{}, run(false), run(true))
+	result.Outcome = outcome
+
+	if u.breaker != nil {
+		u.breaker.Record(err)
+	}
+	u.record(outcome, err, start)
+	if u.cache != nil && err == nil {
+		for id, balance := range result.Balances {
+			u.cache.Set(id, balance.Amount, balance.Version)
+		}
+	}
+	return result, err
+}
+
+// runScriptOnce makes one attempt at the script, taking row locks up front if lock is set
+func (u *Updater) runScriptOnce(ctx context.Context, ops []Op, lock bool) (map[uint]models.Balance, error) {
+	final := make(map[uint]models.Balance)
+	err := u.db.WithContext(tenantContext(ctx, u.db)).Transaction(func(tx *gorm.DB) error {
+		read := make(map[uint]models.Balance)
+		var order []uint
+		load := func(query *gorm.DB, id uint) error {
+			if _, ok := read[id]; ok {
+				return nil
+			}
+			var b models.Balance
+			if err := query.First(&b, id).Error; err != nil {
+				return err
+			}
+			read[id] = b
+			final[id] = b
+			order = append(order, id)
+			return nil
+		}
+
+		if lock {
+			for _, id := range scriptBalanceIDs(ops) {
+				if err := load(tx.Clauses(clause.Locking{Strength: "UPDATE"}), id); err != nil {
+					return err
+				}
+			}
+		}
+
+		for i, op := range ops {
+			if err := load(tx, op.BalanceID); err != nil {
+				return err
+			}
+			b := final[op.BalanceID]
+			switch op.Kind {
+			case OpRead:
+			case OpAssert:
+				if err := op.Check(b); err != nil {
+					return fmt.Errorf("%w: op %d: %w", ErrAssertionFailed, i, err)
+				}
+			case OpCredit:
+				b.Amount += op.Amount
+			case OpDebit:
+				b.Amount -= op.Amount
+			default:
+				return fmt.Errorf("op %d: unknown kind %d", i, op.Kind)
+			}
+			final[op.BalanceID] = b
+		}
+
+		for _, id := range order {
+			before := read[id]
+			delta := final[id].Amount - before.Amount
+			if delta == 0 {
+				if err := checkVersion(tx, before); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := u.authorizeBalance(ctx, before, WriteOp{Kind: WriteScript, Delta: delta}); err != nil {
+				return err
+			}
+			var outcome UpdateOutcome
+			if err := writeVersioned(tx, before, delta, &outcome); err != nil {
+				return err
+			}
+			b := final[id]
+			b.Version = outcome.Version
+			final[id] = b
+			if err := u.afterWrite(tx, id, delta, &outcome); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return final, err
+}
+
+// checkVersion returns ErrConflict if the balance is no longer at the version that was read
+func checkVersion(tx *gorm.DB, b models.Balance) error {
+	var count int64
+	if err := tx.Model(&models.Balance{}).Where("id = ? AND version = ?", b.ID, b.Version).Count(&count).Error; err != nil {
+		return retryableError{err}
+	}
+	if count == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+// scriptBalanceIDs returns the distinct balance IDs of ops in ascending order
+func scriptBalanceIDs(ops []Op) []uint {
+	seen := make(map[uint]bool)
+	var ids []uint
+	for _, op := range ops {
+		if !seen[op.BalanceID] {
+			seen[op.BalanceID] = true
+			ids = append(ids, op.BalanceID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// KeyedSerializer runs work for the same balance ID one at a time within this
+// process. IDs are hashed onto a fixed set of striped mutexes, so unrelated IDs
+// rarely wait on each other and memory stays constant regardless of key count.
+type KeyedSerializer struct {
+	stripes atomic.Pointer[[]sync.Mutex]
+}
+
+// NewKeyedSerializer returns a serializer with the given number of stripes (default 256)
+func NewKeyedSerializer(stripes int) *KeyedSerializer {
+	s := &KeyedSerializer{}
+	s.Resize(stripes)
+	return s
+}
+
+// Resize replaces the stripes with a new set of the given size (default 256).
+// Work already running keeps its old stripe, so until it finishes it may
+// overlap with new work for the same ID; the version check still rejects any
+// stale write, the overlap only costs a conflict.
+func (s *KeyedSerializer) Resize(stripes int) {
+	if stripes <= 0 {
+		stripes = 256
+	}
+	table := make([]sync.Mutex, stripes)
+	s.stripes.Store(&table)
+}
+
+// Stripes returns the number of stripes
+func (s *KeyedSerializer) Stripes() int {
+	return len(*s.stripes.Load())
+}
+
+// Do runs fn while holding the stripe lock for id
+func (s *KeyedSerializer) Do(id uint, fn func() error) error {
+	stripes := *s.stripes.Load()
+	mu := &stripes[stripe(id, len(stripes))]
+	mu.Lock()
+	defer mu.Unlock()
+	return fn()
+}
+
+func stripe(id uint, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(strconv.FormatUint(uint64(id), 10)))
+	return int(h.Sum32() % uint32(n))
+}
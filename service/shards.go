@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// ErrNotSharded is returned for a balance without shards
+var ErrNotSharded = errors.New("balance is not sharded")
+
+// shardCursor spreads writes over the shards of a balance
+var shardCursor atomic.Uint64
+
+// CreateShards spreads amount over n new shards of the logical balance id,
+// which must not have shards yet. The logical balance lives in the
+// balance_shards table only; its ID does not have to exist in balances.
+func CreateShards(db *gorm.DB, id uint, n int, amount int64) error {
+	if n < 1 {
+		return fmt.Errorf("shard count must be positive, got %d", n)
+	}
+	shards := make([]models.BalanceShard, n)
+	for i, share := range splitAmount(amount, n) {
+		shards[i] = models.BalanceShard{BalanceID: id, Shard: i, Amount: share}
+	}
+	return db.Create(&shards).Error
+}
+
+// ShardTotal is the amount of a sharded balance
+type ShardTotal struct {
+	BalanceID uint
+	Amount    int64
+	Shards    int
+}
+
+// GetTotal adds up the shards of balance id in one query, so the total is
+// consistent at the statement's snapshot
+func GetTotal(db *gorm.DB, id uint) (ShardTotal, error) {
+	total := ShardTotal{BalanceID: id}
+	row := db.Model(&models.BalanceShard{}).Where("balance_id = ?", id).
+		Select("COALESCE(SUM(amount), 0), COUNT(*)").Row()
+	if err := row.Scan(&total.Amount, &total.Shards); err != nil {
+		return total, err
+	}
+	if total.Shards == 0 {
+		return total, ErrNotSharded
+	}
+	return total, nil
+}
+
+// UpdateShardedBalance adds delta to one shard of balance id, using the
+// default Updater overridden by opts
+func UpdateShardedBalance(db *gorm.DB, id uint, delta int64, opts ...Option) (UpdateOutcome, error) {
+	return NewUpdater(db, opts...).UpdateShardedBalance(id, delta)
+}
+
+// UpdateShardedBalance adds delta to one shard of balance id. Writes take the
+// shards round robin and a conflicting attempt moves on to the next shard, so
+// writers rarely meet on the same row. Shards may go negative; use
+// RebalanceShards to even them out. The outcome's amounts and version are the
+// shard's. The Authorizer, write check, rate limit, breaker, hooks, metrics
+// and pessimistic fallback apply. The serializer does not, since queueing the
+// writes would undo the sharding, and neither do events, the cache, the
+// conflict audit and the dead letter queue. Policies and spending limits
+// need the balance row, so it returns ErrChecksUnsupported with either.
+func (u *Updater) UpdateShardedBalance(id uint, delta int64) (UpdateOutcome, error) {
+	if err := u.refuseUnchecked(id, "sharded update"); err != nil {
+		return UpdateOutcome{}, err
+	}
+	if err := u.authorize(id, WriteOp{Kind: WriteShard, Delta: delta}); err != nil {
+		return UpdateOutcome{}, err
+	}
+	start := time.Now()
+	unqueued := *u
+	unqueued.serializer = nil
+
+	var outcome UpdateOutcome
+	err := unqueued.guard(id, func() error {
+		var count int64
+		if err := u.db.Model(&models.BalanceShard{}).Where("balance_id = ?", id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrNotSharded
+		}
+		first := shardCursor.Add(1)
+		shardFor := func(o *UpdateOutcome) int {
+			return int((first + uint64(o.Attempts)) % uint64(count))
+		}
+
+		var err error
+		outcome, err = u.retry(Attempt{BalanceID: id, Delta: delta},
+			func(o *UpdateOutcome) error { return u.updateShardOnce(id, shardFor(o), delta, o) },
+			func(o *UpdateOutcome) error { return u.updateShardLocked(id, shardFor(o), delta, o) })
+		return err
+	})
+	u.record(outcome, err, start)
+	return outcome, err
+}
+
+// updateShardOnce reads one shard and writes it back guarded by its version
+func (u *Updater) updateShardOnce(id uint, shard int, delta int64, outcome *UpdateOutcome) error {
+	var row models.BalanceShard
+	if err := u.db.Where("balance_id = ? AND shard = ?", id, shard).First(&row).Error; err != nil {
+		return notFound(err)
+	}
+	return writeShard(u.db, row, delta, outcome)
+}
+
+// updateShardLocked writes one shard under a row lock
+func (u *Updater) updateShardLocked(id uint, shard int, delta int64, outcome *UpdateOutcome) error {
+	return u.db.Transaction(func(tx *gorm.DB) error {
+		var row models.BalanceShard
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("balance_id = ? AND shard = ?", id, shard).First(&row).Error; err != nil {
+			return err
+		}
+		err := writeShard(tx, row, delta, outcome)
+		var retryable retryableError
+		if errors.As(err, &retryable) {
+			return retryable.err
+		}
+		return err
+	})
+}
+
+// writeShard is writeVersioned for a shard row
+func writeShard(tx *gorm.DB, row models.BalanceShard, delta int64, outcome *UpdateOutcome) error {
+	result := tx.Model(&models.BalanceShard{}).Where("id = ? AND version = ?", row.ID, row.Version).
+		Updates(map[string]any{"amount": row.Amount + delta, "version": row.Version + 1})
+	if result.Error != nil {
+		return retryableError{result.Error}
+	}
+	if result.RowsAffected == 0 {
+		return conflictOrMissing(tx, &models.BalanceShard{}, row.ID)
+	}
+	outcome.PreviousAmount = row.Amount
+	outcome.NewAmount = row.Amount + delta
+	outcome.Version = row.Version + 1
+	return nil
+}
+
+// RebalanceShards evens out the shards of balance id, keeping the total.
+// The shards are locked in shard order and every changed shard gets a new
+// version, so writers holding an old read conflict and retry. It reports
+// whether anything changed.
+func RebalanceShards(db *gorm.DB, id uint) (bool, error) {
+	changed := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var shards []models.BalanceShard
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("balance_id = ?", id).Order("shard").Find(&shards).Error; err != nil {
+			return err
+		}
+		if len(shards) == 0 {
+			return ErrNotSharded
+		}
+		var total int64
+		for _, s := range shards {
+			total += s.Amount
+		}
+		for i, share := range splitAmount(total, len(shards)) {
+			s := shards[i]
+			if s.Amount == share {
+				continue
+			}
+			result := tx.Model(&models.BalanceShard{}).Where("id = ? AND version = ?", s.ID, s.Version).
+				Updates(map[string]any{"amount": share, "version": s.Version + 1})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrConflict
+			}
+			changed = true
+		}
+		return nil
+	})
+	return changed && err == nil, err
+}
+
+// splitAmount divides amount into n shares that differ by at most one
+func splitAmount(amount int64, n int) []int64 {
+	shares := make([]int64, n)
+	base, rest := amount/int64(n), amount%int64(n)
+	for i := range shares {
+		shares[i] = base
+		// A negative remainder is spread as -1s, so shares still sum to amount
+		switch {
+		case rest > 0 && int64(i) < rest:
+			shares[i]++
+		case rest < 0 && int64(i) < -rest:
+			shares[i]--
+		}
+	}
+	return shares
+}
+
+// ShardRebalancer evens out sharded balances whose shards drifted apart
+type ShardRebalancer struct {
+	db *gorm.DB
+	// maxSpread is the largest difference between two shards left alone
+	maxSpread int64
+}
+
+// NewShardRebalancer returns a rebalancer for balances whose largest and
+// smallest shard differ by more than maxSpread
+func NewShardRebalancer(db *gorm.DB, maxSpread int64) *ShardRebalancer {
+	return &ShardRebalancer{db: db, maxSpread: maxSpread}
+}
+
+// RebalanceOnce rebalances every skewed balance and returns how many it changed
+func (r *ShardRebalancer) RebalanceOnce(ctx context.Context) (int, error) {
+	var ids []uint
+	err := r.db.WithContext(ctx).Model(&models.BalanceShard{}).
+		Group("balance_id").Having("MAX(amount) - MIN(amount) > ?", r.maxSpread).
+		Pluck("balance_id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+
+	rebalanced := 0
+	var errs []error
+	for _, id := range ids {
+		changed, err := RebalanceShards(r.db.WithContext(ctx), id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("balance %d: %w", id, err))
+		}
+		if changed {
+			rebalanced++
+		}
+	}
+	return rebalanced, errors.Join(errs...)
+}
+
+// Run rebalances every interval until ctx is cancelled. Errors are passed to
+// onError, which may be nil.
+func (r *ShardRebalancer) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	for {
+		if _, err := r.RebalanceOnce(ctx); err != nil && ctx.Err() == nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
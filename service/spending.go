@@ -0,0 +1,72 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// periodicLimits are the kinds of BalanceLimit WithSpendingLimits enforces
+var periodicLimits = []string{models.LimitDailyDebit, models.LimitMonthlyDebit}
+
+// WithSpendingLimits enforces the periodic limits of each balance, such as a
+// daily_debit cap: a debit that would take what was debited in the current
+// UTC day or month past the limit fails with ErrLimitExceeded, without
+// retrying. The debit is counted in the same transaction as the versioned
+// write of the balance, after it; a concurrent debit of the same balance
+// conflicts on the version and is counted only when it commits, so two
+// debits cannot both slip under the cap. Checking the limit before the
+// update instead would let them.
+func WithSpendingLimits() Option {
+	return func(u *Updater) {
+		u.spendingLimits = true
+	}
+}
+
+// periodStart returns the start of the period containing t of a periodic
+// limit kind
+func periodStart(kind string, t time.Time) time.Time {
+	t = t.UTC()
+	if kind == models.LimitMonthlyDebit {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// usedIn returns what limit counted in the period starting at start
+func usedIn(limit models.BalanceLimit, start time.Time) int64 {
+	if limit.PeriodStart == nil || !limit.PeriodStart.Equal(start) {
+		return 0
+	}
+	return limit.Used
+}
+
+// chargeLimits counts a debit of balance id against its periodic limits. It
+// runs in the transaction that wrote the balance, so the version check of
+// that write serializes the charges of one balance.
+func chargeLimits(tx *gorm.DB, id uint, delta int64) error {
+	if delta >= 0 {
+		return nil
+	}
+	var limits []models.BalanceLimit
+	if err := tx.Where("balance_id = ? AND kind IN ?", id, periodicLimits).Find(&limits).Error; err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, limit := range limits {
+		start := periodStart(limit.Kind, now)
+		used := usedIn(limit, start) - delta
+		if used > limit.Amount {
+			return fmt.Errorf("%w: %s of %d, %d used, debit of %d", ErrLimitExceeded, limit.Kind, limit.Amount, used+delta, -delta)
+		}
+		err := tx.Model(&models.BalanceLimit{}).Where("id = ?", limit.ID).
+			Updates(map[string]any{"used": used, "period_start": start}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
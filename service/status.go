@@ -0,0 +1,149 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+var (
+	// ErrNotActive is wrapped by the *StatusError an update of a frozen or
+	// closed balance returns
+	ErrNotActive = errors.New("balance is not active")
+
+	// ErrInvalidTransition is returned for a status change the balance's
+	// current status does not allow, such as unfreezing a closed balance
+	ErrInvalidTransition = errors.New("invalid balance status transition")
+)
+
+// StatusError is returned when an update is refused because the balance is
+// frozen or closed. It wraps ErrNotActive and is never retried.
+type StatusError struct {
+	BalanceID uint
+	Status    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("balance %d is %s", e.BalanceID, e.Status)
+}
+
+func (e *StatusError) Unwrap() error { return ErrNotActive }
+
+// checkActive returns a *StatusError unless balance is active
+func checkActive(balance models.Balance) error {
+	if balance.Status == models.BalanceActive || balance.Status == "" {
+		return nil
+	}
+	return &StatusError{BalanceID: balance.ID, Status: balance.Status}
+}
+
+// missedWrite tells why a versioned write of balance id, guarded by its
+// status too, matched no row: ErrNotFound, a *StatusError or ErrConflict
+func missedWrite(tx *gorm.DB, id uint) error {
+	var balance models.Balance
+	if err := tx.Select("id", "status").First(&balance, id).Error; err != nil {
+		if err = notFound(err); err == ErrNotFound {
+			return err
+		}
+		return retryableError{err}
+	}
+	if err := checkActive(balance); err != nil {
+		return err
+	}
+	return ErrConflict
+}
+
+// transitions lists the statuses each status can move to
+var transitions = map[string][]string{
+	models.BalanceActive: {models.BalanceFrozen, models.BalanceClosed},
+	models.BalanceFrozen: {models.BalanceActive, models.BalanceClosed},
+}
+
+// Freeze stops all updates of an active balance until Unfreeze
+func Freeze(db *gorm.DB, id uint) (models.Balance, error) {
+	return transition(db, id, models.BalanceFrozen)
+}
+
+// Unfreeze lets a frozen balance take updates again
+func Unfreeze(db *gorm.DB, id uint) (models.Balance, error) {
+	return transition(db, id, models.BalanceActive)
+}
+
+// Close stops all updates of an active or frozen balance for good
+func Close(db *gorm.DB, id uint) (models.Balance, error) {
+	return transition(db, id, models.BalanceClosed)
+}
+
+// Freeze is Freeze on the database of the Updater, asking its Authorizer first
+func (u *Updater) Freeze(id uint) (models.Balance, error) {
+	return u.transition(id, models.BalanceFrozen, WriteFreeze)
+}
+
+// Unfreeze is Unfreeze on the database of the Updater, asking its Authorizer first
+func (u *Updater) Unfreeze(id uint) (models.Balance, error) {
+	return u.transition(id, models.BalanceActive, WriteUnfreeze)
+}
+
+// Close is Close on the database of the Updater, asking its Authorizer first
+func (u *Updater) Close(id uint) (models.Balance, error) {
+	return u.transition(id, models.BalanceClosed, WriteClose)
+}
+
+func (u *Updater) transition(id uint, to, kind string) (models.Balance, error) {
+	if err := u.authorize(id, WriteOp{Kind: kind}); err != nil {
+		return models.Balance{}, err
+	}
+	if !u.eventSourced {
+		return transition(u.db, id, to)
+	}
+
+	var balance models.Balance
+	err := u.db.Transaction(func(tx *gorm.DB) error {
+		var changed bool
+		var err error
+		if balance, changed, err = changeStatus(tx, id, to); err != nil || !changed {
+			return err
+		}
+		return appendMarker(tx, balance, models.BalanceEventStatus)
+	})
+	return balance, err
+}
+
+// transition moves balance id to status to and returns it. The write is
+// guarded by the status it moves from and bumps the version, so an update
+// that read the balance before the change conflicts, reads it again and is
+// refused. Moving a balance to the status it has already succeeds without
+// writing.
+func transition(db *gorm.DB, id uint, to string) (models.Balance, error) {
+	balance, _, err := changeStatus(db, id, to)
+	return balance, err
+}
+
+// changeStatus is transition, also reporting whether it wrote
+func changeStatus(db *gorm.DB, id uint, to string) (models.Balance, bool, error) {
+	var from []string
+	for status, next := range transitions {
+		for _, n := range next {
+			if n == to {
+				from = append(from, status)
+			}
+		}
+	}
+
+	result := db.Model(&models.Balance{}).Where("id = ? AND status IN ?", id, from).
+		Updates(map[string]any{"status": to, "version": gorm.Expr("version + 1")})
+	if result.Error != nil {
+		return models.Balance{}, false, result.Error
+	}
+	balance, err := GetBalance(db, id)
+	if err != nil {
+		return models.Balance{}, false, notFound(err)
+	}
+	if result.RowsAffected == 0 && balance.Status != to {
+		return balance, false, fmt.Errorf("%w: balance %d is %s, cannot become %s", ErrInvalidTransition, id, balance.Status, to)
+	}
+	return balance, result.RowsAffected > 0, nil
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// BalanceStore is the storage an optimistic update needs: a read and a
+// version-checked write. GormStore keeps balances in SQL, MemoryStore in a
+// map for unit tests, and kvstore.Store in a bbolt file.
+type BalanceStore interface {
+	// Get returns the balance, or ErrNotFound
+	Get(id uint) (models.Balance, error)
+	// CompareAndSwap stores amount and bumps the version if the balance is
+	// still at expectedVersion. It reports false if the version moved and
+	// returns ErrNotFound if the balance is gone.
+	CompareAndSwap(id uint, expectedVersion int, amount int64) (bool, error)
+}
+
+var (
+	_ BalanceStore = (*GormStore)(nil)
+	_ BalanceStore = (*MemoryStore)(nil)
+)
+
+// WithStore has UpdateBalance read and write through store instead of the
+// updater's *gorm.DB, which may then be nil. The retry policy, hooks,
+// metrics and cache work as usual; events, the outbox, conflict audits and
+// event sourcing need the database and are skipped. A store has no row
+// locks, so the pessimistic fallback makes one last compare-and-swap and
+// returns ErrRetryExhausted if that conflicts too. Policies and spending
+// limits run in the transaction of the write, which a store does not have,
+// so UpdateBalance returns ErrChecksUnsupported with either. The other
+// Updater methods still use the database.
+func WithStore(store BalanceStore) Option {
+	return func(u *Updater) {
+		u.store = store
+	}
+}
+
+// updateStore reads the balance from u.store and swaps in the new amount
+func (u *Updater) updateStore(id uint, delta int64, outcome *UpdateOutcome) error {
+	balance, err := u.store.Get(id)
+	if err != nil {
+		return notFound(err)
+	}
+	if err := checkActive(balance); err != nil {
+		return err
+	}
+
+	swapped, err := u.store.CompareAndSwap(id, balance.Version, balance.Amount+delta)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return ErrNotFound
+	case err != nil:
+		return retryableError{err}
+	case !swapped:
+		return ErrConflict
+	}
+
+	outcome.PreviousAmount = balance.Amount
+	outcome.NewAmount = balance.Amount + delta
+	outcome.Version = balance.Version + 1
+	return nil
+}
+
+// updateStoreLast is the pessimistic fallback of updateStore
+func (u *Updater) updateStoreLast(id uint, delta int64, outcome *UpdateOutcome) error {
+	err := u.updateStore(id, delta, outcome)
+	var retryable retryableError
+	switch {
+	case err == ErrConflict:
+		outcome.Conflicts++
+		return ErrRetryExhausted
+	case errors.As(err, &retryable):
+		return retryable.err
+	}
+	return err
+}
+
+// GormStore is the BalanceStore over a GORM database
+type GormStore struct {
+	db *gorm.DB
+}
+
+// NewGormStore returns a store on the balances table of db
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+// Get returns the balance, or ErrNotFound
+func (s *GormStore) Get(id uint) (models.Balance, error) {
+	var balance models.Balance
+	if err := s.db.First(&balance, id).Error; err != nil {
+		return models.Balance{}, notFound(err)
+	}
+	return balance, nil
+}
+
+// CompareAndSwap writes amount guarded by expectedVersion
+func (s *GormStore) CompareAndSwap(id uint, expectedVersion int, amount int64) (bool, error) {
+	result := s.db.Model(&models.Balance{}).Where("id = ? AND version = ?", id, expectedVersion).Updates(map[string]any{
+		"amount":  amount,
+		"version": expectedVersion + 1,
+	})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	var retryable retryableError
+	switch err := conflictOrMissing(s.db, &models.Balance{}, id); {
+	case err == ErrConflict:
+		return false, nil
+	case errors.As(err, &retryable):
+		return false, retryable.err
+	default:
+		return false, err
+	}
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// Strategy is how UpdateBalance keeps concurrent writers of a balance apart
+type Strategy string
+
+const (
+	// StrategyOptimistic writes guarded by the version column and retries
+	// on a conflict. It is the default.
+	StrategyOptimistic Strategy = "optimistic"
+
+	// StrategySerializable reads and writes in a SERIALIZABLE transaction
+	// without checking the version, and retries when the database aborts it
+	// with a serialization failure (SQLSTATE 40001)
+	StrategySerializable Strategy = "serializable"
+
+	// StrategyAdvisory takes pg_advisory_xact_lock on a fixed class key and
+	// the balance ID before reading it, so writers of a balance queue in Postgres instead of
+	// conflicting. Other drivers fail with ErrStrategyUnsupported.
+	StrategyAdvisory Strategy = "advisory"
+
+	// StrategyForUpdate reads the balance with SELECT ... FOR UPDATE, as the
+	// pessimistic fallback does, on every attempt
+	StrategyForUpdate Strategy = "for_update"
+
+	// StrategyAtomic adds the delta in a single UPDATE without reading the
+	// balance or checking its version, so it never conflicts
+	StrategyAtomic Strategy = "atomic"
+)
+
+// ErrStrategyUnsupported is returned by an update whose Strategy the database
+// cannot run
+var ErrStrategyUnsupported = errors.New("strategy not supported by the database")
+
+// ParseStrategy parses a strategy name; the empty string means StrategyOptimistic
+func ParseStrategy(s string) (Strategy, error) {
+	switch st := Strategy(s); st {
+	case "":
+		return StrategyOptimistic, nil
+	case StrategyOptimistic, StrategySerializable, StrategyAdvisory, StrategyForUpdate, StrategyAtomic:
+		return st, nil
+	}
+	return "", fmt.Errorf("unknown strategy %q: want %s", s, strings.Join(StrategyNames(), ", "))
+}
+
+// StrategyNames returns the names of the strategies, the default first
+func StrategyNames() []string {
+	return []string{string(StrategyOptimistic), string(StrategySerializable), string(StrategyAdvisory), string(StrategyForUpdate), string(StrategyAtomic)}
+}
+
+// WithStrategy makes the attempts of UpdateBalance use s, so strategies can be
+// compared behind the same API, e.g. per call with
+// u.With(WithStrategy(StrategySerializable)).UpdateBalance(id, delta). The
+// retry policy, hooks and outcome are the same for every strategy; a
+// serialization failure counts as a conflict. Updates through WithStore
+// ignore it.
+func WithStrategy(s Strategy) Option {
+	return func(u *Updater) {
+		u.strategy = s
+	}
+}
+
+// updateSerializable reads the balance and writes it back in a SERIALIZABLE
+// transaction. The version is bumped but not checked: the database aborts one
+// of two transactions that would otherwise both write the amount they read,
+// and the bump still makes optimistic writers of the balance conflict.
+func (u *Updater) updateSerializable(id uint, delta int64, outcome *UpdateOutcome) error {
+	err := u.db.Transaction(func(tx *gorm.DB) error {
+		var balance models.Balance
+		if err := tx.First(&balance, id).Error; err != nil {
+			return notFound(err)
+		}
+		if err := checkActive(balance); err != nil {
+			return err
+		}
+		result := tx.Model(&models.Balance{}).Where("id = ?", id).
+			Updates(map[string]any{"amount": balance.Amount + delta, "version": balance.Version + 1})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		outcome.PreviousAmount = balance.Amount
+		outcome.NewAmount = balance.Amount + delta
+		outcome.Version = balance.Version + 1
+		return u.afterWrite(tx, id, delta, outcome)
+	}, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		outcome.NewAmount, outcome.Version = 0, 0
+	}
+	if serializationFailure(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+// balanceLockClass is the first key of the advisory locks of balances, so
+// they share no keys with other advisory locks taken on the database
+var balanceLockClass = func() int32 {
+	h := fnv.New32a()
+	h.Write([]byte("optimistic-lock/balances"))
+	return int32(h.Sum32() >> 1)
+}()
+
+// updateAdvisory takes the transaction-level advisory lock of balance id, then
+// reads and writes it in the same transaction. The lock queues the writers
+// using this strategy behind each other; the write is still guarded by the
+// version against those that do not, and conflicts with them.
+func (u *Updater) updateAdvisory(id uint, delta int64, outcome *UpdateOutcome) error {
+	if name := u.db.Dialector.Name(); name != "postgres" {
+		return fmt.Errorf("%w: advisory locks need postgres, not %s", ErrStrategyUnsupported, name)
+	}
+	err := u.db.Transaction(func(tx *gorm.DB) error {
+		// Released when the transaction ends. The second key holds the low
+		// 32 bits of the ID; IDs 2^32 apart share a lock, which only queues
+		// them behind each other.
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?, ?)", balanceLockClass, int32(uint32(id))).Error; err != nil {
+			return err
+		}
+		var balance models.Balance
+		if err := tx.First(&balance, id).Error; err != nil {
+			return notFound(err)
+		}
+		if err := checkActive(balance); err != nil {
+			return err
+		}
+		if err := writeVersioned(tx, balance, delta, outcome); err != nil {
+			return err
+		}
+		return u.afterWrite(tx, id, delta, outcome)
+	})
+	if err != nil {
+		outcome.NewAmount, outcome.Version = 0, 0
+	}
+	return err
+}
+
+// updateAtomic adds delta to balance id with amount = amount + delta and reads
+// the result back in the same transaction, where the write holds the row
+func (u *Updater) updateAtomic(id uint, delta int64, outcome *UpdateOutcome) error {
+	err := u.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Balance{}).Where("id = ? AND status = ?", id, models.BalanceActive).
+			Updates(map[string]any{"amount": gorm.Expr("amount + ?", delta), "version": gorm.Expr("version + 1")})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return missedWrite(tx, id)
+		}
+		var balance models.Balance
+		if err := tx.Select("amount", "version").First(&balance, id).Error; err != nil {
+			return err
+		}
+		outcome.PreviousAmount = balance.Amount - delta
+		outcome.NewAmount = balance.Amount
+		outcome.Version = balance.Version
+		return u.afterWrite(tx, id, delta, outcome)
+	})
+	if err != nil {
+		outcome.NewAmount, outcome.Version = 0, 0
+	}
+	return err
+}
+
+// serializationFailure reports whether the database aborted a transaction
+// with SQLSTATE 40001 because it could not be serialized with another
+func serializationFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "40001"
+	}
+	m := mysqlError.FindStringSubmatch(err.Error())
+	return m != nil && m[2] == "40001"
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"github.com/ghozilaaa/optimistic-lock/encryption"
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// VersionType is how a concurrency column of a legacy schema moves on
+type VersionType string
+
+const (
+	// VersionCounter is an integer of any width incremented on every write,
+	// such as version, lock_version or row_version
+	VersionCounter VersionType = "counter"
+
+	// VersionTimestamp is a timestamp set to the time of every write, with
+	// microsecond precision. The store reports it as Unix microseconds in
+	// Balance.Version; the Version of an UpdateOutcome is then not the
+	// stored one, read the balance again for it.
+	VersionTimestamp VersionType = "timestamp"
+)
+
+// Table names the table and columns of balances in an existing schema. Empty
+// names default to those of models.Balance.
+type Table struct {
+	Name        string // balances
+	ID          string // id
+	Amount      string // amount
+	Version     string // version, or e.g. lock_version or row_version
+	VersionType VersionType
+
+	// Codec, when set, opens and seals the amount column, which then holds
+	// amounts sealed by it rather than integers
+	Codec *encryption.Codec
+}
+
+// binding is where the sealed amount of row id is stored
+func (t Table) binding(id uint) encryption.Binding {
+	return encryption.Binding{Table: t.Name, Column: t.Amount, RowID: uint64(id)}
+}
+
+// TableOf derives the Table of model from its GORM tags: its table name,
+// primary key, the column of amountField and the column of the field tagged
+// `gorm:"version"`. The version is a VersionTimestamp if that field is a
+// time.Time, else a VersionCounter; `gorm:"version:timestamp"` or
+// `gorm:"version:counter"` overrides the type. An amount field tagged
+// `gorm:"serializer:encrypted_amount"` sets the Codec of the registered
+// encryption.Serializer.
+func TableOf(db *gorm.DB, model any, amountField string) (Table, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return Table{}, err
+	}
+	s := stmt.Schema
+	if s.PrioritizedPrimaryField == nil {
+		return Table{}, fmt.Errorf("%s has no single primary key", s.Name)
+	}
+	amount := s.LookUpField(amountField)
+	if amount == nil {
+		return Table{}, fmt.Errorf("%s has no field %s", s.Name, amountField)
+	}
+
+	t := Table{Name: s.Table, ID: s.PrioritizedPrimaryField.DBName, Amount: amount.DBName}
+	if sealed, ok := amount.Serializer.(encryption.Serializer); ok {
+		t.Codec = sealed.Codec
+	}
+	for _, f := range s.Fields {
+		typ, ok := f.TagSettings["VERSION"]
+		if !ok {
+			continue
+		}
+		if t.Version != "" {
+			return Table{}, fmt.Errorf("%s has more than one version field", s.Name)
+		}
+		t.Version = f.DBName
+		switch VersionType(typ) {
+		case VersionCounter, VersionTimestamp:
+			t.VersionType = VersionType(typ)
+		case "VERSION":
+			// A bare version tag; the type follows the field
+			t.VersionType = VersionCounter
+			if f.FieldType == reflect.TypeOf(time.Time{}) || f.DataType == schema.Time {
+				t.VersionType = VersionTimestamp
+			}
+		default:
+			return Table{}, fmt.Errorf("%s.%s: unknown version type %q", s.Name, f.Name, typ)
+		}
+	}
+	if t.Version == "" {
+		return Table{}, fmt.Errorf(`%s has no field tagged gorm:"version"`, s.Name)
+	}
+	return t, nil
+}
+
+// TableStore is a BalanceStore over a table whose names differ from those of
+// models.Balance, so an Updater WithStore can run against a legacy schema
+// that already has its own concurrency column. Rows are not soft-deleted or
+// scoped to tenants.
+type TableStore struct {
+	db    *gorm.DB
+	table Table
+}
+
+var _ BalanceStore = (*TableStore)(nil)
+
+// NewTableStore returns a store on the table t of db
+func NewTableStore(db *gorm.DB, t Table) (*TableStore, error) {
+	defaults := []struct {
+		name *string
+		def  string
+	}{{&t.Name, "balances"}, {&t.ID, "id"}, {&t.Amount, "amount"}, {&t.Version, "version"}}
+	for _, d := range defaults {
+		if *d.name == "" {
+			*d.name = d.def
+		}
+	}
+	switch t.VersionType {
+	case "":
+		t.VersionType = VersionCounter
+	case VersionCounter, VersionTimestamp:
+	default:
+		return nil, fmt.Errorf("unknown version type %q", t.VersionType)
+	}
+	return &TableStore{db: db, table: t}, nil
+}
+
+// tableRow is a row read by TableStore
+type tableRow struct {
+	ID      uint
+	Amount  int64
+	Sealed  string
+	Version int64
+	Stamp   time.Time
+}
+
+// Get returns the balance, or ErrNotFound
+func (s *TableStore) Get(id uint) (models.Balance, error) {
+	t := s.table
+	amount, version := "? AS amount", "? AS version"
+	if t.Codec != nil {
+		amount = "? AS sealed"
+	}
+	if t.VersionType == VersionTimestamp {
+		version = "? AS stamp"
+	}
+	var row tableRow
+	err := s.db.Table(t.Name).
+		Select("? AS id, "+amount+", "+version, clause.Column{Name: t.ID}, clause.Column{Name: t.Amount}, clause.Column{Name: t.Version}).
+		Where("? = ?", clause.Column{Name: t.ID}, id).
+		Take(&row).Error
+	if err != nil {
+		return models.Balance{}, notFound(err)
+	}
+	if t.Codec != nil {
+		if row.Amount, err = t.Codec.Open(row.Sealed, t.binding(id)); err != nil {
+			return models.Balance{}, fmt.Errorf("balance %d: %w", id, err)
+		}
+	}
+	if t.VersionType == VersionTimestamp {
+		row.Version = row.Stamp.UnixMicro()
+	}
+	return models.Balance{ID: row.ID, Amount: row.Amount, Version: int(row.Version)}, nil
+}
+
+// CompareAndSwap writes amount guarded by expectedVersion, as returned by Get
+func (s *TableStore) CompareAndSwap(id uint, expectedVersion int, amount int64) (bool, error) {
+	t := s.table
+	var expected, next any = expectedVersion, expectedVersion + 1
+	if t.VersionType == VersionTimestamp {
+		// Two writes within the same microsecond must still differ
+		was := time.UnixMicro(int64(expectedVersion)).UTC()
+		now := time.Now().UTC().Truncate(time.Microsecond)
+		if !now.After(was) {
+			now = was.Add(time.Microsecond)
+		}
+		expected, next = was, now
+	}
+
+	var value any = amount
+	if t.Codec != nil {
+		value = t.Codec.Seal(amount, t.binding(id))
+	}
+	result := s.db.Table(t.Name).
+		Where("? = ? AND ? = ?", clause.Column{Name: t.ID}, id, clause.Column{Name: t.Version}, expected).
+		Updates(map[string]any{t.Amount: value, t.Version: next})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	var count int64
+	if err := s.db.Table(t.Name).Where("? = ?", clause.Column{Name: t.ID}, id).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count == 0 {
+		return false, ErrNotFound
+	}
+	return false, nil
+}
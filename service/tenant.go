@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/tenant"
+)
+
+// WithTenant scopes every read and write of the Updater to the balances of
+// tenant id, as if its database handle carried tenant.With(ctx, id). Derive a
+// per-tenant copy with u.With(WithTenant(id)) to share the rest of the
+// Updater between tenants.
+func WithTenant(id string) Option {
+	return func(u *Updater) {
+		if u.db != nil {
+			u.db = tenant.Scope(u.db, id)
+		}
+	}
+}
+
+// tenantScoped reports whether statements on db are scoped to a tenant. The
+// paths that bypass GORM callbacks, the raw SQL attempts and the cache, which
+// is keyed by balance ID alone, are skipped for such handles.
+func tenantScoped(db *gorm.DB) bool {
+	if db == nil {
+		return false
+	}
+	_, ok := tenant.FromContext(db.Statement.Context)
+	return ok
+}
+
+// tenantOf returns the tenant statements on db are scoped to, or "" if none
+func tenantOf(db *gorm.DB) string {
+	if db == nil {
+		return ""
+	}
+	id, _ := tenant.FromContext(db.Statement.Context)
+	return id
+}
+
+// tenantContext returns ctx carrying the tenant of db, if it is scoped to one,
+// so db.WithContext(ctx) stays scoped to it
+func tenantContext(ctx context.Context, db *gorm.DB) context.Context {
+	if id, ok := tenant.FromContext(db.Statement.Context); ok {
+		return tenant.With(ctx, id)
+	}
+	return ctx
+}
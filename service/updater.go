@@ -0,0 +1,440 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ghozilaaa/optimistic-lock/cache"
+	"github.com/ghozilaaa/optimistic-lock/events"
+	"github.com/ghozilaaa/optimistic-lock/internal/backoff"
+	"github.com/ghozilaaa/optimistic-lock/logging"
+	"github.com/ghozilaaa/optimistic-lock/metrics"
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+// Updater applies version-checked balance updates with retry
+type Updater struct {
+	db                *gorm.DB
+	maxAttempts       int
+	baseBackoff       time.Duration
+	timeout           time.Duration
+	adaptive          *AdaptiveBackoff
+	contention        *ContentionMonitor
+	spendingLimits    bool
+	policies          []Policy
+	reserved          bool
+	faults            *FaultInjector
+	rawSQL            bool
+	store             BalanceStore
+	pessimisticAfter  int
+	serializer        *KeyedSerializer
+	breaker           *CircuitBreaker
+	limiter           *rateLimiter
+	hooks             []Hooks
+	recorder          metrics.Metrics
+	logger            logging.Logger
+	writeCheck        func() error
+	publisher         events.Publisher
+	outbox            bool
+	notify            bool
+	eventSourced      bool
+	conflictSink      ConflictSink
+	onAuditError      func(error)
+	deadLetter        DeadLetterSink
+	onDeadLetterError func(error)
+	actor             string
+	cache             *cache.Cache
+	authorizer        Authorizer
+	ctx               context.Context
+	metadataMerge     bool
+	merge             Merge
+	classifier        ErrorClassifier
+	statementTimeout  time.Duration
+	strategy          Strategy
+}
+
+// Option configures an Updater
+type Option func(*Updater)
+
+// NewUpdater returns an Updater with the default retry policy: 5 attempts
+// starting at a 10ms backoff that doubles on every conflict.
+func NewUpdater(db *gorm.DB, opts ...Option) *Updater {
+	u := &Updater{
+		db:          db,
+		maxAttempts: 5,
+		baseBackoff: 10 * time.Millisecond,
+		logger:      logging.Nop{},
+	}
+	for _, opt := range opts {
+		opt(u)
+	}
+	return u
+}
+
+// WithMaxAttempts sets how many optimistic attempts are made before giving up
+func WithMaxAttempts(n int) Option {
+	return func(u *Updater) {
+		if n > 0 {
+			u.maxAttempts = n
+		}
+	}
+}
+
+// WithBaseBackoff sets the backoff after the first conflict
+func WithBaseBackoff(d time.Duration) Option {
+	return func(u *Updater) {
+		u.baseBackoff = d
+	}
+}
+
+// WithNoBackoff retries a conflict at once instead of sleeping first
+func WithNoBackoff() Option {
+	return func(u *Updater) {
+		u.baseBackoff = 0
+	}
+}
+
+// WithTimeout bounds the time spent retrying. The retry loop gives up with
+// ErrDeadlineExceeded instead of sleeping past the budget; an attempt that
+// has started is not interrupted. Zero, the default, disables the budget.
+func WithTimeout(budget time.Duration) Option {
+	return func(u *Updater) {
+		u.timeout = budget
+	}
+}
+
+// WithPessimisticFallback switches to SELECT ... FOR UPDATE inside a
+// transaction once afterConflicts version conflicts have been seen, so an
+// update under extreme contention still makes progress instead of returning
+// ErrRetryExhausted. Zero disables the fallback.
+func WithPessimisticFallback(afterConflicts int) Option {
+	return func(u *Updater) {
+		u.pessimisticAfter = afterConflicts
+	}
+}
+
+// WithSerializer queues updates to the same balance ID behind each other in
+// this process before they reach the database. Share one serializer between
+// all updaters in the process; conflicts then only come from other processes.
+func WithSerializer(s *KeyedSerializer) Option {
+	return func(u *Updater) {
+		u.serializer = s
+	}
+}
+
+// WithCircuitBreaker fails updates fast with ErrCircuitOpen while the breaker
+// is open. Share one breaker between the updaters guarding the same database.
+func WithCircuitBreaker(cb *CircuitBreaker) Option {
+	return func(u *Updater) {
+		u.breaker = cb
+	}
+}
+
+// WithRateLimit rejects updates beyond the per-balance and global token-bucket
+// rates with ErrRateLimited. The buckets belong to the Updater, so reuse one
+// Updater for all callers that should share the limit.
+func WithRateLimit(opts RateLimitOptions) Option {
+	return func(u *Updater) {
+		u.limiter = newRateLimiter(opts)
+	}
+}
+
+// WithWriteCheck calls check before every update and returns its error
+// instead of writing, e.g. region.Controller.CheckWrite while a region is passive.
+func WithWriteCheck(check func() error) Option {
+	return func(u *Updater) {
+		u.writeCheck = check
+	}
+}
+
+// With returns a copy of the Updater with opts applied on top of its own, for
+// call sites that need a different retry policy, e.g.
+// u.With(WithMaxAttempts(1), WithNoBackoff()).UpdateBalance(id, delta) on a
+// latency-sensitive path. The copy shares the serializer, breaker, rate
+// limiter, cache and sinks of u; options that create state, such as
+// WithRateLimit, give the copy its own.
+func (u *Updater) With(opts ...Option) *Updater {
+	c := *u
+	c.hooks = append([]Hooks(nil), u.hooks...)
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &c
+}
+
+// UpdateBalance adds delta to the balance amount and bumps its version. The
+// outcome reports what happened even when an error is returned.
+func (u *Updater) UpdateBalance(id uint, delta int64) (UpdateOutcome, error) {
+	if u.store != nil {
+		if err := u.refuseUnchecked(id, "store update"); err != nil {
+			return UpdateOutcome{}, err
+		}
+	}
+	if err := u.authorize(id, WriteOp{Kind: WriteUpdate, Delta: delta}); err != nil {
+		return UpdateOutcome{}, err
+	}
+	start := time.Now()
+	var outcome UpdateOutcome
+	err := u.guard(id, func() error {
+		attempt := u.timed(func(u *Updater, o *UpdateOutcome) error { return u.updateOnce(id, delta, o) })
+		locked := u.timed(func(u *Updater, o *UpdateOutcome) error { return u.updateLocked(id, delta, o) })
+		switch {
+		case u.store != nil:
+			attempt = func(o *UpdateOutcome) error { return u.updateStore(id, delta, o) }
+			locked = func(o *UpdateOutcome) error { return u.updateStoreLast(id, delta, o) }
+		case u.strategy == StrategySerializable:
+			attempt = u.timed(func(u *Updater, o *UpdateOutcome) error { return u.updateSerializable(id, delta, o) })
+		case u.strategy == StrategyAdvisory:
+			attempt = u.timed(func(u *Updater, o *UpdateOutcome) error { return u.updateAdvisory(id, delta, o) })
+		case u.strategy == StrategyForUpdate:
+			attempt = locked
+		case u.strategy == StrategyAtomic:
+			attempt = u.timed(func(u *Updater, o *UpdateOutcome) error { return u.updateAtomic(id, delta, o) })
+		case u.rawSQL && !tenantScoped(u.db):
+			attempt = u.timed(func(u *Updater, o *UpdateOutcome) error { return u.updateRaw(id, delta, o) })
+		case supportsReturning(u.db):
+			version := u.knownVersion(id)
+			attempt = u.timed(func(u *Updater, o *UpdateOutcome) error { return u.updateReturning(id, delta, &version, o) })
+		}
+
+		var err error
+		outcome, err = u.retry(Attempt{BalanceID: id, Delta: delta}, attempt, locked)
+		return err
+	})
+	u.record(outcome, err, start)
+	u.cacheWrite(id, outcome, err)
+	return outcome, u.deadLettered("", id, delta, outcome, err)
+}
+
+// guard runs fn behind the write check, rate limiter, circuit breaker and serializer
+func (u *Updater) guard(id uint, fn func() error) error {
+	if u.writeCheck != nil {
+		if err := u.writeCheck(); err != nil {
+			return err
+		}
+	}
+
+	if u.limiter != nil && !u.limiter.allow(id) {
+		return ErrRateLimited
+	}
+
+	if u.breaker != nil {
+		if err := u.breaker.Allow(); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if u.serializer != nil {
+		err = u.serializer.Do(id, fn)
+	} else {
+		err = fn()
+	}
+
+	if u.breaker != nil {
+		u.breaker.Record(err)
+	}
+	return err
+}
+
+// retryableError marks a failed database operation, retried like a conflict if
+// it is transient
+type retryableError struct{ err error }
+
+func (e retryableError) Error() string { return e.err.Error() }
+
+// retry runs the optimistic retry loop. attempt makes one versioned
+// read-modify-write and returns ErrConflict when the version check fails, or
+// the error that failed it. A database error, wrapped in a retryableError or
+// not, is retried if the ErrorClassifier reports it transient; any other error
+// gives up at once.
+// locked finishes the update under a row lock once the pessimistic fallback
+// threshold is reached.
+func (u *Updater) retry(a Attempt, attempt, locked func(*UpdateOutcome) error) (UpdateOutcome, error) {
+	// Use a local random source for jitter to avoid global Seed usage
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	if u.faults != nil {
+		attempt = u.faults.wrap(attempt)
+	}
+
+	start := time.Now()
+	var outcome UpdateOutcome
+	var lastErr error
+	for n := 1; n <= u.maxAttempts; n++ {
+		outcome.Attempts = n
+		a.Number = n
+		if err := u.beforeAttempt(a); err != nil {
+			return outcome, err
+		}
+
+		err := attempt(&outcome)
+		if u.adaptive != nil && (err == nil || err == ErrConflict) {
+			u.adaptive.Record(a.BalanceID, err == ErrConflict)
+		}
+		if u.contention != nil && (err == nil || err == ErrConflict) {
+			u.contention.Record(a.BalanceID, err == ErrConflict)
+		}
+
+		// A database error is retried only if it is transient
+		transient := false
+		var retryable retryableError
+		if errors.As(err, &retryable) {
+			err = retryable.err
+			transient = u.retryable(err)
+			if !transient {
+				u.logger.Error("balance write failed, not retrying", logging.BalanceID, a.BalanceID, logging.Attempt, n, logging.Err, err)
+			}
+		} else if err != nil && err != ErrConflict {
+			transient = u.retryable(err)
+		}
+
+		switch {
+		case err == nil:
+			u.afterAttempt(a, nil)
+			return outcome, nil
+		case transient:
+			lastErr = err
+			u.afterAttempt(a, lastErr)
+			u.logger.Warn("balance write failed", logging.BalanceID, a.BalanceID, logging.Attempt, n, logging.Err, lastErr)
+		case err == ErrConflict:
+			// Conflict: version changed by another transaction
+			outcome.Conflicts++
+			lastErr = ErrRetryExhausted
+			u.afterAttempt(a, ErrConflict)
+			u.logger.Debug("version conflict", logging.BalanceID, a.BalanceID, logging.Attempt, n, logging.Delta, a.Delta)
+			if err := u.onConflict(a); err != nil {
+				return outcome, err
+			}
+
+			// Take the row lock instead of retrying (or failing) once the threshold is hit
+			if u.pessimisticAfter > 0 && (outcome.Conflicts >= u.pessimisticAfter || n == u.maxAttempts) {
+				outcome.Pessimistic = true
+				u.logger.Info("falling back to row lock", logging.BalanceID, a.BalanceID, logging.Conflicts, outcome.Conflicts)
+				return outcome, u.retryAfter(a.BalanceID, outcome, locked(&outcome))
+			}
+		default:
+			return outcome, err
+		}
+
+		// If we will retry, sleep with exponential backoff + jitter
+		if n < u.maxAttempts {
+			sleep := backoff.Exponential(rnd, u.baseBackoff, n)
+			if u.adaptive != nil {
+				sleep = time.Duration(float64(sleep) * u.adaptive.Scale(a.BalanceID))
+			}
+			if u.timeout > 0 && time.Since(start)+sleep > u.timeout {
+				u.logger.Warn("retry budget exceeded", logging.BalanceID, a.BalanceID, logging.Attempts, outcome.Attempts,
+					logging.Conflicts, outcome.Conflicts, logging.Backoff, outcome.Backoff)
+				if errors.Is(lastErr, ErrRetryExhausted) {
+					u.onExhausted(a.BalanceID, outcome)
+				}
+				return outcome, u.retryAfter(a.BalanceID, outcome, fmt.Errorf("%w after %d attempts: %w", ErrDeadlineExceeded, outcome.Attempts, lastErr))
+			}
+			outcome.Backoff += sleep
+			time.Sleep(sleep)
+		}
+	}
+
+	if errors.Is(lastErr, ErrRetryExhausted) {
+		u.logger.Warn("retries exhausted", logging.BalanceID, a.BalanceID, logging.Attempts, outcome.Attempts,
+			logging.Conflicts, outcome.Conflicts, logging.Backoff, outcome.Backoff)
+		u.onExhausted(a.BalanceID, outcome)
+	} else if lastErr != nil {
+		u.logger.Error("balance update failed", logging.BalanceID, a.BalanceID, logging.Attempts, outcome.Attempts, logging.Err, lastErr)
+	}
+	if lastErr != nil {
+		return outcome, u.retryAfter(a.BalanceID, outcome, lastErr)
+	}
+
+	return outcome, errors.New("update failed after retries")
+}
+
+// updateOnce reads the balance and writes it back guarded by its version
+func (u *Updater) updateOnce(id uint, delta int64, outcome *UpdateOutcome) error {
+	var balance models.Balance
+	if err := u.db.First(&balance, id).Error; err != nil {
+		return notFound(err)
+	}
+	if err := checkActive(balance); err != nil {
+		return err
+	}
+
+	err := u.commit(func(tx *gorm.DB) error {
+		return writeVersioned(tx, balance, delta, outcome)
+	}, id, delta, outcome)
+	if err == ErrConflict {
+		u.auditConflict(id, balance.Version, -1, delta, outcome.Attempts)
+	}
+	return err
+}
+
+// updateLocked reads the row with SELECT ... FOR UPDATE and writes it in the
+// same transaction. Concurrent optimistic writers still see the version bump.
+func (u *Updater) updateLocked(id uint, delta int64, outcome *UpdateOutcome) error {
+	return u.modifyLocked(id, func(models.Balance) (int64, error) { return delta, nil }, outcome)
+}
+
+// modifyLocked is updateLocked with the delta computed by deltaOf from the
+// locked row
+func (u *Updater) modifyLocked(id uint, deltaOf func(models.Balance) (int64, error), outcome *UpdateOutcome) error {
+	err := u.db.Transaction(func(tx *gorm.DB) error {
+		var balance models.Balance
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&balance, id).Error; err != nil {
+			return notFound(err)
+		}
+		if err := checkActive(balance); err != nil {
+			return err
+		}
+		delta, err := deltaOf(balance)
+		if err != nil {
+			return err
+		}
+
+		// ErrConflict is only possible when the driver ignores row locks (e.g. SQLite)
+		if err := writeVersioned(tx, balance, delta, outcome); err != nil {
+			var retryable retryableError
+			if errors.As(err, &retryable) {
+				return retryable.err
+			}
+			return err
+		}
+		return u.afterWrite(tx, id, delta, outcome)
+	})
+	if err != nil {
+		outcome.NewAmount, outcome.Version = 0, 0
+	}
+	return err
+}
+
+// writeVersioned writes balance.Amount+delta guarded by the version that was
+// read. It returns ErrConflict if the version moved, ErrNotFound if the row
+// was deleted, a *StatusError if it is no longer active and a retryableError
+// if the write failed; on success it fills in the outcome.
+func writeVersioned(tx *gorm.DB, balance models.Balance, delta int64, outcome *UpdateOutcome) error {
+	previous, version := balance.Amount, balance.Version
+
+	// Use UPDATE with WHERE clause to check version for optimistic locking. A
+	// map rather than a struct, so an amount of zero is written too.
+	result := tx.Model(&balance).Where("id = ? AND version = ? AND status = ?", balance.ID, version, models.BalanceActive).Updates(map[string]any{
+		"amount":  previous + delta,
+		"version": version + 1,
+	})
+	if result.Error != nil {
+		return retryableError{result.Error}
+	}
+	if result.RowsAffected == 0 {
+		return missedWrite(tx, balance.ID)
+	}
+
+	outcome.PreviousAmount = previous
+	outcome.NewAmount = previous + delta
+	outcome.Version = version + 1
+	return nil
+}
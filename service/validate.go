@@ -0,0 +1,79 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+var (
+	// ErrInsufficientFunds is reported when a debit would take a balance
+	// below zero, or below its min_amount limit
+	ErrInsufficientFunds = errors.New("insufficient funds")
+
+	// ErrLimitExceeded is reported when an update would break a limit of the
+	// balance, and returned by updates WithSpendingLimits for a debit past a
+	// periodic limit
+	ErrLimitExceeded = errors.New("limit exceeded")
+)
+
+// Validation is the outcome of an update as ValidateUpdate predicts it
+type Validation struct {
+	BalanceID  uint
+	Amount     int64 // as read
+	Version    int   // as read; pass it to UpdateIfVersion to apply exactly this update
+	NewAmount  int64
+	NewVersion int
+}
+
+// ValidateUpdate checks, without writing, what adding delta to the balance
+// would do, for pre-flight checks such as a checkout page. It returns
+// ErrNotFound for a missing balance. Otherwise it returns the expected post
+// state, with an error joining every rule the update breaks:
+// ErrInsufficientFunds for a debit below zero, or below the min_amount limit
+// of the balance, and ErrLimitExceeded for a credit above its max_amount
+// limit or a debit past what its periodic limits have left this period.
+//
+// The prediction holds as of the read. UpdateBalance does not check these
+// rules itself; to apply a validated update, pass Version to UpdateIfVersion,
+// which fails with ErrConflict if anything was written in between.
+func ValidateUpdate(db *gorm.DB, id uint, delta int64) (Validation, error) {
+	balance, err := GetBalanceAggregate(db, id)
+	if err != nil {
+		return Validation{}, err
+	}
+	v := Validation{
+		BalanceID:  id,
+		Amount:     balance.Amount,
+		Version:    balance.Version,
+		NewAmount:  balance.Amount + delta,
+		NewVersion: balance.Version + 1,
+	}
+
+	floor := int64(0)
+	now := time.Now()
+	var problems []error
+	for _, limit := range balance.Limits {
+		switch limit.Kind {
+		case models.LimitDailyDebit, models.LimitMonthlyDebit:
+			used := usedIn(limit, periodStart(limit.Kind, now))
+			if delta < 0 && used-delta > limit.Amount {
+				problems = append(problems, fmt.Errorf("%w: %s of %d, %d used", ErrLimitExceeded, limit.Kind, limit.Amount, used))
+			}
+		case models.LimitMinAmount:
+			floor = limit.Amount
+		case models.LimitMaxAmount:
+			if delta > 0 && v.NewAmount > limit.Amount {
+				problems = append(problems, fmt.Errorf("%w: %s %d, would be %d", ErrLimitExceeded, limit.Kind, limit.Amount, v.NewAmount))
+			}
+		}
+	}
+	if delta < 0 && v.NewAmount < floor {
+		problems = append(problems, fmt.Errorf("%w: %d available, debit of %d", ErrInsufficientFunds, balance.Amount-floor, -delta))
+	}
+	return v, errors.Join(problems...)
+}
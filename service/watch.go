@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/events"
+)
+
+// NotifyChannel is the Postgres channel balance changes are sent on
+const NotifyChannel = "balance_changes"
+
+// watchBuffer is how many changes a slow subscriber may fall behind before
+// further changes are dropped for it
+const watchBuffer = 64
+
+// WithNotify sends every integer balance update as a JSON
+// events.BalanceChanged on NotifyChannel with pg_notify, in the update's
+// transaction, so Postgres delivers it to listeners only once the update
+// commits. Postgres only.
+func WithNotify() Option {
+	return func(u *Updater) {
+		u.notify = true
+	}
+}
+
+// notify queues event for delivery to NotifyChannel listeners when tx commits
+func notify(tx *gorm.DB, event events.BalanceChanged) error {
+	return tx.Exec("SELECT pg_notify(?, ?)", NotifyChannel, string(event.AppendJSON(nil))).Error
+}
+
+// Watcher delivers the changes sent by WithNotify to subscribers of a
+// balance. It holds one connection that LISTENs on NotifyChannel while Run is
+// active, however many balances are watched.
+type Watcher struct {
+	db *gorm.DB
+
+	mu   sync.Mutex
+	subs map[uint]map[chan events.BalanceChanged]struct{}
+}
+
+// NewWatcher returns a Watcher listening through db, which must use the
+// Postgres (pgx) driver
+func NewWatcher(db *gorm.DB) *Watcher {
+	return &Watcher{db: db, subs: make(map[uint]map[chan events.BalanceChanged]struct{})}
+}
+
+// Watch returns a channel receiving the changes of balance id until ctx is
+// cancelled, when the channel is closed. Changes are not replayed: call
+// GetBalance after subscribing for the current state. A subscriber that falls
+// more than 64 changes behind misses the changes in between, which it can
+// detect from a gap in Version.
+func (w *Watcher) Watch(ctx context.Context, id uint) <-chan events.BalanceChanged {
+	ch := make(chan events.BalanceChanged, watchBuffer)
+
+	w.mu.Lock()
+	if w.subs[id] == nil {
+		w.subs[id] = make(map[chan events.BalanceChanged]struct{})
+	}
+	w.subs[id][ch] = struct{}{}
+	w.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subs[id], ch)
+		if len(w.subs[id]) == 0 {
+			delete(w.subs, id)
+		}
+		close(ch)
+	}()
+	return ch
+}
+
+// Run listens for changes until ctx is cancelled. A lost connection is
+// re-established after retryEvery (default 1s) and reported to onError, which
+// may be nil; changes committed while disconnected are not delivered.
+func (w *Watcher) Run(ctx context.Context, retryEvery time.Duration, onError func(error)) {
+	if retryEvery <= 0 {
+		retryEvery = time.Second
+	}
+	for {
+		err := w.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryEvery):
+		}
+	}
+}
+
+// listen holds a connection in LISTEN and dispatches notifications until ctx
+// is cancelled or the connection fails
+func (w *Watcher) listen(ctx context.Context) error {
+	sqlDB, err := w.db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("watch requires the pgx driver, got %T", driverConn)
+		}
+		pg := c.Conn()
+		if _, err := pg.Exec(ctx, "LISTEN "+NotifyChannel); err != nil {
+			return err
+		}
+		// Keep the connection from returning to the pool still subscribed
+		defer pg.Exec(context.Background(), "UNLISTEN "+NotifyChannel)
+
+		for {
+			n, err := pg.WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+			var event events.BalanceChanged
+			if err := json.Unmarshal([]byte(n.Payload), &event); err != nil {
+				continue
+			}
+			w.dispatch(event)
+		}
+	})
+}
+
+// dispatch sends event to the subscribers of its balance without blocking
+func (w *Watcher) dispatch(event events.BalanceChanged) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.subs[event.BalanceID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
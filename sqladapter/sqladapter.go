@@ -0,0 +1,117 @@
+// Package sqladapter applies the optimistic-lock CAS update and retry loop on
+// plain database/sql connections, for services that do not use GORM.
+package sqladapter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/internal/backoff"
+)
+
+var (
+	// ErrConflict is returned when the row version changed between read and write
+	ErrConflict = errors.New("conflict: balance updated by another transaction")
+
+	// ErrRetryExhausted is returned when every attempt ended in a version conflict
+	ErrRetryExhausted = fmt.Errorf("%w, retry exhausted", ErrConflict)
+
+	// ErrNotFound is returned when the select query matches no row
+	ErrNotFound = errors.New("balance not found")
+)
+
+// Conn is satisfied by *sql.DB, *sql.Tx and *sql.Conn
+type Conn interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Queries are the caller-supplied statements for one table
+type Queries struct {
+	// Select is called with (id) and must return (amount, version)
+	Select string
+	// Update is called with (newAmount, newVersion, id, expectedVersion) and
+	// must only match the row when its version still equals expectedVersion
+	Update string
+}
+
+// PostgresQueries targets the balances table created by the models package and
+// skips soft-deleted rows
+var PostgresQueries = Queries{
+	Select: "SELECT amount, version FROM balances WHERE id = $1 AND deleted_at IS NULL",
+	Update: "UPDATE balances SET amount = $1, version = $2 WHERE id = $3 AND version = $4 AND deleted_at IS NULL",
+}
+
+// MySQLQueries is PostgresQueries with "?" placeholders (also valid for SQLite)
+var MySQLQueries = Queries{
+	Select: "SELECT amount, version FROM balances WHERE id = ? AND deleted_at IS NULL",
+	Update: "UPDATE balances SET amount = ?, version = ? WHERE id = ? AND version = ? AND deleted_at IS NULL",
+}
+
+// Updater runs version-checked updates with exponential backoff between conflicts
+type Updater struct {
+	Queries     Queries
+	MaxAttempts int           // Attempts before returning ErrRetryExhausted (default 5)
+	BaseBackoff time.Duration // Backoff after the first conflict, doubled per attempt (default 10ms)
+}
+
+// New returns an Updater using the same retry policy as service.UpdateBalance
+func New(q Queries) *Updater {
+	return &Updater{
+		Queries:     q,
+		MaxAttempts: 5,
+		BaseBackoff: 10 * time.Millisecond,
+	}
+}
+
+// UpdateBalance adds delta to the amount of row id. Conn may be a *sql.Tx, in
+// which case every attempt runs inside the caller's transaction.
+func (u *Updater) UpdateBalance(ctx context.Context, conn Conn, id any, delta int64) error {
+	maxAttempts := u.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
+	// Use a local random source for jitter to avoid global Seed usage
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		var amount int64
+		var version int
+		err := conn.QueryRowContext(ctx, u.Queries.Select, id).Scan(&amount, &version)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		result, err := conn.ExecContext(ctx, u.Queries.Update, amount+delta, version+1, id, version)
+		if err != nil {
+			lastErr = err
+		} else if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			// Conflict: version changed by another transaction
+			lastErr = ErrRetryExhausted
+		} else {
+			return nil
+		}
+
+		// If we will retry, sleep with exponential backoff + jitter
+		if attempt < maxAttempts {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff.Exponential(rnd, u.BaseBackoff, attempt)):
+			}
+		}
+	}
+
+	return lastErr
+}
@@ -0,0 +1,81 @@
+package tenant
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Setting is the Postgres setting the row-level security policies compare
+// tenant_id with
+const Setting = "app.tenant_id"
+
+// EnableRLS turns on Postgres row-level security for tables, with a policy
+// that lets role see and write only the rows whose tenant_id equals the
+// Setting of its transaction, as set by SetLocal. Table owners, such as the
+// role the service migrates and runs as, bypass the policy and are scoped
+// by the Plugin instead; point reporting users and other direct clients at
+// role. Enabling it again replaces the policy.
+func EnableRLS(db *gorm.DB, role string, tables ...string) error {
+	if db.Dialector.Name() != "postgres" {
+		return fmt.Errorf("row-level security needs postgres, not %s", db.Dialector.Name())
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range tables {
+			policy := quote(table + "_tenant_isolation")
+			statements := []string{
+				fmt.Sprintf("ALTER TABLE %s ENABLE ROW LEVEL SECURITY", quote(table)),
+				fmt.Sprintf("DROP POLICY IF EXISTS %s ON %s", policy, quote(table)),
+				fmt.Sprintf("CREATE POLICY %s ON %s TO %s USING (tenant_id = current_setting('%s', true)) WITH CHECK (tenant_id = current_setting('%[4]s', true))",
+					policy, quote(table), quote(role), Setting),
+			}
+			for _, sql := range statements {
+				if err := tx.Exec(sql).Error; err != nil {
+					return fmt.Errorf("enabling row-level security on %s: %w", table, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// DisableRLS drops the policies of EnableRLS and turns row-level security off
+func DisableRLS(db *gorm.DB, tables ...string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range tables {
+			if err := tx.Exec(fmt.Sprintf("DROP POLICY IF EXISTS %s ON %s", quote(table+"_tenant_isolation"), quote(table))).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s DISABLE ROW LEVEL SECURITY", quote(table))).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetLocal sets the tenant the policies of EnableRLS allow for the rest of
+// transaction tx. Outside a transaction the setting would end with the
+// statement, so call it first thing inside db.Transaction.
+func SetLocal(tx *gorm.DB, id string) error {
+	return tx.Exec("SELECT set_config(?, ?, true)", Setting, id).Error
+}
+
+// Transaction runs fn in a transaction scoped to tenant id by both the
+// Plugin and, on Postgres, the row-level security setting
+func Transaction(db *gorm.DB, id string, fn func(tx *gorm.DB) error) error {
+	return Scope(db, id).Transaction(func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "postgres" {
+			if err := SetLocal(tx, id); err != nil {
+				return err
+			}
+		}
+		return fn(tx)
+	})
+}
+
+// quote quotes a Postgres identifier
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
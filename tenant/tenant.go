@@ -0,0 +1,163 @@
+// Package tenant scopes balances to the tenant carried in a context, so one
+// deployment can host the balances of many tenants. The GORM Plugin, which
+// database.Open registers, adds tenant_id = ? to every query, update and
+// delete of a model with a TenantID field and fills the field in on create,
+// whenever the statement's context carries a tenant. Statements without a
+// tenant are not scoped, which keeps single-tenant deployments and admin
+// tools working unchanged.
+//
+// On Postgres, EnableRLS adds row-level security as a second line of defence
+// for roles that do not go through the plugin, such as reporting users.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ErrTenantMismatch is returned when a row is created with a TenantID other
+// than the tenant of the context
+var ErrTenantMismatch = errors.New("tenant: row belongs to another tenant")
+
+// field is the model field the plugin scopes by
+const field = "TenantID"
+
+type contextKey struct{}
+
+// With returns a copy of ctx carrying tenant id. An empty id removes the tenant.
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant ctx carries, if any
+func FromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id, id != ""
+}
+
+// Scope returns db with tenant id in its context, so every statement run on
+// it only sees and writes the rows of that tenant
+func Scope(db *gorm.DB, id string) *gorm.DB {
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return db.WithContext(With(ctx, id))
+}
+
+// Plugin scopes statements on models with a TenantID field to the tenant of
+// their context
+type Plugin struct{}
+
+// Name implements gorm.Plugin
+func (Plugin) Name() string { return "tenant" }
+
+// Initialize implements gorm.Plugin
+func (Plugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Query().Before("gorm:query").Register("tenant:scope", scope(false)),
+		cb.Row().Before("gorm:row").Register("tenant:scope", scope(false)),
+		cb.Update().Before("gorm:update").Register("tenant:scope", scope(true)),
+		cb.Delete().Before("gorm:delete").Register("tenant:scope", scope(true)),
+		cb.Create().Before("gorm:create").Register("tenant:assign", assign),
+	)
+}
+
+// tenantField returns the tenant of the statement and the field to scope by,
+// or false if the statement is not scoped
+func tenantField(db *gorm.DB) (string, *schema.Field, bool) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return "", nil, false
+	}
+	id, ok := FromContext(db.Statement.Context)
+	if !ok {
+		return "", nil, false
+	}
+	f := db.Statement.Schema.LookUpField(field)
+	if f == nil {
+		return "", nil, false
+	}
+	return id, f, true
+}
+
+// scope returns a callback adding tenant_id = ? to the WHERE clause. GORM
+// refuses updates and deletes without conditions unless AllowGlobalUpdate is
+// set; the tenant condition alone must not lift that guard, so such writes
+// fail here.
+func scope(write bool) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		id, f, ok := tenantField(db)
+		if !ok {
+			return
+		}
+		stmt := db.Statement
+		if _, hasWhere := stmt.Clauses["WHERE"]; write && !hasWhere && !db.AllowGlobalUpdate && !hasPrimaryKey(stmt) {
+			db.AddError(gorm.ErrMissingWhereClause)
+			return
+		}
+		stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+			clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName}, Value: id},
+		}})
+	}
+}
+
+// assign sets TenantID on the created rows, rejecting rows of another tenant
+func assign(db *gorm.DB) {
+	id, f, ok := tenantField(db)
+	if !ok {
+		return
+	}
+	ctx := db.Statement.Context
+	set := func(rv reflect.Value) {
+		switch current, zero := f.ValueOf(ctx, rv); {
+		case zero:
+			if err := f.Set(ctx, rv, id); err != nil {
+				db.AddError(err)
+			}
+		case current != id:
+			db.AddError(fmt.Errorf("%w: %v", ErrTenantMismatch, current))
+		}
+	}
+
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			set(reflect.Indirect(rv.Index(i)))
+		}
+	case reflect.Struct:
+		set(rv)
+	}
+}
+
+// hasPrimaryKey reports whether the rows of stmt carry a primary key, which
+// GORM turns into the WHERE clause of an update or delete
+func hasPrimaryKey(stmt *gorm.Statement) bool {
+	f := stmt.Schema.PrioritizedPrimaryField
+	if f == nil {
+		return false
+	}
+	rv := stmt.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if _, zero := f.ValueOf(stmt.Context, reflect.Indirect(rv.Index(i))); !zero {
+				return true
+			}
+		}
+	case reflect.Struct:
+		_, zero := f.ValueOf(stmt.Context, rv)
+		return !zero
+	}
+	return false
+}
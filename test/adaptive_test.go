@@ -0,0 +1,84 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestAdaptiveBackoffScalesHotBalances(t *testing.T) {
+	a := service.NewAdaptiveBackoff(service.AdaptiveConfig{Window: 100 * time.Millisecond, MinAttempts: 4, MaxScale: 5})
+
+	// Balance 1 conflicts on every other attempt, balance 2 never
+	for i := 0; i < 8; i++ {
+		a.Record(1, i%2 == 0)
+		a.Record(2, false)
+	}
+	if rate, attempts := a.ConflictRate(1); rate != 0.5 || attempts != 8 {
+		t.Errorf("expected a 0.5 conflict rate over 8 attempts, got %v over %d", rate, attempts)
+	}
+	if got := a.Scale(1); got != 3 {
+		t.Errorf("expected a hot balance to scale by 3, got %v", got)
+	}
+	if got := a.Scale(2); got != 1 {
+		t.Errorf("expected a cold balance not to scale, got %v", got)
+	}
+
+	// Too few attempts to call a balance hot
+	a.Record(3, true)
+	if got := a.Scale(3); got != 1 {
+		t.Errorf("expected a single conflict not to scale, got %v", got)
+	}
+
+	// The window slides: old conflicts fade and are forgotten
+	time.Sleep(130 * time.Millisecond)
+	if rate, attempts := a.ConflictRate(1); rate != 0.5 || attempts >= 8 {
+		t.Errorf("expected the previous window to be weighed down, got %v over %d", rate, attempts)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, attempts := a.ConflictRate(1); attempts != 0 {
+		t.Errorf("expected no attempts after two windows, got %d", attempts)
+	}
+}
+
+func TestAdaptiveBackoff(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	hot := models.Balance{Amount: 1000}
+	cold := models.Balance{Amount: 1000}
+	db.Create(&hot)
+	db.Create(&cold)
+
+	// Bump the version of the hot balance after every read
+	db.Callback().Query().After("gorm:query").Register("test:bump_version", func(tx *gorm.DB) {
+		if tx.Statement.Table == "balances" {
+			tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE balances SET version = version + 1 WHERE id = ?", hot.ID)
+		}
+	})
+
+	adaptive := service.NewAdaptiveBackoff(service.AdaptiveConfig{MinAttempts: 3, MaxScale: 4})
+	updater := service.NewUpdater(db, service.WithMaxAttempts(3), service.WithBaseBackoff(time.Millisecond),
+		service.WithAdaptiveBackoff(adaptive))
+
+	if _, err := updater.UpdateBalance(hot.ID, 10); !errors.Is(err, service.ErrRetryExhausted) {
+		t.Fatalf("expected ErrRetryExhausted, got %v", err)
+	}
+	if _, err := updater.UpdateBalance(cold.ID, 10); err != nil {
+		t.Fatalf("UpdateBalance failed: %v", err)
+	}
+	if rate, attempts := adaptive.ConflictRate(hot.ID); rate != 1 || attempts != 3 {
+		t.Errorf("expected every attempt on the hot balance to conflict, got %v over %d", rate, attempts)
+	}
+	if got := adaptive.Scale(hot.ID); got != 4 {
+		t.Errorf("expected the hot balance to back off 4 times longer, got %v", got)
+	}
+	if rate, _ := adaptive.ConflictRate(cold.ID); rate != 0 {
+		t.Errorf("expected no conflicts on the cold balance, got %v", rate)
+	}
+}
@@ -0,0 +1,96 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestBatcherCoalescesDeltas(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	batcher := service.NewBatcher(service.NewUpdater(db), 20*time.Millisecond, 25)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := batcher.Add(balance.ID, 10); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	batcher.Close()
+
+	var updated models.Balance
+	db.First(&updated, balance.ID)
+	if updated.Amount != 2000 {
+		t.Errorf("Balance integrity failed: expected 2000, got %d", updated.Amount)
+	}
+	// 100 deltas flushed in groups of at most 25 need far fewer than 100 writes
+	if updated.Version > 20 {
+		t.Errorf("expected deltas to be coalesced, got version %d", updated.Version)
+	}
+	t.Logf("Applied 100 deltas with %d versioned writes", updated.Version)
+
+	if _, err := batcher.Add(balance.ID, 1); !errors.Is(err, service.ErrBatcherClosed) {
+		t.Errorf("expected ErrBatcherClosed after Close, got %v", err)
+	}
+}
+
+func TestAsyncUpdaterDrainsOnShutdown(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	async := service.NewAsyncUpdater(service.NewUpdater(db), 4, 100)
+
+	var mu sync.Mutex
+	completed, failed := 0, 0
+	for i := 0; i < 100; i++ {
+		err := async.Submit(balance.ID, 10, func(_ service.UpdateOutcome, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed++
+				return
+			}
+			completed++
+		})
+		if err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := async.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if err := async.Submit(balance.ID, 10, nil); !errors.Is(err, service.ErrAsyncUpdaterClosed) {
+		t.Errorf("expected ErrAsyncUpdaterClosed, got %v", err)
+	}
+
+	var updated models.Balance
+	db.First(&updated, balance.ID)
+	t.Logf("Final balance: %d, completed: %d, failed: %d", updated.Amount, completed, failed)
+	if completed+failed != 100 {
+		t.Errorf("expected every queued update to complete, got %d", completed+failed)
+	}
+	if updated.Amount != int64(1000+completed*10) {
+		t.Errorf("Balance integrity failed: expected %d, got %d", 1000+completed*10, updated.Amount)
+	}
+}
@@ -0,0 +1,127 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestConflictAudit(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	// Bump the version right after the first read so the first attempt conflicts
+	bumped := false
+	db.Callback().Query().After("gorm:query").Register("test:bump_version", func(tx *gorm.DB) {
+		if !bumped && tx.Statement.Table == "balances" {
+			bumped = true
+			tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE balances SET version = version + 1 WHERE id = ?", balance.ID)
+		}
+	})
+
+	var auditErrs []error
+	updater := service.NewUpdater(db,
+		service.WithBaseBackoff(time.Millisecond),
+		service.WithConflictAudit(service.TableConflictSink{DB: db}, func(err error) { auditErrs = append(auditErrs, err) }),
+	).As("alice")
+
+	outcome, err := updater.UpdateBalance(balance.ID, 25)
+	if err != nil {
+		t.Fatalf("UpdateBalance failed: %v", err)
+	}
+	if outcome.Conflicts != 1 {
+		t.Fatalf("expected one conflict, got %d", outcome.Conflicts)
+	}
+
+	// A stale conditional write is audited with the version the client expected
+	if _, err := updater.UpdateIfVersion(balance.ID, balance.Version, -5); !errors.Is(err, service.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+
+	var records []models.UpdateConflict
+	db.Order("id").Find(&records)
+	if len(records) != 2 || len(auditErrs) != 0 {
+		t.Fatalf("expected 2 records and no errors, got %+v, %v", records, auditErrs)
+	}
+	first, second := records[0], records[1]
+	if first.Actor != "alice" || first.ExpectedVersion != balance.Version || first.ActualVersion != balance.Version+1 ||
+		first.Delta != 25 || first.Attempt != 1 {
+		t.Errorf("unexpected first record: %+v", first)
+	}
+	if second.ExpectedVersion != balance.Version || second.ActualVersion != balance.Version+2 || second.Delta != -5 {
+		t.Errorf("unexpected second record: %+v", second)
+	}
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 100}
+	db.Create(&balance)
+	gone := models.Balance{Amount: 100}
+	db.Create(&gone)
+
+	// Bump the version after every read so every update exhausts its retries
+	contended := true
+	db.Callback().Query().After("gorm:query").Register("test:bump_version", func(tx *gorm.DB) {
+		if contended && tx.Statement.Table == "balances" {
+			tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE balances SET version = version + 1 WHERE id IN ?", []uint{balance.ID, gone.ID})
+		}
+	})
+
+	updater := service.NewUpdater(db, service.WithMaxAttempts(2), service.WithBaseBackoff(time.Millisecond),
+		service.WithDeadLetter(service.TableDeadLetterSink{DB: db}, nil))
+	if _, err := updater.UpdateBalance(balance.ID, 10); !errors.Is(err, service.ErrDeadLettered) || !errors.Is(err, service.ErrRetryExhausted) {
+		t.Fatalf("expected ErrDeadLettered, got %v", err)
+	}
+	if _, err := updater.ApplyAdjustment("invoice-1", balance.ID, 5); !errors.Is(err, service.ErrDeadLettered) {
+		t.Fatalf("expected ErrDeadLettered, got %v", err)
+	}
+	if _, err := updater.UpdateBalance(gone.ID, 1); !errors.Is(err, service.ErrDeadLettered) {
+		t.Fatalf("expected ErrDeadLettered, got %v", err)
+	}
+
+	var queued []models.FailedUpdate
+	db.Order("id").Find(&queued)
+	if len(queued) != 3 || queued[1].IdempotencyKey != "invoice-1" || !strings.HasPrefix(queued[0].IdempotencyKey, "dead-letter:") ||
+		queued[0].Attempts != 2 {
+		t.Fatalf("unexpected failed updates: %+v", queued)
+	}
+
+	// Replay once the contention is over; the deleted balance keeps failing
+	contended = false
+	db.Delete(&gone)
+	reprocessor := service.NewReprocessor(db, updater, 10)
+	replayed, err := reprocessor.ReprocessOnce(context.Background())
+	if err != nil || replayed != 2 {
+		t.Fatalf("expected 2 replays, got %d %v", replayed, err)
+	}
+	if final, _ := service.GetBalance(db, balance.ID); final.Amount != 115 {
+		t.Errorf("expected amount 115 after replay, got %d", final.Amount)
+	}
+
+	var failed models.FailedUpdate
+	db.Where("balance_id = ?", gone.ID).First(&failed)
+	if failed.ReplayedAt != nil || failed.Replays != 1 || failed.LastError == "" || !failed.NextReplayAt.After(time.Now()) {
+		t.Errorf("expected the replay to back off, got %+v", failed)
+	}
+
+	// Nothing is due again, and replaying under the same key never double-applies
+	if replayed, _ := reprocessor.ReprocessOnce(context.Background()); replayed != 0 {
+		t.Errorf("expected nothing due, got %d", replayed)
+	}
+	if applied, _ := updater.ApplyAdjustment("invoice-1", balance.ID, 5); applied {
+		t.Error("expected the replayed adjustment to be applied once")
+	}
+}
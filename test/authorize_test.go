@@ -0,0 +1,136 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// callerKey is the context key of the caller in TestAuthorizer
+type callerKey struct{}
+
+func TestAuthorizer(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	alice, err := service.CreateBalance(db, "alice", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []service.WriteOp
+	var mu sync.Mutex
+	updater := service.NewUpdater(db, service.WithAuthorizer(func(ctx context.Context, b models.Balance, op service.WriteOp) error {
+		mu.Lock()
+		ops = append(ops, op)
+		mu.Unlock()
+		if caller, _ := ctx.Value(callerKey{}).(string); b.OwnerID == nil || *b.OwnerID != caller {
+			return fmt.Errorf("%s does not own balance %d", caller, b.ID)
+		}
+		return nil
+	}))
+	as := func(caller string) *service.Updater {
+		return updater.With(service.WithContext(context.WithValue(context.Background(), callerKey{}, caller)))
+	}
+
+	if _, err := as("alice").UpdateBalance(alice.ID, 5); err != nil {
+		t.Errorf("expected the owner to update, got %v", err)
+	}
+	if _, err := as("bob").UpdateBalance(alice.ID, -5); !errors.Is(err, service.ErrForbidden) {
+		t.Errorf("expected ErrForbidden, got %v", err)
+	}
+	if _, err := as("bob").Prepare(alice.ID, -5, 0); !errors.Is(err, service.ErrForbidden) {
+		t.Errorf("expected Prepare to be refused, got %v", err)
+	}
+	ctx := context.WithValue(context.Background(), callerKey{}, "bob")
+	if _, err := updater.RunScript(ctx, []service.Op{{Kind: service.OpDebit, BalanceID: alice.ID, Amount: 5}}); !errors.Is(err, service.ErrForbidden) {
+		t.Errorf("expected the script to be refused, got %v", err)
+	}
+	if _, err := updater.UpdateBalance(9999, 1); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if current, _ := service.GetBalance(db, alice.ID); current.Amount != 105 {
+		t.Errorf("expected only the owner's update applied, got %d", current.Amount)
+	}
+	want := []service.WriteOp{{Kind: service.WriteUpdate, Delta: 5}, {Kind: service.WriteUpdate, Delta: -5}, {Kind: service.WritePrepare, Delta: -5}, {Kind: service.WriteScript, Delta: -5}}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("expected the authorizer asked about %v, got %v", want, ops)
+	}
+
+	// Sharded balances, status changes and deletes ask too
+	if err := service.CreateShards(db, alice.ID, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	ops = nil
+	mu.Unlock()
+	if _, err := as("bob").UpdateShardedBalance(alice.ID, -5); !errors.Is(err, service.ErrForbidden) {
+		t.Errorf("expected the sharded update to be refused, got %v", err)
+	}
+	if _, err := as("bob").Freeze(alice.ID); !errors.Is(err, service.ErrForbidden) {
+		t.Errorf("expected Freeze to be refused, got %v", err)
+	}
+	if _, err := as("alice").Freeze(alice.ID); err != nil {
+		t.Errorf("expected the owner to freeze, got %v", err)
+	}
+	if _, err := as("bob").Unfreeze(alice.ID); !errors.Is(err, service.ErrForbidden) {
+		t.Errorf("expected Unfreeze to be refused, got %v", err)
+	}
+	if _, err := as("bob").Close(alice.ID); !errors.Is(err, service.ErrForbidden) {
+		t.Errorf("expected Close to be refused, got %v", err)
+	}
+	frozen, _ := service.GetBalance(db, alice.ID)
+	if err := as("bob").DeleteBalance(alice.ID, frozen.Version); !errors.Is(err, service.ErrForbidden) {
+		t.Errorf("expected DeleteBalance to be refused, got %v", err)
+	}
+	if frozen.Status != models.BalanceFrozen {
+		t.Errorf("expected only the owner's freeze applied, got %s", frozen.Status)
+	}
+	if _, err := as("alice").Unfreeze(alice.ID); err != nil {
+		t.Errorf("expected the owner to unfreeze, got %v", err)
+	}
+	want = []service.WriteOp{{Kind: service.WriteShard, Delta: -5}, {Kind: service.WriteFreeze}, {Kind: service.WriteFreeze},
+		{Kind: service.WriteUnfreeze}, {Kind: service.WriteClose}, {Kind: service.WriteDelete}, {Kind: service.WriteUnfreeze}}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("expected the authorizer asked about %v, got %v", want, ops)
+	}
+	dec := models.DecimalBalance{Amount: decimal.NewFromInt(1)}
+	db.Create(&dec)
+	if _, err := as("alice").UpdateDecimalBalance(dec.ID, decimal.NewFromInt(1)); !errors.Is(err, service.ErrForbidden) {
+		t.Errorf("expected decimal balances refused with an authorizer, got %v", err)
+	}
+
+	// The HTTP API passes the request context, and answers 403
+	api := (&httpapi.Server{DB: db, Updater: updater}).Handler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, r.Header.Get("X-Caller"))))
+	}))
+	defer server.Close()
+	for caller, status := range map[string]int{"bob": http.StatusForbidden, "alice": http.StatusOK} {
+		current, _ := service.GetBalance(db, alice.ID)
+		req, _ := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/balances/%d", server.URL, alice.ID), strings.NewReader(`{"delta": 1}`))
+		req.Header.Set("If-Match", fmt.Sprintf(`"v=%d"`, current.Version))
+		req.Header.Set("X-Caller", caller)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]any
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != status || (status == http.StatusForbidden && body["code"] != "PERMISSION_DENIED") {
+			t.Errorf("expected %d for %s, got %d and %v", status, caller, resp.StatusCode, body)
+		}
+	}
+}
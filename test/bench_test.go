@@ -0,0 +1,158 @@
+package service_test
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// The benchmarks run against the docker-compose Postgres, like the TPS
+// tests. Profile them with the standard flags, e.g.
+//
+//	go test -run '^$' -bench . -benchmem -cpuprofile cpu.out -memprofile mem.out ./test
+//
+// or make bench-profile, and compare against another commit with
+// ./bench-compare.sh.
+
+// benchDB returns a private Postgres database tuned like the TPS tests
+func benchDB(b *testing.B) *gorm.DB {
+	db := cloneDB(b, &gorm.Config{SkipDefaultTransaction: true, PrepareStmt: true})
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.SetMaxIdleConns(25)
+		sqlDB.SetMaxOpenConns(100)
+	}
+	return db
+}
+
+// seedBalances creates n balances and returns their IDs
+func seedBalances(b *testing.B, db *gorm.DB, n int) []uint {
+	ids := make([]uint, n)
+	for i := range ids {
+		balance := models.Balance{Amount: 1000}
+		if err := db.Create(&balance).Error; err != nil {
+			b.Fatalf("Failed to seed balance: %v", err)
+		}
+		ids[i] = balance.ID
+	}
+	return ids
+}
+
+// benchCounters adds up what the updates of one benchmark ran into
+type benchCounters struct {
+	conflicts atomic.Int64
+	failures  atomic.Int64
+}
+
+func (c *benchCounters) add(outcome service.UpdateOutcome, err error) {
+	c.conflicts.Add(int64(outcome.Conflicts))
+	if err != nil {
+		c.failures.Add(1)
+	}
+}
+
+// report adds conflicts/op and failures/op next to ns/op
+func (c *benchCounters) report(b *testing.B) {
+	b.ReportMetric(float64(c.conflicts.Load())/float64(b.N), "conflicts/op")
+	b.ReportMetric(float64(c.failures.Load())/float64(b.N), "failures/op")
+}
+
+// BenchmarkUpdateSingleKey runs parallel updates to one hot balance with the
+// contention strategies of the updater
+func BenchmarkUpdateSingleKey(b *testing.B) {
+	db := benchDB(b)
+	for _, bc := range []struct {
+		name string
+		opts []service.Option
+	}{
+		{"optimistic", nil},
+		{"pessimistic-fallback", []service.Option{service.WithPessimisticFallback(2)}},
+		{"serialized", []service.Option{service.WithSerializer(service.NewKeyedSerializer(64))}},
+		{"adaptive", []service.Option{service.WithAdaptiveBackoff(service.NewAdaptiveBackoff(service.AdaptiveConfig{}))}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			id := seedBalances(b, db, 1)[0]
+			updater := service.NewUpdater(db, bc.opts...)
+			var counters benchCounters
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					counters.add(updater.UpdateBalance(id, 1))
+				}
+			})
+			counters.report(b)
+		})
+	}
+}
+
+// BenchmarkUpdateMultiKey spreads parallel updates over a number of balances,
+// where conflicts get rarer as the key count grows
+func BenchmarkUpdateMultiKey(b *testing.B) {
+	db := benchDB(b)
+	for _, keys := range []int{16, 256} {
+		b.Run(fmt.Sprintf("keys=%d", keys), func(b *testing.B) {
+			ids := seedBalances(b, db, keys)
+			updater := service.NewUpdater(db)
+			var counters benchCounters
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+				for pb.Next() {
+					counters.add(updater.UpdateBalance(ids[rnd.Intn(len(ids))], 1))
+				}
+			})
+			counters.report(b)
+		})
+	}
+}
+
+// BenchmarkBatcher coalesces parallel updates to one hot balance
+func BenchmarkBatcher(b *testing.B) {
+	db := benchDB(b)
+	id := seedBalances(b, db, 1)[0]
+	batcher := service.NewBatcher(service.NewUpdater(db), time.Millisecond, 64)
+	defer batcher.Close()
+	var counters benchCounters
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			counters.add(batcher.Add(id, 1))
+		}
+	})
+	counters.report(b)
+}
+
+// BenchmarkIncrementFastPath measures one uncontended update through GORM,
+// which uses UPDATE ... RETURNING on Postgres, and through WithRawSQL
+func BenchmarkIncrementFastPath(b *testing.B) {
+	db := benchDB(b)
+	for _, bc := range []struct {
+		name string
+		opts []service.Option
+	}{
+		{"gorm", nil},
+		{"raw", []service.Option{service.WithRawSQL()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			id := seedBalances(b, db, 1)[0]
+			updater := service.NewUpdater(db, bc.opts...)
+			var counters benchCounters
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				counters.add(updater.UpdateBalance(id, 1))
+			}
+			counters.report(b)
+		})
+	}
+}
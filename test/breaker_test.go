@@ -0,0 +1,96 @@
+package service_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	var transitions []string
+	cb := service.NewCircuitBreaker(service.BreakerConfig{
+		MinRequests:  4,
+		FailureRatio: 0.5,
+		OpenTimeout:  20 * time.Millisecond,
+		OnStateChange: func(from, to service.CircuitState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	})
+
+	// Missing rows do not count as failures
+	for i := 0; i < 2; i++ {
+		cb.Allow()
+		cb.Record(gorm.ErrRecordNotFound)
+	}
+	for i := 0; i < 2; i++ {
+		cb.Allow()
+		cb.Record(service.ErrRetryExhausted)
+	}
+	if cb.State() != service.CircuitOpen {
+		t.Fatalf("expected breaker to open, got %s", cb.State())
+	}
+	if err := cb.Allow(); !errors.Is(err, service.ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen while open, got %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	// One probe is allowed through after the open timeout
+	if err := cb.Allow(); err != nil {
+		t.Fatalf("expected probe to be allowed, got %v", err)
+	}
+	if err := cb.Allow(); !errors.Is(err, service.ErrCircuitOpen) {
+		t.Errorf("expected concurrent probe to be rejected, got %v", err)
+	}
+	cb.Record(nil)
+
+	if cb.State() != service.CircuitClosed {
+		t.Errorf("expected breaker to close after successful probe, got %s", cb.State())
+	}
+
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("expected transitions %v, got %v", want, transitions)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Errorf("transition %d: expected %s, got %s", i, want[i], transitions[i])
+		}
+	}
+}
+
+func TestCircuitBreakerIgnoresRejections(t *testing.T) {
+	cb := service.NewCircuitBreaker(service.BreakerConfig{MinRequests: 5, FailureRatio: 0.5})
+
+	// Refusals of the service or the caller say nothing about the database
+	rejections := []error{
+		&service.StatusError{BalanceID: 1, Status: "frozen"},
+		&service.PolicyError{BalanceID: 1, Decision: service.PolicyDecision{Err: service.ErrInsufficientFunds}},
+		fmt.Errorf("debit: %w", service.ErrLimitExceeded),
+		&service.MetadataConflictError{BalanceID: 1, Keys: []string{"tier"}},
+		service.ErrConflict,
+		service.ErrForbidden,
+		service.ErrNotFound,
+	}
+	for _, err := range rejections {
+		cb.Allow()
+		cb.Record(err)
+	}
+	if cb.State() != service.CircuitClosed {
+		t.Fatalf("expected rejections to leave the breaker closed, got %s", cb.State())
+	}
+
+	failures := []error{service.ErrRetryExhausted, errors.New("connection refused"), service.ErrStatementTimeout}
+	for i := 0; i < 8; i++ {
+		cb.Allow()
+		cb.Record(failures[i%len(failures)])
+	}
+	if cb.State() != service.CircuitOpen {
+		t.Errorf("expected retry exhaustion and database errors to open the breaker, got %s", cb.State())
+	}
+}
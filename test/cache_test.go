@@ -0,0 +1,112 @@
+package service_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/cache"
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := cache.New(2)
+	c.Set(1, 100, 1)
+	c.Set(2, 200, 1)
+	c.Get(1, time.Minute) // 2 is now the least recently used
+	c.Set(3, 300, 1)
+
+	if _, ok := c.Get(2, time.Minute); ok {
+		t.Error("expected balance 2 to be evicted")
+	}
+	for _, id := range []uint{1, 3} {
+		if _, ok := c.Get(id, time.Minute); !ok {
+			t.Errorf("expected balance %d to be cached", id)
+		}
+	}
+	if stats := c.Stats(); stats.Size != 2 || stats.Hits != 3 || stats.Misses != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCacheKeepsTheLatestVersion(t *testing.T) {
+	c := cache.New(10)
+	c.Set(1, 150, 3)
+	c.Set(1, 100, 2) // a read that started before the write finishes late
+
+	entry, ok := c.Get(1, time.Minute)
+	if !ok || entry.Amount != 150 || entry.Version != 3 {
+		t.Fatalf("expected version 3 to stay cached, got %+v %v", entry, ok)
+	}
+
+	if _, ok := c.Get(1, 0); ok {
+		t.Error("expected an entry older than the staleness bound to miss")
+	}
+	c.Invalidate(1)
+	if _, ok := c.Get(1, time.Minute); ok {
+		t.Error("expected an invalidated entry to miss")
+	}
+}
+
+func TestCachedReads(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 100}
+	db.Create(&balance)
+	updater := service.NewUpdater(db, service.WithCache(cache.New(16)))
+
+	if _, hit, err := updater.GetCached(balance.ID, time.Minute); err != nil || hit {
+		t.Fatalf("expected the first read to miss, got hit=%v err=%v", hit, err)
+	}
+
+	// Writes through the updater refresh the cache
+	if _, err := updater.UpdateBalance(balance.ID, 20); err != nil {
+		t.Fatal(err)
+	}
+	entry, hit, err := updater.GetCached(balance.ID, time.Minute)
+	if err != nil || !hit || entry.Amount != 120 || entry.Version != balance.Version+1 {
+		t.Fatalf("expected a cached 120 after the write, got %+v hit=%v err=%v", entry, hit, err)
+	}
+
+	// A write made elsewhere is only seen past the staleness bound
+	db.Model(&models.Balance{}).Where("id = ?", balance.ID).Updates(map[string]any{"amount": 500, "version": gorm.Expr("version + 1")})
+	if entry, _, _ := updater.GetCached(balance.ID, time.Minute); entry.Amount != 120 {
+		t.Errorf("expected the cached amount within the bound, got %d", entry.Amount)
+	}
+	if entry, hit, _ := updater.GetCached(balance.ID, 0); hit || entry.Amount != 500 {
+		t.Errorf("expected a fresh read past the bound, got %d hit=%v", entry.Amount, hit)
+	}
+
+	// A conflict drops the stale entry
+	if _, err := updater.UpdateIfVersion(balance.ID, entry.Version-1, 1); !errors.Is(err, service.ErrConflict) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	if _, hit, _ := updater.GetCached(balance.ID, time.Minute); hit {
+		t.Error("expected the conflict to invalidate the entry")
+	}
+
+	server := httptest.NewServer((&httpapi.Server{DB: db, Updater: updater}).Handler())
+	defer server.Close()
+	for _, want := range []string{"cache", "primary"} {
+		query := "?max_stale=1m"
+		if want == "primary" {
+			query = "?max_stale=0s"
+		}
+		resp, err := http.Get(fmt.Sprintf("%s/balances/%d%s", server.URL, balance.ID, query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("X-Read-Source"); resp.StatusCode != http.StatusOK || got != want {
+			t.Errorf("%s: expected 200 from %s, got %d from %s", query, want, resp.StatusCode, got)
+		}
+	}
+}
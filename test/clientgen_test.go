@@ -0,0 +1,67 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	client "github.com/ghozilaaa/optimistic-lock/clients/go"
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/internal/clientgen"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestGeneratedClientsUpToDate(t *testing.T) {
+	spec, err := clientgen.Load("../api/openapi.yaml")
+	if err != nil {
+		t.Fatalf("load spec: %v", err)
+	}
+
+	goSrc, err := clientgen.GenerateGo(spec, "client", "openapi.yaml")
+	if err != nil {
+		t.Fatalf("generate Go client: %v", err)
+	}
+	tsSrc, err := clientgen.GenerateTypeScript(spec, "openapi.yaml")
+	if err != nil {
+		t.Fatalf("generate TypeScript client: %v", err)
+	}
+
+	for path, want := range map[string][]byte{
+		"../clients/go/client.go":         goSrc,
+		"../clients/typescript/client.ts": tsSrc,
+	} {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is stale; run go generate ./clients", path)
+		}
+	}
+}
+
+func TestGeneratedGoClient(t *testing.T) {
+	ops := service.NewOperationRegistry(0)
+	server := httptest.NewServer((&httpapi.Server{Operations: ops}).Handler())
+	defer server.Close()
+	c := client.New(server.URL)
+
+	id := ops.Start(7, 25)
+	op, err := c.GetOperation(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetOperation failed: %v", err)
+	}
+	if op.ID != id || op.Status != "pending" || op.BalanceID != 7 || op.Delta != 25 {
+		t.Errorf("unexpected operation: %+v", op)
+	}
+
+	_, err = c.GetOperation(context.Background(), "unknown")
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a 404 client error, got %v", err)
+	}
+}
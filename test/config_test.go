@@ -0,0 +1,120 @@
+package service_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/config"
+	"github.com/ghozilaaa/optimistic-lock/secrets"
+)
+
+func TestConfigDefaults(t *testing.T) {
+	cfg, err := config.Load("test", nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Retry.MaxAttempts != 5 || cfg.Database.Pool.MaxOpenConns != 50 || cfg.Server.Addr != ":8080" {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+}
+
+func TestConfigExampleFile(t *testing.T) {
+	cfg, err := config.Load("test", []string{"-config", "../config.example.yaml"})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	want := config.Default()
+	if cfg.Retry != want.Retry || cfg.Database.Pool != want.Database.Pool || cfg.Server != want.Server {
+		t.Errorf("example file does not match the defaults: %+v", cfg)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "config.yaml")
+	os.WriteFile(yamlFile, []byte("retry:\n  max_attempts: 3\n  base_backoff: 50ms\nserver:\n  addr: \":9000\"\ndatabase:\n  host: file-host\n"), 0o644)
+	tomlFile := filepath.Join(dir, "config.toml")
+	os.WriteFile(tomlFile, []byte("[retry]\nmax_attempts = 4\nbase_backoff = \"20ms\"\n\n[database.pool]\nmax_open_conns = 10\nmax_idle_conns = 5\n"), 0o644)
+
+	cfg, err := config.Load("test", []string{"-config", tomlFile})
+	if err != nil {
+		t.Fatalf("Load TOML failed: %v", err)
+	}
+	if cfg.Retry.MaxAttempts != 4 || cfg.Retry.BaseBackoff != 20*time.Millisecond || cfg.Database.Pool.MaxOpenConns != 10 {
+		t.Errorf("unexpected TOML config: %+v", cfg)
+	}
+
+	// Environment overrides the file, flags override both
+	t.Setenv("DB_HOST", "env-host")
+	t.Setenv("RETRY_MAX_ATTEMPTS", "7")
+	t.Setenv("DB_HOSTS", "a:5432, b:5432")
+	cfg, err = config.Load("test", []string{"-config", yamlFile, "-retry-max-attempts", "9", "-audit-conflicts"})
+	if err != nil {
+		t.Fatalf("Load YAML failed: %v", err)
+	}
+	if cfg.Database.Host != "env-host" || cfg.Retry.MaxAttempts != 9 || cfg.Retry.BaseBackoff != 50*time.Millisecond ||
+		cfg.Server.Addr != ":9000" || !cfg.Audit.Conflicts || len(cfg.Database.Hosts) != 2 || cfg.Database.Hosts[1] != "b:5432" {
+		t.Errorf("unexpected precedence result: %+v", cfg)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	_, err := config.Load("test", []string{"-retry-max-attempts", "0", "-metrics-backend", "statsd",
+		"-db-max-open-conns", "5", "-db-max-idle-conns", "10", "-log-level", "loud"})
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{"retry.max_attempts", "metrics.statsd_addr", "max_idle_conns", "log level"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected an error about %s, got %v", want, err)
+		}
+	}
+
+	// Conflict simulation is refused outside the test profile
+	if _, err := config.Load("test", []string{"-testing-conflict-every-nth", "3"}); err == nil || !strings.Contains(err.Error(), "test profile") {
+		t.Errorf("expected testing settings to require the test profile, got %v", err)
+	}
+	cfg, err := config.Load("test", []string{"-profile", "test", "-testing-conflict-balance-ids", "4, 7"})
+	if err != nil || len(cfg.Testing.ConflictBalanceIDs) != 2 || cfg.Testing.ConflictBalanceIDs[1] != 7 {
+		t.Errorf("expected the test profile to accept conflict balance IDs, got %+v %v", cfg.Testing, err)
+	}
+
+	if _, err := config.Load("test", []string{"-runtime-serializer-stripes", "-1"}); err == nil || !strings.Contains(err.Error(), "serializer_stripes") {
+		t.Errorf("expected negative serializer stripes to be refused, got %v", err)
+	}
+	cfg, _ = config.Load("test", []string{"-runtime-serialize"})
+	if s := cfg.NewSerializer(); s == nil || s.Stripes() != 64*runtime.GOMAXPROCS(0) {
+		t.Errorf("expected 64 serializer stripes per GOMAXPROCS, got %v", s)
+	}
+
+	for profile, want := range map[string]bool{"production": false, "staging": true, "test": true} {
+		if cfg, err := config.Load("test", []string{"-profile", profile}); err != nil || cfg.DebugHeaders() != want {
+			t.Errorf("profile %s: expected debug headers %v, got %v (%v)", profile, want, cfg.DebugHeaders(), err)
+		}
+	}
+
+	if _, err := config.Load("test", []string{"-db-replicas", "replica:5432", "-db-replica-max-lag", "0s"}); err == nil ||
+		!strings.Contains(err.Error(), "replica_max_lag") {
+		t.Errorf("expected replicas to require a positive max lag, got %v", err)
+	}
+
+	if _, err := config.Load("test", []string{"-db-migrate", "always"}); err == nil || !strings.Contains(err.Error(), "database.migrate") {
+		t.Errorf("expected an unknown migrate mode to be refused, got %v", err)
+	}
+
+	if _, err := config.Load("test", []string{"-db-secrets-provider", "vault"}); err == nil || !strings.Contains(err.Error(), "vault_path") {
+		t.Errorf("expected the vault provider to require a path, got %v", err)
+	}
+	cfg, _ = config.Load("test", []string{"-db-secrets-provider", "file", "-db-secrets-user-file", "u", "-db-secrets-password-file", "p"})
+	if p, ok := cfg.SecretProvider().(secrets.File); !ok || p.PasswordPath != "p" {
+		t.Errorf("expected a file secrets provider, got %#v", cfg.SecretProvider())
+	}
+
+	if _, err := config.Load("test", []string{"-retry-base-backoff", "soon"}); err == nil {
+		t.Error("expected an error for an invalid duration flag")
+	}
+}
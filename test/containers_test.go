@@ -0,0 +1,203 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/testcontainers/testcontainers-go"
+	tckafka "github.com/testcontainers/testcontainers-go/modules/kafka"
+	tcpostgres "github.com/testcontainers/testcontainers-go/modules/postgres"
+
+	"github.com/ghozilaaa/optimistic-lock/database"
+)
+
+// testServer is the database server the integration tests connect to: the
+// docker-compose service, a server named by environment variables, or a
+// container started by testcontainers when neither is reachable
+type testServer struct {
+	Host, Port, User, Password string
+	err                        error // why no server is available
+}
+
+var (
+	postgresOnce   sync.Once
+	postgresServer testServer
+
+	kafkaOnce   sync.Once
+	kafkaServer testServer
+
+	containersMu sync.Mutex
+	containers   []testcontainers.Container
+)
+
+// TestMain removes the containers the tests started. Testcontainers' reaper
+// also removes them if the test binary is killed.
+func TestMain(m *testing.M) {
+	code := m.Run()
+	containersMu.Lock()
+	for _, c := range containers {
+		testcontainers.TerminateContainer(c)
+	}
+	containersMu.Unlock()
+	os.Exit(code)
+}
+
+// postgres returns the Postgres server for the tests. POSTGRES_HOST,
+// POSTGRES_PORT, POSTGRES_USER and POSTGRES_PASSWORD override the
+// docker-compose settings. If nothing accepts connections there, a
+// postgres:15-alpine container is started once for the whole run. The test
+// is skipped when Docker is not available either, unless
+// OPTLOCK_REQUIRE_DB=1 asks for a failure instead, e.g. in CI.
+func postgres(t testing.TB) testServer {
+	t.Helper()
+	postgresOnce.Do(func() {
+		postgresServer = testServer{
+			Host:     envOr("POSTGRES_HOST", "localhost"),
+			Port:     envOr("POSTGRES_PORT", "5432"),
+			User:     envOr("POSTGRES_USER", "postgres"),
+			Password: envOr("POSTGRES_PASSWORD", "postgres"),
+		}
+		if !reachable(postgresServer) {
+			postgresServer = startPostgres(postgresServer)
+		}
+	})
+	requireServer(t, "Postgres", postgresServer)
+	return postgresServer
+}
+
+// startPostgres runs a Postgres container with the credentials of s and the
+// optimistic_lock database the docker-compose service creates
+func startPostgres(s testServer) (started testServer) {
+	defer noDocker(&started)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	c, err := tcpostgres.Run(ctx, "postgres:15-alpine",
+		tcpostgres.WithUsername(s.User),
+		tcpostgres.WithPassword(s.Password),
+		tcpostgres.WithDatabase("optimistic_lock"),
+		tcpostgres.BasicWaitStrategies())
+	if c != nil {
+		track(c)
+	}
+	if err != nil {
+		return testServer{err: err}
+	}
+	return containerServer(ctx, c, "5432/tcp", s)
+}
+
+// kafka returns the Kafka broker for the tests: KAFKA_HOST and KAFKA_PORT,
+// localhost:9092 by default, or a confluent-local container started once for
+// the whole run. It is skipped like postgres without either.
+func kafka(t testing.TB) testServer {
+	t.Helper()
+	kafkaOnce.Do(func() {
+		kafkaServer = testServer{Host: envOr("KAFKA_HOST", "localhost"), Port: envOr("KAFKA_PORT", "9092")}
+		if !reachable(kafkaServer) {
+			kafkaServer = startKafka()
+		}
+	})
+	requireServer(t, "Kafka", kafkaServer)
+	return kafkaServer
+}
+
+// startKafka runs a single-node Kafka container
+func startKafka() (started testServer) {
+	defer noDocker(&started)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	c, err := tckafka.Run(ctx, "confluentinc/confluent-local:7.5.0", tckafka.WithClusterID("optimistic-lock"))
+	if c != nil {
+		track(c)
+	}
+	if err != nil {
+		return testServer{err: err}
+	}
+	brokers, err := c.Brokers(ctx)
+	if err != nil {
+		return testServer{err: err}
+	}
+	host, port, err := net.SplitHostPort(brokers[0])
+	if err != nil {
+		return testServer{err: err}
+	}
+	return testServer{Host: host, Port: port}
+}
+
+// noDocker turns the panic testcontainers raises when it finds no Docker
+// host into the error of *s, so the tests skip instead of crashing
+func noDocker(s *testServer) {
+	if r := recover(); r != nil {
+		*s = testServer{err: fmt.Errorf("%v", r)}
+	}
+}
+
+// track has TestMain terminate container c, which may have failed to start
+func track(c testcontainers.Container) {
+	containersMu.Lock()
+	defer containersMu.Unlock()
+	containers = append(containers, c)
+}
+
+// containerServer returns s at the host and mapped port of container c
+func containerServer(ctx context.Context, c testcontainers.Container, port string, s testServer) testServer {
+	var err error
+	if s.Host, err = c.Host(ctx); err != nil {
+		return testServer{err: err}
+	}
+	mapped, err := c.MappedPort(ctx, nat.Port(port))
+	if err != nil {
+		return testServer{err: err}
+	}
+	s.Port = mapped.Port()
+	return s
+}
+
+// requireServer skips or fails the test when s is not available
+func requireServer(t testing.TB, name string, s testServer) {
+	t.Helper()
+	if s.err == nil {
+		return
+	}
+	if os.Getenv("OPTLOCK_REQUIRE_DB") == "1" {
+		t.Fatalf("No %s server and no container: %v", name, s.err)
+	}
+	t.Skipf("No %s server and no container (start one with ./db-manager.sh start): %v", name, s.err)
+}
+
+// reachable reports whether something accepts connections at s
+func reachable(s testServer) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(s.Host, s.Port), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// postgresConfig returns the settings of the test Postgres for database name
+func postgresConfig(t testing.TB, name string) database.Config {
+	t.Helper()
+	s := postgres(t)
+	return database.Config{
+		Driver:   "postgres",
+		Host:     s.Host,
+		Port:     s.Port,
+		User:     s.User,
+		Password: s.Password,
+		Name:     name,
+		SSLMode:  "disable",
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,86 @@
+package service_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/metrics"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestContentionMonitorHotKeys(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	monitor := service.NewContentionMonitor(time.Minute, metrics.NewPrometheus(reg, nil))
+	store := service.NewMemoryStore()
+	updater := service.NewUpdater(nil, service.WithStore(store), service.WithNoBackoff(), service.WithContentionMonitor(monitor))
+
+	// Balance a conflicts three times, b once and c never
+	a, _ := store.Create(0)
+	b, _ := store.Create(0)
+	c, _ := store.Create(0)
+	for _, conflicts := range []struct {
+		id uint
+		n  int
+	}{{a.ID, 3}, {b.ID, 1}, {c.ID, 0}} {
+		store.ConflictNext(conflicts.id, conflicts.n)
+		if _, err := updater.UpdateBalance(conflicts.id, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hot := monitor.HotKeys(0)
+	if len(hot) != 2 || hot[0].BalanceID != a.ID || hot[1].BalanceID != b.ID {
+		t.Fatalf("expected balances %d and %d, most conflicts first, got %+v", a.ID, b.ID, hot)
+	}
+	if hot[0].Attempts != 4 || hot[0].Conflicts != 3 || hot[0].ConflictRate != 0.75 {
+		t.Errorf("expected 3 conflicts in 4 attempts, got %+v", hot[0])
+	}
+	if top := monitor.HotKeys(1); len(top) != 1 || top[0].BalanceID != a.ID {
+		t.Errorf("expected the single hottest balance, got %+v", top)
+	}
+
+	// Metrics count balances, without naming them
+	monitor.Report()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, f := range families {
+		found[f.GetName()] = true
+		switch f.GetName() {
+		case metrics.ContendedBalances:
+			if v := f.GetMetric()[0].GetGauge().GetValue(); v != 2 {
+				t.Errorf("expected 2 contended balances, got %v", v)
+			}
+		case metrics.BalanceConflicts:
+			if h := f.GetMetric()[0].GetHistogram(); h.GetSampleCount() != 2 || h.GetSampleSum() != 4 {
+				t.Errorf("expected samples 3 and 1, got %d summing to %v", h.GetSampleCount(), h.GetSampleSum())
+			}
+		}
+	}
+	if !found[metrics.ContendedBalances] || !found[metrics.BalanceConflicts] {
+		t.Errorf("expected the contention metrics to be gathered, got %v", keys(found))
+	}
+
+	server := httptest.NewServer((&httpapi.Server{HotKeys: monitor}).Handler())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/admin/hot-keys?limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body []service.HotKey
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 with hot keys, got %d and %v", resp.StatusCode, err)
+	}
+	if len(body) != 1 || body[0] != hot[0] {
+		t.Errorf("expected %+v, got %+v", hot[:1], body)
+	}
+}
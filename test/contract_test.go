@@ -0,0 +1,128 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/ghozilaaa/optimistic-lock/cache"
+	client "github.com/ghozilaaa/optimistic-lock/clients/go"
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// contractStep is one call of a case in api/contract.json
+type contractStep struct {
+	Call        string `json:"call"`
+	Balance     string `json:"balance"`
+	IfMatch     string `json:"if_match"`
+	Delta       int64  `json:"delta"`
+	Amount      int64  `json:"amount"`
+	Consistency string `json:"consistency"`
+	MinVersion  int64  `json:"min_version"`
+	MaxStale    string `json:"max_stale"`
+	Conflict    bool   `json:"conflict"`
+	Expect      struct {
+		Amount    int64  `json:"amount"`
+		Version   int64  `json:"version"`
+		Error     string `json:"error"`
+		Status    int    `json:"status"`
+		Retryable bool   `json:"retryable"`
+	} `json:"expect"`
+}
+
+type contractCase struct {
+	Name   string         `json:"name"`
+	Amount int64          `json:"amount"`
+	Steps  []contractStep `json:"steps"`
+}
+
+// TestGoClientContract runs the contract cases shared by every client SDK
+// through the generated Go client against a fresh server per case
+func TestGoClientContract(t *testing.T) {
+	data, err := os.ReadFile("../api/contract.json")
+	if err != nil {
+		t.Fatalf("read contract: %v", err)
+	}
+	var contract struct {
+		Cases []contractCase `json:"cases"`
+	}
+	if err := json.Unmarshal(data, &contract); err != nil {
+		t.Fatalf("decode contract: %v", err)
+	}
+
+	for _, tc := range contract.Cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			db := openDB(t)
+			balance := models.Balance{Amount: tc.Amount}
+			db.Create(&balance)
+
+			sim := httpapi.NewConflictSimulator(httpapi.ConflictRules{})
+			updater := service.NewUpdater(db, service.WithCache(cache.New(16)))
+			server := httptest.NewServer((&httpapi.Server{DB: db, Updater: updater, Conflicts: sim}).Handler())
+			defer server.Close()
+			c := client.New(server.URL)
+
+			var current, stale string
+			for n, step := range tc.Steps {
+				id := int64(balance.ID)
+				if step.Balance == "missing" {
+					id = 999999
+				}
+				ifMatch := step.IfMatch
+				switch ifMatch {
+				case "current":
+					ifMatch = current
+				case "stale":
+					ifMatch = stale
+				case "none":
+					ifMatch = ""
+				}
+				if step.Conflict {
+					sim.SetRules(httpapi.ConflictRules{BalanceIDs: []uint{balance.ID}})
+				}
+
+				var got *client.Balance
+				var err error
+				ctx := context.Background()
+				switch step.Call {
+				case "getBalance":
+					got, err = c.GetBalance(ctx, id, step.Consistency, step.MinVersion, step.MaxStale)
+				case "patchBalance":
+					got, err = c.PatchBalance(ctx, id, ifMatch, client.PatchBalanceRequest{Delta: step.Delta})
+				case "putBalance":
+					got, err = c.PutBalance(ctx, id, ifMatch, client.PutBalanceRequest{Amount: step.Amount})
+				default:
+					t.Fatalf("step %d: unknown call %q", n, step.Call)
+				}
+				sim.SetRules(httpapi.ConflictRules{})
+
+				want := step.Expect
+				if want.Error != "" {
+					var apiErr *client.Error
+					if !errors.As(err, &apiErr) {
+						t.Fatalf("step %d %s: expected error %s, got %v", n, step.Call, want.Error, err)
+					}
+					if apiErr.Code != want.Error || apiErr.StatusCode != want.Status || apiErr.Retryable() != want.Retryable {
+						t.Errorf("step %d %s: expected %s %d retryable=%v, got %s %d retryable=%v", n, step.Call,
+							want.Error, want.Status, want.Retryable, apiErr.Code, apiErr.StatusCode, apiErr.Retryable())
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("step %d %s: %v", n, step.Call, err)
+				}
+				if got.Amount != want.Amount || got.Version != want.Version {
+					t.Errorf("step %d %s: expected amount %d version %d, got %+v", n, step.Call, want.Amount, want.Version, got)
+				}
+				stale, current = current, fmt.Sprintf(`"v=%d"`, got.Version)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package service_test
+
+import (
+	"runtime"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ghozilaaa/optimistic-lock/cpuquota"
+)
+
+func TestCPUQuota(t *testing.T) {
+	v2 := fstest.MapFS{
+		"cpu.max":            {Data: []byte("max 100000\n")},
+		"app.slice/cpu.max":  {Data: []byte("150000 100000\n")},
+		"idle.slice/cpu.max": {Data: []byte("max 100000\n")},
+	}
+	v1 := fstest.MapFS{
+		"cpu,cpuacct/docker/abc/cpu.cfs_quota_us":  {Data: []byte("250000\n")},
+		"cpu,cpuacct/docker/abc/cpu.cfs_period_us": {Data: []byte("100000\n")},
+		"cpu/cpu.cfs_quota_us":                     {Data: []byte("-1\n")},
+		"cpu/cpu.cfs_period_us":                    {Data: []byte("100000\n")},
+	}
+
+	tests := []struct {
+		name   string
+		fsys   fstest.MapFS
+		self   string
+		cpus   float64
+		quoted bool
+	}{
+		{"v2 quota", v2, "0::/app.slice\n", 1.5, true},
+		{"v2 namespace root", fstest.MapFS{"cpu.max": {Data: []byte("200000 100000")}}, "0::/\n", 2, true},
+		{"v2 hidden path falls back to the root", fstest.MapFS{"cpu.max": {Data: []byte("50000 100000")}}, "0::/not/visible\n", 0.5, true},
+		{"v2 unlimited", v2, "0::/idle.slice\n", 0, false},
+		{"v1 quota", v1, "12:cpu,cpuacct:/docker/abc\n4:memory:/docker/abc\n", 2.5, true},
+		{"v1 unlimited", v1, "3:cpu:/\n", 0, false},
+		{"no cgroup", fstest.MapFS{}, "", 0, false},
+	}
+	for _, tt := range tests {
+		cpus, ok := cpuquota.Read(tt.fsys, tt.self)
+		if cpus != tt.cpus || ok != tt.quoted {
+			t.Errorf("%s: expected %v %v, got %v %v", tt.name, tt.cpus, tt.quoted, cpus, ok)
+		}
+	}
+
+	if n := cpuquota.Procs(0.5); n != 1 {
+		t.Errorf("expected a fractional quota to round up to 1, got %d", n)
+	}
+	if n := cpuquota.Procs(1000); n != runtime.NumCPU() {
+		t.Errorf("expected GOMAXPROCS capped at %d CPUs, got %d", runtime.NumCPU(), n)
+	}
+}
@@ -0,0 +1,87 @@
+package service_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ghozilaaa/optimistic-lock/csvproc"
+	"github.com/ghozilaaa/optimistic-lock/models"
+)
+
+func TestReadRows(t *testing.T) {
+	input := "balance_id,delta,reference\n1, 10, ref-1\n2,-5,ref-2\n"
+	rows, err := csvproc.ReadRows(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ReadRows failed: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+	if rows[1].BalanceID != 2 || rows[1].Delta != -5 || rows[1].Reference != "ref-2" || rows[1].Line != 3 {
+		t.Errorf("unexpected row: %+v", rows[1])
+	}
+
+	if _, err := csvproc.ReadRows(strings.NewReader("1,abc,ref\n")); err == nil {
+		t.Error("expected error for invalid delta")
+	}
+}
+
+func TestProcessCSVIdempotentAndResumable(t *testing.T) {
+	t.Parallel()
+	db := schemaDB(t, postgresConfig(t, "optimistic_lock"))
+
+	// Seed with initial balance
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "adjustments.csv")
+	content := "balance_id,delta,reference\n" +
+		itoa(balance.ID) + ",10,ref-1\n" +
+		itoa(balance.ID) + ",20,ref-2\n" +
+		itoa(balance.ID) + ",20,ref-2\n" + // duplicate reference
+		"999999,5,ref-missing\n"
+	os.WriteFile(input, []byte(content), 0o644)
+
+	cfg := csvproc.Config{
+		Workers:      4,
+		FailureFile:  filepath.Join(dir, "failures.csv"),
+		ProgressFile: filepath.Join(dir, "progress.log"),
+	}
+
+	summary, err := csvproc.ProcessFile(db, input, cfg)
+	if err != nil {
+		t.Fatalf("ProcessFile failed: %v", err)
+	}
+	t.Logf("First run: %+v", summary)
+	if summary.Applied != 2 || summary.Failed != 1 {
+		t.Errorf("unexpected first run summary: %+v", summary)
+	}
+
+	// Second run resumes from the progress journal and applies nothing new
+	summary, err = csvproc.ProcessFile(db, input, cfg)
+	if err != nil {
+		t.Fatalf("ProcessFile failed: %v", err)
+	}
+	if summary.Applied != 0 {
+		t.Errorf("expected no rows applied on resume, got %+v", summary)
+	}
+
+	var updated models.Balance
+	db.First(&updated, balance.ID)
+	if updated.Amount != 1030 {
+		t.Errorf("Balance integrity failed: expected 1030, got %d", updated.Amount)
+	}
+
+	report, _ := os.ReadFile(cfg.FailureFile)
+	if !strings.Contains(string(report), "ref-missing") {
+		t.Errorf("expected failure report to contain ref-missing, got %q", report)
+	}
+}
+
+func itoa(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
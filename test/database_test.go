@@ -0,0 +1,114 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/database"
+	"github.com/ghozilaaa/optimistic-lock/secrets"
+)
+
+func TestOpenTriesEveryFailoverHost(t *testing.T) {
+	cfg := database.ConfigFromEnv()
+	cfg.Hosts = []string{"127.0.0.1:1", "127.0.0.1:2"}
+	cfg.FailoverResolveInterval = time.Second
+
+	_, err := database.Open(cfg, nil)
+	if err == nil {
+		t.Fatal("expected an error when no endpoint is reachable")
+	}
+
+	// Both endpoints must have been attempted before giving up
+	for _, want := range []string{"endpoint 0", "endpoint 1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestOpenFollowsRotatedCredentials(t *testing.T) {
+	admin, err := database.Open(postgresConfig(t, "postgres"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to postgres: %v", err)
+	}
+	t.Cleanup(func() { closeDB(admin) })
+
+	role := uniqueName("rotated")
+	if err := admin.Exec(fmt.Sprintf("CREATE ROLE %s LOGIN PASSWORD 'first'", role)).Error; err != nil {
+		t.Fatalf("Failed to create role: %v", err)
+	}
+	t.Cleanup(func() { admin.Exec(fmt.Sprintf("DROP ROLE IF EXISTS %s", role)) })
+
+	var mu sync.Mutex
+	password := "first"
+	creds, err := database.NewCredentials(context.Background(), secrets.ProviderFunc(func(context.Context) (secrets.Credentials, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return secrets.Credentials{User: role, Password: password}, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := postgresConfig(t, "postgres")
+	cfg.User, cfg.Password, cfg.Credentials = "", "", creds
+	db, err := database.Open(cfg, nil)
+	if err != nil {
+		t.Fatalf("Open with credentials: %v", err)
+	}
+	t.Cleanup(func() { closeDB(db) })
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxIdleConns(0) // every query opens a new connection
+
+	var user string
+	if err := db.Raw("SELECT current_user").Scan(&user).Error; err != nil || user != role {
+		t.Fatalf("current_user = %q, %v; want %q", user, err, role)
+	}
+
+	// Rotate the password; the next connection is refused, refreshes and retries
+	if err := admin.Exec(fmt.Sprintf("ALTER ROLE %s PASSWORD 'second'", role)).Error; err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	password = "second"
+	mu.Unlock()
+	if err := db.Raw("SELECT current_user").Scan(&user).Error; err != nil {
+		t.Fatalf("query after rotation: %v", err)
+	}
+	if got := creds.Current().Password; got != "second" {
+		t.Errorf("current password = %q, want the rotated one", got)
+	}
+}
+
+func TestRefusedConnectionsRefreshCredentialsSparingly(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	creds, err := database.NewCredentials(context.Background(), secrets.ProviderFunc(func(context.Context) (secrets.Credentials, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return secrets.Credentials{User: "app", Password: "secret"}, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := database.ConfigFromEnv()
+	cfg.Hosts = []string{"127.0.0.1:1", "127.0.0.1:2"}
+	cfg.FailoverResolveInterval = time.Second
+	cfg.Credentials = creds
+
+	// Every endpoint refuses; only the first refusal asks the provider again
+	for i := 0; i < 3; i++ {
+		if _, err := database.Open(cfg, nil); err == nil {
+			t.Fatal("expected an error when no endpoint is reachable")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 {
+		t.Errorf("provider asked %d times, want 2 (initial fetch and one refresh)", calls)
+	}
+}
@@ -0,0 +1,43 @@
+package service_test
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestRetryableErrors(t *testing.T) {
+	cases := map[string]struct {
+		err  error
+		want bool
+	}{
+		"serialization failure": {&pgconn.PgError{Code: "40001"}, true},
+		"deadlock":              {fmt.Errorf("update: %w", &pgconn.PgError{Code: "40P01"}), true},
+		"connection failure":    {&pgconn.PgError{Code: "08006"}, true},
+		"unique violation":      {&pgconn.PgError{Code: "23505"}, false},
+		"syntax error":          {&pgconn.PgError{Code: "42601"}, false},
+		"mysql deadlock":        {errors.New("Error 1213 (40001): Deadlock found when trying to get lock"), true},
+		"mysql lock wait":       {errors.New("Error 1205 (HY000): Lock wait timeout exceeded"), true},
+		"mysql duplicate":       {errors.New("Error 1062 (23000): Duplicate entry '1' for key 'PRIMARY'"), false},
+		"sqlite busy":           {errors.New("database is locked"), true},
+		"sqlite constraint":     {errors.New("UNIQUE constraint failed: balances.uid"), false},
+		"connection reset":      {fmt.Errorf("read tcp: %w", syscall.ECONNRESET), true},
+		"bad connection":        {driver.ErrBadConn, true},
+		"unexpected EOF":        {io.ErrUnexpectedEOF, true},
+		"statement timeout":     {fmt.Errorf("%w after 1s", service.ErrStatementTimeout), true},
+		"not found":             {service.ErrNotFound, false},
+		"nil":                   {nil, false},
+	}
+	for name, c := range cases {
+		if got := service.Retryable(c.err); got != c.want {
+			t.Errorf("%s: expected Retryable(%v) = %v, got %v", name, c.err, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/config"
+	"github.com/ghozilaaa/optimistic-lock/dbpool"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestPoolTuning(t *testing.T) {
+	t.Parallel()
+	sqlDB, _ := openDB(t).DB()
+
+	cfg := config.Default().Database.Pool
+	cfg.MaxOpenConns, cfg.MaxIdleConns = 1, 1
+	cfg.Tune = config.PoolTune{Enabled: true, MinOpenConns: 1, MaxOpenConns: 4, WaitThreshold: time.Millisecond, ConflictPercent: 50}
+	counts := &countingMetrics{counts: map[string]int64{}}
+	pool := dbpool.New(sqlDB, cfg, counts)
+
+	// Hold the only connection so a ping has to wait for it
+	conn, err := sqlDB.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Conn failed: %v", err)
+	}
+	done := make(chan error)
+	go func() { done <- sqlDB.Ping() }()
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	s := pool.Sample()
+	if s.Waits != 1 || s.AvgWait < 10*time.Millisecond || s.MaxOpen != 2 || sqlDB.Stats().MaxOpenConnections != 2 {
+		t.Fatalf("expected the wait to grow the pool to 2, got %+v", s)
+	}
+
+	// Mostly conflicting attempts shrink it again
+	hooks := pool.Hooks()
+	for _, err := range []error{service.ErrConflict, service.ErrConflict, nil} {
+		hooks.AfterAttempt(service.Attempt{}, err)
+	}
+	if s := pool.Sample(); s.Attempts != 3 || s.Conflicts != 2 || s.MaxOpen != 1 {
+		t.Fatalf("expected conflicts to shrink the pool to 1, got %+v", s)
+	}
+	if s := pool.Sample(); s.MaxOpen != 1 || s.Attempts != 0 {
+		t.Errorf("expected a quiet sample to keep the limit, got %+v", s)
+	}
+
+	if counts.counts[dbpool.WaitsTotal] != 1 || counts.counts[dbpool.ResizesTotal] != 2 {
+		t.Errorf("unexpected metrics: %v", counts.counts)
+	}
+}
@@ -0,0 +1,49 @@
+package service_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestConcurrentDecimalUpdates(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.DecimalBalance{Amount: decimal.RequireFromString("100.25")}
+	db.Create(&balance)
+
+	updater := service.NewUpdater(db, service.WithMaxAttempts(20), service.WithBaseBackoff(time.Millisecond))
+	delta := decimal.RequireFromString("0.01")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := updater.UpdateDecimalBalance(balance.ID, delta); err != nil {
+				t.Errorf("UpdateDecimalBalance failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	outcome, err := updater.UpdateDecimalBalance(balance.ID, delta.Neg())
+	if err != nil {
+		t.Fatalf("UpdateDecimalBalance failed: %v", err)
+	}
+	if want := decimal.RequireFromString("100.75"); !outcome.PreviousAmount.Equal(want) {
+		t.Errorf("expected previous amount %s, got %s", want, outcome.PreviousAmount)
+	}
+	if want := decimal.RequireFromString("100.74"); !outcome.NewAmount.Equal(want) {
+		t.Errorf("expected amount %s, got %s", want, outcome.NewAmount)
+	}
+	if outcome.Version != balance.Version+51 {
+		t.Errorf("expected version %d, got %d", balance.Version+51, outcome.Version)
+	}
+}
@@ -0,0 +1,141 @@
+package service_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/metrics"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+	"github.com/ghozilaaa/optimistic-lock/webhook"
+)
+
+func TestDeduplicatorStatsAndCleanup(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	m := &countingMetrics{counts: make(map[string]int64)}
+	dedup := service.NewDeduplicator(db, time.Hour, m)
+	for _, ref := range []string{"ref-1", "ref-2", "ref-1"} {
+		if _, err := dedup.Apply(ref, balance.ID, 10); err != nil {
+			t.Fatalf("Apply(%s) failed: %v", ref, err)
+		}
+	}
+
+	// Age one key past the TTL
+	db.Model(&models.DedupKey{}).Where("reference = ?", "ref-1").
+		Update("created_at", time.Now().Add(-2*time.Hour))
+
+	stats, err := dedup.Report()
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if stats.Applied != 2 || stats.Duplicates != 1 || stats.Stored != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if rate := stats.DuplicateRate(); rate < 0.33 || rate > 0.34 {
+		t.Errorf("expected duplicate rate 1/3, got %f", rate)
+	}
+	if m.counts[metrics.DedupAppliedTotal] != 2 || m.counts[metrics.DedupDuplicatesTotal] != 1 || m.gauges[metrics.DedupStoredKeys] != 2 {
+		t.Errorf("expected 2 applied, 1 duplicate and 2 stored keys recorded, got %v and %v", m.counts, m.gauges)
+	}
+	if age := m.gauges[metrics.DedupOldestSeconds]; age < 7200 {
+		t.Errorf("expected the oldest key two hours old, got %fs", age)
+	}
+
+	purged, err := dedup.Cleanup()
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("expected 1 purged key, got %d", purged)
+	}
+
+	// The ledger is left alone and still refuses the expired reference
+	var adjustments int64
+	db.Model(&models.Adjustment{}).Count(&adjustments)
+	if adjustments != 2 {
+		t.Errorf("expected both adjustments kept, got %d", adjustments)
+	}
+	if applied, err := dedup.Apply("ref-1", balance.ID, 10); applied || err != nil {
+		t.Errorf("expected the expired reference skipped, got %v and %v", applied, err)
+	}
+
+	var final models.Balance
+	db.First(&final, balance.ID)
+	if final.Amount != 1020 {
+		t.Errorf("expected amount 1020, got %d", final.Amount)
+	}
+}
+
+func TestDedupWebhookAndAdmin(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	balance := models.Balance{Amount: 100}
+	db.Create(&balance)
+
+	secret := []byte("s3cret")
+	dedup := service.NewDeduplicator(db, time.Hour, nil)
+	server := httptest.NewServer((&httpapi.Server{
+		DB:              db,
+		PaymentWebhooks: webhook.NewVerifier(secret, time.Minute),
+		Dedup:           dedup,
+		DedupAdmin:      true,
+	}).Handler())
+	defer server.Close()
+
+	// Two deliveries of one payment, signed apart as a provider retry would be
+	body := []byte(fmt.Sprintf(`{"reference":"pay-1","balance_id":%d,"delta":50}`, balance.ID))
+	for i := 0; i < 2; i++ {
+		req, _ := webhook.Signer{Secret: secret}.NewRequest(server.URL+"/webhooks/payments", body)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+	}
+
+	call := func(method, path, body string) map[string]any {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out map[string]any
+		json.NewDecoder(resp.Body).Decode(&out)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: expected 200, got %d and %v", method, path, resp.StatusCode, out)
+		}
+		return out
+	}
+	stats := call(http.MethodGet, "/admin/dedup", "")
+	if stats["applied"] != 1.0 || stats["duplicates"] != 1.0 || stats["stored"] != 1.0 {
+		t.Errorf("expected the duplicate delivery counted, got %v", stats)
+	}
+	if stats = call(http.MethodPut, "/admin/dedup", `{"ttl": "1ms"}`); stats["ttl"] != "1ms" {
+		t.Errorf("expected the TTL changed, got %v", stats)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if out := call(http.MethodPost, "/admin/dedup/cleanup", ""); out["purged"] != 1.0 {
+		t.Errorf("expected the key purged, got %v", out)
+	}
+
+	var final models.Balance
+	db.First(&final, balance.ID)
+	if final.Amount != 150 {
+		t.Errorf("expected the payment applied once, got %d", final.Amount)
+	}
+}
@@ -0,0 +1,76 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestDeleteBalanceChecksVersion(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+	readVersion := balance.Version
+
+	// An update lands between the read and the delete
+	if _, err := service.UpdateBalance(db, balance.ID, 10); err != nil {
+		t.Fatalf("UpdateBalance failed: %v", err)
+	}
+	if err := service.DeleteBalance(db, balance.ID, readVersion); !errors.Is(err, service.ErrConflict) {
+		t.Fatalf("expected ErrConflict for stale delete, got %v", err)
+	}
+
+	if err := service.DeleteBalance(db, balance.ID, readVersion+1); err != nil {
+		t.Fatalf("DeleteBalance failed: %v", err)
+	}
+
+	// Deleted balances are invisible to updates and further deletes
+	if _, err := service.UpdateBalance(db, balance.ID, 10); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound updating a deleted balance, got %v", err)
+	}
+	if err := service.DeleteBalance(db, balance.ID, readVersion+1); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound deleting twice, got %v", err)
+	}
+
+	// The row is kept for auditing
+	var deleted models.Balance
+	db.Unscoped().First(&deleted, balance.ID)
+	if !deleted.DeletedAt.Valid || deleted.Amount != 1010 {
+		t.Errorf("expected soft-deleted row with amount 1010, got %+v", deleted)
+	}
+}
+
+func TestDeletedBetweenReadAndWrite(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	// Delete the balance right after the first read, so the versioned write
+	// matches no row
+	deleted := false
+	db.Callback().Query().After("gorm:query").Register("test:delete_balance", func(tx *gorm.DB) {
+		if !deleted && tx.Statement.Table == "balances" {
+			deleted = true
+			tx.Session(&gorm.Session{NewDB: true}).Delete(&models.Balance{ID: balance.ID})
+		}
+	})
+
+	outcome, err := service.UpdateBalance(db, balance.ID, 10)
+	if !errors.Is(err, service.ErrNotFound) || errors.Is(err, service.ErrConflict) {
+		t.Fatalf("expected ErrNotFound rather than a conflict, got %v", err)
+	}
+	if outcome.Attempts != 1 || outcome.Conflicts != 0 {
+		t.Errorf("expected no retry against a deleted balance, got %+v", outcome)
+	}
+	if _, err := service.UpdateIfVersion(db, balance.ID, balance.Version, 10); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound from UpdateIfVersion, got %v", err)
+	}
+}
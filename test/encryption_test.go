@@ -0,0 +1,134 @@
+package service_test
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/encryption"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestAmountCodec(t *testing.T) {
+	if _, err := encryption.NewCodec([]byte("short")); err == nil {
+		t.Error("expected a short key to be refused")
+	}
+	codec, err := encryption.NewCodec(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row := encryption.Binding{Table: "accounts", Column: "amount", RowID: 1}
+	for _, amount := range []int64{0, 1, -250, 1 << 62} {
+		sealed := codec.Seal(amount, row)
+		if got, err := codec.Open(sealed, row); err != nil || got != amount {
+			t.Errorf("expected %d back, got %d and %v", amount, got, err)
+		}
+		if codec.Seal(amount, row) != sealed {
+			t.Errorf("expected sealing %d to be deterministic", amount)
+		}
+	}
+	if codec.Seal(1, row) == codec.Seal(2, row) {
+		t.Error("expected different amounts to seal differently")
+	}
+
+	other, _ := encryption.NewCodec(bytes.Repeat([]byte{2}, 32))
+	if _, err := other.Open(codec.Seal(5, row), row); !errors.Is(err, encryption.ErrTampered) {
+		t.Errorf("expected another key to fail, got %v", err)
+	}
+	sealed := []byte(codec.Seal(5, row))
+	sealed[len(sealed)-3] ^= 1
+	if _, err := codec.Open(string(sealed), row); !errors.Is(err, encryption.ErrTampered) {
+		t.Errorf("expected a changed amount to fail, got %v", err)
+	}
+
+	// A sealed amount moved to another row, column or table does not open
+	for _, moved := range []encryption.Binding{
+		{Table: "accounts", Column: "amount", RowID: 2},
+		{Table: "accounts", Column: "limit", RowID: 1},
+		{Table: "wallets", Column: "amount", RowID: 1},
+	} {
+		if _, err := codec.Open(codec.Seal(5, row), moved); !errors.Is(err, encryption.ErrTampered) {
+			t.Errorf("expected %+v to refuse the amount of %+v, got %v", moved, row, err)
+		}
+	}
+	if codec.Seal(5, row) == codec.Seal(5, encryption.Binding{Table: "accounts", Column: "amount", RowID: 2}) {
+		t.Error("expected equal amounts in different rows to seal differently")
+	}
+}
+
+// sealedAccount is a model whose amount is sealed at rest
+type sealedAccount struct {
+	ID      uint  `gorm:"primaryKey"`
+	Amount  int64 `gorm:"serializer:encrypted_amount"`
+	Version int   `gorm:"version"`
+}
+
+func TestEncryptedAmounts(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	codec, err := encryption.NewCodec(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryption.Register(codec)
+	if err := db.AutoMigrate(&sealedAccount{}); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if err := db.Create(&sealedAccount{Amount: 1000}).Error; !errors.Is(err, encryption.ErrUnboundRow) {
+		t.Errorf("expected a row without its ID to be refused, got %v", err)
+	}
+	account := sealedAccount{ID: 1, Amount: 1000}
+	if err := db.Create(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+	other := sealedAccount{ID: 2, Amount: 5}
+	if err := db.Create(&other).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var stored string
+	db.Raw("SELECT amount FROM sealed_accounts WHERE id = ?", account.ID).Scan(&stored)
+	if stored != codec.Seal(1000, encryption.Binding{Table: "sealed_accounts", Column: "amount", RowID: 1}) {
+		t.Errorf("expected the amount sealed at rest, got %q", stored)
+	}
+
+	table, err := service.TableOf(db, &sealedAccount{}, "Amount")
+	if err != nil || table.Codec != codec {
+		t.Fatalf("expected TableOf to pick up the codec, got %+v and %v", table, err)
+	}
+	store, err := service.NewTableStore(db, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updater := service.NewUpdater(nil, service.WithStore(store), service.WithMaxAttempts(100), service.WithBaseBackoff(time.Millisecond))
+
+	// Concurrent updates are version-checked as with plain amounts
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := updater.UpdateBalance(account.ID, -10); err != nil {
+				t.Errorf("UpdateBalance failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var read sealedAccount
+	if err := db.First(&read, account.ID).Error; err != nil || read.Amount != 920 || read.Version != 8 {
+		t.Errorf("expected 920 at version 8, got %+v and %v", read, err)
+	}
+	// Copying the sealed amount of another row does not pass
+	db.Exec("UPDATE sealed_accounts SET amount = (SELECT amount FROM sealed_accounts WHERE id = ?) WHERE id = ?", other.ID, account.ID)
+	if _, err := store.Get(account.ID); !errors.Is(err, encryption.ErrTampered) {
+		t.Errorf("expected an amount copied from another row to be refused, got %v", err)
+	}
+	db.Exec("UPDATE sealed_accounts SET amount = ? WHERE id = ?", "920", account.ID)
+	if _, err := store.Get(account.ID); !errors.Is(err, encryption.ErrTampered) {
+		t.Errorf("expected a plaintext amount to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+
+	"github.com/ghozilaaa/optimistic-lock/events"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// recordingWriter keeps the messages written to it
+type recordingWriter struct {
+	msgs []events.KafkaMessage
+}
+
+func (w *recordingWriter) WriteMessages(ctx context.Context, msgs ...events.KafkaMessage) error {
+	w.msgs = append(w.msgs, msgs...)
+	return nil
+}
+
+func TestKafkaPublisherKeysByBalance(t *testing.T) {
+	w := &recordingWriter{}
+	p := &events.KafkaPublisher{Writer: w, Topic: "balance-changes"}
+
+	event := events.BalanceChanged{BalanceID: 42, OldAmount: 10, NewAmount: 15, Delta: 5, Version: 3, At: time.Now()}
+	if err := p.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	if len(w.msgs) != 1 {
+		t.Fatalf("expected one message, got %d", len(w.msgs))
+	}
+	msg := w.msgs[0]
+	if msg.Topic != "balance-changes" || string(msg.Key) != "42" {
+		t.Errorf("unexpected topic/key: %s/%s", msg.Topic, msg.Key)
+	}
+	var decoded events.BalanceChanged
+	if err := json.Unmarshal(msg.Value, &decoded); err != nil {
+		t.Fatalf("failed to decode value: %v", err)
+	}
+	if decoded.Version != 3 || decoded.NewAmount != 15 {
+		t.Errorf("unexpected payload: %+v", decoded)
+	}
+}
+
+// plainEvent drops the hand-written encoder so encoding/json falls back to reflection
+type plainEvent events.BalanceChanged
+
+// reflectedEvent is the versioned payload as encoding/json writes it
+type reflectedEvent struct {
+	SchemaVersion int `json:"schema_version"`
+	plainEvent
+}
+
+func reflected(e events.BalanceChanged) reflectedEvent {
+	return reflectedEvent{events.SchemaVersion, plainEvent(e)}
+}
+
+func TestBalanceChangedJSONMatchesReflection(t *testing.T) {
+	for _, event := range []events.BalanceChanged{
+		{},
+		{BalanceID: 42, OldAmount: -10, NewAmount: 15, Delta: 25, Version: 3, At: time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC)},
+		{BalanceID: 1, At: time.Date(2024, 5, 1, 12, 30, 0, 0, time.FixedZone("", 2*60*60))},
+	} {
+		got, _ := json.Marshal(event)
+		want, _ := json.Marshal(reflected(event))
+		if string(got) != string(want) {
+			t.Errorf("expected %s, got %s", want, got)
+		}
+	}
+}
+
+func BenchmarkBalanceChangedJSON(b *testing.B) {
+	event := events.BalanceChanged{BalanceID: 42, OldAmount: 10, NewAmount: 15, Delta: 5, Version: 3, At: time.Now()}
+	b.Run("reflection", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			json.Marshal(reflected(event))
+		}
+	})
+	b.Run("append", func(b *testing.B) {
+		b.ReportAllocs()
+		buf := make([]byte, 0, 256)
+		for i := 0; i < b.N; i++ {
+			buf = event.AppendJSON(buf[:0])
+		}
+	})
+}
+
+func TestBalanceChangedUpcasting(t *testing.T) {
+	// Written before events carried a schema version
+	legacy := `{"balance_id":7,"old_amount":10,"new_amount":15,"delta":5,"version":2,"at":"2024-05-01T12:30:00Z"}`
+	var event events.BalanceChanged
+	if err := json.Unmarshal([]byte(legacy), &event); err != nil {
+		t.Fatalf("failed to decode a version 1 payload: %v", err)
+	}
+	if event.BalanceID != 7 || event.NewAmount != 15 || event.Version != 2 {
+		t.Errorf("unexpected event: %+v", event)
+	}
+
+	// Current payloads round-trip
+	data, _ := json.Marshal(event)
+	var decoded events.BalanceChanged
+	if err := json.Unmarshal(data, &decoded); err != nil || !decoded.At.Equal(event.At) || decoded.Delta != 5 {
+		t.Errorf("round trip failed: %+v %v", decoded, err)
+	}
+
+	// A consumer older than the producer refuses rather than guessing
+	future := `{"schema_version":99,"balance_id":7}`
+	if err := json.Unmarshal([]byte(future), &event); !errors.Is(err, events.ErrUnknownSchemaVersion) {
+		t.Errorf("expected ErrUnknownSchemaVersion, got %v", err)
+	}
+}
+
+func TestSchemaUpcasterChain(t *testing.T) {
+	// A later schema adding a currency, defaulted for older payloads
+	schema := events.Schema{
+		Current: 3,
+		Upcasters: map[int]events.Upcaster{
+			1: events.BalanceChangedSchema.Upcasters[1],
+			2: func(fields map[string]json.RawMessage) error {
+				fields["currency"] = json.RawMessage(`"USD"`)
+				return nil
+			},
+		},
+	}
+
+	upcast, err := schema.Upcast([]byte(`{"balance_id":7,"delta":5}`))
+	if err != nil {
+		t.Fatalf("Upcast failed: %v", err)
+	}
+	var fields struct {
+		SchemaVersion int    `json:"schema_version"`
+		Currency      string `json:"currency"`
+		Delta         int64  `json:"delta"`
+	}
+	json.Unmarshal(upcast, &fields)
+	if fields.SchemaVersion != 3 || fields.Currency != "USD" || fields.Delta != 5 {
+		t.Errorf("unexpected upcast payload: %s", upcast)
+	}
+
+	delete(schema.Upcasters, 2)
+	if _, err := schema.Upcast([]byte(`{"balance_id":7}`)); !errors.Is(err, events.ErrUnknownSchemaVersion) {
+		t.Errorf("expected a missing upcaster to fail, got %v", err)
+	}
+}
+
+func TestEventPublishedWithUpdate(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	var published []events.BalanceChanged
+	updater := service.NewUpdater(db, service.WithEventPublisher(events.PublisherFunc(
+		func(ctx context.Context, e events.BalanceChanged) error {
+			published = append(published, e)
+			return nil
+		})))
+	if _, err := updater.UpdateBalance(balance.ID, 25); err != nil {
+		t.Fatalf("UpdateBalance failed: %v", err)
+	}
+	if len(published) != 1 {
+		t.Fatalf("expected one event, got %d", len(published))
+	}
+	e := published[0]
+	if e.BalanceID != balance.ID || e.OldAmount != 1000 || e.NewAmount != 1025 || e.Delta != 25 || e.Version != balance.Version+1 {
+		t.Errorf("unexpected event: %+v", e)
+	}
+
+	// A failed publish rolls the update back
+	errBroker := errors.New("broker unavailable")
+	failing := service.NewUpdater(db, service.WithEventPublisher(events.PublisherFunc(
+		func(context.Context, events.BalanceChanged) error { return errBroker })))
+	if _, err := failing.UpdateBalance(balance.ID, 25); !errors.Is(err, errBroker) {
+		t.Fatalf("expected publish error, got %v", err)
+	}
+
+	final, _ := service.GetBalance(db, balance.ID)
+	if final.Amount != 1025 {
+		t.Errorf("expected the failed update to be rolled back, got amount %d", final.Amount)
+	}
+}
+
+func TestKafkaProducer(t *testing.T) {
+	s := kafka(t)
+	broker := net.JoinHostPort(s.Host, s.Port)
+	topic := uniqueName("balance_changes")
+	conn, err := kafkago.Dial("tcp", broker)
+	if err != nil {
+		t.Fatalf("Failed to connect to kafka: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.CreateTopics(kafkago.TopicConfig{Topic: topic, NumPartitions: 3, ReplicationFactor: 1}); err != nil {
+		t.Fatalf("Failed to create topic: %v", err)
+	}
+
+	producer := events.NewKafkaProducer(broker)
+	defer producer.Close()
+	p := &events.KafkaPublisher{Writer: producer, Topic: topic}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for version := 1; version <= 3; version++ {
+		event := events.BalanceChanged{BalanceID: 42, NewAmount: int64(version), Delta: 1, Version: version, At: time.Now()}
+		if err := p.Publish(ctx, event); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+
+	// The events of a balance share a partition, so they are read in order
+	reader := kafkago.NewReader(kafkago.ReaderConfig{Brokers: []string{broker}, Topic: topic, GroupID: topic})
+	defer reader.Close()
+	partition := -1
+	for version := 1; version <= 3; version++ {
+		msg, err := reader.ReadMessage(ctx)
+		if err != nil {
+			t.Fatalf("ReadMessage failed: %v", err)
+		}
+		var event events.BalanceChanged
+		if err := json.Unmarshal(msg.Value, &event); err != nil || event.Version != version || string(msg.Key) != "42" {
+			t.Fatalf("expected version %d of balance 42, got %s: %s (%v)", version, msg.Key, msg.Value, err)
+		}
+		if partition >= 0 && msg.Partition != partition {
+			t.Errorf("expected one partition, got %d and %d", partition, msg.Partition)
+		}
+		partition = msg.Partition
+		if len(msg.Headers) != 1 || msg.Headers[0].Key != "type" || string(msg.Headers[0].Value) != "balance.changed" {
+			t.Errorf("expected the type header, got %v", msg.Headers)
+		}
+	}
+}
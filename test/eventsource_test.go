@@ -0,0 +1,66 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestEventSourcedRebuild(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 100}
+	db.Create(&balance)
+	updater := service.NewUpdater(db, service.WithEventSourcing())
+	updater.UpdateBalance(balance.ID, 10)
+	updater.UpdateBalance(balance.ID, -30)
+	updater.ApplyAdjustment("evt-1", balance.ID, 5)
+	if _, err := updater.RunScript(context.Background(), []service.Op{{Kind: service.OpCredit, BalanceID: balance.ID, Amount: 15}}); err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+
+	result, err := service.VerifySnapshot(db, balance.ID)
+	if err != nil || result.Events != 4 || result.Amount != 100 || result.Version != 4 || !result.Consistent() {
+		t.Fatalf("expected 4 events adding up to the snapshot, got %+v %v", result, err)
+	}
+
+	// A write that skips the version check leaves the snapshot out of line
+	db.Model(&models.Balance{}).Where("id = ?", balance.ID).Update("amount", 999)
+	if result, err := service.VerifySnapshot(db, balance.ID); err != nil || result.Consistent() || result.Snapshot.Amount != 999 {
+		t.Errorf("expected drift, got %+v %v", result, err)
+	}
+	result, err = service.Rebuild(db, balance.ID)
+	if err != nil || !result.Repaired || result.Version != 5 || result.Events != 5 {
+		t.Fatalf("expected a repair at version 5, got %+v %v", result, err)
+	}
+	if final, _ := service.GetBalance(db, balance.ID); final.Amount != 100 || final.Version != 5 {
+		t.Errorf("expected the snapshot reset to 100 at version 5, got %+v", final)
+	}
+	if result, err := service.Rebuild(db, balance.ID); err != nil || result.Repaired {
+		t.Errorf("expected a consistent balance to be left alone, got %+v %v", result, err)
+	}
+
+	// Writes without the mode cannot be rebuilt and are not overwritten
+	service.UpdateBalance(db, balance.ID, 1)
+	if _, err := service.Rebuild(db, balance.ID); !errors.Is(err, service.ErrUnrecordedWrites) {
+		t.Errorf("expected ErrUnrecordedWrites, got %v", err)
+	}
+	if final, _ := service.GetBalance(db, balance.ID); final.Amount != 101 {
+		t.Errorf("expected the unrecorded write kept, got %d", final.Amount)
+	}
+
+	// A missing event breaks the chain
+	other := models.Balance{Amount: 0}
+	db.Create(&other)
+	for i := 0; i < 3; i++ {
+		updater.UpdateBalance(other.ID, 1)
+	}
+	db.Where("balance_id = ? AND version = 2", other.ID).Delete(&models.BalanceEvent{})
+	if _, err := service.Rebuild(db, other.ID); !errors.Is(err, service.ErrEventChainBroken) {
+		t.Errorf("expected ErrEventChainBroken, got %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !sqlite
+
+package service_test
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// openDB returns a private database with testModels for a feature test: a
+// clone of the Postgres template, or with the sqlite tag a SQLite file (see
+// sqlite_test.go). Feature tests use it so they run on either driver.
+func openDB(t testing.TB) *gorm.DB {
+	t.Helper()
+	return cloneDB(t, nil)
+}
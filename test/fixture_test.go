@@ -0,0 +1,11 @@
+package service_test
+
+import "github.com/ghozilaaa/optimistic-lock/models"
+
+// testModels are the tables every test database starts with. Tests of
+// features with tables of their own, such as schedules, migrate those.
+var testModels = []any{
+	&models.Balance{}, &models.Adjustment{}, &models.DecimalBalance{}, &models.FailoverEpoch{},
+	&models.UpdateConflict{}, &models.FailedUpdate{}, &models.BalanceEvent{}, &models.BalanceShard{},
+	&models.DedupKey{},
+}
@@ -0,0 +1,74 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestForceSet(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	if err := db.AutoMigrate(&models.BalanceOverride{}); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	balance := models.Balance{Amount: 100}
+	db.Create(&balance)
+	service.UpdateBalance(db, balance.ID, 5)
+	stale, _ := service.GetBalance(db, balance.ID)
+	if _, err := service.Freeze(db, balance.ID); err != nil {
+		t.Fatal(err)
+	}
+	frozen, _ := service.GetBalance(db, balance.ID)
+
+	var asked service.WriteOp
+	updater := service.NewUpdater(db, service.WithEventSourcing(), service.WithAuthorizer(func(_ context.Context, _ models.Balance, op service.WriteOp) error {
+		asked = op
+		return nil
+	}))
+	if err := db.AutoMigrate(&models.BalanceEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := updater.ForceSet(balance.ID, 42, " "); err == nil {
+		t.Error("expected a reason to be required")
+	}
+	fixed, err := updater.As("support").ForceSet(balance.ID, 42, "TICKET-1: bad import")
+	if err != nil || fixed.Amount != 42 || fixed.Version != frozen.Version+1 || fixed.Status != models.BalanceFrozen {
+		t.Fatalf("expected 42 at the next version, still frozen, got %+v and %v", fixed, err)
+	}
+	if want := (service.WriteOp{Kind: service.WriteForceSet, Delta: -63, Amount: 42}); asked != want {
+		t.Errorf("expected the authorizer asked about %+v, got %+v", want, asked)
+	}
+
+	overrides, err := service.ListOverrides(db, balance.ID)
+	want := models.BalanceOverride{
+		BalanceID: balance.ID, Actor: "support", Reason: "TICKET-1: bad import",
+		PreviousAmount: 105, Amount: 42, PreviousVersion: frozen.Version, Version: fixed.Version,
+	}
+	if err != nil || len(overrides) != 1 {
+		t.Fatalf("expected one override, got %v and %v", overrides, err)
+	}
+	got := overrides[0]
+	got.ID, got.CreatedAt = 0, time.Time{}
+	if got != want {
+		t.Errorf("expected override %+v, got %+v", want, got)
+	}
+
+	// The change is published as a delta, and writers holding the old version conflict
+	var event models.BalanceEvent
+	db.Where("balance_id = ?", balance.ID).First(&event)
+	if event.Delta != -63 || event.Amount != 42 || event.Version != fixed.Version {
+		t.Errorf("expected an event of -63 at version %d, got %+v", fixed.Version, event)
+	}
+	service.Unfreeze(db, balance.ID)
+	if _, err := service.UpdateIfVersion(db, balance.ID, stale.Version, 1); !errors.Is(err, service.ErrConflict) {
+		t.Errorf("expected a stale write to conflict, got %v", err)
+	}
+	if _, err := service.ForceSet(db, 9999, 1, "missing"); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	client "github.com/ghozilaaa/optimistic-lock/clients/go"
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+	"github.com/ghozilaaa/optimistic-lock/velocity"
+)
+
+func TestBalanceHistory(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	balance := models.Balance{Amount: 100}
+	db.Create(&balance)
+	updater := service.NewUpdater(db, service.WithEventSourcing())
+	for _, delta := range []int64{10, -30, 5, 20, -1} {
+		if _, err := updater.UpdateBalance(balance.ID, delta); err != nil {
+			t.Fatalf("UpdateBalance failed: %v", err)
+		}
+	}
+
+	first, err := service.GetHistory(db, balance.ID, service.TimeRange{}, service.Pagination{Limit: 3})
+	if err != nil || len(first.Entries) != 3 || first.Next == "" {
+		t.Fatalf("expected a first page of 3, got %+v and %v", first, err)
+	}
+	rest, err := service.GetHistory(db, balance.ID, service.TimeRange{}, service.Pagination{Cursor: first.Next})
+	if err != nil || len(rest.Entries) != 2 || rest.Next != "" {
+		t.Fatalf("expected a last page of 2, got %+v and %v", rest, err)
+	}
+	running := []int64{110, 80, 85, 105, 104}
+	for i, e := range append(first.Entries, rest.Entries...) {
+		if e.Version != i+1 || e.Amount != running[i] {
+			t.Errorf("entry %d: expected version %d at %d, got %+v", i, i+1, running[i], e)
+		}
+	}
+
+	future := service.TimeRange{Since: time.Now().Add(time.Hour)}
+	if empty, err := service.GetHistory(db, balance.ID, future, service.Pagination{}); err != nil || len(empty.Entries) != 0 {
+		t.Errorf("expected no entries in the future, got %+v and %v", empty, err)
+	}
+	if _, err := service.GetHistory(db, balance.ID+1, service.TimeRange{}, service.Pagination{}); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	server := httptest.NewServer((&httpapi.Server{DB: db}).Handler())
+	defer server.Close()
+	c := client.New(server.URL)
+	history, err := c.GetHistory(context.Background(), int64(balance.ID), "", "", "", 0, "")
+	if err != nil || len(history.Entries) != 5 || history.Entries[4].Amount != 104 {
+		t.Errorf("expected 5 entries ending at 104, got %+v and %v", history, err)
+	}
+	if _, err := c.GetHistory(context.Background(), int64(balance.ID), "yesterday", "", "", 0, ""); err == nil {
+		t.Error("expected an invalid since to be rejected")
+	}
+}
+
+func TestVelocityReport(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	busy := models.Balance{Amount: 1000}
+	quiet := models.Balance{Amount: 1000}
+	db.Create(&busy)
+	db.Create(&quiet)
+
+	for i, delta := range []int64{-100, -200, -300, 50} {
+		service.ApplyAdjustment(db, fmt.Sprintf("busy-%d", i), busy.ID, delta)
+	}
+	service.ApplyAdjustment(db, "quiet-1", quiet.ID, -10)
+	// Outside the window
+	db.Create(&models.Adjustment{Reference: "old", BalanceID: quiet.ID, Delta: -5000, CreatedAt: time.Now().Add(-48 * time.Hour)})
+
+	server := httptest.NewServer((&httpapi.Server{
+		DB:            db,
+		VelocityRules: velocity.Rules{MaxDebits: 2, MaxDebitTotal: 1000},
+	}).Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/reports/velocity?window=24h&flagged=true")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var report velocity.Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if len(report.Accounts) != 1 {
+		t.Fatalf("expected one flagged account, got %+v", report.Accounts)
+	}
+	got := report.Accounts[0]
+	if got.BalanceID != busy.ID || got.Debits != 3 || got.DebitTotal != 600 || got.Credits != 1 || got.CreditTotal != 50 {
+		t.Errorf("unexpected account: %+v", got)
+	}
+	if len(got.Flags) != 1 {
+		t.Errorf("expected only the debit count rule to fire, got %v", got.Flags)
+	}
+}
@@ -0,0 +1,213 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/ghozilaaa/optimistic-lock/hotpath"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// scriptedRedis records the scripts it is asked to run and replies in order
+type scriptedRedis struct {
+	keys    [][]string
+	args    [][]any
+	replies []any
+}
+
+func (r *scriptedRedis) Eval(ctx context.Context, script string, keys []string, args ...any) (any, error) {
+	r.keys = append(r.keys, keys)
+	r.args = append(r.args, args)
+	reply := r.replies[0]
+	r.replies = r.replies[1:]
+	return reply, nil
+}
+
+func TestRedisStoreDecodesReplies(t *testing.T) {
+	redis := &scriptedRedis{replies: []any{
+		[]any{"100", "3", "0", "0", "active"},            // claim: hash fields come back as strings
+		[]any{int64(1), "150", "4", "50", "0", "active"}, // applied
+		[]any{int64(0), "150", "4", "50", "0", "active"}, // version mismatch
+		[]any{int64(-1)}, // not hot
+		[]any{"150", "4", "50", "0", []byte("frozen")},   // set status
+		[]any{int64(0), "150", "4", "50", "0", "frozen"}, // refused while frozen
+		[]any{"7", "50"}, // take
+		[]any{"12"},      // hot ids
+	}}
+	store := hotpath.RedisStore{Client: redis, Prefix: "{test}:"}
+	ctx := context.Background()
+
+	if state, err := store.Claim(ctx, 12, 100, 3, "active"); err != nil || state != (hotpath.State{Amount: 100, Version: 3, Status: "active"}) {
+		t.Fatalf("Claim = %+v, %v", state, err)
+	}
+	if want := []string{"{test}:12", "{test}:ids"}; !reflect.DeepEqual(redis.keys[0], want) {
+		t.Errorf("Claim keys = %v, want %v", redis.keys[0], want)
+	}
+
+	state, hot, applied, err := store.Apply(ctx, 12, 50, -1)
+	if err != nil || !hot || !applied || state.Amount != 150 || state.Pending != 50 {
+		t.Errorf("Apply = %+v hot=%v applied=%v, %v", state, hot, applied, err)
+	}
+	if _, hot, applied, _ := store.Apply(ctx, 12, 50, 3); !hot || applied {
+		t.Errorf("expected a version mismatch, got hot=%v applied=%v", hot, applied)
+	}
+	if _, hot, _, _ := store.Apply(ctx, 13, 50, -1); hot {
+		t.Error("expected balance 13 not to be hot")
+	}
+	if state, hot, err := store.SetStatus(ctx, 12, "frozen"); err != nil || !hot || state.Status != "frozen" {
+		t.Errorf("SetStatus = %+v hot=%v, %v", state, hot, err)
+	}
+	if want := []any{"frozen"}; !reflect.DeepEqual(redis.args[4], want) {
+		t.Errorf("SetStatus args = %v, want %v", redis.args[4], want)
+	}
+	if state, hot, applied, _ := store.Apply(ctx, 12, 50, -1); !hot || applied || state.Status != "frozen" {
+		t.Errorf("expected a frozen balance to refuse, got %+v hot=%v applied=%v", state, hot, applied)
+	}
+	if batch, err := store.Take(ctx, 12); err != nil || batch != (hotpath.Batch{Seq: 7, Delta: 50}) {
+		t.Errorf("Take = %+v, %v", batch, err)
+	}
+	if ids, err := store.Hot(ctx); err != nil || !reflect.DeepEqual(ids, []uint{12}) {
+		t.Errorf("Hot = %v, %v", ids, err)
+	}
+}
+
+// failingAck is a hotpath store that loses the next acknowledgement, as if
+// the process died between writing a batch and acknowledging it
+type failingAck struct {
+	hotpath.Store
+	fail bool
+}
+
+func (s *failingAck) Ack(ctx context.Context, id uint, seq, base int64) error {
+	if s.fail {
+		s.fail = false
+		return errors.New("connection reset")
+	}
+	return s.Store.Ack(ctx, id, seq, base)
+}
+
+func TestHotPathFlushes(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	ctx := context.Background()
+
+	hot, cold := models.Balance{Amount: 1000}, models.Balance{Amount: 10}
+	db.Create(&hot)
+	db.Create(&cold)
+	store := &failingAck{Store: hotpath.NewMemoryStore()}
+	coordinator := hotpath.NewCoordinator(db, store, nil)
+	if _, err := coordinator.Claim(ctx, hot.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := coordinator.Add(ctx, hot.ID, 2); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The writes stay in the store until a flush
+	if state, _ := coordinator.Balance(ctx, hot.ID); state.Amount != 1100 || state.Pending != 100 {
+		t.Errorf("expected 1100 with 100 pending, got %+v", state)
+	}
+	if balance, _ := service.GetBalance(db, hot.ID); balance.Amount != 1000 {
+		t.Errorf("expected the database to lag until a flush, got %d", balance.Amount)
+	}
+
+	// A lost acknowledgement makes the next flush resend the batch, which is applied once
+	store.fail = true
+	if err := coordinator.Flush(ctx); err == nil {
+		t.Fatal("expected the lost acknowledgement to be reported")
+	}
+	coordinator.Add(ctx, hot.ID, -30)
+	if err := coordinator.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := coordinator.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if balance, _ := service.GetBalance(db, hot.ID); balance.Amount != 1070 {
+		t.Errorf("expected 1070 after the flushes, got %d", balance.Amount)
+	}
+
+	// Conditional writes check the hot version
+	state, _ := coordinator.Balance(ctx, hot.ID)
+	if _, err := coordinator.AddIfVersion(ctx, hot.ID, state.Version-1, 5); !errors.Is(err, service.ErrConflict) {
+		t.Errorf("expected a conflict for an old hot version, got %v", err)
+	}
+	if released, err := coordinator.Release(ctx, hot.ID); err != nil || !released {
+		t.Errorf("Release = %v, %v", released, err)
+	}
+
+	// Cold balances are written directly
+	if state, err := coordinator.Add(ctx, cold.ID, 5); err != nil || state.Amount != 15 {
+		t.Errorf("expected a direct write to 15, got %+v %v", state, err)
+	}
+}
+
+func TestHotPathStatus(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	ctx := context.Background()
+
+	balance := models.Balance{Amount: 100}
+	db.Create(&balance)
+	coordinator := hotpath.NewCoordinator(db, hotpath.NewMemoryStore(), nil)
+	if state, err := coordinator.Claim(ctx, balance.ID); err != nil || state.Status != models.BalanceActive {
+		t.Fatalf("Claim = %+v, %v", state, err)
+	}
+	coordinator.Add(ctx, balance.ID, 20)
+
+	// Freezing flushes what was accepted before and refuses writes in the store
+	if _, err := coordinator.Freeze(ctx, balance.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := service.GetBalance(db, balance.ID); got.Amount != 120 || got.Status != models.BalanceFrozen {
+		t.Errorf("expected a frozen 120, got %d %s", got.Amount, got.Status)
+	}
+	var statusErr *service.StatusError
+	if _, err := coordinator.Add(ctx, balance.ID, 5); !errors.As(err, &statusErr) || statusErr.Status != models.BalanceFrozen {
+		t.Errorf("expected a frozen balance to refuse, got %v", err)
+	}
+	if _, err := coordinator.Unfreeze(ctx, balance.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := coordinator.Add(ctx, balance.ID, 5); err != nil {
+		t.Errorf("expected an unfrozen balance to take writes, got %v", err)
+	}
+
+	// A freeze around the coordinator is picked up by the failed flush
+	if _, err := service.Freeze(db, balance.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := coordinator.Flush(ctx); !errors.Is(err, service.ErrNotActive) {
+		t.Errorf("expected the flush to fail on the frozen balance, got %v", err)
+	}
+	if _, err := coordinator.Add(ctx, balance.ID, 5); !errors.Is(err, service.ErrNotActive) {
+		t.Errorf("expected writes to be refused after the failed flush, got %v", err)
+	}
+	if _, err := coordinator.Unfreeze(ctx, balance.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing flushes and releases the balance
+	if _, err := coordinator.Close(ctx, balance.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := service.GetBalance(db, balance.ID); got.Amount != 125 || got.Status != models.BalanceClosed {
+		t.Errorf("expected a closed 125, got %d %s", got.Amount, got.Status)
+	}
+	if _, err := coordinator.Add(ctx, balance.ID, 5); !errors.Is(err, service.ErrNotActive) {
+		t.Errorf("expected a closed balance to refuse, got %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestGetOperationStatus(t *testing.T) {
+	ops := service.NewOperationRegistry(0)
+	server := httptest.NewServer((&httpapi.Server{Operations: ops}).Handler())
+	defer server.Close()
+
+	pending := ops.Start(1, 10)
+	failed := ops.Start(2, -5)
+	ops.Complete(failed, errors.New("db down"))
+	missing := ops.Start(3, 1)
+	ops.Complete(missing, gorm.ErrRecordNotFound)
+
+	cases := []struct {
+		id     string
+		code   int
+		status string
+	}{
+		{pending, http.StatusOK, "pending"},
+		{failed, http.StatusOK, "failed"},
+		{missing, http.StatusOK, "failed"},
+		{"unknown", http.StatusNotFound, ""},
+	}
+	for _, c := range cases {
+		resp, err := http.Get(server.URL + "/operations/" + c.id)
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		var body map[string]any
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+
+		if resp.StatusCode != c.code {
+			t.Errorf("%s: expected %d, got %d", c.id, c.code, resp.StatusCode)
+		}
+		if c.status != "" && body["status"] != c.status {
+			t.Errorf("%s: expected status %s, got %v", c.id, c.status, body["status"])
+		}
+	}
+}
+
+func TestSubmitTrackedRefused(t *testing.T) {
+	ops := service.NewOperationRegistry(0)
+	async := service.NewAsyncUpdater(service.NewUpdater(nil), 1, 1)
+	if err := async.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	if _, err := async.SubmitTracked(ops, 1, 10); !errors.Is(err, service.ErrAsyncUpdaterClosed) {
+		t.Fatalf("expected ErrAsyncUpdaterClosed, got %v", err)
+	}
+	if n := ops.Len(); n != 0 {
+		t.Errorf("expected no operation for a refused update, got %d", n)
+	}
+
+	server := httptest.NewServer((&httpapi.Server{DB: &gorm.DB{}, Operations: ops, Async: async}).Handler())
+	defer server.Close()
+	resp, err := http.Post(server.URL+"/balances/1/updates", "application/json", strings.NewReader(`{"delta": 10}`))
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", resp.StatusCode)
+	}
+}
+
+func TestSubmitUpdateOperation(t *testing.T) {
+	db := openDB(t)
+
+	mine := models.Balance{Amount: 100, TenantID: "acme"}
+	theirs := models.Balance{Amount: 100, TenantID: "globex"}
+	db.Create(&mine)
+	db.Create(&theirs)
+
+	async := service.NewAsyncUpdater(service.NewUpdater(db), 2, 10)
+	ops := service.NewOperationRegistry(0)
+	server := httptest.NewServer((&httpapi.Server{DB: db, Async: async, Operations: ops, TenantHeader: "X-Tenant-ID"}).Handler())
+	defer server.Close()
+
+	submit := func(id uint) string {
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/balances/%d/updates", server.URL, id), strings.NewReader(`{"delta": 25}`))
+		req.Header.Set("X-Tenant-ID", "acme")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d", resp.StatusCode)
+		}
+		return resp.Header.Get("Location")
+	}
+	ok, other := submit(mine.ID), submit(theirs.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := async.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	get := func(location, tenantID string) (int, map[string]any) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+location, nil)
+		if tenantID != "" {
+			req.Header.Set("X-Tenant-ID", tenantID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var body map[string]any
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+	for location, want := range map[string]string{ok: "succeeded", other: "failed"} {
+		if _, body := get(location, "acme"); body["status"] != want {
+			t.Errorf("%s: expected %s, got %v", location, want, body)
+		}
+	}
+	// Operations are only visible to the tenant that submitted them
+	if code, _ := get(ok, "globex"); code != http.StatusNotFound {
+		t.Errorf("expected 404 for another tenant's operation, got %d", code)
+	}
+	if code, _ := get(ok, ""); code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a tenant, got %d", code)
+	}
+
+	db.First(&mine, mine.ID)
+	db.First(&theirs, theirs.ID)
+	if mine.Amount != 125 || theirs.Amount != 100 {
+		t.Errorf("expected 125 and an untouched 100, got %d and %d", mine.Amount, theirs.Amount)
+	}
+}
+
+func TestBalanceETags(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	server := httptest.NewServer((&httpapi.Server{DB: db}).Handler())
+	defer server.Close()
+	url := fmt.Sprintf("%s/balances/%d", server.URL, balance.ID)
+
+	send := func(method, ifMatch, body string) *http.Response {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s failed: %v", method, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := send(http.MethodGet, "", "")
+	etag := resp.Header.Get("ETag")
+	if want := fmt.Sprintf(`"v=%d"`, balance.Version); etag != want {
+		t.Fatalf("expected ETag %s, got %s", want, etag)
+	}
+
+	if resp := send(http.MethodPatch, "", `{"delta": 10}`); resp.StatusCode != http.StatusPreconditionRequired {
+		t.Errorf("expected 428 without If-Match, got %d", resp.StatusCode)
+	}
+
+	resp = send(http.MethodPatch, etag, `{"delta": 10}`)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag {
+		t.Fatalf("expected 200 with a new ETag, got %d %s", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+	next := resp.Header.Get("ETag")
+
+	// The first ETag is now stale
+	if resp := send(http.MethodPut, etag, `{"amount": 0}`); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 for a stale ETag, got %d", resp.StatusCode)
+	}
+	if resp := send(http.MethodPut, next, `{"amount": 500}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 for PUT, got %d", resp.StatusCode)
+	}
+
+	final, _ := service.GetBalance(db, balance.ID)
+	if final.Amount != 500 {
+		t.Errorf("expected amount 500, got %d", final.Amount)
+	}
+}
+
+func TestAttemptHeader(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	for _, enabled := range []bool{false, true} {
+		server := httptest.NewServer((&httpapi.Server{DB: db, AttemptHeader: enabled}).Handler())
+		current, _ := service.GetBalance(db, balance.ID)
+		req, _ := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/balances/%d", server.URL, balance.ID), strings.NewReader(`{"delta": 5}`))
+		req.Header.Set("If-Match", fmt.Sprintf(`"v=%d"`, current.Version))
+		resp, err := http.DefaultClient.Do(req)
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		header := resp.Header.Get("X-OptLock-Attempts")
+		switch {
+		case resp.StatusCode != http.StatusOK:
+			t.Errorf("expected 200, got %d", resp.StatusCode)
+		case !enabled && header != "":
+			t.Errorf("expected no attempts header by default, got %q", header)
+		case enabled && !strings.HasPrefix(header, "attempts=1; conflicts=0; elapsed="):
+			t.Errorf("unexpected attempts header %q", header)
+		}
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	api := &httpapi.Server{DB: db}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	status := func(path string) int {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status("/healthz") != http.StatusOK || status("/readyz") != http.StatusOK {
+		t.Fatal("expected a healthy, ready server")
+	}
+
+	// A lost database makes the server unready but still alive
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+	if got := status("/readyz"); got != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without a database, got %d", got)
+	}
+	if got := status("/healthz"); got != http.StatusOK {
+		t.Errorf("expected /healthz to ignore the database, got %d", got)
+	}
+
+	draining := &httpapi.Server{DB: openDB(t)}
+	draining.Drain()
+	server.Config.Handler = draining.Handler()
+	if got := status("/readyz"); got != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while draining, got %d", got)
+	}
+}
+
+func TestAdminWorkers(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	balance := models.Balance{Amount: 0}
+	db.Create(&balance)
+
+	serializer := service.NewKeyedSerializer(8)
+	updater := service.NewUpdater(db, service.WithSerializer(serializer))
+	async := service.NewAsyncUpdater(updater, 2, 100)
+	defer async.Shutdown(context.Background())
+
+	server := httptest.NewServer((&httpapi.Server{DB: db, Workers: &httpapi.Workers{Serializer: serializer, Async: async}}).Handler())
+	defer server.Close()
+
+	workers := func(method, body string) (int, map[string]float64) {
+		req, _ := http.NewRequest(method, server.URL+"/admin/workers", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s /admin/workers failed: %v", method, err)
+		}
+		defer resp.Body.Close()
+		var sizes map[string]float64
+		json.NewDecoder(resp.Body).Decode(&sizes)
+		return resp.StatusCode, sizes
+	}
+
+	if status, sizes := workers(http.MethodGet, ""); status != http.StatusOK || sizes["serializer_stripes"] != 8 ||
+		sizes["async_workers"] != 2 || int(sizes["gomaxprocs"]) != runtime.GOMAXPROCS(0) {
+		t.Fatalf("unexpected worker sizes: %d %v", status, sizes)
+	}
+
+	// Resize both pools; GOMAXPROCS is left as it is so other tests are not affected
+	status, sizes := workers(http.MethodPut, fmt.Sprintf(`{"gomaxprocs": %d, "serializer_stripes": 32, "async_workers": 5}`, runtime.GOMAXPROCS(0)))
+	if status != http.StatusOK || sizes["serializer_stripes"] != 32 || sizes["async_workers"] != 5 {
+		t.Fatalf("unexpected sizes after resize: %d %v", status, sizes)
+	}
+	if status, _ := workers(http.MethodPut, `{"async_workers": -1}`); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for a negative size, got %d", status)
+	}
+
+	// Updates keep flowing through the resized pools
+	async.Resize(1)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		if err := async.Submit(balance.ID, 1, func(_ service.UpdateOutcome, err error) {
+			if err != nil {
+				t.Errorf("async update failed: %v", err)
+			}
+			wg.Done()
+		}); err != nil {
+			t.Fatalf("submit failed: %v", err)
+		}
+	}
+	wg.Wait()
+	if async.Workers() != 1 {
+		t.Errorf("expected 1 worker, got %d", async.Workers())
+	}
+	if final, _ := service.GetBalance(db, balance.ID); final.Amount != 20 {
+		t.Errorf("expected amount 20, got %d", final.Amount)
+	}
+
+	// Without Workers the admin endpoints are not served
+	plain := httptest.NewServer((&httpapi.Server{DB: db}).Handler())
+	defer plain.Close()
+	if resp, err := http.Get(plain.URL + "/admin/workers"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 without Workers, got %v %v", resp, err)
+	}
+}
@@ -0,0 +1,108 @@
+package service_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/ids"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestIDGenerators(t *testing.T) {
+	formats := map[string]*regexp.Regexp{
+		"uuid":   regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		"uuidv7": regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		"ulid":   regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`),
+	}
+	for name, format := range formats {
+		generate, ok := ids.ByName(name)
+		if !ok {
+			t.Fatalf("expected generator %s", name)
+		}
+		seen := make(map[string]bool)
+		for i := 0; i < 100; i++ {
+			id := generate()
+			if !format.MatchString(id) || seen[id] {
+				t.Fatalf("%s: expected unique well-formed IDs, got %q", name, id)
+			}
+			seen[id] = true
+		}
+	}
+	if _, ok := ids.ByName("snowflake"); ok {
+		t.Error("expected an unknown generator to be refused")
+	}
+
+	// ULIDs sort by creation time across milliseconds
+	first := ids.ULID()
+	time.Sleep(2 * time.Millisecond)
+	if second := ids.ULID(); second <= first {
+		t.Errorf("expected %s to sort after %s", second, first)
+	}
+}
+
+func TestBalanceUIDs(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	if err := db.Use(ids.Plugin{Generate: ids.ULID}); err != nil {
+		t.Fatal(err)
+	}
+
+	one, err := service.CreateBalance(db, "alice", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if one.UID == nil || len(*one.UID) != 26 {
+		t.Fatalf("expected a ULID, got %v", one.UID)
+	}
+	mine := "my-own-uid"
+	batch := []models.Balance{{Amount: 1}, {Amount: 2, UID: &mine}}
+	if err := db.Create(&batch).Error; err != nil {
+		t.Fatal(err)
+	}
+	if batch[0].UID == nil || *batch[0].UID == *one.UID || *batch[1].UID != mine {
+		t.Errorf("expected a new ULID and the given UID kept, got %v and %v", batch[0].UID, batch[1].UID)
+	}
+
+	outcome, err := service.UpdateBalanceByUID(db, *one.UID, 5)
+	if err != nil || outcome.NewAmount != 105 {
+		t.Errorf("expected the update by UID applied, got %+v and %v", outcome, err)
+	}
+	if b, err := service.GetBalanceByUID(db, *one.UID); err != nil || b.ID != one.ID || b.Version != 1 {
+		t.Errorf("expected balance %d at version 1, got %+v and %v", one.ID, b, err)
+	}
+	if _, err := service.UpdateBalanceByUID(db, "missing", 1); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	dup := models.Balance{UID: &mine}
+	if err := db.Create(&dup).Error; err == nil {
+		t.Error("expected a duplicate UID to be refused")
+	}
+
+	// The HTTP routes take the UID in place of the ID
+	server := httptest.NewServer((&httpapi.Server{DB: db}).Handler())
+	defer server.Close()
+	for path, want := range map[string]int{
+		"/balances/" + *one.UID:           http.StatusOK,
+		"/balances/" + fmt.Sprint(one.ID): http.StatusOK,
+		"/balances/missing":               http.StatusNotFound,
+	} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got struct{ Amount int64 }
+		json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if resp.StatusCode != want || want == http.StatusOK && got.Amount != 105 {
+			t.Errorf("GET %s: expected %d, got %d with %+v", path, want, resp.StatusCode, got)
+		}
+	}
+}
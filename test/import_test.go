@@ -0,0 +1,54 @@
+package service_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestImportBalances(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	seeds := make([]service.BalanceSeed, 2500)
+	for i := range seeds {
+		seeds[i] = service.BalanceSeed{OwnerID: fmt.Sprintf("owner-%d", i), Currency: "USD", Amount: int64(i), Version: 3}
+	}
+	seeds[0] = service.BalanceSeed{ExternalRef: "legacy-0", Amount: 7, Version: 1}
+	result, err := service.ImportBalances(db, seeds)
+	if err != nil || result.Inserted != 2500 {
+		t.Fatalf("expected 2500 inserts across batches, got %+v and %v", result, err)
+	}
+	if balance, _ := service.GetBalanceByKey(db, "owner-42", "USD"); balance.Amount != 42 || balance.Version != 3 {
+		t.Errorf("expected amount 42 at the seed version 3, got %+v", balance)
+	}
+
+	// Importing again changes nothing; a live write since is not undone
+	live, _ := service.GetBalanceByKey(db, "owner-1", "USD")
+	service.UpdateBalance(db, live.ID, 100)
+	if result, err := service.ImportBalances(db, seeds); err != nil || result.Skipped != 2500 {
+		t.Errorf("expected every seed skipped, got %+v and %v", result, err)
+	}
+	if balance, _ := service.GetBalance(db, live.ID); balance.Amount != 101 {
+		t.Errorf("expected the live write kept, got %+v", balance)
+	}
+
+	// A newer version overwrites
+	newer := []service.BalanceSeed{
+		{OwnerID: "owner-1", Currency: "USD", Amount: 500, Version: 9},
+		{ExternalRef: "legacy-0", Amount: 8, Version: 2},
+		{OwnerID: "owner-1", Currency: "EUR", Amount: 1},
+	}
+	if result, err := service.ImportBalances(db, newer); err != nil || result != (service.ImportResult{Inserted: 1, Updated: 2}) {
+		t.Errorf("expected 2 updates and 1 insert, got %+v and %v", result, err)
+	}
+	if balance, _ := service.GetBalanceByRef(db, "legacy-0"); balance.Amount != 8 || balance.Version != 2 {
+		t.Errorf("expected the referenced balance overwritten, got %+v", balance)
+	}
+
+	duplicate := []service.BalanceSeed{{OwnerID: "owner-x"}, {OwnerID: "owner-x"}}
+	if _, err := service.ImportBalances(db, duplicate); err == nil {
+		t.Error("expected a key repeated in one batch to be rejected")
+	}
+}
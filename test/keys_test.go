@@ -0,0 +1,103 @@
+package service_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestCreateBalanceOncePerOwner(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	ids := map[uint]bool{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			balance, ok, err := service.GetOrCreateBalance(db, "owner-1", 500)
+			if err != nil {
+				t.Errorf("GetOrCreateBalance failed: %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if ok {
+				created++
+			}
+			ids[balance.ID] = true
+		}()
+	}
+	wg.Wait()
+	if created != 1 || len(ids) != 1 {
+		t.Errorf("expected one balance created, got %d created with ids %v", created, ids)
+	}
+
+	existing, err := service.CreateBalance(db, "owner-1", 999)
+	if !errors.Is(err, service.ErrBalanceExists) {
+		t.Fatalf("expected ErrBalanceExists, got %v", err)
+	}
+	if existing.Amount != 500 || !ids[existing.ID] {
+		t.Errorf("expected the existing balance, got %+v", existing)
+	}
+
+	other, err := service.CreateBalance(db, "owner-2", 0)
+	if err != nil || other.ID == existing.ID {
+		t.Errorf("expected a new balance for another owner, got %+v, %v", other, err)
+	}
+}
+
+func TestUpdateBalanceByKey(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	usd, _, err := service.GetOrCreateBalanceByKey(db, "owner-1", "USD", 100)
+	if err != nil {
+		t.Fatalf("GetOrCreateBalanceByKey failed: %v", err)
+	}
+	eur, created, err := service.GetOrCreateBalanceByKey(db, "owner-1", "EUR", 50)
+	if err != nil || !created || eur.ID == usd.ID {
+		t.Fatalf("expected a second balance for another currency, got %+v, %v and %v", eur, created, err)
+	}
+
+	// Concurrent writers by key resolve to the same row and keep the version check
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := service.UpdateBalanceByKey(db, "owner-1", "USD", 5, service.WithMaxAttempts(50)); err != nil {
+				t.Errorf("UpdateBalanceByKey failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if balance, _ := service.GetBalanceByKey(db, "owner-1", "USD"); balance.Amount != 150 || balance.Version != 10 {
+		t.Errorf("expected amount 150 at version 10, got %+v", balance)
+	}
+	if balance, _ := service.GetBalance(db, eur.ID); balance.Amount != 50 {
+		t.Errorf("expected the EUR balance untouched, got %+v", balance)
+	}
+
+	if err := service.SetExternalRef(db, eur.ID, "wallet-42"); err != nil {
+		t.Fatalf("SetExternalRef failed: %v", err)
+	}
+	if err := service.SetExternalRef(db, usd.ID, "wallet-42"); err == nil {
+		t.Error("expected a reference in use to be rejected")
+	}
+	if outcome, err := service.UpdateBalanceByRef(db, "wallet-42", -20); err != nil || outcome.NewAmount != 30 {
+		t.Errorf("expected amount 30, got %+v and %v", outcome, err)
+	}
+
+	if _, err := service.UpdateBalanceByKey(db, "owner-1", "GBP", 1); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown key, got %v", err)
+	}
+	if _, err := service.UpdateBalanceByRef(db, "wallet-0", 1); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown reference, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package service_test
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ghozilaaa/optimistic-lock/kvstore"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestKVStoreConcurrentUpdates(t *testing.T) {
+	store, err := kvstore.Open(filepath.Join(t.TempDir(), "balances.db"))
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	balance, _ := store.Create(1000)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.UpdateBalance(balance.ID, 10); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	conflictCount := 0
+	for err := range errs {
+		if errors.Is(err, service.ErrConflict) {
+			conflictCount++
+		} else {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	updated, _ := store.Get(balance.ID)
+	t.Logf("Final balance: %d, version: %d, conflicts: %d", updated.Amount, updated.Version, conflictCount)
+	expected := int64(1000 + (100-conflictCount)*10)
+	if updated.Amount != expected {
+		t.Errorf("Balance integrity failed: expected %d, got %d", expected, updated.Amount)
+	}
+	if updated.Version != 100-conflictCount {
+		t.Errorf("expected version %d, got %d", 100-conflictCount, updated.Version)
+	}
+}
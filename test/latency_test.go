@@ -0,0 +1,80 @@
+package service_test
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/latency"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestLatencyDistributions(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	if d := latency.Fixed(5 * time.Millisecond).Sample(r); d != 5*time.Millisecond {
+		t.Errorf("expected fixed 5ms, got %v", d)
+	}
+
+	normal := latency.Normal{Mean: 10 * time.Millisecond, StdDev: 20 * time.Millisecond}
+	pareto := latency.Pareto{Scale: time.Millisecond, Shape: 1.5, Max: 50 * time.Millisecond}
+	var normalSum time.Duration
+	const n = 10000
+	for i := 0; i < n; i++ {
+		d := normal.Sample(r)
+		if d < 0 {
+			t.Fatalf("normal sample is negative: %v", d)
+		}
+		normalSum += d
+
+		p := pareto.Sample(r)
+		if p < pareto.Scale || p > pareto.Max {
+			t.Fatalf("pareto sample %v outside [%v, %v]", p, pareto.Scale, pareto.Max)
+		}
+	}
+
+	// Clamping negatives to zero pulls the mean above 10ms
+	if mean := normalSum / n; mean < 10*time.Millisecond || mean > 20*time.Millisecond {
+		t.Errorf("unexpected normal mean %v", mean)
+	}
+}
+
+func TestLatencyInjection(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	injector := latency.New(map[latency.Op]latency.Distribution{latency.Update: latency.Fixed(50 * time.Millisecond)})
+	if err := db.Use(injector); err != nil {
+		t.Fatalf("Failed to register injector: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := service.UpdateBalance(db, balance.ID, 10); err != nil {
+		t.Fatalf("UpdateBalance failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected the update to take at least 50ms, took %v", elapsed)
+	}
+
+	// A cancelled context ends the delay with an error (GORM joins it with the
+	// rollback error as text)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := db.WithContext(ctx).Model(&models.Balance{}).Where("id = ?", balance.ID).Update("amount", 0).Error
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+
+	injector.Set(latency.Update, nil)
+	start = time.Now()
+	service.UpdateBalance(db, balance.ID, 10)
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Errorf("expected no delay after removing it, took %v", elapsed)
+	}
+}
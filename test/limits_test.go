@@ -0,0 +1,203 @@
+package service_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestBalanceAggregate(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	if err := db.AutoMigrate(&models.BalanceLimit{}); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	balance := models.Balance{Amount: 1000, Limits: []models.BalanceLimit{{Kind: "daily_debit", Amount: 500}}}
+	db.Create(&balance)
+
+	// Saving bumps the root and the changed limits, and creates new ones
+	aggregate, err := service.GetBalanceAggregate(db, balance.ID)
+	if err != nil || len(aggregate.Limits) != 1 {
+		t.Fatalf("expected the balance with one limit, got %+v and %v", aggregate, err)
+	}
+	aggregate.Limits[0].Amount = 300
+	aggregate.Limits = append(aggregate.Limits, models.BalanceLimit{Kind: "max_amount", Amount: 5000})
+	saved, err := service.SaveBalanceAggregate(db, aggregate)
+	if err != nil || saved.Version != 1 || saved.Limits[0].Version != 1 || saved.Limits[1].ID == 0 {
+		t.Fatalf("expected versions bumped and the new limit created, got %+v and %v", saved, err)
+	}
+
+	// A stale aggregate conflicts on the root
+	if _, err := service.SaveBalanceAggregate(db, aggregate); !errors.Is(err, service.ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+
+	// A limit changed on its own conflicts even when the root did not move,
+	// and the error names it; nothing of the aggregate is written
+	current, _ := service.GetBalanceAggregate(db, balance.ID)
+	db.Model(&models.BalanceLimit{}).Where("kind = ?", "max_amount").Updates(map[string]any{"amount": 1, "version": 1})
+	current.Amount = 900
+	current.Limits[0].Amount = 100
+	current.Limits[1].Amount = 200
+	_, err = service.SaveBalanceAggregate(db, current)
+	var conflict *service.ConflictError
+	if !errors.As(err, &conflict) || conflict.Root || len(conflict.Limits) != 1 || conflict.Limits[0] != "max_amount" {
+		t.Fatalf("expected a conflict on max_amount only, got %v", err)
+	}
+	after, _ := service.GetBalanceAggregate(db, balance.ID)
+	if after.Amount != 1000 || after.Version != 1 || after.Limits[0].Amount != 300 {
+		t.Errorf("expected nothing written, got %+v", after)
+	}
+
+	// A limit created by someone else conflicts as well
+	after.Limits = append(after.Limits, models.BalanceLimit{Kind: "daily_debit"})
+	if _, err := service.SaveBalanceAggregate(db, after); !errors.As(err, &conflict) || conflict.Limits[0] != "daily_debit" {
+		t.Errorf("expected a conflict on the duplicate limit, got %v", err)
+	}
+	if _, err := service.SaveBalanceAggregate(db, models.Balance{ID: 999}); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	if err := db.AutoMigrate(&models.BalanceLimit{}); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	plain := models.Balance{Amount: 100}
+	limited := models.Balance{Amount: 100, Limits: []models.BalanceLimit{
+		{Kind: models.LimitMinAmount, Amount: -50},
+		{Kind: models.LimitMaxAmount, Amount: 200},
+	}}
+	db.Create(&plain)
+	db.Create(&limited)
+
+	v, err := service.ValidateUpdate(db, plain.ID, -100)
+	if err != nil || v.NewAmount != 0 || v.Version != 0 || v.NewVersion != 1 {
+		t.Errorf("expected a debit to zero to pass, got %+v and %v", v, err)
+	}
+	if v, err := service.ValidateUpdate(db, plain.ID, -101); !errors.Is(err, service.ErrInsufficientFunds) || v.NewAmount != -1 {
+		t.Errorf("expected ErrInsufficientFunds with the post state, got %+v and %v", v, err)
+	}
+
+	// A min_amount limit allows an overdraft, max_amount caps credits
+	if _, err := service.ValidateUpdate(db, limited.ID, -150); err != nil {
+		t.Errorf("expected an overdraft within the limit to pass, got %v", err)
+	}
+	if _, err := service.ValidateUpdate(db, limited.ID, -151); !errors.Is(err, service.ErrInsufficientFunds) {
+		t.Errorf("expected ErrInsufficientFunds past the overdraft, got %v", err)
+	}
+	if _, err := service.ValidateUpdate(db, limited.ID, 101); !errors.Is(err, service.ErrLimitExceeded) {
+		t.Errorf("expected ErrLimitExceeded, got %v", err)
+	}
+	if _, err := service.ValidateUpdate(db, 999, 1); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// Nothing was written, and the version makes the update binding
+	if current, _ := service.GetBalance(db, plain.ID); current.Amount != 100 || current.Version != 0 {
+		t.Fatalf("expected the balance untouched, got %+v", current)
+	}
+	if _, err := service.UpdateIfVersion(db, plain.ID, v.Version, -100); err != nil {
+		t.Errorf("expected the validated update to apply, got %v", err)
+	}
+
+	server := httptest.NewServer((&httpapi.Server{DB: db}).Handler())
+	defer server.Close()
+	resp, err := http.Post(fmt.Sprintf("%s/balances/%d/validate", server.URL, limited.ID), "application/json", strings.NewReader(`{"delta": 500}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		NewAmount  int64    `json:"new_amount"`
+		Valid      bool     `json:"valid"`
+		Violations []string `json:"violations"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK || body.Valid || body.NewAmount != 600 || len(body.Violations) != 1 {
+		t.Errorf("expected 200 with one violation, got %d and %+v", resp.StatusCode, body)
+	}
+}
+
+func TestSpendingLimits(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	if err := db.AutoMigrate(&models.BalanceLimit{}); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	balance := models.Balance{Amount: 1000, Limits: []models.BalanceLimit{{Kind: models.LimitDailyDebit, Amount: 500}}}
+	db.Create(&balance)
+	updater := service.NewUpdater(db, service.WithSpendingLimits(), service.WithMaxAttempts(100), service.WithBaseBackoff(time.Millisecond))
+
+	// Concurrent debits cannot both slip under the cap
+	var wg sync.WaitGroup
+	var applied, refused atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := updater.UpdateBalance(balance.ID, -100)
+			switch {
+			case err == nil:
+				applied.Add(1)
+			case errors.Is(err, service.ErrLimitExceeded):
+				refused.Add(1)
+			default:
+				t.Errorf("UpdateBalance failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if applied.Load() != 5 || refused.Load() != 3 {
+		t.Errorf("expected 5 debits applied and 3 refused, got %d and %d", applied.Load(), refused.Load())
+	}
+	var limit models.BalanceLimit
+	db.Where("balance_id = ?", balance.ID).First(&limit)
+	if current, _ := service.GetBalance(db, balance.ID); current.Amount != 500 || limit.Used != 500 {
+		t.Errorf("expected 500 debited and counted, got amount %d and %d used", current.Amount, limit.Used)
+	}
+
+	// Credits are not counted, and a new day starts from zero
+	if _, err := updater.UpdateBalance(balance.ID, 100); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := service.ValidateUpdate(db, balance.ID, -1); !errors.Is(err, service.ErrLimitExceeded) {
+		t.Errorf("expected ValidateUpdate to see the spent limit, got %+v and %v", v, err)
+	}
+	yesterday := time.Now().UTC().AddDate(0, 0, -1)
+	db.Model(&limit).Update("period_start", yesterday)
+	if _, err := updater.UpdateBalance(balance.ID, -200); err != nil {
+		t.Errorf("expected a debit on a new day to pass, got %v", err)
+	}
+
+	// The HTTP API refuses a debit past the limit with 422
+	server := httptest.NewServer((&httpapi.Server{DB: db, Updater: updater}).Handler())
+	defer server.Close()
+	current, _ := service.GetBalance(db, balance.ID)
+	req, _ := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/balances/%d", server.URL, balance.ID), strings.NewReader(`{"delta": -400}`))
+	req.Header.Set("If-Match", fmt.Sprintf(`"v=%d"`, current.Version))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d", resp.StatusCode)
+	}
+	if after, _ := service.GetBalance(db, balance.ID); after.Amount != current.Amount || after.Version != current.Version {
+		t.Errorf("expected the refused debit rolled back, got %+v", after)
+	}
+}
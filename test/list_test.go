@@ -0,0 +1,85 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	client "github.com/ghozilaaa/optimistic-lock/clients/go"
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestListBalances(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	for i := 0; i < 25; i++ {
+		service.GetOrCreateBalanceByKey(db, fmt.Sprintf("owner-%d", i%5), fmt.Sprint("C", i/5), int64(100-i%10))
+	}
+
+	// Pages by ID cover every balance once, in order
+	var seen []uint
+	page := service.Pagination{Limit: 10}
+	for {
+		result, err := service.ListBalances(db, service.BalanceFilter{}, page)
+		if err != nil {
+			t.Fatalf("ListBalances failed: %v", err)
+		}
+		for _, b := range result.Balances {
+			seen = append(seen, b.ID)
+		}
+		if result.Next == "" {
+			break
+		}
+		page.Cursor = result.Next
+	}
+	if len(seen) != 25 || seen[0] != 1 || seen[24] != 25 {
+		t.Errorf("expected IDs 1 to 25 once each, got %v", seen)
+	}
+
+	// Filters, and amount order with ties broken by ID
+	low, high := int64(93), int64(96)
+	filter := service.BalanceFilter{MinAmount: &low, MaxAmount: &high}
+	first, err := service.ListBalances(db, filter, service.Pagination{Order: service.ByAmount, Limit: 4})
+	if err != nil || len(first.Balances) != 4 || first.Next == "" {
+		t.Fatalf("expected a full first page, got %+v and %v", first, err)
+	}
+	rest, _ := service.ListBalances(db, filter, service.Pagination{Order: service.ByAmount, Cursor: first.Next, Limit: 100})
+	all := append(first.Balances, rest.Balances...)
+	if len(all) != 9 {
+		t.Fatalf("expected 9 balances between 93 and 96, got %d", len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		prev, cur := all[i-1], all[i]
+		if cur.Amount < prev.Amount || (cur.Amount == prev.Amount && cur.ID <= prev.ID) {
+			t.Errorf("out of order at %d: %+v after %+v", i, cur, prev)
+		}
+	}
+	owned, _ := service.ListBalances(db, service.BalanceFilter{OwnerID: "owner-3", Currency: "C2"}, service.Pagination{})
+	if len(owned.Balances) != 1 || owned.Next != "" {
+		t.Errorf("expected one balance of owner-3 in C2, got %+v", owned)
+	}
+
+	// A cursor of the other order is rejected
+	if _, err := service.ListBalances(db, filter, service.Pagination{Cursor: first.Next}); !errors.Is(err, service.ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+
+	// The listed version works as If-Match for a conditional write
+	server := httptest.NewServer((&httpapi.Server{DB: db}).Handler())
+	defer server.Close()
+	c := client.New(server.URL)
+	list, err := c.ListBalances(context.Background(), "owner-1", "C0", 0, 0, "", "", 0, "")
+	if err != nil || len(list.Balances) != 1 || list.Balances[0].OwnerID != "owner-1" {
+		t.Fatalf("expected the balance of owner-1 in C0, got %+v and %v", list, err)
+	}
+	listed := list.Balances[0]
+	if _, err := c.PatchBalance(context.Background(), listed.ID, fmt.Sprintf(`"v=%d"`, listed.Version), client.PatchBalanceRequest{Delta: 1}); err != nil {
+		t.Errorf("expected the conditional write to succeed, got %v", err)
+	}
+	if _, err := c.ListBalances(context.Background(), "", "", 0, 0, "size", "", 0, ""); err == nil {
+		t.Error("expected an unknown order to be rejected")
+	}
+}
@@ -0,0 +1,289 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/loadgen"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestCompareReports(t *testing.T) {
+	old := loadgen.Report{Name: "main", Runs: []loadgen.Run{
+		{TPS: 390, P99Ms: 40, ConflictRate: 0.20},
+		{TPS: 392, P99Ms: 42, ConflictRate: 0.21},
+		{TPS: 388, P99Ms: 41, ConflictRate: 0.19},
+	}}
+	new := loadgen.Report{Name: "branch", Runs: []loadgen.Run{
+		{TPS: 420, P99Ms: 41, ConflictRate: 0.20},
+		{TPS: 422, P99Ms: 40, ConflictRate: 0.22},
+		{TPS: 418, P99Ms: 42, ConflictRate: 0.18},
+	}}
+
+	deltas := loadgen.Compare(old, new)
+	if len(deltas) != 3 {
+		t.Fatalf("expected 3 deltas, got %d", len(deltas))
+	}
+
+	tps := deltas[0]
+	if math.Abs(tps.Diff-30) > 1e-9 {
+		t.Errorf("expected TPS diff 30, got %f", tps.Diff)
+	}
+	if !tps.Significant() {
+		t.Errorf("expected TPS change to be significant, CI [%f, %f]", tps.CILow, tps.CIHigh)
+	}
+
+	p99 := deltas[1]
+	if p99.Significant() {
+		t.Errorf("expected p99 change to be noise, CI [%f, %f]", p99.CILow, p99.CIHigh)
+	}
+
+	single := loadgen.Compare(loadgen.Report{Runs: old.Runs[:1]}, new)
+	if single[0].HasCI {
+		t.Error("expected no confidence interval with a single run")
+	}
+}
+
+func TestSummarizeStrategies(t *testing.T) {
+	reports := []loadgen.Report{
+		{Name: "optimistic", Runs: []loadgen.Run{
+			{TPS: 400, P50Ms: 2, P99Ms: 40, ConflictRate: 0.30},
+			{TPS: 420, P50Ms: 4, P99Ms: 44, ConflictRate: 0.10},
+		}},
+		{Name: "atomic", Runs: []loadgen.Run{{TPS: 615, P50Ms: 1, P99Ms: 5}}},
+	}
+
+	s := loadgen.Summarize(reports[0])
+	want := loadgen.Summary{Name: "optimistic", Runs: 2, TPS: 410, P50Ms: 3, P99Ms: 42, ConflictRate: 0.20}
+	if math.Abs(s.ConflictRate-want.ConflictRate) > 1e-9 {
+		t.Errorf("expected conflict rate %f, got %f", want.ConflictRate, s.ConflictRate)
+	}
+	s.ConflictRate = want.ConflictRate
+	if s != want {
+		t.Errorf("expected %+v, got %+v", want, s)
+	}
+
+	var out bytes.Buffer
+	loadgen.PrintSummaries(&out, reports)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a header and 2 rows, got:\n%s", out.String())
+	}
+	if fields := strings.Fields(lines[1]); fields[0] != "optimistic" || fields[3] != "+0.0%" || fields[6] != "20.00%" {
+		t.Errorf("unexpected baseline row %q", lines[1])
+	}
+	if fields := strings.Fields(lines[2]); fields[0] != "atomic" || fields[3] != "+50.0%" {
+		t.Errorf("unexpected atomic row %q", lines[2])
+	}
+}
+
+func TestLoadShapes(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	constant := loadgen.Constant{TPS: 100, Duration: time.Second}.Offsets(rng)
+	if len(constant) != 100 || constant[1] != 10*time.Millisecond || constant[99] != 990*time.Millisecond {
+		t.Errorf("unexpected constant offsets: %d, %v ... %v", len(constant), constant[1], constant[len(constant)-1])
+	}
+
+	variable := loadgen.Variable{TPS: 100, Duration: time.Second, Jitter: 0.5}.Offsets(rng)
+	if len(variable) != 100 {
+		t.Fatalf("expected 100 variable offsets, got %d", len(variable))
+	}
+	for i := 1; i < len(variable); i++ {
+		if gap := variable[i] - variable[i-1]; gap < 5*time.Millisecond || gap > 15*time.Millisecond {
+			t.Fatalf("interval %d is %v, want 10ms ±50%%", i, gap)
+		}
+	}
+
+	// 0 to 100 TPS over 2s sends 100 transactions, three quarters in the second half
+	ramp := loadgen.Ramp{From: 0, To: 100, Duration: 2 * time.Second}.Offsets(rng)
+	if len(ramp) != 100 {
+		t.Fatalf("expected 100 ramp offsets, got %d", len(ramp))
+	}
+	if second := ramp[25]; second < 990*time.Millisecond || second > 1010*time.Millisecond {
+		t.Errorf("expected transaction 25 at 1s, got %v", second)
+	}
+
+	// A soak streams its offsets instead of listing hours of them up front
+	soak := loadgen.Soak{TPS: 4, Duration: 10 * time.Hour}
+	var n int
+	var last time.Duration
+	for offset := range soak.Stream(rng) {
+		n++
+		last = offset
+	}
+	if n != 144000 || last != 10*time.Hour-250*time.Millisecond {
+		t.Errorf("expected 144000 soak offsets up to 9h59m59.75s, got %d up to %v", n, last)
+	}
+
+	burst, err := loadgen.ParseBurst("3x10ms+1s, 2x5ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{0, 10 * time.Millisecond, 20 * time.Millisecond, 1020 * time.Millisecond, 1025 * time.Millisecond}
+	got := burst.Offsets(rng)
+	if len(got) != len(want) {
+		t.Fatalf("expected burst offsets %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected burst offsets %v, got %v", want, got)
+		}
+	}
+	if burst.String() != "burst 3x10ms+1s,2x5ms" {
+		t.Errorf("unexpected burst string %q", burst)
+	}
+
+	for _, spec := range []string{"", "10", "0x1ms", "5xfast", "5x1ms+soon"} {
+		if _, err := loadgen.ParseBurst(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	var h loadgen.Histogram
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	if h.Count() != 1000 || h.Max() != time.Second {
+		t.Errorf("unexpected count %d or max %v", h.Count(), h.Max())
+	}
+	if mean := h.Mean(); mean != 500500*time.Microsecond {
+		t.Errorf("expected mean 500.5ms, got %v", mean)
+	}
+	for _, q := range []struct {
+		q    float64
+		want time.Duration
+	}{{0.5, 500 * time.Millisecond}, {0.9, 900 * time.Millisecond}, {0.99, 990 * time.Millisecond}, {0.999, 999 * time.Millisecond}, {1, time.Second}} {
+		got := h.Quantile(q.q)
+		if math.Abs(float64(got-q.want))/float64(q.want) > 0.001 {
+			t.Errorf("quantile %g: expected %v within 0.1%%, got %v", q.q, q.want, got)
+		}
+	}
+
+	var total int
+	for _, b := range h.Buckets() {
+		total += b.Count
+	}
+	if total != 1000 {
+		t.Errorf("expected the buckets to hold 1000 latencies, got %d", total)
+	}
+
+	// Merging two halves gives the same distribution
+	var low, high loadgen.Histogram
+	for i := 1; i <= 1000; i++ {
+		if i <= 500 {
+			low.Record(time.Duration(i) * time.Millisecond)
+		} else {
+			high.Record(time.Duration(i) * time.Millisecond)
+		}
+	}
+	low.Merge(&high)
+	if low.Count() != 1000 || low.Mean() != h.Mean() || low.Quantile(0.999) != h.Quantile(0.999) {
+		t.Errorf("expected the merged histogram to match, got %d, %v, %v", low.Count(), low.Mean(), low.Quantile(0.999))
+	}
+
+	var out bytes.Buffer
+	if err := h.WritePercentiles(&out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if !strings.HasPrefix(strings.TrimSpace(lines[0]), "Value") || lines[2] != "       1.000 0.000000000000          1           1.00" {
+		t.Errorf("unexpected head of distribution:\n%s", strings.Join(lines[:3], "\n"))
+	}
+	if last := lines[len(lines)-4]; last != "    1000.000 1.000000000000       1000" {
+		t.Errorf("expected the distribution to end at the max, got %q", last)
+	}
+	if footer := lines[len(lines)-2]; footer != "#[Max     =     1000.000, Total count    =         1000]" {
+		t.Errorf("unexpected footer %q", footer)
+	}
+}
+
+func TestGenerateInterims(t *testing.T) {
+	var reported atomic.Int64
+	pool := func() sql.DBStats {
+		return sql.DBStats{OpenConnections: 4, InUse: 1, WaitCount: reported.Load() * 2, WaitDuration: time.Duration(reported.Load()) * time.Millisecond}
+	}
+	res := loadgen.Generate(context.Background(), loadgen.Soak{TPS: 200, Duration: 500 * time.Millisecond},
+		func() (service.UpdateOutcome, error) {
+			time.Sleep(time.Millisecond)
+			return service.UpdateOutcome{Attempts: 1}, nil
+		},
+		loadgen.WithInterim(100*time.Millisecond, func(loadgen.Interim) { reported.Add(1) }),
+		loadgen.WithPoolStats(pool))
+
+	if len(res.Interims) < 3 || int(reported.Load()) != len(res.Interims) {
+		t.Fatalf("expected an interim every 100ms, each reported, got %d and %d reported", len(res.Interims), reported.Load())
+	}
+	var sent int
+	for i, in := range res.Interims {
+		sent += in.Sent
+		if in.Sent < 10 || in.TPS < 100 || in.P50Ms < 1 || in.HeapMB <= 0 || in.Goroutines < 1 {
+			t.Errorf("interim %d: unexpected %+v", i, in)
+		}
+		if in.OpenConns != 4 || in.InUseConns != 1 || (i > 0 && (in.Waits != 2 || in.WaitMs != 1)) {
+			t.Errorf("interim %d: expected the pool stats and their change, got %+v", i, in)
+		}
+	}
+	if sent > res.Sent {
+		t.Errorf("expected the interims to add up to at most %d sent, got %d", res.Sent, sent)
+	}
+	if run := res.Run(); len(run.Interims) != len(res.Interims) {
+		t.Errorf("expected the interims in the report, got %d", len(run.Interims))
+	}
+}
+
+func TestGenerateLoad(t *testing.T) {
+	var calls atomic.Int64
+	res := loadgen.Generate(context.Background(), loadgen.Constant{TPS: 1000, Duration: 50 * time.Millisecond},
+		func() (service.UpdateOutcome, error) {
+			switch calls.Add(1) % 5 {
+			case 0:
+				return service.UpdateOutcome{}, service.ErrRetryExhausted
+			case 1:
+				return service.UpdateOutcome{}, errors.New("connection reset")
+			case 2:
+				return service.UpdateOutcome{Attempts: 2}, nil
+			}
+			return service.UpdateOutcome{Attempts: 1}, nil
+		})
+
+	if res.Sent != 50 || res.Succeeded != 30 || res.Retried != 10 || res.Conflicts != 10 || res.Failures != 10 {
+		t.Errorf("unexpected counts: %+v", res)
+	}
+	if res.Latency.Count() != 50 || len(res.Errors) != 10 {
+		t.Errorf("expected 50 latencies and 10 sampled errors, got %d and %d", res.Latency.Count(), len(res.Errors))
+	}
+	if res.ConflictRate() != 0.2 {
+		t.Errorf("expected conflict rate 0.2, got %f", res.ConflictRate())
+	}
+	if res.Elapsed < 49*time.Millisecond {
+		t.Errorf("expected the run to be paced over 50ms, took %v", res.Elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if res := loadgen.Generate(ctx, loadgen.Constant{TPS: 10, Duration: time.Second}, nil); res.Sent != 0 {
+		t.Errorf("expected a cancelled run to send nothing, sent %d", res.Sent)
+	}
+
+	var buf bytes.Buffer
+	run := res.Run()
+	if err := loadgen.WriteCSV(&buf, loadgen.Report{Name: "ci", Runs: []loadgen.Run{run}}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "name,run,shape,sent") || !strings.HasPrefix(lines[1], "ci,1,constant 1000 tps for 50ms,50,30,10,10,10,") {
+		t.Errorf("unexpected CSV:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,118 @@
+package service_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestMetadata(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	alice, err := service.CreateBalance(db, "alice", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.Metadata != nil {
+		t.Fatalf("expected no metadata, got %v", alice.Metadata)
+	}
+
+	patched, err := service.PatchMetadata(db, alice, map[string]any{"tier": "gold", "limits": map[string]any{"atm": 500.0, "pos": 1000.0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.Version != alice.Version+1 || patched.Amount != 100 {
+		t.Errorf("expected the version bumped and the amount kept, got %+v", patched)
+	}
+	stored, _ := service.GetBalance(db, alice.ID)
+	if !reflect.DeepEqual(stored.Metadata, patched.Metadata) || stored.Version != patched.Version {
+		t.Errorf("expected %v at version %d stored, got %v at %d", patched.Metadata, patched.Version, stored.Metadata, stored.Version)
+	}
+
+	// null removes a key and objects merge
+	patched, err = service.PatchMetadata(db, stored, map[string]any{"tier": nil, "limits": map[string]any{"atm": 200.0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.Metadata{"limits": map[string]any{"atm": 200.0, "pos": 1000.0}}
+	if !reflect.DeepEqual(patched.Metadata, want) {
+		t.Errorf("expected %v, got %v", want, patched.Metadata)
+	}
+
+	// An update of the amount makes a patch against the older version stale
+	base := patched
+	if _, err := service.UpdateBalance(db, alice.ID, 5); err != nil {
+		t.Fatal(err)
+	}
+	_, err = service.PatchMetadata(db, base, map[string]any{"note": "hi"}, service.WithBaseBackoff(time.Millisecond))
+	var conflict *service.MetadataConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, service.ErrConflict) || len(conflict.Keys) != 0 {
+		t.Fatalf("expected a metadata conflict without keys, got %v", err)
+	}
+
+	// With merging, a patch of other keys applies to the current metadata
+	merge := service.NewUpdater(db, service.WithMetadataMerge(), service.WithBaseBackoff(time.Millisecond))
+	if _, err := merge.PatchMetadata(base, map[string]any{"limits": map[string]any{"pos": 50.0}}); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := merge.PatchMetadata(base, map[string]any{"note": "hi", "limits": map[string]any{"web": 10.0}})
+	if err != nil {
+		t.Fatalf("expected non-overlapping patches merged, got %v", err)
+	}
+	want = models.Metadata{"note": "hi", "limits": map[string]any{"atm": 200.0, "pos": 50.0, "web": 10.0}}
+	if !reflect.DeepEqual(merged.Metadata, want) || merged.Amount != 105 {
+		t.Errorf("expected %v with amount 105, got %+v", want, merged)
+	}
+
+	// but not one that patches a key changed since
+	_, err = merge.PatchMetadata(base, map[string]any{"limits": map[string]any{"pos": 75.0, "atm": 1.0}})
+	if !errors.As(err, &conflict) || !reflect.DeepEqual(conflict.Keys, []string{"limits.pos"}) {
+		t.Errorf("expected a conflict on limits.pos, got %v", err)
+	}
+	if _, err := service.PatchMetadata(db, models.Balance{ID: 9999}, map[string]any{"a": 1}); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// Over HTTP the patch needs the current version in If-Match
+	server := httptest.NewServer((&httpapi.Server{DB: db, Updater: merge}).Handler())
+	defer server.Close()
+	resp, err := http.Get(fmt.Sprintf("%s/balances/%d/metadata", server.URL, alice.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := resp.Header.Get("ETag")
+	resp.Body.Close()
+	for _, tc := range []struct {
+		ifMatch string
+		status  int
+	}{{`"v=1"`, http.StatusPreconditionFailed}, {etag, http.StatusOK}} {
+		req, _ := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/balances/%d/metadata", server.URL, alice.ID), strings.NewReader(`{"note": null}`))
+		req.Header.Set("If-Match", tc.ifMatch)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Version  int             `json:"version"`
+			Metadata models.Metadata `json:"metadata"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("expected %d for If-Match %s, got %d", tc.status, tc.ifMatch, resp.StatusCode)
+		}
+		if tc.status == http.StatusOK && (body.Metadata["note"] != nil || resp.Header.Get("ETag") != fmt.Sprintf(`"v=%d"`, body.Version)) {
+			t.Errorf("expected note removed and the new ETag, got %+v and %s", body, resp.Header.Get("ETag"))
+		}
+	}
+}
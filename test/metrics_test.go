@@ -0,0 +1,129 @@
+package service_test
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ghozilaaa/optimistic-lock/metrics"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestStatsdLineProtocol(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	s, err := metrics.NewStatsd(conn.LocalAddr().String(), "wallet")
+	if err != nil {
+		t.Fatalf("NewStatsd failed: %v", err)
+	}
+	defer s.Close()
+
+	s.Count(metrics.UpdatesTotal, 1, metrics.Labels{"result": "ok", "env": "test"})
+	s.Observe(metrics.UpdateSeconds, 0.25, nil)
+
+	want := []string{
+		"wallet.optlock_updates_total:1|c|#env:test,result:ok",
+		"wallet.optlock_update_duration_seconds:250|ms",
+	}
+	buf := make([]byte, 512)
+	for _, w := range want {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("ReadFrom failed: %v", err)
+		}
+		if got := string(buf[:n]); got != w {
+			t.Errorf("expected %q, got %q", w, got)
+		}
+	}
+}
+
+func TestPrometheusAdapter(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := metrics.NewPrometheus(reg, nil)
+	p.Count(metrics.UpdatesTotal, 2, metrics.Labels{"result": "ok"})
+	p.Observe(metrics.UpdateSeconds, 0.01, metrics.Labels{"result": "ok"})
+
+	// A second adapter on the same registry shares the collectors
+	metrics.NewPrometheus(reg, nil).Count(metrics.UpdatesTotal, 1, metrics.Labels{"result": "ok"})
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	found := map[string]bool{}
+	for _, f := range families {
+		found[f.GetName()] = true
+		if f.GetName() == metrics.UpdatesTotal {
+			if v := f.GetMetric()[0].GetCounter().GetValue(); v != 3 {
+				t.Errorf("expected counter 3, got %v", v)
+			}
+		}
+	}
+	for _, name := range []string{metrics.UpdatesTotal, metrics.UpdateSeconds} {
+		if !found[name] {
+			t.Errorf("expected %s to be gathered, got %s", name, strings.Join(keys(found), ","))
+		}
+	}
+}
+
+func keys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+// countingMetrics sums counters and keeps the last gauges by name
+type countingMetrics struct {
+	mu     sync.Mutex
+	counts map[string]int64
+	gauges map[string]float64
+}
+
+func (m *countingMetrics) Count(name string, delta int64, labels metrics.Labels) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[name+labels["result"]] += delta
+}
+
+func (m *countingMetrics) Observe(string, float64, metrics.Labels) {}
+
+func (m *countingMetrics) Gauge(name string, value float64, _ metrics.Labels) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.gauges == nil {
+		m.gauges = make(map[string]float64)
+	}
+	m.gauges[name] = value
+}
+
+func TestUpdaterMetrics(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	m := &countingMetrics{counts: make(map[string]int64)}
+	updater := service.NewUpdater(db, service.WithMetrics(m))
+	updater.UpdateBalance(balance.ID, 10)
+	updater.UpdateBalance(balance.ID+1, 10) // missing balance
+
+	if m.counts[metrics.UpdatesTotal+"ok"] != 1 || m.counts[metrics.UpdatesTotal+"error"] != 1 {
+		t.Errorf("unexpected update counts: %v", m.counts)
+	}
+	if m.counts[metrics.AttemptsTotal] != 2 {
+		t.Errorf("expected 2 attempts, got %d", m.counts[metrics.AttemptsTotal])
+	}
+}
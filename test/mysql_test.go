@@ -0,0 +1,112 @@
+//go:build mysql
+
+package service_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	tcmysql "github.com/testcontainers/testcontainers-go/modules/mysql"
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/database"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+var (
+	mysqlOnce   sync.Once
+	mysqlServer testServer
+)
+
+// openMySQL returns a private database on the MySQL service from
+// docker-compose (or MYSQL_* overrides), or else on a mysql:8.0 container
+// started once for the run, like postgres does for Postgres
+func openMySQL(t *testing.T) *gorm.DB {
+	t.Helper()
+	mysqlOnce.Do(func() {
+		mysqlServer = testServer{
+			Host:     envOr("MYSQL_HOST", "localhost"),
+			Port:     envOr("MYSQL_PORT", "3306"),
+			User:     envOr("MYSQL_USER", "root"),
+			Password: envOr("MYSQL_PASSWORD", "mysql"),
+		}
+		if !reachable(mysqlServer) {
+			mysqlServer = startMySQL(mysqlServer)
+		}
+	})
+	requireServer(t, "MySQL", mysqlServer)
+
+	cfg := database.Config{
+		Driver:   "mysql",
+		Host:     mysqlServer.Host,
+		Port:     mysqlServer.Port,
+		User:     mysqlServer.User,
+		Password: mysqlServer.Password,
+		Name:     envOr("MYSQL_DATABASE", "optimistic_lock"),
+	}
+	return schemaDB(t, cfg)
+}
+
+// startMySQL runs a MySQL container with the password of s for root
+func startMySQL(s testServer) (started testServer) {
+	defer noDocker(&started)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+	c, err := tcmysql.Run(ctx, "mysql:8.0",
+		tcmysql.WithUsername("root"),
+		tcmysql.WithPassword(s.Password),
+		tcmysql.WithDatabase(envOr("MYSQL_DATABASE", "optimistic_lock")))
+	if c != nil {
+		track(c)
+	}
+	if err != nil {
+		return testServer{err: err}
+	}
+	s.User = "root"
+	return containerServer(ctx, c, "3306/tcp", s)
+}
+
+func TestMySQLConcurrentBalanceUpdates(t *testing.T) {
+	t.Parallel()
+	db := openMySQL(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := service.UpdateBalance(db, balance.ID, 10); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	conflictCount := 0
+	for err := range errs {
+		if errors.Is(err, service.ErrConflict) {
+			conflictCount++
+		} else {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	var updated models.Balance
+	db.First(&updated, balance.ID)
+	t.Logf("Final balance: %d, conflicts: %d", updated.Amount, conflictCount)
+
+	expected := int64(1000 + ((100 - conflictCount) * 10))
+	if updated.Amount != expected {
+		t.Errorf("Balance integrity failed: expected %d, got %d", expected, updated.Amount)
+	}
+}
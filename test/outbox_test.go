@@ -0,0 +1,65 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ghozilaaa/optimistic-lock/events"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/outbox"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestOutboxRelay(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	if err := outbox.Migrate(db); err != nil {
+		t.Fatalf("Failed to migrate outbox: %v", err)
+	}
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	updater := service.NewUpdater(db, service.WithOutbox())
+	for i := 0; i < 3; i++ {
+		if _, err := updater.UpdateBalance(balance.ID, 10); err != nil {
+			t.Fatalf("UpdateBalance failed: %v", err)
+		}
+	}
+
+	// The second message fails once; the first stays published
+	var published []events.BalanceChanged
+	failOnce := true
+	publisher := events.PublisherFunc(func(ctx context.Context, e events.BalanceChanged) error {
+		if len(published) == 1 && failOnce {
+			failOnce = false
+			return errors.New("broker unavailable")
+		}
+		published = append(published, e)
+		return nil
+	})
+
+	relay := outbox.NewRelay(db, "kafka", publisher, 0)
+	if n, err := relay.RelayOnce(context.Background()); err == nil || n != 1 {
+		t.Fatalf("expected one message and an error, got %d, %v", n, err)
+	}
+
+	// A new relay with the same name resumes after the recorded offset
+	relay = outbox.NewRelay(db, "kafka", publisher, 0)
+	if n, err := relay.RelayOnce(context.Background()); err != nil || n != 2 {
+		t.Fatalf("expected two messages, got %d, %v", n, err)
+	}
+	if len(published) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(published))
+	}
+	for i, e := range published {
+		if e.BalanceID != balance.ID || e.Version != balance.Version+i+1 || e.NewAmount != 1000+int64(i+1)*10 {
+			t.Errorf("unexpected event %d: %+v", i, e)
+		}
+	}
+
+	if n, err := relay.Prune(); err != nil || n != 3 {
+		t.Errorf("expected 3 pruned messages, got %d, %v", n, err)
+	}
+}
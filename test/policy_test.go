@@ -0,0 +1,92 @@
+package service_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestPolicies(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	if err := db.AutoMigrate(&models.BalanceLimit{}, &models.PreparedUpdate{}); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	overdrawn := models.Balance{Amount: 100, Limits: []models.BalanceLimit{{Kind: models.LimitOverdraft, Amount: 50}}}
+	floored := models.Balance{Amount: 200, Limits: []models.BalanceLimit{
+		{Kind: models.LimitMinAmount, Amount: 120},
+		{Kind: models.LimitReservationOver, Amount: 30},
+	}}
+	plain := models.Balance{Amount: 100}
+	db.Create(&overdrawn)
+	db.Create(&floored)
+	db.Create(&plain)
+	updater := service.NewUpdater(db, service.WithPolicies(service.MinimumBalance(), service.Overdraft(), service.RequireReservation()))
+
+	// The overdraft lets the balance go below zero, down to -50
+	outcome, err := updater.UpdateBalance(overdrawn.ID, -140)
+	if err != nil || outcome.NewAmount != -40 || len(outcome.Policies) != 1 || !outcome.Policies[0].Allowed {
+		t.Errorf("expected the overdraft to allow -40, got %+v and %v", outcome, err)
+	}
+	outcome, err = updater.UpdateBalance(overdrawn.ID, -20)
+	var denied *service.PolicyError
+	if !errors.As(err, &denied) || !errors.Is(err, service.ErrInsufficientFunds) || denied.Decision.Policy != service.PolicyOverdraft {
+		t.Errorf("expected the overdraft policy to refuse -60, got %v", err)
+	}
+	if outcome.NewAmount != 0 || len(outcome.Policies) != 1 || outcome.Policies[0].Allowed {
+		t.Errorf("expected the refusal in the outcome, got %+v", outcome)
+	}
+	if current, _ := service.GetBalance(db, overdrawn.ID); current.Amount != -40 {
+		t.Errorf("expected the refused debit rolled back, got %d", current.Amount)
+	}
+
+	// A small debit passes both policies of the floored balance
+	outcome, err = updater.UpdateBalance(floored.ID, -30)
+	if err != nil || len(outcome.Policies) != 2 {
+		t.Errorf("expected two allowing decisions, got %+v and %v", outcome, err)
+	}
+
+	// Debits over 30 need a reservation
+	if _, err := updater.UpdateBalance(floored.ID, -40); !errors.Is(err, service.ErrPolicyDenied) || errors.Is(err, service.ErrInsufficientFunds) {
+		t.Errorf("expected a reservation to be required, got %v", err)
+	}
+	prepared, err := updater.Prepare(floored.ID, -40, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome, err := updater.CommitPrepared(prepared.ID); err != nil || outcome.NewAmount != 130 {
+		t.Errorf("expected the prepared debit to apply, got %+v and %v", outcome, err)
+	}
+
+	// The minimum holds
+	if _, err := updater.UpdateBalance(floored.ID, -20); !errors.Is(err, service.ErrInsufficientFunds) {
+		t.Errorf("expected the minimum balance to refuse a debit to 110, got %v", err)
+	}
+
+	// Balances without policy limits are not checked
+	if outcome, err := updater.UpdateBalance(plain.ID, -500); err != nil || len(outcome.Policies) != 0 {
+		t.Errorf("expected no policy to apply, got %+v and %v", outcome, err)
+	}
+
+	// The HTTP API refuses with 422
+	server := httptest.NewServer((&httpapi.Server{DB: db, Updater: updater}).Handler())
+	defer server.Close()
+	current, _ := service.GetBalance(db, overdrawn.ID)
+	req, _ := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/balances/%d", server.URL, overdrawn.ID), strings.NewReader(`{"delta": -100}`))
+	req.Header.Set("If-Match", fmt.Sprintf(`"v=%d"`, current.Version))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d", resp.StatusCode)
+	}
+}
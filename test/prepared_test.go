@@ -0,0 +1,103 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	client "github.com/ghozilaaa/optimistic-lock/clients/go"
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestPreparedUpdates(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	if err := db.AutoMigrate(&models.PreparedUpdate{}); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+	updater := service.NewUpdater(db)
+
+	// Prepare, then commit: applied once
+	prepared, err := updater.Prepare(balance.ID, -300, time.Minute)
+	if err != nil || prepared.ExpectedVersion != 0 || prepared.Status != models.PreparedPending {
+		t.Fatalf("expected a pending update at version 0, got %+v and %v", prepared, err)
+	}
+	outcome, err := updater.CommitPrepared(prepared.ID)
+	if err != nil || outcome.NewAmount != 700 || outcome.Version != 1 {
+		t.Fatalf("expected 700 at version 1, got %+v and %v", outcome, err)
+	}
+	if _, err := updater.CommitPrepared(prepared.ID); !errors.Is(err, service.ErrPreparedResolved) {
+		t.Errorf("expected a second commit rejected, got %v", err)
+	}
+	if err := updater.AbortPrepared(prepared.ID); !errors.Is(err, service.ErrPreparedResolved) {
+		t.Errorf("expected aborting a committed update rejected, got %v", err)
+	}
+
+	// A write in between makes the commit fail and aborts the prepared update
+	stale, _ := updater.Prepare(balance.ID, -100, time.Minute)
+	updater.UpdateBalance(balance.ID, 50)
+	if _, err := updater.CommitPrepared(stale.ID); !errors.Is(err, service.ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+	if got, _ := service.GetPrepared(db, stale.ID); got.Status != models.PreparedAborted || got.ResolvedAt == nil {
+		t.Errorf("expected the stale update aborted, got %+v", got)
+	}
+	if _, err := updater.PrepareIfVersion(balance.ID, 1, -100, time.Minute); !errors.Is(err, service.ErrConflict) {
+		t.Errorf("expected preparing at an old version rejected, got %v", err)
+	}
+
+	// Past the TTL it can only be aborted
+	expired, _ := updater.Prepare(balance.ID, -100, time.Minute)
+	db.Model(&models.PreparedUpdate{}).Where("id = ?", expired.ID).Update("expires_at", time.Now().Add(-time.Second))
+	if got, _ := service.GetPrepared(db, expired.ID); got.Status != models.PreparedExpired {
+		t.Errorf("expected the update reported expired, got %q", got.Status)
+	}
+	if _, err := updater.CommitPrepared(expired.ID); !errors.Is(err, service.ErrPreparedExpired) {
+		t.Errorf("expected ErrPreparedExpired, got %v", err)
+	}
+	if err := updater.AbortPrepared(expired.ID); err != nil {
+		t.Errorf("expected aborting an expired update to succeed, got %v", err)
+	}
+	if _, err := updater.CommitPrepared("unknown"); !errors.Is(err, service.ErrPreparedNotFound) {
+		t.Errorf("expected ErrPreparedNotFound, got %v", err)
+	}
+	current, _ := service.GetBalance(db, balance.ID)
+	if current.Amount != 750 || current.Version != 2 {
+		t.Errorf("expected 750 at version 2, got %d at %d", current.Amount, current.Version)
+	}
+
+	// Over HTTP: prepare at the If-Match version, then commit or abort
+	server := httptest.NewServer((&httpapi.Server{DB: db}).Handler())
+	defer server.Close()
+	c := client.New(server.URL)
+	ctx := context.Background()
+	if _, err := c.PrepareBalance(ctx, int64(balance.ID), `"v=1"`, client.PrepareRequest{Delta: 10}); err == nil {
+		t.Error("expected a stale If-Match rejected")
+	}
+	p, err := c.PrepareBalance(ctx, int64(balance.ID), `"v=2"`, client.PrepareRequest{Delta: 10, ExpiresIn: "30s"})
+	if err != nil || p.ExpectedVersion != 2 || p.Status != "prepared" {
+		t.Fatalf("expected a prepared update at version 2, got %+v and %v", p, err)
+	}
+	committed, err := c.CommitPrepared(ctx, p.Token)
+	if err != nil || committed.Amount != 760 || committed.Version != 3 {
+		t.Errorf("expected 760 at version 3, got %+v and %v", committed, err)
+	}
+	var apiErr *client.Error
+	if _, err := c.CommitPrepared(ctx, p.Token); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("expected a repeated commit to get 412, got %v", err)
+	}
+	p, _ = c.PrepareBalance(ctx, int64(balance.ID), "", client.PrepareRequest{Delta: 10})
+	if aborted, err := c.AbortPrepared(ctx, p.Token); err != nil || aborted.Status != "aborted" {
+		t.Errorf("expected the update aborted, got %+v and %v", aborted, err)
+	}
+	if _, err := c.GetPrepared(ctx, "unknown"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown token, got %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package service_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/recon"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestParseStatements(t *testing.T) {
+	entries, err := recon.ParseCSV(strings.NewReader("date,reference,amount\n2024-03-01,INV-1,12.50\n2024-03-02,INV-2,-3\n"))
+	if err != nil {
+		t.Fatalf("ParseCSV failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Amount != 1250 || entries[1].Amount != -300 {
+		t.Errorf("unexpected CSV entries: %+v", entries)
+	}
+
+	mt940 := ":20:STMT\n:25:NL00BANK0123456789\n" +
+		":61:2403010301C12,50NTRFINV-1//BANK1\n:86:payment\n" +
+		":61:240302D3,NCHGINV-2\n"
+	entries, err = recon.ParseMT940(strings.NewReader(mt940))
+	if err != nil {
+		t.Fatalf("ParseMT940 failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 MT940 entries, got %d", len(entries))
+	}
+	if entries[0].Reference != "INV-1" || entries[0].Amount != 1250 || entries[0].Date.Format("2006-01-02") != "2024-03-01" {
+		t.Errorf("unexpected first MT940 entry: %+v", entries[0])
+	}
+	if entries[1].Reference != "INV-2" || entries[1].Amount != -300 {
+		t.Errorf("unexpected second MT940 entry: %+v", entries[1])
+	}
+}
+
+func TestReconcileStatement(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	day := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	db.Create(&models.Adjustment{Reference: "INV-1", BalanceID: balance.ID, Delta: 1250, CreatedAt: day})
+	db.Create(&models.Adjustment{Reference: "INV-2", BalanceID: balance.ID, Delta: -300, CreatedAt: day})
+	db.Create(&models.Adjustment{Reference: "INV-3", BalanceID: balance.ID, Delta: 99, CreatedAt: day})
+
+	midnight := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	entries := []recon.Entry{
+		{Date: midnight, Reference: "INV-1", Amount: 1250},       // matched
+		{Date: midnight, Reference: "INV-2", Amount: -350},       // amount mismatch
+		{Date: midnight, Reference: "BANKREF-77", Amount: 99},    // matched by amount and date
+		{Date: midnight, Reference: "INTEREST-MAR", Amount: 500}, // missing internally
+	}
+
+	result, err := recon.Reconcile(db, balance.ID, entries, recon.Options{})
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	kinds := map[recon.Kind]int{}
+	for _, f := range result.Findings {
+		kinds[f.Kind]++
+	}
+	if kinds[recon.Matched] != 2 || kinds[recon.AmountMismatch] != 1 || kinds[recon.MissingInternal] != 1 {
+		t.Errorf("unexpected findings: %v", kinds)
+	}
+
+	var out strings.Builder
+	count, err := result.WriteAdjustments(&out)
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 proposed adjustments, got %d (%v)", count, err)
+	}
+	if !strings.Contains(out.String(), ",-50,recon:INV-2:diff") || !strings.Contains(out.String(), ",500,recon:INTEREST-MAR") {
+		t.Errorf("unexpected adjustments file:\n%s", out.String())
+	}
+}
+
+func TestAuditVersions(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	if err := db.AutoMigrate(&models.BalanceOverride{}); err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(amount int64) uint {
+		balance := models.Balance{Amount: amount}
+		db.Create(&balance)
+		return balance.ID
+	}
+	adjust := func(id uint, ref string, delta int64) {
+		if _, err := service.ApplyAdjustment(db, ref, id, delta); err != nil {
+			t.Fatalf("ApplyAdjustment failed: %v", err)
+		}
+	}
+
+	clean := create(0)
+	adjust(clean, "clean-1", 10)
+
+	opened := create(100) // opening amount set outside the ledger
+
+	reset := create(0)
+	adjust(reset, "reset-1", 10)
+	adjust(reset, "reset-2", 10)
+	db.Exec("UPDATE balances SET version = 0 WHERE id = ?", reset)
+
+	bypassed := create(0)
+	adjust(bypassed, "bypassed-1", 10)
+	service.UpdateBalance(db, bypassed, 5) // no adjustment recorded
+
+	// A metadata patch leaves no history in the row mode: reported, not fixed
+	patched := create(0)
+	adjust(patched, "patched-1", 10)
+	current, _ := service.GetBalance(db, patched)
+	if _, err := service.PatchMetadata(db, current, map[string]any{"tier": "gold"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The history starts again at a force set
+	forced := create(0)
+	adjust(forced, "forced-1", 10)
+	if _, err := service.ForceSet(db, forced, 50, "support fix"); err != nil {
+		t.Fatal(err)
+	}
+	adjust(forced, "forced-2", 5)
+	// Adjustments are ordered after the override by ID, not by a clock that
+	// may be behind the one that stamped the override
+	db.Exec("UPDATE adjustments SET created_at = ? WHERE reference = ?", time.Now().Add(-time.Hour), "forced-2")
+
+	audit, err := recon.AuditVersions(db, recon.VersionAuditOptions{OpenAtZero: true, BatchSize: 2})
+	if err != nil {
+		t.Fatalf("AuditVersions failed: %v", err)
+	}
+	if audit.Scanned != 6 {
+		t.Errorf("expected 6 balances scanned, got %d", audit.Scanned)
+	}
+
+	kinds := map[uint][]recon.VersionKind{}
+	for _, f := range audit.Findings {
+		kinds[f.BalanceID] = append(kinds[f.BalanceID], f.Kind)
+	}
+	want := map[uint][]recon.VersionKind{
+		opened:   {recon.AmountDrift},
+		reset:    {recon.VersionBehind},
+		bypassed: {recon.AmountDrift, recon.Unledgered},
+		patched:  {recon.Unledgered},
+	}
+	if fmt.Sprint(kinds) != fmt.Sprint(want) {
+		t.Errorf("expected findings %v, got %v", want, kinds)
+	}
+
+	wantFixes := []recon.VersionFix{
+		{BalanceID: opened, Version: 0, Amount: 100, ToVersion: 1, Reason: "version audit: amount_drift; amount 100 is +100 from the ledger"},
+		{BalanceID: reset, Version: 0, Amount: 20, ToVersion: 3, Reason: "version audit: version_behind"},
+		{BalanceID: bypassed, Version: 2, Amount: 15, ToVersion: 3, Reason: "version audit: amount_drift, unledgered; amount 15 is +5 from the ledger"},
+	}
+	if fmt.Sprint(audit.Fixes) != fmt.Sprint(wantFixes) {
+		t.Fatalf("expected fixes %+v, got %+v", wantFixes, audit.Fixes)
+	}
+
+	for _, fix := range audit.Fixes {
+		if err := recon.ApplyVersionFix(db, fix); err != nil {
+			t.Fatalf("ApplyVersionFix failed: %v", err)
+		}
+	}
+	// A plan is only valid for the versions it was made from
+	if err := recon.ApplyVersionFix(db, audit.Fixes[0]); !errors.Is(err, service.ErrConflict) {
+		t.Errorf("expected a stale fix to conflict, got %v", err)
+	}
+
+	again, err := recon.AuditVersions(db, recon.VersionAuditOptions{OpenAtZero: true})
+	if err != nil || len(again.Findings) != 1 || again.Findings[0].BalanceID != patched {
+		t.Errorf("expected only the patched balance left for review, got %+v %v", again.Findings, err)
+	}
+	if final, _ := service.GetBalance(db, bypassed); final.Amount != 15 || final.Version != 3 {
+		t.Errorf("expected the repair to keep amount 15 at version 3, got %d at %d", final.Amount, final.Version)
+	}
+	if final, _ := service.GetBalance(db, reset); final.Version != 3 {
+		t.Errorf("expected the reset version raised past 2, got %d", final.Version)
+	}
+	overrides, _ := service.ListOverrides(db, reset)
+	if len(overrides) != 1 || overrides[0].Actor != "version-audit" || overrides[0].Amount != overrides[0].PreviousAmount {
+		t.Errorf("expected the repair recorded as an override keeping the amount, got %+v", overrides)
+	}
+}
+
+func TestAuditVersionsEvents(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	updater := service.NewUpdater(db, service.WithEventSourcing())
+
+	balance := models.Balance{Amount: 0}
+	db.Create(&balance)
+	if _, err := updater.UpdateBalance(balance.ID, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := updater.Freeze(balance.ID); err != nil {
+		t.Fatal(err)
+	}
+	frozen, err := updater.Unfreeze(balance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := updater.PatchMetadata(frozen, map[string]any{"tier": "gold"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every write is an event, so the chain has no gaps
+	if result, err := service.VerifySnapshot(db, balance.ID); err != nil || !result.Consistent() || result.Events != 4 {
+		t.Errorf("expected 4 consistent events, got %+v and %v", result, err)
+	}
+	audit, err := recon.AuditVersions(db, recon.VersionAuditOptions{Events: true})
+	if err != nil || len(audit.Findings) != 0 {
+		t.Errorf("expected no findings counting events, got %+v and %v", audit.Findings, err)
+	}
+	audit, _ = recon.AuditVersions(db, recon.VersionAuditOptions{})
+	if len(audit.Findings) != 1 || audit.Findings[0].Kind != recon.Unledgered {
+		t.Errorf("expected the writes without adjustments unledgered, got %+v", audit.Findings)
+	}
+}
@@ -0,0 +1,120 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/region"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestRegionFollowsFailoverEpoch(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	west := region.NewController(db, "west", true)
+	east := region.NewController(db, "east", false)
+	updater := service.NewUpdater(db, service.WithWriteCheck(east.CheckWrite))
+
+	if _, err := updater.UpdateBalance(balance.ID, 10); !errors.Is(err, region.ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly in the passive region, got %v", err)
+	}
+
+	// optlockctl promote in east records the new epoch
+	db.Save(&models.FailoverEpoch{ID: 1, Epoch: 1, Region: "east", PromotedAt: time.Now()})
+
+	for _, c := range []*region.Controller{west, east} {
+		if err := c.Refresh(); err != nil {
+			t.Fatalf("Refresh failed: %v", err)
+		}
+	}
+	if west.Active() || !east.Active() {
+		t.Fatalf("expected east active and west passive, got east=%v west=%v", east.Active(), west.Active())
+	}
+	if _, err := updater.UpdateBalance(balance.ID, 10); err != nil {
+		t.Errorf("expected the promoted region to write, got %v", err)
+	}
+}
+
+func TestReadConsistency(t *testing.T) {
+	t.Parallel()
+	primary, replica := openDB(t), openDB(t)
+
+	// The replica has not caught up with the latest write
+	primary.Create(&models.Balance{Amount: 200})
+	replica.Create(&models.Balance{Amount: 100})
+
+	lagOf := func(lag time.Duration) service.LagFunc {
+		return func(context.Context, *gorm.DB) (time.Duration, error) { return lag, nil }
+	}
+	read := func(reads *service.ReadRouter, query string) (int, string, int64) {
+		server := httptest.NewServer((&httpapi.Server{DB: primary, Reads: reads}).Handler())
+		defer server.Close()
+		resp, err := http.Get(server.URL + "/balances/1?" + query)
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var balance models.Balance
+		json.NewDecoder(resp.Body).Decode(&balance)
+		return resp.StatusCode, resp.Header.Get("X-Read-Source"), balance.Amount
+	}
+
+	fresh := service.NewReadRouter(primary, []*gorm.DB{replica}, time.Second, lagOf(10*time.Millisecond))
+	behind := service.NewReadRouter(primary, []*gorm.DB{replica}, time.Second, lagOf(time.Minute))
+
+	tests := []struct {
+		name        string
+		reads       *service.ReadRouter
+		consistency string
+		source      string
+		amount      int64
+	}{
+		{"default", fresh, "", "primary", 200},
+		{"strong", fresh, "strong", "primary", 200},
+		{"bounded within lag", fresh, "bounded", "replica", 100},
+		{"bounded past lag", behind, "bounded", "primary", 200},
+		{"eventual", behind, "eventual", "replica", 100},
+		{"no router", nil, "eventual", "primary", 200},
+	}
+	for _, tt := range tests {
+		status, source, amount := read(tt.reads, "consistency="+tt.consistency)
+		if status != http.StatusOK || source != tt.source || amount != tt.amount {
+			t.Errorf("%s: expected 200 from %s with %d, got %d from %s with %d",
+				tt.name, tt.source, tt.amount, status, source, amount)
+		}
+	}
+
+	if status, _, _ := read(fresh, "consistency=linearizable"); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown consistency, got %d", status)
+	}
+
+	// Read-your-writes: a replica that has not replayed the version a write
+	// returned is passed over for the primary
+	primary.Model(&models.Balance{}).Where("id = ?", 1).Updates(map[string]any{"amount": 250, "version": 1})
+	if status, source, amount := read(behind, "min_version=1"); status != http.StatusOK || source != "primary" || amount != 250 {
+		t.Errorf("expected the primary to serve an unreplayed version, got %d from %s with %d", status, source, amount)
+	}
+	replica.Model(&models.Balance{}).Where("id = ?", 1).Updates(map[string]any{"amount": 250, "version": 1})
+	if status, source, _ := read(behind, "min_version=1"); status != http.StatusOK || source != "replica" {
+		t.Errorf("expected a caught-up replica to serve min_version reads, got %d from %s", status, source)
+	}
+	if status, source, _ := read(behind, "min_version=1&consistency=strong"); status != http.StatusOK || source != "primary" {
+		t.Errorf("expected consistency=strong to keep the read on the primary, got %d from %s", status, source)
+	}
+	if status, _, _ := read(fresh, "min_version=-1"); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid min_version, got %d", status)
+	}
+}
@@ -0,0 +1,74 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestRolloutRollsBackOnErrorRegression(t *testing.T) {
+	control := func(id uint, delta int64) (service.UpdateOutcome, error) {
+		return service.UpdateOutcome{Attempts: 1}, nil
+	}
+	candidate := func(id uint, delta int64) (service.UpdateOutcome, error) {
+		return service.UpdateOutcome{Attempts: 1}, errors.New("db error")
+	}
+
+	rolledBack := false
+	rollout := service.NewRollout(control, candidate, service.RolloutConfig{
+		Percent:    50,
+		MinSamples: 20,
+		OnRollback: func(service.RolloutStats) { rolledBack = true },
+	})
+
+	for id := uint(1); id <= 500; id++ {
+		rollout.UpdateBalance(id, 1)
+	}
+
+	stats := rollout.Stats()
+	if !rolledBack || !stats.RolledBack || stats.Percent != 0 {
+		t.Fatalf("expected rollout to roll back, got %+v", stats)
+	}
+
+	// After rollback every ID goes to control
+	before := stats.Candidate.Requests
+	for id := uint(1); id <= 100; id++ {
+		if _, err := rollout.UpdateBalance(id, 1); err != nil {
+			t.Fatalf("expected control path after rollback, got %v", err)
+		}
+	}
+	if rollout.Stats().Candidate.Requests != before {
+		t.Error("expected no candidate traffic after rollback")
+	}
+}
+
+func TestRolloutRoutingIsStickyPerBalance(t *testing.T) {
+	seen := map[uint]string{}
+	var current string
+	control := func(id uint, delta int64) (service.UpdateOutcome, error) {
+		current = "control"
+		return service.UpdateOutcome{}, nil
+	}
+	candidate := func(id uint, delta int64) (service.UpdateOutcome, error) {
+		current = "candidate"
+		return service.UpdateOutcome{}, nil
+	}
+
+	rollout := service.NewRollout(control, candidate, service.RolloutConfig{Percent: 30})
+	for round := 0; round < 3; round++ {
+		for id := uint(1); id <= 200; id++ {
+			rollout.UpdateBalance(id, 1)
+			if prev, ok := seen[id]; ok && prev != current {
+				t.Fatalf("balance %d switched from %s to %s", id, prev, current)
+			}
+			seen[id] = current
+		}
+	}
+
+	stats := rollout.Stats()
+	share := float64(stats.Candidate.Requests) / float64(stats.Candidate.Requests+stats.Control.Requests)
+	if share < 0.15 || share > 0.45 {
+		t.Errorf("expected roughly 30%% candidate share, got %.2f", share)
+	}
+}
@@ -0,0 +1,80 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestSagaCompensation(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	from, fees, to := models.Balance{Amount: 100}, models.Balance{Amount: 0}, models.Balance{Amount: 0}
+	db.Create(&from)
+	db.Create(&fees)
+	db.Create(&to)
+	amounts := func() [3]int64 {
+		var got [3]int64
+		for i, id := range []uint{from.ID, fees.ID, to.ID} {
+			b, _ := service.GetBalance(db, id)
+			got[i] = b.Amount
+		}
+		return got
+	}
+	transfer := func(dest uint) []service.SagaStep {
+		return []service.SagaStep{
+			{Name: "debit", BalanceID: from.ID, Delta: -62},
+			{Name: "fee", BalanceID: fees.ID, Delta: 2},
+			{Name: "credit", BalanceID: dest, Delta: 60},
+		}
+	}
+
+	// The credit fails, so the debit and the fee are undone
+	err := service.RunSaga(db, "t1", transfer(999))
+	var sagaErr *service.SagaError
+	if !errors.As(err, &sagaErr) || sagaErr.Step != 2 || sagaErr.Name != "credit" || sagaErr.CompensationErr != nil ||
+		!errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected the credit step to fail and be compensated, got %v", err)
+	}
+	if got := amounts(); got != [3]int64{100, 0, 0} {
+		t.Errorf("expected every balance restored, got %v", got)
+	}
+	if err := service.RunSaga(db, "t1", transfer(to.ID)); !errors.Is(err, service.ErrSagaCompensated) {
+		t.Errorf("expected a compensated saga not to run again, got %v", err)
+	}
+
+	// A successful saga runs once, however often it is retried
+	for i := 0; i < 2; i++ {
+		if err := service.RunSaga(db, "t2", transfer(to.ID)); err != nil {
+			t.Fatalf("saga failed: %v", err)
+		}
+	}
+	if got := amounts(); got != [3]int64{38, 2, 60} {
+		t.Errorf("expected the transfer applied once, got %v", got)
+	}
+
+	// Cancelling it later undoes every step exactly once
+	updater := service.NewUpdater(db)
+	for i := 0; i < 2; i++ {
+		if err := updater.CompensateSaga("t2"); err != nil {
+			t.Fatalf("compensation failed: %v", err)
+		}
+	}
+	if got := amounts(); got != [3]int64{100, 0, 0} {
+		t.Errorf("expected the transfer undone, got %v", got)
+	}
+	var undos int64
+	db.Model(&models.Adjustment{}).Where("reference LIKE ?", "saga:t2:%:undo").Count(&undos)
+	if undos != 3 {
+		t.Errorf("expected 3 compensating adjustments, got %d", undos)
+	}
+
+	if err := service.RunSaga(db, "a:b", nil); err == nil {
+		t.Error("expected a saga ID with ':' to be refused")
+	}
+}
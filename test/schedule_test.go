@@ -0,0 +1,126 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/schedule"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestScheduleSpecs(t *testing.T) {
+	// A Friday
+	from := time.Date(2026, 10, 16, 10, 7, 30, 0, time.UTC)
+	cases := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"0 0 1 * *", from, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", from, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"*/15 9-17 * * 1-5", from, time.Date(2026, 10, 16, 10, 15, 0, 0, time.UTC)},
+		{"*/15 9-17 * * 1-5", from.Add(7*time.Hour + 45*time.Minute), time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", from, time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)},
+		// Day of month or day of week when both are restricted
+		{"0 0 13 * 5", from, time.Date(2026, 10, 23, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", from, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0,30 0 * * *", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 16, 0, 30, 0, 0, time.UTC)},
+		{"@every 90m", from, from.Add(90 * time.Minute)},
+	}
+	for _, c := range cases {
+		spec, err := schedule.ParseSpec(c.spec)
+		if err != nil {
+			t.Errorf("%q: %v", c.spec, err)
+			continue
+		}
+		if got := spec.Next(c.from); !got.Equal(c.want) {
+			t.Errorf("%q after %s: expected %s, got %s", c.spec, c.from, c.want, got)
+		}
+	}
+
+	for _, bad := range []string{"0 0 30 2 *", "@every 30s", "61 * * * *", "* * *", "*/0 * * * *", "5-1 * * * *", "@sometimes"} {
+		if _, err := schedule.ParseSpec(bad); err == nil {
+			t.Errorf("expected %q rejected", bad)
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	if err := db.AutoMigrate(&models.Schedule{}); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	ctx := context.Background()
+
+	fee := models.Balance{Amount: 10000}
+	interest := models.Balance{Amount: 10000}
+	db.Create(&fee)
+	db.Create(&interest)
+
+	if _, err := schedule.Create(db, models.Schedule{Name: "bad", BalanceID: fee.ID, Spec: "0 0 30 2 *"}); err == nil {
+		t.Error("expected a spec that never runs rejected")
+	}
+
+	// Down for two and a half hours: three hourly runs were missed
+	now := time.Now()
+	feeSchedule, err := schedule.Create(db, models.Schedule{
+		Name: "fee", BalanceID: fee.ID, Spec: "@every 1h", Delta: -10, NextRunAt: now.Add(-150 * time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	_, err = schedule.Create(db, models.Schedule{
+		Name: "interest", BalanceID: interest.ID, Spec: "@every 1h", RateBps: 100, NextRunAt: now.Add(-150 * time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Interest catches up two runs per pass, compounding on the amount at each run
+	scheduler := schedule.NewScheduler(db, service.NewUpdater(db), 2)
+	if applied, err := scheduler.RunOnce(ctx); err != nil || applied != 4 {
+		t.Fatalf("expected 4 runs applied, got %d and %v", applied, err)
+	}
+	if applied, err := scheduler.RunOnce(ctx); err != nil || applied != 2 {
+		t.Fatalf("expected the last missed runs applied, got %d and %v", applied, err)
+	}
+	if applied, _ := scheduler.RunOnce(ctx); applied != 0 {
+		t.Errorf("expected nothing due, applied %d", applied)
+	}
+	db.First(&fee, fee.ID)
+	db.First(&interest, interest.ID)
+	if fee.Amount != 9970 || interest.Amount != 10303 {
+		t.Errorf("expected 9970 and 10303, got %d and %d", fee.Amount, interest.Amount)
+	}
+	var stored models.Schedule
+	db.First(&stored, feeSchedule.ID)
+	if !stored.NextRunAt.After(now) || stored.LastRunAt == nil || !stored.LastRunAt.Equal(stored.NextRunAt.Add(-time.Hour)) {
+		t.Errorf("expected the schedule advanced past now, got next %v last %v", stored.NextRunAt, stored.LastRunAt)
+	}
+
+	// A scheduler that crashed before advancing the schedule repeats runs
+	// that were already applied: they are no-ops
+	db.Model(&stored).Update("next_run_at", feeSchedule.NextRunAt)
+	if applied, err := schedule.NewScheduler(db, service.NewUpdater(db), 0).RunOnce(ctx); err != nil || applied != 0 {
+		t.Errorf("expected repeated runs skipped, got %d and %v", applied, err)
+	}
+	db.First(&fee, fee.ID)
+	if fee.Amount != 9970 {
+		t.Errorf("expected repeated runs not applied twice, got %d", fee.Amount)
+	}
+
+	// A schedule of a deleted balance is paused
+	db.Delete(&fee)
+	db.Model(&stored).Update("next_run_at", now.Add(-time.Minute))
+	if _, err := scheduler.RunOnce(ctx); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	db.First(&stored, feeSchedule.ID)
+	if !stored.Paused {
+		t.Error("expected the schedule paused")
+	}
+}
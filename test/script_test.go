@@ -0,0 +1,71 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestRunScript(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	from := models.Balance{Amount: 100}
+	to := models.Balance{Amount: 0}
+	watched := models.Balance{Amount: 7}
+	db.Create(&from)
+	db.Create(&to)
+	db.Create(&watched)
+
+	transfer := func(amount int64) []service.Op {
+		return []service.Op{
+			{Kind: service.OpRead, BalanceID: watched.ID},
+			{Kind: service.OpDebit, BalanceID: from.ID, Amount: amount},
+			{Kind: service.OpAssert, BalanceID: from.ID, Check: service.AtLeast(0)},
+			{Kind: service.OpCredit, BalanceID: to.ID, Amount: amount},
+		}
+	}
+
+	result, err := service.RunScript(context.Background(), db, transfer(60))
+	if err != nil {
+		t.Fatalf("RunScript failed: %v", err)
+	}
+	if result.Balances[from.ID].Amount != 40 || result.Balances[to.ID].Amount != 60 || result.Balances[watched.ID].Amount != 7 {
+		t.Errorf("unexpected result: %+v", result.Balances)
+	}
+	if result.Balances[from.ID].Version != from.Version+1 || result.Balances[watched.ID].Version != watched.Version {
+		t.Errorf("unexpected versions: %+v", result.Balances)
+	}
+
+	// A failed assertion rolls back the whole script
+	if _, err := service.RunScript(context.Background(), db, transfer(60)); !errors.Is(err, service.ErrAssertionFailed) {
+		t.Fatalf("expected ErrAssertionFailed, got %v", err)
+	}
+	var current models.Balance
+	db.First(&current, to.ID)
+	if current.Amount != 60 {
+		t.Errorf("expected the failed script to change nothing, got %d", current.Amount)
+	}
+
+	// A change to a balance the script only read makes it retry
+	bumped := false
+	db.Callback().Query().After("gorm:query").Register("test:bump_watched", func(tx *gorm.DB) {
+		if !bumped && tx.Statement.Table == "balances" {
+			bumped = true
+			tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE balances SET version = version + 1 WHERE id = ?", watched.ID)
+		}
+	})
+	result, err = service.NewUpdater(db, service.WithBaseBackoff(time.Millisecond)).RunScript(context.Background(), transfer(10))
+	if err != nil {
+		t.Fatalf("RunScript failed: %v", err)
+	}
+	if result.Outcome.Conflicts != 1 || result.Balances[from.ID].Amount != 30 {
+		t.Errorf("expected one conflict and a retried transfer, got %+v", result)
+	}
+}
@@ -0,0 +1,120 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ghozilaaa/optimistic-lock/database"
+	"github.com/ghozilaaa/optimistic-lock/secrets"
+)
+
+func TestFileSecretsFollowReplacedFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, value string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("username", "app")
+	write("password", "first")
+	provider := secrets.File{UserPath: filepath.Join(dir, "username"), PasswordPath: filepath.Join(dir, "password")}
+
+	creds, err := database.NewCredentials(context.Background(), provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := creds.Current(); got != (secrets.Credentials{User: "app", Password: "first"}) {
+		t.Fatalf("credentials = %+v", got)
+	}
+
+	rotations := 0
+	creds.OnRotate(func() { rotations++ })
+	if rotated, err := creds.Refresh(context.Background()); err != nil || rotated {
+		t.Fatalf("refresh without a change = %v, %v", rotated, err)
+	}
+	write("password", "second")
+	if rotated, err := creds.Refresh(context.Background()); err != nil || !rotated {
+		t.Fatalf("refresh after rotation = %v, %v", rotated, err)
+	}
+	if creds.Current().Password != "second" || rotations != 1 {
+		t.Errorf("password = %q after %d rotations", creds.Current().Password, rotations)
+	}
+}
+
+func TestVaultSecrets(t *testing.T) {
+	responses := map[string]string{
+		"/v1/secret/data/optimistic-lock":           `{"data":{"data":{"username":"kv","password":"kv-pass"},"metadata":{"version":3}}}`,
+		"/v1/database/static-creds/optimistic-lock": `{"lease_id":"","data":{"username":"app","password":"static-pass","ttl":3600}}`,
+		"/v1/database/creds/optimistic-lock":        `{"lease_id":"database/creds/x","data":{"username":"v-token-app","password":"dyn-pass"}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("s.token\n"), 0o600)
+
+	for path, want := range map[string]secrets.Credentials{
+		"secret/data/optimistic-lock":           {User: "kv", Password: "kv-pass"},
+		"database/static-creds/optimistic-lock": {User: "app", Password: "static-pass"},
+	} {
+		got, err := secrets.Vault{Addr: server.URL, Path: path, TokenFile: tokenFile}.Credentials(context.Background())
+		if err != nil || got != want {
+			t.Errorf("%s: got %+v, %v; want %+v", path, got, err, want)
+		}
+	}
+	if _, err := (secrets.Vault{Addr: server.URL, Path: "database/creds/optimistic-lock", TokenFile: tokenFile}).Credentials(context.Background()); !errors.Is(err, secrets.ErrLeasedSecret) {
+		t.Errorf("dynamic role: got %v, want ErrLeasedSecret", err)
+	}
+
+	t.Setenv("VAULT_TOKEN", "wrong")
+	if _, err := (secrets.Vault{Addr: server.URL, Path: "secret/data/optimistic-lock"}).Credentials(context.Background()); err == nil ||
+		!strings.Contains(err.Error(), "403") {
+		t.Errorf("expected a 403 error with a bad token, got %v", err)
+	}
+}
+
+func TestAWSSecretsManagerSecrets(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(auth, "/eu-west-1/secretsmanager/aws4_request") ||
+			r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" {
+			http.Error(w, "bad request signature", http.StatusBadRequest)
+			return
+		}
+		var req struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&req)
+		secret, _ := json.Marshal(map[string]string{"username": "rds", "password": "rotated", "engine": "postgres"})
+		json.NewEncoder(w).Encode(map[string]string{"Name": req.SecretId, "SecretString": string(secret)})
+	}))
+	defer server.Close()
+
+	got, err := secrets.AWSSecretsManager{Region: "eu-west-1", SecretID: "prod/db", Endpoint: server.URL}.Credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != (secrets.Credentials{User: "rds", Password: "rotated"}) {
+		t.Errorf("credentials = %+v", got)
+	}
+}
@@ -0,0 +1,68 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestShardedBalance(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	const id = 77
+
+	if err := service.CreateShards(db, id, 4, 1002); err != nil {
+		t.Fatal(err)
+	}
+	var shards []models.BalanceShard
+	db.Where("balance_id = ?", id).Order("shard").Find(&shards)
+	if len(shards) != 4 || shards[0].Amount != 251 || shards[3].Amount != 250 {
+		t.Fatalf("expected 1002 spread over 4 shards, got %+v", shards)
+	}
+
+	updater := service.NewUpdater(db, service.WithBaseBackoff(time.Millisecond))
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := updater.UpdateShardedBalance(id, 5); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// A debit larger than a shard leaves it negative until rebalanced
+	if _, err := updater.UpdateShardedBalance(id, -700); err != nil {
+		t.Fatal(err)
+	}
+	total, err := service.GetTotal(db, id)
+	if err != nil || total.Amount != 1002+200-700 || total.Shards != 4 {
+		t.Fatalf("expected a total of 502 over 4 shards, got %+v %v", total, err)
+	}
+
+	rebalanced, err := service.NewShardRebalancer(db, 1).RebalanceOnce(context.Background())
+	if err != nil || rebalanced != 1 {
+		t.Fatalf("expected one rebalanced balance, got %d %v", rebalanced, err)
+	}
+	db.Where("balance_id = ?", id).Order("shard").Find(&shards)
+	for _, s := range shards {
+		if s.Amount < 125 || s.Amount > 126 {
+			t.Errorf("expected even shards after rebalancing, got %+v", shards)
+			break
+		}
+	}
+	if total, _ := service.GetTotal(db, id); total.Amount != 502 {
+		t.Errorf("expected rebalancing to keep the total, got %d", total.Amount)
+	}
+
+	if _, err := updater.UpdateShardedBalance(id+1, 5); !errors.Is(err, service.ErrNotSharded) {
+		t.Errorf("expected ErrNotSharded, got %v", err)
+	}
+}
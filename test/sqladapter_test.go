@@ -0,0 +1,65 @@
+//go:build sqlite
+
+package service_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/ghozilaaa/optimistic-lock/sqladapter"
+)
+
+func TestSQLAdapterConcurrentUpdates(t *testing.T) {
+	t.Parallel()
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "adapter.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	db.Exec("CREATE TABLE balances (id INTEGER PRIMARY KEY, amount INTEGER, version INTEGER, deleted_at DATETIME)")
+	db.Exec("INSERT INTO balances (id, amount, version) VALUES (1, 1000, 0)")
+
+	updater := sqladapter.New(sqladapter.MySQLQueries)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := updater.UpdateBalance(ctx, db, 1, 10); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	conflictCount := 0
+	for err := range errs {
+		if !errors.Is(err, sqladapter.ErrConflict) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		conflictCount++
+	}
+
+	var amount int64
+	db.QueryRow("SELECT amount FROM balances WHERE id = 1").Scan(&amount)
+	expected := int64(1000 + (50-conflictCount)*10)
+	if amount != expected {
+		t.Errorf("Balance integrity failed: expected %d, got %d", expected, amount)
+	}
+
+	if err := updater.UpdateBalance(ctx, db, 2, 10); !errors.Is(err, sqladapter.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for missing row, got %v", err)
+	}
+}
@@ -0,0 +1,451 @@
+//go:build sqlite
+
+package service_test
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/ghozilaaa/optimistic-lock/cache"
+	"github.com/ghozilaaa/optimistic-lock/database"
+	"github.com/ghozilaaa/optimistic-lock/migrations"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+// openDB runs the feature tests on SQLite when built with the sqlite tag
+func openDB(t testing.TB) *gorm.DB {
+	return openSQLite(t)
+}
+
+// openSQLite opens a fresh SQLite database file in a temp directory
+func openSQLite(t testing.TB) *gorm.DB {
+	t.Helper()
+	db, err := database.Open(database.Config{
+		Driver: "sqlite",
+		Name:   filepath.Join(t.TempDir(), "optimistic_lock.db"),
+	}, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(testModels...); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	return db
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	t.Parallel()
+	db, err := database.Open(database.Config{
+		Driver: "sqlite",
+		Name:   filepath.Join(t.TempDir(), "migrations.db"),
+	}, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open sqlite: %v", err)
+	}
+
+	applied, err := migrations.Up(db, 0)
+	if err != nil || len(applied) != len(migrations.All) {
+		t.Fatalf("expected every migration applied, got %d and %v", len(applied), err)
+	}
+	if drift, err := migrations.DetectDrift(db); err != nil || len(drift) != 0 {
+		t.Fatalf("expected no drift after migrating, got %v and %v", drift, err)
+	}
+	if applied, _ := migrations.Up(db, 0); len(applied) != 0 {
+		t.Errorf("expected a second Up to do nothing, applied %d", len(applied))
+	}
+
+	reverted, err := migrations.Down(db, 7)
+	if err != nil || len(reverted) != 17 || reverted[0].Version != 24 {
+		t.Fatalf("expected migrations 24 to 8 reverted, got %v and %v", reverted, err)
+	}
+	if db.Migrator().HasTable("balance_shards") || !db.Migrator().HasTable("balance_events") {
+		t.Error("expected only the tables of the reverted migrations dropped")
+	}
+	if db.Migrator().HasColumn("balances", "tenant_id") {
+		t.Error("expected balances.tenant_id dropped")
+	}
+	drift, _ := migrations.DetectDrift(db)
+	if len(drift) != 39 {
+		t.Errorf("expected 17 pending migrations, 8 missing tables, 6 missing balance columns, 3 indexes, decimal_balances.tenant_id and the tenant columns and indexes of adjustments and failed_updates, got %v", drift)
+	}
+
+	// A column added outside the migrations shows up as drift
+	migrations.Up(db, 0)
+	db.Exec("ALTER TABLE balances ADD COLUMN note TEXT")
+	drift, _ = migrations.DetectDrift(db)
+	if len(drift) != 1 || drift[0].String() != "balances.note: column not in the model" {
+		t.Errorf("expected the extra column reported, got %v", drift)
+	}
+
+	// A database created by AutoMigrate is adopted without changes
+	legacy := openSQLite(t)
+	if _, err := migrations.Up(legacy, 0); err != nil {
+		t.Fatalf("Up on an AutoMigrate database failed: %v", err)
+	}
+	if drift, _ := migrations.DetectDrift(legacy); len(drift) != 0 {
+		t.Errorf("expected no drift on the adopted database, got %v", drift)
+	}
+}
+
+func TestSQLiteStaleVersionAffectsNoRows(t *testing.T) {
+	t.Parallel()
+	db := openSQLite(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	// SQLite reports matched rows, so a write guarded by a stale version affects none
+	result := db.Model(&models.Balance{}).Where("id = ? AND version = ?", balance.ID, balance.Version+1).
+		Update("amount", 1)
+	if result.Error != nil {
+		t.Fatalf("Update failed: %v", result.Error)
+	}
+	if result.RowsAffected != 0 {
+		t.Errorf("expected 0 rows affected for stale version, got %d", result.RowsAffected)
+	}
+
+	// Writing identical values with the current version still counts as affected
+	result = db.Model(&models.Balance{}).Where("id = ? AND version = ?", balance.ID, balance.Version).
+		Update("amount", balance.Amount)
+	if result.RowsAffected != 1 {
+		t.Errorf("expected 1 row affected for current version, got %d", result.RowsAffected)
+	}
+}
+
+func TestSQLiteUpdateReturningSkipsReads(t *testing.T) {
+	t.Parallel()
+	db := openSQLite(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	var reads int
+	db.Callback().Query().After("gorm:query").Register("test:count_reads", func(tx *gorm.DB) {
+		if tx.Statement.Table == "balances" {
+			reads++
+		}
+	})
+	updater := service.NewUpdater(db, service.WithCache(cache.New(10)))
+
+	// The first update reads the version; the cache knows it afterwards
+	for i, wantReads := range []int{1, 1} {
+		outcome, err := updater.UpdateBalance(balance.ID, 10)
+		if err != nil {
+			t.Fatalf("UpdateBalance failed: %v", err)
+		}
+		want := service.UpdateOutcome{Attempts: 1, PreviousAmount: 1000 + int64(i)*10, NewAmount: 1010 + int64(i)*10, Version: balance.Version + i + 1}
+		if !reflect.DeepEqual(outcome, want) {
+			t.Errorf("expected outcome %+v, got %+v", want, outcome)
+		}
+		if reads != wantReads {
+			t.Errorf("expected %d reads after update %d, got %d", wantReads, i+1, reads)
+		}
+	}
+
+	// A stale cached version costs a conflict and a read, then succeeds
+	db.Exec("UPDATE balances SET version = version + 1 WHERE id = ?", balance.ID)
+	outcome, err := updater.UpdateBalance(balance.ID, 10)
+	if err != nil || outcome.Attempts != 2 || outcome.NewAmount != 1030 || outcome.Version != balance.Version+4 {
+		t.Errorf("expected the second attempt to succeed, got %+v, %v", outcome, err)
+	}
+
+	// UpdateIfVersion never needs to read first
+	reads = 0
+	if _, err := updater.UpdateIfVersion(balance.ID, balance.Version+4, 10); err != nil {
+		t.Errorf("UpdateIfVersion failed: %v", err)
+	}
+	if _, err := updater.UpdateIfVersion(balance.ID, balance.Version+4, 10); !errors.Is(err, service.ErrConflict) {
+		t.Errorf("expected ErrConflict for a stale version, got %v", err)
+	}
+	if reads != 1 {
+		t.Errorf("expected a read only to confirm the conflict, got %d", reads)
+	}
+}
+
+func TestSQLiteRawSQLUpdates(t *testing.T) {
+	t.Parallel()
+	db := openSQLite(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	updater := service.NewUpdater(db, service.WithRawSQL(), service.WithMaxAttempts(2), service.WithNoBackoff())
+	outcome, err := updater.UpdateBalance(balance.ID, 25)
+	want := service.UpdateOutcome{Attempts: 1, PreviousAmount: 1000, NewAmount: 1025, Version: balance.Version + 1}
+	if err != nil || !reflect.DeepEqual(outcome, want) {
+		t.Errorf("expected outcome %+v, got %+v, %v", want, outcome, err)
+	}
+
+	// Concurrent raw updates add up
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			service.UpdateBalance(db, balance.ID, 1, service.WithRawSQL(), service.WithPessimisticFallback(1))
+		}()
+	}
+	wg.Wait()
+	if final, _ := service.GetBalance(db, balance.ID); final.Amount != 1045 {
+		t.Errorf("expected amount 1045, got %d", final.Amount)
+	}
+
+	// Missing and deleted balances are not retried
+	if _, err := updater.UpdateBalance(9999, 1); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	db.Delete(&models.Balance{ID: balance.ID})
+	if outcome, err := updater.UpdateBalance(balance.ID, 1); !errors.Is(err, service.ErrNotFound) || outcome.Attempts != 1 {
+		t.Errorf("expected ErrNotFound after one attempt, got %+v, %v", outcome, err)
+	}
+}
+
+// BenchmarkSQLiteUpdateBalance compares the GORM attempt with the raw SQL
+// fast path: go test -tags sqlite -bench UpdateBalance -benchmem ./test
+func BenchmarkSQLiteUpdateBalance(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts []service.Option
+	}{
+		{"gorm", nil},
+		{"raw", []service.Option{service.WithRawSQL()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			db := openSQLite(b)
+			balance := models.Balance{Amount: 0}
+			db.Create(&balance)
+			updater := service.NewUpdater(db, bc.opts...)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := updater.UpdateBalance(balance.ID, 1); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSQLiteBackfillBalanceUIDs(t *testing.T) {
+	t.Parallel()
+	db, err := database.Open(database.Config{
+		Driver: "sqlite",
+		Name:   filepath.Join(t.TempDir(), "backfill.db"),
+	}, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open sqlite: %v", err)
+	}
+	if _, err := migrations.Up(db, 21); err != nil {
+		t.Fatal(err)
+	}
+	kept := "kept-uid"
+	db.Create(&[]models.Balance{{Amount: 1}, {Amount: 2}, {Amount: 3, UID: &kept}})
+	db.Delete(&models.Balance{}, 2)
+
+	if _, err := migrations.Up(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	var balances []models.Balance
+	db.Unscoped().Order("id").Find(&balances)
+	if len(balances) != 3 || balances[0].UID == nil || balances[1].UID == nil || *balances[0].UID == *balances[1].UID {
+		t.Fatalf("expected distinct UIDs for every balance, got %+v", balances)
+	}
+	if len(*balances[0].UID) != 36 || *balances[2].UID != kept {
+		t.Errorf("expected a UUIDv7 backfilled and the existing UID kept, got %s and %s", *balances[0].UID, *balances[2].UID)
+	}
+}
+
+func TestSQLiteKeyTenantsMigration(t *testing.T) {
+	t.Parallel()
+	db, err := database.Open(database.Config{
+		Driver: "sqlite",
+		Name:   filepath.Join(t.TempDir(), "keys.db"),
+	}, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open sqlite: %v", err)
+	}
+	if _, err := migrations.Up(db, 22); err != nil {
+		t.Fatal(err)
+	}
+	// Rows as the version 22 schema holds them, without tenants
+	for _, sql := range []string{
+		"INSERT INTO balances (id, tenant_id, amount, version) VALUES (1, 'acme', 100, 1)",
+		"INSERT INTO adjustments (reference, balance_id, delta, created_at) VALUES ('pay-1', 1, 100, CURRENT_TIMESTAMP)",
+		"INSERT INTO dedup_keys (reference, balance_id, created_at) VALUES ('pay-1', 1, CURRENT_TIMESTAMP)",
+		"INSERT INTO failed_updates (idempotency_key, balance_id, delta, next_replay_at, created_at) VALUES ('pay-2', 1, 5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)",
+	} {
+		if err := db.Exec(sql).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := migrations.Up(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	var adjustment models.Adjustment
+	var key models.DedupKey
+	var failed models.FailedUpdate
+	db.First(&adjustment)
+	db.First(&key)
+	db.First(&failed)
+	if adjustment.TenantID != "acme" || key.TenantID != "acme" || key.Reference != "pay-1" || failed.TenantID != "acme" {
+		t.Errorf("expected the tenant of the balance backfilled, got %+v, %+v and %+v", adjustment, key, failed)
+	}
+	// Another tenant can use the same references now
+	other := []any{
+		&models.Adjustment{TenantID: "globex", Reference: "pay-1", BalanceID: 2},
+		&models.DedupKey{TenantID: "globex", Reference: "pay-1", BalanceID: 2},
+		&models.FailedUpdate{TenantID: "globex", IdempotencyKey: "pay-2", BalanceID: 2},
+	}
+	for _, row := range other {
+		if err := db.Create(row).Error; err != nil {
+			t.Errorf("expected %T unique per tenant, got %v", row, err)
+		}
+	}
+	if drift, _ := migrations.DetectDrift(db); len(drift) != 0 {
+		t.Errorf("expected no drift, got %v", drift)
+	}
+}
+
+func TestSQLiteErrorClassification(t *testing.T) {
+	t.Parallel()
+	db := openSQLite(t)
+	balance, err := service.CreateBalance(db, "alice", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// failWrites fails the next n balance writes with err
+	var failing atomic.Int32
+	var writeErr error
+	db.Callback().Update().Before("gorm:update").Register("test:fail_write", func(tx *gorm.DB) {
+		if failing.Add(-1) >= 0 {
+			tx.AddError(writeErr)
+		}
+	})
+	failWrites := func(n int32, err error) {
+		writeErr = err
+		failing.Store(n)
+	}
+	updater := service.NewUpdater(db, service.WithNoBackoff())
+
+	// A constraint violation fails the same way every time and is not retried
+	failWrites(5, errors.New("CHECK constraint failed: amount"))
+	outcome, err := updater.UpdateBalance(balance.ID, 1)
+	if err == nil || errors.Is(err, service.ErrRetryExhausted) || outcome.Attempts != 1 {
+		t.Errorf("expected the write error after one attempt, got %d attempts and %v", outcome.Attempts, err)
+	}
+	// unless a classifier says so
+	failWrites(5, errors.New("CHECK constraint failed: amount"))
+	outcome, err = updater.With(service.WithErrorClassifier(func(error) bool { return true })).UpdateBalance(balance.ID, 1)
+	if err == nil || outcome.Attempts != 5 {
+		t.Errorf("expected every attempt to fail, got %d attempts and %v", outcome.Attempts, err)
+	}
+
+	// A busy database is retried
+	failWrites(1, errors.New("database is locked"))
+	outcome, err = updater.UpdateBalance(balance.ID, 1)
+	if err != nil || outcome.Attempts != 2 {
+		t.Errorf("expected the update through on the second attempt, got %d attempts and %v", outcome.Attempts, err)
+	}
+	failWrites(0, nil)
+
+	// An attempt past the statement timeout is cancelled and retried
+	var slow atomic.Int32
+	slow.Store(1)
+	db.Callback().Query().Before("gorm:query").Register("test:slow_read", func(tx *gorm.DB) {
+		if slow.Add(-1) >= 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+	outcome, err = updater.With(service.WithStatementTimeout(20*time.Millisecond)).UpdateBalance(balance.ID, 1)
+	if err != nil || outcome.Attempts != 2 {
+		t.Errorf("expected the slow attempt retried, got %d attempts and %v", outcome.Attempts, err)
+	}
+	slow.Store(5)
+	_, err = updater.With(service.WithStatementTimeout(20*time.Millisecond), service.WithMaxAttempts(2)).UpdateBalance(balance.ID, 1)
+	if !errors.Is(err, service.ErrStatementTimeout) {
+		t.Errorf("expected ErrStatementTimeout, got %v", err)
+	}
+	slow.Store(0)
+	if current, _ := service.GetBalance(db, balance.ID); current.Amount != 102 {
+		t.Errorf("expected the two successful updates applied, got %d", current.Amount)
+	}
+}
+
+func TestSQLiteStrategies(t *testing.T) {
+	t.Parallel()
+	db := openSQLite(t)
+	balance, err := service.CreateBalance(db, "alice", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.ParseStrategy("pessimistic"); err == nil {
+		t.Error("expected an unknown strategy to be refused")
+	}
+	strategy, err := service.ParseStrategy("serializable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The strategy is chosen per call, behind the same API
+	updater := service.NewUpdater(db)
+	outcome, err := updater.With(service.WithStrategy(strategy)).UpdateBalance(balance.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := service.UpdateOutcome{Attempts: 1, PreviousAmount: 100, NewAmount: 110, Version: balance.Version + 1}
+	if !reflect.DeepEqual(outcome, want) {
+		t.Errorf("expected %+v, got %+v", want, outcome)
+	}
+	// and bumps the version, so optimistic writers holding the old one conflict
+	if _, err := updater.UpdateIfVersion(balance.ID, balance.Version, 5); !errors.Is(err, service.ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+
+	// Row locks work on SQLite, which ignores them; advisory locks need Postgres
+	outcome, err = service.UpdateBalance(db, balance.ID, 5, service.WithStrategy(service.StrategyForUpdate))
+	if err != nil || outcome.NewAmount != 115 {
+		t.Errorf("expected 115 written under a row lock, got %+v and %v", outcome, err)
+	}
+	if _, err := service.UpdateBalance(db, balance.ID, 5, service.WithStrategy(service.StrategyAdvisory)); !errors.Is(err, service.ErrStrategyUnsupported) {
+		t.Errorf("expected ErrStrategyUnsupported, got %v", err)
+	}
+
+	// An atomic increment never reads first, so it never conflicts
+	increment := service.WithStrategy(service.StrategyAtomic)
+	outcome, err = service.UpdateBalance(db, balance.ID, 5, increment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = service.UpdateOutcome{Attempts: 1, PreviousAmount: 115, NewAmount: 120, Version: balance.Version + 3}
+	if !reflect.DeepEqual(outcome, want) {
+		t.Errorf("expected %+v, got %+v", want, outcome)
+	}
+
+	if _, err := service.Freeze(db, balance.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UpdateBalance(db, balance.ID, 1, service.WithStrategy(strategy)); !errors.Is(err, service.ErrNotActive) {
+		t.Errorf("expected ErrNotActive, got %v", err)
+	}
+	if _, err := service.UpdateBalance(db, balance.ID, 1, increment); !errors.Is(err, service.ErrNotActive) {
+		t.Errorf("expected ErrNotActive, got %v", err)
+	}
+	if _, err := service.UpdateBalance(db, 9999, 1, service.WithStrategy(strategy)); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := service.UpdateBalance(db, 9999, 1, increment); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package service_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ghozilaaa/optimistic-lock/cache"
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestBalanceStatus(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	balance := models.Balance{Amount: 100}
+	db.Create(&balance)
+	cached := service.NewUpdater(db, service.WithCache(cache.New(16)))
+	if _, err := cached.UpdateBalance(balance.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	frozen, err := service.Freeze(db, balance.ID)
+	if err != nil || frozen.Status != models.BalanceFrozen || frozen.Version != 2 {
+		t.Fatalf("expected the balance frozen at version 2, got %+v and %v", frozen, err)
+	}
+
+	// Every write path refuses a frozen balance without retrying, even one
+	// that expects the version the freeze left
+	paths := map[string]func() error{
+		"UpdateBalance": func() error { _, err := service.UpdateBalance(db, balance.ID, 1); return err },
+		"raw SQL":       func() error { _, err := service.UpdateBalance(db, balance.ID, 1, service.WithRawSQL()); return err },
+		"pessimistic": func() error {
+			_, err := service.UpdateBalance(db, balance.ID, 1, service.WithPessimisticFallback(1), service.WithMaxAttempts(1))
+			return err
+		},
+		"UpdateIfVersion": func() error { _, err := service.UpdateIfVersion(db, balance.ID, frozen.Version, 1); return err },
+		"cached version":  func() error { _, err := cached.UpdateBalance(balance.ID, 1); return err },
+	}
+	for name, write := range paths {
+		err := write()
+		var statusErr *service.StatusError
+		if !errors.As(err, &statusErr) || !errors.Is(err, service.ErrNotActive) || statusErr.Status != models.BalanceFrozen {
+			t.Errorf("%s: expected a *StatusError for the frozen balance, got %v", name, err)
+		}
+	}
+	if current, _ := service.GetBalance(db, balance.ID); current.Amount != 101 || current.Version != 2 {
+		t.Errorf("expected the frozen balance untouched, got %+v", current)
+	}
+
+	// Freezing twice is a no-op; unfreezing lets updates through again
+	if again, err := service.Freeze(db, balance.ID); err != nil || again.Version != 2 {
+		t.Errorf("expected a repeated freeze to change nothing, got %+v and %v", again, err)
+	}
+	if _, err := service.Unfreeze(db, balance.ID); err != nil {
+		t.Fatal(err)
+	}
+	if outcome, err := service.UpdateBalance(db, balance.ID, 1); err != nil || outcome.NewAmount != 102 {
+		t.Errorf("expected the unfrozen balance updated, got %+v and %v", outcome, err)
+	}
+
+	// Closed is final
+	if _, err := service.Close(db, balance.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Unfreeze(db, balance.ID); !errors.Is(err, service.ErrInvalidTransition) {
+		t.Errorf("expected ErrInvalidTransition, got %v", err)
+	}
+	if _, err := service.Freeze(db, 999); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	server := httptest.NewServer((&httpapi.Server{DB: db}).Handler())
+	defer server.Close()
+	current, _ := service.GetBalance(db, balance.ID)
+	req, _ := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/balances/%d", server.URL, balance.ID), strings.NewReader(`{"delta": 1}`))
+	req.Header.Set("If-Match", fmt.Sprintf(`"v=%d"`, current.Version))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a closed balance, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,243 @@
+package service_test
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/kvstore"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestMemoryStoreSimulatedConflicts(t *testing.T) {
+	store := service.NewMemoryStore()
+	balance, _ := store.Create(1000)
+	updater := service.NewUpdater(nil, service.WithStore(store), service.WithNoBackoff())
+
+	store.ConflictNext(balance.ID, 2)
+	outcome, err := updater.UpdateBalance(balance.ID, 10)
+	if err != nil {
+		t.Fatalf("UpdateBalance failed: %v", err)
+	}
+	if outcome.Attempts != 3 || outcome.Conflicts != 2 || outcome.NewAmount != 1010 {
+		t.Errorf("expected success on the third attempt, got %+v", outcome)
+	}
+
+	// Every simulated conflict bumped the version, like a concurrent writer
+	if current, _ := store.Get(balance.ID); current.Version != 3 || current.Amount != 1010 {
+		t.Errorf("expected version 3 and amount 1010, got %+v", current)
+	}
+
+	store.SetConflictRate(1)
+	if _, err := updater.UpdateBalance(balance.ID, 10); !errors.Is(err, service.ErrRetryExhausted) {
+		t.Errorf("expected ErrRetryExhausted, got %v", err)
+	}
+
+	// The store has no row locks: the fallback makes one more attempt
+	outcome, err = updater.With(service.WithPessimisticFallback(2)).UpdateBalance(balance.ID, 10)
+	if !errors.Is(err, service.ErrRetryExhausted) || !outcome.Pessimistic || outcome.Conflicts != 3 {
+		t.Errorf("expected the fallback to conflict too, got %+v and %v", outcome, err)
+	}
+	if got := store.SimulatedConflicts(); got != 2+5+3 {
+		t.Errorf("expected 10 simulated conflicts, got %d", got)
+	}
+
+	store.Delete(balance.ID)
+	if _, err := updater.UpdateBalance(balance.ID, 10); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRetryAfterOnExhaustedRetries(t *testing.T) {
+	store := service.NewMemoryStore()
+	store.SetConflictRate(1)
+	balance, _ := store.Create(1000)
+	updater := service.NewUpdater(nil, service.WithStore(store), service.WithMaxAttempts(3), service.WithBaseBackoff(time.Millisecond))
+
+	// The suggestion carries on the backoff: 1ms doubled per attempt made
+	_, err := updater.UpdateBalance(balance.ID, 10)
+	if !errors.Is(err, service.ErrRetryExhausted) {
+		t.Fatalf("expected ErrRetryExhausted, got %v", err)
+	}
+	if delay, ok := service.RetryAfter(err); !ok || delay != 8*time.Millisecond {
+		t.Errorf("expected a suggested delay of 8ms, got %v (%v)", delay, ok)
+	}
+
+	// A balance known to be hot gets a longer one
+	adaptive := service.NewAdaptiveBackoff(service.AdaptiveConfig{MinAttempts: 1, MaxScale: 4})
+	_, err = updater.With(service.WithAdaptiveBackoff(adaptive)).UpdateBalance(balance.ID, 10)
+	if delay, ok := service.RetryAfter(err); !ok || delay != 32*time.Millisecond {
+		t.Errorf("expected the delay scaled by the conflict rate to 32ms, got %v (%v)", delay, ok)
+	}
+
+	// Other failures suggest nothing
+	store.Delete(balance.ID)
+	if _, err := updater.UpdateBalance(balance.ID, 10); !errors.Is(err, service.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	} else if _, ok := service.RetryAfter(err); ok {
+		t.Errorf("expected no suggested delay for a missing balance, got one on %v", err)
+	}
+}
+
+func TestMemoryStoreConcurrentUpdates(t *testing.T) {
+	store := service.NewMemoryStore()
+	store.SetConflictRate(0.3)
+	balance, _ := store.Create(0)
+	updater := service.NewUpdater(nil, service.WithStore(store), service.WithMaxAttempts(50), service.WithNoBackoff())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := updater.UpdateBalance(balance.ID, 1); err != nil {
+				t.Errorf("UpdateBalance failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	current, _ := store.Get(balance.ID)
+	if current.Amount != 100 {
+		t.Errorf("expected amount 100, got %d", current.Amount)
+	}
+	if store.SimulatedConflicts() == 0 {
+		t.Error("expected some simulated conflicts")
+	}
+}
+
+func TestStoreRefusesUncheckedUpdates(t *testing.T) {
+	store := service.NewMemoryStore()
+	balance, _ := store.Create(100)
+	for name, opt := range map[string]service.Option{
+		"policies":        service.WithPolicies(service.MinimumBalance()),
+		"spending limits": service.WithSpendingLimits(),
+	} {
+		updater := service.NewUpdater(nil, service.WithStore(store), opt)
+		if _, err := updater.UpdateBalance(balance.ID, -500); !errors.Is(err, service.ErrChecksUnsupported) {
+			t.Errorf("%s: expected ErrChecksUnsupported, got %v", name, err)
+		}
+		if _, err := updater.UpdateShardedBalance(balance.ID, -500); !errors.Is(err, service.ErrChecksUnsupported) {
+			t.Errorf("%s: expected sharded updates refused, got %v", name, err)
+		}
+	}
+	if current, _ := store.Get(balance.ID); current.Amount != 100 || current.Version != 0 {
+		t.Errorf("expected the balance untouched, got %+v", current)
+	}
+}
+
+func TestKVStoreBehindUpdater(t *testing.T) {
+	store, err := kvstore.Open(filepath.Join(t.TempDir(), "balances.db"))
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	balance, _ := store.Create(100)
+	updater := service.NewUpdater(nil, service.WithStore(store))
+	if outcome, err := updater.UpdateBalance(balance.ID, -40); err != nil || outcome.NewAmount != 60 {
+		t.Fatalf("expected amount 60, got %+v and %v", outcome, err)
+	}
+	if _, err := updater.UpdateBalance(balance.ID+1, 1); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestGormStore(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+	store := service.NewGormStore(db)
+
+	if swapped, err := store.CompareAndSwap(balance.ID, balance.Version+1, 0); swapped || err != nil {
+		t.Fatalf("expected a stale version not to swap, got %v and %v", swapped, err)
+	}
+	if swapped, err := store.CompareAndSwap(balance.ID+1, 0, 0); swapped || !errors.Is(err, service.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a missing balance, got %v and %v", swapped, err)
+	}
+
+	outcome, err := service.NewUpdater(nil, service.WithStore(store)).UpdateBalance(balance.ID, 25)
+	if err != nil || outcome.NewAmount != 1025 || outcome.Version != balance.Version+1 {
+		t.Fatalf("expected amount 1025 at the next version, got %+v and %v", outcome, err)
+	}
+	if current, err := store.Get(balance.ID); err != nil || current.Amount != 1025 {
+		t.Errorf("expected the write in the database, got %+v and %v", current, err)
+	}
+}
+
+// legacyAccount is a table of an existing schema with its own names
+type legacyAccount struct {
+	AccountID   uint `gorm:"primaryKey"`
+	Balance     int64
+	LockVersion int `gorm:"version"`
+}
+
+// legacyWallet is versioned by the time of its last write
+type legacyWallet struct {
+	ID         uint `gorm:"primaryKey"`
+	Amount     int64
+	RowVersion time.Time `gorm:"version"`
+}
+
+func TestTableStore(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	if err := db.AutoMigrate(&legacyAccount{}, &legacyWallet{}); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	table, err := service.TableOf(db, &legacyAccount{}, "Balance")
+	want := service.Table{Name: "legacy_accounts", ID: "account_id", Amount: "balance", Version: "lock_version", VersionType: service.VersionCounter}
+	if err != nil || table != want {
+		t.Fatalf("expected %+v, got %+v and %v", want, table, err)
+	}
+	if _, err := service.TableOf(db, &struct{ ID uint }{}, "ID"); err == nil {
+		t.Error("expected a model without a version field rejected")
+	}
+
+	account := legacyAccount{Balance: 1000, LockVersion: 7}
+	db.Create(&account)
+	store, _ := service.NewTableStore(db, table)
+	if swapped, err := store.CompareAndSwap(account.AccountID, 6, 0); swapped || err != nil {
+		t.Fatalf("expected a stale version not to swap, got %v and %v", swapped, err)
+	}
+	if _, err := store.CompareAndSwap(account.AccountID+1, 7, 0); !errors.Is(err, service.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a missing row, got %v", err)
+	}
+	outcome, err := service.NewUpdater(nil, service.WithStore(store)).UpdateBalance(account.AccountID, 25)
+	if err != nil || outcome.NewAmount != 1025 || outcome.Version != 8 {
+		t.Fatalf("expected 1025 at version 8, got %+v and %v", outcome, err)
+	}
+	db.First(&account, account.AccountID)
+	if account.Balance != 1025 || account.LockVersion != 8 {
+		t.Errorf("expected the legacy row written, got %+v", account)
+	}
+
+	// A timestamp version moves on to the time of each write
+	table, err = service.TableOf(db, &legacyWallet{}, "Amount")
+	if err != nil || table.VersionType != service.VersionTimestamp || table.Version != "row_version" {
+		t.Fatalf("expected a timestamp version, got %+v and %v", table, err)
+	}
+	wallet := legacyWallet{Amount: 100, RowVersion: time.Now().Add(-time.Hour).Truncate(time.Microsecond)}
+	db.Create(&wallet)
+	store, _ = service.NewTableStore(db, table)
+	updater := service.NewUpdater(nil, service.WithStore(store))
+	for i := 0; i < 2; i++ {
+		if _, err := updater.UpdateBalance(wallet.ID, 10); err != nil {
+			t.Fatalf("UpdateBalance failed: %v", err)
+		}
+	}
+	before := wallet.RowVersion
+	db.First(&wallet, wallet.ID)
+	if wallet.Amount != 120 || !wallet.RowVersion.After(before) {
+		t.Errorf("expected 120 with a newer row version, got %+v", wallet)
+	}
+	if swapped, _ := store.CompareAndSwap(wallet.ID, int(before.UnixMicro()), 0); swapped {
+		t.Error("expected the old timestamp not to swap")
+	}
+}
@@ -0,0 +1,175 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+	"github.com/ghozilaaa/optimistic-lock/tenant"
+	"github.com/ghozilaaa/optimistic-lock/velocity"
+)
+
+func TestTenantScopedBalances(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	acme, globex := tenant.Scope(db, "acme"), tenant.Scope(db, "globex")
+
+	// Owners are unique per tenant
+	a, err := service.CreateBalance(acme, "owner-1", 100)
+	if err != nil || a.TenantID != "acme" {
+		t.Fatalf("expected a balance of acme, got %+v and %v", a, err)
+	}
+	g, err := service.CreateBalance(globex, "owner-1", 200)
+	if err != nil || g.TenantID != "globex" {
+		t.Fatalf("expected a balance of globex for the same owner, got %+v and %v", g, err)
+	}
+
+	if _, err := service.GetBalance(globex, a.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected another tenant's balance to be invisible, got %v", err)
+	}
+	updater := service.NewUpdater(db, service.WithNoBackoff())
+	for name, u := range map[string]*service.Updater{
+		"gorm": updater.With(service.WithTenant("globex")),
+		"raw":  updater.With(service.WithRawSQL(), service.WithTenant("globex")),
+		// Attempts bounded by a statement timeout keep the tenant of the handle
+		"timed": updater.With(service.WithTenant("globex"), service.WithStatementTimeout(time.Second)),
+	} {
+		if _, err := u.UpdateBalance(a.ID, 10); !errors.Is(err, service.ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound updating another tenant's balance, got %v", name, err)
+		}
+	}
+	if outcome, err := updater.With(service.WithTenant("acme")).UpdateBalance(a.ID, 10); err != nil || outcome.NewAmount != 110 {
+		t.Errorf("expected acme to update its balance, got %+v and %v", outcome, err)
+	}
+
+	// Unscoped handles see every tenant; scoped writes keep GORM's guards
+	if balance, err := service.GetBalance(db, g.ID); err != nil || balance.Amount != 200 {
+		t.Errorf("expected the unscoped handle to read any balance, got %+v and %v", balance, err)
+	}
+	if err := acme.Delete(&models.Balance{}).Error; !errors.Is(err, gorm.ErrMissingWhereClause) {
+		t.Errorf("expected ErrMissingWhereClause, got %v", err)
+	}
+	if err := acme.Create(&models.Balance{TenantID: "globex"}).Error; !errors.Is(err, tenant.ErrTenantMismatch) {
+		t.Errorf("expected ErrTenantMismatch, got %v", err)
+	}
+
+	// Scripts, sharded and decimal balances are scoped as well
+	globexUpdater := updater.With(service.WithTenant("globex"))
+	if _, err := globexUpdater.RunScript(context.Background(), []service.Op{{Kind: service.OpRead, BalanceID: a.ID}}); err == nil {
+		t.Error("expected a script reading another tenant's balance to fail")
+	}
+	if err := service.CreateShards(acme, a.ID, 2, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.GetTotal(globex, a.ID); !errors.Is(err, service.ErrNotSharded) {
+		t.Errorf("expected another tenant's shards to be invisible, got %v", err)
+	}
+	if _, err := globexUpdater.UpdateShardedBalance(a.ID, 5); !errors.Is(err, service.ErrNotSharded) {
+		t.Errorf("expected ErrNotSharded updating another tenant's shards, got %v", err)
+	}
+	if _, err := service.RebalanceShards(globex, a.ID); !errors.Is(err, service.ErrNotSharded) {
+		t.Errorf("expected ErrNotSharded rebalancing another tenant's shards, got %v", err)
+	}
+	dec := models.DecimalBalance{Amount: decimal.NewFromInt(1)}
+	if err := acme.Create(&dec).Error; err != nil || dec.TenantID != "acme" {
+		t.Fatalf("expected a decimal balance of acme, got %+v and %v", dec, err)
+	}
+	if _, err := globexUpdater.UpdateDecimalBalance(dec.ID, decimal.NewFromInt(1)); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound updating another tenant's decimal balance, got %v", err)
+	}
+	if outcome, err := updater.With(service.WithTenant("acme")).UpdateDecimalBalance(dec.ID, decimal.NewFromInt(1)); err != nil || !outcome.NewAmount.Equal(decimal.NewFromInt(2)) {
+		t.Errorf("expected acme to update its decimal balance, got %+v and %v", outcome, err)
+	}
+
+	// Adjustments follow the tenant of their balance
+	service.ApplyAdjustment(acme, "ref-a", a.ID, -5)
+	service.ApplyAdjustment(globex, "ref-g", g.ID, -5)
+	if applied, err := service.ApplyAdjustment(globex, "ref-x", a.ID, -5); applied || err == nil {
+		t.Errorf("expected an adjustment of another tenant's balance to fail, got %v and %v", applied, err)
+	}
+	report, err := velocity.Build(acme, time.Hour, velocity.Rules{})
+	if err != nil || len(report.Accounts) != 1 || report.Accounts[0].BalanceID != a.ID {
+		t.Errorf("expected only acme's balance in its report, got %+v and %v", report.Accounts, err)
+	}
+
+	server := httptest.NewServer((&httpapi.Server{DB: db, TenantHeader: "X-Tenant-ID"}).Handler())
+	defer server.Close()
+	for tenantID, want := range map[string]int{"": http.StatusBadRequest, "globex": http.StatusNotFound, "acme": http.StatusOK} {
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/balances/%d", server.URL, a.ID), nil)
+		if tenantID != "" {
+			req.Header.Set("X-Tenant-ID", tenantID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("tenant %q: expected %d, got %d", tenantID, want, resp.StatusCode)
+		}
+	}
+}
+
+func TestTenantsShareReferences(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	a, _ := service.CreateBalance(tenant.Scope(db, "acme"), "owner-1", 100)
+	g, _ := service.CreateBalance(tenant.Scope(db, "globex"), "owner-1", 200)
+	updater := service.NewUpdater(db)
+	acme, globex := updater.With(service.WithTenant("acme")), updater.With(service.WithTenant("globex"))
+
+	// Idempotency keys are unique per tenant, so one tenant's reference
+	// does not swallow another's payment
+	if applied, err := acme.ApplyAdjustment("pay-1", a.ID, 10); !applied || err != nil {
+		t.Errorf("expected acme's payment applied, got %v and %v", applied, err)
+	}
+	if applied, err := globex.ApplyAdjustment("pay-1", g.ID, 10); !applied || err != nil {
+		t.Errorf("expected globex's payment under the same reference applied, got %v and %v", applied, err)
+	}
+	if applied, _ := globex.ApplyAdjustment("pay-1", g.ID, 10); applied {
+		t.Error("expected the repeated reference skipped within the tenant")
+	}
+	dedup := service.NewDeduplicator(db, time.Hour, nil)
+	if applied, err := dedup.ApplyWith(acme, "hook-1", a.ID, 1); !applied || err != nil {
+		t.Errorf("expected acme's webhook applied, got %v and %v", applied, err)
+	}
+	if applied, err := dedup.ApplyWith(globex, "hook-1", g.ID, 1); !applied || err != nil {
+		t.Errorf("expected globex's webhook under the same reference applied, got %v and %v", applied, err)
+	}
+
+	// A dead letter is replayed in its tenant, even if another tenant used the key
+	sink := service.TableDeadLetterSink{DB: db}
+	if err := sink.AddDeadLetter(service.DeadLetter{BalanceID: g.ID, Delta: 5, IdempotencyKey: "pay-1", TenantID: "globex", At: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.AddDeadLetter(service.DeadLetter{BalanceID: g.ID, Delta: 5, IdempotencyKey: "retry-1", TenantID: "globex", At: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.AddDeadLetter(service.DeadLetter{BalanceID: a.ID, Delta: 5, IdempotencyKey: "retry-1", TenantID: "acme", At: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if replayed, err := service.NewReprocessor(db, updater, 10).ReprocessOnce(context.Background()); replayed != 3 || err != nil {
+		t.Errorf("expected 3 replays, got %d and %v", replayed, err)
+	}
+	var adjustment models.Adjustment
+	if err := db.Where("reference = ? AND balance_id = ?", "retry-1", g.ID).First(&adjustment).Error; err != nil || adjustment.TenantID != "globex" {
+		t.Errorf("expected the replay recorded in its tenant, got %+v and %v", adjustment, err)
+	}
+
+	if current, _ := service.GetBalance(db, a.ID); current.Amount != 116 {
+		t.Errorf("expected acme's balance at 116, got %d", current.Amount)
+	}
+	if current, _ := service.GetBalance(db, g.ID); current.Amount != 216 {
+		t.Errorf("expected globex's balance at 216, got %d", current.Amount)
+	}
+}
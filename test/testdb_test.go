@@ -0,0 +1,149 @@
+package service_test
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+
+	"github.com/ghozilaaa/optimistic-lock/database"
+)
+
+// templateDB is migrated once per test run and cloned for every test
+const templateDB = "optimistic_lock_template"
+
+var (
+	templateOnce sync.Once
+	templateErr  error
+)
+
+// cloneDB creates a private database for the test from the migrated template
+// and drops it when the test ends. Use it for tests that update concurrently,
+// where a single rolled-back transaction would serialize every writer.
+func cloneDB(t testing.TB, gormCfg *gorm.Config) *gorm.DB {
+	t.Helper()
+
+	admin, err := database.Open(postgresConfig(t, "postgres"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to connect to postgres: %v", err)
+	}
+	t.Cleanup(func() { closeDB(admin) })
+
+	templateOnce.Do(func() { templateErr = createTemplate(admin, postgresConfig(t, templateDB)) })
+	if templateErr != nil {
+		t.Fatalf("Failed to create template database: %v", templateErr)
+	}
+
+	name := uniqueName("optimistic_lock_test")
+	if err := admin.Exec(fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s", name, templateDB)).Error; err != nil {
+		t.Fatalf("Failed to clone template database: %v", err)
+	}
+
+	if gormCfg == nil {
+		gormCfg = &gorm.Config{}
+	}
+	if gormCfg.Logger == nil {
+		gormCfg.Logger = logger.Default.LogMode(logger.Silent)
+	}
+	db, err := database.Open(postgresConfig(t, name), gormCfg)
+	if err != nil {
+		t.Fatalf("Failed to connect to %s: %v", name, err)
+	}
+
+	// Cleanups run last-in first-out: close the pool, then drop the clone
+	t.Cleanup(func() {
+		admin.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", name))
+	})
+	t.Cleanup(func() { closeDB(db) })
+	return db
+}
+
+// schemaDB creates a uniquely named schema on the server described by cfg,
+// migrates the models into it and drops it when the test ends. On Postgres the
+// tables are addressed as schema.table; on MySQL, where a schema is a
+// database, the connection simply uses it as its default database.
+func schemaDB(t testing.TB, cfg database.Config) *gorm.DB {
+	t.Helper()
+	silent := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+
+	admin, err := database.Open(cfg, silent)
+	if err != nil {
+		t.Fatalf("Failed to connect to %s: %v", cfg.Driver, err)
+	}
+	t.Cleanup(func() { closeDB(admin) })
+
+	name := uniqueName("test")
+	if err := admin.Exec(fmt.Sprintf("CREATE SCHEMA %s", name)).Error; err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	drop := fmt.Sprintf("DROP SCHEMA IF EXISTS %s", name)
+	if cfg.Driver == "postgres" {
+		drop += " CASCADE"
+	}
+	t.Cleanup(func() { admin.Exec(drop) })
+
+	gormCfg := &gorm.Config{Logger: silent.Logger}
+	if cfg.Driver == "postgres" {
+		gormCfg.NamingStrategy = schema.NamingStrategy{TablePrefix: name + "."}
+	} else {
+		cfg.Name = name
+	}
+	db, err := database.Open(cfg, gormCfg)
+	if err != nil {
+		t.Fatalf("Failed to connect to schema %s: %v", name, err)
+	}
+	t.Cleanup(func() { closeDB(db) })
+
+	if err := db.AutoMigrate(testModels...); err != nil {
+		t.Fatalf("Failed to migrate schema %s: %v", name, err)
+	}
+	return db
+}
+
+// rollbackDB returns a transaction on db that is rolled back when the test
+// ends. It suits tests that use one goroutine; concurrent writers should use
+// cloneDB instead.
+func rollbackDB(t testing.TB, db *gorm.DB) *gorm.DB {
+	t.Helper()
+	tx := db.Begin()
+	if tx.Error != nil {
+		t.Fatalf("Failed to begin transaction: %v", tx.Error)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+	return tx
+}
+
+// createTemplate (re)creates the template database described by cfg with the
+// current schema
+func createTemplate(admin *gorm.DB, cfg database.Config) error {
+	if err := admin.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", templateDB)).Error; err != nil {
+		return err
+	}
+	if err := admin.Exec(fmt.Sprintf("CREATE DATABASE %s", templateDB)).Error; err != nil {
+		return err
+	}
+
+	db, err := database.Open(cfg, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return err
+	}
+	// A template cannot be cloned while anyone is connected to it
+	defer closeDB(db)
+	return db.AutoMigrate(testModels...)
+}
+
+// uniqueName returns an identifier no other test run is using
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("%s_%d_%d", prefix, time.Now().UnixNano(), rand.Intn(1000000))
+}
+
+func closeDB(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
@@ -0,0 +1,578 @@
+package service_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/latency"
+	"github.com/ghozilaaa/optimistic-lock/logging"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+)
+
+func TestConcurrentUpdatesAddUp(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := service.UpdateBalance(db, balance.ID, 10); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	conflictCount := 0
+	for err := range errs {
+		if errors.Is(err, service.ErrConflict) {
+			conflictCount++
+		} else {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	var updated models.Balance
+	db.First(&updated, balance.ID)
+	t.Logf("Final balance: %d, conflicts: %d", updated.Amount, conflictCount)
+
+	expected := int64(1000 + ((50 - conflictCount) * 10))
+	if updated.Amount != expected {
+		t.Errorf("Balance integrity failed: expected %d, got %d", expected, updated.Amount)
+	}
+}
+
+func TestPessimisticFallbackNeverExhausts(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	updater := service.NewUpdater(db, service.WithMaxAttempts(2), service.WithPessimisticFallback(1))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := updater.UpdateBalance(balance.ID, 10); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("expected every update to succeed, got %v", err)
+	}
+
+	var updated models.Balance
+	db.First(&updated, balance.ID)
+	if updated.Amount != 1500 {
+		t.Errorf("Balance integrity failed: expected 1500, got %d", updated.Amount)
+	}
+}
+
+func TestSerializerAvoidsConflicts(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	updater := service.NewUpdater(db, service.WithMaxAttempts(1), service.WithSerializer(service.NewKeyedSerializer(16)))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := updater.UpdateBalance(balance.ID, 10); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	// With a single attempt any conflict would surface as an error
+	for err := range errs {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	var updated models.Balance
+	db.First(&updated, balance.ID)
+	if updated.Amount != 1500 {
+		t.Errorf("Balance integrity failed: expected 1500, got %d", updated.Amount)
+	}
+}
+
+func TestVerifyFence(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	token, err := service.ReadFence(db, balance.ID)
+	if err != nil {
+		t.Fatalf("ReadFence failed: %v", err)
+	}
+	if err := service.VerifyFence(db, balance.ID, token); err != nil {
+		t.Errorf("expected fresh token to verify, got %v", err)
+	}
+
+	service.UpdateBalance(db, balance.ID, 10)
+
+	if err := service.VerifyFence(db, balance.ID, token); !errors.Is(err, service.ErrStaleFence) {
+		t.Errorf("expected ErrStaleFence after update, got %v", err)
+	}
+}
+
+func TestRateLimitPerKey(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	hot := models.Balance{Amount: 1000}
+	cold := models.Balance{Amount: 1000}
+	db.Create(&hot)
+	db.Create(&cold)
+
+	updater := service.NewUpdater(db, service.WithRateLimit(service.RateLimitOptions{
+		PerKeyRate:  1,
+		PerKeyBurst: 5,
+	}))
+
+	limited := 0
+	for i := 0; i < 10; i++ {
+		if _, err := updater.UpdateBalance(hot.ID, 1); errors.Is(err, service.ErrRateLimited) {
+			limited++
+		}
+	}
+	if limited != 5 {
+		t.Errorf("expected 5 of 10 hot updates to be limited, got %d", limited)
+	}
+
+	// Other balances have their own bucket
+	if _, err := updater.UpdateBalance(cold.ID, 1); err != nil {
+		t.Errorf("expected cold balance update to pass, got %v", err)
+	}
+}
+
+func TestUpdateOutcome(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	outcome, err := service.UpdateBalance(db, balance.ID, 25)
+	if err != nil {
+		t.Fatalf("UpdateBalance failed: %v", err)
+	}
+	want := service.UpdateOutcome{Attempts: 1, PreviousAmount: 1000, NewAmount: 1025, Version: balance.Version + 1}
+	if !reflect.DeepEqual(outcome, want) {
+		t.Errorf("expected outcome %+v, got %+v", want, outcome)
+	}
+	if outcome.Retried() {
+		t.Error("expected a first-attempt update not to report a retry")
+	}
+}
+
+func TestHooks(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	var calls []string
+	updater := service.NewUpdater(db, service.WithHooks(service.Hooks{
+		BeforeAttempt: func(a service.Attempt) error {
+			calls = append(calls, "before")
+			return nil
+		},
+		AfterAttempt: func(a service.Attempt, err error) {
+			if err != nil {
+				t.Errorf("expected attempt %d to succeed, got %v", a.Number, err)
+			}
+			calls = append(calls, "after")
+		},
+	}))
+
+	if _, err := updater.UpdateBalance(balance.ID, 10); err != nil {
+		t.Fatalf("UpdateBalance failed: %v", err)
+	}
+	if got := strings.Join(calls, ","); got != "before,after" {
+		t.Errorf("expected hooks before,after, got %s", got)
+	}
+
+	// An error from BeforeAttempt aborts the update without writing
+	errAbort := errors.New("aborted by hook")
+	updater = service.NewUpdater(db, service.WithHooks(service.Hooks{
+		BeforeAttempt: func(a service.Attempt) error { return errAbort },
+	}))
+	if _, err := updater.UpdateBalance(balance.ID, 10); !errors.Is(err, errAbort) {
+		t.Errorf("expected hook error, got %v", err)
+	}
+
+	var final models.Balance
+	db.First(&final, balance.ID)
+	if final.Amount != 1010 {
+		t.Errorf("expected amount 1010, got %d", final.Amount)
+	}
+}
+
+func TestPerCallRetryOverrides(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	// Bump the version after every read so every attempt conflicts
+	db.Callback().Query().After("gorm:query").Register("test:bump_version", func(tx *gorm.DB) {
+		if tx.Statement.Table == "balances" {
+			tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE balances SET version = version + 1 WHERE id = ?", balance.ID)
+		}
+	})
+
+	outcome, err := service.UpdateBalance(db, balance.ID, 10, service.WithMaxAttempts(1), service.WithNoBackoff())
+	if !errors.Is(err, service.ErrRetryExhausted) {
+		t.Fatalf("expected ErrRetryExhausted, got %v", err)
+	}
+	if outcome.Attempts != 1 || outcome.Backoff != 0 {
+		t.Errorf("expected one attempt without backoff, got %+v", outcome)
+	}
+
+	// With overrides a copy; the original keeps its policy
+	updater := service.NewUpdater(db, service.WithMaxAttempts(3), service.WithBaseBackoff(time.Millisecond))
+	failFast := updater.With(service.WithMaxAttempts(1), service.WithNoBackoff())
+	if outcome, _ := failFast.UpdateBalance(balance.ID, 10); outcome.Attempts != 1 {
+		t.Errorf("expected the override to make one attempt, got %d", outcome.Attempts)
+	}
+	if outcome, _ := updater.UpdateBalance(balance.ID, 10); outcome.Attempts != 3 || outcome.Backoff == 0 {
+		t.Errorf("expected the original policy of three attempts with backoff, got %+v", outcome)
+	}
+
+	var final models.Balance
+	db.First(&final, balance.ID)
+	if final.Amount != 1000 {
+		t.Errorf("expected no update to land, got amount %d", final.Amount)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	// Bump the version after every read so every attempt conflicts
+	db.Callback().Query().After("gorm:query").Register("test:bump_version", func(tx *gorm.DB) {
+		if tx.Statement.Table == "balances" {
+			tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE balances SET version = version + 1 WHERE id = ?", balance.ID)
+		}
+	})
+
+	var exhausted int
+	updater := service.NewUpdater(db,
+		service.WithMaxAttempts(10),
+		service.WithBaseBackoff(20*time.Millisecond),
+		service.WithTimeout(50*time.Millisecond),
+		service.WithHooks(service.Hooks{OnExhausted: func(uint, service.UpdateOutcome) { exhausted++ }}))
+
+	outcome, err := updater.UpdateBalance(balance.ID, 10)
+	if !errors.Is(err, service.ErrDeadlineExceeded) || !errors.Is(err, service.ErrRetryExhausted) {
+		t.Fatalf("expected ErrDeadlineExceeded wrapping ErrRetryExhausted, got %v", err)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("after %d attempts", outcome.Attempts)) {
+		t.Errorf("expected the error to report %d attempts, got %v", outcome.Attempts, err)
+	}
+	if outcome.Attempts < 2 || outcome.Attempts >= 10 {
+		t.Errorf("expected the budget to stop the retries early, got %d attempts", outcome.Attempts)
+	}
+	if outcome.Backoff > 50*time.Millisecond {
+		t.Errorf("expected the backoff to stay within the budget, slept %s", outcome.Backoff)
+	}
+	if exhausted != 1 {
+		t.Errorf("expected OnExhausted once, got %d", exhausted)
+	}
+}
+
+func TestUpdateIfVersion(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	created := models.Balance{Amount: 1000}
+	db.Create(&created)
+
+	read, err := service.GetBalance(db, created.ID)
+	if err != nil {
+		t.Fatalf("GetBalance failed: %v", err)
+	}
+
+	outcome, err := service.UpdateIfVersion(db, read.ID, read.Version, 50)
+	if err != nil {
+		t.Fatalf("UpdateIfVersion failed: %v", err)
+	}
+	if outcome.PreviousAmount != 1000 || outcome.NewAmount != 1050 || outcome.Version != read.Version+1 {
+		t.Errorf("unexpected outcome: %+v", outcome)
+	}
+
+	// A second write based on the same read is rejected, not retried
+	if _, err := service.UpdateIfVersion(db, read.ID, read.Version, 50); !errors.Is(err, service.ErrConflict) {
+		t.Errorf("expected ErrConflict for a stale version, got %v", err)
+	}
+
+	final, _ := service.GetBalance(db, created.ID)
+	if final.Amount != 1050 {
+		t.Errorf("expected amount 1050, got %d", final.Amount)
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	faults := service.NewFaultInjector(service.FaultConfig{ConflictRate: 1, Seed: 1})
+	updater := service.NewUpdater(db, service.WithFaultInjector(faults), service.WithMaxAttempts(3), service.WithNoBackoff())
+
+	outcome, err := updater.UpdateBalance(balance.ID, 10)
+	if !errors.Is(err, service.ErrRetryExhausted) || outcome.Conflicts != 3 {
+		t.Fatalf("expected 3 forced conflicts, got %d and %v", outcome.Conflicts, err)
+	}
+
+	faults.Set(service.FaultConfig{ErrorRate: 1})
+	if _, err := updater.UpdateBalance(balance.ID, 10); !errors.Is(err, service.ErrInjectedFault) {
+		t.Fatalf("expected the injected error after the retries, got %v", err)
+	}
+
+	// Injected faults replace the attempt, so nothing was written
+	if current, _ := service.GetBalance(db, balance.ID); current.Amount != 1000 || current.Version != balance.Version {
+		t.Fatalf("expected the balance untouched, got %+v", current)
+	}
+
+	// Half the attempts fail; with enough attempts every update gets through
+	faults.Set(service.FaultConfig{ErrorRate: 0.25, ConflictRate: 0.25, LatencyRate: 1, Latency: latency.Fixed(time.Millisecond)})
+	resilient := updater.With(service.WithMaxAttempts(20))
+	var conflicts int
+	for i := 0; i < 20; i++ {
+		outcome, err := resilient.UpdateBalance(balance.ID, 1)
+		if err != nil {
+			t.Fatalf("update %d failed: %v", i, err)
+		}
+		conflicts += outcome.Conflicts
+	}
+	if current, _ := service.GetBalance(db, balance.ID); current.Amount != 1020 {
+		t.Errorf("expected 20 applied updates, got amount %d", current.Amount)
+	}
+
+	stats := faults.Stats()
+	if stats.Conflicts != int64(3+conflicts) || stats.Errors < 3 || stats.Delays != stats.Attempts-6 {
+		t.Errorf("unexpected stats %+v after %d conflicts in the resilient run", stats, conflicts)
+	}
+}
+
+func TestConflictSimulation(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	for i := 0; i < 2; i++ {
+		db.Create(&models.Balance{Amount: 100})
+	}
+
+	sim := httpapi.NewConflictSimulator(httpapi.ConflictRules{BalanceIDs: []uint{2}})
+	server := httptest.NewServer((&httpapi.Server{DB: db, Conflicts: sim}).Handler())
+	defer server.Close()
+
+	patch := func(id uint) int {
+		balance, _ := service.GetBalance(db, id)
+		req, _ := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/balances/%d", server.URL, id), strings.NewReader(`{"delta": 1}`))
+		req.Header.Set("If-Match", fmt.Sprintf(`"v=%d"`, balance.Version))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PATCH failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusConflict {
+			var body map[string]string
+			json.NewDecoder(resp.Body).Decode(&body)
+			if body["code"] != "ABORTED" || resp.Header.Get("X-Simulated-Conflict") != "true" {
+				t.Errorf("unexpected simulated conflict: %v", body)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	if got := patch(1); got != http.StatusOK {
+		t.Errorf("expected balance 1 to update, got %d", got)
+	}
+	if got := patch(2); got != http.StatusConflict {
+		t.Errorf("expected a simulated conflict for balance 2, got %d", got)
+	}
+
+	// Switch to every third write through the testing endpoint
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/testing/conflicts", strings.NewReader(`{"every_nth": 3}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT /testing/conflicts failed: %v %v", err, resp)
+	}
+	resp.Body.Close()
+
+	var statuses []int
+	for i := 0; i < 6; i++ {
+		statuses = append(statuses, patch(2))
+	}
+	want := []int{200, 200, 409, 200, 200, 409}
+	if fmt.Sprint(statuses) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, statuses)
+	}
+
+	// Rejected writes never reached the database
+	if final, _ := service.GetBalance(db, 2); final.Amount != 104 {
+		t.Errorf("expected 4 applied writes, got amount %d", final.Amount)
+	}
+}
+
+func TestUpdaterLogging(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+
+	balance := models.Balance{Amount: 1000}
+	db.Create(&balance)
+
+	// Bump the version after every read so no attempt can succeed
+	db.Callback().Query().After("gorm:query").Register("test:bump_version", func(tx *gorm.DB) {
+		if tx.Statement.Table == "balances" {
+			tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE balances SET version = version + 1 WHERE id = ?", balance.ID)
+		}
+	})
+
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "json", "debug")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	updater := service.NewUpdater(db, service.WithMaxAttempts(2), service.WithBaseBackoff(time.Millisecond), service.WithLogger(logger))
+	if _, err := updater.UpdateBalance(balance.ID, 25); !errors.Is(err, service.ErrRetryExhausted) {
+		t.Fatalf("expected ErrRetryExhausted, got %v", err)
+	}
+
+	type line struct {
+		Level     string `json:"level"`
+		Msg       string `json:"msg"`
+		BalanceID uint   `json:"balance_id"`
+		Attempt   int    `json:"attempt"`
+		Attempts  int    `json:"attempts"`
+	}
+	var lines []line
+	for _, raw := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var l line
+		if err := json.Unmarshal(raw, &l); err != nil {
+			t.Fatalf("invalid log line %s: %v", raw, err)
+		}
+		lines = append(lines, l)
+	}
+
+	want := []line{
+		{Level: "DEBUG", Msg: "version conflict", BalanceID: balance.ID, Attempt: 1},
+		{Level: "DEBUG", Msg: "version conflict", BalanceID: balance.ID, Attempt: 2},
+		{Level: "WARN", Msg: "retries exhausted", BalanceID: balance.ID, Attempts: 2},
+	}
+	if fmt.Sprint(lines) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, lines)
+	}
+}
+
+func TestModifyMerge(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	alice, err := service.CreateBalance(db, "alice", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// double calls fn, and lets another writer add 10 on its first call
+	calls := 0
+	double := func(b models.Balance) (int64, error) {
+		calls++
+		if calls == 1 {
+			if _, err := service.UpdateBalance(db, alice.ID, 10); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return b.Amount * 2, nil
+	}
+
+	// Without a merge the conflict reads again and calls fn again
+	outcome, err := service.Modify(db, alice.ID, double, service.WithNoBackoff())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || outcome.Conflicts != 1 || outcome.Merged != 0 || outcome.NewAmount != 220 {
+		t.Errorf("expected fn called again and 220 written, got %d calls and %+v", calls, outcome)
+	}
+
+	// ReapplyDelta writes the change on top of the row the conflict found
+	calls = 0
+	outcome, err = service.Modify(db, alice.ID, double, service.WithNoBackoff(), service.WithMerge(service.ReapplyDelta))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || outcome.Merged != 1 || outcome.PreviousAmount != 230 || outcome.NewAmount != 450 {
+		t.Errorf("expected the doubling of 220 reapplied to 230, got %d calls and %+v", calls, outcome)
+	}
+
+	calls = 0
+	outcome, err = service.Modify(db, alice.ID, double, service.WithNoBackoff(), service.WithMerge(service.LastWriterWins))
+	if err != nil || calls != 1 || outcome.NewAmount != 900 {
+		t.Errorf("expected the amount computed first written, got %d calls, %+v and %v", calls, outcome, err)
+	}
+
+	// A merge that gives up with ErrConflict reads again
+	calls = 0
+	refuse := func(read, fresh models.Balance, amount int64) (int64, error) { return 0, service.ErrConflict }
+	outcome, err = service.Modify(db, alice.ID, double, service.WithNoBackoff(), service.WithMerge(refuse))
+	if err != nil || calls != 2 || outcome.Merged != 0 || outcome.NewAmount != 1820 {
+		t.Errorf("expected fn called again, got %d calls, %+v and %v", calls, outcome, err)
+	}
+	if current, _ := service.GetBalance(db, alice.ID); current.Amount != 1820 {
+		t.Errorf("expected 1820 stored, got %d", current.Amount)
+	}
+
+	errNegative := errors.New("negative")
+	if _, err := service.Modify(db, alice.ID, func(models.Balance) (int64, error) { return 0, errNegative }); err != errNegative {
+		t.Errorf("expected the error of fn, got %v", err)
+	}
+	if _, err := service.Modify(db, 9999, double); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ghozilaaa/optimistic-lock/httpapi"
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/service"
+	"github.com/ghozilaaa/optimistic-lock/webhook"
+)
+
+func TestWebhookVerifyRejectsTamperingAndReplays(t *testing.T) {
+	secret := []byte("s3cret")
+	verifier := webhook.NewVerifier(secret, time.Minute)
+
+	body := []byte(`{"reference":"pay-1","balance_id":1,"delta":100}`)
+	req, err := webhook.Signer{Secret: secret}.NewRequest("http://example.invalid/webhooks/payments", body)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+
+	if err := verifier.Verify(req.Header, body); err != nil {
+		t.Fatalf("expected valid delivery, got %v", err)
+	}
+	if err := verifier.Verify(req.Header, body); !errors.Is(err, webhook.ErrReplayed) {
+		t.Errorf("expected ErrReplayed for the same delivery, got %v", err)
+	}
+	if err := verifier.Verify(req.Header, []byte(`{"delta":1000000}`)); !errors.Is(err, webhook.ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for a changed body, got %v", err)
+	}
+	if err := webhook.NewVerifier([]byte("other"), time.Minute).Verify(req.Header, body); !errors.Is(err, webhook.ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for another secret, got %v", err)
+	}
+
+	// A correctly signed delivery from an hour ago is stale
+	ts := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + ".old-nonce."))
+	mac.Write(body)
+	old := http.Header{}
+	old.Set(webhook.TimestampHeader, ts)
+	old.Set(webhook.NonceHeader, "old-nonce")
+	old.Set(webhook.SignatureHeader, "v1="+hex.EncodeToString(mac.Sum(nil)))
+	if err := verifier.Verify(old, body); !errors.Is(err, webhook.ErrStale) {
+		t.Errorf("expected ErrStale, got %v", err)
+	}
+}
+
+func TestWebhookMiddleware(t *testing.T) {
+	secret := []byte("s3cret")
+	handler := webhook.NewVerifier(secret, time.Minute).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	body := []byte(`{}`)
+	signed, _ := webhook.Signer{Secret: secret}.NewRequest("/hook", body)
+
+	var codes []int
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body))
+		req.Header = signed.Header.Clone()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	if codes[0] != http.StatusNoContent || codes[1] != http.StatusConflict {
+		t.Errorf("expected 204 then 409, got %v", codes)
+	}
+}
+
+func TestPaymentWebhookUsesServerUpdater(t *testing.T) {
+	t.Parallel()
+	db := openDB(t)
+	balance := models.Balance{Amount: 100}
+	db.Create(&balance)
+
+	secret := []byte("s3cret")
+	server := httptest.NewServer((&httpapi.Server{
+		DB: db,
+		Updater: service.NewUpdater(db, service.WithAuthorizer(func(_ context.Context, _ models.Balance, op service.WriteOp) error {
+			if op.Delta < 0 {
+				return errors.New("payments only credit")
+			}
+			return nil
+		})),
+		PaymentWebhooks: webhook.NewVerifier(secret, time.Minute),
+	}).Handler())
+	defer server.Close()
+
+	deliver := func(reference string, delta int64) int {
+		body := []byte(fmt.Sprintf(`{"reference":%q,"balance_id":%d,"delta":%d}`, reference, balance.ID, delta))
+		req, _ := webhook.Signer{Secret: secret}.NewRequest(server.URL+"/webhooks/payments", body)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := deliver("pay-1", 50); code != http.StatusOK {
+		t.Errorf("expected the credit applied, got %d", code)
+	}
+	if code := deliver("pay-2", -50); code != http.StatusForbidden {
+		t.Errorf("expected the server's authorizer to refuse the debit, got %d", code)
+	}
+	if current, _ := service.GetBalance(db, balance.ID); current.Amount != 150 {
+		t.Errorf("expected only the credit applied, got %d", current.Amount)
+	}
+}
@@ -0,0 +1,100 @@
+// Package velocity aggregates debit and credit velocity per balance from the
+// adjustments table and flags balances that exceed rule thresholds, for
+// compliance review.
+package velocity
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ghozilaaa/optimistic-lock/models"
+	"github.com/ghozilaaa/optimistic-lock/tenant"
+)
+
+// Rules are the thresholds within one window. Zero disables a rule.
+type Rules struct {
+	MaxDebits      int   `json:"max_debits,omitempty"`       // Number of debits
+	MaxCredits     int   `json:"max_credits,omitempty"`      // Number of credits
+	MaxDebitTotal  int64 `json:"max_debit_total,omitempty"`  // Sum of debited amounts
+	MaxCreditTotal int64 `json:"max_credit_total,omitempty"` // Sum of credited amounts
+}
+
+// Account is the activity of one balance within the window
+type Account struct {
+	BalanceID   uint     `json:"balance_id"`
+	Debits      int      `json:"debits"`
+	Credits     int      `json:"credits"`
+	DebitTotal  int64    `json:"debit_total"`
+	CreditTotal int64    `json:"credit_total"`
+	Flags       []string `json:"flags,omitempty"` // Rules the balance broke
+}
+
+// Report covers adjustments created in [Since, Until)
+type Report struct {
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+	Rules    Rules     `json:"rules"`
+	Accounts []Account `json:"accounts"`
+}
+
+// Flagged returns the accounts that broke at least one rule
+func (r Report) Flagged() []Account {
+	var out []Account
+	for _, a := range r.Accounts {
+		if len(a.Flags) > 0 {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// Build aggregates the adjustments of the last window, ending now. When db
+// carries a tenant only the balances of that tenant are included.
+func Build(db *gorm.DB, window time.Duration, rules Rules) (Report, error) {
+	until := time.Now()
+	report := Report{Since: until.Add(-window), Until: until, Rules: rules}
+
+	query := db.Model(&models.Adjustment{})
+	if _, ok := tenant.FromContext(db.Statement.Context); ok {
+		// Adjustments have no tenant of their own; the subquery on balances is scoped
+		query = query.Where("balance_id IN (?)", db.Unscoped().Model(&models.Balance{}).Select("id"))
+	}
+	err := query.
+		Select(`balance_id,
+			SUM(CASE WHEN delta < 0 THEN 1 ELSE 0 END) AS debits,
+			SUM(CASE WHEN delta > 0 THEN 1 ELSE 0 END) AS credits,
+			SUM(CASE WHEN delta < 0 THEN -delta ELSE 0 END) AS debit_total,
+			SUM(CASE WHEN delta > 0 THEN delta ELSE 0 END) AS credit_total`).
+		Where("created_at >= ? AND created_at < ?", report.Since, report.Until).
+		Group("balance_id").
+		Order("balance_id").
+		Scan(&report.Accounts).Error
+	if err != nil {
+		return Report{}, err
+	}
+
+	for i := range report.Accounts {
+		report.Accounts[i].Flags = rules.check(report.Accounts[i])
+	}
+	return report, nil
+}
+
+// check returns a description of every rule the account broke
+func (r Rules) check(a Account) []string {
+	var flags []string
+	if r.MaxDebits > 0 && a.Debits > r.MaxDebits {
+		flags = append(flags, fmt.Sprintf("debits %d > %d", a.Debits, r.MaxDebits))
+	}
+	if r.MaxCredits > 0 && a.Credits > r.MaxCredits {
+		flags = append(flags, fmt.Sprintf("credits %d > %d", a.Credits, r.MaxCredits))
+	}
+	if r.MaxDebitTotal > 0 && a.DebitTotal > r.MaxDebitTotal {
+		flags = append(flags, fmt.Sprintf("debit total %d > %d", a.DebitTotal, r.MaxDebitTotal))
+	}
+	if r.MaxCreditTotal > 0 && a.CreditTotal > r.MaxCreditTotal {
+		flags = append(flags, fmt.Sprintf("credit total %d > %d", a.CreditTotal, r.MaxCreditTotal))
+	}
+	return flags
+}